@@ -0,0 +1,180 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ProtonMail/proton-bridge/internal/api"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+// accountsCommand lists and changes accounts, e.g. `bridge accounts --json`
+// in scripts. Changes go through the control API of running Bridge.
+func accountsCommand() cli.Command {
+	accountAction := func(name, usage string) cli.Command {
+		return cli.Command{
+			Name:      name,
+			Usage:     usage + " (Bridge must be running)",
+			ArgsUsage: "<account>",
+			Action: func(context *cli.Context) error {
+				return runAccountAction(context, name)
+			},
+		}
+	}
+
+	return cli.Command{
+		Name:   "accounts",
+		Usage:  "List accounts, from the running Bridge or from the keychain when it is not running",
+		Action: runAccounts,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print accounts as JSON",
+			},
+		},
+		Subcommands: []cli.Command{
+			accountAction("pause", "Stop polling of changes of the account"),
+			accountAction("resume", "Start polling of changes of the account again"),
+			accountAction("logout", "Log the account out"),
+		},
+	}
+}
+
+func runAccounts(context *cli.Context) error {
+	accounts, err := getRunningAccounts()
+	if err != nil {
+		log.WithError(err).Debug("Cannot get accounts from running Bridge")
+		if accounts, err = getStoredAccounts(); err != nil {
+			return err
+		}
+	}
+
+	if context.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(accounts)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tSTATE\tADDRESSES")
+	for _, account := range accounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", account.Username, getAccountState(account), strings.Join(account.Addresses, ", "))
+	}
+	return w.Flush()
+}
+
+func runAccountAction(context *cli.Context, action string) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: username, address or ID of the account", 1)
+	}
+
+	port, tls, token, err := getControlAccess()
+	if err != nil {
+		return cli.NewExitError("Bridge is not running: "+err.Error(), 3)
+	}
+
+	account, err := api.ChangeAccount(port, tls, token, context.Args().First(), action)
+	if err != nil {
+		return cli.NewExitError("Cannot "+action+" account: "+err.Error(), 1)
+	}
+
+	fmt.Printf("Account %s is %s.\n", account.Username, getAccountState(*account))
+	return nil
+}
+
+func getAccountState(account api.AccountStatus) string {
+	switch {
+	case !account.Connected:
+		return "disconnected"
+	case account.Paused:
+		return "paused"
+	default:
+		return "connected"
+	}
+}
+
+// getControlAccess returns what is needed to call the control API of the
+// running Bridge. Running Bridge has the certificate already, it is not
+// generated here.
+func getControlAccess() (port int, tlsConfig *tls.Config, token string, err error) {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	pref := preferences.New(cfg)
+
+	if _, err := os.Stat(cfg.GetTLSCertPath()); err != nil {
+		return 0, nil, "", err
+	}
+	if tlsConfig, err = config.GetTLSConfig(cfg); err != nil {
+		return 0, nil, "", err
+	}
+	if token, err = api.LoadToken(cfg.GetAPITokenPath()); err != nil {
+		return 0, nil, "", err
+	}
+	return pref.GetInt(preferences.APIPortKey), tlsConfig, token, nil
+}
+
+func getRunningAccounts() ([]api.AccountStatus, error) {
+	port, tls, token, err := getControlAccess()
+	if err != nil {
+		return nil, err
+	}
+	return api.GetAccounts(port, tls, token)
+}
+
+// getStoredAccounts lists accounts in the keychain when Bridge is not
+// running. Only what is stored with credentials is known, e.g. there is no
+// sync progress.
+func getStoredAccounts() ([]api.AccountStatus, error) {
+	credStore, unlock, err := lockCredentialsStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	userIDs, err := credStore.List()
+	if err != nil {
+		return nil, cli.NewExitError("Cannot list accounts: "+err.Error(), 1)
+	}
+
+	accounts := []api.AccountStatus{}
+	for _, userID := range userIDs {
+		creds, err := credStore.Get(userID)
+		if err != nil {
+			return nil, cli.NewExitError("Cannot get account "+userID+": "+err.Error(), 1)
+		}
+		addresses := creds.EmailList()
+		if addresses == nil {
+			addresses = []string{}
+		}
+		accounts = append(accounts, api.AccountStatus{
+			ID:        userID,
+			Username:  creds.Name,
+			Addresses: addresses,
+			Connected: creds.IsConnected(),
+		})
+	}
+	return accounts, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/backup"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/allan-simon/go-singleinstance"
+	"github.com/urfave/cli"
+)
+
+// backupCommand writes local stores, caches and preferences to an archive,
+// e.g. `bridge backup bridge.tar.gz`.
+func backupCommand() cli.Command {
+	return cli.Command{
+		Name:      "backup",
+		Usage:     "Write local mail stores and preferences to an archive (Bridge must not be running)",
+		ArgsUsage: "<file>",
+		Action:    runBackup,
+	}
+}
+
+// restoreCommand restores the archive written by backupCommand, e.g. on new
+// machine before logging in the accounts again.
+func restoreCommand() cli.Command {
+	return cli.Command{
+		Name:      "restore",
+		Usage:     "Restore local mail stores and preferences from an archive (Bridge must not be running)",
+		ArgsUsage: "<file>",
+		Action:    runRestore,
+	}
+}
+
+func runBackup(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: path of the archive", 1)
+	}
+	archivePath := context.Args().First()
+
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(archivePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) //nolint[gosec]
+	if err != nil {
+		return cli.NewExitError("Cannot create archive: "+err.Error(), 1)
+	}
+
+	userIDs, err := backup.Create(cfg, constants.Version, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(archivePath)
+		return cli.NewExitError("Cannot write backup: "+err.Error(), 1)
+	}
+
+	fmt.Printf("Backup of %d account(s) written to %s.\n", len(userIDs), archivePath)
+	fmt.Println("The archive contains mail metadata and cached messages, keep it safe.")
+	fmt.Println("Credentials are not included, use `credentials export` to move them as well.")
+	return nil
+}
+
+func runRestore(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: path of the archive", 1)
+	}
+
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.Open(context.Args().First())
+	if err != nil {
+		return cli.NewExitError("Cannot open archive: "+err.Error(), 1)
+	}
+	defer f.Close() //nolint[errcheck]
+
+	userIDs, err := backup.Restore(cfg, f)
+	if err != nil {
+		return cli.NewExitError("Cannot restore backup: "+err.Error(), 1)
+	}
+
+	fmt.Printf("Restored stores of %d account(s): %s\n", len(userIDs), strings.Join(userIDs, ", "))
+	fmt.Println("Start Bridge and log in the accounts again to use them.")
+	return nil
+}
+
+// lockBridgeData takes the lock of running Bridge, so stores are not changed
+// during backup or restore.
+func lockBridgeData() (cfg *config.Config, unlock func(), err error) {
+	cfg = config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	if err := cfg.CreateDirs(); err != nil {
+		return nil, nil, cli.NewExitError("Cannot create Bridge folders: "+err.Error(), 1)
+	}
+
+	lock, err := singleinstance.CreateLockFile(cfg.GetLockPath())
+	if err != nil {
+		return nil, nil, cli.NewExitError("Bridge is running, quit it first.", 3)
+	}
+
+	return cfg, func() { _ = lock.Close() }, nil
+}
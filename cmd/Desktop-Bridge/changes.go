@@ -0,0 +1,129 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/changes"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+// changesCommand prints the journal of mailbox changes of the account as JSON
+// lines, e.g. `bridge changes --follow --since 120 user@pm.me`.
+func changesCommand() cli.Command {
+	return cli.Command{
+		Name:      "changes",
+		Usage:     "Print changes of mailboxes of the account as JSON lines (Bridge can be running)",
+		ArgsUsage: "account",
+		Flags: []cli.Flag{
+			cli.Uint64Flag{
+				Name:  "since",
+				Usage: "Print only changes after the change with this Seq"},
+			cli.StringFlag{
+				Name:  "label",
+				Usage: "Print only changes of the label ID"},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "Print only changes of the type (added, removed or flags)"},
+			cli.BoolFlag{
+				Name:  "follow",
+				Usage: "Keep printing new changes until interrupted"},
+		},
+		Action: runChanges,
+	}
+}
+
+func runChanges(context *cli.Context) error {
+	account := context.Args().First()
+	if account == "" {
+		return cli.NewExitError("Account is required", 1)
+	}
+
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	storePath, err := getStorePath(cfg.GetDBDir(), account)
+	if err != nil {
+		return err
+	}
+	journalPath := store.GetChangeJournalPath(storePath)
+
+	filter := changes.Filter{
+		Since:   context.Uint64("since"),
+		LabelID: context.String("label"),
+		Type:    context.String("type"),
+	}
+	enc := json.NewEncoder(os.Stdout)
+
+	if !context.Bool("follow") {
+		found, err := changes.Read(journalPath, filter)
+		if err != nil {
+			return cli.NewExitError("Cannot read changes: "+err.Error(), 1)
+		}
+		for _, change := range found {
+			if err := enc.Encode(change); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+
+	if err := changes.Tail(journalPath, filter, stop, func(change *changes.Change) error {
+		return enc.Encode(change)
+	}); err != nil {
+		return cli.NewExitError("Cannot read changes: "+err.Error(), 1)
+	}
+	return nil
+}
+
+// getStorePath returns the path of the store database of the account given
+// by its name or user ID.
+func getStorePath(dbDir, account string) (string, error) {
+	storePaths, err := filepath.Glob(filepath.Join(dbDir, "mailbox-*"))
+	if err != nil {
+		return "", cli.NewExitError(err.Error(), 1)
+	}
+
+	names := getAccountNames()
+	for _, storePath := range storePaths {
+		match := storeFileRgx.FindStringSubmatch(filepath.Base(storePath))
+		if match == nil {
+			continue
+		}
+		if !strings.EqualFold(names[match[1]], account) && match[1] != account {
+			continue
+		}
+		return storePath, nil
+	}
+
+	return "", cli.NewExitError("No store found", 1)
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+var storeFileRgx = regexp.MustCompile(`^mailbox-(.+)\.db$`) //nolint[gochecknoglobals]
+
+// checkStoreCommand checks integrity of local stores and optionally repairs
+// them, e.g. `bridge check-store --repair user@pm.me`.
+func checkStoreCommand() cli.Command {
+	return cli.Command{
+		Name:      "check-store",
+		Usage:     "Check integrity of local mail stores (Bridge must not be running)",
+		ArgsUsage: "[account]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair",
+				Usage: "Fix found problems and resync what cannot be fixed locally when Bridge starts"},
+		},
+		Action: runCheckStore,
+	}
+}
+
+func runCheckStore(context *cli.Context) error {
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	storePaths, err := filepath.Glob(filepath.Join(cfg.GetDBDir(), "mailbox-*"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	names := getAccountNames()
+	account := context.Args().First()
+	repair := context.Bool("repair")
+
+	checked, withProblems := 0, 0
+	for _, storePath := range storePaths {
+		match := storeFileRgx.FindStringSubmatch(filepath.Base(storePath))
+		if match == nil {
+			continue
+		}
+
+		name := names[match[1]]
+		if name == "" {
+			name = match[1]
+		}
+		if account != "" && !strings.EqualFold(name, account) && match[1] != account {
+			continue
+		}
+		checked++
+
+		result, err := store.CheckStore(storePath, repair)
+		if err != nil {
+			fmt.Printf("Store of %s cannot be checked: %v\n", name, err)
+			withProblems++
+			continue
+		}
+
+		fmt.Printf("Store of %s: %d messages in %d mailboxes\n", name, result.Messages, result.Mailboxes)
+		for _, problem := range result.Problems {
+			fmt.Println("  " + formatCheckProblem(problem))
+		}
+		if result.ResyncScheduled {
+			fmt.Println("  Resync scheduled, it runs when Bridge starts")
+		}
+		if len(result.Problems) != 0 {
+			withProblems++
+		}
+	}
+
+	if checked == 0 {
+		return cli.NewExitError("No store to check", 1)
+	}
+	if withProblems != 0 && !repair {
+		return cli.NewExitError("Problems found, run with --repair to fix them", 1)
+	}
+	return nil
+}
+
+func formatCheckProblem(problem *store.CheckProblem) string {
+	line := problem.Description
+	if problem.Mailbox != "" {
+		line = problem.Mailbox + ": " + line
+	}
+	switch {
+	case problem.Repaired && problem.NeedsResync:
+		line += " [removed, resync]"
+	case problem.Repaired:
+		line += " [repaired]"
+	}
+	return line
+}
+
+// getAccountNames returns usernames by user IDs of accounts in the
+// credentials store. Stores of unknown accounts are shown by user ID.
+func getAccountNames() map[string]string {
+	names := map[string]string{}
+
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	backend := preferences.New(cfg).Get(preferences.KeychainBackendKey)
+
+	creds, err := credentials.NewStore(config.WithProfile(appName), backend)
+	if err != nil {
+		return names
+	}
+
+	userIDs, err := creds.List()
+	if err != nil {
+		return names
+	}
+
+	for _, userID := range userIDs {
+		if c, err := creds.Get(userID); err == nil {
+			names[userID] = c.Name
+		}
+	}
+	return names
+}
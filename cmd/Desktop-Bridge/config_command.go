@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+// configCommand checks and shows the configuration file, e.g.
+// `bridge config validate` or `bridge config show > config.yaml`.
+func configCommand() cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "Check or show the configuration file",
+		Subcommands: []cli.Command{
+			{
+				Name:      "validate",
+				Usage:     "Check the configuration file and environment variables",
+				ArgsUsage: "[file]",
+				Action:    runConfigValidate,
+			},
+			{
+				Name:   "show",
+				Usage:  "Print configuration file with current values of all settings",
+				Action: runConfigShow,
+			},
+		},
+	}
+}
+
+func runConfigValidate(context *cli.Context) error {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+
+	path := context.Args().First()
+	if path == "" {
+		path = cfg.GetConfigFilePath()
+	}
+
+	// Environment is checked together with the file by loading both into
+	// preferences which are not saved.
+	pref := config.NewPreferences(cfg.GetPreferencesPath())
+	if err := preferences.LoadConfig(pref, path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Println("Configuration file", path, "does not exist, defaults are used")
+	} else {
+		fmt.Println("Configuration file", path, "is valid")
+	}
+	return nil
+}
+
+func runConfigShow(context *cli.Context) error {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	return preferences.WriteConfig(os.Stdout, preferences.New(cfg))
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// credentialsCommand moves credentials of all accounts to another machine,
+// e.g. `bridge credentials export creds.asc` and, on the new machine,
+// `bridge credentials import creds.asc`.
+func credentialsCommand() cli.Command {
+	passphraseFlag := cli.StringFlag{
+		Name:  "passphrase-file",
+		Usage: "Read passphrase of the export from `FILE` instead of the terminal",
+	}
+
+	return cli.Command{
+		Name:  "credentials",
+		Usage: "Export or import credentials of all accounts (Bridge must not be running)",
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Write credentials encrypted by a passphrase to a file",
+				ArgsUsage: "<file>",
+				Flags:     []cli.Flag{passphraseFlag},
+				Action:    runCredentialsExport,
+			},
+			{
+				Name:      "import",
+				Usage:     "Add credentials from a file written by export",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					passphraseFlag,
+					cli.BoolFlag{
+						Name:  "replace",
+						Usage: "Replace credentials of accounts which are already logged in",
+					},
+				},
+				Action: runCredentialsImport,
+			},
+		},
+	}
+}
+
+func runCredentialsExport(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: path of the export", 1)
+	}
+	exportPath := context.Args().First()
+
+	passphrase, err := getExportPassphrase(context, true)
+	if err != nil {
+		return err
+	}
+
+	credStore, unlock, err := lockCredentialsStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(exportPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600) //nolint[gosec]
+	if err != nil {
+		return cli.NewExitError("Cannot create export: "+err.Error(), 1)
+	}
+
+	userIDs, err := credStore.Export(f, appName, passphrase)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(exportPath)
+		return cli.NewExitError("Cannot export credentials: "+err.Error(), 1)
+	}
+
+	fmt.Printf("Credentials of %d account(s) written to %s.\n", len(userIDs), exportPath)
+	fmt.Println("The export contains logged in sessions, do not run Bridge on this machine after importing it elsewhere.")
+	return nil
+}
+
+func runCredentialsImport(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: path of the export", 1)
+	}
+
+	f, err := os.Open(context.Args().First())
+	if err != nil {
+		return cli.NewExitError("Cannot open export: "+err.Error(), 1)
+	}
+	defer f.Close() //nolint[errcheck]
+
+	passphrase, err := getExportPassphrase(context, false)
+	if err != nil {
+		return err
+	}
+
+	credStore, unlock, err := lockCredentialsStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	imported, skipped, err := credStore.Import(f, appName, passphrase, context.Bool("replace"))
+	if err == credentials.ErrWrongExportPassphrase {
+		return cli.NewExitError("Wrong passphrase", 1)
+	}
+	if err != nil {
+		return cli.NewExitError("Cannot import credentials: "+err.Error(), 1)
+	}
+
+	fmt.Printf("Credentials of %d account(s) imported.\n", len(imported))
+	for _, userID := range skipped {
+		fmt.Println("Skipped already logged in account", userID, "(use --replace to overwrite it)")
+	}
+	return nil
+}
+
+// lockCredentialsStore makes sure Bridge is not running and opens the
+// credentials in the keychain selected in preferences.
+func lockCredentialsStore() (credStore *credentials.Store, unlock func(), err error) {
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credStore, err = credentials.NewStore(config.WithProfile(appName), preferences.New(cfg).Get(preferences.KeychainBackendKey))
+	if err != nil {
+		unlock()
+		return nil, nil, cli.NewExitError("Cannot open keychain: "+err.Error(), 1)
+	}
+
+	return credStore, unlock, nil
+}
+
+// getExportPassphrase reads the passphrase from the file given by flag or
+// asks for it in the terminal; new passphrase has to be entered twice.
+func getExportPassphrase(context *cli.Context, confirm bool) ([]byte, error) {
+	if path := context.String("passphrase-file"); path != "" {
+		passphrase, err := ioutil.ReadFile(path) //nolint[gosec]
+		if err != nil {
+			return nil, cli.NewExitError("Cannot read passphrase: "+err.Error(), 1)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, cli.NewExitError("Passphrase file is empty", 1)
+		}
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, cli.NewExitError("Cannot ask for passphrase, use --passphrase-file", 1)
+	}
+
+	fmt.Print("Passphrase: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, cli.NewExitError("Cannot read passphrase: "+err.Error(), 1)
+	}
+	if len(passphrase) == 0 {
+		return nil, cli.NewExitError("Passphrase must not be empty", 1)
+	}
+
+	if confirm {
+		fmt.Print("Repeat passphrase: ")
+		repeated, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, cli.NewExitError("Cannot read passphrase: "+err.Error(), 1)
+		}
+		if !bytes.Equal(passphrase, repeated) {
+			return nil, cli.NewExitError("Passphrases do not match", 1)
+		}
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/cmd"
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/transfer"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/urfave/cli"
+)
+
+// exportProgressInterval limits how often progress of export is printed.
+const exportProgressInterval = 5 * time.Second
+
+// exportCommand exports all messages of the account to local files, e.g.
+// `bridge export --format mbox user@pm.me ~/backup` as a scheduled job.
+func exportCommand() cli.Command {
+	return cli.Command{
+		Name:      "export",
+		Usage:     "Export messages of the account to EML or MBOX files (Bridge must not be running)",
+		ArgsUsage: "<account> <folder>",
+		Action:    runExport,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format, f",
+				Value: "eml",
+				Usage: "Format of exported messages, eml or mbox",
+			},
+			cli.BoolFlag{
+				Name:  "skip-encrypted",
+				Usage: "Skip messages which cannot be decrypted instead of exporting them encrypted",
+			},
+		},
+	}
+}
+
+// noExportMetrics is used instead of metrics of the Import-Export app.
+type noExportMetrics struct{}
+
+func (noExportMetrics) Load(int)  {}
+func (noExportMetrics) Start()    {}
+func (noExportMetrics) Complete() {}
+func (noExportMetrics) Cancel()   {}
+func (noExportMetrics) Fail()     {}
+
+func runExport(context *cli.Context) (contextError error) {
+	if context.NArg() != 2 {
+		return cli.NewExitError("Expected two arguments: account and folder", 1)
+	}
+	account, path := context.Args().Get(0), context.Args().Get(1)
+
+	var target transfer.TargetProvider
+	switch format := context.String("format"); format {
+	case "eml":
+		target = transfer.NewEMLProvider(path)
+	case "mbox":
+		target = transfer.NewMBOXProvider(path)
+	default:
+		return cli.NewExitError("Unknown format "+format+", use eml or mbox", 1)
+	}
+
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	panicHandler := &cmd.PanicHandler{
+		AppName:  "ProtonMail Bridge",
+		Config:   cfg,
+		Err:      &contextError,
+		Headless: true,
+	}
+	defer panicHandler.HandlePanic()
+
+	pref := preferences.New(cfg)
+	cmd.SetupProxy(pref)
+	eventListener := listener.New()
+	events.SetupEvents(eventListener)
+
+	b, cm, err := newBridge(cfg, pref, panicHandler, eventListener)
+	if err != nil {
+		return cli.NewExitError("Cannot open keychain: "+err.Error(), 1)
+	}
+	defer func() {
+		if err := b.CloseStores(); err != nil {
+			log.WithError(err).Error("Cannot close stores")
+		}
+	}()
+
+	user, err := b.GetUser(account)
+	if err != nil {
+		return cli.NewExitError("Unknown account "+account, 1)
+	}
+	if !user.IsConnected() {
+		return cli.NewExitError("Account "+user.Username()+" is logged out, log it in first", 1)
+	}
+
+	// Address selects messages of one address in split address mode,
+	// messages of all addresses are exported otherwise.
+	addressID, err := user.GetAddressID(account)
+	if err != nil {
+		log.WithError(err).Info("Address does not exist, using all addresses")
+	}
+
+	source, err := transfer.NewPMAPIProvider(cfg.GetAPIConfig(), cm, user.ID(), addressID)
+	if err != nil {
+		return cli.NewExitError("Cannot export: "+err.Error(), 1)
+	}
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return cli.NewExitError("Cannot create folder: "+err.Error(), 1)
+	}
+
+	t, err := transfer.New(panicHandler, noExportMetrics{}, cfg.GetLogDir(), cfg.GetTransferDir(), source, target)
+	if err != nil {
+		return cli.NewExitError("Cannot export: "+err.Error(), 1)
+	}
+	t.SetSkipEncryptedMessages(context.Bool("skip-encrypted"))
+
+	fmt.Printf("Exporting %s to %s ...\n", user.Username(), path)
+	return runExportTransfer(t.Start())
+}
+
+// runExportTransfer prints progress until the export is done. Pauses, e.g.
+// when connection is lost, are only reported; the transfer continues by
+// itself once the reason is gone.
+func runExportTransfer(progress *transfer.Progress) error {
+	var printed time.Time
+	var pauseReason string
+	for range progress.GetUpdateChannel() {
+		if progress.IsPaused() {
+			if reason := progress.PauseReason(); reason != pauseReason {
+				fmt.Println("Export is paused:", reason)
+				pauseReason = reason
+			}
+			continue
+		}
+		pauseReason = ""
+
+		if time.Since(printed) < exportProgressInterval {
+			continue
+		}
+		if counts := progress.GetCounts(); counts.Total != 0 {
+			fmt.Printf("Exported %d / %d, skipped %d, failed %d\n", counts.Exported, counts.Total, counts.Skipped, counts.Failed)
+			printed = time.Now()
+		}
+	}
+
+	if err := progress.GetFatalError(); err != nil {
+		return cli.NewExitError("Export failed: "+err.Error(), 1)
+	}
+
+	counts := progress.GetCounts()
+	fmt.Printf("Exported %d / %d, skipped %d, failed %d\n", counts.Exported, counts.Total, counts.Skipped, counts.Failed)
+
+	if failed := progress.GetFailedMessages(); len(failed) != 0 {
+		for _, status := range failed {
+			fmt.Printf(" %s: %s\n", status.SourceID, status.GetErrorMessage())
+		}
+		if report := progress.FileReport(); report != "" {
+			fmt.Println("Details are in", report)
+		}
+		return cli.NewExitError("Export finished with errors", 1)
+	}
+	return nil
+}
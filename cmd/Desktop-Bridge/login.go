@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/internal/cmd"
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// loginCommand logs in the account without starting Bridge, e.g.
+// `bridge login` in the terminal or `bridge login --from creds.txt` in
+// scripts before Bridge is started as a service.
+func loginCommand() cli.Command {
+	return cli.Command{
+		Name:  "login",
+		Usage: "Log in an account and print its Bridge password (Bridge must not be running)",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "Read credentials from `SOURCE`: env, - for stdin or path to a file readable only by owner (asked in the terminal when not set)",
+			},
+			cli.StringFlag{
+				Name:  "username, u",
+				Usage: "Username of the account to log in when asking in the terminal",
+			},
+		},
+		Action: runLogin,
+	}
+}
+
+func runLogin(context *cli.Context) (contextError error) {
+	if context.NArg() != 0 {
+		return cli.NewExitError("Unexpected argument, use --from to read credentials", 1)
+	}
+
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	panicHandler := &cmd.PanicHandler{
+		AppName:  "ProtonMail Bridge",
+		Config:   cfg,
+		Err:      &contextError,
+		Headless: true,
+	}
+	defer panicHandler.HandlePanic()
+
+	pref := preferences.New(cfg)
+	cmd.SetupProxy(pref)
+	cmd.SetupTelemetry(pref)
+	eventListener := listener.New()
+	events.SetupEvents(eventListener)
+
+	b, _, err := newBridge(cfg, pref, panicHandler, eventListener)
+	if err != nil {
+		return cli.NewExitError("Cannot open keychain: "+err.Error(), 1)
+	}
+	defer func() {
+		if err := b.CloseStores(); err != nil {
+			log.WithError(err).Error("Cannot close stores")
+		}
+	}()
+
+	var user *users.User
+	if source := context.String("from"); source != "" {
+		user, err = loginWithSource(b, source)
+	} else {
+		user, err = loginInTerminal(b, context.String("username"))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Account %s is logged in.\n", user.Username())
+	fmt.Println("Addresses:", strings.Join(user.GetAddresses(), ", "))
+	fmt.Println("Bridge password:", user.GetBridgePassword())
+	return nil
+}
+
+// loginInTerminal asks for the credentials the same way as the CLI frontend,
+// two factor code and mailbox password only when the account needs them.
+func loginInTerminal(b *bridge.Bridge, username string) (*users.User, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, cli.NewExitError("Cannot ask for credentials, use --from", 1)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	readLine := func(prompt string) (string, error) {
+		fmt.Print(prompt + ": ")
+		line, err := reader.ReadString('\n')
+		return strings.TrimSpace(line), err
+	}
+	readPassword := func(prompt string) (string, error) {
+		fmt.Print(prompt + ": ")
+		password, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		return string(password), err
+	}
+
+	var err error
+	if username == "" {
+		if username, err = readLine("Username"); err != nil || username == "" {
+			return nil, cli.NewExitError("Username is required", 1)
+		}
+	}
+	password, err := readPassword("Password")
+	if err != nil || password == "" {
+		return nil, cli.NewExitError("Password is required", 1)
+	}
+
+	fmt.Println("Authenticating ...")
+	client, auth, err := b.Login(username, password)
+	if err != nil {
+		return nil, cli.NewExitError("Login failed: "+err.Error(), 1)
+	}
+
+	if auth.HasTwoFactor() {
+		code, err := readLine("Two factor code")
+		if err != nil || code == "" {
+			client.Logout()
+			return nil, cli.NewExitError("Two factor code is required", 1)
+		}
+		if err := client.Auth2FA(code, auth); err != nil {
+			client.Logout()
+			return nil, cli.NewExitError("Login failed: "+err.Error(), 1)
+		}
+	}
+
+	mailboxPassword := password
+	if auth.HasMailboxPassword() {
+		if mailboxPassword, err = readPassword("Mailbox password"); err != nil || mailboxPassword == "" {
+			client.Logout()
+			return nil, cli.NewExitError("Mailbox password is required", 1)
+		}
+	}
+
+	fmt.Println("Adding account ...")
+	user, err := b.FinishLogin(client, auth, mailboxPassword)
+	if err != nil {
+		return nil, cli.NewExitError("Adding account failed: "+err.Error(), 1)
+	}
+	return user, nil
+}
+
+// loginFromSource logs in the account by credentials from the source given
+// by --login flag: `env` for environment variables, `-` for stdin or path
+// to a credentials file. See users.ReadLoginCredentials for the format.
+func loginFromSource(b *bridge.Bridge, source string) error {
+	user, err := loginWithSource(b, source)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("username", user.Username()).Info("Logged in non-interactively")
+	return nil
+}
+
+// loginWithSource logs in by credentials from the source and returns the
+// logged in user.
+func loginWithSource(b *bridge.Bridge, source string) (*users.User, error) {
+	var creds *users.LoginCredentials
+	var err error
+
+	switch source {
+	case "env":
+		creds, err = users.ReadLoginCredentialsFromEnv()
+	case "-":
+		creds, err = users.ReadLoginCredentials(os.Stdin)
+	default:
+		creds, err = users.ReadLoginCredentialsFile(source)
+	}
+	if err != nil {
+		return nil, cli.NewExitError("Cannot read login credentials: "+err.Error(), 1)
+	}
+
+	user, err := b.LoginWithCredentials(creds)
+	if err != nil {
+		return nil, cli.NewExitError("Login failed: "+err.Error(), 1)
+	}
+	return user, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+// logsCommand manages log files without running Bridge.
+func logsCommand() cli.Command {
+	return cli.Command{
+		Name:  "logs",
+		Usage: "Manage log files and crash dumps",
+		Subcommands: []cli.Command{
+			{
+				Name:   "prune",
+				Usage:  "Remove logs and crash dumps over the limits of app.log_* settings",
+				Action: runLogsPrune,
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "max-files",
+						Usage: "Log files and crash dumps kept instead of app.log_max_files",
+					},
+					cli.IntFlag{
+						Name:  "max-total-mb",
+						Usage: "Size of all logs instead of app.log_max_total_mb",
+					},
+					cli.IntFlag{
+						Name:  "max-age-days",
+						Usage: "Age of logs instead of app.log_max_age_days",
+					},
+				},
+			},
+		},
+	}
+}
+
+// runLogsPrune is safe to run while Bridge is running because the newest
+// log file, which is the one being written, is never removed.
+func runLogsPrune(context *cli.Context) error {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	pref := preferences.New(cfg)
+
+	for key, flag := range map[string]string{
+		preferences.LogMaxFilesKey:     "max-files",
+		preferences.LogMaxTotalSizeKey: "max-total-mb",
+		preferences.LogMaxAgeKey:       "max-age-days",
+	} {
+		if context.IsSet(flag) {
+			pref.SetOverride(key, fmt.Sprint(context.Int(flag)))
+		}
+	}
+
+	removed, err := config.PruneLogs(cfg.GetLogDir(), preferences.GetLogRetention(pref))
+	for _, path := range removed {
+		fmt.Println("Removed", path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return cli.NewExitError("Cannot prune logs: "+err.Error(), 1)
+	}
+
+	fmt.Println("Removed", len(removed), "files from", cfg.GetLogDir())
+	return nil
+}
@@ -35,25 +35,35 @@ package main
 */
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"syscall"
 
 	"github.com/ProtonMail/proton-bridge/internal/api"
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/cmd"
 	"github.com/ProtonMail/proton-bridge/internal/cookies"
+	"github.com/ProtonMail/proton-bridge/internal/dbus"
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/frontend"
 	"github.com/ProtonMail/proton-bridge/internal/imap"
+	"github.com/ProtonMail/proton-bridge/internal/lmtp"
 	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/internal/smtp"
 	"github.com/ProtonMail/proton-bridge/internal/updates"
 	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
+	"github.com/ProtonMail/proton-bridge/internal/webhooks"
 	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/constants"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/netwatch"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
+	"github.com/ProtonMail/proton-bridge/pkg/systemd"
 	"github.com/allan-simon/go-singleinstance"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -61,7 +71,8 @@ import (
 
 const (
 	// cacheVersion is used for cache files such as lock, events, preferences, user_info, db files.
-	// Different number will drop old files and create new ones.
+	// Different number will drop old files and create new ones. Changes of the store database
+	// do not need it, they are done by store migrations (see internal/store/migrations.go).
 	cacheVersion = "c11"
 
 	appName = "bridge"
@@ -75,18 +86,62 @@ func main() {
 	cmd.Main(
 		"ProtonMail Bridge",
 		"ProtonMail IMAP and SMTP Bridge",
-		[]cli.Flag{
-			cli.BoolFlag{
-				Name:  "no-window",
-				Usage: "Don't show window after start"},
-			cli.BoolFlag{
-				Name:  "noninteractive",
-				Usage: "Start Bridge entirely noninteractively"},
-		},
+		runFlags(),
+		[]cli.Command{serveCommand(), loginCommand(), accountsCommand(), exportCommand(), sendmailCommand(), backupCommand(), restoreCommand(), checkStoreCommand(), migrateStoreCommand(), changesCommand(), snapshotStoreCommand(), configCommand(), credentialsCommand(), statusCommand(), rollbackCommand(), logsCommand(), tlsExceptionsCommand()},
 		run,
 	)
 }
 
+// runFlags are flags of running Bridge in addition to the base flags of all
+// apps. They are accepted both by the app itself and by `bridge serve`.
+func runFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-window",
+			Usage: "Don't show window after start"},
+		cli.BoolFlag{
+			Name:  "noninteractive",
+			Usage: "Start Bridge entirely noninteractively"},
+		cli.BoolFlag{
+			Name:  "headless",
+			Usage: "Run without any frontend until terminated, e.g. as a service (log in by --cli or --login first)"},
+		cli.BoolFlag{
+			Name:  "grpc",
+			Usage: "Start gRPC API for a frontend running in another process instead of the GUI"},
+		cli.StringFlag{
+			Name:  "login",
+			Usage: "Log in the account by credentials from `SOURCE`: env, - for stdin or path to a file readable only by owner"},
+		cli.BoolFlag{
+			Name:  "imap-trace",
+			Usage: "Log IMAP dialogue of all connections with credentials and literals redacted"},
+	}
+}
+
+// serveCommand runs Bridge the same way as running the app without command,
+// e.g. `bridge serve --headless` as a service.
+func serveCommand() cli.Command {
+	return cli.Command{
+		Name:   "serve",
+		Usage:  "Run Bridge with its IMAP and SMTP servers (the default when no command is given)",
+		Flags:  append(cmd.GetBaseFlags(), runFlags()...),
+		Action: run,
+	}
+}
+
+// flagBool returns the flag given either to the app or to the command, so
+// `run` works for both `bridge --headless` and `bridge serve --headless`.
+func flagBool(context *cli.Context, name string) bool {
+	return context.Bool(name) || context.GlobalBool(name)
+}
+
+// flagString is like flagBool for string flags, the flag of the command wins.
+func flagString(context *cli.Context, name string) string {
+	if value := context.String(name); value != "" {
+		return value
+	}
+	return context.GlobalString(name)
+}
+
 // run initializes and starts everything in a precise order.
 //
 // IMPORTANT: ***Read the comments before CHANGING the order ***
@@ -99,9 +154,10 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	// report which will not be possible if no folder can be created. That's the
 	// only problem we will not be notified about in any way.
 	panicHandler := &cmd.PanicHandler{
-		AppName: "ProtonMail Bridge",
-		Config:  cfg,
-		Err:     &contextError,
+		AppName:  "ProtonMail Bridge",
+		Config:   cfg,
+		Err:      &contextError,
+		Headless: flagBool(context, "headless"),
 	}
 	defer panicHandler.HandlePanic()
 
@@ -111,7 +167,7 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	}
 
 	// Setup of logs should be as soon as possible to ensure we record every wanted report in the log.
-	logLevel := context.GlobalString("log-level")
+	logLevel := flagString(context, "log-level")
 	debugClient, debugServer := config.SetupLog(cfg, logLevel)
 
 	// Doesn't make sense to continue when Bridge was invoked with wrong arguments.
@@ -125,7 +181,7 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	// (thus we put it before check of presence of other Bridge instance).
 	updates := updates.NewBridge(cfg.GetUpdateDir())
 
-	if dir := context.GlobalString("version-json"); dir != "" {
+	if dir := flagString(context, "version-json"); dir != "" {
 		cmd.GenerateVersionFiles(updates, dir)
 		return nil
 	}
@@ -152,27 +208,45 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	}
 
 	pref := preferences.New(cfg)
+	cmd.SetupCrashReports(cfg, pref)
+	cmd.SetupProxy(pref)
+	cmd.SetupTelemetry(pref)
+	config.SetLogRetention(preferences.GetLogRetention(pref))
+	updates.SetChannel(pref.Get(preferences.UpdateChannelKey))
+
+	// IMAP and SMTP can use the certificate supplied by the user instead of
+	// the generated one, e.g. when connecting from other machines in LAN.
+	// The generated one is still used for local bridge API.
+	listenerTLS := tls
+	if certPath, keyPath := pref.Get(preferences.TLSCertPathKey), pref.Get(preferences.TLSKeyPathKey); certPath != "" && keyPath != "" {
+		if userTLS, err := config.GetUserTLSConfig(certPath, keyPath); err != nil {
+			log.WithError(err).Error("Cannot load user TLS certificate, using generated one")
+		} else {
+			listenerTLS = userTLS
+		}
+	}
 
 	// Now we can try to proceed with starting the bridge. First we need to ensure
 	// this is the only instance. If not, we will end and focus the existing one.
 	lock, err := singleinstance.CreateLockFile(cfg.GetLockPath())
 	if err != nil {
-		log.Warn("Bridge is already running")
+		log.WithError(err).Warn("Bridge is already running")
 		if err := api.CheckOtherInstanceAndFocus(pref.GetInt(preferences.APIPortKey), tls); err != nil {
 			cmd.DisableRestart()
 			log.Error("Second instance: ", err)
+			return cli.NewExitError(getNotRespondingMessage(cfg.GetLockPath()), 3)
 		}
 		return cli.NewExitError("Bridge is already running.", 3)
 	}
 	defer lock.Close() //nolint[errcheck]
 
 	// In case user wants to do CPU or memory profiles...
-	if doCPUProfile := context.GlobalBool("cpu-prof"); doCPUProfile {
+	if doCPUProfile := flagBool(context, "cpu-prof"); doCPUProfile {
 		cmd.StartCPUProfile()
 		defer pprof.StopCPUProfile()
 	}
 
-	if doMemoryProfile := context.GlobalBool("mem-prof"); doMemoryProfile {
+	if doMemoryProfile := flagBool(context, "mem-prof"); doMemoryProfile {
 		defer cmd.MakeMemoryProfile()
 	}
 
@@ -181,59 +255,189 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	eventListener := listener.New()
 	events.SetupEvents(eventListener)
 
-	credentialsStore, credentialsError := credentials.NewStore(appName)
-	if credentialsError != nil {
-		log.Error("Could not get credentials store: ", credentialsError)
+	bridgeInstance, _, credentialsError := newBridge(cfg, pref, panicHandler, eventListener)
+	imapBackend := imap.NewIMAPBackend(panicHandler, eventListener, cfg, pref, bridgeInstance)
+	smtpBackend := smtp.NewSMTPBackend(panicHandler, eventListener, pref, bridgeInstance, cfg.GetSMTPQueueDir())
+
+	// Scripted deployments log in before anything is served, so accounts
+	// are available from the start.
+	if source := flagString(context, "login"); source != "" {
+		if err := loginFromSource(bridgeInstance, source); err != nil {
+			return err
+		}
 	}
 
-	cm := pmapi.NewClientManager(cfg.GetAPIConfig())
+	// Control endpoints of the API are protected by token in the config
+	// folder, so only the user running Bridge can use them.
+	apiToken, err := api.LoadToken(cfg.GetAPITokenPath())
+	if err != nil {
+		log.WithError(err).Error("Cannot load API token, control API is disabled")
+	}
+	apiServer := api.NewAPIServer(pref, tls, cfg.GetTLSCertPath(), cfg.GetTLSKeyPath(), eventListener, bridgeInstance, apiToken)
+	go func() {
+		defer panicHandler.HandlePanic()
+		apiServer.ListenAndServe()
+	}()
 
-	// Different build types have different roundtrippers (e.g. we want to enable
-	// TLS fingerprint checks in production builds). GetRoundTripper has a different
-	// implementation depending on whether build flag pmapi_prod is used or not.
-	cm.SetRoundTripper(cfg.GetRoundTripper(cm, eventListener))
+	// Configuration is reloaded on SIGHUP so it can be changed without
+	// dropping connections of clients.
+	reloader := &reloader{
+		cfg:            cfg,
+		pref:           pref,
+		bridge:         bridgeInstance,
+		updates:        updates,
+		debugServer:    cmd.NewDebugServer(apiToken),
+		logLevelByFlag: logLevel != "",
+	}
+	applyLogLevel(pref, reloader.logLevelByFlag)
+	reloader.debugServer.SetPort(pref.GetInt(preferences.DebugPortKey))
+
+	shutdown := &gracefulShutdown{queue: smtpBackend, bridge: bridgeInstance}
+
+	imapTrace := flagBool(context, "imap-trace")
+	startIMAP := func(imapListener bridge.ListenerConfig) {
+		useNamespace := pref.GetBool(preferences.IMAPNamespaceKey)
+		imapServer := imap.NewIMAPServer(debugClient, debugServer, imapTrace, imapListener, getIMAPLimits(pref), getIMAPTimeouts(pref), useNamespace, listenerTLS, imapBackend, eventListener)
+		reloader.imapServers = append(reloader.imapServers, imapServer)
+		apiServer.AddConnections(imapServer)
+		apiServer.AddListener("imap "+imapListener.Address(), imapServer)
+		shutdown.addServer(imapServer)
+		go func() {
+			defer panicHandler.HandlePanic()
+			imapServer.ListenAndServe()
+		}()
+	}
 
-	// Cookies must be persisted across restarts.
-	jar, err := cookies.NewCookieJar(pref)
+	startSMTP := func(smtpListener bridge.ListenerConfig, useSSL bool) {
+		smtpServer := smtp.NewSMTPServer(debugClient || debugServer, smtpListener, useSSL, listenerTLS, smtpBackend, eventListener)
+		apiServer.AddListener("smtp "+smtpListener.Address(), smtpServer)
+		shutdown.addServer(smtpServer)
+		go func() {
+			defer panicHandler.HandlePanic()
+			smtpServer.ListenAndServe()
+		}()
+	}
+
+	// Sockets passed by systemd socket activation are named by
+	// FileDescriptorName= in the socket unit and used instead of the
+	// configured ports.
+	activated, err := systemd.Listeners()
 	if err != nil {
-		logrus.WithError(err).Warn("Could not create cookie jar")
-	} else {
-		cm.SetCookieJar(jar)
+		log.WithError(err).Fatal("Cannot use sockets passed by systemd")
+	}
+	takeActivated := func(name string) net.Listener {
+		l := activated[name]
+		delete(activated, name)
+		return l
 	}
 
-	bridgeInstance := bridge.New(cfg, pref, panicHandler, eventListener, cm, credentialsStore)
-	imapBackend := imap.NewIMAPBackend(panicHandler, eventListener, cfg, bridgeInstance)
-	smtpBackend := smtp.NewSMTPBackend(panicHandler, eventListener, pref, bridgeInstance)
+	imapListener := newListenerConfig(pref, preferences.IMAPPortKey, preferences.IMAPSocketKey)
+	imapListener.Activated = takeActivated("imap")
+	smtpListener := newListenerConfig(pref, preferences.SMTPPortKey, preferences.SMTPSocketKey)
+	smtpListener.Activated = takeActivated("smtp")
+	smtpUseSSL := pref.GetBool(preferences.SMTPSSLKey)
+	startIMAP(imapListener)
+	startSMTP(smtpListener, smtpUseSSL)
+
+	// Some clients support only one of STARTTLS and implicit TLS, so SMTPS
+	// can listen on another port next to the main SMTP listener.
+	smtpsActivated := takeActivated("smtps")
+	if smtpsPort := pref.GetInt(preferences.SMTPSPortKey); smtpsPort != 0 || smtpsActivated != nil {
+		smtpsListener := smtpListener
+		smtpsListener.Port = smtpsPort
+		smtpsListener.SocketPath = ""
+		smtpsListener.Activated = smtpsActivated
+		startSMTP(smtpsListener, true)
+	}
+
+	// Accounts with dedicated ports get their own listeners in addition
+	// to the shared ones so clients mishandling several logins on one port
+	// can be configured per account.
+	accountPorts, err := bridge.ParseAccountPorts(pref.Get(preferences.AccountPortsKey))
+	if err != nil {
+		log.WithError(err).Fatal("Cannot parse account ports")
+	}
+	for account, ports := range accountPorts {
+		if ports.IMAP != 0 {
+			startIMAP(newAccountListenerConfig(imapListener, account, ports.IMAP))
+		}
+		if ports.SMTP != 0 {
+			startSMTP(newAccountListenerConfig(smtpListener, account, ports.SMTP), smtpUseSSL)
+		}
+	}
+
+	// LMTP has no authentication, so it is never bound to other than
+	// loopback address. It is off unless a port or a socket is set.
+	lmtpListener := bridge.ListenerConfig{
+		Host:       "127.0.0.1",
+		Port:       pref.GetInt(preferences.LMTPPortKey),
+		SocketPath: pref.Get(preferences.LMTPSocketKey),
+		Activated:  takeActivated("lmtp"),
+	}
+	if lmtpListener.Port != 0 || lmtpListener.SocketPath != "" || lmtpListener.Activated != nil {
+		lmtpBackend := lmtp.NewLMTPBackend(panicHandler, bridgeInstance)
+		lmtpServer := lmtp.NewLMTPServer(panicHandler, lmtpListener, lmtpBackend, eventListener)
+		apiServer.AddListener("lmtp "+lmtpListener.Address(), lmtpServer)
+		go func() {
+			defer panicHandler.HandlePanic()
+			lmtpServer.ListenAndServe()
+		}()
+	}
+
+	for name, l := range activated {
+		log.WithField("name", name).Warn("Unknown socket passed by systemd, use imap, smtp, smtps or lmtp")
+		_ = l.Close()
+	}
 
 	go func() {
 		defer panicHandler.HandlePanic()
-		apiServer := api.NewAPIServer(pref, tls, cfg.GetTLSCertPath(), cfg.GetTLSKeyPath(), eventListener)
-		apiServer.ListenAndServe()
+		reloader.watch()
 	}()
 
+	// Desktop applets and scripts get accounts and new mail over D-Bus
+	// when there is a session bus.
 	go func() {
 		defer panicHandler.HandlePanic()
-		imapPort := pref.GetInt(preferences.IMAPPortKey)
-		imapServer := imap.NewIMAPServer(debugClient, debugServer, imapPort, tls, imapBackend, eventListener)
-		imapServer.ListenAndServe()
+		dbus.Serve(eventListener, bridgeInstance)
 	}()
 
 	go func() {
 		defer panicHandler.HandlePanic()
-		smtpPort := pref.GetInt(preferences.SMTPPortKey)
-		useSSL := pref.GetBool(preferences.SMTPSSLKey)
-		smtpServer := smtp.NewSMTPServer(debugClient || debugServer, smtpPort, useSSL, tls, smtpBackend, eventListener)
-		smtpServer.ListenAndServe()
+		webhooks.Serve(panicHandler, eventListener, pref, bridgeInstance)
+	}()
+
+	// Connections to API are replaced right after network change or resume
+	// from sleep instead of waiting minutes for TCP timeouts.
+	go func() {
+		defer panicHandler.HandlePanic()
+		netwatch.Watch(panicHandler, func() {
+			sysproxy.Reset()
+			bridgeInstance.NetworkChanged()
+		})
+	}()
+
+	// Services with Type=notify are started once listeners are set up.
+	// Accounts are loaded already and sync runs in the background.
+	if err := systemd.Notify(systemd.Ready); err != nil {
+		log.WithError(err).Warn("Cannot notify systemd")
+	}
+	go func() {
+		defer panicHandler.HandlePanic()
+		systemd.RunWatchdog()
 	}()
 
 	// Decide about frontend mode before initializing rest of bridge.
 	var frontendMode string
 
 	switch {
-	case context.GlobalBool("cli"):
+	case flagBool(context, "cli"):
 		frontendMode = "cli"
-	case context.GlobalBool("noninteractive"):
+	case flagBool(context, "noninteractive"):
 		frontendMode = "noninteractive"
+	case flagBool(context, "headless"):
+		frontendMode = "headless"
+	case flagBool(context, "grpc"):
+		frontendMode = "grpc"
 	default:
 		frontendMode = "qt"
 	}
@@ -246,7 +450,19 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 		return nil
 	}
 
-	showWindowOnStart := !context.GlobalBool("no-window")
+	// Headless mode has no frontend at all, accounts are managed by --cli
+	// and the state is provided by the local bridge API. It runs until
+	// the service manager or container runtime stops it.
+	if frontendMode == "headless" {
+		log.Info("Running headless, check status at local bridge API /status with the API token")
+		waitForTermination()
+		log.Info("Terminated, exiting")
+		_ = systemd.Notify(systemd.Stopping)
+		shutdown.run()
+		return nil
+	}
+
+	showWindowOnStart := !flagBool(context, "no-window")
 	frontend := frontend.New(constants.Version, constants.BuildVersion, frontendMode, showWindowOnStart, panicHandler, cfg, pref, eventListener, updates, bridgeInstance, smtpBackend)
 
 	// Last part is to start everything.
@@ -256,6 +472,8 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 		return cli.NewExitError("Frontend error", 2)
 	}
 
+	shutdown.run()
+
 	if frontend.IsAppRestarting() {
 		cmd.RestartApp()
 	}
@@ -263,6 +481,41 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	return nil
 }
 
+// newBridge creates the Bridge with its credentials store and client manager
+// as used by run and by commands working with accounts. The error of the
+// credentials store is returned separately as Bridge works without it,
+// just with no accounts.
+func newBridge(cfg *config.Config, pref *config.Preferences, panicHandler *cmd.PanicHandler, eventListener listener.Listener) (b *bridge.Bridge, cm *pmapi.ClientManager, credentialsError error) {
+	credentialsStore, credentialsError := credentials.NewStore(config.WithProfile(appName), pref.Get(preferences.KeychainBackendKey))
+	if credentialsError != nil {
+		log.Error("Could not get credentials store: ", credentialsError)
+	}
+
+	cm = pmapi.NewClientManager(cfg.GetAPIConfig())
+
+	// Different build types have different roundtrippers (e.g. we want to enable
+	// TLS fingerprint checks in production builds). GetRoundTripper has a different
+	// implementation depending on whether build flag pmapi_prod is used or not.
+	cm.SetRoundTripper(cfg.GetRoundTripper(cm, eventListener))
+
+	// Cookies must be persisted across restarts.
+	jar, err := cookies.NewCookieJar(pref)
+	if err != nil {
+		logrus.WithError(err).Warn("Could not create cookie jar")
+	} else {
+		cm.SetCookieJar(jar)
+	}
+
+	return bridge.New(cfg, pref, panicHandler, eventListener, cm, credentialsStore), cm, credentialsError
+}
+
+// waitForTermination blocks until the process is interrupted or terminated.
+func waitForTermination() {
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+	<-terminate
+}
+
 // migratePreferencesFromC10 will copy preferences from c10 folder to c11.
 // It will happen only when c10/prefs.json exists and c11/prefs.json not.
 // No configuration changed between c10 and c11 versions.
@@ -293,3 +546,44 @@ func migratePreferencesFromC10(cfg *config.Config) {
 
 	log.Info("Preferences migrated")
 }
+
+// getNotRespondingMessage returns the message for the user when the running
+// instance holding the lock at `lockPath` does not respond.
+func getNotRespondingMessage(lockPath string) string {
+	pid, err := singleinstance.GetLockFilePid(lockPath)
+	if err != nil {
+		return "Bridge is already running but it does not respond. Quit it before starting Bridge again."
+	}
+	return fmt.Sprintf("Bridge is already running (process %d) but it does not respond. Quit it before starting Bridge again.", pid)
+}
+
+// newListenerConfig returns where the IMAP or SMTP server should listen
+// according to preferences.
+func newListenerConfig(pref *config.Preferences, portKey, socketKey string) bridge.ListenerConfig {
+	host := pref.Get(preferences.BindHostKey)
+	if host == "" {
+		host = bridge.Host
+	}
+
+	allowedClients, err := bridge.ParseAllowedClients(pref.Get(preferences.AllowedClientsKey))
+	if err != nil {
+		log.WithError(err).Fatal("Cannot parse allowed clients")
+	}
+
+	return bridge.ListenerConfig{
+		Host:           host,
+		Port:           pref.GetInt(portKey),
+		SocketPath:     pref.Get(socketKey),
+		AllowedClients: allowedClients,
+	}
+}
+
+// newAccountListenerConfig returns listener dedicated to the account on port
+// based on the shared listener. Dedicated listeners always use TCP.
+func newAccountListenerConfig(shared bridge.ListenerConfig, account string, port int) bridge.ListenerConfig {
+	shared.Port = port
+	shared.SocketPath = ""
+	shared.Account = account
+	shared.Activated = nil
+	return shared
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/urfave/cli"
+)
+
+// migrateStoreCommand prints schema versions of local stores or migrates
+// them, e.g. `bridge migrate-store --to 1` before downgrading Bridge.
+func migrateStoreCommand() cli.Command {
+	return cli.Command{
+		Name:      "migrate-store",
+		Usage:     "Print or change schema version of local mail stores (Bridge must not be running)",
+		ArgsUsage: "[account]",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "to",
+				Value: -1,
+				Usage: "Migrate stores up or down to the schema version, e.g. the one used by older Bridge"},
+		},
+		Action: runMigrateStore,
+	}
+}
+
+func runMigrateStore(context *cli.Context) error {
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	storePaths, err := filepath.Glob(filepath.Join(cfg.GetDBDir(), "mailbox-*"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	names := getAccountNames()
+	account := context.Args().First()
+	target := context.Int("to")
+
+	fmt.Printf("This Bridge uses store schema version %d\n", store.CurrentSchemaVersion())
+
+	found, failed := 0, 0
+	for _, storePath := range storePaths {
+		match := storeFileRgx.FindStringSubmatch(filepath.Base(storePath))
+		if match == nil {
+			continue
+		}
+
+		name := names[match[1]]
+		if name == "" {
+			name = match[1]
+		}
+		if account != "" && !strings.EqualFold(name, account) && match[1] != account {
+			continue
+		}
+		found++
+
+		if target >= 0 {
+			if err := store.MigrateStore(storePath, target); err != nil {
+				fmt.Printf("Store of %s cannot be migrated: %v\n", name, err)
+				failed++
+				continue
+			}
+		}
+
+		version, err := store.GetSchemaVersion(storePath)
+		if err != nil {
+			fmt.Printf("Store of %s cannot be read: %v\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Store of %s: schema version %d\n", name, version)
+	}
+
+	if found == 0 {
+		return cli.NewExitError("No store found", 1)
+	}
+	if failed != 0 {
+		return cli.NewExitError("Some stores failed, backups of databases are kept next to them", 1)
+	}
+	return nil
+}
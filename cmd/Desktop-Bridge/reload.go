@@ -0,0 +1,190 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/internal/cmd"
+	"github.com/ProtonMail/proton-bridge/internal/imap"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/updates"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/sentry"
+	"github.com/ProtonMail/proton-bridge/pkg/systemd"
+	"github.com/sirupsen/logrus"
+)
+
+// restartKeys are preferences used only when listeners and stores are
+// started. Changing them by reload has effect after restart.
+var restartKeys = []string{ //nolint[gochecknoglobals]
+	preferences.APIPortKey,
+	preferences.IMAPPortKey,
+	preferences.IMAPSocketKey,
+	preferences.IMAPNamespaceKey,
+	preferences.IMAPAutoLogoutKey,
+	preferences.IMAPIdleKeepaliveKey,
+	preferences.IMAPLiteralTimeoutKey,
+	preferences.SMTPPortKey,
+	preferences.SMTPSSLKey,
+	preferences.SMTPSPortKey,
+	preferences.SMTPSocketKey,
+	preferences.LMTPPortKey,
+	preferences.LMTPSocketKey,
+	preferences.BindHostKey,
+	preferences.AllowedClientsKey,
+	preferences.AccountPortsKey,
+	preferences.TLSCertPathKey,
+	preferences.TLSKeyPathKey,
+	preferences.SyncModeKey,
+}
+
+// limitedServer is an IMAP server whose connection limits can be changed
+// while it is running.
+type limitedServer interface {
+	SetLimits(imap.ConnectionLimits)
+}
+
+// reloader applies the configuration file again on SIGHUP without dropping
+// client connections. Log levels, format and retention, poll interval, rate
+// limits and debug server are applied right away, listener settings need
+// restart.
+type reloader struct {
+	cfg            *config.Config
+	pref           *config.Preferences
+	bridge         *bridge.Bridge
+	updates        *updates.Updates
+	debugServer    *cmd.DebugServer
+	imapServers    []limitedServer
+	logLevelByFlag bool
+}
+
+// watch reloads the configuration on every SIGHUP. It never returns.
+func (r *reloader) watch() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		r.reload()
+	}
+}
+
+func (r *reloader) reload() {
+	log.Info("Reloading configuration")
+	_ = systemd.Notify(systemd.Reloading)
+	defer func() { _ = systemd.Notify(systemd.Ready) }()
+
+	before := map[string]string{}
+	for _, key := range restartKeys {
+		before[key] = r.pref.Get(key)
+	}
+
+	if err := preferences.LoadConfig(r.pref, r.cfg.GetConfigFilePath()); err != nil {
+		log.WithError(err).Error("Invalid configuration skipped, check it by config validate command")
+	}
+
+	for _, key := range restartKeys {
+		if r.pref.Get(key) != before[key] {
+			log.WithField("key", key).Warn("Preference changed, restart to apply it")
+		}
+	}
+
+	r.apply()
+}
+
+// apply sets the running parts according to the current preferences.
+func (r *reloader) apply() {
+	applyLogLevel(r.pref, r.logLevelByFlag)
+	config.SetLogRetention(preferences.GetLogRetention(r.pref))
+
+	limits := getIMAPLimits(r.pref)
+	for _, server := range r.imapServers {
+		server.SetLimits(limits)
+	}
+
+	r.bridge.ApplyPreferences()
+	r.updates.SetChannel(r.pref.Get(preferences.UpdateChannelKey))
+	sentry.SetReportLevel(r.pref.Get(preferences.CrashReportsKey))
+	cmd.SetupProxy(r.pref)
+	cmd.SetupTelemetry(r.pref)
+	r.debugServer.SetPort(r.pref.GetInt(preferences.DebugPortKey))
+}
+
+// applyLogLevel sets the log format and levels from preferences. The general
+// level is kept when it was set by --log-level flag which always takes
+// precedence; levels of subsystems are applied in any case.
+func applyLogLevel(pref *config.Preferences, byFlag bool) {
+	level := config.GetLogLevel()
+	if !byFlag {
+		level = parseLogLevel(pref.Get(preferences.LogLevelKey), logrus.InfoLevel)
+	}
+
+	subsystems := map[string]logrus.Level{}
+	for _, subsystem := range config.GetLogSubsystems() {
+		if name := pref.Get(preferences.LogLevelSubsystemKey(subsystem)); name != "" {
+			subsystems[subsystem] = parseLogLevel(name, level)
+		}
+	}
+
+	if level != config.GetLogLevel() {
+		log.WithField("level", level).Info("Changing log level")
+	}
+	config.SetLogLevels(level, subsystems)
+
+	if err := config.SetLogFormat(pref.Get(preferences.LogFormatKey)); err != nil {
+		log.WithError(err).Warn("Unknown log format")
+	}
+}
+
+// parseLogLevel returns the level by its name or the fallback when the name
+// is empty or unknown.
+func parseLogLevel(name string, fallback logrus.Level) logrus.Level {
+	if name == "" {
+		return fallback
+	}
+
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		log.WithError(err).Warn("Unknown log level")
+		return fallback
+	}
+
+	return level
+}
+
+// getIMAPLimits returns connection limits of IMAP servers.
+func getIMAPLimits(pref *config.Preferences) imap.ConnectionLimits {
+	return imap.ConnectionLimits{
+		PerAccount:         pref.GetInt(preferences.IMAPMaxConnAccountKey),
+		PerClient:          pref.GetInt(preferences.IMAPMaxConnClientKey),
+		PerClientPerMinute: pref.GetInt(preferences.IMAPMaxConnRateKey),
+	}
+}
+
+// getIMAPTimeouts returns timeouts of IMAP servers.
+func getIMAPTimeouts(pref *config.Preferences) imap.Timeouts {
+	return imap.Timeouts{
+		AutoLogout:    time.Duration(pref.GetInt(preferences.IMAPAutoLogoutKey)) * time.Minute,
+		IdleKeepalive: time.Duration(pref.GetInt(preferences.IMAPIdleKeepaliveKey)) * time.Second,
+		Literal:       time.Duration(pref.GetInt(preferences.IMAPLiteralTimeoutKey)) * time.Second,
+	}
+}
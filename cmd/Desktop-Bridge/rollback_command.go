@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/ProtonMail/proton-bridge/internal/updates"
+	"github.com/urfave/cli"
+)
+
+// rollbackCommand installs the version used before the last update again,
+// e.g. when the update broke something.
+func rollbackCommand() cli.Command {
+	return cli.Command{
+		Name:   "rollback",
+		Usage:  "Install the version used before the last update again (Bridge must not be running)",
+		Action: runRollback,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only print the version to roll back to",
+			},
+		},
+	}
+}
+
+func runRollback(context *cli.Context) error {
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	u := updates.NewBridge(cfg.GetUpdateDir())
+
+	if context.Bool("dry-run") {
+		version := u.GetRollbackVersion()
+		if version == "" {
+			return cli.NewExitError(updates.ErrNoRollback.Error(), 1)
+		}
+		fmt.Println("Previous version:", version)
+		return nil
+	}
+
+	version, err := u.Rollback()
+	if err != nil {
+		return cli.NewExitError("Cannot roll back: "+err.Error(), 1)
+	}
+
+	if runtime.GOOS == "windows" {
+		fmt.Println("Installer of version", version, "started.")
+	} else {
+		fmt.Println("Rolled back to version", version+", start Bridge again.")
+	}
+	return nil
+}
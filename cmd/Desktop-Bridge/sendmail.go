@@ -0,0 +1,138 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/sendmail"
+	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// sendmailCommand reads the message from stdin and sends it through SMTP
+// server of running Bridge, e.g. `bridge sendmail -t < message.eml`.
+func sendmailCommand() cli.Command {
+	return cli.Command{
+		Name:      "sendmail",
+		Usage:     "Send message from standard input through running Bridge",
+		ArgsUsage: "[recipient ...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "f",
+				Usage: "Sender address (From header is used by default)"},
+			cli.BoolFlag{
+				Name:  "t",
+				Usage: "Send also to recipients of To, Cc and Bcc headers"},
+			cli.StringFlag{
+				Name:  "user",
+				Usage: "Address of the account to log in (sender address by default)"},
+			cli.BoolFlag{
+				Name:  "i, oi",
+				Usage: "Do not treat a line with a single dot as the end of input (always on)"},
+		},
+		Action: runSendmail,
+	}
+}
+
+func runSendmail(context *cli.Context) error {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	pref := preferences.New(cfg)
+
+	literal, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return cli.NewExitError("Cannot read message: "+err.Error(), 1)
+	}
+
+	from := context.String("f")
+	if from == "" {
+		if from, err = sendmail.HeaderSender(literal); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	username := context.String("user")
+	if username == "" {
+		username = from
+	}
+
+	password, err := getBridgePassword(pref, username)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	err = sendmail.Submit(sendmail.Options{
+		Listener:          newListenerConfig(pref, preferences.SMTPPortKey, preferences.SMTPSocketKey),
+		UseSSL:            pref.GetBool(preferences.SMTPSSLKey),
+		Username:          username,
+		Password:          password,
+		From:              from,
+		To:                context.Args(),
+		ExtractRecipients: context.Bool("t"),
+	}, literal)
+	if err != nil {
+		return cli.NewExitError("Cannot send message: "+err.Error(), 1)
+	}
+
+	return nil
+}
+
+// getBridgePassword returns bridge password of the connected account with
+// the given address or username.
+func getBridgePassword(pref *config.Preferences, username string) (string, error) {
+	store, err := credentials.NewStore(config.WithProfile(appName), pref.Get(preferences.KeychainBackendKey))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot open credentials store")
+	}
+
+	userIDs, err := store.List()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot list accounts")
+	}
+
+	for _, userID := range userIDs {
+		creds, err := store.Get(userID)
+		if err != nil {
+			continue
+		}
+		if !strings.EqualFold(creds.Name, username) && !containsFold(creds.EmailList(), username) {
+			continue
+		}
+		if !creds.IsConnected() {
+			return "", errors.New("account " + username + " is logged out")
+		}
+		return creds.BridgePassword, nil
+	}
+
+	return "", errors.New("no account with address " + username)
+}
+
+func containsFold(list []string, item string) bool {
+	for _, value := range list {
+		if strings.EqualFold(value, item) {
+			return true
+		}
+	}
+	return false
+}
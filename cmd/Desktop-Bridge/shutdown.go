@@ -0,0 +1,79 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+)
+
+// drainTimeout bounds how long quit waits for commands of IMAP clients and
+// messages being sent.
+const drainTimeout = 10 * time.Second
+
+// drainer stops taking new work and waits for the work in progress until
+// the deadline.
+type drainer interface {
+	Drain(deadline time.Time)
+}
+
+// gracefulShutdown lets clients finish what they are doing when Bridge quits or
+// restarts after upgrade instead of cutting them off.
+type gracefulShutdown struct {
+	lock    sync.Mutex
+	servers []drainer
+	queue   drainer
+	bridge  *bridge.Bridge
+}
+
+func (s *gracefulShutdown) addServer(server drainer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.servers = append(s.servers, server)
+}
+
+// run drains all servers in parallel so the whole shutdown takes at most
+// drainTimeout, then sends due queued messages in the time left and closes
+// stores of all users.
+func (s *gracefulShutdown) run() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	log.Info("Draining connections before shutdown")
+	deadline := time.Now().Add(drainTimeout)
+
+	var wg sync.WaitGroup
+	for _, server := range s.servers {
+		wg.Add(1)
+		go func(server drainer) {
+			defer wg.Done()
+			server.Drain(deadline)
+		}(server)
+	}
+	wg.Wait()
+
+	if s.queue != nil {
+		s.queue.Drain(deadline)
+	}
+
+	if err := s.bridge.CloseStores(); err != nil {
+		log.WithError(err).Error("Cannot close stores")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/urfave/cli"
+)
+
+// snapshotStoreCommand writes the state of mailboxes of the account to a file
+// or loads it back, e.g. `bridge snapshot-store --sanitize user@pm.me state.json`.
+func snapshotStoreCommand() cli.Command {
+	return cli.Command{
+		Name:      "snapshot-store",
+		Usage:     "Save state of mailboxes of the account to a file or load it from one (Bridge must not be running)",
+		ArgsUsage: "account file",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "sanitize",
+				Usage: "Replace subjects, addresses, label names and other personal data"},
+			cli.BoolFlag{
+				Name:  "load",
+				Usage: "Replace mailboxes of the account by the state from the file"},
+		},
+		Action: runSnapshotStore,
+	}
+}
+
+func runSnapshotStore(context *cli.Context) error {
+	account, file := context.Args().Get(0), context.Args().Get(1)
+	if account == "" || file == "" {
+		return cli.NewExitError("Account and file are required", 1)
+	}
+
+	cfg, unlock, err := lockBridgeData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	storePath, err := getStorePath(cfg.GetDBDir(), account)
+	if err != nil {
+		return err
+	}
+
+	if context.Bool("load") {
+		f, err := os.Open(file) //nolint[gosec]
+		if err != nil {
+			return cli.NewExitError("Cannot open snapshot: "+err.Error(), 1)
+		}
+		defer f.Close() //nolint[errcheck]
+
+		snapshot := &store.MailboxSnapshot{}
+		if err := json.NewDecoder(f).Decode(snapshot); err != nil {
+			return cli.NewExitError("Cannot read snapshot: "+err.Error(), 1)
+		}
+		if err := store.LoadMailboxSnapshot(storePath, snapshot); err != nil {
+			return cli.NewExitError("Cannot load snapshot: "+err.Error(), 1)
+		}
+		return nil
+	}
+
+	snapshot, err := store.SnapshotMailboxes(storePath, context.Bool("sanitize"))
+	if err != nil {
+		return cli.NewExitError("Cannot take snapshot: "+err.Error(), 1)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return cli.NewExitError("Cannot write snapshot: "+err.Error(), 1)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/proton-bridge/internal/api"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/urfave/cli"
+)
+
+// Exit codes of `bridge status --exit-code`.
+const (
+	statusUnhealthy  = 1
+	statusNotRunning = 2
+)
+
+// statusCommand shows the health of the running instance, e.g.
+// `bridge status --exit-code` as a check of monitoring.
+func statusCommand() cli.Command {
+	return cli.Command{
+		Name:   "status",
+		Usage:  "Show health of the running Bridge",
+		Action: runStatus,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "exit-code",
+				Usage: fmt.Sprintf("Exit with %d when unhealthy and with %d when not running", statusUnhealthy, statusNotRunning),
+			},
+			cli.BoolFlag{
+				Name:  "live",
+				Usage: "Check only listeners, not connection to the server and accounts",
+			},
+		},
+	}
+}
+
+func runStatus(context *cli.Context) error {
+	cfg := config.New(appName, constants.Version, constants.Revision, cacheVersion)
+	pref := preferences.New(cfg)
+
+	exitCode := func(code int) int {
+		if context.Bool("exit-code") {
+			return code
+		}
+		return 0
+	}
+
+	// Running Bridge has the certificate already, it is not generated here.
+	if _, err := os.Stat(cfg.GetTLSCertPath()); err != nil {
+		fmt.Println("Bridge is not running:", err)
+		return cli.NewExitError("", exitCode(statusNotRunning))
+	}
+	tls, err := config.GetTLSConfig(cfg)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// Without the token accounts are checked only together.
+	token, err := api.LoadToken(cfg.GetAPITokenPath())
+	if err != nil {
+		log.WithError(err).Warn("Cannot load API token")
+	}
+
+	health, err := api.GetHealth(pref.GetInt(preferences.APIPortKey), tls, token, context.Bool("live"))
+	if err != nil {
+		fmt.Println("Bridge is not running:", err)
+		return cli.NewExitError("", exitCode(statusNotRunning))
+	}
+
+	for _, check := range health.Checks {
+		if check.Healthy {
+			fmt.Printf("ok      %s\n", check.Name)
+		} else {
+			fmt.Printf("failed  %s: %s\n", check.Name, check.Error)
+		}
+	}
+
+	// Exceptions are not a failure but the user should not forget them.
+	if exceptions, err := config.LoadTLSExceptions(cfg.GetTLSExceptionsPath()); err == nil && len(exceptions) > 0 {
+		fmt.Printf("warning TLS exceptions trust %d imported CA(s) instead of pinned keys, see `%s tls-exceptions`\n", len(exceptions), appName)
+	}
+
+	if !health.Healthy {
+		return cli.NewExitError("", exitCode(statusUnhealthy))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// tlsExceptionWarning is printed before an exception is imported.
+const tlsExceptionWarning = `WARNING: Bridge will trust this CA for the hosts instead of the keys of Proton
+servers built into Bridge. Whoever has the private key of the CA, e.g. the
+TLS-inspecting proxy of your network, can read and change all traffic between
+Bridge and Proton, including your session. Import only the CA of a network
+you trust, and remove the exception when you leave the network.`
+
+// tlsExceptionsCommand manages CAs trusted for API connections behind
+// TLS-inspecting proxies, e.g.
+// `bridge tls-exceptions import --host api.protonmail.ch corporate-ca.pem`.
+func tlsExceptionsCommand() cli.Command {
+	return cli.Command{
+		Name:   "tls-exceptions",
+		Usage:  "Manage CAs of TLS-inspecting proxies trusted for API connections (applied after restart)",
+		Action: runTLSExceptionsList,
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List imported CAs and their hosts",
+				Action: runTLSExceptionsList,
+			},
+			{
+				Name:      "import",
+				Usage:     "Trust the root CA in PEM for the hosts instead of pinned keys",
+				ArgsUsage: "<ca.pem>",
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "host",
+						Usage: "Host the CA is trusted for, `*.example.com` for subdomains (default: " + pmapi.GetAPIHost() + ")",
+					},
+					cli.BoolFlag{
+						Name:  "yes",
+						Usage: "Do not ask for confirmation",
+					},
+				},
+				Action: runTLSExceptionsImport,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove the exception by fingerprint or its prefix",
+				ArgsUsage: "<fingerprint>",
+				Action:    runTLSExceptionsRemove,
+			},
+		},
+	}
+}
+
+func getTLSExceptionsPath() string {
+	return config.New(appName, constants.Version, constants.Revision, cacheVersion).GetTLSExceptionsPath()
+}
+
+func runTLSExceptionsList(context *cli.Context) error {
+	exceptions, err := config.LoadTLSExceptions(getTLSExceptionsPath())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(exceptions) == 0 {
+		fmt.Println("No TLS exceptions, all API connections are checked by pinned keys.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FINGERPRINT\tSUBJECT\tHOSTS\tADDED")
+	for _, exception := range exceptions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			shortFingerprint(exception.Fingerprint),
+			exception.Subject,
+			strings.Join(exception.Hosts, ", "),
+			exception.Added.Format("2006-01-02"),
+		)
+	}
+	return w.Flush()
+}
+
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 16 {
+		return fingerprint[:16]
+	}
+	return fingerprint
+}
+
+func runTLSExceptionsImport(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: path of the CA certificate", 1)
+	}
+
+	certPEM, err := ioutil.ReadFile(context.Args().First())
+	if err != nil {
+		return cli.NewExitError("Cannot read certificate: "+err.Error(), 1)
+	}
+
+	hosts := context.StringSlice("host")
+	if len(hosts) == 0 {
+		hosts = []string{pmapi.GetAPIHost()}
+	}
+
+	exception, err := config.NewTLSException(certPEM, hosts)
+	if err != nil {
+		return cli.NewExitError("Cannot import certificate: "+err.Error(), 1)
+	}
+
+	fmt.Println("Subject:    ", exception.Subject)
+	fmt.Println("Fingerprint:", exception.Fingerprint)
+	fmt.Println("Hosts:      ", strings.Join(exception.Hosts, ", "))
+	fmt.Println()
+	fmt.Println(tlsExceptionWarning)
+	fmt.Println()
+
+	if !context.Bool("yes") {
+		if err := confirmTLSException(); err != nil {
+			return err
+		}
+	}
+
+	path := getTLSExceptionsPath()
+	exceptions, err := config.LoadTLSExceptions(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// Importing the same CA again replaces its hosts.
+	replaced := false
+	for i, existing := range exceptions {
+		if existing.Fingerprint == exception.Fingerprint {
+			exceptions[i] = exception
+			replaced = true
+		}
+	}
+	if !replaced {
+		exceptions = append(exceptions, exception)
+	}
+
+	if err := config.SaveTLSExceptions(path, exceptions); err != nil {
+		return cli.NewExitError("Cannot save TLS exceptions: "+err.Error(), 1)
+	}
+
+	fmt.Println("TLS exception added, restart Bridge to apply it.")
+	return nil
+}
+
+func confirmTLSException() error {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return cli.NewExitError("Cannot ask for confirmation, use --yes", 1)
+	}
+
+	fmt.Print("Type yes to trust the CA: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return cli.NewExitError("Cannot read answer: "+err.Error(), 1)
+	}
+	if strings.TrimSpace(strings.ToLower(answer)) != "yes" {
+		return cli.NewExitError("Import cancelled", 1)
+	}
+	return nil
+}
+
+func runTLSExceptionsRemove(context *cli.Context) error {
+	if context.NArg() != 1 {
+		return cli.NewExitError("Expected one argument: fingerprint of the exception", 1)
+	}
+	prefix := strings.ToLower(context.Args().First())
+
+	path := getTLSExceptionsPath()
+	exceptions, err := config.LoadTLSExceptions(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	var kept []*config.TLSException
+	var removed *config.TLSException
+	for _, exception := range exceptions {
+		if !strings.HasPrefix(exception.Fingerprint, prefix) {
+			kept = append(kept, exception)
+			continue
+		}
+		if removed != nil {
+			return cli.NewExitError("Fingerprint "+prefix+" is ambiguous, use more of it", 1)
+		}
+		removed = exception
+	}
+	if removed == nil {
+		return cli.NewExitError("No TLS exception with fingerprint "+prefix, 1)
+	}
+
+	if err := config.SaveTLSExceptions(path, kept); err != nil {
+		return cli.NewExitError("Cannot save TLS exceptions: "+err.Error(), 1)
+	}
+
+	fmt.Printf("TLS exception of %s removed, restart Bridge to apply it.\n", removed.Subject)
+	return nil
+}
@@ -55,6 +55,7 @@ func main() {
 		"ProtonMail Import-Export",
 		"ProtonMail Import-Export app",
 		nil,
+		nil,
 		run,
 	)
 }
@@ -126,7 +127,13 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	eventListener := listener.New()
 	events.SetupEvents(eventListener)
 
-	credentialsStore, credentialsError := credentials.NewStore(appNameDash)
+	pref := preferences.New(cfg)
+	cmd.SetupCrashReports(cfg, pref)
+	cmd.SetupProxy(pref)
+	cmd.SetupTelemetry(pref)
+	config.SetLogRetention(preferences.GetLogRetention(pref))
+
+	credentialsStore, credentialsError := credentials.NewStore(config.WithProfile(appNameDash), pref.Get(preferences.KeychainBackendKey))
 	if credentialsError != nil {
 		log.Error("Could not get credentials store: ", credentialsError)
 	}
@@ -138,8 +145,6 @@ func run(context *cli.Context) (contextError error) { // nolint[funlen]
 	// implementation depending on whether build flag pmapi_prod is used or not.
 	cm.SetRoundTripper(cfg.GetRoundTripper(cm, eventListener))
 
-	pref := preferences.New(cfg)
-
 	// Cookies must be persisted across restarts.
 	jar, err := cookies.NewCookieJar(pref)
 	if err != nil {
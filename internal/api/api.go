@@ -19,12 +19,23 @@
 //
 // API endpoints:
 //  * /focus, see focusHandler
+//  * /status, see statusHandler
+//  * /v1/accounts, see accountsHandler
+//  * /v1/accounts/<account>/<action>, see accountActionHandler
+//  * /v1/connections, see connectionsHandler
+//  * /v1/network-profile, see networkProfileHandler
+//  * /v1/network-profile/<name>, see networkProfileActionHandler
+//  * /healthz and /healthz/live, see healthWrapper
+//
+// Endpoints under /v1 and /status need the token from LoadToken in
+// Authorization header.
 package api
 
 import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/events"
@@ -46,10 +57,19 @@ type apiServer struct {
 	certPath      string
 	keyPath       string
 	eventListener listener.Listener
+	users         usersProvider
+	token         string
+
+	lock        sync.RWMutex
+	connections []connectionsProvider
+	listeners   []namedListener
+
+	connectivity connectivityCache
 }
 
-// NewAPIServer returns prepared API server struct.
-func NewAPIServer(pref *config.Preferences, tls *tls.Config, certPath, keyPath string, eventListener listener.Listener) *apiServer { //nolint[golint]
+// NewAPIServer returns prepared API server struct. Control endpoints are
+// disabled when the token is empty.
+func NewAPIServer(pref *config.Preferences, tls *tls.Config, certPath, keyPath string, eventListener listener.Listener, users usersProvider, token string) *apiServer { //nolint[golint]
 	return &apiServer{
 		host:          bridge.Host,
 		pref:          pref,
@@ -57,18 +77,49 @@ func NewAPIServer(pref *config.Preferences, tls *tls.Config, certPath, keyPath s
 		certPath:      certPath,
 		keyPath:       keyPath,
 		eventListener: eventListener,
+		users:         users,
+		token:         token,
 	}
 }
 
+// AddConnections adds a server whose connections are reported by the API.
+// Servers can be added while the API is running.
+func (api *apiServer) AddConnections(provider connectionsProvider) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.connections = append(api.connections, provider)
+}
+
+func (api *apiServer) getConnectionsProviders() []connectionsProvider {
+	api.lock.RLock()
+	defer api.lock.RUnlock()
+
+	return append([]connectionsProvider{}, api.connections...)
+}
+
+// AddListener adds a server whose state is checked by the health endpoint,
+// e.g. "imap 127.0.0.1:1143". Servers can be added while the API is running.
+func (api *apiServer) AddListener(name string, l listeningChecker) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.listeners = append(api.listeners, namedListener{name: name, listener: l})
+}
+
+func (api *apiServer) getListeners() []namedListener {
+	api.lock.RLock()
+	defer api.lock.RUnlock()
+
+	return append([]namedListener{}, api.listeners...)
+}
+
 // Starts the server.
 func (api *apiServer) ListenAndServe() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/focus", wrapper(api, focusHandler))
-
 	addr := api.getAddress()
 	server := &http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      api.newMux(),
 		TLSConfig:    api.tls,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
 	}
@@ -81,6 +132,22 @@ func (api *apiServer) ListenAndServe() {
 	defer server.Close() //nolint[errcheck]
 }
 
+// newMux returns the handler of all endpoints. Status lists usernames of
+// accounts, so it needs the token as the control endpoints do.
+func (api *apiServer) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/focus", wrapper(api, focusHandler))
+	mux.HandleFunc("/status", controlWrapper(api, statusHandler, http.MethodGet))
+	mux.HandleFunc("/v1/accounts", controlWrapper(api, accountsHandler, http.MethodGet))
+	mux.HandleFunc("/v1/accounts/", controlWrapper(api, accountActionHandler, http.MethodPost))
+	mux.HandleFunc("/v1/connections", controlWrapper(api, connectionsHandler, http.MethodGet))
+	mux.HandleFunc("/v1/network-profile", controlWrapper(api, networkProfileHandler, http.MethodGet))
+	mux.HandleFunc("/v1/network-profile/", controlWrapper(api, networkProfileActionHandler, http.MethodPost))
+	mux.HandleFunc("/healthz", healthWrapper(api, false))
+	mux.HandleFunc("/healthz/live", healthWrapper(api, true))
+	return mux
+}
+
 func (api *apiServer) getAddress() string {
 	port := api.pref.GetInt(preferences.APIPortKey)
 	newPort := ports.FindFreePortFrom(port)
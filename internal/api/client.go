@@ -0,0 +1,96 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/pkg/errors"
+)
+
+// controlTimeout is how long commands wait for the running instance.
+// Logout waits for the stores of the account to be closed.
+const controlTimeout = 30 * time.Second
+
+// GetAccounts asks the running instance for its accounts, see accountsHandler.
+func GetAccounts(port int, tls *tls.Config, token string) ([]AccountStatus, error) {
+	return getAccounts(getAPIAddress(bridge.Host, port), tls, token)
+}
+
+func getAccounts(address string, tls *tls.Config, token string) ([]AccountStatus, error) {
+	accounts := []AccountStatus{}
+	if err := doControlRequest(address, tls, token, http.MethodGet, "/v1/accounts", &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// ChangeAccount runs the action (pause, resume or logout) on the account of
+// the running instance, see accountActionHandler.
+func ChangeAccount(port int, tls *tls.Config, token, account, action string) (*AccountStatus, error) {
+	return changeAccount(getAPIAddress(bridge.Host, port), tls, token, account, action)
+}
+
+func changeAccount(address string, tls *tls.Config, token, account, action string) (*AccountStatus, error) {
+	path := "/v1/accounts/" + url.PathEscape(account) + "/" + url.PathEscape(action)
+	status := &AccountStatus{}
+	if err := doControlRequest(address, tls, token, http.MethodPost, path, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// doControlRequest calls the control endpoint and decodes JSON response to
+// out. Errors of handlers are plain text, they are returned as they are.
+func doControlRequest(address string, tls *tls.Config, token, method, path string, out interface{}) error {
+	transport := &http.Transport{TLSClientConfig: tls}
+	client := &http.Client{Transport: transport, Timeout: controlTimeout}
+
+	req, err := http.NewRequest(method, "https://"+address+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint[errcheck]
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		if text := strings.TrimSpace(string(msg)); text != "" {
+			return errors.New(text)
+		}
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "failed to read response")
+	}
+	return nil
+}
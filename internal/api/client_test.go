@@ -0,0 +1,51 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlClient(t *testing.T) {
+	api := &apiServer{token: "secret", users: &fakeUsers{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/accounts", controlWrapper(api, accountsHandler, http.MethodGet))
+	mux.HandleFunc("/v1/accounts/", controlWrapper(api, accountActionHandler, http.MethodPost))
+
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	tls := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	accounts, err := getAccounts(serverURL.Host, tls, "secret")
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+
+	_, err = getAccounts(serverURL.Host, tls, "wrong")
+	require.EqualError(t, err, "missing or wrong API token")
+
+	_, err = changeAccount(serverURL.Host, tls, "secret", "user@pm.me", "pause")
+	require.EqualError(t, err, "unknown account")
+}
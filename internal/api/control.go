@@ -0,0 +1,147 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+)
+
+// connectionsProvider is a server reporting connected clients.
+type connectionsProvider interface {
+	Connections() []imap.Connection
+}
+
+// AccountStatus is the state of the account returned by control endpoints.
+type AccountStatus struct {
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Addresses       []string   `json:"addresses"`
+	Connected       bool       `json:"connected"`
+	Paused          bool       `json:"paused"`
+	IMAPConnections int        `json:"imapConnections"`
+	Sync            SyncStatus `json:"sync"`
+}
+
+type connectionStatus struct {
+	Protocol string `json:"protocol"`
+	UserID   string `json:"userId,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Remote   string `json:"remote,omitempty"`
+	Client   string `json:"client,omitempty"`
+	Mailbox  string `json:"mailbox,omitempty"`
+}
+
+// accountsHandler returns JSON list of accounts with their state, sync
+// progress and number of connected IMAP clients.
+func accountsHandler(ctx handlerContext) error {
+	counts := map[string]int{}
+	for _, conn := range getConnections(ctx) {
+		counts[conn.UserID]++
+	}
+
+	status := []AccountStatus{}
+	for _, user := range ctx.users.GetUsers() {
+		status = append(status, getAccountStatus(user, counts[user.ID()]))
+	}
+	return writeJSON(ctx, status)
+}
+
+// accountActionHandler handles POST /v1/accounts/<account>/<action> where
+// account is user ID, username or address and action is one of:
+//  * pause stops polling of changes of the account,
+//  * resume starts polling again,
+//  * logout logs the account out as from the GUI.
+// It returns the status of the account after the action.
+func accountActionHandler(ctx handlerContext) error {
+	parts := strings.Split(strings.TrimPrefix(ctx.req.URL.Path, "/v1/accounts/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return &statusError{http.StatusNotFound, "expected /v1/accounts/<account>/<action>"}
+	}
+
+	user, err := ctx.users.GetUser(parts[0])
+	if err != nil {
+		return &statusError{http.StatusNotFound, "unknown account"}
+	}
+
+	switch parts[1] {
+	case "pause":
+		err = user.SetPaused(true)
+	case "resume":
+		err = user.SetPaused(false)
+	case "logout":
+		err = user.Logout()
+	default:
+		return &statusError{http.StatusNotFound, "unknown action " + parts[1]}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithField("user", user.ID()).WithField("action", parts[1]).Info("Account changed by API")
+
+	count := 0
+	for _, conn := range getConnections(ctx) {
+		if conn.UserID == user.ID() {
+			count++
+		}
+	}
+	return writeJSON(ctx, getAccountStatus(user, count))
+}
+
+// connectionsHandler returns JSON list of connected clients.
+func connectionsHandler(ctx handlerContext) error {
+	return writeJSON(ctx, getConnections(ctx))
+}
+
+func getAccountStatus(user *users.User, imapConnections int) AccountStatus {
+	addresses := user.GetAddresses()
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	return AccountStatus{
+		ID:              user.ID(),
+		Username:        user.Username(),
+		Addresses:       addresses,
+		Connected:       user.IsConnected(),
+		Paused:          user.IsPaused(),
+		IMAPConnections: imapConnections,
+		Sync:            getSyncStatus(user),
+	}
+}
+
+func getConnections(ctx handlerContext) []connectionStatus {
+	status := []connectionStatus{}
+	for _, provider := range ctx.connections {
+		for _, conn := range provider.Connections() {
+			status = append(status, connectionStatus{
+				Protocol: "imap",
+				UserID:   conn.UserID,
+				Address:  conn.Address,
+				Remote:   conn.RemoteAddr,
+				Client:   conn.Client,
+				Mailbox:  conn.Mailbox,
+			})
+		}
+	}
+	return status
+}
@@ -19,6 +19,7 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 )
@@ -33,6 +34,18 @@ type handlerContext struct {
 	req           *http.Request
 	resp          http.ResponseWriter
 	eventListener listener.Listener
+	users         usersProvider
+	connections   []connectionsProvider
+}
+
+// statusError is returned by handlers to respond with other status than 500.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (err *statusError) Error() string {
+	return err.msg
 }
 
 func wrapper(api *apiServer, callback handler) httpHandler {
@@ -41,11 +54,37 @@ func wrapper(api *apiServer, callback handler) httpHandler {
 			req:           req,
 			resp:          w,
 			eventListener: api.eventListener,
+			users:         api.users,
+			connections:   api.getConnectionsProviders(),
 		}
 		err := callback(ctx)
+		if statusErr, ok := err.(*statusError); ok {
+			http.Error(w, statusErr.msg, statusErr.status)
+			return
+		}
 		if err != nil {
 			log.Error("API callback of ", req.URL, " failed: ", err)
 			http.Error(w, err.Error(), 500)
 		}
 	}
 }
+
+// controlWrapper allows only requests with the API token and given methods.
+func controlWrapper(api *apiServer, callback handler, methods ...string) httpHandler {
+	wrapped := wrapper(api, callback)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !hasToken(req, api.token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong API token", http.StatusUnauthorized)
+			return
+		}
+		for _, method := range methods {
+			if req.Method == method {
+				wrapped(w, req)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -20,26 +20,38 @@ package api
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/pkg/errors"
 )
 
+// focusResponse is the answer of the running instance. It is checked by the
+// new instance, so other service listening on the port is not taken for it.
+const focusResponse = "OK"
+
+// focusTimeout is how long the new instance waits for the running one.
+const focusTimeout = 10 * time.Second
+
 // focusHandler should be called from other instances (attempt to start bridge
 // for the second time) to get focus in the currently running instance.
 func focusHandler(ctx handlerContext) error {
 	log.Info("Focus from other instance")
 	ctx.eventListener.Emit(events.SecondInstanceEvent, "")
-	fmt.Fprintf(ctx.resp, "OK")
+	fmt.Fprint(ctx.resp, focusResponse)
 	return nil
 }
 
 // CheckOtherInstanceAndFocus is helper for new instances to check if there is
-// already a running instance and get it's focus.
+// already a running instance and get it's focus. It returns error when the
+// running instance does not respond.
 func CheckOtherInstanceAndFocus(port int, tls *tls.Config) error {
 	transport := &http.Transport{TLSClientConfig: tls}
-	client := &http.Client{Transport: transport}
+	client := &http.Client{Transport: transport, Timeout: focusTimeout}
 
 	addr := getAPIAddress(bridge.Host, port)
 	resp, err := client.Get("https://" + addr + "/focus")
@@ -48,8 +60,16 @@ func CheckOtherInstanceAndFocus(port int, tls *tls.Config) error {
 	}
 	defer resp.Body.Close() //nolint[errcheck]
 
-	if resp.StatusCode != 200 {
-		log.Error("Focus error: ", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("focus failed with status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(len(focusResponse)+1)))
+	if err != nil {
+		return errors.Wrap(err, "failed to read focus response")
+	}
+	if string(body) != focusResponse {
+		return errors.New("unexpected focus response")
 	}
 	return nil
 }
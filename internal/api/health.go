@@ -0,0 +1,187 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/pkg/errors"
+)
+
+// connectivityTTL is how long the check of connection to the server is
+// reused, so frequent probes do not flood it.
+const connectivityTTL = 30 * time.Second
+
+// healthTimeout is how long GetHealth waits for the running instance.
+const healthTimeout = 10 * time.Second
+
+// listeningChecker is a server reporting whether it accepts connections.
+type listeningChecker interface {
+	IsListening() bool
+}
+
+type namedListener struct {
+	name     string
+	listener listeningChecker
+}
+
+type connectivityCache struct {
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (c *connectivityCache) check(checkConnection func() error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if time.Since(c.checked) > connectivityTTL {
+		c.err = checkConnection()
+		c.checked = time.Now()
+	}
+	return c.err
+}
+
+// Health is the response of the health endpoint.
+type Health struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// HealthCheck is the result of one check, e.g. of one listener.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (h *Health) add(name string, err error) {
+	check := HealthCheck{Name: name, Healthy: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+		h.Healthy = false
+	}
+	h.Checks = append(h.Checks, check)
+}
+
+// healthWrapper returns the handler of /healthz with all checks for
+// readiness and monitoring or of /healthz/live checking only listeners, so
+// that Bridge is not restarted when the server is not reachable. It responds
+// with 503 when any check fails. No token is needed, it is meant for probes
+// of service managers and container orchestrators. Accounts are checked one
+// by one, with their usernames, only for requests with the API token.
+func healthWrapper(api *apiServer, live bool) httpHandler {
+	return func(w http.ResponseWriter, req *http.Request) {
+		health := api.getHealth(live, hasToken(req, api.token))
+
+		w.Header().Set("Content-Type", "application/json")
+		if !health.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(health); err != nil {
+			log.WithError(err).Warn("Cannot write health")
+		}
+	}
+}
+
+func (api *apiServer) getHealth(live, perAccount bool) *Health {
+	health := &Health{Healthy: true, Checks: []HealthCheck{}}
+
+	for _, l := range api.getListeners() {
+		var err error
+		if !l.listener.IsListening() {
+			err = errors.New("not listening")
+		}
+		health.add(l.name, err)
+	}
+
+	if live {
+		return health
+	}
+
+	health.add("server connection", api.connectivity.check(api.users.CheckConnection))
+
+	users := api.users.GetUsers()
+	if perAccount {
+		for _, user := range users {
+			var err error
+			if !user.IsConnected() {
+				err = errors.New("logged out, log in again")
+			}
+			health.add("account "+user.Username(), err)
+		}
+		return health
+	}
+
+	loggedOut := 0
+	for _, user := range users {
+		if !user.IsConnected() {
+			loggedOut++
+		}
+	}
+	var err error
+	if loggedOut > 0 {
+		err = fmt.Errorf("%d of %d accounts logged out, log in again", loggedOut, len(users))
+	}
+	health.add("accounts", err)
+
+	return health
+}
+
+// GetHealth asks the running instance for its health. Failed checks are not
+// an error, see Health.Healthy. Error means the instance does not respond.
+// With the API token accounts are checked one by one.
+func GetHealth(port int, tls *tls.Config, token string, live bool) (*Health, error) {
+	transport := &http.Transport{TLSClientConfig: tls}
+	client := &http.Client{Transport: transport, Timeout: healthTimeout}
+
+	path := "/healthz"
+	if live {
+		path += "/live"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://"+getAPIAddress(bridge.Host, port)+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint[errcheck]
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("health failed with status %d", resp.StatusCode)
+	}
+
+	health := &Health{}
+	if err := json.NewDecoder(resp.Body).Decode(health); err != nil {
+		return nil, errors.Wrap(err, "failed to read health")
+	}
+	return health, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeListener bool
+
+func (l fakeListener) IsListening() bool { return bool(l) }
+
+type fakeUsers struct {
+	connectionErr  error
+	checked        int
+	networkProfile string
+}
+
+func (u *fakeUsers) GetUsers() []*users.User { return nil }
+
+func (u *fakeUsers) GetUser(query string) (*users.User, error) { return nil, errors.New("no user") }
+
+func (u *fakeUsers) CheckConnection() error {
+	u.checked++
+	return u.connectionErr
+}
+
+func (u *fakeUsers) GetNetworkProfile() preferences.NetworkProfile {
+	profile, _ := preferences.FindNetworkProfile(u.networkProfile)
+	return profile
+}
+
+func (u *fakeUsers) SetNetworkProfile(name string) error {
+	u.networkProfile = name
+	return nil
+}
+
+func getTestHealth(t *testing.T, api *apiServer, path string, live bool) (int, Health) {
+	return getTestHealthWithToken(t, api, path, live, "")
+}
+
+func getTestHealthWithToken(t *testing.T, api *apiServer, path string, live bool, token string) (int, Health) {
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	healthWrapper(api, live)(resp, req)
+
+	var health Health
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	return resp.Code, health
+}
+
+func TestHealth(t *testing.T) {
+	fake := &fakeUsers{}
+	api := &apiServer{users: fake}
+	api.AddListener("imap 127.0.0.1:1143", fakeListener(true))
+
+	code, health := getTestHealth(t, api, "/healthz", false)
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, health.Healthy)
+	require.Equal(t, []HealthCheck{
+		{Name: "imap 127.0.0.1:1143", Healthy: true},
+		{Name: "server connection", Healthy: true},
+		{Name: "accounts", Healthy: true},
+	}, health.Checks)
+
+	api.AddListener("smtp 127.0.0.1:1025", fakeListener(false))
+	code, health = getTestHealth(t, api, "/healthz/live", true)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, health.Healthy)
+	require.Equal(t, HealthCheck{Name: "smtp 127.0.0.1:1025", Error: "not listening"}, health.Checks[1])
+}
+
+func TestHealthLiveIgnoresConnection(t *testing.T) {
+	fake := &fakeUsers{connectionErr: errors.New("no internet")}
+	api := &apiServer{users: fake}
+	api.AddListener("imap 127.0.0.1:1143", fakeListener(true))
+
+	code, _ := getTestHealth(t, api, "/healthz/live", true)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 0, fake.checked)
+
+	code, health := getTestHealth(t, api, "/healthz", false)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "no internet", health.Checks[1].Error)
+
+	// Connection is checked once per connectivityTTL.
+	_, _ = getTestHealth(t, api, "/healthz", false)
+	require.Equal(t, 1, fake.checked)
+}
+
+func TestHealthAccountsNeedToken(t *testing.T) {
+	api := &apiServer{users: &fakeUsers{}, token: "secret"}
+
+	_, health := getTestHealthWithToken(t, api, "/healthz", false, "wrong")
+	require.Equal(t, []HealthCheck{
+		{Name: "server connection", Healthy: true},
+		{Name: "accounts", Healthy: true},
+	}, health.Checks)
+
+	// No account, so no check of account with token.
+	_, health = getTestHealthWithToken(t, api, "/healthz", false, "secret")
+	require.Equal(t, []HealthCheck{
+		{Name: "server connection", Healthy: true},
+	}, health.Checks)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+)
+
+type networkProfileStatus struct {
+	preferences.NetworkProfile
+	Available []string `json:"available"`
+}
+
+// networkProfileHandler returns JSON with the network profile in use and
+// names of all profiles.
+func networkProfileHandler(ctx handlerContext) error {
+	return writeNetworkProfile(ctx)
+}
+
+// networkProfileActionHandler handles POST /v1/network-profile/<name> which
+// switches the network profile and returns it as networkProfileHandler.
+func networkProfileActionHandler(ctx handlerContext) error {
+	name := strings.TrimPrefix(ctx.req.URL.Path, "/v1/network-profile/")
+	if _, ok := preferences.FindNetworkProfile(name); !ok {
+		return &statusError{http.StatusNotFound, "unknown network profile " + name}
+	}
+
+	if err := ctx.users.SetNetworkProfile(name); err != nil {
+		return err
+	}
+
+	log.WithField("profile", name).Info("Network profile changed by API")
+
+	return writeNetworkProfile(ctx)
+}
+
+func writeNetworkProfile(ctx handlerContext) error {
+	return writeJSON(ctx, networkProfileStatus{
+		NetworkProfile: ctx.users.GetNetworkProfile(),
+		Available:      preferences.GetNetworkProfileNames(),
+	})
+}
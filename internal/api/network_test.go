@@ -0,0 +1,67 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requestNetworkProfile(t *testing.T, api *apiServer, method, path string) (int, networkProfileStatus) {
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	handler := controlWrapper(api, networkProfileHandler, http.MethodGet)
+	if method == http.MethodPost {
+		handler = controlWrapper(api, networkProfileActionHandler, http.MethodPost)
+	}
+	handler(resp, req)
+
+	var status networkProfileStatus
+	if resp.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	}
+	return resp.Code, status
+}
+
+func TestNetworkProfile(t *testing.T) {
+	fake := &fakeUsers{networkProfile: "normal"}
+	api := &apiServer{users: fake, token: "token"}
+
+	code, status := requestNetworkProfile(t, api, http.MethodGet, "/v1/network-profile")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "normal", status.Name)
+	require.True(t, status.Prefetch)
+	require.Equal(t, []string{"normal", "metered", "low_bandwidth"}, status.Available)
+
+	code, status = requestNetworkProfile(t, api, http.MethodPost, "/v1/network-profile/metered")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "metered", fake.networkProfile)
+	require.Equal(t, "metered", status.Name)
+	require.Equal(t, int64(512), status.BandwidthKBps)
+	require.False(t, status.Prefetch)
+
+	code, _ = requestNetworkProfile(t, api, http.MethodPost, "/v1/network-profile/fast")
+	require.Equal(t, http.StatusNotFound, code)
+	require.Equal(t, "metered", fake.networkProfile)
+}
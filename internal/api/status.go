@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+)
+
+// usersProvider provides users of the bridge for the status.
+type usersProvider interface {
+	GetUsers() []*users.User
+	GetUser(query string) (*users.User, error)
+	CheckConnection() error
+	GetNetworkProfile() preferences.NetworkProfile
+	SetNetworkProfile(name string) error
+}
+
+type userStatus struct {
+	Username  string     `json:"username"`
+	Connected bool       `json:"connected"`
+	Sync      SyncStatus `json:"sync"`
+}
+
+// SyncStatus is the progress of synchronisation of the account.
+type SyncStatus struct {
+	Phase  string `json:"phase"`
+	Folder string `json:"folder,omitempty"`
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+	ETA    int64  `json:"eta"` // Estimated remaining seconds, zero when unknown.
+}
+
+// statusHandler returns JSON list of accounts with the progress of their
+// sync. Frontends should call it after receiving events.SyncProgressEvent.
+func statusHandler(ctx handlerContext) error {
+	status := []userStatus{}
+	for _, user := range ctx.users.GetUsers() {
+		status = append(status, userStatus{
+			Username:  user.Username(),
+			Connected: user.IsConnected(),
+			Sync:      getSyncStatus(user),
+		})
+	}
+
+	return writeJSON(ctx, status)
+}
+
+func getSyncStatus(user *users.User) SyncStatus {
+	progress := user.GetSyncProgress()
+	return SyncStatus{
+		Phase:  progress.Phase,
+		Folder: progress.Folder,
+		Done:   progress.Done,
+		Total:  progress.Total,
+		ETA:    int64(progress.ETA.Seconds()),
+	}
+}
+
+func writeJSON(ctx handlerContext, v interface{}) error {
+	ctx.resp.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(ctx.resp).Encode(v)
+}
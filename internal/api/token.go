@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const tokenBytes = 32
+
+// LoadToken returns the token protecting control endpoints stored in the file.
+// New random token is written when the file does not exist yet. Scripts read
+// the token from the same file, so it is readable only by the user.
+func LoadToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path) //nolint[gosec]
+	if err == nil {
+		if token := strings.TrimSpace(string(b)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", errors.Wrap(err, "cannot read API token")
+	}
+
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", errors.Wrap(err, "cannot generate API token")
+	}
+	token := hex.EncodeToString(raw)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", errors.Wrap(err, "cannot create API token folder")
+	}
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", errors.Wrap(err, "cannot write API token")
+	}
+	return token, nil
+}
+
+// hasToken checks the request is authorized by `Authorization: Bearer` header.
+func hasToken(req *http.Request, token string) bool {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") || token == "" {
+		return false
+	}
+	given := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
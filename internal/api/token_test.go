@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	path := filepath.Join(dir, "config", "api_token")
+	token, err := LoadToken(path)
+	require.NoError(t, err)
+	require.Len(t, token, 2*tokenBytes)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	again, err := LoadToken(path)
+	require.NoError(t, err)
+	require.Equal(t, token, again)
+}
+
+func TestControlWrapper(t *testing.T) {
+	api := &apiServer{token: "secret"}
+	handler := controlWrapper(api, func(ctx handlerContext) error {
+		return writeJSON(ctx, "ok")
+	}, http.MethodPost)
+
+	for _, tc := range []struct {
+		method, auth string
+		status       int
+	}{
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "secret", http.StatusUnauthorized},
+		{http.MethodGet, "Bearer secret", http.StatusMethodNotAllowed},
+		{http.MethodPost, "Bearer secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, "/v1/accounts/user/pause", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		require.Equal(t, tc.status, resp.Code, "%s %q", tc.method, tc.auth)
+	}
+
+	// Control endpoints are disabled without token.
+	api.token = ""
+	req := httptest.NewRequest(http.MethodPost, "/v1/accounts/user/pause", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestAccountActionHandlerBadPath(t *testing.T) {
+	api := &apiServer{token: "secret"}
+	handler := controlWrapper(api, accountActionHandler, http.MethodPost)
+
+	for _, path := range []string{"/v1/accounts/", "/v1/accounts/user", "/v1/accounts/user/pause/now"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		require.Equal(t, http.StatusNotFound, resp.Code, path)
+	}
+}
+
+func TestStatusNeedsToken(t *testing.T) {
+	api := &apiServer{token: "secret"}
+	mux := api.newMux()
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusUnauthorized, resp.Code)
+	require.NotContains(t, resp.Body.String(), "username")
+}
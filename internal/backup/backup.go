@@ -0,0 +1,205 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package backup writes local data of Bridge (store databases, body caches,
+// event IDs and preferences) to one archive and restores it, so Bridge can be
+// moved to another machine without syncing all mail again. Credentials are
+// never part of the archive; accounts have to be logged in again after
+// restore and they then reuse the restored stores.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/pkg/errors"
+)
+
+// formatVersion is increased when the layout of the archive changes in a way
+// older versions cannot restore.
+const formatVersion = 1
+
+const (
+	manifestName    = "manifest.json"
+	preferencesName = "prefs.json"
+	imapCacheName   = "user_info.json"
+	storesDir       = "stores"
+)
+
+// User IDs are base64 encoded, so names matching these never contain path
+// separators.
+var (
+	storeFileRgx    = regexp.MustCompile(`^mailbox-([\w=-]+)\.db$`)    //nolint[gochecknoglobals]
+	bodyCacheDirRgx = regexp.MustCompile(`^mailbox-([\w=-]+)-bodies$`) //nolint[gochecknoglobals]
+	bodyFileRgx     = regexp.MustCompile(`^[0-9a-f]+$`)                //nolint[gochecknoglobals]
+)
+
+// Configer provides locations of backed up data.
+type Configer interface {
+	GetDBDir() string
+	GetIMAPCachePath() string
+	GetPreferencesPath() string
+}
+
+type manifest struct {
+	Format  int
+	Version string // Version of Bridge which created the archive.
+	Created time.Time
+}
+
+// Create writes the archive to w and returns IDs of users whose stores it
+// contains. Bridge must not be running, so the stores are not changed during
+// the backup.
+func Create(cfg Configer, version string, w io.Writer) (userIDs []string, err error) {
+	tmpDir, err := ioutil.TempDir("", "bridge-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir) //nolint[errcheck]
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	manifestJSON, err := json.Marshal(manifest{Format: formatVersion, Version: version, Created: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestName, time.Now(), int64(len(manifestJSON)), bytes.NewReader(manifestJSON)); err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(tw, preferencesName, cfg.GetPreferencesPath()); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+	if err := writeFile(tw, imapCacheName, cfg.GetIMAPCachePath()); err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+
+	storePaths, err := listStores(cfg.GetDBDir())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, storePath := range storePaths {
+		storeName := filepath.Base(storePath)
+
+		snapshotPath := filepath.Join(tmpDir, storeName)
+		if err := store.SnapshotStorage(storePath, snapshotPath); err != nil {
+			return nil, errors.Wrapf(err, "failed to snapshot %s", storeName)
+		}
+		if err := writeFile(tw, path.Join(storesDir, storeName), snapshotPath); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(snapshotPath); err != nil {
+			return nil, err
+		}
+
+		if err := writeBodyCache(tw, store.GetBodyCacheDir(storePath)); err != nil {
+			return nil, errors.Wrapf(err, "failed to write body cache of %s", storeName)
+		}
+
+		userIDs = append(userIDs, storeFileRgx.FindStringSubmatch(storeName)[1])
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// listStores returns sorted paths of store databases in the directory.
+func listStores(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, file := range files {
+		if file.Mode().IsRegular() && storeFileRgx.MatchString(file.Name()) {
+			paths = append(paths, filepath.Join(dir, file.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func writeBodyCache(tw *tar.Writer, dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dirName := path.Join(storesDir, filepath.Base(dir))
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if err := writeFile(tw, path.Join(dirName, file.Name()), filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(tw *tar.Writer, name, filePath string) error {
+	f, err := os.Open(filePath) //nolint[gosec]
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", name)
+	}
+	defer f.Close() //nolint[errcheck]
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(tw, name, info.ModTime(), info.Size(), f)
+}
+
+func writeEntry(tw *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(tw, r, size)
+	return errors.Wrapf(err, "failed to write %s", name)
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+type fakeConfig struct {
+	dir string
+}
+
+func newFakeConfig(t *testing.T) *fakeConfig {
+	dir, err := ioutil.TempDir("", "backup-test")
+	require.NoError(t, err)
+	return &fakeConfig{dir: dir}
+}
+
+func (c *fakeConfig) GetDBDir() string           { return c.dir }
+func (c *fakeConfig) GetIMAPCachePath() string   { return filepath.Join(c.dir, "user_info.json") }
+func (c *fakeConfig) GetPreferencesPath() string { return filepath.Join(c.dir, "prefs.json") }
+
+func writeTestFile(t *testing.T, filePath, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0700))
+	require.NoError(t, ioutil.WriteFile(filePath, []byte(content), 0600))
+}
+
+func requireFileContent(t *testing.T, filePath, content string) {
+	data, err := ioutil.ReadFile(filePath) //nolint[gosec]
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+}
+
+func createBoltStore(t *testing.T, filePath, value string) {
+	db, err := bolt.Open(filePath, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close() //nolint[errcheck]
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+		require.NoError(t, err)
+		return b.Put([]byte("key"), []byte(value))
+	}))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	src := newFakeConfig(t)
+	defer os.RemoveAll(src.dir) //nolint[errcheck]
+
+	writeTestFile(t, src.GetPreferencesPath(), `{"cache_enabled":"true"}`)
+	writeTestFile(t, src.GetIMAPCachePath(), `{"user1":{}}`)
+	createBoltStore(t, filepath.Join(src.dir, "mailbox-user1.db"), "backed up")
+	bodyPath := filepath.Join(src.dir, "mailbox-user1-bodies", "0a1b")
+	writeTestFile(t, bodyPath, "body")
+	bodyTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(bodyPath, bodyTime, bodyTime))
+	writeTestFile(t, filepath.Join(src.dir, "bridge.lock"), "not backed up")
+
+	var archive bytes.Buffer
+	userIDs, err := Create(src, "1.5.0", &archive)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user1"}, userIDs)
+
+	dst := newFakeConfig(t)
+	defer os.RemoveAll(dst.dir) //nolint[errcheck]
+
+	// Store of the same user is replaced, other stores are kept.
+	writeTestFile(t, filepath.Join(dst.dir, "mailbox-user1.db"), "old")
+	writeTestFile(t, filepath.Join(dst.dir, "mailbox-user1-bodies", "ffff"), "old body")
+	writeTestFile(t, filepath.Join(dst.dir, "mailbox-user2.db"), "other")
+
+	userIDs, err = Restore(dst, &archive)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user1"}, userIDs)
+
+	requireFileContent(t, dst.GetPreferencesPath(), `{"cache_enabled":"true"}`)
+	requireFileContent(t, dst.GetIMAPCachePath(), `{"user1":{}}`)
+	requireFileContent(t, filepath.Join(dst.dir, "mailbox-user2.db"), "other")
+	requireFileContent(t, filepath.Join(dst.dir, "mailbox-user1-bodies", "0a1b"), "body")
+
+	info, err := os.Stat(filepath.Join(dst.dir, "mailbox-user1-bodies", "0a1b"))
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(bodyTime))
+
+	for _, name := range []string{"mailbox-user1-bodies/ffff", "bridge.lock"} {
+		_, err := os.Stat(filepath.Join(dst.dir, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+
+	db, err := bolt.Open(filepath.Join(dst.dir, "mailbox-user1.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close() //nolint[errcheck]
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("backed up"), tx.Bucket([]byte("metadata")).Get([]byte("key")))
+		return nil
+	}))
+}
+
+func TestRestoreNotBackup(t *testing.T) {
+	cfg := newFakeConfig(t)
+	defer os.RemoveAll(cfg.dir) //nolint[errcheck]
+
+	_, err := Restore(cfg, bytes.NewReader([]byte("plain text")))
+	require.Equal(t, errNotBackup, err)
+}
+
+func TestRestoreRejectsUnexpectedEntries(t *testing.T) {
+	for _, name := range []string{
+		"../prefs.json",
+		"stores/../../outside",
+		"stores/mailbox-../x.db",
+		"stores/mailbox-user1-bodies/../../outside",
+		"stores/other.db",
+		"/etc/passwd",
+	} {
+		cfg := newFakeConfig(t)
+
+		var archive bytes.Buffer
+		gw := gzip.NewWriter(&archive)
+		tw := tar.NewWriter(gw)
+		require.NoError(t, writeEntry(tw, manifestName, time.Now(), 12, bytes.NewReader([]byte(`{"Format":1}`))))
+		require.NoError(t, writeEntry(tw, name, time.Now(), 4, bytes.NewReader([]byte("evil"))))
+		require.NoError(t, tw.Close())
+		require.NoError(t, gw.Close())
+
+		_, err := Restore(cfg, &archive)
+		require.Error(t, err, name)
+
+		files, err := ioutil.ReadDir(cfg.dir)
+		require.NoError(t, err)
+		require.Empty(t, files, name)
+
+		require.NoError(t, os.RemoveAll(cfg.dir))
+	}
+}
+
+func TestRestoreNewerFormat(t *testing.T) {
+	cfg := newFakeConfig(t)
+	defer os.RemoveAll(cfg.dir) //nolint[errcheck]
+
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	manifestJSON := []byte(`{"Format":2,"Version":"9.0.0"}`)
+	require.NoError(t, writeEntry(tw, manifestName, time.Now(), int64(len(manifestJSON)), bytes.NewReader(manifestJSON)))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	_, err := Restore(cfg, &archive)
+	require.EqualError(t, err, "backup was created by newer version 9.0.0")
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/pkg/errors"
+)
+
+var errNotBackup = errors.New("not a Bridge backup archive") //nolint[gochecknoglobals]
+
+// Restore extracts the archive created by Create and returns IDs of users
+// whose stores were restored. Preferences, event IDs and existing stores of
+// the same users are replaced, other stores are kept. Bridge must not be
+// running.
+func Restore(cfg Configer, r io.Reader) (userIDs []string, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errNotBackup
+	}
+	tr := tar.NewReader(gr)
+
+	if header, err := tr.Next(); err != nil || header.Name != manifestName {
+		return nil, errNotBackup
+	}
+
+	var m manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, errNotBackup
+	}
+	if m.Format > formatVersion {
+		return nil, errors.Errorf("backup was created by newer version %s", m.Version)
+	}
+
+	// Files of a store are removed before its first entry is extracted, so
+	// nothing of the replaced store stays next to the restored one.
+	cleared := map[string]bool{}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return userIDs, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return userIDs, errors.Errorf("unexpected entry %s", header.Name)
+		}
+
+		filePath, userID, err := getRestorePath(cfg, header.Name)
+		if err != nil {
+			return userIDs, err
+		}
+
+		if userID != "" && !cleared[userID] {
+			if err := removeStoreFiles(cfg.GetDBDir(), userID); err != nil {
+				return userIDs, err
+			}
+			cleared[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+
+		if err := extractFile(tr, filePath, header); err != nil {
+			return userIDs, errors.Wrapf(err, "failed to restore %s", header.Name)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// getRestorePath returns where the entry of the archive is extracted to and
+// the user ID when the entry belongs to a store. Only names which Create
+// writes are accepted, so the archive cannot write outside of Bridge data.
+func getRestorePath(cfg Configer, name string) (filePath, userID string, err error) {
+	switch name {
+	case preferencesName:
+		return cfg.GetPreferencesPath(), "", nil
+	case imapCacheName:
+		return cfg.GetIMAPCachePath(), "", nil
+	}
+
+	parts := strings.Split(name, "/")
+	if len(parts) < 2 || parts[0] != storesDir {
+		return "", "", errors.Errorf("unexpected entry %s", name)
+	}
+
+	if len(parts) == 2 {
+		if match := storeFileRgx.FindStringSubmatch(parts[1]); match != nil {
+			return filepath.Join(cfg.GetDBDir(), parts[1]), match[1], nil
+		}
+	}
+
+	if len(parts) == 3 && bodyFileRgx.MatchString(parts[2]) {
+		if match := bodyCacheDirRgx.FindStringSubmatch(parts[1]); match != nil {
+			return filepath.Join(cfg.GetDBDir(), parts[1], parts[2]), match[1], nil
+		}
+	}
+
+	return "", "", errors.Errorf("unexpected entry %s", name)
+}
+
+// removeStoreFiles removes the database and the body and attachment caches
+// of the user.
+func removeStoreFiles(dir, userID string) error {
+	storePath := filepath.Join(dir, "mailbox-"+userID+".db")
+	for _, file := range []string{
+		storePath, store.GetStoreBackupPath(storePath), store.GetStorageLockPath(storePath),
+		store.GetBodyCacheDir(storePath), store.GetAttachmentCacheDir(storePath),
+	} {
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, filePath string, header *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) //nolint[gosec]
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Modification times of bodies decide which are evicted first.
+	return os.Chtimes(filePath, header.ModTime, header.ModTime)
+}
@@ -19,9 +19,11 @@
 package bridge
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/metrics"
 	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/internal/users"
@@ -39,7 +41,9 @@ type Bridge struct {
 	*users.Users
 
 	pref          PreferenceProvider
+	panicHandler  users.PanicHandler
 	clientManager users.ClientManager
+	storeFactory  *storeFactory
 
 	userAgentClientName    string
 	userAgentClientVersion string
@@ -60,27 +64,38 @@ func New(
 		clientManager.AllowProxy()
 	}
 
-	storeFactory := newStoreFactory(config, panicHandler, clientManager, eventListener)
+	storeFactory := newStoreFactory(config, pref, panicHandler, clientManager, eventListener)
 	u := users.New(config, panicHandler, eventListener, clientManager, credStorer, storeFactory, true)
 	b := &Bridge{
 		Users: u,
 
 		pref:          pref,
+		panicHandler:  panicHandler,
 		clientManager: clientManager,
+		storeFactory:  storeFactory,
 	}
 
+	b.applyNetworkProfile()
+
 	if pref.GetBool(preferences.FirstStartKey) {
 		b.SendMetric(metrics.New(metrics.Setup, metrics.FirstStart, metrics.Label(config.GetVersion())))
 		pref.SetBool(preferences.FirstStartKey, false)
 	}
 
-	go b.heartbeat()
+	go b.heartbeat(config)
+	go b.watchSyncDuration(eventListener)
 
 	return b
 }
 
-// heartbeat sends a heartbeat signal once a day.
-func (b *Bridge) heartbeat() {
+// heartbeat sends crashes since the previous start and then a heartbeat
+// signal once a day. It runs in the background as metrics are sent over
+// network.
+func (b *Bridge) heartbeat(config Configer) {
+	defer b.panicHandler.HandlePanic()
+
+	b.sendCrashMetric(config)
+
 	ticker := time.NewTicker(1 * time.Minute)
 
 	for range ticker.C {
@@ -97,6 +112,80 @@ func (b *Bridge) heartbeat() {
 	}
 }
 
+// sendCrashMetric sends the number of crash dumps written since the last
+// check, i.e. crashes since the previous start.
+func (b *Bridge) sendCrashMetric(config Configer) {
+	last, err := strconv.ParseInt(b.pref.Get(preferences.LastCrashCheckKey), 10, 64)
+	if err != nil {
+		last = 0
+	}
+	b.pref.Set(preferences.LastCrashCheckKey, strconv.FormatInt(time.Now().Unix(), 10))
+
+	if count := config.CountCrashDumps(time.Unix(last, 0)); count > 0 {
+		b.SendMetric(metrics.New(metrics.Crash, metrics.CrashDumps, metrics.CountLabel(count)))
+	}
+}
+
+// watchSyncDuration sends the duration of each finished sync.
+func (b *Bridge) watchSyncDuration(eventListener listener.Listener) {
+	defer b.panicHandler.HandlePanic()
+
+	syncCh := make(chan string)
+	eventListener.Add(events.SyncFinishedEvent, syncCh)
+
+	for userID := range syncCh {
+		user, err := b.GetUser(userID)
+		if err != nil {
+			continue
+		}
+		store := user.GetStore()
+		if store == nil {
+			continue
+		}
+		if duration := store.GetLastSyncDuration(); duration > 0 {
+			b.SendMetric(metrics.New(metrics.Performance, metrics.SyncDuration, metrics.DurationLabel(duration)))
+		}
+	}
+}
+
+// ApplyPreferences applies preferences read only when stores are created to
+// the stores already running, e.g. after the configuration is reloaded.
+// Other preferences are read whenever they are used.
+func (b *Bridge) ApplyPreferences() {
+	b.applyNetworkProfile()
+
+	policy := b.storeFactory.getPollPolicy()
+	for _, user := range b.GetUsers() {
+		user.SetPollPolicy(policy)
+	}
+}
+
+// GetNetworkProfile returns the network profile in use.
+func (b *Bridge) GetNetworkProfile() preferences.NetworkProfile {
+	return preferences.GetNetworkProfile(b.pref)
+}
+
+// SetNetworkProfile switches the network profile of running Bridge.
+func (b *Bridge) SetNetworkProfile(name string) error {
+	if _, ok := preferences.FindNetworkProfile(name); !ok {
+		return fmt.Errorf("unknown network profile %q", name)
+	}
+
+	b.pref.Set(preferences.NetworkProfileKey, name)
+	b.ApplyPreferences()
+
+	return nil
+}
+
+// applyNetworkProfile limits bandwidth and parallel transfers of all clients.
+// Prefetch reads the profile whenever it is used.
+func (b *Bridge) applyNetworkProfile() {
+	profile := b.GetNetworkProfile()
+
+	log.WithField("profile", profile.Name).Info("Applying network profile")
+	b.clientManager.SetThrottle(profile.BandwidthKBps*1024, profile.ParallelTransfers)
+}
+
 // GetCurrentClient returns currently connected client (e.g. Thunderbird).
 func (b *Bridge) GetCurrentClient() string {
 	res := b.userAgentClientName
@@ -0,0 +1,255 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ListenerConfig holds where the IMAP or SMTP server listens and who
+// is allowed to connect.
+type ListenerConfig struct {
+	Host       string
+	Port       int
+	SocketPath string
+
+	// AllowedClients limits remote clients by IP. Clients from loopback
+	// are always allowed. Empty list allows everybody.
+	AllowedClients []*net.IPNet
+
+	// Account dedicates the listener to one account (any of its addresses
+	// or username). Empty means every account can log in.
+	Account string
+
+	// Activated is the listener passed by systemd socket activation. It is
+	// used instead of host, port and socket path when set.
+	Activated net.Listener
+}
+
+// AccountPorts holds dedicated IMAP and SMTP ports of one account.
+// Zero port means the account does not have a dedicated listener.
+type AccountPorts struct {
+	IMAP int `json:"imap"`
+	SMTP int `json:"smtp"`
+}
+
+// Address returns the address used in logs and for the server itself.
+func (lc *ListenerConfig) Address() string {
+	if lc.Activated != nil {
+		addr := lc.Activated.Addr()
+		if addr.Network() == "tcp" {
+			return addr.String()
+		}
+		return addr.Network() + ":" + addr.String()
+	}
+	if lc.SocketPath != "" {
+		return "unix:" + lc.SocketPath
+	}
+	return fmt.Sprintf("%v:%v", lc.Host, lc.Port)
+}
+
+// IsRemote returns whether clients from other machines can connect.
+// Remote access requires TLS before authentication.
+func (lc *ListenerConfig) IsRemote() bool {
+	if lc.Activated != nil {
+		tcpAddr, ok := lc.Activated.Addr().(*net.TCPAddr)
+		return ok && !tcpAddr.IP.IsLoopback()
+	}
+	if lc.SocketPath != "" || lc.Host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(lc.Host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// Listen returns the activated listener if passed, opens the unix socket if
+// set or TCP port otherwise.
+func (lc *ListenerConfig) Listen() (l net.Listener, err error) {
+	switch {
+	case lc.Activated != nil:
+		l = lc.Activated
+		if _, ok := l.Addr().(*net.TCPAddr); !ok {
+			return l, nil
+		}
+	case lc.SocketPath != "":
+		return ListenUnixSocket(lc.SocketPath)
+	default:
+		if l, err = net.Listen("tcp", lc.Address()); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(lc.AllowedClients) == 0 {
+		return l, nil
+	}
+	return &allowListListener{Listener: l, allowed: lc.AllowedClients}, nil
+}
+
+// ParseAllowedClients parses comma separated list of IP addresses or CIDR ranges.
+func ParseAllowedClients(list string) (allowed []*net.IPNet, err error) {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			allowed = append(allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, ipNet)
+	}
+	return allowed, nil
+}
+
+// ParseAccountPorts parses JSON object mapping account to its dedicated ports,
+// for example `{"alice@pm.me": {"imap": 1144, "smtp": 1026}}`.
+func ParseAccountPorts(value string) (map[string]AccountPorts, error) {
+	accountPorts := map[string]AccountPorts{}
+	if value == "" {
+		return accountPorts, nil
+	}
+	if err := json.Unmarshal([]byte(value), &accountPorts); err != nil {
+		return nil, err
+	}
+
+	used := map[int]string{}
+	for account, ports := range accountPorts {
+		for _, port := range []int{ports.IMAP, ports.SMTP} {
+			if port == 0 {
+				continue
+			}
+			if port < 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %d for account %q", port, account)
+			}
+			if other, ok := used[port]; ok {
+				return nil, fmt.Errorf("port %d is used by both %q and %q", port, other, account)
+			}
+			used[port] = account
+		}
+	}
+	return accountPorts, nil
+}
+
+// allowListListener drops connections from clients not in the allow list.
+type allowListListener struct {
+	net.Listener
+
+	allowed []*net.IPNet
+}
+
+func (l *allowListListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.isAllowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		log.WithField("remote", conn.RemoteAddr().String()).Warn("Rejecting connection from client not in allow list")
+		_ = conn.Close()
+	}
+}
+
+func (l *allowListListener) isAllowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if tcpAddr.IP.IsLoopback() {
+		return true
+	}
+	for _, ipNet := range l.allowed {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// DrainListener lets the server finish open connections while no new ones
+// are accepted. Servers close all their connections once Accept fails, so
+// after Drain the Accept blocks until the listener is closed.
+type DrainListener struct {
+	net.Listener
+
+	drainOnce, closeOnce sync.Once
+	draining, closed     chan struct{}
+}
+
+// NewDrainListener wraps the listener to be able to drain it.
+func NewDrainListener(l net.Listener) *DrainListener {
+	return &DrainListener{
+		Listener: l,
+		draining: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (l *DrainListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && l.IsDraining() {
+		<-l.closed
+	}
+	return conn, err
+}
+
+// Drain stops accepting new connections.
+func (l *DrainListener) Drain() {
+	l.drainOnce.Do(func() {
+		close(l.draining)
+		_ = l.Listener.Close()
+	})
+}
+
+// IsDraining returns whether Drain or Close was called.
+func (l *DrainListener) IsDraining() bool {
+	select {
+	case <-l.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting new connections and unblocks Accept.
+func (l *DrainListener) Close() error {
+	l.Drain()
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package bridge
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowedClients(t *testing.T) {
+	allowed, err := ParseAllowedClients("")
+	require.NoError(t, err)
+	require.Empty(t, allowed)
+
+	allowed, err = ParseAllowedClients("192.168.1.10, 10.0.0.0/8,fd00::1")
+	require.NoError(t, err)
+	require.Len(t, allowed, 3)
+	require.Equal(t, "192.168.1.10/32", allowed[0].String())
+	require.Equal(t, "10.0.0.0/8", allowed[1].String())
+	require.Equal(t, "fd00::1/128", allowed[2].String())
+
+	_, err = ParseAllowedClients("192.168.1")
+	require.Error(t, err)
+
+	_, err = ParseAllowedClients("10.0.0.0/33")
+	require.Error(t, err)
+}
+
+func TestAllowListListener(t *testing.T) {
+	allowed, err := ParseAllowedClients("192.168.1.0/24")
+	require.NoError(t, err)
+
+	l := &allowListListener{allowed: allowed}
+
+	tests := []struct {
+		addr net.Addr
+		want bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.20")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.2.20")}, false},
+		{&net.UnixAddr{Name: "sock"}, false},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, l.isAllowed(tc.addr), tc.addr.String())
+	}
+}
+
+func TestListenerConfigIsRemote(t *testing.T) {
+	require.False(t, (&ListenerConfig{Host: "127.0.0.1"}).IsRemote())
+	require.False(t, (&ListenerConfig{Host: "localhost"}).IsRemote())
+	require.False(t, (&ListenerConfig{Host: "0.0.0.0", SocketPath: "/tmp/sock"}).IsRemote())
+	require.True(t, (&ListenerConfig{Host: "0.0.0.0"}).IsRemote())
+	require.True(t, (&ListenerConfig{Host: "192.168.1.124"}).IsRemote())
+}
+
+func TestListenerConfigActivated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close() //nolint[errcheck]
+
+	lc := ListenerConfig{Host: "0.0.0.0", Port: 1143, Activated: l}
+	require.False(t, lc.IsRemote())
+	require.Equal(t, l.Addr().String(), lc.Address())
+
+	listener, err := lc.Listen()
+	require.NoError(t, err)
+	require.Equal(t, l, listener)
+}
+
+func TestParseAccountPorts(t *testing.T) {
+	accountPorts, err := ParseAccountPorts("")
+	require.NoError(t, err)
+	require.Empty(t, accountPorts)
+
+	accountPorts, err = ParseAccountPorts(`{"alice@pm.me": {"imap": 1144, "smtp": 1026}, "bob@pm.me": {"imap": 1145}}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]AccountPorts{
+		"alice@pm.me": {IMAP: 1144, SMTP: 1026},
+		"bob@pm.me":   {IMAP: 1145},
+	}, accountPorts)
+
+	_, err = ParseAccountPorts(`{"alice@pm.me": {"imap": 1144}, "bob@pm.me": {"smtp": 1144}}`)
+	require.Error(t, err)
+
+	_, err = ParseAccountPorts(`{"alice@pm.me": {"imap": 70000}}`)
+	require.Error(t, err)
+
+	_, err = ParseAccountPorts(`[1144]`)
+	require.Error(t, err)
+}
+
+func TestDrainListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l := NewDrainListener(tcp)
+	accepted := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+
+	l.Drain()
+	require.True(t, l.IsDraining())
+
+	_, err = net.Dial("tcp", tcp.Addr().String())
+	require.Error(t, err)
+
+	select {
+	case <-accepted:
+		t.Fatal("Accept returned before close")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, l.Close())
+	require.Error(t, <-accepted)
+}
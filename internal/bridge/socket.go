@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// socketFileMode allows connections only for the owner and the group
+// of the user running Bridge.
+const socketFileMode = 0660
+
+// ListenUnixSocket listens on the unix domain socket at path.
+// The socket left by previous run is removed first, the socket of a running
+// process is never taken over.
+func ListenUnixSocket(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%v is used by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// The socket is created in a folder only the owner can access and moved
+	// to the path once it has its mode, so nobody else can connect before.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	tmpPath := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, socketFileMode); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	return &unixSocketListener{UnixListener: l, path: path}, nil
+}
+
+// unixSocketListener removes the socket when closed. The listener cannot do
+// it by itself because the socket was moved after it was created.
+type unixSocketListener struct {
+	*net.UnixListener
+
+	path string
+}
+
+func (l *unixSocketListener) Close() error {
+	err := l.UnixListener.Close()
+	if removeErr := os.Remove(l.path); err == nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-socket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	path := filepath.Join(dir, "imap.sock")
+
+	l, err := ListenUnixSocket(path)
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(socketFileMode), info.Mode().Perm())
+
+	// Socket of running process is kept.
+	_, err = ListenUnixSocket(path)
+	require.Error(t, err)
+	require.NoError(t, l.Close())
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// Stale socket is replaced.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	l, err = ListenUnixSocket(path)
+	require.NoError(t, err)
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_ = conn.Close()
+	require.NoError(t, l.Close())
+
+	// Regular file is never removed.
+	filePath := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("data"), 0600))
+	_, err = ListenUnixSocket(filePath)
+	require.Error(t, err)
+	require.FileExists(t, filePath)
+}
@@ -18,17 +18,23 @@
 package bridge
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/users"
 
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/pkg/errors"
 )
 
 type storeFactory struct {
 	config        StoreFactoryConfiger
+	pref          PreferenceProvider
 	panicHandler  users.PanicHandler
 	clientManager users.ClientManager
 	eventListener listener.Listener
@@ -37,12 +43,14 @@ type storeFactory struct {
 
 func newStoreFactory(
 	config StoreFactoryConfiger,
+	pref PreferenceProvider,
 	panicHandler users.PanicHandler,
 	clientManager users.ClientManager,
 	eventListener listener.Listener,
 ) *storeFactory {
 	return &storeFactory{
 		config:        config,
+		pref:          pref,
 		panicHandler:  panicHandler,
 		clientManager: clientManager,
 		eventListener: eventListener,
@@ -53,7 +61,144 @@ func newStoreFactory(
 // New creates new store for given user.
 func (f *storeFactory) New(user store.BridgeUser) (*store.Store, error) {
 	storePath := getUserStorePath(f.config.GetDBDir(), user.ID())
-	return store.New(f.panicHandler, user, f.clientManager, f.eventListener, storePath, f.storeCache)
+	s, err := f.newStore(user, storePath)
+
+	// Store migrated by newer Bridge cannot be read, it is synced again.
+	if errors.Cause(err) == store.ErrStoreSchemaTooNew {
+		log.WithError(err).Warn("Store database is too new, removing it")
+		if err := store.RemoveStore(f.storeCache, storePath, user.ID()); err != nil {
+			return nil, err
+		}
+		s, err = f.newStore(user, storePath)
+	}
+
+	return s, err
+}
+
+func (f *storeFactory) newStore(user store.BridgeUser, storePath string) (*store.Store, error) {
+	return store.New(
+		f.panicHandler,
+		user,
+		f.clientManager,
+		f.eventListener,
+		storePath,
+		f.storeCache,
+		store.Options{
+			SavedSearches:     f.getSavedSearches(),
+			SearchIndex:       f.getSearchIndexPolicy(),
+			BodyCache:         f.getBodyCachePolicy(user.GetPrimaryAddress()),
+			SyncWorkers:       f.pref.GetInt(preferences.SyncWorkersKey),
+			ExcludedMailboxes: f.getExcludedMailboxes(user.GetPrimaryAddress()),
+			SyncMode:          f.getSyncMode(),
+			PollPolicy:        f.getPollPolicy(),
+			SyncMemoryLimit:   f.getSyncMemoryLimit(),
+		},
+	)
+}
+
+// getSearchIndexPolicy returns the policy of the local full-text search index
+// or nil when the index is turned off.
+func (f *storeFactory) getSearchIndexPolicy() *store.SearchIndexPolicy {
+	if !f.pref.GetBool(preferences.SearchIndexKey) {
+		return nil
+	}
+	return &store.SearchIndexPolicy{
+		MaxAge: time.Duration(f.pref.GetInt(preferences.SearchIndexDaysKey)) * 24 * time.Hour,
+	}
+}
+
+// getSavedSearches returns saved searches (name to query) exposed as virtual mailboxes.
+func (f *storeFactory) getSavedSearches() map[string]string {
+	savedSearches := map[string]string{}
+	if err := json.Unmarshal([]byte(f.pref.Get(preferences.SavedSearchesKey)), &savedSearches); err != nil {
+		log.WithError(err).Warn("Cannot parse saved searches")
+	}
+	return savedSearches
+}
+
+// getBodyCachePolicy returns the policy of the on-disk body cache for given
+// account or nil when the cache is turned off by zero size. Size set for the
+// account in preferences.BodyCacheAccountsKey takes precedence. Attachments
+// are cached only together with bodies.
+func (f *storeFactory) getBodyCachePolicy(address string) *store.BodyCachePolicy {
+	sizeMB := f.pref.GetInt(preferences.BodyCacheSizeKey)
+
+	accountSizes := map[string]int{}
+	if err := json.Unmarshal([]byte(f.pref.Get(preferences.BodyCacheAccountsKey)), &accountSizes); err != nil {
+		log.WithError(err).Warn("Cannot parse per-account body cache sizes")
+	}
+	for account, accountSizeMB := range accountSizes {
+		if strings.EqualFold(account, address) {
+			sizeMB = accountSizeMB
+		}
+	}
+
+	if sizeMB <= 0 {
+		return nil
+	}
+
+	eviction := f.pref.Get(preferences.BodyCacheEvictionKey)
+	if !store.IsBodyCacheEviction(eviction) {
+		log.WithField("eviction", eviction).Warn("Unknown body cache eviction policy, using LRU")
+		eviction = store.BodyCacheEvictLRU
+	}
+
+	return &store.BodyCachePolicy{
+		MaxSize:           int64(sizeMB) * 1024 * 1024,
+		Eviction:          eviction,
+		AttachmentMaxSize: int64(f.pref.GetInt(preferences.AttCacheSizeKey)) * 1024 * 1024,
+	}
+}
+
+// getExcludedMailboxes returns names or label IDs of mailboxes which are
+// not synced nor exposed over IMAP for given account.
+func (f *storeFactory) getExcludedMailboxes(address string) []string {
+	accountExcluded := map[string][]string{}
+	if err := json.Unmarshal([]byte(f.pref.Get(preferences.SyncExcludedKey)), &accountExcluded); err != nil {
+		log.WithError(err).Warn("Cannot parse mailboxes excluded from sync")
+	}
+	for account, excluded := range accountExcluded {
+		if strings.EqualFold(account, address) {
+			return excluded
+		}
+	}
+	return nil
+}
+
+// getSyncMode returns what the store keeps of messages locally.
+func (f *storeFactory) getSyncMode() string {
+	mode := f.pref.Get(preferences.SyncModeKey)
+	if !store.IsSyncMode(mode) {
+		log.WithField("mode", mode).Warn("Unknown sync mode, using full")
+		return store.SyncModeFull
+	}
+	return mode
+}
+
+// getPollPolicy returns bounds of the adaptive event poll interval.
+func (f *storeFactory) getPollPolicy() *store.PollPolicy {
+	return &store.PollPolicy{
+		MinInterval: time.Duration(f.pref.GetInt(preferences.PollMinIntervalKey)) * time.Second,
+		MaxInterval: time.Duration(f.pref.GetInt(preferences.PollMaxIntervalKey)) * time.Second,
+		Metered:     preferences.GetNetworkProfile(f.pref).MeteredPolling,
+	}
+}
+
+// minSyncMemoryLimitMB is the least memory sync may use for fetched pages.
+// Store treats zero limit as unlimited which must not be reachable from
+// preferences.
+const minSyncMemoryLimitMB = 16
+
+// getSyncMemoryLimit returns how many bytes of fetched pages sync keeps in
+// memory before spilling to disk. Values below minSyncMemoryLimitMB,
+// including zero, are raised to it.
+func (f *storeFactory) getSyncMemoryLimit() int64 {
+	limitMB := f.pref.GetInt(preferences.SyncMemoryLimitKey)
+	if limitMB < minSyncMemoryLimitMB {
+		log.WithField("limit", limitMB).Warn("Sync memory limit too low, using minimum")
+		limitMB = minSyncMemoryLimitMB
+	}
+	return int64(limitMB) * 1024 * 1024
 }
 
 // Remove removes all store files for given user.
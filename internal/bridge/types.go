@@ -17,11 +17,16 @@
 
 package bridge
 
-import "github.com/ProtonMail/proton-bridge/internal/users"
+import (
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/users"
+)
 
 type Configer interface {
 	users.Configer
 	StoreFactoryConfiger
+	CountCrashDumps(since time.Time) int
 }
 
 type StoreFactoryConfiger interface {
@@ -0,0 +1,209 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugServer serves net/http/pprof and runtime metrics on localhost so
+// users can capture profiles of high CPU or memory usage. It is off until
+// a port is set. Requests need the API token, as header or as token query
+// parameter for browsers and go tool pprof, and localhost in Host header, so
+// other local users and web pages (by DNS rebinding) cannot read profiles.
+type DebugServer struct {
+	lock    sync.Mutex
+	port    int
+	token   string
+	server  *http.Server
+	started time.Time
+}
+
+// NewDebugServer returns the debug server which is not listening yet.
+// Without the token the server is never started.
+func NewDebugServer(token string) *DebugServer {
+	return &DebugServer{token: token, started: time.Now()}
+}
+
+// SetPort starts the server on the port of localhost, restarts it when the
+// port changed or stops it when the port is zero.
+func (d *DebugServer) SetPort(port int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if port == d.port {
+		return
+	}
+
+	d.stop()
+	d.port = port
+
+	if port == 0 {
+		return
+	}
+	if d.token == "" {
+		log.Error("Cannot start debug server without API token")
+		d.port = 0
+		return
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.WithError(err).Error("Cannot start debug server")
+		d.port = 0
+		return
+	}
+
+	d.server = &http.Server{Handler: d.protect(d.newMux())}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("Debug server failed")
+		}
+	}(d.server)
+
+	log.Warn("Debug server with profiles listening at http://", addr, "/debug/pprof/?token=<API token>")
+}
+
+// Stop stops the server if it is running.
+func (d *DebugServer) Stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.stop()
+	d.port = 0
+}
+
+func (d *DebugServer) stop() {
+	if d.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := d.server.Shutdown(ctx); err != nil {
+		log.WithError(err).Warn("Debug server was not stopped gracefully")
+	}
+	d.server = nil
+
+	log.Info("Debug server stopped")
+}
+
+// newMux registers handlers explicitly instead of relying on the default
+// mux to which net/http/pprof adds them on import.
+func (d *DebugServer) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/metrics", d.metricsHandler)
+	return mux
+}
+
+// protect allows only requests to localhost with the token.
+func (d *DebugServer) protect(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalHost(r.Host) {
+			http.Error(w, "wrong host", http.StatusForbidden)
+			return
+		}
+		if !d.hasToken(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong API token", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (d *DebugServer) hasToken(r *http.Request) bool {
+	given := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		given = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(d.token)) == 1
+}
+
+func isLocalHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	switch strings.ToLower(host) {
+	case "127.0.0.1", "localhost", "::1", "[::1]":
+		return true
+	}
+	return false
+}
+
+// runtimeMetrics is the summary of the runtime for quick checks without
+// a profile.
+type runtimeMetrics struct {
+	UptimeSeconds int64
+	Goroutines    int
+	CPUs          int
+	MaxProcs      int
+	CgoCalls      int64
+	HeapAlloc     uint64
+	HeapInuse     uint64
+	HeapObjects   uint64
+	Sys           uint64
+	TotalAlloc    uint64
+	NumGC         uint32
+	PauseTotalNs  uint64
+}
+
+func (d *DebugServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	metrics := runtimeMetrics{
+		UptimeSeconds: int64(time.Since(d.started).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		CPUs:          runtime.NumCPU(),
+		MaxProcs:      runtime.GOMAXPROCS(0),
+		CgoCalls:      runtime.NumCgoCall(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		Sys:           mem.Sys,
+		TotalAlloc:    mem.TotalAlloc,
+		NumGC:         mem.NumGC,
+		PauseTotalNs:  mem.PauseTotalNs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		log.WithError(err).Warn("Cannot write debug metrics")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getFreePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close() //nolint[errcheck]
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestDebugServer(t *testing.T) {
+	port := getFreePort(t)
+	url := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	server := NewDebugServer("token")
+	server.SetPort(port)
+	defer server.Stop()
+
+	res, err := http.Get(url + "/debug/metrics")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, url+"/debug/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Host = "attacker.example.com"
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	req.Host = ""
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var metrics runtimeMetrics
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&metrics))
+	_ = res.Body.Close()
+	require.NotZero(t, metrics.Goroutines)
+	require.NotZero(t, metrics.HeapAlloc)
+
+	res, err = http.Get(url + "/debug/pprof/heap?token=token")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	server.SetPort(0)
+	_, err = http.Get(url + "/debug/metrics") //nolint[bodyclose]
+	require.Error(t, err)
+}
+
+func TestDebugServerNeedsToken(t *testing.T) {
+	port := getFreePort(t)
+
+	server := NewDebugServer("")
+	server.SetPort(port)
+	defer server.Stop()
+
+	_, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/metrics", port)) //nolint[bodyclose]
+	require.Error(t, err)
+}
@@ -18,10 +18,17 @@
 package cmd
 
 import (
+	"io"
 	"os"
 	"runtime"
 
+	"github.com/ProtonMail/proton-bridge/internal/metrics"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/constants"
+	"github.com/ProtonMail/proton-bridge/pkg/keychain"
+	pkgsentry "github.com/ProtonMail/proton-bridge/pkg/sentry"
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
 	"github.com/getsentry/sentry-go"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -47,13 +54,33 @@ var (
 			Name:  "cpu-prof, p",
 			Usage: "Generate CPU profile"},
 	}
+
+	// profileFlags select the instance for the app and all its commands,
+	// so they are not repeated in flags of commands.
+	profileFlags = []cli.Flag{ //nolint[gochecknoglobals]
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "Run separate instance `NAME` with its own accounts, preferences and cache",
+			EnvVar: "BRIDGE_PROFILE"},
+		cli.StringFlag{
+			Name:   "config-dir",
+			Usage:  "Keep all files of the instance in `DIR` (profile name is the folder name unless --profile is set)",
+			EnvVar: "BRIDGE_CONFIG_DIR"},
+		cli.BoolFlag{
+			Name:   "portable",
+			Usage:  "Keep everything incl. encrypted credentials in --config-dir or in folder portable next to the executable and change nothing in the system",
+			EnvVar: "BRIDGE_PORTABLE"},
+	}
 )
 
 // Main sets up Sentry, filters out unwanted args, creates app and runs it.
-func Main(appName, usage string, extraFlags []cli.Flag, run func(*cli.Context) error) {
+// Commands are optional subcommands of the app running instead of `run`.
+func Main(appName, usage string, extraFlags []cli.Flag, commands []cli.Command, run func(*cli.Context) error) {
 	err := sentry.Init(sentry.ClientOptions{
 		Dsn:     constants.DSNSentry,
 		Release: constants.Revision,
+		// Events are dropped or anonymised by the level from preferences.
+		BeforeSend: pkgsentry.BeforeSend,
 	})
 
 	sentry.ConfigureScope(func(scope *sentry.Scope) {
@@ -67,7 +94,7 @@ func Main(appName, usage string, extraFlags []cli.Flag, run func(*cli.Context) e
 	filterProcessSerialNumberFromArgs()
 	filterRestartNumberFromArgs()
 
-	app := newApp(appName, usage, extraFlags, run)
+	app := newApp(appName, usage, extraFlags, commands, run)
 
 	logrus.SetLevel(logrus.InfoLevel)
 	log.WithField("version", constants.Version).
@@ -83,12 +110,73 @@ func Main(appName, usage string, extraFlags []cli.Flag, run func(*cli.Context) e
 	}
 }
 
-func newApp(appName, usage string, extraFlags []cli.Flag, run func(*cli.Context) error) *cli.App {
+// GetBaseFlags returns flags common to all apps, e.g. for a command running
+// the app which accepts the same flags as the app itself.
+func GetBaseFlags() []cli.Flag {
+	return append([]cli.Flag{}, baseFlags...)
+}
+
+// SetupCrashReports applies the level of crash reports from preferences and
+// adds the summary of settings to local crash dumps.
+func SetupCrashReports(cfg *config.Config, pref *config.Preferences) {
+	pkgsentry.SetReportLevel(pref.Get(preferences.CrashReportsKey))
+	cfg.SetCrashSummary(func(w io.Writer) error {
+		return preferences.WriteSummary(w, pref)
+	})
+}
+
+// SetupProxy applies the proxy of API traffic from preferences. Invalid
+// proxy is only logged and proxy is then detected automatically.
+func SetupProxy(pref *config.Preferences) {
+	if err := sysproxy.SetMode(pref.Get(preferences.ProxyKey)); err != nil {
+		log.WithError(err).Error("Invalid proxy, using automatic detection")
+	}
+}
+
+// SetupTelemetry applies which groups of anonymous metrics can be sent.
+func SetupTelemetry(pref *config.Preferences) {
+	metrics.SetEnabledGroups(preferences.GetTelemetryGroups(pref))
+}
+
+func newApp(appName, usage string, extraFlags []cli.Flag, commands []cli.Command, run func(*cli.Context) error) *cli.App {
 	app := cli.NewApp()
 	app.Name = appName
 	app.Usage = usage
 	app.Version = constants.BuildVersion
-	app.Flags = append(baseFlags, extraFlags...) //nolint[gocritic]
+	app.Flags = append(append(baseFlags, profileFlags...), extraFlags...) //nolint[gocritic]
+	app.Commands = commands
+	app.Before = setupProfile
 	app.Action = run
 	return app
 }
+
+// setupProfile selects the instance before the app or any command creates
+// its config.
+func setupProfile(context *cli.Context) error {
+	dir := context.String("config-dir")
+	portable := context.Bool("portable")
+	if dir == "" {
+		if portableDir, ok := getPortableDir(portable); ok {
+			dir, portable = portableDir, true
+		}
+	}
+
+	if err := config.SetProfile(context.String("profile"), dir); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := config.SetPortable(portable); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if portable {
+		if !hasPortablePassphrase() {
+			return cli.NewExitError("portable mode needs a terminal to ask passphrase of credentials or "+
+				keychain.KeychainPassphraseEnv+" or "+keychain.KeychainKeyFileEnv+" set", 1)
+		}
+		keychain.SetPortable(config.GetPortableKeychainPath(), readPortablePassphrase)
+		log.WithField("dir", dir).Info("Running in portable mode")
+	}
+	if profile := config.GetProfile(); profile != "" {
+		log.WithField("profile", profile).Info("Using profile")
+	}
+	return nil
+}
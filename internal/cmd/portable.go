@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/keychain"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// getPortableDir returns the folder of portable mode next to the executable.
+// Portable mode is used when it is requested or when the folder exists, so
+// the app started from a USB stick without flags finds its data again. The
+// existing folder is not used without a source of the passphrase, e.g. when
+// the GUI is started from a file manager, as credentials could not be opened.
+func getPortableDir(requested bool) (string, bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		if requested {
+			log.WithError(err).Error("Cannot find executable for portable mode")
+		}
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	dir := filepath.Join(filepath.Dir(exe), config.PortableDirName)
+	if requested {
+		return dir, true
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", false
+	}
+	if !hasPortablePassphrase() {
+		log.WithField("dir", dir).Warn("Not using portable mode: no terminal to ask passphrase of credentials and neither " +
+			keychain.KeychainPassphraseEnv + " nor " + keychain.KeychainKeyFileEnv + " is set")
+		return "", false
+	}
+	return dir, true
+}
+
+// hasPortablePassphrase tells whether the passphrase of credentials of
+// portable mode is set by environment or can be asked in the terminal.
+func hasPortablePassphrase() bool {
+	if os.Getenv(keychain.KeychainPassphraseEnv) != "" || os.Getenv(keychain.KeychainKeyFileEnv) != "" {
+		return true
+	}
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// readPortablePassphrase asks for the passphrase of the credentials file of
+// portable mode in the terminal; new file needs the passphrase entered twice.
+// Keychain asks again when the passphrase does not decrypt the file.
+func readPortablePassphrase(newFile bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, errors.New("credentials of portable mode need a passphrase, run in terminal or set BRIDGE_KEYCHAIN_PASSPHRASE or BRIDGE_KEYCHAIN_KEY_FILE")
+	}
+
+	fmt.Print("Passphrase of credentials: ")
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+
+	if newFile {
+		fmt.Print("Repeat passphrase: ")
+		repeated, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, repeated) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
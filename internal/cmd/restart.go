@@ -88,6 +88,10 @@ type PanicHandler struct {
 	AppName string
 	Config  *config.Config
 	Err     *error // Pointer to error of cli action.
+
+	// Headless apps have no desktop to notify and are restarted by their
+	// service manager, so they only exit.
+	Headless bool
 }
 
 // HandlePanic should be called in defer to ensure restart of app after error.
@@ -98,6 +102,11 @@ func (ph *PanicHandler) HandlePanic() {
 	}
 
 	config.HandlePanic(ph.Config, fmt.Sprintf("Recover: %v", r))
+	if ph.Headless {
+		*ph.Err = cli.NewExitError("Panic", 255)
+		log.Error("Exiting after panic")
+		os.Exit(255)
+	}
 	frontend.HandlePanic(ph.AppName)
 
 	*ph.Err = cli.NewExitError("Panic and restart", 255)
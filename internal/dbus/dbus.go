@@ -0,0 +1,302 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package dbus exposes accounts, unread counts and new mail on the D-Bus
+// session bus for desktop integration, e.g. applets of GNOME or KDE and
+// scripts, so they need neither to parse logs nor to speak IMAP.
+package dbus
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	busName    = "ch.protonmail.Bridge"
+	objectPath = godbus.ObjectPath("/ch/protonmail/Bridge")
+	iface      = "ch.protonmail.Bridge"
+
+	// reconnectInterval is how often connection to the session bus is tried
+	// again, e.g. when Bridge starts before the desktop session.
+	reconnectInterval = time.Minute
+)
+
+var log = logrus.WithField("pkg", "dbus") //nolint[gochecknoglobals]
+
+// signals of the interface with names of their arguments.
+var signals = []introspect.Signal{ //nolint[gochecknoglobals]
+	{Name: "AccountChanged", Args: []introspect.Arg{{Name: "account", Type: "s"}}},
+	{Name: "UnreadChanged", Args: []introspect.Arg{{Name: "account", Type: "s"}, {Name: "unread", Type: "u"}}},
+	{Name: "NewMessage", Args: []introspect.Arg{
+		{Name: "account", Type: "s"},
+		{Name: "message", Type: "s"},
+		{Name: "sender", Type: "s"},
+		{Name: "subject", Type: "s"},
+	}},
+}
+
+type usersProvider interface {
+	GetUsers() []*users.User
+	GetUser(query string) (*users.User, error)
+}
+
+// Account is the state of an account, (ssasbbu) in D-Bus signature.
+type Account struct {
+	ID        string
+	Username  string
+	Addresses []string
+	Connected bool
+	Paused    bool
+	Unread    uint32
+}
+
+type service struct {
+	users usersProvider
+
+	lock   sync.Mutex
+	conn   *godbus.Conn
+	unread map[string]uint32
+
+	// emit sends the signal. It is replaced in tests.
+	emit func(name string, values ...interface{}) error
+}
+
+// Serve publishes the service on the session bus and emits signals for
+// events of accounts. When there is no session bus, e.g. on a headless
+// server, it keeps trying to connect. It never returns.
+func Serve(eventListener listener.Listener, users usersProvider) {
+	s := newService(users)
+	s.emit = s.emitOnBus
+
+	if err := s.connect(); err != nil {
+		log.WithError(err).Info("D-Bus session bus is not available")
+	}
+
+	s.watchEvents(eventListener)
+}
+
+func newService(users usersProvider) *service {
+	return &service{
+		users:  users,
+		unread: map[string]uint32{},
+	}
+}
+
+func (s *service) connect() error {
+	conn, err := godbus.SessionBusPrivate()
+	if err != nil {
+		return err
+	}
+	if err := s.publish(conn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+
+	log.WithField("name", getBusName()).Info("Serving D-Bus interface")
+	return nil
+}
+
+// getBusName returns the bus name of the selected profile, e.g.
+// ch.protonmail.Bridge.work, so each instance can be addressed.
+func getBusName() string {
+	if profile := config.GetProfile(); profile != "" {
+		return busName + "." + strings.ReplaceAll(profile, "-", "_")
+	}
+	return busName
+}
+
+func (s *service) publish(conn *godbus.Conn) error {
+	if err := conn.Auth(nil); err != nil {
+		return err
+	}
+	if err := conn.Hello(); err != nil {
+		return err
+	}
+
+	object := &busObject{s}
+	if err := conn.Export(object, objectPath, iface); err != nil {
+		return err
+	}
+	if err := conn.Export(introspect.NewIntrospectable(getIntrospection(object)), objectPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+
+	name := getBusName()
+	reply, err := conn.RequestName(name, godbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != godbus.RequestNameReplyPrimaryOwner {
+		return errors.New("name " + name + " is already taken")
+	}
+	return nil
+}
+
+func (s *service) isConnected() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.conn != nil
+}
+
+// emitOnBus emits the signal when connected. Failed emit means the bus is
+// gone, e.g. the desktop session ended, and connecting is tried again later.
+func (s *service) emitOnBus(name string, values ...interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	if err := s.conn.Emit(objectPath, iface+"."+name, values...); err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *service) watchEvents(eventListener listener.Listener) {
+	newMessageCh := make(chan string)
+	unreadCh := make(chan string)
+	accountCh := make(chan string)
+	eventListener.Add(events.NewMessageEvent, newMessageCh)
+	eventListener.Add(events.UnreadChangedEvent, unreadCh)
+	eventListener.Add(events.LogoutEvent, accountCh)
+	eventListener.Add(events.UserRefreshEvent, accountCh)
+
+	reconnect := time.NewTicker(reconnectInterval)
+	defer reconnect.Stop()
+
+	for {
+		var err error
+		select {
+		case ids := <-newMessageCh:
+			err = s.emitNewMessage(ids)
+		case userID := <-unreadCh:
+			err = s.emitUnreadChanged(userID)
+		case userID := <-accountCh:
+			err = s.emit("AccountChanged", userID)
+		case <-reconnect.C:
+			if !s.isConnected() {
+				if err := s.connect(); err != nil {
+					log.WithError(err).Debug("D-Bus session bus is still not available")
+				}
+			}
+		}
+		if err != nil {
+			log.WithError(err).Warn("Cannot emit D-Bus signal")
+		}
+	}
+}
+
+// emitNewMessage emits NewMessage for "userID:messageID" of the event.
+func (s *service) emitNewMessage(ids string) error {
+	parts := strings.SplitN(ids, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	user, err := s.users.GetUser(parts[0])
+	if err != nil {
+		return nil
+	}
+	message, err := user.GetMessageMetadata(parts[1])
+	if err != nil {
+		return err
+	}
+
+	var sender string
+	if message.Sender != nil {
+		sender = message.Sender.String()
+	}
+	return s.emit("NewMessage", user.ID(), message.ID, sender, message.Subject)
+}
+
+// emitUnreadChanged emits UnreadChanged only when the count really changed
+// as counts are updated by server also for other mailboxes than Inbox.
+func (s *service) emitUnreadChanged(userID string) error {
+	user, err := s.users.GetUser(userID)
+	if err != nil {
+		return nil
+	}
+	unread, err := user.GetUnreadCount()
+	if err != nil {
+		return err
+	}
+	if !s.setUnread(userID, uint32(unread)) {
+		return nil
+	}
+	return s.emit("UnreadChanged", userID, uint32(unread))
+}
+
+// setUnread remembers the count and returns whether it differs from the
+// last one.
+func (s *service) setUnread(userID string, unread uint32) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if last, ok := s.unread[userID]; ok && last == unread {
+		return false
+	}
+	s.unread[userID] = unread
+	return true
+}
+
+func getIntrospection(object *busObject) *introspect.Node {
+	return &introspect.Node{
+		Name: string(objectPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name:    iface,
+				Methods: introspect.Methods(object),
+				Signals: signals,
+			},
+		},
+	}
+}
+
+func getAccount(user *users.User) Account {
+	unread, err := user.GetUnreadCount()
+	if err != nil {
+		log.WithError(err).Debug("Cannot get unread count")
+	}
+	return Account{
+		ID:        user.ID(),
+		Username:  user.Username(),
+		Addresses: user.GetAddresses(),
+		Connected: user.IsConnected(),
+		Paused:    user.IsPaused(),
+		Unread:    uint32(unread),
+	}
+}
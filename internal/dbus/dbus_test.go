@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package dbus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUsers struct{}
+
+func (fakeUsers) GetUsers() []*users.User { return nil }
+
+func (fakeUsers) GetUser(query string) (*users.User, error) { return nil, errors.New("no user") }
+
+func TestIntrospection(t *testing.T) {
+	node := getIntrospection(&busObject{newService(fakeUsers{})})
+	require.Len(t, node.Interfaces, 2)
+
+	methods := map[string]string{}
+	for _, method := range node.Interfaces[1].Methods {
+		var args []string
+		for _, arg := range method.Args {
+			args = append(args, arg.Direction+" "+arg.Type)
+		}
+		methods[method.Name] = strings.Join(args, ", ")
+	}
+	require.Equal(t, map[string]string{
+		"GetAccounts":    "out a(ssasbbu)",
+		"GetAccount":     "in s, out (ssasbbu)",
+		"GetUnreadCount": "out u",
+	}, methods)
+	require.Equal(t, signals, node.Interfaces[1].Signals)
+	require.Equal(t, introspect.IntrospectData, node.Interfaces[0])
+}
+
+func TestSetUnread(t *testing.T) {
+	s := newService(fakeUsers{})
+	require.True(t, s.setUnread("user", 0))
+	require.False(t, s.setUnread("user", 0))
+	require.True(t, s.setUnread("user", 2))
+	require.True(t, s.setUnread("other", 2))
+	require.False(t, s.setUnread("user", 2))
+}
+
+func TestWatchEvents(t *testing.T) {
+	emitted := make(chan string)
+	s := newService(fakeUsers{})
+	s.emit = func(name string, values ...interface{}) error {
+		emitted <- name + " " + values[0].(string)
+		return nil
+	}
+
+	eventListener := listener.New()
+	go s.watchEvents(eventListener)
+
+	// Events of unknown users are skipped, the next one must be emitted.
+	require.Eventually(t, func() bool {
+		eventListener.Emit(events.NewMessageEvent, "unknown:msg")
+		eventListener.Emit(events.LogoutEvent, "user")
+		select {
+		case signal := <-emitted:
+			require.Equal(t, "AccountChanged user", signal)
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
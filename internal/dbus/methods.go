@@ -0,0 +1,61 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package dbus
+
+import (
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// busObject has methods of the interface. All exported methods are
+// callable over D-Bus, so helpers belong to service.
+type busObject struct {
+	s *service
+}
+
+// GetAccounts returns state of all accounts.
+func (o *busObject) GetAccounts() ([]Account, *godbus.Error) {
+	accounts := []Account{}
+	for _, user := range o.s.users.GetUsers() {
+		accounts = append(accounts, getAccount(user))
+	}
+	return accounts, nil
+}
+
+// GetAccount returns state of the account by its ID, username or address.
+func (o *busObject) GetAccount(account string) (Account, *godbus.Error) {
+	user, err := o.s.users.GetUser(account)
+	if err != nil {
+		return Account{}, godbus.MakeFailedError(err)
+	}
+	return getAccount(user), nil
+}
+
+// GetUnreadCount returns the number of unread messages in Inbox of all
+// connected accounts, e.g. for a badge of a tray icon.
+func (o *busObject) GetUnreadCount() (uint32, *godbus.Error) {
+	var total uint32
+	for _, user := range o.s.users.GetUsers() {
+		if !user.IsConnected() {
+			continue
+		}
+		if unread, err := user.GetUnreadCount(); err == nil {
+			total += uint32(unread)
+		}
+	}
+	return total, nil
+}
@@ -19,6 +19,7 @@
 package events
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
@@ -40,6 +41,14 @@ const (
 	NoActiveKeyForRecipientEvent = "noActiveKeyForRecipient"
 	UpgradeApplicationEvent      = "upgradeApplication"
 	TLSCertIssue                 = "tlsCertPinningIssue"
+	TLSExceptionUsedEvent        = "tlsExceptionUsed"
+	ReadReceiptRequestEvent      = "readReceiptRequest"
+	SyncProgressEvent            = "syncProgress"
+	NewMessageEvent              = "newMessage"
+	UnreadChangedEvent           = "unreadChanged"
+	SyncFinishedEvent            = "syncFinished"
+	MessageSentEvent             = "messageSent"
+	SendFailedEvent              = "sendFailed"
 
 	// LogoutEventTimeout is the minimum time to permit between logout events being sent.
 	LogoutEventTimeout = 3 * time.Minute
@@ -49,5 +58,28 @@ const (
 func SetupEvents(listener listener.Listener) {
 	listener.SetLimit(LogoutEvent, LogoutEventTimeout)
 	listener.SetBuffer(TLSCertIssue)
+	listener.SetBuffer(TLSExceptionUsedEvent)
 	listener.SetBuffer(ErrorEvent)
 }
+
+// SendResult is the data of MessageSentEvent and SendFailedEvent. It is too
+// rich for a plain string, so the event carries it as JSON.
+type SendResult struct {
+	UserID  string
+	From    string
+	To      []string
+	Subject string
+	Error   string `json:",omitempty"`
+}
+
+// String returns the JSON data of the event.
+func (r SendResult) String() string {
+	b, _ := json.Marshal(r) //nolint[errcheck] Strings and slices always marshal.
+	return string(b)
+}
+
+// ParseSendResult returns the result from the data of the event.
+func ParseSendResult(data string) (result SendResult, err error) {
+	err = json.Unmarshal([]byte(data), &result)
+	return
+}
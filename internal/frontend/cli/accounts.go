@@ -18,11 +18,14 @@
 package cli
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
 	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/abiosoft/ishell"
 )
 
@@ -33,6 +36,12 @@ func (f *frontendCLI) listAccounts(c *ishell.Context) {
 		connected := "disconnected"
 		if user.IsConnected() {
 			connected = "connected"
+			if progress := user.GetSyncProgress(); progress.Phase != store.SyncPhaseIdle {
+				connected = "syncing"
+				if progress.Total > 0 {
+					connected = fmt.Sprintf("syncing %d%%", 100*progress.Done/progress.Total)
+				}
+			}
 		}
 		mode := "split"
 		if user.IsCombinedAddressMode() {
@@ -54,6 +63,11 @@ func (f *frontendCLI) showAccountInfo(c *ishell.Context) {
 		return
 	}
 
+	if progress := user.GetSyncProgress(); progress.Phase != store.SyncPhaseIdle {
+		f.Println(bold("Synchronization: ") + formatSyncProgress(progress))
+		f.Println("")
+	}
+
 	if user.IsCombinedAddressMode() {
 		f.showAccountAddressInfo(user, user.GetPrimaryAddress())
 	} else {
@@ -84,10 +98,13 @@ func (f *frontendCLI) showAccountAddressInfo(user types.User, address string) {
 		user.GetBridgePassword(),
 		smtpSecurity,
 	)
+	if smtpsPort := f.preferences.GetInt(preferences.SMTPSPortKey); smtpsPort != 0 {
+		f.Printf("SMTPS port: %d (SSL)\n", smtpsPort)
+	}
 	f.Println("")
 }
 
-func (f *frontendCLI) loginAccount(c *ishell.Context) { // nolint[funlen]
+func (f *frontendCLI) loginAccount(c *ishell.Context) {
 	f.ShowPrompt(false)
 	defer f.ShowPrompt(true)
 
@@ -108,37 +125,43 @@ func (f *frontendCLI) loginAccount(c *ishell.Context) { // nolint[funlen]
 		f.Println("Username:", loginName)
 	}
 
-	password := f.readStringInAttempts("Password", c.ReadPassword, isNotEmpty)
+	f.login(loginName)
+}
+
+// login asks for password and 2FA code of the account, adds it to Bridge and
+// returns it or nil when the login was not successful.
+func (f *frontendCLI) login(loginName string) types.User {
+	password := f.readStringInAttempts("Password", f.ReadPassword, isNotEmpty)
 	if password == "" {
-		return
+		return nil
 	}
 
 	f.Println("Authenticating ... ")
 	client, auth, err := f.bridge.Login(loginName, password)
 	if err != nil {
 		f.processAPIError(err)
-		return
+		return nil
 	}
 
 	if auth.HasTwoFactor() {
-		twoFactor := f.readStringInAttempts("Two factor code", c.ReadLine, isNotEmpty)
+		twoFactor := f.readStringInAttempts("Two factor code", f.ReadLine, isNotEmpty)
 		if twoFactor == "" {
-			return
+			return nil
 		}
 
 		err = client.Auth2FA(twoFactor, auth)
 		if err != nil {
 			f.processAPIError(err)
-			return
+			return nil
 		}
 	}
 
 	mailboxPassword := password
 	if auth.HasMailboxPassword() {
-		mailboxPassword = f.readStringInAttempts("Mailbox password", c.ReadPassword, isNotEmpty)
+		mailboxPassword = f.readStringInAttempts("Mailbox password", f.ReadPassword, isNotEmpty)
 	}
 	if mailboxPassword == "" {
-		return
+		return nil
 	}
 
 	f.Println("Adding account ...")
@@ -146,10 +169,11 @@ func (f *frontendCLI) loginAccount(c *ishell.Context) { // nolint[funlen]
 	if err != nil {
 		log.WithField("username", loginName).WithError(err).Error("Login was unsuccessful")
 		f.Println("Adding account was unsuccessful:", err)
-		return
+		return nil
 	}
 
 	f.Printf("Account %s was added successfully.\n", bold(user.Username()))
+	return user
 }
 
 func (f *frontendCLI) logoutAccount(c *ishell.Context) {
@@ -217,3 +241,22 @@ func (f *frontendCLI) changeMode(c *ishell.Context) {
 	}
 	f.Printf("Address mode for account %s changed to %s\n", user.Username(), newMode)
 }
+
+// formatSyncProgress returns human readable description of the sync progress.
+func formatSyncProgress(progress store.SyncProgress) string {
+	switch progress.Phase {
+	case store.SyncPhasePreparing:
+		return "preparing"
+	case store.SyncPhaseDeleting:
+		return "removing messages deleted on server"
+	}
+
+	description := fmt.Sprintf("%d of %d messages", progress.Done, progress.Total)
+	if progress.Folder != "" {
+		description += " in " + progress.Folder
+	}
+	if progress.ETA > 0 {
+		description += fmt.Sprintf(", about %s remaining", progress.ETA.Round(time.Second))
+	}
+	return description
+}
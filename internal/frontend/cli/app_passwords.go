@@ -0,0 +1,114 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package cli
+
+import (
+	"github.com/abiosoft/ishell"
+)
+
+func (f *frontendCLI) listAppPasswords(c *ishell.Context) {
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	names := user.GetAppPasswordNames()
+	if len(names) == 0 {
+		f.Printf("Account %s has no app passwords.\n", bold(user.Username()))
+		return
+	}
+
+	f.Println(bold("App passwords of " + user.Username()))
+	for _, name := range names {
+		f.Println(name)
+	}
+}
+
+func (f *frontendCLI) addAppPassword(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	name := f.readStringInAttempts("Name (e.g. device)", c.ReadLine, isNotEmpty)
+	if name == "" {
+		return
+	}
+
+	password, err := user.AddAppPassword(name)
+	if err != nil {
+		f.printAndLogError("Cannot add app password:", err)
+		return
+	}
+
+	f.Printf("App password %s for account %s: %s\n", bold(name), user.Username(), password)
+}
+
+func (f *frontendCLI) addBearerToken(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	name := f.readStringInAttempts("Name (e.g. device)", c.ReadLine, isNotEmpty)
+	if name == "" {
+		return
+	}
+
+	token, err := user.AddBearerToken(name)
+	if err != nil {
+		f.printAndLogError("Cannot add OAuth token:", err)
+		return
+	}
+
+	f.Printf("OAuth token %s for account %s: %s\n", bold(name), user.Username(), token)
+}
+
+func (f *frontendCLI) removeAppPassword(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	if len(user.GetAppPasswordNames()) == 0 {
+		f.Printf("Account %s has no app passwords.\n", bold(user.Username()))
+		return
+	}
+
+	name := f.readStringInAttempts("Name of app password to revoke", c.ReadLine, isNotEmpty)
+	if name == "" {
+		return
+	}
+
+	if !f.yesNoQuestion("Are you sure you want to revoke app password " + bold(name)) {
+		return
+	}
+	if err := user.RemoveAppPassword(name); err != nil {
+		f.printAndLogError("Cannot revoke app password:", err)
+		return
+	}
+	f.Printf("App password %s was revoked\n", name)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/abiosoft/ishell"
+)
+
+func (f *frontendCLI) exportMessages(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	format := f.readStringInAttempts("Format (mbox or maildir)", c.ReadLine, store.IsExportFormat)
+	if format == "" {
+		return
+	}
+
+	dir := f.readStringInAttempts("Directory", c.ReadLine, isNotEmpty)
+	if dir == "" {
+		return
+	}
+
+	f.Print("Mailbox (e.g. INBOX or Folders/Work, empty for all): ")
+	mailboxName := strings.TrimSpace(c.ReadLine())
+
+	f.Println("Exporting messages of", bold(user.Username()), "...")
+	exported, err := user.Export(dir, format, mailboxName)
+	if err != nil {
+		f.printAndLogError("Export was not complete:", err)
+	}
+	f.Printf("Exported %d messages to %s.\n", exported, dir)
+}
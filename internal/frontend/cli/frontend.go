@@ -21,6 +21,7 @@ package cli
 import (
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 
@@ -102,6 +103,18 @@ func New( //nolint[funlen]
 		Help: "allow or disallow bridge to securely connect to proton via a third party when it is being blocked",
 		Func: fe.toggleAllowProxy,
 	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "network-profile",
+		Help:      "limit bandwidth, parallel transfers and polling, e.g. on metered connection. Use profile name as parameter. (alias: net)",
+		Aliases:   []string{"net"},
+		Func:      fe.changeNetworkProfile,
+		Completer: fe.completeNetworkProfiles,
+	})
+	changeCmd.AddCmd(&ishell.Cmd{Name: "crash-reports",
+		Help:      "choose what is sent about crashes: off, anonymous or full. Local crash dumps are written always. (alias: crash)",
+		Aliases:   []string{"crash"},
+		Func:      fe.changeCrashReports,
+		Completer: fe.completeCrashReports,
+	})
 	changeCmd.AddCmd(&ishell.Cmd{Name: "smtp-security",
 		Help:    "change port numbers of IMAP and SMTP servers.(alias: ssl, starttls)",
 		Aliases: []string{"ssl", "starttls"},
@@ -145,6 +158,11 @@ func New( //nolint[funlen]
 	})
 
 	// Account commands.
+	fe.AddCmd(&ishell.Cmd{Name: "setup",
+		Help:    "guided setup of account: login, address mode and configuration of email client. (alias: wizard)",
+		Aliases: []string{"wizard"},
+		Func:    fe.setup,
+	})
 	fe.AddCmd(&ishell.Cmd{Name: "list",
 		Help:    "print the list of accounts. (aliases: l, ls)",
 		Func:    fe.noAccountWrapper(fe.listAccounts),
@@ -175,6 +193,74 @@ func New( //nolint[funlen]
 		Completer: fe.completeUsernames,
 	})
 
+	appPasswordCmd := &ishell.Cmd{Name: "app-password",
+		Help:    "manage additional named passwords of account, e.g. one per device. (alias: app)",
+		Aliases: []string{"app"},
+	}
+	appPasswordCmd.AddCmd(&ishell.Cmd{Name: "list",
+		Help:      "print names of app passwords. Use index or account name as parameter. (aliases: l, ls)",
+		Func:      fe.noAccountWrapper(fe.listAppPasswords),
+		Aliases:   []string{"l", "ls"},
+		Completer: fe.completeUsernames,
+	})
+	appPasswordCmd.AddCmd(&ishell.Cmd{Name: "add",
+		Help:      "generate new app password. Use index or account name as parameter. (alias: a)",
+		Func:      fe.noAccountWrapper(fe.addAppPassword),
+		Aliases:   []string{"a"},
+		Completer: fe.completeUsernames,
+	})
+	appPasswordCmd.AddCmd(&ishell.Cmd{Name: "token",
+		Help:      "generate new bearer token for clients using OAUTHBEARER or XOAUTH2; it is listed and revoked as app password. Use index or account name as parameter.",
+		Func:      fe.noAccountWrapper(fe.addBearerToken),
+		Completer: fe.completeUsernames,
+	})
+	appPasswordCmd.AddCmd(&ishell.Cmd{Name: "remove",
+		Help:      "revoke app password. Use index or account name as parameter. (aliases: rm, revoke)",
+		Func:      fe.noAccountWrapper(fe.removeAppPassword),
+		Aliases:   []string{"rm", "revoke"},
+		Completer: fe.completeUsernames,
+	})
+	fe.AddCmd(appPasswordCmd)
+	fe.AddCmd(&ishell.Cmd{Name: "search",
+		Help:      "search messages in the local search index. Use index or account name as parameter. (alias: s)",
+		Func:      fe.noAccountWrapper(fe.searchMessages),
+		Aliases:   []string{"s"},
+		Completer: fe.completeUsernames,
+	})
+	storageCmd := &ishell.Cmd{Name: "storage",
+		Help:    "manage local storage of account. (alias: st)",
+		Aliases: []string{"st"},
+	}
+	storageCmd.AddCmd(&ishell.Cmd{Name: "usage",
+		Help:      "print disk usage of local storage. Use index or account name as parameter. (alias: u)",
+		Func:      fe.noAccountWrapper(fe.showStorageUsage),
+		Aliases:   []string{"u"},
+		Completer: fe.completeUsernames,
+	})
+	storageCmd.AddCmd(&ishell.Cmd{Name: "clear",
+		Help:      "remove locally cached message bodies and attachments, metadata are kept. Use index or account name as parameter. (alias: c)",
+		Func:      fe.noAccountWrapper(fe.clearBodyCache),
+		Aliases:   []string{"c"},
+		Completer: fe.completeUsernames,
+	})
+	storageCmd.AddCmd(&ishell.Cmd{Name: "compact",
+		Help:      "rewrite local database to reclaim free space. Use index or account name as parameter.",
+		Func:      fe.noAccountWrapper(fe.compactStore),
+		Completer: fe.completeUsernames,
+	})
+	storageCmd.AddCmd(&ishell.Cmd{Name: "rotate-keys",
+		Help:      "re-encrypt locally cached data and search index with new keys, e.g. when a password leaked. Use index or account name as parameter.",
+		Func:      fe.noAccountWrapper(fe.rotateStoreKeys),
+		Completer: fe.completeUsernames,
+	})
+	fe.AddCmd(storageCmd)
+	fe.AddCmd(&ishell.Cmd{Name: "export",
+		Help:      "export messages from local storage to mbox or Maildir. Use index or account name as parameter. (alias: ex)",
+		Func:      fe.noAccountWrapper(fe.exportMessages),
+		Aliases:   []string{"ex"},
+		Completer: fe.completeUsernames,
+	})
+
 	// System commands.
 	fe.AddCmd(&ishell.Cmd{Name: "restart",
 		Help: "restart the bridge.",
@@ -186,6 +272,7 @@ func New( //nolint[funlen]
 		fe.watchEvents()
 	}()
 	fe.eventListener.RetryEmit(events.TLSCertIssue)
+	fe.eventListener.RetryEmit(events.TLSExceptionUsedEvent)
 	fe.eventListener.RetryEmit(events.ErrorEvent)
 	return fe
 }
@@ -198,6 +285,7 @@ func (f *frontendCLI) watchEvents() {
 	addressChangedLogoutCh := f.getEventChannel(events.AddressChangedLogoutEvent)
 	logoutCh := f.getEventChannel(events.LogoutEvent)
 	certIssue := f.getEventChannel(events.TLSCertIssue)
+	tlsExceptionCh := f.getEventChannel(events.TLSExceptionUsedEvent)
 	for {
 		select {
 		case errorDetails := <-errorCh:
@@ -218,6 +306,8 @@ func (f *frontendCLI) watchEvents() {
 			f.notifyLogout(user.Username())
 		case <-certIssue:
 			f.notifyCertIssue()
+		case host := <-tlsExceptionCh:
+			f.notifyTLSExceptionUsed(host)
 		}
 	}
 }
@@ -257,6 +347,12 @@ func (f *frontendCLI) Loop(credentialsError error) error {
       jgs   [ ]                                        [ ]
     ~~^_~^~/   \~^-~^~ _~^-~_^~-^~_^~~-^~_~^~-~_~-^~_^/   \~^ ~~_ ^
 `)
+	if f.preferences.GetBool(preferences.FirstStartCLIKey) {
+		f.preferences.SetBool(preferences.FirstStartCLIKey, false)
+		if len(f.bridge.GetUsers()) == 0 && f.yesNoQuestion("No account is set up yet. Do you want to start the setup") {
+			f.runSetup()
+		}
+	}
 	f.Run()
 	return nil
 }
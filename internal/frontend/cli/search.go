@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/abiosoft/ishell"
+)
+
+const maxSearchResults = 20
+
+func (f *frontendCLI) searchMessages(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	query := f.readStringInAttempts("Query (e.g. invoice subject:paid)", c.ReadLine, isNotEmpty)
+	if query == "" {
+		return
+	}
+
+	msgs, err := user.SearchMessages(query, maxSearchResults)
+	if err != nil {
+		f.printAndLogError("Cannot search messages (is search_index preference turned on?):", err)
+		return
+	}
+
+	if len(msgs) == 0 {
+		f.Println("No message found.")
+		return
+	}
+
+	for _, msg := range msgs {
+		from := ""
+		if msg.Sender != nil {
+			from = msg.Sender.Address
+		}
+		f.Printf("%s  %s  %s\n",
+			time.Unix(msg.Time, 0).Format("2006-01-02 15:04"),
+			from,
+			bold(strings.TrimSpace(msg.Subject)),
+		)
+	}
+}
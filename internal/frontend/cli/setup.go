@@ -0,0 +1,261 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/internal/frontend/autoconfig"
+	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/abiosoft/ishell"
+)
+
+// Email clients with configuration printed by the setup.
+const (
+	clientThunderbird = "thunderbird"
+	clientMutt        = "mutt"
+	clientAppleMail   = "apple-mail"
+	clientOther       = "other"
+)
+
+// clientSettings holds what an email client needs to connect to Bridge.
+type clientSettings struct {
+	address  string
+	password string
+	host     string
+	imapPort int
+	smtpPort int
+	smtpSSL  bool
+}
+
+func (f *frontendCLI) setup(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	f.runSetup()
+}
+
+// runSetup guides the user through login, choice of address mode and
+// configuration of email client so nobody has to guess port numbers.
+func (f *frontendCLI) runSetup() {
+	f.Println(bold("Step 1 of 3: Login"))
+	loginName := f.readStringInAttempts("Username", f.ReadLine, isNotEmpty)
+	if loginName == "" {
+		f.notifySetupNotFinished()
+		return
+	}
+	user := f.login(loginName)
+	if user == nil {
+		f.notifySetupNotFinished()
+		return
+	}
+
+	f.Println()
+	f.Println(bold("Step 2 of 3: Address mode"))
+	if !f.setupAddressMode(user) {
+		f.notifySetupNotFinished()
+		return
+	}
+
+	f.Println()
+	f.Println(bold("Step 3 of 3: Email client"))
+	f.setupEmailClient(user)
+
+	f.Println("Messages are being synchronized now, the email client shows all of them")
+	f.Println("when it finishes. Use `list` to see the progress.")
+}
+
+func (f *frontendCLI) notifySetupNotFinished() {
+	f.Println("Setup was not finished. Use `setup` to start it again.")
+}
+
+// setupAddressMode lets the user choose address mode when the account has
+// more addresses. It returns false when the choice was not made.
+func (f *frontendCLI) setupAddressMode(user types.User) bool {
+	if len(user.GetAddresses()) < 2 {
+		f.Println("The account has one address, nothing to choose.")
+		return true
+	}
+
+	f.Println("The account has more addresses:", strings.Join(user.GetAddresses(), ", "))
+	f.Println("* combined: one mailbox with mail of all addresses and one set of credentials")
+	f.Println("* split: each address is a separate account in the email client")
+
+	mode := f.readChoice("Address mode", []string{"combined", "split"})
+	if mode == "" {
+		return false
+	}
+	if (mode == "split") == !user.IsCombinedAddressMode() {
+		return true
+	}
+	if err := user.SwitchAddressMode(); err != nil {
+		f.printAndLogError("Cannot switch address mode:", err)
+		return false
+	}
+	f.Printf("Address mode for account %s changed to %s\n", user.Username(), mode)
+	return true
+}
+
+// setupEmailClient prints configuration of the chosen email client for each
+// address the client will use.
+func (f *frontendCLI) setupEmailClient(user types.User) {
+	client := f.readChoice("Email client", []string{clientThunderbird, clientMutt, clientAppleMail, clientOther})
+	if client == "" {
+		client = clientOther
+	}
+
+	addresses := user.GetAddresses()
+	if user.IsCombinedAddressMode() {
+		addresses = []string{user.GetPrimaryAddress()}
+	}
+
+	if client == clientAppleMail && f.autoconfigAppleMail(user, len(addresses)) {
+		return
+	}
+
+	for _, address := range addresses {
+		settings := f.getClientSettings(user, address)
+		f.Println()
+		switch client {
+		case clientThunderbird:
+			f.Print(thunderbirdSettings(settings))
+		case clientMutt:
+			f.Print(muttSettings(settings))
+		case clientAppleMail:
+			f.Print(appleMailSettings(settings))
+		default:
+			f.showAccountAddressInfo(user, address)
+		}
+	}
+	f.Println()
+	f.Println("Use `info` to print the settings again.")
+}
+
+// autoconfigAppleMail configures Apple Mail automatically when it is
+// available and the user wants it. It returns whether it was configured.
+func (f *frontendCLI) autoconfigAppleMail(user types.User, addressCount int) bool {
+	if len(autoconfig.Available()) == 0 || !f.yesNoQuestion("Configure Apple Mail automatically") {
+		return false
+	}
+
+	imapPort := f.preferences.GetInt(preferences.IMAPPortKey)
+	smtpPort := f.preferences.GetInt(preferences.SMTPPortKey)
+	smtpSSL := f.preferences.GetBool(preferences.SMTPSSLKey)
+	for _, autoConf := range autoconfig.Available() {
+		for addressIndex := 0; addressIndex < addressCount; addressIndex++ {
+			if err := autoConf.Configure(imapPort, smtpPort, false, smtpSSL, user, addressIndex); err != nil {
+				f.printAndLogError("Automatic configuration failed: ", err)
+				return false
+			}
+		}
+	}
+
+	f.Println("Confirm the configuration profile in System Preferences to finish it.")
+	return true
+}
+
+func (f *frontendCLI) getClientSettings(user types.User, address string) clientSettings {
+	return clientSettings{
+		address:  address,
+		password: user.GetBridgePassword(),
+		host:     bridge.Host,
+		imapPort: f.preferences.GetInt(preferences.IMAPPortKey),
+		smtpPort: f.preferences.GetInt(preferences.SMTPPortKey),
+		smtpSSL:  f.preferences.GetBool(preferences.SMTPSSLKey),
+	}
+}
+
+func (s clientSettings) smtpSecurity(ssl, starttls string) string {
+	if s.smtpSSL {
+		return ssl
+	}
+	return starttls
+}
+
+// thunderbirdSettings returns values for the manual configuration dialog of
+// Thunderbird.
+func thunderbirdSettings(s clientSettings) string {
+	return fmt.Sprintf(`Thunderbird: choose New > Existing Mail Account, fill in your name,
+%[1]s and the password below, click Configure manually and set:
+  Incoming server: IMAP, hostname %[3]s, port %[4]d, STARTTLS, Normal password
+  Outgoing server: SMTP, hostname %[3]s, port %[5]d, %[6]s, Normal password
+  Username:        %[1]s
+  Password:        %[2]s
+Confirm the security exception for the certificate of Bridge when asked.
+`,
+		s.address, s.password, s.host, s.imapPort, s.smtpPort, s.smtpSecurity("SSL/TLS", "STARTTLS"),
+	)
+}
+
+// muttSettings returns lines of muttrc.
+func muttSettings(s clientSettings) string {
+	smtpURL := &url.URL{
+		Scheme: s.smtpSecurity("smtps", "smtp"),
+		User:   url.User(s.address),
+		Host:   net.JoinHostPort(s.host, strconv.Itoa(s.smtpPort)),
+		Path:   "/",
+	}
+	imapURL := &url.URL{
+		Scheme: "imap",
+		Host:   net.JoinHostPort(s.host, strconv.Itoa(s.imapPort)),
+		Path:   "/",
+	}
+
+	return fmt.Sprintf(`# mutt: add to ~/.muttrc and accept the certificate of Bridge on first connection
+set from = "%[1]s"
+set imap_user = "%[1]s"
+set imap_pass = "%[2]s"
+set folder = "%[3]s"
+set spoolfile = "+INBOX"
+set smtp_url = "%[4]s"
+set smtp_pass = "%[2]s"
+set ssl_starttls = yes
+set ssl_force_tls = yes
+set certificate_file = "~/.mutt/certificates"
+`,
+		s.address, s.password, imapURL, smtpURL,
+	)
+}
+
+// appleMailSettings returns values for manual configuration of Apple Mail.
+func appleMailSettings(s clientSettings) string {
+	settings := fmt.Sprintf(`Apple Mail: choose Mail > Add Account > Other Mail Account, fill in your name,
+%[1]s and the password below and set:
+  Account type:         IMAP
+  Incoming mail server: %[3]s
+  Outgoing mail server: %[3]s
+  User name:            %[1]s
+  Password:             %[2]s
+Then in Preferences > Accounts > Server Settings turn off Automatically manage
+connection settings and set IMAP port %[4]d with TLS and SMTP port %[5]d with TLS.
+Trust the certificate of Bridge when asked.
+`,
+		s.address, s.password, s.host, s.imapPort, s.smtpPort,
+	)
+	if !s.smtpSSL && runtime.GOOS == "darwin" {
+		settings += "Apple Mail on macOS 10.15 and newer needs SSL for SMTP, use `change smtp-security`.\n"
+	}
+	return settings
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/abiosoft/ishell"
+)
+
+func (f *frontendCLI) showStorageUsage(c *ishell.Context) {
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	usage, err := user.GetDiskUsage()
+	if err != nil {
+		f.printAndLogError("Cannot get disk usage:", err)
+		return
+	}
+
+	limit, attLimit := "turned off", "turned off"
+	if usage.BodyCacheLimit > 0 {
+		limit = formatSize(usage.BodyCacheLimit)
+	}
+	if usage.AttachmentCacheLimit > 0 {
+		attLimit = formatSize(usage.AttachmentCacheLimit)
+	}
+
+	f.Printf("Account %s:\n", bold(user.Username()))
+	f.Printf("  Metadata database:  %s (%s reclaimable by compaction)\n", formatSize(usage.DatabaseSize), formatSize(usage.DatabaseFree))
+	f.Printf("  Body cache:         %s in %d messages (limit %s)\n", formatSize(usage.BodyCacheSize), usage.CachedBodies, limit)
+	f.Printf("  Attachment cache:   %s in %d attachments (limit %s)\n", formatSize(usage.AttachmentCacheSize), usage.CachedAttachments, attLimit)
+}
+
+func (f *frontendCLI) clearBodyCache(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	if !f.yesNoQuestion("Are you sure you want to clear cached message bodies and attachments of " + bold(user.Username())) {
+		return
+	}
+
+	if err := user.ClearBodyCache(); err != nil {
+		f.printAndLogError("Cannot clear body cache:", err)
+		return
+	}
+	f.Println("Body and attachment caches cleared.")
+}
+
+func (f *frontendCLI) compactStore(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	f.Println("Compacting database of", bold(user.Username()), "...")
+	reclaimed, err := user.CompactStore()
+	if err != nil {
+		f.printAndLogError("Cannot compact database:", err)
+		return
+	}
+	f.Println("Database compacted, reclaimed", formatSize(reclaimed)+".")
+}
+
+func (f *frontendCLI) rotateStoreKeys(c *ishell.Context) {
+	f.ShowPrompt(false)
+	defer f.ShowPrompt(true)
+
+	user := f.askUserByIndexOrName(c)
+	if user == nil {
+		return
+	}
+
+	if !f.yesNoQuestion("Are you sure you want to re-encrypt local storage of " + bold(user.Username()) + " with new keys") {
+		return
+	}
+
+	if err := user.RotateStoreKeys(); err != nil {
+		f.printAndLogError("Cannot rotate keys:", err)
+		return
+	}
+	f.Println("Rotation of keys started, local storage is re-encrypted in the background.")
+}
+
+// formatSize returns human readable size of `bytes`.
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	size, prefix := float64(bytes)/unit, "KMGT"
+	for i := 0; i < len(prefix); i++ {
+		if size < unit || i == len(prefix)-1 {
+			return fmt.Sprintf("%.1f %ciB", size, prefix[i])
+		}
+		size /= unit
+	}
+	return ""
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/pkg/ports"
+	"github.com/ProtonMail/proton-bridge/pkg/sentry"
 	"github.com/abiosoft/ishell"
 )
 
@@ -144,6 +145,45 @@ func (f *frontendCLI) toggleAllowProxy(c *ishell.Context) {
 	}
 }
 
+func (f *frontendCLI) changeNetworkProfile(c *ishell.Context) {
+	profile := f.bridge.GetNetworkProfile()
+	if len(c.Args) == 0 {
+		f.Println("Current network profile:", bold(profile.Name))
+		f.Println("Available profiles:", strings.Join(preferences.GetNetworkProfileNames(), ", "))
+		return
+	}
+
+	if err := f.bridge.SetNetworkProfile(c.Args[0]); err != nil {
+		f.printAndLogError(err)
+		return
+	}
+	f.Println("Network profile changed to", bold(c.Args[0]))
+}
+
+func (f *frontendCLI) completeNetworkProfiles(args []string) []string {
+	return preferences.GetNetworkProfileNames()
+}
+
+func (f *frontendCLI) changeCrashReports(c *ishell.Context) {
+	if len(c.Args) == 0 {
+		f.Println("Current crash reports:", bold(sentry.GetReportLevel()))
+		f.Println("Available levels:", strings.Join(sentry.GetReportLevels(), ", "))
+		f.Println("Crash dumps to attach to bug reports are stored in\n\n ", f.config.GetLogDir())
+		return
+	}
+
+	if _, err := preferences.SetSetting(f.preferences, "app.crash_reports", c.Args[0]); err != nil {
+		f.printAndLogError(err)
+		return
+	}
+	sentry.SetReportLevel(c.Args[0])
+	f.Println("Crash reports changed to", bold(c.Args[0]))
+}
+
+func (f *frontendCLI) completeCrashReports(args []string) []string {
+	return sentry.GetReportLevels()
+}
+
 func (f *frontendCLI) isPortFree(port string) bool {
 	port = strings.Replace(port, ":", "", -1)
 	if port == "" || port == currentPort {
@@ -32,6 +32,9 @@ func (f *frontendCLI) checkUpdates(c *ishell.Context) {
 		f.checkInternetConnection(c)
 		return
 	}
+	if report := f.updates.GetSignatureReport(); report != nil {
+		f.Println("Version information", report)
+	}
 	if isUpToDate {
 		f.Println("Your version is up to date.")
 	} else {
@@ -63,6 +63,28 @@ func (f *frontendCLI) readStringInAttempts(title string, readFunc func() string,
 	return
 }
 
+// readChoice asks for one of choices, the first one is used when the answer
+// is empty. It returns empty string after too many wrong answers.
+func (f *frontendCLI) readChoice(title string, choices []string) string {
+	f.Printf("%s (%s) [%s]: ", title, strings.Join(choices, "/"), choices[0])
+	for i := 0; ; i++ {
+		answer := strings.ToLower(strings.TrimSpace(f.ReadLine()))
+		if answer == "" {
+			return choices[0]
+		}
+		for _, choice := range choices {
+			if answer == choice {
+				return choice
+			}
+		}
+		if i >= maxInputRepeat {
+			f.Println("Too many attempts")
+			return ""
+		}
+		f.Printf("Please choose one of %s: ", strings.Join(choices, ", "))
+	}
+}
+
 func (f *frontendCLI) printAndLogError(args ...interface{}) {
 	log.Error(args...)
 	f.Println(args...)
@@ -121,3 +143,9 @@ Recommendation:
   a different network to access ProtonMail.
 `)
 }
+
+func (f *frontendCLI) notifyTLSExceptionUsed(host string) {
+	f.Printf("Warning: connection to %s is trusted by a TLS exception, not by pinned keys.\n", host)
+	f.Println("The network operator can read and change the traffic. Remove the exception")
+	f.Println("by `bridge tls-exceptions remove` when it is no longer needed.")
+}
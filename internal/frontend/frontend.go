@@ -23,6 +23,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/cli"
 	cliie "github.com/ProtonMail/proton-bridge/internal/frontend/cli-ie"
+	"github.com/ProtonMail/proton-bridge/internal/frontend/grpc"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/qt"
 	qtie "github.com/ProtonMail/proton-bridge/internal/frontend/qt-ie"
 	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
@@ -51,7 +52,7 @@ func HandlePanic(appName string) {
 	_ = notify.Push("Fatal Error", "The "+appName+" has encountered a fatal error. ", "/frontend/icon/icon.png", notificator.UR_CRITICAL)
 }
 
-// New returns initialized frontend based on `frontendType`, which can be `cli` or `qt`.
+// New returns initialized frontend based on `frontendType`, which can be `cli`, `grpc` or `qt`.
 func New(
 	version,
 	buildVersion,
@@ -85,6 +86,8 @@ func new(
 	switch frontendType {
 	case "cli":
 		return cli.New(panicHandler, config, preferences, eventListener, updates, bridge)
+	case "grpc":
+		return grpc.New(version, buildVersion, panicHandler, config, preferences, eventListener, updates, bridge, noEncConfirmator)
 	default:
 		return qt.New(version, buildVersion, showWindowOnStart, panicHandler, config, preferences, eventListener, updates, bridge, noEncConfirmator)
 	}
@@ -0,0 +1,204 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func (s *service) Login(_ context.Context, req *LoginRequest) (*LoginResponse, error) {
+	if req.Username == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "username and password are required")
+	}
+
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+
+	s.login = nil
+
+	client, auth, err := s.bridge.Login(req.Username, req.Password)
+	if err != nil {
+		log.WithError(err).Warn("Login failed")
+		return nil, getErrorStatus(err)
+	}
+
+	s.login = &pendingLogin{client: client, auth: auth, password: req.Password}
+	return &LoginResponse{
+		TwoFactorRequired:       auth.HasTwoFactor(),
+		MailboxPasswordRequired: auth.HasMailboxPassword(),
+	}, nil
+}
+
+func (s *service) Login2FA(_ context.Context, req *Login2FARequest) (*LoginResponse, error) {
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+
+	if s.login == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no login in progress")
+	}
+
+	if err := s.login.client.Auth2FA(req.Code, s.login.auth); err != nil {
+		log.WithError(err).Warn("Two factor authentication failed")
+		return nil, getErrorStatus(err)
+	}
+
+	return &LoginResponse{MailboxPasswordRequired: s.login.auth.HasMailboxPassword()}, nil
+}
+
+func (s *service) LoginMailboxPassword(_ context.Context, req *LoginMailboxPasswordRequest) (*Account, error) {
+	return s.finishLogin(req.Password)
+}
+
+// LoginFinish finishes login of accounts without mailbox password.
+func (s *service) LoginFinish(context.Context, *emptypb.Empty) (*Account, error) {
+	return s.finishLogin("")
+}
+
+func (s *service) finishLogin(mailboxPassword string) (*Account, error) {
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+
+	if s.login == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no login in progress")
+	}
+	if mailboxPassword == "" {
+		mailboxPassword = s.login.password
+	}
+
+	user, err := s.bridge.FinishLogin(s.login.client, s.login.auth, mailboxPassword)
+	if err != nil {
+		log.WithError(err).Error("Login was unsuccessful")
+		return nil, getErrorStatus(err)
+	}
+
+	s.login = nil
+	return newAccount(user), nil
+}
+
+func (s *service) LoginAbort(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+
+	s.login = nil
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) ListAccounts(context.Context, *emptypb.Empty) (*AccountList, error) {
+	list := &AccountList{}
+	for _, user := range s.bridge.GetUsers() {
+		list.Accounts = append(list.Accounts, newAccount(user))
+	}
+	return list, nil
+}
+
+func (s *service) GetAccount(_ context.Context, req *AccountRequest) (*Account, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	return newAccount(user), nil
+}
+
+func (s *service) LogoutAccount(_ context.Context, req *AccountRequest) (*emptypb.Empty, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.Logout(); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) RemoveAccount(_ context.Context, req *RemoveAccountRequest) (*emptypb.Empty, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bridge.DeleteUser(user.ID(), req.ClearCache); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) SwitchAddressMode(_ context.Context, req *AccountRequest) (*Account, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.SwitchAddressMode(); err != nil {
+		return nil, err
+	}
+	return newAccount(user), nil
+}
+
+func (s *service) AddAppPassword(_ context.Context, req *AppPasswordRequest) (*AppPassword, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	password, err := user.AddAppPassword(req.Name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &AppPassword{Name: req.Name, Password: password}, nil
+}
+
+func (s *service) RemoveAppPassword(_ context.Context, req *AppPasswordRequest) (*emptypb.Empty, error) {
+	user, err := s.getUser(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.RemoveAppPassword(req.Name); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) getUser(query string) (types.User, error) {
+	user, err := s.bridge.GetUser(query)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return user, nil
+}
+
+func newAccount(user types.User) *Account {
+	progress := user.GetSyncProgress()
+	return &Account{
+		Id:                  user.ID(),
+		Username:            user.Username(),
+		Connected:           user.IsConnected(),
+		CombinedAddressMode: user.IsCombinedAddressMode(),
+		Addresses:           user.GetAddresses(),
+		BridgePassword:      user.GetBridgePassword(),
+		AppPasswords:        user.GetAppPasswordNames(),
+		Sync: &SyncProgress{
+			Phase:      progress.Phase,
+			Folder:     progress.Folder,
+			Done:       int32(progress.Done),
+			Total:      int32(progress.Total),
+			EtaSeconds: int64(progress.ETA.Seconds()),
+		},
+	}
+}
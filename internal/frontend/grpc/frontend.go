@@ -0,0 +1,201 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package grpc provides gRPC interface of the Bridge, so the GUI and other
+// user interfaces can run in a separate process. The service is defined in
+// bridge.proto, regenerate the code by `make grpc` after changing it.
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/ProtonMail/proton-bridge/internal/api"
+	"github.com/ProtonMail/proton-bridge/internal/frontend/types"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	log = logrus.WithField("pkg", "frontend/grpc") //nolint[gochecknoglobals]
+)
+
+// host is the only address the service listens on; it controls accounts,
+// so it is never reachable from other machines.
+const host = "127.0.0.1"
+
+type service struct {
+	UnimplementedBridgeServer
+
+	version          string
+	buildVersion     string
+	panicHandler     types.PanicHandler
+	config           *config.Config
+	preferences      *config.Preferences
+	eventListener    listener.Listener
+	updates          types.Updater
+	bridge           types.Bridger
+	noEncConfirmator types.NoEncConfirmator
+
+	server   *grpc.Server
+	token    string
+	quitCh   chan struct{}
+	quitOnce sync.Once
+
+	loginLock sync.Mutex
+	login     *pendingLogin
+
+	appRestart bool
+}
+
+// pendingLogin holds the login between calls of the login flow.
+type pendingLogin struct {
+	client   pmapi.Client
+	auth     *pmapi.Auth
+	password string
+}
+
+// New returns a new gRPC frontend configured with the given options.
+func New(
+	version,
+	buildVersion string,
+	panicHandler types.PanicHandler,
+	config *config.Config,
+	preferences *config.Preferences,
+	eventListener listener.Listener,
+	updates types.Updater,
+	bridge types.Bridger,
+	noEncConfirmator types.NoEncConfirmator,
+) *service { //nolint[golint]
+	return &service{
+		version:          version,
+		buildVersion:     buildVersion,
+		panicHandler:     panicHandler,
+		config:           config,
+		preferences:      preferences,
+		eventListener:    eventListener,
+		updates:          updates,
+		bridge:           bridge,
+		noEncConfirmator: noEncConfirmator,
+		quitCh:           make(chan struct{}),
+	}
+}
+
+// IsAppRestarting returns whether the app is currently set to restart.
+func (s *service) IsAppRestarting() bool {
+	return s.appRestart
+}
+
+// Loop serves the gRPC API until Quit or Restart is called or the process
+// is interrupted or terminated.
+func (s *service) Loop(credentialsError error) error {
+	if credentialsError != nil {
+		log.WithError(credentialsError).Error("Cannot start gRPC API without credentials")
+		return credentialsError
+	}
+
+	token, err := api.LoadToken(s.config.GetAPITokenPath())
+	if err != nil {
+		return err
+	}
+	s.token = token
+
+	tls, err := credentials.NewServerTLSFromFile(s.config.GetTLSCertPath(), s.config.GetTLSKeyPath())
+	if err != nil {
+		return err
+	}
+
+	s.newServer(grpc.Creds(tls))
+
+	addr := fmt.Sprintf("%s:%d", host, s.preferences.GetInt(preferences.GRPCPortKey))
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer s.panicHandler.HandlePanic()
+		terminate := make(chan os.Signal, 1)
+		signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-terminate:
+			log.Info("Terminated, stopping gRPC API")
+			s.quit()
+		case <-s.quitCh:
+		}
+	}()
+
+	log.Info("gRPC API listening at ", addr)
+	return s.server.Serve(l)
+}
+
+// newServer creates the server with authorization of all calls.
+func (s *service) newServer(opts ...grpc.ServerOption) {
+	opts = append(opts,
+		grpc.UnaryInterceptor(s.authorizeUnary),
+		grpc.StreamInterceptor(s.authorizeStream),
+	)
+	s.server = grpc.NewServer(opts...)
+	RegisterBridgeServer(s.server, s)
+}
+
+// quit ends event streams and stops the server after running calls finish.
+func (s *service) quit() {
+	s.quitOnce.Do(func() {
+		close(s.quitCh)
+		go s.server.GracefulStop()
+	})
+}
+
+func (s *service) authorizeUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *service) authorizeStream(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// authorize checks the API token in authorization metadata.
+func (s *service) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong API token")
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type testPanicHandler struct{}
+
+func (testPanicHandler) HandlePanic() {}
+
+func newTestService(t *testing.T) (BridgeClient, *service, func()) {
+	dir, err := ioutil.TempDir("", "grpc")
+	require.NoError(t, err)
+
+	pref := config.NewPreferences(filepath.Join(dir, "prefs.json"))
+	pref.SetDefault(preferences.IMAPPortKey, "1143")
+
+	s := New("1.0.0", "1.0.0+test", testPanicHandler{}, nil, pref, listener.New(), nil, nil, nil)
+	s.token = "secret"
+	s.newServer()
+
+	l := bufconn.Listen(1024 * 1024)
+	go func() { _ = s.server.Serve(l) }()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return l.Dial()
+	}))
+	require.NoError(t, err)
+
+	return NewBridgeClient(conn), s, func() {
+		_ = conn.Close()
+		s.quit()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServiceAuthorization(t *testing.T) {
+	client, _, clear := newTestService(t)
+	defer clear()
+
+	_, err := client.GetSettings(context.Background(), &emptypb.Empty{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.GetSettings(withToken("wrong"), &emptypb.Empty{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.RunEventStream(withToken("wrong"), &emptypb.Empty{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestServiceSettings(t *testing.T) {
+	client, s, clear := newTestService(t)
+	defer clear()
+
+	ctx := withToken("secret")
+	list, err := client.GetSettings(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+
+	var found bool
+	for _, setting := range list.Settings {
+		if setting.Name == "imap.port" {
+			found = true
+			require.Equal(t, "1143", setting.Value)
+			require.Equal(t, preferences.KindInt, setting.Kind)
+		}
+	}
+	require.True(t, found)
+
+	setting, err := client.SetSetting(ctx, &SetSettingRequest{Name: "imap.port", Value: "2143"})
+	require.NoError(t, err)
+	require.Equal(t, "2143", setting.Value)
+	require.Equal(t, 2143, s.preferences.GetInt(preferences.IMAPPortKey))
+
+	_, err = client.SetSetting(ctx, &SetSettingRequest{Name: "imap.port", Value: "many"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServiceLoginWithoutLogin(t *testing.T) {
+	client, _, clear := newTestService(t)
+	defer clear()
+
+	_, err := client.Login2FA(withToken("secret"), &Login2FARequest{Code: "123456"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.LoginFinish(withToken("secret"), &emptypb.Empty{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.Login(withToken("secret"), &LoginRequest{Username: "user"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServiceEventStream(t *testing.T) {
+	client, s, clear := newTestService(t)
+	defer clear()
+
+	stream, err := client.RunEventStream(withToken("secret"), &emptypb.Empty{})
+	require.NoError(t, err)
+
+	// Events are subscribed once the stream runs on the server, so the event
+	// is emitted till it arrives.
+	received := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-received:
+				return
+			case <-time.After(10 * time.Millisecond):
+				s.eventListener.Emit(events.LogoutEvent, "userID")
+			}
+		}
+	}()
+
+	event, err := stream.Recv()
+	close(received)
+	require.NoError(t, err)
+	require.Equal(t, events.LogoutEvent, event.Name)
+	require.Equal(t, "userID", event.Data)
+
+	// Quit ends the stream.
+	_, err = client.Quit(withToken("secret"), &emptypb.Empty{})
+	require.NoError(t, err)
+	for err == nil {
+		_, err = stream.Recv()
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/metrics"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/sentry"
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// eventBuffer is the number of events of one kind kept for a slow stream.
+const eventBuffer = 16
+
+// streamedEvents are sent to clients by RunEventStream. Internal events,
+// e.g. closeConnection, are not among them.
+var streamedEvents = []string{ //nolint[gochecknoglobals]
+	events.ErrorEvent,
+	events.LogoutEvent,
+	events.AddressChangedEvent,
+	events.AddressChangedLogoutEvent,
+	events.UserRefreshEvent,
+	events.InternetOffEvent,
+	events.InternetOnEvent,
+	events.SecondInstanceEvent,
+	events.OutgoingNoEncEvent,
+	events.NoActiveKeyForRecipientEvent,
+	events.UpgradeApplicationEvent,
+	events.TLSCertIssue,
+	events.TLSExceptionUsedEvent,
+	events.SyncProgressEvent,
+}
+
+func (s *service) GetInfo(context.Context, *emptypb.Empty) (*InfoResponse, error) {
+	return &InfoResponse{
+		Version:       s.version,
+		BuildVersion:  s.buildVersion,
+		LogDir:        s.config.GetLogDir(),
+		ConfigFile:    s.config.GetConfigFilePath(),
+		CurrentClient: s.bridge.GetCurrentClient(),
+	}, nil
+}
+
+func (s *service) Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	log.Info("Quit requested")
+	s.quit()
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) Restart(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	log.Info("Restart requested")
+	s.appRestart = true
+	s.quit()
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) CheckConnection(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.bridge.CheckConnection(); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) CheckUpdate(context.Context, *emptypb.Empty) (*UpdateResponse, error) {
+	isUpToDate, latest, err := s.updates.CheckIsUpToDate()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &UpdateResponse{
+		UpToDate:      isUpToDate,
+		LatestVersion: latest.Version,
+		ReleaseNotes:  latest.ReleaseNotes,
+		DownloadLink:  s.updates.GetDownloadLink(),
+	}, nil
+}
+
+func (s *service) ReportBug(_ context.Context, req *ReportBugRequest) (*emptypb.Empty, error) {
+	if err := s.bridge.ReportBug(req.OsType, req.OsVersion, req.Description, req.Account, req.Address, req.EmailClient); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) ConfirmNoEncryption(_ context.Context, req *ConfirmNoEncryptionRequest) (*emptypb.Empty, error) {
+	s.noEncConfirmator.ConfirmNoEncryption(req.MessageId, req.Send)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) GetSettings(context.Context, *emptypb.Empty) (*SettingList, error) {
+	list := &SettingList{}
+	for _, setting := range preferences.GetSettings() {
+		list.Settings = append(list.Settings, s.newSetting(setting))
+	}
+	return list, nil
+}
+
+func (s *service) SetSetting(_ context.Context, req *SetSettingRequest) (*Setting, error) {
+	setting, err := preferences.SetSetting(s.preferences, req.Name, req.Value)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Proxy is switched in the running client manager as by other frontends.
+	if setting.Key == preferences.AllowProxyKey {
+		if s.preferences.GetBool(preferences.AllowProxyKey) {
+			s.bridge.AllowProxy()
+		} else {
+			s.bridge.DisallowProxy()
+		}
+	}
+
+	if setting.Key == preferences.UpdateChannelKey {
+		s.updates.SetChannel(s.preferences.Get(preferences.UpdateChannelKey))
+	}
+
+	if setting.Key == preferences.CrashReportsKey {
+		sentry.SetReportLevel(s.preferences.Get(preferences.CrashReportsKey))
+	}
+
+	if setting.Key == preferences.ProxyKey {
+		if err := sysproxy.SetMode(s.preferences.Get(preferences.ProxyKey)); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if strings.HasPrefix(setting.Key, preferences.TelemetryKey+"_") {
+		metrics.SetEnabledGroups(preferences.GetTelemetryGroups(s.preferences))
+	}
+
+	// Network profile is applied to the running client manager and stores.
+	if setting.Key == preferences.NetworkProfileKey || setting.Key == preferences.MeteredConnectionKey {
+		s.bridge.ApplyPreferences()
+	}
+
+	log.WithField("setting", req.Name).Info("Setting changed")
+	return s.newSetting(setting), nil
+}
+
+func (s *service) newSetting(setting *preferences.Setting) *Setting {
+	return &Setting{
+		Name:          setting.Name,
+		Value:         s.preferences.Get(setting.Key),
+		Kind:          setting.Kind,
+		AllowedValues: setting.Values,
+		Usage:         setting.Usage,
+		Overridden:    s.preferences.IsOverridden(setting.Key),
+	}
+}
+
+// RunEventStream sends events until the client cancels the call or the
+// service quits.
+func (s *service) RunEventStream(_ *emptypb.Empty, stream Bridge_RunEventStreamServer) error {
+	eventCh := make(chan *Event)
+	done := make(chan struct{})
+	defer close(done)
+
+	for _, name := range streamedEvents {
+		ch := make(chan string, eventBuffer)
+		s.eventListener.Add(name, ch)
+		defer s.eventListener.Remove(name, ch)
+
+		go func(name string, ch <-chan string) {
+			defer s.panicHandler.HandlePanic()
+			for {
+				select {
+				case data := <-ch:
+					select {
+					case eventCh <- &Event{Name: name, Data: data}:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}(name, ch)
+	}
+
+	// Buffered events happened before any frontend was listening.
+	s.eventListener.RetryEmit(events.TLSCertIssue)
+	s.eventListener.RetryEmit(events.TLSExceptionUsedEvent)
+	s.eventListener.RetryEmit(events.ErrorEvent)
+
+	for {
+		select {
+		case event := <-eventCh:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		case <-s.quitCh:
+			return nil
+		}
+	}
+}
+
+// getErrorStatus returns error of the API with gRPC code by its kind.
+func getErrorStatus(err error) error {
+	switch err {
+	case pmapi.ErrAPINotReachable:
+		return status.Error(codes.Unavailable, err.Error())
+	case pmapi.ErrUpgradeApplication:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+}
@@ -178,6 +178,26 @@ Item {
                 }
             }
 
+            ButtonIconText {
+                id: networkProfile
+                visible: advancedSettings.isAdvanced
+                text: qsTr("Network profile", "label for button switching limits of bandwidth and polling")
+                leftIcon.text  : Style.fa.tachometer
+                rightIcon {
+                    text : go.networkProfile
+                    color: Style.main.text
+                    font {
+                        family : networkProfile.font.family // use default font, not font-awesome
+                        pointSize : Style.settings.fontSize * Style.pt
+                        underline : true
+                    }
+                }
+                Accessible.description: qsTr("Switch to next network profile, current is", "Click to switch network profile") + " " + go.networkProfile
+                onClicked: {
+                    go.switchNetworkProfile()
+                }
+            }
+
         }
     }
 }
@@ -237,6 +237,14 @@ Item {
             winMain.tlsBarState="notOK"
         }
 
+        onShowTLSExceptionUsed : {
+            go.notifyBubble(1, qsTr(
+                "Connection to %1 is trusted by a TLS exception, not by pinned keys. " +
+                "The network operator can read and change the traffic. " +
+                "Remove the exception when it is no longer needed."
+            ).arg(host))
+        }
+
 
     }
 
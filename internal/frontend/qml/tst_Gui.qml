@@ -122,6 +122,7 @@ Window {
         ListElement { title: "Minimize this"  }
         ListElement { title: "SendAlertPopup" }
         ListElement { title: "TLSCertError"   }
+        ListElement { title: "TLSException"   }
     }
 
     ListView {
@@ -208,6 +209,9 @@ Window {
                     case "TLSCertError" :
                     go.showCertIssue()
                     break;
+                    case "TLSException" :
+                    go.showTLSExceptionUsed("mail.protonmail.ch")
+                    break;
                     default :
                     console.log("Not implemented " + data)
                 }
@@ -310,6 +314,7 @@ Window {
         signal failedAutostartCode(string code)
 
         signal showCertIssue()
+        signal showTLSExceptionUsed(string host)
 
         signal updateFinished(bool hasError)
 
@@ -585,6 +590,14 @@ Window {
             workAndClose()
         }
 
+        property string networkProfile : "normal"
+
+        function switchNetworkProfile() {
+            var profiles = ["normal", "metered", "low_bandwidth"]
+            go.networkProfile = profiles[(profiles.indexOf(go.networkProfile)+1) % profiles.length]
+            console.log("Network profile changed to ", go.networkProfile)
+        }
+
         property bool isReportingOutgoingNoEnc : true
 
         function toggleIsReportingOutgoingNoEnc() {
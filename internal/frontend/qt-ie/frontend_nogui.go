@@ -35,10 +35,13 @@ type FrontendHeadless struct{}
 
 func (s *FrontendHeadless) Loop(credentialsError error) error {
 	log.Info("Check status on localhost:8081")
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Own mux so handlers registered on import, e.g. by net/http/pprof,
+	// are not served here.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "IE is running")
 	})
-	return http.ListenAndServe(":8081", nil)
+	return http.ListenAndServe(":8081", mux)
 }
 
 func (s *FrontendHeadless) IsAppRestarting() bool { return false }
@@ -187,6 +187,7 @@ func (s *FrontendQt) watchEvents() {
 	updateApplicationCh := s.getEventChannel(events.UpgradeApplicationEvent)
 	newUserCh := s.getEventChannel(events.UserRefreshEvent)
 	certIssue := s.getEventChannel(events.TLSCertIssue)
+	tlsExceptionCh := s.getEventChannel(events.TLSExceptionUsedEvent)
 	for {
 		select {
 		case errorDetails := <-errorCh:
@@ -226,6 +227,8 @@ func (s *FrontendQt) watchEvents() {
 			s.Qml.LoadAccounts()
 		case <-certIssue:
 			s.Qml.ShowCertIssue()
+		case host := <-tlsExceptionCh:
+			s.Qml.ShowTLSExceptionUsed(host)
 		}
 	}
 }
@@ -341,12 +344,15 @@ func (s *FrontendQt) qtExecute(Procedure func(*FrontendQt) error) error {
 		s.Qml.SetIsProxyAllowed(false)
 	}
 
+	s.Qml.SetNetworkProfile(s.bridge.GetNetworkProfile().Name)
+
 	// Notify user about error during initialization.
 	if s.notifyHasNoKeychain {
 		s.Qml.NotifyHasNoKeychain()
 	}
 
 	s.eventListener.RetryEmit(events.TLSCertIssue)
+	s.eventListener.RetryEmit(events.TLSExceptionUsedEvent)
 	s.eventListener.RetryEmit(events.ErrorEvent)
 
 	// Set reporting of outgoing email without encryption.
@@ -520,6 +526,26 @@ func (s *FrontendQt) toggleAllowProxy() {
 	}
 }
 
+// switchNetworkProfile switches to the next network profile in the list.
+func (s *FrontendQt) switchNetworkProfile() {
+	names := preferences.GetNetworkProfileNames()
+	current := s.bridge.GetNetworkProfile().Name
+
+	next := names[0]
+	for i, name := range names {
+		if name == current {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+
+	if err := s.bridge.SetNetworkProfile(next); err != nil {
+		log.WithError(err).Error("Cannot switch network profile")
+		return
+	}
+	s.Qml.SetNetworkProfile(next)
+}
+
 func (s *FrontendQt) getIMAPPort() string {
 	return s.preferences.Get(preferences.IMAPPortKey)
 }
@@ -35,6 +35,7 @@ type GoQMLInterface struct {
 
 	_ bool   `property:"isAutoStart"`
 	_ bool   `property:"isProxyAllowed"`
+	_ string `property:"networkProfile"`
 	_ string `property:"currentAddress"`
 	_ string `property:"goos"`
 	_ string `property:"credits"`
@@ -83,6 +84,7 @@ type GoQMLInterface struct {
 
 	_ func() `slot:"toggleAutoStart"`
 	_ func() `slot:"toggleAllowProxy"`
+	_ func() `slot:"switchNetworkProfile"`
 	_ func() `slot:"loadAccounts"`
 	_ func() `slot:"openLogs"`
 	_ func() `slot:"clearCache"`
@@ -135,6 +137,7 @@ type GoQMLInterface struct {
 	_ func(x, y float32)                      `slot:"saveOutgoingNoEncPopupCoord"`
 	_ func(recipient string)                  `signal:"showNoActiveKeyForRecipient"`
 	_ func()                                  `signal:"showCertIssue"`
+	_ func(host string)                       `signal:"showTLSExceptionUsed"`
 
 	_ func()              `slot:"startUpdate"`
 	_ func(hasError bool) `signal:"updateFinished"`
@@ -147,6 +150,7 @@ func (s *GoQMLInterface) init() {}
 func (s *GoQMLInterface) SetFrontend(f *FrontendQt) {
 	s.ConnectToggleAutoStart(f.toggleAutoStart)
 	s.ConnectToggleAllowProxy(f.toggleAllowProxy)
+	s.ConnectSwitchNetworkProfile(f.switchNetworkProfile)
 	s.ConnectLoadAccounts(f.loadAccounts)
 	s.ConnectOpenLogs(f.openLogs)
 	s.ConnectClearCache(f.clearCache)
@@ -21,6 +21,8 @@ package types
 import (
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/importexport"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/transfer"
 	"github.com/ProtonMail/proton-bridge/internal/updates"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -37,6 +39,8 @@ type Updater interface {
 	GetDownloadLink() string
 	GetLocalVersion() updates.VersionInfo
 	StartUpgrade(currentStatus chan<- updates.Progress)
+	SetChannel(channel string)
+	GetSignatureReport() *updates.SignatureReport
 }
 
 type NoEncConfirmator interface {
@@ -63,6 +67,17 @@ type User interface {
 	GetPrimaryAddress() string
 	GetAddresses() []string
 	GetBridgePassword() string
+	GetAppPasswordNames() []string
+	AddAppPassword(name string) (string, error)
+	AddBearerToken(name string) (string, error)
+	RemoveAppPassword(name string) error
+	SearchMessages(query string, limit int) ([]*pmapi.Message, error)
+	GetDiskUsage() (store.DiskUsage, error)
+	GetSyncProgress() store.SyncProgress
+	ClearBodyCache() error
+	CompactStore() (int64, error)
+	RotateStoreKeys() error
+	Export(dir, format, mailboxName string) (int, error)
 	SwitchAddressMode() error
 	Logout() error
 }
@@ -76,6 +91,9 @@ type Bridger interface {
 	ReportBug(osType, osVersion, description, accountName, address, emailClient string) error
 	AllowProxy()
 	DisallowProxy()
+	ApplyPreferences()
+	GetNetworkProfile() preferences.NetworkProfile
+	SetNetworkProfile(name string) error
 }
 
 type bridgeWrap struct {
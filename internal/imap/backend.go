@@ -19,12 +19,15 @@
 package imap
 
 import (
+	"os"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/imap/notify"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	"github.com/emersion/go-imap"
 	goIMAPBackend "github.com/emersion/go-imap/backend"
@@ -36,9 +39,11 @@ type panicHandler interface {
 
 type imapBackend struct {
 	panicHandler  panicHandler
+	preferences   *config.Preferences
 	bridge        bridger
 	updates       chan goIMAPBackend.Update
 	eventListener listener.Listener
+	notify        notify.Extension
 
 	users       map[string]*imapUser
 	usersLocker sync.Locker
@@ -46,6 +51,8 @@ type imapBackend struct {
 	imapCache     map[string]map[string]string
 	imapCachePath string
 	imapCacheLock *sync.RWMutex
+
+	spoolDir string
 }
 
 // NewIMAPBackend returns struct implementing go-imap/backend interface.
@@ -53,14 +60,21 @@ func NewIMAPBackend(
 	panicHandler panicHandler,
 	eventListener listener.Listener,
 	cfg configProvider,
+	preferences *config.Preferences,
 	bridge *bridge.Bridge,
 ) *imapBackend { //nolint[golint]
 	bridgeWrap := newBridgeWrap(bridge)
-	backend := newIMAPBackend(panicHandler, cfg, bridgeWrap, eventListener)
+	backend := newIMAPBackend(panicHandler, cfg, preferences, bridgeWrap, eventListener)
+
+	// Spool files are used only while Bridge is running, the ones left
+	// by a crash are removed.
+	if err := os.RemoveAll(backend.spoolDir); err != nil {
+		log.WithError(err).Warn("Cannot remove old spool files")
+	}
 
 	// We want idle updates coming from bridge's updates channel (which in turn come
 	// from the bridge users' stores) to be sent to the imap backend's update channel.
-	backend.updates = bridge.GetIMAPUpdatesChannel()
+	go backend.forwardUpdates(bridge.GetIMAPUpdatesChannel())
 
 	go backend.monitorDisconnectedUsers()
 
@@ -70,20 +84,25 @@ func NewIMAPBackend(
 func newIMAPBackend(
 	panicHandler panicHandler,
 	cfg configProvider,
+	preferences *config.Preferences,
 	bridge bridger,
 	eventListener listener.Listener,
 ) *imapBackend {
 	return &imapBackend{
 		panicHandler:  panicHandler,
+		preferences:   preferences,
 		bridge:        bridge,
 		updates:       make(chan goIMAPBackend.Update),
 		eventListener: eventListener,
+		notify:        notify.NewExtension(panicHandler, store.PathDelimiter),
 
 		users:       map[string]*imapUser{},
 		usersLocker: &sync.Mutex{},
 
 		imapCachePath: cfg.GetIMAPCachePath(),
 		imapCacheLock: &sync.RWMutex{},
+
+		spoolDir: cfg.GetIMAPSpoolDir(),
 	}
 }
 
@@ -143,26 +162,63 @@ func (ib *imapBackend) deleteUser(address string) {
 	delete(ib.users, strings.ToLower(address))
 }
 
+// isAccountAddress returns whether address belongs to the account.
+func (ib *imapBackend) isAccountAddress(account, address string) bool {
+	accountUser, err := ib.bridge.GetUser(account)
+	if err != nil {
+		return false
+	}
+	user, err := ib.bridge.GetUser(strings.ToLower(address))
+	if err != nil {
+		return false
+	}
+	return user.ID() == accountUser.ID()
+}
+
 // Login authenticates a user.
 func (ib *imapBackend) Login(_ *imap.ConnInfo, username, password string) (goIMAPBackend.User, error) {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer ib.panicHandler.HandlePanic()
 
+	return ib.login(username, func(user bridgeUser) error {
+		return user.CheckBridgeLogin(password)
+	})
+}
+
+// LoginWithToken authenticates a user by the bearer token of OAuth mechanisms.
+func (ib *imapBackend) LoginWithToken(username, token string) (goIMAPBackend.User, error) {
+	defer ib.panicHandler.HandlePanic()
+
+	return ib.login(username, func(user bridgeUser) error {
+		return user.CheckBridgeToken(token)
+	})
+}
+
+// getAddressByToken returns the primary address of the user with the bearer
+// token for OAuth clients which do not send the username.
+func (ib *imapBackend) getAddressByToken(token string) (string, error) {
+	user, err := ib.bridge.GetUserByBearerToken(token)
+	if err != nil {
+		return "", err
+	}
+	return user.GetPrimaryAddress(), nil
+}
+
+func (ib *imapBackend) login(username string, check func(bridgeUser) error) (goIMAPBackend.User, error) {
 	imapUser, err := ib.getUser(username)
 	if err != nil {
 		log.WithError(err).Warn("Cannot get user")
 		return nil, err
 	}
 
-	if err := imapUser.user.CheckBridgeLogin(password); err != nil {
+	// Every connection logs out, also after unsuccessful login check below.
+	imapUser.storeUser.ClientConnected()
+
+	if err := check(imapUser.user); err != nil {
 		log.WithError(err).Error("Could not check bridge password")
 		if err := imapUser.Logout(); err != nil {
 			log.WithError(err).Warn("Could not logout user after unsuccessful login check")
 		}
-		// Apple Mail sometimes generates a lot of requests very quickly.
-		// It's therefore good to have a timeout after a bad login so that we can slow
-		// those requests down a little bit.
-		time.Sleep(10 * time.Second)
 		return nil, err
 	}
 
@@ -182,6 +238,18 @@ func (ib *imapBackend) Updates() <-chan goIMAPBackend.Update {
 	return ib.updates
 }
 
+// forwardUpdates passes updates from the stores to go-imap. Go-imap delivers
+// them only to connections with the mailbox selected, so they are passed also
+// to NOTIFY extension which takes care of other mailboxes.
+func (ib *imapBackend) forwardUpdates(updates <-chan goIMAPBackend.Update) {
+	defer ib.panicHandler.HandlePanic()
+
+	for update := range updates {
+		ib.notify.Notify(update)
+		ib.updates <- update
+	}
+}
+
 func (ib *imapBackend) CreateMessageLimit() *uint32 {
 	return nil
 }
@@ -19,7 +19,6 @@ package imap
 
 import (
 	"encoding/json"
-	"errors"
 	"os"
 	"strings"
 )
@@ -29,88 +28,39 @@ const (
 	SubscriptionException = "subscription_exceptions"
 )
 
-// addToCache adds item to existing item list.
-// Starting from following structure:
+// getLegacySubscriptionExceptions returns label IDs of mailboxes unsubscribed
+// by older versions which kept subscriptions in the IMAP cache file with
+// following structure:
 //   {
-//		"username": {"label": "item1;item2"}
+//		"username": {"subscription_exceptions": "item1;item2"}
 //   }
 //
-// After calling addToCache("username", "label", "newItem") we get:
-//   {
-//		"username": {"label": "item1;item2;newItem"}
-//   }
-//
-func (ib *imapBackend) addToCache(userID, label, toAdd string) {
-	list := ib.getCacheList(userID, label)
-
-	if list != "" {
-		list = list + ";" + toAdd
-	} else {
-		list = toAdd
-	}
-
-	ib.imapCacheLock.Lock()
-	ib.imapCache[userID][label] = list
-	ib.imapCacheLock.Unlock()
-
-	if err := ib.saveIMAPCache(); err != nil {
-		log.Info("Backend/userinfo: could not save cache: ", err)
-	}
-}
-
-func (ib *imapBackend) removeFromCache(userID, label, toRemove string) {
-	list := ib.getCacheList(userID, label)
-
-	split := strings.Split(list, ";")
-
-	for i, item := range split {
-		if item == toRemove {
-			split = append(split[:i], split[i+1:]...)
-		}
-	}
-
-	ib.imapCacheLock.Lock()
-	ib.imapCache[userID][label] = strings.Join(split, ";")
-	ib.imapCacheLock.Unlock()
-
-	if err := ib.saveIMAPCache(); err != nil {
-		log.Info("Backend/userinfo: could not save cache: ", err)
-	}
-}
-
-func (ib *imapBackend) getCacheList(userID, label string) (list string) {
+// The same file is used by the store for event IDs, therefore it is only read
+// here. Subscriptions are now kept in the store.
+func (ib *imapBackend) getLegacySubscriptionExceptions(userID string) []string {
 	if err := ib.loadIMAPCache(); err != nil {
-		log.WithError(err).Warn("Could not load cache")
-	}
-
-	ib.imapCacheLock.Lock()
-	if ib.imapCache == nil {
-		ib.imapCache = map[string]map[string]string{}
-	}
-
-	if ib.imapCache[userID] == nil {
-		ib.imapCache[userID] = map[string]string{}
-		ib.imapCache[userID][SubscriptionException] = ""
+		log.WithError(err).Debug("Could not load cache")
+		return nil
 	}
 
-	list = ib.imapCache[userID][label]
+	ib.imapCacheLock.RLock()
+	defer ib.imapCacheLock.RUnlock()
 
-	ib.imapCacheLock.Unlock()
-
-	if err := ib.saveIMAPCache(); err != nil {
-		log.WithError(err).Warn("Could not save cache")
+	list := ib.imapCache[userID][SubscriptionException]
+	if list == "" {
+		return nil
 	}
-	return
+	return strings.Split(list, ";")
 }
 
 func (ib *imapBackend) loadIMAPCache() error {
+	ib.imapCacheLock.Lock()
+	defer ib.imapCacheLock.Unlock()
+
 	if ib.imapCache != nil {
 		return nil
 	}
 
-	ib.imapCacheLock.Lock()
-	defer ib.imapCacheLock.Unlock()
-
 	f, err := os.Open(ib.imapCachePath)
 	if err != nil {
 		return err
@@ -119,20 +69,3 @@ func (ib *imapBackend) loadIMAPCache() error {
 
 	return json.NewDecoder(f).Decode(&ib.imapCache)
 }
-
-func (ib *imapBackend) saveIMAPCache() error {
-	if ib.imapCache == nil {
-		return errors.New("cannot save cache: cache is nil")
-	}
-
-	ib.imapCacheLock.Lock()
-	defer ib.imapCacheLock.Unlock()
-
-	f, err := os.Create(ib.imapCachePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close() //nolint[errcheck]
-
-	return json.NewEncoder(f).Encode(ib.imapCache)
-}
@@ -27,16 +27,19 @@ type configProvider interface {
 	GetEventsPath() string
 	GetDBDir() string
 	GetIMAPCachePath() string
+	GetIMAPSpoolDir() string
 }
 
 type bridger interface {
 	SetCurrentClient(clientName, clientVersion string)
 	GetUser(query string) (bridgeUser, error)
+	GetUserByBearerToken(token string) (bridgeUser, error)
 }
 
 type bridgeUser interface {
 	ID() string
 	CheckBridgeLogin(password string) error
+	CheckBridgeToken(token string) error
 	IsCombinedAddressMode() bool
 	GetAddressID(address string) (string, error)
 	GetPrimaryAddress() string
@@ -67,6 +70,14 @@ func (b *bridgeWrap) GetUser(query string) (bridgeUser, error) {
 	return newBridgeUserWrap(user), nil
 }
 
+func (b *bridgeWrap) GetUserByBearerToken(token string) (bridgeUser, error) {
+	user, err := b.Bridge.GetUserByBearerToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return newBridgeUserWrap(user), nil
+}
+
 type bridgeUserWrap struct {
 	*users.User
 }
@@ -18,7 +18,7 @@
 package cache
 
 import (
-	"bytes"
+	"io"
 	"sort"
 	"sync"
 	"time"
@@ -38,9 +38,30 @@ func (s oldestFirst) Len() int           { return len(s) }
 func (s oldestFirst) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s oldestFirst) Less(i, j int) bool { return s[i].Timestamp < s[j].Timestamp }
 
+// Body is the built message kept in memory (bytes.Reader) or in a spool file.
+// Bodies which implement io.Closer are closed when removed from the cache.
+type Body interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// Reader is a body acquired from SharedBody. It must be closed when it is not
+// needed anymore.
+type Reader interface {
+	Body
+	io.Closer
+}
+
+// SharedBody is a body which stays readable by readers acquired from it even
+// after it is closed. It is released once the last reader is closed.
+type SharedBody interface {
+	Body
+	Acquire() Reader
+}
+
 type cachedMessage struct {
 	key
-	data      []byte
+	body      Body
 	structure backendMessage.BodyStructure
 }
 
@@ -65,7 +86,7 @@ var (
 
 func (m *cachedMessage) isValidOrDel() bool {
 	if m.key.Timestamp+cacheTimeLimit < timestamp() {
-		delete(mailCache, m.key.ID)
+		deleteMail(m.key.ID)
 		return false
 	}
 	return true
@@ -76,7 +97,21 @@ func timestamp() int64 {
 }
 
 func Clear() {
-	mailCache = make(map[string]cachedMessage)
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	for mID := range mailCache {
+		deleteMail(mID)
+	}
+}
+
+// deleteMail removes the message from the cache and closes its body.
+// It must be called with cacheMutex locked.
+func deleteMail(mID string) {
+	if closer, ok := mailCache[mID].body.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	delete(mailCache, mID)
 }
 
 // BuildLock locks per message level, not on global level.
@@ -101,12 +136,18 @@ func BuildUnlock(messageID string) {
 	delete(buildLocks, messageID)
 }
 
-func LoadMail(mID string) (reader *bytes.Reader, structure *backendMessage.BodyStructure) {
-	reader = &bytes.Reader{}
+// LoadMail returns the cached body and structure of the message, or nil body
+// when the message is not in the cache. Shared bodies are returned acquired
+// while the cache is locked, so they cannot be removed before they are read;
+// the caller must close them.
+func LoadMail(mID string) (body Body, structure *backendMessage.BodyStructure) {
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
 	if message, ok := mailCache[mID]; ok && message.isValidOrDel() {
-		reader = bytes.NewReader(message.data)
+		body = message.body
+		if shared, ok := body.(SharedBody); ok {
+			body = shared.Acquire()
+		}
 		structure = &message.structure
 
 		// Update timestamp to keep emails which are used often.
@@ -115,7 +156,10 @@ func LoadMail(mID string) (reader *bytes.Reader, structure *backendMessage.BodyS
 	return
 }
 
-func SaveMail(mID string, msg []byte, structure *backendMessage.BodyStructure) {
+// SaveMail stores the body and structure of the message. Bodies in spool
+// files count into the size limit as well, so the limit keeps the disk
+// usage bounded too.
+func SaveMail(mID string, body Body, structure *backendMessage.BodyStructure) {
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
 
@@ -123,9 +167,9 @@ func SaveMail(mID string, msg []byte, structure *backendMessage.BodyStructure) {
 		key: key{
 			ID:        mID,
 			Timestamp: timestamp(),
-			Size:      len(msg),
+			Size:      int(body.Size()),
 		},
-		data:      msg,
+		body:      body,
 		structure: *structure,
 	}
 
@@ -142,10 +186,13 @@ func SaveMail(mID string, msg []byte, structure *backendMessage.BodyStructure) {
 	var oldest key
 	for totalSize+newMessage.key.Size >= cacheSizeLimit {
 		oldest, messageList = messageList[0], messageList[1:]
-		delete(mailCache, oldest.ID)
+		deleteMail(oldest.ID)
 		totalSize -= oldest.Size
 	}
 
 	// Write new.
+	if old, ok := mailCache[mID]; ok && old.body != body {
+		deleteMail(mID)
+	}
 	mailCache[mID] = newMessage
 }
@@ -33,29 +33,28 @@ const testUID = "testmsg"
 func TestSaveAndLoad(t *testing.T) {
 	msg := []byte("Test message")
 
-	SaveMail(testUID, msg, bs)
-	require.Equal(t, mailCache[testUID].data, msg)
+	SaveMail(testUID, bytes.NewReader(msg), bs)
 
-	reader, _ := LoadMail(testUID)
-	require.Equal(t, reader.Len(), len(msg))
+	body, _ := LoadMail(testUID)
+	require.Equal(t, int64(len(msg)), body.Size())
 	stored := make([]byte, len(msg))
-	_, _ = reader.Read(stored)
+	_, _ = body.ReadAt(stored, 0)
 	require.Equal(t, stored, msg)
 }
 
 func TestMissing(t *testing.T) {
-	reader, _ := LoadMail("non-existing")
-	require.Equal(t, reader.Len(), 0)
+	body, _ := LoadMail("non-existing")
+	require.Nil(t, body)
 }
 
 func TestClearOld(t *testing.T) {
 	cacheTimeLimit = 10
 	msg := []byte("Test message")
-	SaveMail(testUID, msg, bs)
+	SaveMail(testUID, bytes.NewReader(msg), bs)
 	time.Sleep(100 * time.Millisecond)
 
-	reader, _ := LoadMail(testUID)
-	require.Equal(t, reader.Len(), 0)
+	body, _ := LoadMail(testUID)
+	require.Nil(t, body)
 }
 
 func TestClearBig(t *testing.T) {
@@ -68,7 +67,7 @@ func TestClearBig(t *testing.T) {
 	// It should have more than nSize items.
 	for i := 0; i < nSize*nSize; i++ {
 		time.Sleep(1 * time.Millisecond)
-		SaveMail(fmt.Sprintf("%s%d", testUID, i), msg, bs)
+		SaveMail(fmt.Sprintf("%s%d", testUID, i), bytes.NewReader(msg), bs)
 		if len(mailCache) > nSize {
 			t.Error("Number of items in cache should not be more than", nSize)
 		}
@@ -77,15 +76,18 @@ func TestClearBig(t *testing.T) {
 	// Check that the oldest are deleted first.
 	for i := 0; i < nSize*nSize; i++ {
 		iUID := fmt.Sprintf("%s%d", testUID, i)
-		reader, _ := LoadMail(iUID)
-		if i < nSize*(nSize-1) && reader.Len() != 0 {
+		body, _ := LoadMail(iUID)
+		if i < nSize*(nSize-1) && body != nil {
 			mail := mailCache[iUID]
-			t.Error("LoadMail should return empty but have:", mail.data, iUID, mail.key.Timestamp)
+			t.Error("LoadMail should return empty but have:", mail.body, iUID, mail.key.Timestamp)
+		}
+		if i < nSize*(nSize-1) {
+			continue
 		}
 		stored := make([]byte, len(msg))
-		_, _ = reader.Read(stored)
+		_, _ = body.ReadAt(stored, 0)
 
-		if i >= nSize*(nSize-1) && !bytes.Equal(stored, msg) {
+		if !bytes.Equal(stored, msg) {
 			t.Error("LoadMail returned wrong message:", stored, iUID)
 		}
 	}
@@ -94,6 +96,32 @@ func TestClearBig(t *testing.T) {
 func TestConcurency(t *testing.T) {
 	msg := []byte("Test message")
 	for i := 0; i < 10; i++ {
-		go SaveMail(fmt.Sprintf("%s%d", testUID, i), msg, bs)
+		go SaveMail(fmt.Sprintf("%s%d", testUID, i), bytes.NewReader(msg), bs)
 	}
 }
+
+type closingBody struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (b *closingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCloseRemoved(t *testing.T) {
+	cacheTimeLimit = int64(1 * 60 * 60 * 1000)
+	first := &closingBody{Reader: bytes.NewReader([]byte("first"))}
+	second := &closingBody{Reader: bytes.NewReader([]byte("second"))}
+
+	SaveMail(testUID, first, bs)
+	SaveMail(testUID, second, bs)
+	require.True(t, first.closed)
+	require.False(t, second.closed)
+
+	Clear()
+	require.True(t, second.closed)
+	body, _ := LoadMail(testUID)
+	require.Nil(t, body)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"net"
+	"sync"
+
+	imapid "github.com/ProtonMail/go-imap-id"
+	"github.com/emersion/go-imap"
+	"github.com/sirupsen/logrus"
+)
+
+// clientRegistry remembers clients identified by ID command per connection.
+type clientRegistry struct {
+	lock    sync.RWMutex
+	clients map[string]imapid.ID // Keyed by remote address.
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: map[string]imapid.ID{}}
+}
+
+// wrap returns connection which forgets the client once it is closed.
+func (r *clientRegistry) wrap(conn net.Conn) net.Conn {
+	return &clientConn{Conn: conn, registry: r}
+}
+
+// IdentifyClient is called by ID extension for every ID command.
+func (r *clientRegistry) IdentifyClient(connInfo *imap.ConnInfo, id imapid.ID) {
+	l := log.WithFields(logrus.Fields{
+		"name":    id[imapid.FieldName],
+		"version": id[imapid.FieldVersion],
+		"os":      id[imapid.FieldOS],
+	})
+
+	key := connKey(connInfo)
+	if key == "" {
+		l.Info("Client identified")
+		return
+	}
+	l.WithField("rem", key).Info("Client identified")
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.clients[key] = id
+}
+
+// name returns name the client of given connection reported by ID command.
+func (r *clientRegistry) name(connInfo *imap.ConnInfo) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.clients[connKey(connInfo)][imapid.FieldName]
+}
+
+func (r *clientRegistry) forget(remoteAddr net.Addr) {
+	if remoteAddr == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.clients, remoteAddr.String())
+}
+
+// connKey returns remote address of the connection, or empty string when
+// connections cannot be told apart (e.g. unix socket).
+func connKey(connInfo *imap.ConnInfo) string {
+	if connInfo == nil || connInfo.RemoteAddr == nil {
+		return ""
+	}
+	if key := connInfo.RemoteAddr.String(); key != "@" {
+		return key
+	}
+	return ""
+}
+
+type clientConn struct {
+	net.Conn
+
+	registry *clientRegistry
+}
+
+func (c *clientConn) Close() error {
+	c.registry.forget(c.RemoteAddr())
+	return c.Conn.Close()
+}
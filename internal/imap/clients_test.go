@@ -0,0 +1,46 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"net"
+	"testing"
+
+	imapid "github.com/ProtonMail/go-imap-id"
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRegistry(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close() //nolint[errcheck]
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1143}
+	r := newClientRegistry()
+	conn := r.wrap(&fakeAddrConn{Conn: server, remote: addr})
+	connInfo := &imap.ConnInfo{RemoteAddr: addr}
+
+	require.Equal(t, "", r.name(connInfo))
+
+	r.IdentifyClient(connInfo, imapid.ID{imapid.FieldName: "Thunderbird"})
+	require.Equal(t, "Thunderbird", r.name(connInfo))
+	require.Equal(t, "", r.name(&imap.ConnInfo{}))
+
+	require.NoError(t, conn.Close())
+	require.Equal(t, "", r.name(connInfo))
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package imap
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// drainPollInterval is how often draining checks for finished commands.
+const drainPollInterval = 100 * time.Millisecond
+
+// maxLineHead is how much of the beginning of a line is needed to read the
+// tag or continuation request.
+const maxLineHead = 64
+
+// lineScanner splits one direction of the IMAP dialogue into lines and calls
+// onLine with the beginning of each line. Literals are skipped, so a line of
+// a message body is never taken as a command or response.
+type lineScanner struct {
+	onLine func(head []byte)
+
+	head     []byte // Beginning of the current line.
+	reported bool   // Whether head of the current line was passed to onLine.
+	tail     []byte // End of the current line to find literal size.
+	literal  int    // Bytes of literal left to skip.
+}
+
+func (s *lineScanner) Write(b []byte) (int, error) {
+	n := len(b)
+
+	for len(b) > 0 {
+		if s.literal > 0 {
+			skip := s.literal
+			if skip > len(b) {
+				skip = len(b)
+			}
+			s.literal -= skip
+			b = b[skip:]
+			continue
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			s.add(b)
+			break
+		}
+
+		s.add(b[:i])
+		b = b[i+1:]
+		s.endLine()
+	}
+
+	return n, nil
+}
+
+func (s *lineScanner) add(b []byte) {
+	if !s.reported {
+		free := maxLineHead - len(s.head)
+		if free > len(b) {
+			free = len(b)
+		}
+		s.head = append(s.head, b[:free]...)
+		if len(s.head) == maxLineHead {
+			s.report()
+		}
+	}
+
+	s.tail = append(s.tail, b...)
+	if len(s.tail) > maxLineHead {
+		s.tail = append(s.tail[:0], s.tail[len(s.tail)-maxLineHead:]...)
+	}
+}
+
+// endLine handles end of line which is either the end of the whole command
+// or response, or the beginning of a literal.
+func (s *lineScanner) endLine() {
+	s.report()
+
+	tail := bytes.TrimSuffix(s.tail, []byte("\r"))
+	s.tail = s.tail[:0]
+
+	if size, ok := literalSize(tail); ok {
+		s.literal = size
+		return
+	}
+
+	s.head = s.head[:0]
+	s.reported = false
+}
+
+func (s *lineScanner) report() {
+	if s.reported {
+		return
+	}
+	s.reported = true
+	s.onLine(bytes.TrimSuffix(s.head, []byte("\r")))
+}
+
+// literalSize returns size of literal announced at the end of the line,
+// e.g. `{123}` or non-synchronizing `{123+}`.
+func literalSize(line []byte) (int, bool) {
+	if !bytes.HasSuffix(line, []byte("}")) {
+		return 0, false
+	}
+	start := bytes.LastIndexByte(line, '{')
+	if start < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(string(bytes.TrimSuffix(line[start+1:len(line)-1], []byte("+"))))
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// commandTracker follows the dialogue of one connection to tell whether the
+// client waits for a response to some command. It is fed by debug writers,
+// which see the dialogue after STARTTLS as well. IDLE does not count as a
+// command in progress, because it can be interrupted anytime.
+type commandTracker struct {
+	lock         sync.Mutex
+	key          string          // Remote address of the connection.
+	pending      map[string]bool // Tags of commands in progress.
+	idling       bool
+	continuation bool // Next client line is not a command (IDLE or AUTHENTICATE).
+
+	client, server *lineScanner
+}
+
+func newCommandTracker(key string) *commandTracker {
+	t := &commandTracker{key: key, pending: map[string]bool{}}
+	t.client = &lineScanner{onLine: t.clientLine}
+	t.server = &lineScanner{onLine: t.serverLine}
+	return t
+}
+
+func (t *commandTracker) clientLine(head []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.continuation {
+		t.continuation = false
+		t.idling = false
+		return
+	}
+
+	if tag := firstWord(head); tag != "" {
+		t.pending[tag] = true
+	}
+}
+
+func (t *commandTracker) serverLine(head []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if bytes.HasPrefix(head, []byte("+")) {
+		// Literals are handled by the client scanner itself.
+		if !bytes.HasPrefix(head, literalContinuation) {
+			t.continuation = true
+			t.idling = bytes.HasPrefix(head, []byte("+ idling"))
+		}
+		return
+	}
+
+	delete(t.pending, firstWord(head))
+}
+
+// isBusy returns whether a command other than IDLE is in progress.
+func (t *commandTracker) isBusy() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return len(t.pending) > 0 && !t.idling
+}
+
+func firstWord(line []byte) string {
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		line = line[:i]
+	}
+	return string(line)
+}
+
+// commandTrackers holds trackers of open connections.
+type commandTrackers struct {
+	lock     sync.Mutex
+	trackers map[*commandTracker]bool
+}
+
+func newCommandTrackers() *commandTrackers {
+	return &commandTrackers{trackers: map[*commandTracker]bool{}}
+}
+
+// wrap returns connection with new tracker which is removed once the
+// connection is closed.
+func (ts *commandTrackers) wrap(conn net.Conn) *trackerConn {
+	t := newCommandTracker(connKey(&imap.ConnInfo{RemoteAddr: conn.RemoteAddr()}))
+
+	ts.lock.Lock()
+	ts.trackers[t] = true
+	ts.lock.Unlock()
+
+	return &trackerConn{Conn: conn, trackers: ts, tracker: t}
+}
+
+func (ts *commandTrackers) remove(t *commandTracker) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	delete(ts.trackers, t)
+}
+
+// busy returns remote addresses of connections with a command in progress and
+// whether a connection which cannot be told apart by address (unix socket)
+// is busy.
+func (ts *commandTrackers) busy() (keys map[string]bool, anonymous bool) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	keys = map[string]bool{}
+	for t := range ts.trackers {
+		if !t.isBusy() {
+			continue
+		}
+		if t.key == "" {
+			anonymous = true
+		} else {
+			keys[t.key] = true
+		}
+	}
+	return keys, anonymous
+}
+
+// trackerConn removes the tracker once the connection is closed.
+type trackerConn struct {
+	net.Conn
+
+	trackers *commandTrackers
+	tracker  *commandTracker
+}
+
+func (c *trackerConn) Close() error {
+	c.trackers.remove(c.tracker)
+	return c.Conn.Close()
+}
+
+// Drain stops accepting new connections and closes the open ones once they
+// are done with commands in progress, so clients are not cut off in the middle
+// of e.g. FETCH. Connections still busy at the deadline are closed anyway.
+func (s *imapServer) Drain(deadline time.Time) {
+	s.lock.Lock()
+	l := s.listener
+	s.lock.Unlock()
+
+	if l == nil {
+		return
+	}
+	l.Drain()
+	atomic.StoreInt32(&s.listening, 0)
+
+	for {
+		busy, anonymous := s.commands.busy()
+
+		var idle []imapserver.Conn
+		s.server.ForEachConn(func(conn imapserver.Conn) {
+			if key := connKey(conn.Info()); key != "" && !busy[key] {
+				idle = append(idle, conn)
+			}
+		})
+		for _, conn := range idle {
+			_ = conn.Close()
+		}
+
+		if len(busy) == 0 && !anonymous {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.WithField("connections", len(busy)).Warn("Closing IMAP connections with commands in progress")
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	s.Close()
+}
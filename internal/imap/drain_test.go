@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package imap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTo(t *testing.T, w *lineScanner, chunks ...string) {
+	for _, chunk := range chunks {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+}
+
+func TestCommandTrackerFetch(t *testing.T) {
+	ct := newCommandTracker("127.0.0.1:1234")
+	require.False(t, ct.isBusy())
+
+	writeTo(t, ct.client, "A1 UID FE", "TCH 1 BODY[]\r\n")
+	require.True(t, ct.isBusy())
+
+	// Line of the message body looking like tagged response is skipped.
+	writeTo(t, ct.server, "* 1 FETCH (UID 1 BODY[] {18}\r\n", "A1 OK fake\r\nbody\r\n", ")\r\n")
+	require.True(t, ct.isBusy())
+
+	writeTo(t, ct.server, "A1 OK ", "UID FETCH completed\r\n")
+	require.False(t, ct.isBusy())
+}
+
+func TestCommandTrackerAppend(t *testing.T) {
+	ct := newCommandTracker("127.0.0.1:1234")
+
+	writeTo(t, ct.client, "A1 APPEND INBOX {9}\r\n")
+	writeTo(t, ct.server, "+ send literal\r\n")
+	writeTo(t, ct.client, "A2 NOOP\r\n", "\r\n")
+	require.True(t, ct.isBusy())
+
+	writeTo(t, ct.server, "A1 OK APPEND completed\r\n")
+	require.False(t, ct.isBusy())
+}
+
+func TestCommandTrackerIdle(t *testing.T) {
+	ct := newCommandTracker("127.0.0.1:1234")
+
+	writeTo(t, ct.client, "A1 IDLE\r\n")
+	require.True(t, ct.isBusy())
+
+	writeTo(t, ct.server, "+ idling\r\n", "* 2 EXISTS\r\n")
+	require.False(t, ct.isBusy())
+
+	writeTo(t, ct.client, "DONE\r\n")
+	require.True(t, ct.isBusy())
+
+	writeTo(t, ct.server, "A1 OK IDLE terminated\r\n")
+	require.False(t, ct.isBusy())
+}
+
+func TestCommandTrackerPipelining(t *testing.T) {
+	ct := newCommandTracker("127.0.0.1:1234")
+
+	writeTo(t, ct.client, "A1 NOOP\r\nA2 APPEND INBOX {3+}\r\nabc\r\n")
+	writeTo(t, ct.server, "A1 OK NOOP completed\r\n")
+	require.True(t, ct.isBusy())
+
+	writeTo(t, ct.server, "A2 OK APPEND completed\r\n")
+	require.False(t, ct.isBusy())
+}
+
+func TestCommandTrackers(t *testing.T) {
+	ts := newCommandTrackers()
+
+	server, client := net.Pipe()
+	defer client.Close() //nolint[errcheck]
+
+	conn := ts.wrap(server)
+	writeTo(t, conn.tracker.client, "A1 NOOP\r\n")
+
+	busy, anonymous := ts.busy()
+	require.False(t, anonymous)
+	require.Len(t, busy, 1)
+
+	require.NoError(t, conn.Close())
+	busy, _ = ts.busy()
+	require.Empty(t, busy)
+}
@@ -19,6 +19,7 @@ package id
 
 import (
 	imapid "github.com/ProtonMail/go-imap-id"
+	"github.com/emersion/go-imap"
 	imapserver "github.com/emersion/go-imap/server"
 )
 
@@ -26,10 +27,15 @@ type currentClientSetter interface {
 	SetCurrentClient(name, version string)
 }
 
+type clientIdentifier interface {
+	IdentifyClient(connInfo *imap.ConnInfo, id imapid.ID)
+}
+
 // Extension for IMAP server
 type extension struct {
-	extID  imapserver.ConnExtension
-	setter currentClientSetter
+	extID      imapserver.ConnExtension
+	setter     currentClientSetter
+	identifier clientIdentifier
 }
 
 func (ext *extension) Capabilities(conn imapserver.Conn) []string {
@@ -44,8 +50,9 @@ func (ext *extension) Command(name string) imapserver.HandlerFactory {
 	return func() imapserver.Handler {
 		if hdlrID, ok := newIDHandler().(*imapid.Handler); ok {
 			return &handler{
-				hdlrID: hdlrID,
-				setter: ext.setter,
+				hdlrID:     hdlrID,
+				setter:     ext.setter,
+				identifier: ext.identifier,
 			}
 		}
 		return nil
@@ -57,8 +64,9 @@ func (ext *extension) NewConn(conn imapserver.Conn) imapserver.Conn {
 }
 
 type handler struct {
-	hdlrID *imapid.Handler
-	setter currentClientSetter
+	hdlrID     *imapid.Handler
+	setter     currentClientSetter
+	identifier clientIdentifier
 }
 
 func (hdlr *handler) Parse(fields []interface{}) error {
@@ -73,17 +81,20 @@ func (hdlr *handler) Handle(conn imapserver.Conn) error {
 			id[imapid.FieldName],
 			id[imapid.FieldVersion],
 		)
+		hdlr.identifier.IdentifyClient(conn.Info(), id)
 	}
 	return err
 }
 
 // NewExtension returns extension which is adding RFC2871 ID capability, with
-// direct interface to set information about email client to backend.
-func NewExtension(serverID imapid.ID, setter currentClientSetter) imapserver.Extension {
+// direct interface to set information about email client to backend. The
+// identifier is told which client is on which connection.
+func NewExtension(serverID imapid.ID, setter currentClientSetter, identifier clientIdentifier) imapserver.Extension {
 	if conExtID, ok := imapid.NewExtension(serverID).(imapserver.ConnExtension); ok {
 		return &extension{
-			extID:  conExtID,
-			setter: setter,
+			extID:      conExtID,
+			setter:     setter,
+			identifier: identifier,
 		}
 	}
 	return nil
@@ -0,0 +1,116 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package idle implements RFC2177 IDLE extension with optional keepalive.
+//
+// While idling, the server can periodically send untagged OK response so
+// the connection is not considered dead by VPNs or firewalls dropping
+// long-lived connections without any traffic.
+package idle
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapidle "github.com/emersion/go-imap-idle"
+	"github.com/emersion/go-imap/server"
+)
+
+const doneLine = "DONE"
+
+// Handler for IDLE command.
+type Handler struct {
+	imapidle.Command
+
+	keepalive time.Duration
+}
+
+// Handle waits for DONE and sends keepalive responses meanwhile.
+func (h *Handler) Handle(conn server.Conn) error {
+	if err := conn.WriteResp(&imap.ContinuationReq{Info: "idling"}); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- waitForDone(conn)
+	}()
+
+	if h.keepalive == 0 {
+		return <-done
+	}
+
+	ticker := time.NewTicker(h.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if err := conn.WriteResp(&imap.StatusResp{
+				Type: imap.StatusRespOk,
+				Info: "Still here",
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func waitForDone(conn server.Conn) error {
+	scanner := bufio.NewScanner(conn)
+	scanner.Scan()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if strings.ToUpper(scanner.Text()) != doneLine {
+		return errors.New("expected DONE")
+	}
+	return nil
+}
+
+type extension struct {
+	keepalive time.Duration
+}
+
+// NewExtension returns IDLE extension sending keepalive response every
+// keepalive duration. Zero keepalive turns it off.
+func NewExtension(keepalive time.Duration) server.Extension {
+	return &extension{keepalive: keepalive}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{imapidle.Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != imapidle.Capability {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{keepalive: ext.keepalive}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// ConnectionLimits caps number of IMAP connections so a misbehaving client
+// cannot exhaust API quota. Zero means no limit.
+type ConnectionLimits struct {
+	PerAccount         int // Concurrent authenticated connections per account.
+	PerClient          int // Concurrent connections per client IP.
+	PerClientPerMinute int // New connections per client IP in one minute.
+}
+
+const (
+	byeTooManyClientConnections  = "* BYE Too many connections from your address\r\n"
+	byeTooManyAccountConnections = "Too many connections for this account"
+)
+
+// sharedLimits holds the limits used by the listener and the account limit
+// extension so they can be changed while the server is running.
+type sharedLimits struct {
+	lock   sync.RWMutex
+	limits ConnectionLimits
+}
+
+func newSharedLimits(limits ConnectionLimits) *sharedLimits {
+	return &sharedLimits{limits: limits}
+}
+
+func (s *sharedLimits) get() ConnectionLimits {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.limits
+}
+
+func (s *sharedLimits) set(limits ConnectionLimits) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limits = limits
+}
+
+// clientLimitListener refuses connections of clients over the limits with BYE
+// greeting before go-imap greets them.
+type clientLimitListener struct {
+	net.Listener
+
+	limits *sharedLimits
+
+	lock    sync.Mutex
+	active  map[string]int
+	history map[string][]time.Time
+	swept   time.Time
+}
+
+func newClientLimitListener(l net.Listener, limits *sharedLimits) *clientLimitListener {
+	return &clientLimitListener{
+		Listener: l,
+		limits:   limits,
+		active:   map[string]int{},
+		history:  map[string][]time.Time{},
+	}
+}
+
+func (l *clientLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		client := clientIP(conn.RemoteAddr())
+		if l.acquire(client, time.Now()) {
+			return &clientLimitConn{Conn: conn, release: func() { l.release(client, time.Now()) }}, nil
+		}
+
+		log.WithField("client", client).Warn("Too many connections from client")
+		_, _ = conn.Write([]byte(byeTooManyClientConnections))
+		_ = conn.Close()
+	}
+}
+
+// acquire returns whether client can open another connection and counts it.
+func (l *clientLimitListener) acquire(client string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Clients which do not come back are not checked nor released anymore.
+	if now.Sub(l.swept) >= time.Minute {
+		for other := range l.history {
+			l.recent(other, now)
+		}
+		l.swept = now
+	}
+
+	recent := l.recent(client, now)
+
+	limits := l.limits.get()
+	if limits.PerClient > 0 && l.active[client] >= limits.PerClient {
+		return false
+	}
+	if limits.PerClientPerMinute > 0 && len(recent) >= limits.PerClientPerMinute {
+		return false
+	}
+
+	l.active[client]++
+	l.history[client] = append(recent, now)
+	return true
+}
+
+func (l *clientLimitListener) release(client string, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.active[client]--
+	if l.active[client] <= 0 {
+		delete(l.active, client)
+	}
+	l.recent(client, now)
+}
+
+// recent returns connections of the client opened in the last minute. The
+// client is removed from the history once there are none, so the history
+// does not keep every client which ever connected. It must be called with
+// the lock held.
+func (l *clientLimitListener) recent(client string, now time.Time) []time.Time {
+	recent := l.history[client][:0]
+	for _, t := range l.history[client] {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(l.history, client)
+		return nil
+	}
+	l.history[client] = recent
+	return recent
+}
+
+type clientLimitConn struct {
+	net.Conn
+
+	once    sync.Once
+	release func()
+}
+
+func (c *clientLimitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func clientIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return addr.String()
+}
+
+// accountLimitExtension wraps LOGIN and AUTHENTICATE commands and logs out
+// with BYE connections over the limit of the account.
+type accountLimitExtension struct {
+	forEachConn func(func(imapserver.Conn))
+	limits      *sharedLimits
+}
+
+func (ext *accountLimitExtension) Capabilities(imapserver.Conn) []string {
+	return nil
+}
+
+func (ext *accountLimitExtension) Command(name string) imapserver.HandlerFactory {
+	switch name {
+	case "LOGIN":
+		return func() imapserver.Handler {
+			return &accountLimitHandler{Handler: &imapserver.Login{}, ext: ext}
+		}
+	case "AUTHENTICATE":
+		return func() imapserver.Handler {
+			return &accountLimitHandler{Handler: &imapserver.Authenticate{}, ext: ext}
+		}
+	}
+	return nil
+}
+
+// countConnections returns number of connections authenticated to the same
+// account as the user.
+func (ext *accountLimitExtension) countConnections(user *imapUser) (count int) {
+	ext.forEachConn(func(conn imapserver.Conn) {
+		if connUser, ok := conn.Context().User.(*imapUser); ok && connUser.user.ID() == user.user.ID() {
+			count++
+		}
+	})
+	return
+}
+
+type accountLimitHandler struct {
+	imapserver.Handler
+
+	ext *accountLimitExtension
+}
+
+func (h *accountLimitHandler) Handle(conn imapserver.Conn) error {
+	// Successful login returns status with capabilities as error too.
+	err := h.Handler.Handle(conn)
+
+	perAccount := h.ext.limits.get().PerAccount
+	if perAccount <= 0 {
+		return err
+	}
+
+	ctx := conn.Context()
+	user, ok := ctx.User.(*imapUser)
+	if !ok || h.ext.countConnections(user) <= perAccount {
+		return err
+	}
+
+	log.WithField("address", user.currentAddressLowercase).Warn("Too many connections for account")
+	// go-imap calls Logout only for the user left in the context, so the
+	// login is reverted here.
+	if err := user.Logout(); err != nil {
+		log.WithError(err).Warn("Cannot log out user over the limit")
+	}
+	ctx.User = nil
+	ctx.State = imap.LogoutState
+	if err := conn.WriteResp(&imap.StatusResp{
+		Type: imap.StatusRespBye,
+		Info: byeTooManyAccountConnections,
+	}); err != nil {
+		return err
+	}
+	return errors.New(byeTooManyAccountConnections)
+}
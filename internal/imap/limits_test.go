@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapserver "github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientLimitListenerConcurrent(t *testing.T) {
+	l := newClientLimitListener(nil, newSharedLimits(ConnectionLimits{PerClient: 2}))
+	now := time.Now()
+
+	require.True(t, l.acquire("10.0.0.1", now))
+	require.True(t, l.acquire("10.0.0.1", now))
+	require.False(t, l.acquire("10.0.0.1", now))
+	require.True(t, l.acquire("10.0.0.2", now))
+
+	l.release("10.0.0.1", now)
+	require.True(t, l.acquire("10.0.0.1", now))
+}
+
+func TestClientLimitListenerRate(t *testing.T) {
+	l := newClientLimitListener(nil, newSharedLimits(ConnectionLimits{PerClientPerMinute: 2}))
+	now := time.Now()
+
+	require.True(t, l.acquire("10.0.0.1", now))
+	l.release("10.0.0.1", now)
+	require.True(t, l.acquire("10.0.0.1", now.Add(10*time.Second)))
+	l.release("10.0.0.1", now.Add(10*time.Second))
+	require.False(t, l.acquire("10.0.0.1", now.Add(20*time.Second)))
+	require.True(t, l.acquire("10.0.0.1", now.Add(61*time.Second)))
+}
+
+func TestClientLimitListenerChanged(t *testing.T) {
+	limits := newSharedLimits(ConnectionLimits{PerAccount: 5})
+	l := newClientLimitListener(nil, limits)
+	now := time.Now()
+
+	require.True(t, l.acquire("10.0.0.1", now))
+	require.True(t, l.acquire("10.0.0.1", now))
+
+	limits.set(ConnectionLimits{PerClient: 2})
+	require.False(t, l.acquire("10.0.0.1", now))
+	l.release("10.0.0.1", now)
+	require.True(t, l.acquire("10.0.0.1", now))
+}
+
+func TestClientLimitListenerForgetsClients(t *testing.T) {
+	l := newClientLimitListener(nil, newSharedLimits(ConnectionLimits{PerClientPerMinute: 1}))
+	now := time.Now()
+
+	require.True(t, l.acquire("10.0.0.1", now))
+	require.False(t, l.acquire("10.0.0.1", now.Add(10*time.Second)))
+	require.Len(t, l.history, 1)
+
+	// Released connection is forgotten once it is out of the window.
+	l.release("10.0.0.1", now.Add(61*time.Second))
+	require.Empty(t, l.history)
+	require.Empty(t, l.active)
+
+	// Refused client with empty window is not kept.
+	l.limits.set(ConnectionLimits{PerClient: 1, PerClientPerMinute: 1})
+	require.True(t, l.acquire("10.0.0.2", now))
+	require.False(t, l.acquire("10.0.0.2", now.Add(61*time.Second)))
+	require.Empty(t, l.history)
+
+	// Clients which never come back are removed by other clients.
+	require.True(t, l.acquire("10.0.0.3", now.Add(70*time.Second)))
+	l.release("10.0.0.3", now.Add(80*time.Second))
+	require.Len(t, l.history, 1)
+	require.True(t, l.acquire("10.0.0.4", now.Add(140*time.Second)))
+	require.Len(t, l.history, 1)
+	require.Contains(t, l.history, "10.0.0.4")
+}
+
+type testLimitsStoreUser struct {
+	storeUserProvider
+	clients int
+}
+
+func (s *testLimitsStoreUser) ClientConnected()    { s.clients++ }
+func (s *testLimitsStoreUser) ClientDisconnected() { s.clients-- }
+
+type testLimitsStoreAddress struct{ storeAddressProvider }
+
+func (testLimitsStoreAddress) AddressID() string { return "addressID" }
+
+type testLimitsBridgeUser struct{ bridgeUser }
+
+func (testLimitsBridgeUser) ID() string { return "userID" }
+
+type testLimitsConn struct {
+	imapserver.Conn
+	ctx imapserver.Context
+}
+
+func (c *testLimitsConn) Context() *imapserver.Context  { return &c.ctx }
+func (c *testLimitsConn) WriteResp(imap.WriterTo) error { return nil }
+
+// testLoginHandler logs in the user the same way as the backend does.
+type testLoginHandler struct {
+	imapserver.Handler
+	user *imapUser
+}
+
+func (h *testLoginHandler) Handle(conn imapserver.Conn) error {
+	h.user.storeUser.ClientConnected()
+	conn.Context().User = h.user
+	conn.Context().State = imap.AuthenticatedState
+	return nil
+}
+
+func TestAccountLimitLogsOutUserOverLimit(t *testing.T) {
+	storeUser := &testLimitsStoreUser{}
+	user := &imapUser{
+		panicHandler:            noopPanicHandler{},
+		backend:                 &imapBackend{users: map[string]*imapUser{}, usersLocker: &sync.Mutex{}},
+		user:                    testLimitsBridgeUser{},
+		storeUser:               storeUser,
+		storeAddress:            testLimitsStoreAddress{},
+		currentAddressLowercase: "user@pm.me",
+	}
+
+	first, second := &testLimitsConn{}, &testLimitsConn{}
+	ext := &accountLimitExtension{
+		forEachConn: func(f func(imapserver.Conn)) {
+			f(first)
+			f(second)
+		},
+		limits: newSharedLimits(ConnectionLimits{PerAccount: 1}),
+	}
+
+	require.NoError(t, (&accountLimitHandler{Handler: &testLoginHandler{user: user}, ext: ext}).Handle(first))
+	require.Equal(t, 1, storeUser.clients)
+
+	require.Error(t, (&accountLimitHandler{Handler: &testLoginHandler{user: user}, ext: ext}).Handle(second))
+	require.Equal(t, 1, storeUser.clients)
+	require.Nil(t, second.ctx.User)
+	require.Equal(t, imap.ConnState(imap.LogoutState), second.ctx.State)
+}
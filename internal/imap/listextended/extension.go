@@ -0,0 +1,418 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package listextended implements RFC5258 LIST-EXTENDED and RFC3348 CHILDREN
+// extensions.
+//
+// Attributes \HasChildren and \HasNoChildren are computed from names of all
+// listed mailboxes, therefore the backend does not need to know about the
+// hierarchy. LSUB is left to the default handler.
+package listextended
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	specialuse "github.com/emersion/go-imap-specialuse"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Capability extension identifiers.
+const (
+	Capability         = "LIST-EXTENDED"
+	ChildrenCapability = "CHILDREN"
+)
+
+// SubscribedAttr marks subscribed mailbox (RFC5258).
+const SubscribedAttr = "\\Subscribed"
+
+const (
+	optSubscribed     = "SUBSCRIBED"
+	optRemote         = "REMOTE"
+	optRecursiveMatch = "RECURSIVEMATCH"
+	optSpecialUse     = "SPECIAL-USE"
+	optChildren       = "CHILDREN"
+	returnKeyword     = "RETURN"
+	childInfo         = "CHILDINFO"
+)
+
+var specialUseAttrs = map[string]bool{ //nolint[gochecknoglobals]
+	specialuse.All:     true,
+	specialuse.Archive: true,
+	specialuse.Drafts:  true,
+	specialuse.Flagged: true,
+	specialuse.Junk:    true,
+	specialuse.Sent:    true,
+	specialuse.Trash:   true,
+}
+
+// Handler for LIST command with extended syntax.
+type Handler struct {
+	Reference string
+	Patterns  []string
+
+	SelectSubscribed bool
+	SelectSpecialUse bool
+	RecursiveMatch   bool
+	ReturnSubscribed bool
+}
+
+// Parse parses `LIST [(select-opts)] reference pattern|(patterns) [RETURN (return-opts)]`.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if opts, ok := fields[0].([]interface{}); ok {
+			if err := h.parseSelectOptions(opts); err != nil {
+				return err
+			}
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) < 2 {
+		return errors.New("not enough arguments")
+	}
+
+	var err error
+	if h.Reference, err = parseMailboxName(fields[0]); err != nil {
+		return err
+	}
+
+	if patterns, ok := fields[1].([]interface{}); ok {
+		if len(patterns) == 0 {
+			return errors.New("empty list of patterns")
+		}
+		for _, field := range patterns {
+			pattern, err := parseMailboxName(field)
+			if err != nil {
+				return err
+			}
+			h.Patterns = append(h.Patterns, pattern)
+		}
+	} else {
+		pattern, err := parseMailboxName(fields[1])
+		if err != nil {
+			return err
+		}
+		h.Patterns = []string{pattern}
+	}
+
+	fields = fields[2:]
+	if len(fields) > 0 {
+		if err := h.parseReturnOptions(fields); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parseMailboxName(field interface{}) (string, error) {
+	name, err := imap.ParseString(field)
+	if err != nil {
+		return "", err
+	}
+	if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+		return "", err
+	}
+	return imap.CanonicalMailboxName(name), nil
+}
+
+func (h *Handler) parseSelectOptions(opts []interface{}) error {
+	for _, opt := range opts {
+		name, ok := opt.(string)
+		if !ok {
+			return errors.New("selection option must be an atom")
+		}
+		switch strings.ToUpper(name) {
+		case optSubscribed:
+			h.SelectSubscribed = true
+		case optSpecialUse:
+			h.SelectSpecialUse = true
+		case optRecursiveMatch:
+			h.RecursiveMatch = true
+		case optRemote:
+			// There are no remote mailboxes.
+		default:
+			return errors.New("unknown selection option " + name)
+		}
+	}
+
+	if h.RecursiveMatch && !h.SelectSubscribed && !h.SelectSpecialUse {
+		return errors.New("RECURSIVEMATCH requires another selection option")
+	}
+
+	return nil
+}
+
+func (h *Handler) parseReturnOptions(fields []interface{}) error {
+	keyword, ok := fields[0].(string)
+	if !ok || !strings.EqualFold(keyword, returnKeyword) || len(fields) != 2 {
+		return errors.New("unexpected arguments after pattern")
+	}
+
+	opts, ok := fields[1].([]interface{})
+	if !ok {
+		return errors.New("return options must be a list")
+	}
+
+	for _, opt := range opts {
+		name, ok := opt.(string)
+		if !ok {
+			return errors.New("return option must be an atom")
+		}
+		switch strings.ToUpper(name) {
+		case optSubscribed:
+			h.ReturnSubscribed = true
+		case optChildren, optSpecialUse:
+			// Children and special-use attributes are always returned.
+		default:
+			return errors.New("unknown return option " + name)
+		}
+	}
+
+	return nil
+}
+
+// Handle writes LIST responses for mailboxes matching the selection.
+func (h *Handler) Handle(conn server.Conn) error {
+	user := conn.Context().User
+	if user == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	all, err := listInfos(user, false)
+	if err != nil {
+		return err
+	}
+
+	var subscribed []*imap.MailboxInfo
+	if h.SelectSubscribed || h.ReturnSubscribed {
+		if subscribed, err = listInfos(user, true); err != nil {
+			return err
+		}
+	}
+
+	return conn.WriteResp(&response{items: h.list(all, subscribed)})
+}
+
+func listInfos(user backend.User, subscribed bool) ([]*imap.MailboxInfo, error) {
+	mailboxes, err := user.ListMailboxes(subscribed)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*imap.MailboxInfo, 0, len(mailboxes))
+	for _, mbox := range mailboxes {
+		info, err := mbox.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// listItem is one LIST response with optional CHILDINFO extended data.
+type listItem struct {
+	info      *imap.MailboxInfo
+	childInfo []interface{}
+}
+
+// list returns LIST responses for given mailboxes. Attributes of returned
+// mailboxes are extended by children and subscription attributes.
+func (h *Handler) list(all, subscribed []*imap.MailboxInfo) []*listItem {
+	items := []*listItem{}
+
+	// An empty mailbox name is a special request to return the hierarchy
+	// delimiter and the root name of the reference (RFC3501 6.3.8).
+	if len(h.Patterns) == 1 && h.Patterns[0] == "" {
+		if len(all) > 0 {
+			items = append(items, &listItem{info: &imap.MailboxInfo{
+				Attributes: []string{imap.NoSelectAttr},
+				Delimiter:  all[0].Delimiter,
+				Name:       all[0].Delimiter,
+			}})
+		}
+		return items
+	}
+
+	isSubscribed := map[string]bool{}
+	for _, info := range subscribed {
+		isSubscribed[info.Name] = true
+	}
+
+	selected := map[string]bool{}
+	for _, info := range all {
+		selected[info.Name] = h.isSelected(info, isSubscribed[info.Name])
+	}
+
+	for _, info := range all {
+		if !h.matches(info) {
+			continue
+		}
+
+		hasSelectedChild := false
+		hasChildren := false
+		for _, other := range all {
+			if !isChild(info, other) {
+				continue
+			}
+			hasChildren = true
+			if selected[other.Name] {
+				hasSelectedChild = true
+			}
+		}
+
+		if !selected[info.Name] && !(h.RecursiveMatch && hasSelectedChild) {
+			continue
+		}
+
+		item := &listItem{info: &imap.MailboxInfo{
+			Attributes: h.attributes(info, hasChildren, isSubscribed[info.Name]),
+			Delimiter:  info.Delimiter,
+			Name:       info.Name,
+		}}
+		if h.RecursiveMatch && hasSelectedChild {
+			item.childInfo = h.childInfo()
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func (h *Handler) matches(info *imap.MailboxInfo) bool {
+	for _, pattern := range h.Patterns {
+		if info.Match(h.Reference, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) isSelected(info *imap.MailboxInfo, subscribed bool) bool {
+	if h.SelectSubscribed && !subscribed {
+		return false
+	}
+	if h.SelectSpecialUse && !hasSpecialUse(info) {
+		return false
+	}
+	return true
+}
+
+func (h *Handler) attributes(info *imap.MailboxInfo, hasChildren, subscribed bool) []string {
+	attrs := append([]string{}, info.Attributes...)
+
+	// Mailbox which cannot have children has obviously no children.
+	if !hasAttr(info, imap.NoInferiorsAttr) {
+		if hasChildren {
+			attrs = append(attrs, imap.HasChildrenAttr)
+		} else {
+			attrs = append(attrs, imap.HasNoChildrenAttr)
+		}
+	}
+
+	if subscribed && (h.SelectSubscribed || h.ReturnSubscribed) {
+		attrs = append(attrs, SubscribedAttr)
+	}
+
+	return attrs
+}
+
+func (h *Handler) childInfo() []interface{} {
+	criteria := []interface{}{}
+	if h.SelectSubscribed {
+		criteria = append(criteria, optSubscribed)
+	}
+	if h.SelectSpecialUse {
+		criteria = append(criteria, optSpecialUse)
+	}
+	return []interface{}{childInfo, criteria}
+}
+
+func isChild(parent, child *imap.MailboxInfo) bool {
+	if parent.Delimiter == "" {
+		return false
+	}
+	return strings.HasPrefix(child.Name, parent.Name+parent.Delimiter)
+}
+
+func hasSpecialUse(info *imap.MailboxInfo) bool {
+	for _, attr := range info.Attributes {
+		if specialUseAttrs[attr] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(info *imap.MailboxInfo, attr string) bool {
+	for _, a := range info.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+type response struct {
+	items []*listItem
+}
+
+func (r *response) WriteTo(w *imap.Writer) error {
+	for _, item := range r.items {
+		fields := []interface{}{imap.RawString("LIST")}
+		fields = append(fields, item.info.Format()...)
+		if item.childInfo != nil {
+			fields = append(fields, item.childInfo)
+		}
+
+		if err := imap.NewUntaggedResp(fields).WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type extension struct{}
+
+// NewExtension returns new LIST-EXTENDED extension which also announces
+// children attributes.
+func NewExtension() server.Extension {
+	return &extension{}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability, ChildrenCapability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != "LIST" {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{}
+	}
+}
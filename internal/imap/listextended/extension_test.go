@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package listextended
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	specialuse "github.com/emersion/go-imap-specialuse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{"", "*"}))
+	require.Equal(t, &Handler{Patterns: []string{"*"}}, h)
+
+	h = &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		[]interface{}{"subscribed", "RECURSIVEMATCH"},
+		"",
+		[]interface{}{"INBOX", "Folders/%"},
+		"RETURN",
+		[]interface{}{"CHILDREN", "SUBSCRIBED"},
+	}))
+	require.Equal(t, &Handler{
+		Patterns:         []string{"INBOX", "Folders/%"},
+		SelectSubscribed: true,
+		RecursiveMatch:   true,
+		ReturnSubscribed: true,
+	}, h)
+
+	for _, fields := range [][]interface{}{
+		{""},
+		{[]interface{}{"RECURSIVEMATCH"}, "", "*"},
+		{[]interface{}{"UNKNOWN"}, "", "*"},
+		{"", []interface{}{}},
+		{"", "*", "RETURN"},
+		{"", "*", "RETURN", []interface{}{"STATUS"}},
+	} {
+		require.Error(t, (&Handler{}).Parse(fields), "%v", fields)
+	}
+}
+
+func testMailboxes() []*imap.MailboxInfo {
+	return []*imap.MailboxInfo{
+		{Attributes: []string{imap.NoInferiorsAttr}, Delimiter: "/", Name: "INBOX"},
+		{Attributes: []string{imap.NoInferiorsAttr, specialuse.Sent}, Delimiter: "/", Name: "Sent"},
+		{Attributes: []string{imap.NoSelectAttr}, Delimiter: "/", Name: "Folders"},
+		{Attributes: []string{}, Delimiter: "/", Name: "Folders/Family"},
+		{Attributes: []string{}, Delimiter: "/", Name: "Folders/Family/Kids"},
+		{Attributes: []string{imap.NoSelectAttr}, Delimiter: "/", Name: "Labels"},
+	}
+}
+
+func listedNames(items []*listItem) map[string][]string {
+	res := map[string][]string{}
+	for _, item := range items {
+		res[item.info.Name] = item.info.Attributes
+	}
+	return res
+}
+
+func TestListChildren(t *testing.T) {
+	h := &Handler{Patterns: []string{"*"}}
+	require.Equal(t, map[string][]string{
+		"INBOX":               {imap.NoInferiorsAttr},
+		"Sent":                {imap.NoInferiorsAttr, specialuse.Sent},
+		"Folders":             {imap.NoSelectAttr, imap.HasChildrenAttr},
+		"Folders/Family":      {imap.HasChildrenAttr},
+		"Folders/Family/Kids": {imap.HasNoChildrenAttr},
+		"Labels":              {imap.NoSelectAttr, imap.HasNoChildrenAttr},
+	}, listedNames(h.list(testMailboxes(), nil)))
+}
+
+func TestListSubscribed(t *testing.T) {
+	all := testMailboxes()
+	subscribed := []*imap.MailboxInfo{all[0], all[4]}
+
+	h := &Handler{Patterns: []string{"%"}, SelectSubscribed: true}
+	require.Equal(t, map[string][]string{
+		"INBOX": {imap.NoInferiorsAttr, SubscribedAttr},
+	}, listedNames(h.list(all, subscribed)))
+
+	h = &Handler{Patterns: []string{"%"}, SelectSubscribed: true, RecursiveMatch: true}
+	items := h.list(all, subscribed)
+	require.Equal(t, map[string][]string{
+		"INBOX":   {imap.NoInferiorsAttr, SubscribedAttr},
+		"Folders": {imap.NoSelectAttr, imap.HasChildrenAttr},
+	}, listedNames(items))
+	require.Nil(t, items[0].childInfo)
+	require.Equal(t, []interface{}{childInfo, []interface{}{optSubscribed}}, items[1].childInfo)
+
+	h = &Handler{Reference: "Folders", Patterns: []string{"*"}, ReturnSubscribed: true}
+	require.Equal(t, map[string][]string{
+		"Folders/Family":      {imap.HasChildrenAttr},
+		"Folders/Family/Kids": {imap.HasNoChildrenAttr, SubscribedAttr},
+	}, listedNames(h.list(all, subscribed)))
+}
+
+func TestListSpecialUse(t *testing.T) {
+	h := &Handler{Patterns: []string{"*"}, SelectSpecialUse: true}
+	require.Equal(t, map[string][]string{
+		"Sent": {imap.NoInferiorsAttr, specialuse.Sent},
+	}, listedNames(h.list(testMailboxes(), nil)))
+}
+
+func TestListDelimiter(t *testing.T) {
+	h := &Handler{Patterns: []string{""}}
+	require.Equal(t, map[string][]string{
+		"/": {imap.NoSelectAttr},
+	}, listedNames(h.list(testMailboxes(), nil)))
+}
+
+func TestResponse(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := imap.NewWriter(b)
+	r := &response{items: []*listItem{
+		{info: &imap.MailboxInfo{Attributes: []string{imap.HasNoChildrenAttr}, Delimiter: "/", Name: "INBOX"}},
+		{
+			info:      &imap.MailboxInfo{Attributes: []string{}, Delimiter: "/", Name: "Folders"},
+			childInfo: []interface{}{childInfo, []interface{}{optSubscribed}},
+		},
+	}}
+	require.NoError(t, r.WriteTo(w))
+	require.NoError(t, w.Flush())
+	require.Equal(t, "* LIST (\\HasNoChildren) \"/\" INBOX\r\n"+
+		"* LIST () \"/\" \"Folders\" (\"CHILDINFO\" (\"SUBSCRIBED\"))\r\n", b.String())
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/emersion/go-imap"
 	specialuse "github.com/emersion/go-imap-specialuse"
+	imapserver "github.com/emersion/go-imap/server"
 	"github.com/sirupsen/logrus"
 )
 
@@ -102,6 +103,27 @@ func (im *imapMailbox) getFlags() []string {
 	return flags
 }
 
+// getPermanentFlags returns flags client can change in the mailbox.
+func (im *imapMailbox) getPermanentFlags() []string {
+	if im.isReadOnly() {
+		return []string{}
+	}
+
+	flags := []string{
+		imap.SeenFlag, strings.ToUpper(imap.SeenFlag),
+		imap.FlaggedFlag, strings.ToUpper(imap.FlaggedFlag),
+	}
+	if im.canDelete() {
+		flags = append(flags, imap.DeletedFlag, strings.ToUpper(imap.DeletedFlag))
+	}
+	return append(flags,
+		imap.DraftFlag, strings.ToUpper(imap.DraftFlag),
+		message.AppleMailJunkFlag,
+		message.ThunderbirdJunkFlag,
+		message.ThunderbirdNonJunkFlag,
+	)
+}
+
 // Status returns this mailbox status. The fields Name, Flags and
 // PermanentFlags in the returned MailboxStatus must be always populated. This
 // function does not affect the state of any messages in the mailbox. See RFC
@@ -118,15 +140,8 @@ func (im *imapMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, err
 	l.Data["address"] = im.storeAddress.AddressID()
 	status := imap.NewMailboxStatus(im.name, items)
 	status.UidValidity = im.storeMailbox.UIDValidity()
-	status.PermanentFlags = []string{
-		imap.SeenFlag, strings.ToUpper(imap.SeenFlag),
-		imap.FlaggedFlag, strings.ToUpper(imap.FlaggedFlag),
-		imap.DeletedFlag, strings.ToUpper(imap.DeletedFlag),
-		imap.DraftFlag, strings.ToUpper(imap.DraftFlag),
-		message.AppleMailJunkFlag,
-		message.ThunderbirdJunkFlag,
-		message.ThunderbirdNonJunkFlag,
-	}
+	status.ReadOnly = im.isReadOnly()
+	status.PermanentFlags = im.getPermanentFlags()
 
 	dbTotal, dbUnread, dbUnreadSeqNum, err := im.storeMailbox.GetCounts()
 	l.WithFields(logrus.Fields{
@@ -148,20 +163,41 @@ func (im *imapMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, err
 	return status, nil
 }
 
+// StatusFromCache is used for STATUS command instead of Status. It takes
+// message counts synchronised with API when they are up to date, so clients
+// polling STATUS of many mailboxes do not make the store go through all
+// messages. Otherwise it falls back to Status.
+func (im *imapMailbox) StatusFromCache(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	// Called from go-imap in goroutines - we need to handle panics for each function.
+	defer im.panicHandler.HandlePanic()
+
+	total, unread, ok := im.storeMailbox.GetCachedCounts()
+	if !ok {
+		return im.Status(items)
+	}
+
+	status := imap.NewMailboxStatus(im.name, items)
+	status.UidValidity = im.storeMailbox.UIDValidity()
+	status.ReadOnly = im.isReadOnly()
+	status.PermanentFlags = im.getPermanentFlags()
+	status.Messages = uint32(total)
+	status.Unseen = uint32(unread)
+
+	var err error
+	if status.UidNext, err = im.storeMailbox.GetNextUID(); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
 // SetSubscribed adds or removes the mailbox to the server's set of "active"
 // or "subscribed" mailboxes.
 func (im *imapMailbox) SetSubscribed(subscribed bool) error {
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer im.panicHandler.HandlePanic()
 
-	label := im.storeMailbox.LabelID()
-	if subscribed && !im.user.isSubscribed(label) {
-		im.user.removeFromCache(SubscriptionException, label)
-	}
-	if !subscribed && im.user.isSubscribed(label) {
-		im.user.addToCache(SubscriptionException, label)
-	}
-	return nil
+	return im.storeUser.SetSubscribed(im.storeMailbox.LabelID(), subscribed)
 }
 
 // Check requests a checkpoint of the currently selected mailbox. A checkpoint
@@ -177,7 +213,15 @@ func (im *imapMailbox) Check() error {
 // Expunge permanently removes all messages that have the \Deleted flag set
 // from the currently selected mailbox.
 func (im *imapMailbox) Expunge() error {
-	return im.storeMailbox.RemoveDeleted()
+	if im.isReadOnly() {
+		return imapserver.ErrMailboxReadOnly
+	}
+
+	// Nothing can be marked as deleted in All Mail.
+	if !im.canDelete() {
+		return nil
+	}
+	return im.storeMailbox.RemoveDeleted(im.user.backend.expungePolicy(im.name))
 }
 
 func (im *imapMailbox) ListQuotas() ([]string, error) {
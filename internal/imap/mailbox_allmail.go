@@ -0,0 +1,49 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+func (ib *imapBackend) allMailPolicy() string {
+	switch policy := ib.preferences.Get(preferences.AllMailPolicyKey); policy {
+	case preferences.AllMailReadOnly, preferences.AllMailHidden:
+		return policy
+	default:
+		return preferences.AllMailFlagsOnly
+	}
+}
+
+func (im *imapMailbox) isAllMail() bool {
+	return im.storeMailbox.LabelID() == pmapi.AllMailLabel
+}
+
+// isReadOnly returns whether the mailbox has to be selected as read-only.
+func (im *imapMailbox) isReadOnly() bool {
+	if im.isScheduled() {
+		return true
+	}
+	return im.isAllMail() && im.user.backend.allMailPolicy() == preferences.AllMailReadOnly
+}
+
+// canDelete returns whether messages can be marked as deleted and expunged.
+func (im *imapMailbox) canDelete() bool {
+	return !im.isAllMail() && !im.isScheduled()
+}
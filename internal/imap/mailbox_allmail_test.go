@@ -0,0 +1,183 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+	goIMAPBackend "github.com/emersion/go-imap/backend"
+	imapserver "github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/require"
+)
+
+type testAllMailStoreUser struct{ storeUserProvider }
+
+func (testAllMailStoreUser) UserID() string { return "userID" }
+
+type testAllMailStoreAddress struct {
+	storeAddressProvider
+	mailboxes []storeMailboxProvider
+}
+
+func (testAllMailStoreAddress) AddressID() string { return "addressID" }
+
+func (a *testAllMailStoreAddress) ListMailboxes() []storeMailboxProvider { return a.mailboxes }
+
+func (a *testAllMailStoreAddress) GetMailbox(name string) (storeMailboxProvider, error) {
+	for _, mailbox := range a.mailboxes {
+		if mailbox.Name() == name {
+			return mailbox, nil
+		}
+	}
+	return nil, errNoSuchMailbox
+}
+
+type testAllMailStoreMailbox struct {
+	storeMailboxProvider
+	labelID, name  string
+	read, deleted  []string
+	removedDeleted bool
+}
+
+func (m *testAllMailStoreMailbox) LabelID() string { return m.labelID }
+func (m *testAllMailStoreMailbox) Name() string    { return m.name }
+func (m *testAllMailStoreMailbox) IsVirtual() bool { return false }
+
+func (m *testAllMailStoreMailbox) UIDValidity() uint32 { return 1 }
+
+func (m *testAllMailStoreMailbox) GetNextUID() (uint32, error) { return 1, nil }
+
+func (m *testAllMailStoreMailbox) GetCounts() (uint, uint, uint, error) { return 0, 0, 0, nil }
+
+func (m *testAllMailStoreMailbox) GetAPIIDsFromSequenceRange(start, stop uint32) ([]string, error) {
+	return []string{"messageID"}, nil
+}
+
+func (m *testAllMailStoreMailbox) MarkMessagesRead(apiIDs []string) error {
+	m.read = append(m.read, apiIDs...)
+	return nil
+}
+
+func (m *testAllMailStoreMailbox) MarkMessagesDeleted(apiIDs []string) error {
+	m.deleted = append(m.deleted, apiIDs...)
+	return nil
+}
+
+func (m *testAllMailStoreMailbox) RemoveDeleted(string) error {
+	m.removedDeleted = true
+	return nil
+}
+
+func newTestAllMailUser(dir, policy string) (*imapUser, *testAllMailStoreMailbox) {
+	pref := config.NewPreferences(filepath.Join(dir, policy+".json"))
+	pref.Set(preferences.AllMailPolicyKey, policy)
+
+	allMail := &testAllMailStoreMailbox{labelID: pmapi.AllMailLabel, name: "All Mail"}
+	inbox := &testAllMailStoreMailbox{labelID: pmapi.InboxLabel, name: "INBOX"}
+
+	return &imapUser{
+		panicHandler: &noopPanicHandler{},
+		backend:      &imapBackend{preferences: pref},
+		storeUser:    testAllMailStoreUser{},
+		storeAddress: &testAllMailStoreAddress{mailboxes: []storeMailboxProvider{inbox, allMail}},
+	}, allMail
+}
+
+func TestAllMailPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-all-mail")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	tests := []struct {
+		policy     string
+		listed     bool
+		readOnly   bool
+		storeSeen  bool
+		appendErr  error
+		expungeErr error
+	}{
+		{policy: preferences.AllMailFlagsOnly, listed: true, storeSeen: true, appendErr: imapserver.ErrMailboxReadOnly},
+		{policy: preferences.AllMailReadOnly, listed: true, readOnly: true, appendErr: imapserver.ErrMailboxReadOnly, expungeErr: imapserver.ErrMailboxReadOnly},
+		{policy: preferences.AllMailHidden},
+		{policy: "unknown", listed: true, storeSeen: true, appendErr: imapserver.ErrMailboxReadOnly},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.policy, func(t *testing.T) {
+			user, allMail := newTestAllMailUser(dir, tc.policy)
+
+			// LIST
+			mailboxes, err := user.ListMailboxes(false)
+			require.NoError(t, err)
+			names := []string{}
+			for _, mailbox := range mailboxes {
+				names = append(names, mailbox.Name())
+			}
+			require.Contains(t, names, "INBOX")
+			if tc.listed {
+				require.Contains(t, names, "All Mail")
+			} else {
+				require.NotContains(t, names, "All Mail")
+			}
+
+			mailbox, err := user.GetMailbox("All Mail")
+			if !tc.listed {
+				require.Equal(t, goIMAPBackend.ErrNoSuchMailbox, err)
+				return
+			}
+			require.NoError(t, err)
+
+			status, err := mailbox.Status([]imap.StatusItem{})
+			require.NoError(t, err)
+			require.Equal(t, tc.readOnly, status.ReadOnly)
+
+			// APPEND
+			err = mailbox.CreateMessage(nil, time.Now(), bytes.NewBufferString("Subject: test\r\n\r\n"))
+			require.Equal(t, tc.appendErr, err)
+
+			// STORE
+			seqSet, _ := imap.ParseSeqSet("1")
+			err = mailbox.UpdateMessagesFlags(false, seqSet, imap.AddFlags, []string{imap.SeenFlag, imap.DeletedFlag})
+			if tc.readOnly {
+				require.Equal(t, imapserver.ErrMailboxReadOnly, err)
+			} else {
+				require.NoError(t, err)
+			}
+			if tc.storeSeen {
+				require.Equal(t, []string{"messageID"}, allMail.read)
+			} else {
+				require.Empty(t, allMail.read)
+			}
+			require.Empty(t, allMail.deleted)
+
+			// EXPUNGE
+			require.Equal(t, tc.expungeErr, mailbox.Expunge())
+			require.False(t, allMail.removedDeleted)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"encoding/json"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+)
+
+// expungePolicy returns what EXPUNGE means in the mailbox with given name.
+// Policy set for the mailbox in preferences.ExpungeOverridesKey takes
+// precedence over preferences.ExpungePolicyKey. Unknown policies are ignored.
+func (ib *imapBackend) expungePolicy(mailboxName string) string {
+	overrides := map[string]string{}
+	if err := json.Unmarshal([]byte(ib.preferences.Get(preferences.ExpungeOverridesKey)), &overrides); err != nil {
+		log.WithError(err).Warn("Cannot parse expunge policy overrides")
+	}
+
+	if policy, ok := overrides[mailboxName]; ok {
+		if store.IsExpungePolicy(policy) {
+			return policy
+		}
+		log.WithField("mailbox", mailboxName).WithField("policy", policy).Warn("Unknown expunge policy")
+	}
+
+	if policy := ib.preferences.Get(preferences.ExpungePolicyKey); store.IsExpungePolicy(policy) {
+		return policy
+	}
+
+	return store.ExpungeDefault
+}
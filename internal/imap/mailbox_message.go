@@ -18,12 +18,15 @@
 package imap
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/mail"
 	"net/textproto"
+	"os"
 	"sort"
 	"strings"
 	"time"
@@ -31,15 +34,26 @@ import (
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/ProtonMail/proton-bridge/internal/imap/cache"
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/parallel"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/emersion/go-imap"
+	imapserver "github.com/emersion/go-imap/server"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	openpgperrors "golang.org/x/crypto/openpgp/errors"
 )
 
+// maxAppendSize is the biggest message accepted by APPEND. The literal is
+// read whole into memory by go-imap and parsed whole as well, so the size is
+// limited explicitly to bound the memory. It leaves room for MIME encoding
+// of attachments up to the size of the biggest upload allowed by API.
+const maxAppendSize = 48 * 1024 * 1024
+
+// errAppendTooBig is returned by APPEND of message bigger than maxAppendSize.
+var errAppendTooBig = errors.New("[TOOBIG] Message is too big") //nolint[gochecknoglobals]
+
 type doNotCacheError struct{ e error }
 
 func (dnc *doNotCacheError) Error() string { return dnc.e.Error() }
@@ -66,6 +80,20 @@ func (im *imapMailbox) CreateMessage(flags []string, date time.Time, body imap.L
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer im.panicHandler.HandlePanic()
 
+	if im.storeMailbox.IsVirtual() {
+		return store.ErrVirtualMailboxOpNotAllowed
+	}
+
+	// All Mail only shows messages of other mailboxes, nothing can be added
+	// to it whatever its policy is.
+	if im.isScheduled() || im.isAllMail() {
+		return imapserver.ErrMailboxReadOnly
+	}
+
+	if body.Len() > maxAppendSize {
+		return errAppendTooBig
+	}
+
 	m, _, _, readers, err := message.Parse(body, "", "")
 	if err != nil {
 		return err
@@ -110,6 +138,13 @@ func (im *imapMailbox) CreateMessage(flags []string, date time.Time, body imap.L
 	// We need to make sure this is an import, and not a sent message from this account
 	// (sent messages from the account will be added by the event loop).
 	if im.storeMailbox.LabelID() == pmapi.SentLabel {
+		// The message was just sent through SMTP and API already created
+		// the copy in Sent. It may not be in the store yet.
+		if apiID, ok := im.storeUser.FindSentMessage(m.Header); ok {
+			im.log.WithField("msgID", apiID).Info("Ignoring APPEND of message sent by SMTP")
+			return uidplus.AppendResponse(im.storeMailbox.UIDValidity(), im.storeMailbox.GetUIDList([]string{apiID}))
+		}
+
 		sanitizedSender := pmapi.SanitizeEmail(m.Sender.Address)
 
 		// Check whether this message was sent by a bridge user.
@@ -179,11 +214,31 @@ func (im *imapMailbox) CreateMessage(flags []string, date time.Time, body imap.L
 	return uidplus.AppendResponse(im.storeMailbox.UIDValidity(), targetSeq)
 }
 
+// importMessage encrypts the message into a spool file from which it is
+// uploaded, so big messages (e.g. when archiving to a folder) are not kept
+// in memory twice. The spool contains only the encrypted message.
 func (im *imapMailbox) importMessage(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) (err error) { // nolint[funlen]
-	body, err := message.BuildEncrypted(m, readers, kr)
+	spool, err := createSpoolFile(im.user.backend.spoolDir, "append-")
 	if err != nil {
+		return errors.Wrap(err, "cannot create spool file")
+	}
+	defer func() {
+		_ = spool.Close()
+		if err := os.Remove(spool.Name()); err != nil {
+			im.log.WithError(err).Warn("Cannot remove spool file")
+		}
+	}()
+
+	w := bufio.NewWriter(spool)
+	if err := message.WriteEncrypted(w, m, readers, kr); err != nil {
 		return err
 	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "cannot write spool file")
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "cannot rewind spool file")
+	}
 
 	labels := []string{}
 	for _, l := range m.LabelIDs {
@@ -192,7 +247,7 @@ func (im *imapMailbox) importMessage(m *pmapi.Message, readers []io.Reader, kr *
 		}
 	}
 
-	return im.storeMailbox.ImportMessage(m, body, labels)
+	return im.storeMailbox.ImportMessage(m, spool, labels)
 }
 
 func (im *imapMailbox) getMessage(storeMessage storeMessageProvider, items []imap.FetchItem) (msg *imap.Message, err error) {
@@ -212,7 +267,7 @@ func (im *imapMailbox) getMessage(storeMessage storeMessageProvider, items []ima
 			msg.Envelope = message.GetEnvelope(m)
 		case imap.FetchBody, imap.FetchBodyStructure:
 			var structure *message.BodyStructure
-			if structure, _, err = im.getBodyStructure(storeMessage); err != nil {
+			if structure, err = im.getStoredBodyStructure(storeMessage); err != nil {
 				return
 			}
 			if msg.BodyStructure, err = structure.IMAPBodyStructure([]int{}); err != nil {
@@ -229,9 +284,11 @@ func (im *imapMailbox) getMessage(storeMessage storeMessageProvider, items []ima
 			// Size attribute on the server counts encrypted data. The value is cleared
 			// on our part and we need to compute "real" size of decrypted data.
 			if m.Size <= 0 {
-				if _, _, err = im.getBodyStructure(storeMessage); err != nil {
+				var body cache.Body
+				if _, body, err = im.getBodyStructure(storeMessage); err != nil {
 					return
 				}
+				releaseBody(body)
 			}
 			msg.Size = uint32(m.Size)
 		case imap.FetchUid:
@@ -261,21 +318,36 @@ func (im *imapMailbox) getLiteralForSection(itemSection imap.FetchItem, msg *ima
 	}
 
 	msg.Body[section] = literal
+
+	// Client reading whole message will most likely read the next one soon.
+	if section.Specifier == imap.EntireSpecifier || section.Specifier == imap.TextSpecifier {
+		im.prefetchAfter(msg.SeqNum)
+	}
 	return nil
 }
 
 func (im *imapMailbox) getBodyStructure(storeMessage storeMessageProvider) (
 	structure *message.BodyStructure,
-	bodyReader *bytes.Reader, err error,
+	bodyReader cache.Body, err error,
 ) {
 	m := storeMessage.Message()
 	id := im.storeUser.UserID() + m.ID
 	cache.BuildLock(id)
-	if bodyReader, structure = cache.LoadMail(id); bodyReader.Len() == 0 || structure == nil {
-		var body []byte
+	if bodyReader, structure = cache.LoadMail(id); bodyReader == nil || bodyReader.Size() == 0 || structure == nil {
+		releaseBody(bodyReader)
+		bodyReader = nil
+
+		if cachedStructure, cachedBody := im.loadCachedBody(storeMessage); cachedStructure != nil {
+			bodyReader = bytes.NewReader(cachedBody)
+			cache.SaveMail(id, bodyReader, cachedStructure)
+			cache.BuildUnlock(id)
+			return cachedStructure, bodyReader, nil
+		}
+
+		var body cache.Body
 		structure, body, err = im.buildMessage(m)
-		if err == nil && structure != nil && len(body) > 0 {
-			m.Size = int64(len(body))
+		if err == nil && structure != nil && body.Size() > 0 {
+			m.Size = body.Size()
 			if err := storeMessage.SetSize(m.Size); err != nil {
 				im.log.WithError(err).
 					WithField("newSize", m.Size).
@@ -287,22 +359,130 @@ func (im *imapMailbox) getBodyStructure(storeMessage storeMessageProvider) (
 					WithField("msgID", m.ID).
 					Warn("Cannot update header while building")
 			}
-			// Drafts can change and we don't want to cache them.
-			if !isMessageInDraftFolder(m) {
+			// Drafts can change and we don't want to cache them. Nothing is
+			// cached when the store must not keep bodies. Messages with
+			// attachments are not kept on disk whole, their attachments are
+			// cached separately so big ones do not evict many small bodies.
+			if !isMessageInDraftFolder(m) && im.storeUser.KeepsBodies() {
+				// The body is acquired before it is cached because other
+				// messages can evict it from the cache right away.
+				bodyReader = acquireBody(body)
 				cache.SaveMail(id, body, structure)
+				if memBody, ok := body.(*bytes.Reader); ok && len(m.Attachments) == 0 {
+					im.storeUser.SaveCachedBody(m.ID, readBody(memBody))
+				}
+				if err := storeMessage.SetBodyStructure(structure); err != nil {
+					im.log.WithError(err).
+						WithField("msgID", m.ID).
+						Warn("Cannot update body structure while building")
+				}
+			} else {
+				bodyReader = keepInMemory(body)
 			}
-			bodyReader = bytes.NewReader(body)
 		}
 		if _, ok := err.(*doNotCacheError); ok {
 			im.log.WithField("msgID", m.ID).Errorf("do not cache message: %v", err)
 			err = nil
-			bodyReader = bytes.NewReader(body)
+			bodyReader = keepInMemory(body)
 		}
 	}
 	cache.BuildUnlock(id)
 	return structure, bodyReader, err
 }
 
+// keepInMemory returns the body read into memory if it is spooled. Only
+// the cache removes spools, so bodies which are not cached cannot stay in
+// a spool.
+func keepInMemory(body cache.Body) cache.Body {
+	if body == nil {
+		return bytes.NewReader(nil)
+	}
+	spool, ok := body.(*messageSpool)
+	if !ok {
+		return body
+	}
+	defer spool.Close() //nolint[errcheck]
+
+	return bytes.NewReader(readBody(spool))
+}
+
+// acquireBody returns reader of the body which must be released by
+// releaseBody when it is not needed anymore.
+func acquireBody(body cache.Body) cache.Body {
+	if shared, ok := body.(cache.SharedBody); ok {
+		return shared.Acquire()
+	}
+	return body
+}
+
+// releaseBody releases the body returned by getBodyStructure or acquireBody.
+// Spools are removed from the disk only after all their readers are released.
+func releaseBody(body cache.Body) {
+	if reader, ok := body.(*spoolReader); ok {
+		_ = reader.Close()
+	}
+}
+
+// readBody returns whole content of the body.
+func readBody(body cache.Body) []byte {
+	data := make([]byte, body.Size())
+	n, _ := body.ReadAt(data, 0)
+	return data[:n]
+}
+
+// loadCachedBody returns the message from the on-disk body cache together
+// with its body structure, stored one or parsed from the body. The stored one
+// is used only when it matches the length of the body whose header is built
+// again by the store. Drafts are never cached because they can change.
+func (im *imapMailbox) loadCachedBody(storeMessage storeMessageProvider) (*message.BodyStructure, []byte) {
+	m := storeMessage.Message()
+	if isMessageInDraftFolder(m) {
+		return nil, nil
+	}
+
+	body, ok := im.storeUser.LoadCachedBody(m.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	structure, err := storeMessage.GetBodyStructure()
+	if err == nil && structure != nil {
+		if r, errSection := structure.GetSectionReader(bytes.NewReader(body), nil); errSection != nil || r.Size() != int64(len(body)) {
+			structure = nil
+		}
+	}
+	if err != nil || structure == nil {
+		if structure, err = message.NewBodyStructure(bytes.NewReader(body)); err != nil {
+			im.log.WithError(err).WithField("msgID", m.ID).Warn("Cannot parse cached body")
+			return nil, nil
+		}
+	}
+
+	return structure, body
+}
+
+// getStoredBodyStructure returns the body structure persisted in the store
+// if there is any. Otherwise it builds the message to get it. Clients like
+// Apple Mail fetch BODYSTRUCTURE for whole folders which would otherwise mean
+// downloading and decrypting every message again and again.
+func (im *imapMailbox) getStoredBodyStructure(storeMessage storeMessageProvider) (*message.BodyStructure, error) {
+	if !isMessageInDraftFolder(storeMessage.Message()) {
+		structure, err := storeMessage.GetBodyStructure()
+		if err != nil {
+			im.log.WithError(err).
+				WithField("msgID", storeMessage.ID()).
+				Warn("Cannot load stored body structure")
+		}
+		if structure != nil {
+			return structure, nil
+		}
+	}
+
+	structure, body, err := im.getBodyStructure(storeMessage)
+	releaseBody(body)
+	return structure, err
+}
+
 func isMessageInDraftFolder(m *pmapi.Message) bool {
 	for _, labelID := range m.LabelIDs {
 		if labelID == pmapi.DraftLabel {
@@ -316,10 +496,11 @@ func isMessageInDraftFolder(m *pmapi.Message) bool {
 // extract data (header,body, both) and trim the output if needed.
 func (im *imapMailbox) getMessageBodySection(storeMessage storeMessageProvider, section *imap.BodySectionName) (literal imap.Literal, err error) { // nolint[funlen]
 	var (
-		structure  *message.BodyStructure
-		bodyReader *bytes.Reader
-		header     textproto.MIMEHeader
-		response   []byte
+		structure     *message.BodyStructure
+		bodyReader    cache.Body
+		header        textproto.MIMEHeader
+		response      []byte
+		sectionReader *io.SectionReader
 	)
 
 	im.log.WithField("msgID", storeMessage.ID()).Trace("Getting message body")
@@ -353,11 +534,11 @@ func (im *imapMailbox) getMessageBodySection(storeMessage storeMessageProvider,
 		switch {
 		case section.Specifier == imap.EntireSpecifier && len(section.Path) == 0:
 			//  An empty section specification refers to the entire message, including the header.
-			response, err = structure.GetSection(bodyReader, section.Path)
+			sectionReader, err = structure.GetSectionReader(bodyReader, section.Path)
 		case section.Specifier == imap.TextSpecifier || (section.Specifier == imap.EntireSpecifier && len(section.Path) != 0):
 			// The TEXT specifier refers to the content of the message (or section), omitting the [RFC-2822] header.
 			// Non-empty section with no specifier (imap.EntireSpecifier) refers to section content without header.
-			response, err = structure.GetSectionContent(bodyReader, section.Path)
+			sectionReader, err = structure.GetSectionContentReader(bodyReader, section.Path)
 		case section.Specifier == imap.MIMESpecifier:
 			// The MIME part specifier refers to the [MIME-IMB] header for this part.
 			fallthrough
@@ -369,9 +550,18 @@ func (im *imapMailbox) getMessageBodySection(storeMessage storeMessageProvider,
 	}
 
 	if err != nil {
+		releaseBody(bodyReader)
 		return
 	}
 
+	// Body sections are served as views into the built message without copying
+	// them. Big messages are read from the spool only as far as requested and
+	// the spool is released once the literal is sent.
+	if sectionReader != nil {
+		return newPartialLiteral(section, sectionReader, bodyReader), nil
+	}
+	releaseBody(bodyReader)
+
 	// Filter header. Options are: all fields, only selected fields, all fields except selected.
 	if header != nil {
 		// remove fields
@@ -411,6 +601,44 @@ func (im *imapMailbox) getMessageBodySection(storeMessage storeMessageProvider,
 	return literal, nil
 }
 
+// sectionLiteral is imap.Literal reading only part of the built message.
+// The body is released when the literal is read to the end.
+type sectionLiteral struct {
+	*io.SectionReader
+	body cache.Body
+}
+
+func (l *sectionLiteral) Read(p []byte) (int, error) {
+	n, err := l.SectionReader.Read(p)
+	if err == io.EOF {
+		releaseBody(l.body)
+	}
+	return n, err
+}
+
+func (l *sectionLiteral) Len() int {
+	return int(l.Size())
+}
+
+// newPartialLiteral returns literal for the range requested by partial FETCH
+// (`<offset.size>`) with the same semantics as imap.BodySectionName.ExtractPartial.
+func newPartialLiteral(section *imap.BodySectionName, r *io.SectionReader, body cache.Body) imap.Literal {
+	if len(section.Partial) != 2 {
+		return &sectionLiteral{r, body}
+	}
+
+	from := int64(section.Partial[0])
+	to := from + int64(section.Partial[1])
+	if from > r.Size() {
+		from, to = 0, 0
+	}
+	if to > r.Size() {
+		to = r.Size()
+	}
+
+	return &sectionLiteral{io.NewSectionReader(r, from, to-from), body}
+}
+
 func (im *imapMailbox) fetchMessage(m *pmapi.Message) (err error) {
 	im.log.Trace("Fetching message")
 
@@ -453,6 +681,10 @@ func (im *imapMailbox) writeMessageBody(w io.Writer, m *pmapi.Message) (err erro
 }
 
 func (im *imapMailbox) writeAttachmentBody(w io.Writer, m *pmapi.Message, att *pmapi.Attachment) (err error) {
+	if data, ok := im.storeUser.LoadCachedAttachment(att.ID); ok {
+		return message.EncodeAttachmentBody(w, bytes.NewReader(data))
+	}
+
 	// Retrieve encrypted attachment.
 	r, err := im.user.client().GetAttachment(att.ID)
 	if err != nil {
@@ -465,7 +697,18 @@ func (im *imapMailbox) writeAttachmentBody(w io.Writer, m *pmapi.Message, att *p
 		return errors.Wrap(err, "failed to get keyring for address ID")
 	}
 
-	if err = message.WriteAttachmentBody(w, kr, m, att, r); err != nil {
+	dr, decrypted, err := message.DecryptAttachment(kr, att, r)
+	if err == nil && decrypted && im.storeUser.KeepsBodies() {
+		var data []byte
+		if data, err = ioutil.ReadAll(dr); err == nil {
+			im.storeUser.SaveCachedAttachment(att.ID, data)
+			dr = bytes.NewReader(data)
+		}
+	}
+	if err == nil {
+		err = message.EncodeAttachmentBody(w, dr)
+	}
+	if err != nil {
 		// Returning an error here makes certain mail clients behave badly,
 		// trying to retrieve the message again and again.
 		im.log.Warn("Cannot write attachment body: ", err)
@@ -547,7 +790,7 @@ func (im *imapMailbox) setMessageContentType(m *pmapi.Message) (multipartType in
 }
 
 // buildMessage from PM to IMAP.
-func (im *imapMailbox) buildMessage(m *pmapi.Message) (structure *message.BodyStructure, msgBody []byte, err error) {
+func (im *imapMailbox) buildMessage(m *pmapi.Message) (structure *message.BodyStructure, msgBody cache.Body, err error) {
 	im.log.Trace("Building message")
 
 	var errNoCache doNotCacheError
@@ -598,13 +841,52 @@ func (im *imapMailbox) buildMessage(m *pmapi.Message) (structure *message.BodySt
 	return structure, msgBody, err
 }
 
-func (im *imapMailbox) buildMessageInner(m *pmapi.Message, kr *crypto.KeyRing) (structure *message.BodyStructure, msgBody []byte, err error) { // nolint[funlen]
+// buildMessageInner builds the message in memory or, when it is big and will
+// be cached, in a spool file.
+func (im *imapMailbox) buildMessageInner(m *pmapi.Message, kr *crypto.KeyRing) (structure *message.BodyStructure, msgBody cache.Body, err error) {
 	multipartType, err := im.setMessageContentType(m)
 	if err != nil {
 		return
 	}
 
-	tmpBuf := &bytes.Buffer{}
+	if len(m.Attachments) == 0 || m.Size < spoolMessageSize || isMessageInDraftFolder(m) || !im.storeUser.KeepsBodies() {
+		buf := &bytes.Buffer{}
+		if err = im.writeMessage(buf, m, kr, multipartType); err != nil {
+			return
+		}
+		msgBody = bytes.NewReader(buf.Bytes())
+	} else {
+		spool, errSpool := newMessageSpool(im.user.backend.spoolDir)
+		if errSpool != nil {
+			return nil, nil, errSpool
+		}
+		w := bufio.NewWriter(spool)
+		if err = im.writeMessage(w, m, kr, multipartType); err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			_ = spool.Close()
+			return
+		}
+		msgBody = spool
+	}
+
+	structure, err = message.NewBodyStructure(io.NewSectionReader(msgBody, 0, msgBody.Size()))
+	if err != nil {
+		// NOTE: We need to set structure if it fails and is empty.
+		if structure == nil {
+			structure = &message.BodyStructure{}
+		}
+		// The message is built again as custom message or not used at all.
+		if spool, ok := msgBody.(*messageSpool); ok {
+			_ = spool.Close()
+		}
+	}
+	return structure, msgBody, err
+}
+
+// writeMessage writes the whole message with decrypted body and attachments.
+func (im *imapMailbox) writeMessage(tmpBuf io.Writer, m *pmapi.Message, kr *crypto.KeyRing, multipartType int) (err error) { // nolint[funlen]
 	mainHeader := message.GetHeader(m)
 	if err = writeHeader(tmpBuf, mainHeader); err != nil {
 		return
@@ -692,14 +974,5 @@ func (im *imapMailbox) buildMessageInner(m *pmapi.Message, kr *crypto.KeyRing) (
 		fmt.Fprintf(tmpBuf, "\r\n\r\nUknown multipart type: %d\r\n\r\n", multipartType)
 	}
 
-	// We need to copy buffer before building body structure.
-	msgBody = tmpBuf.Bytes()
-	structure, err = message.NewBodyStructure(tmpBuf)
-	if err != nil {
-		// NOTE: We need to set structure if it fails and is empty.
-		if structure == nil {
-			structure = &message.BodyStructure{}
-		}
-	}
-	return structure, msgBody, err
+	return nil
 }
@@ -19,8 +19,12 @@ package imap
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 
+	"github.com/emersion/go-imap"
 	"github.com/stretchr/testify/require"
 )
 
@@ -39,3 +43,30 @@ func TestDoNotCache(t *testing.T) {
 	dnc.add(errors.New("third"))
 	t.Log(dnc.errorOrNil())
 }
+
+func TestPartialLiteral(t *testing.T) {
+	body := "0123456789"
+
+	tests := []struct {
+		partial []int
+		want    string
+	}{
+		{nil, body},
+		{[]int{0, 4}, "0123"},
+		{[]int{3, 4}, "3456"},
+		{[]int{8, 10}, "89"},
+		{[]int{10, 5}, ""},
+		{[]int{20, 5}, ""},
+	}
+	for _, tc := range tests {
+		section := &imap.BodySectionName{Partial: tc.partial}
+		r := io.NewSectionReader(strings.NewReader(body), 0, int64(len(body)))
+
+		literal := newPartialLiteral(section, r, nil)
+		have, err := ioutil.ReadAll(literal)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, string(have))
+		require.Equal(t, len(tc.want), literal.Len())
+		require.Equal(t, string(section.ExtractPartial([]byte(body))), string(have))
+	}
+}
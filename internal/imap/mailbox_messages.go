@@ -26,10 +26,12 @@ import (
 	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/parallel"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/emersion/go-imap"
+	imapserver "github.com/emersion/go-imap/server"
 	"github.com/sirupsen/logrus"
 )
 
@@ -46,6 +48,10 @@ func (im *imapMailbox) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, operat
 	// Called from go-imap in goroutines - we need to handle panics for each function.
 	defer im.panicHandler.HandlePanic()
 
+	if im.isReadOnly() {
+		return imapserver.ErrMailboxReadOnly
+	}
+
 	messageIDs, err := im.apiIDsFromSeqSet(uid, seqSet)
 	if err != nil || len(messageIDs) == 0 {
 		return err
@@ -100,11 +106,14 @@ func (im *imapMailbox) setFlags(messageIDs, flags []string) error { //nolint
 		}
 	}
 
-	if deleted {
+	switch {
+	case !im.canDelete():
+		// \Deleted is not among permanent flags and is ignored.
+	case deleted:
 		if err := im.storeMailbox.MarkMessagesDeleted(messageIDs); err != nil {
 			return err
 		}
-	} else {
+	default:
 		if err := im.storeMailbox.MarkMessagesUndeleted(messageIDs); err != nil {
 			return err
 		}
@@ -154,6 +163,9 @@ func (im *imapMailbox) addOrRemoveFlags(operation imap.FlagsOp, messageIDs, flag
 				}
 			}
 		case imap.DeletedFlag:
+			if !im.canDelete() {
+				continue
+			}
 			switch operation {
 			case imap.AddFlags:
 				if err := im.storeMailbox.MarkMessagesDeleted(messageIDs); err != nil {
@@ -218,6 +230,26 @@ func (im *imapMailbox) labelMessages(uid bool, seqSet *imap.SeqSet, targetLabel
 		return err
 	}
 
+	// Messages cannot be removed from virtual mailbox, therefore moving
+	// would end up as copy.
+	if move && im.storeMailbox.IsVirtual() {
+		return store.ErrVirtualMailboxOpNotAllowed
+	}
+
+	// Scheduled and snoozed messages can be copied out but not moved.
+	if move && im.isScheduled() {
+		return imapserver.ErrMailboxReadOnly
+	}
+
+	// Messages stay in All Mail after labeling so moving out of it is copy
+	// unless it is read-only.
+	if move && im.isAllMail() {
+		if im.isReadOnly() {
+			return imapserver.ErrMailboxReadOnly
+		}
+		move = false
+	}
+
 	// It is needed to get UID list before LabelingMessages because
 	// messages can be removed from source during labeling (e.g. folder1 -> folder2).
 	sourceSeqSet := im.storeMailbox.GetUIDList(messageIDs)
@@ -227,6 +259,10 @@ func (im *imapMailbox) labelMessages(uid bool, seqSet *imap.SeqSet, targetLabel
 		return err
 	}
 
+	if isScheduledLabel(targetStoreMailbox.LabelID()) {
+		return imapserver.ErrMailboxReadOnly
+	}
+
 	deletedIDs := []string{}
 	allDeletedIDs, err := im.storeMailbox.GetDeletedAPIIDs()
 	if err != nil {
@@ -273,8 +309,13 @@ func (im *imapMailbox) SearchMessages(isUID bool, criteria *imap.SearchCriteria)
 		return nil, errors.New("unsupported search query")
 	}
 
+	// Body and Text criteria are matched by words using the local search
+	// index (if enabled); nil means they are not applied.
+	var textMatches map[string]bool
 	if criteria.Body != nil || criteria.Text != nil {
-		log.Warn("Body and Text criteria not applied.")
+		if textMatches, err = im.storeUser.SearchIndexed(criteria.Body, criteria.Text); err != nil {
+			log.WithError(err).Warn("Body and Text criteria not applied.")
+		}
 	}
 
 	var apiIDs []string
@@ -296,6 +337,10 @@ func (im *imapMailbox) SearchMessages(isUID bool, criteria *imap.SearchCriteria)
 	}
 
 	for _, apiID := range apiIDs {
+		if textMatches != nil && !textMatches[apiID] {
+			continue
+		}
+
 		// Get message.
 		storeMessage, err := im.storeMailbox.GetMessage(apiID)
 		if err != nil {
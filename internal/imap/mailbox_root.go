@@ -26,8 +26,8 @@ import (
 	imap "github.com/emersion/go-imap"
 )
 
-// The mailbox containing all custom folders or labels.
-// The purpose of this mailbox is to see "Folders" and "Labels"
+// The mailbox containing all custom folders, labels or virtual mailboxes.
+// The purpose of this mailbox is to see "Folders", "Labels" and "Views"
 // at the root of the mailbox tree, e.g.:
 //
 // 		Folders 					<< this
@@ -36,53 +36,47 @@ import (
 //		Labels						<< this
 //			Labels/Security
 //
+//		Views						<< this
+//			Views/Starred
+//
 // This mailbox cannot be modified or read in any way.
 type imapRootMailbox struct {
-	isFolder bool
+	name string
 }
 
 func newFoldersRootMailbox() *imapRootMailbox {
-	return &imapRootMailbox{isFolder: true}
+	return &imapRootMailbox{name: store.UserFoldersMailboxName}
 }
 
 func newLabelsRootMailbox() *imapRootMailbox {
-	return &imapRootMailbox{isFolder: false}
+	return &imapRootMailbox{name: store.UserLabelsMailboxName}
+}
+
+func newVirtualRootMailbox() *imapRootMailbox {
+	return &imapRootMailbox{name: store.VirtualMailboxesName}
 }
 
 func (m *imapRootMailbox) Name() string {
-	if m.isFolder {
-		return store.UserFoldersMailboxName
-	}
-	return store.UserLabelsMailboxName
+	return m.name
 }
 
 func (m *imapRootMailbox) Info() (info *imap.MailboxInfo, err error) {
 	info = &imap.MailboxInfo{
 		Attributes: []string{imap.NoSelectAttr},
 		Delimiter:  store.PathDelimiter,
+		Name:       m.name,
 	}
-
-	if m.isFolder {
-		info.Name = store.UserFoldersMailboxName
-	} else {
-		info.Name = store.UserLabelsMailboxName
-	}
-
 	return
 }
 
 func (m *imapRootMailbox) Status(_ []imap.StatusItem) (*imap.MailboxStatus, error) {
 	status := &imap.MailboxStatus{}
-	if m.isFolder {
-		status.Name = store.UserFoldersMailboxName
-	} else {
-		status.Name = store.UserLabelsMailboxName
-	}
+	status.Name = m.name
 	return status, nil
 }
 
 func (m *imapRootMailbox) SetSubscribed(_ bool) error {
-	return errors.New("cannot subscribe or unsubsribe to Labels or Folders mailboxes")
+	return errors.New("cannot subscribe or unsubsribe to Labels, Folders or Views mailboxes")
 }
 
 func (m *imapRootMailbox) Check() error {
@@ -0,0 +1,31 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import "github.com/ProtonMail/proton-bridge/pkg/pmapi"
+
+// isScheduledLabel returns whether the label holds messages waiting for later
+// delivery or snoozed messages. These are managed only by API (messages are
+// scheduled or snoozed in web or mobile app) and therefore are read-only.
+func isScheduledLabel(labelID string) bool {
+	return labelID == pmapi.ScheduledLabel || labelID == pmapi.SnoozedLabel
+}
+
+func (im *imapMailbox) isScheduled() bool {
+	return isScheduledLabel(im.storeMailbox.LabelID())
+}
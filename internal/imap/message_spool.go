@@ -0,0 +1,240 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/cache"
+	"github.com/pkg/errors"
+)
+
+// spoolMessageSize is the size from which messages with attachments are
+// built into a spool file instead of memory.
+const spoolMessageSize = 1024 * 1024
+
+// messageSpool keeps a built message in a temporary file so partial FETCH
+// can read any part of it without the whole message being in memory. The
+// file is encrypted by AES-CTR with a key which exists only in memory, so the
+// decrypted message is never written to disk and any offset can be read.
+// The file is removed when the spool is closed and no reader acquired by
+// Acquire is open anymore.
+type messageSpool struct {
+	lock    sync.RWMutex
+	file    *os.File
+	block   cipher.Block
+	iv      []byte
+	writer  cipher.Stream
+	size    int64
+	readers int
+	closed  bool
+}
+
+// createSpoolFile creates a spool file in the spool folder of the cache
+// which only the user can access; the file is created with mode 0600.
+func createSpoolFile(dir, pattern string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, pattern)
+}
+
+func newMessageSpool(dir string) (*messageSpool, error) {
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := createSpoolFile(dir, "fetch-")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create spool file")
+	}
+
+	return &messageSpool{
+		file:   file,
+		block:  block,
+		iv:     iv,
+		writer: cipher.NewCTR(block, iv),
+	}, nil
+}
+
+// Write appends data to the end of the spool.
+func (s *messageSpool) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+
+	enc := make([]byte, len(p))
+	s.writer.XORKeyStream(enc, p)
+	n, err := s.file.WriteAt(enc, s.size)
+	s.size += int64(n)
+	return n, err
+}
+
+// ReadAt decrypts data at the given offset; the key stream of CTR mode
+// starts at the counter of the block containing the offset.
+func (s *messageSpool) ReadAt(p []byte, off int64) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	return s.readAt(p, off)
+}
+
+// readAt reads the spool file even after the spool is closed as long as
+// the file exists. It must be called with the lock held.
+func (s *messageSpool) readAt(p []byte, off int64) (int, error) {
+	if s.file == nil {
+		return 0, os.ErrClosed
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	if rest := s.size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+
+	n, err := s.file.ReadAt(p, off)
+	s.streamAt(off).XORKeyStream(p[:n], p[:n])
+	if err == nil && off+int64(n) == s.size {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *messageSpool) streamAt(off int64) cipher.Stream {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, s.iv)
+
+	// The counter is the IV incremented as 128-bit big endian number.
+	blocks := uint64(off / aes.BlockSize)
+	low := binary.BigEndian.Uint64(iv[8:])
+	high := binary.BigEndian.Uint64(iv[:8])
+	if low+blocks < low {
+		high++
+	}
+	binary.BigEndian.PutUint64(iv[8:], low+blocks)
+	binary.BigEndian.PutUint64(iv[:8], high)
+
+	stream := cipher.NewCTR(s.block, iv)
+	if skip := int(off % aes.BlockSize); skip > 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	return stream
+}
+
+// Size returns the number of bytes written to the spool.
+func (s *messageSpool) Size() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.size
+}
+
+// Close removes the spool file once all acquired readers are closed.
+// The spool itself cannot be read afterwards.
+func (s *messageSpool) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	return s.removeUnused()
+}
+
+// Acquire returns a reader which keeps the spool file until it is closed.
+// Readers which are not closed, for example literals not sent to a client
+// which disconnected, are closed when garbage collected.
+func (s *messageSpool) Acquire() cache.Reader {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.readers++
+	reader := &spoolReader{spool: s}
+	runtime.SetFinalizer(reader, (*spoolReader).Close)
+	return reader
+}
+
+func (s *messageSpool) release() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.readers--
+	return s.removeUnused()
+}
+
+// removeUnused removes the file of closed spool without readers.
+// It must be called with the lock held.
+func (s *messageSpool) removeUnused() error {
+	if !s.closed || s.readers > 0 || s.file == nil {
+		return nil
+	}
+
+	name := s.file.Name()
+	_ = s.file.Close()
+	s.file = nil
+	return os.Remove(name)
+}
+
+// spoolReader reads the spool on behalf of one FETCH response.
+type spoolReader struct {
+	spool *messageSpool
+	once  sync.Once
+}
+
+func (r *spoolReader) ReadAt(p []byte, off int64) (int, error) {
+	r.spool.lock.RLock()
+	defer r.spool.lock.RUnlock()
+
+	return r.spool.readAt(p, off)
+}
+
+func (r *spoolReader) Size() int64 {
+	return r.spool.Size()
+}
+
+// Close releases the spool. Only the first call has an effect.
+func (r *spoolReader) Close() (err error) {
+	r.once.Do(func() {
+		runtime.SetFinalizer(r, nil)
+		err = r.spool.release()
+	})
+	return
+}
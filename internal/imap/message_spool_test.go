@@ -0,0 +1,141 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/cache"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	spool, err := newMessageSpool(filepath.Join(dir, "imap_spool"))
+	require.NoError(t, err)
+	defer spool.Close() //nolint[errcheck]
+
+	data := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz\r\n"), 1000)
+	for rest := data; len(rest) > 0; {
+		n := 777
+		if n > len(rest) {
+			n = len(rest)
+		}
+		_, err := spool.Write(rest[:n])
+		require.NoError(t, err)
+		rest = rest[n:]
+	}
+	require.Equal(t, int64(len(data)), spool.Size())
+
+	info, err := os.Stat(spool.file.Name())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	require.Equal(t, filepath.Join(dir, "imap_spool"), filepath.Dir(spool.file.Name()))
+
+	onDisk, err := ioutil.ReadFile(spool.file.Name())
+	require.NoError(t, err)
+	require.Equal(t, len(data), len(onDisk))
+	require.False(t, bytes.Contains(onDisk, []byte("0123456789")))
+
+	for _, off := range []int{0, 1, 15, 16, 17, 1000, len(data) - 20} {
+		have := make([]byte, 20)
+		n, err := spool.ReadAt(have, int64(off))
+		require.Equal(t, 20, n)
+		if off+n == len(data) {
+			require.Equal(t, io.EOF, err)
+		} else {
+			require.NoError(t, err)
+		}
+		require.Equal(t, data[off:off+20], have, off)
+	}
+
+	n, err := spool.ReadAt(make([]byte, 10), int64(len(data)))
+	require.Equal(t, 0, n)
+	require.Equal(t, io.EOF, err)
+
+	whole, err := ioutil.ReadAll(io.NewSectionReader(spool, 0, spool.Size()))
+	require.NoError(t, err)
+	require.Equal(t, data, whole)
+
+	section := &imap.BodySectionName{Partial: []int{100, 50}}
+	partial, err := ioutil.ReadAll(newPartialLiteral(section, io.NewSectionReader(spool, 0, spool.Size()), nil))
+	require.NoError(t, err)
+	require.Equal(t, data[100:150], partial)
+}
+
+func TestMessageSpoolClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	spool, err := newMessageSpool(filepath.Join(dir, "imap_spool"))
+	require.NoError(t, err)
+
+	_, err = spool.Write([]byte("message"))
+	require.NoError(t, err)
+	name := spool.file.Name()
+
+	require.NoError(t, spool.Close())
+	_, err = os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = spool.ReadAt(make([]byte, 1), 0)
+	require.Error(t, err)
+	require.NoError(t, spool.Close())
+}
+
+func TestMessageSpoolCloseWithReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	spool, err := newMessageSpool(filepath.Join(dir, "imap_spool"))
+	require.NoError(t, err)
+
+	_, err = spool.Write([]byte("message"))
+	require.NoError(t, err)
+	name := spool.file.Name()
+
+	cache.SaveMail("spool-reader", spool, &message.BodyStructure{})
+	body, _ := cache.LoadMail("spool-reader")
+	require.NotNil(t, body)
+
+	// Evicting the message must not remove the spool being sent to client.
+	cache.Clear()
+	_, err = spool.ReadAt(make([]byte, 1), 0)
+	require.Error(t, err)
+
+	section := &imap.BodySectionName{}
+	literal := newPartialLiteral(section, io.NewSectionReader(body, 0, body.Size()), body)
+	have, err := ioutil.ReadAll(literal)
+	require.NoError(t, err)
+	require.Equal(t, []byte("message"), have)
+
+	_, err = os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+}
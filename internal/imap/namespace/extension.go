@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package namespace implements RFC2342 NAMESPACE extension.
+//
+// Bridge has only personal namespaces. Other users' and shared namespaces
+// are always NIL.
+package namespace
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// Capability extension identifier
+const Capability = "NAMESPACE"
+
+// Namespace is a prefix with hierarchy delimiter.
+type Namespace struct {
+	Prefix    string
+	Delimiter string
+}
+
+func (ns Namespace) format() string {
+	return "(" + quote(ns.Prefix) + " " + quote(ns.Delimiter) + ")"
+}
+
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// Handler for NAMESPACE command.
+type Handler struct {
+	personal []Namespace
+}
+
+// Parse checks that no arguments were passed.
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		return errors.New("no arguments allowed")
+	}
+	return nil
+}
+
+// Handle writes the NAMESPACE response.
+func (h *Handler) Handle(conn server.Conn) error {
+	if conn.Context().User == nil {
+		return server.ErrNotAuthenticated
+	}
+	return conn.WriteResp(newResponse(h.personal))
+}
+
+func newResponse(personal []Namespace) imap.WriterTo {
+	// RFC2342 does not allow spaces between namespace descriptions
+	// therefore the list cannot be formatted as a regular IMAP list.
+	var personalField interface{}
+	if len(personal) > 0 {
+		namespaces := ""
+		for _, ns := range personal {
+			namespaces += ns.format()
+		}
+		personalField = imap.RawString("(" + namespaces + ")")
+	}
+
+	return &imap.DataResp{
+		Fields: []interface{}{imap.RawString(Capability), personalField, nil, nil},
+	}
+}
+
+type extension struct {
+	personal []Namespace
+}
+
+// NewExtension returns new NAMESPACE extension announcing given personal namespaces.
+func NewExtension(personal ...Namespace) server.Extension {
+	return &extension{personal: personal}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	if name != Capability {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &Handler{personal: ext.personal}
+	}
+}
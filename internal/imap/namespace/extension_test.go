@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package namespace
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		personal []Namespace
+		want     string
+	}{
+		{nil, "* NAMESPACE NIL NIL NIL\r\n"},
+		{[]Namespace{{"", "/"}}, "* NAMESPACE ((\"\" \"/\")) NIL NIL\r\n"},
+		{
+			[]Namespace{{"", "/"}, {"Folders/", "/"}, {"Labels/", "/"}},
+			"* NAMESPACE ((\"\" \"/\")(\"Folders/\" \"/\")(\"Labels/\" \"/\")) NIL NIL\r\n",
+		},
+	}
+	for _, tc := range tests {
+		b := &bytes.Buffer{}
+		w := imap.NewWriter(b)
+		require.NoError(t, newResponse(tc.personal).WriteTo(w))
+		require.NoError(t, w.Flush())
+		require.Equal(t, tc.want, b.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse(nil))
+	require.Error(t, h.Parse([]interface{}{"arg"}))
+}
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, `"a\"b\\c"`, quote(`a"b\c`))
+}
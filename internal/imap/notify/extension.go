@@ -0,0 +1,644 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package notify DOES NOT implement full RFC5465!
+//
+// Excluded parts are:
+// * Events for the selected mailbox are always sent as without NOTIFY, i.e.
+//   the SELECTED and SELECTED-DELAYED filters are accepted but not enforced.
+// * Events AnnotationChange, MailboxMetadataChange and ServerMetadataChange
+//   are not supported and are rejected with BADEVENT.
+// * SubscriptionChange is accepted but never sent.
+// * Fetch attributes of MessageNew are ignored, non-selected mailboxes
+//   always get STATUS response.
+//
+// Otherwise, changes in non-selected mailboxes are announced by STATUS
+// response and new mailboxes by LIST response over the same connection.
+package notify
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+	"github.com/sirupsen/logrus"
+)
+
+// Capability extension identifier
+const Capability = "NOTIFY"
+
+const (
+	commandName = "NOTIFY"
+
+	setArg    = "SET"
+	noneArg   = "NONE"
+	statusArg = "STATUS"
+
+	filterSelected        = "SELECTED"
+	filterSelectedDelayed = "SELECTED-DELAYED"
+	filterInboxes         = "INBOXES"
+	filterPersonal        = "PERSONAL"
+	filterSubscribed      = "SUBSCRIBED"
+	filterSubtree         = "SUBTREE"
+	filterMailboxes       = "MAILBOXES"
+
+	// EventMessageNew is sent when new message appears in the mailbox.
+	EventMessageNew = "MessageNew"
+	// EventMessageExpunge is sent when message is removed from the mailbox.
+	EventMessageExpunge = "MessageExpunge"
+	// EventFlagChange is sent when flags of message are changed.
+	EventFlagChange = "FlagChange"
+	// EventMailboxName is sent when mailbox is created.
+	EventMailboxName = "MailboxName"
+	// EventSubscriptionChange is accepted but never sent.
+	EventSubscriptionChange = "SubscriptionChange"
+
+	codeBadEvent = "BADEVENT"
+
+	// sendTimeout is the maximum time to wait for the connection to accept
+	// the notification.
+	sendTimeout = 10 * time.Second
+)
+
+var (
+	log = logrus.WithField("pkg", "imap/notify") //nolint[gochecknoglobals]
+
+	supportedEvents = []string{ //nolint[gochecknoglobals]
+		EventMessageNew,
+		EventMessageExpunge,
+		EventFlagChange,
+		EventMailboxName,
+		EventSubscriptionChange,
+	}
+
+	statusItems = []imap.StatusItem{ //nolint[gochecknoglobals]
+		imap.StatusMessages,
+		imap.StatusUidNext,
+		imap.StatusUidValidity,
+		imap.StatusUnseen,
+	}
+)
+
+// eventGroup is one parenthesised group of NOTIFY SET command.
+type eventGroup struct {
+	filter    string
+	mailboxes []string
+	events    map[string]bool
+}
+
+// settings holds all event groups set by the client.
+type settings struct {
+	status bool
+	groups []eventGroup
+}
+
+// matches returns true if event for non-selected mailbox should be sent.
+func (s *settings) matches(mailbox, event, delimiter string, isSubscribed func(string) bool) bool {
+	for _, group := range s.groups {
+		if !group.events[event] || !group.matchesMailbox(mailbox, delimiter, isSubscribed) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (group *eventGroup) matchesMailbox(mailbox, delimiter string, isSubscribed func(string) bool) bool {
+	switch group.filter {
+	case filterPersonal:
+		return true
+	case filterInboxes:
+		return strings.EqualFold(mailbox, imap.InboxName)
+	case filterSubscribed:
+		return isSubscribed(mailbox)
+	case filterMailboxes:
+		for _, name := range group.mailboxes {
+			if name == mailbox {
+				return true
+			}
+		}
+	case filterSubtree:
+		for _, name := range group.mailboxes {
+			if name == mailbox || strings.HasPrefix(mailbox, name+delimiter) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Handler for NOTIFY command.
+type Handler struct {
+	ext      *extension
+	settings *settings
+}
+
+// Parse NOTIFY NONE or NOTIFY SET [STATUS] (filter events)...
+func (h *Handler) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("no enough arguments")
+	}
+
+	arg, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToUpper(arg) {
+	case noneArg:
+		if len(fields) != 1 {
+			return errors.New("NOTIFY NONE does not take arguments")
+		}
+		return nil
+	case setArg:
+	default:
+		return errors.New("unknown NOTIFY argument " + arg)
+	}
+
+	h.settings = &settings{}
+	fields = fields[1:]
+
+	if len(fields) > 0 {
+		if arg, err := imap.ParseString(fields[0]); err == nil && strings.EqualFold(arg, statusArg) {
+			h.settings.status = true
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) == 0 {
+		return errors.New("NOTIFY SET requires at least one event group")
+	}
+
+	for _, field := range fields {
+		group, err := parseEventGroup(field)
+		if err != nil {
+			return err
+		}
+		h.settings.groups = append(h.settings.groups, group)
+	}
+
+	return nil
+}
+
+func parseEventGroup(field interface{}) (group eventGroup, err error) {
+	fields, ok := field.([]interface{})
+	if !ok || len(fields) < 2 {
+		return group, errors.New("event group must be a list of filter and events")
+	}
+
+	filter, err := imap.ParseString(fields[0])
+	if err != nil {
+		return
+	}
+	group.filter = strings.ToUpper(filter)
+	fields = fields[1:]
+
+	switch group.filter {
+	case filterSelected, filterSelectedDelayed, filterInboxes, filterPersonal, filterSubscribed:
+	case filterSubtree, filterMailboxes:
+		if len(fields) < 2 {
+			return group, errors.New("missing mailboxes for " + group.filter)
+		}
+		if group.mailboxes, err = parseMailboxes(fields[0]); err != nil {
+			return
+		}
+		fields = fields[1:]
+	default:
+		return group, errors.New("unknown filter " + filter)
+	}
+
+	if len(fields) != 1 {
+		return group, errors.New("wrong number of arguments in event group")
+	}
+
+	group.events, err = parseEvents(fields[0])
+	return group, err
+}
+
+func parseMailboxes(field interface{}) (mailboxes []string, err error) {
+	list, ok := field.([]interface{})
+	if !ok {
+		list = []interface{}{field}
+	}
+
+	for _, item := range list {
+		name, err := imap.ParseString(item)
+		if err != nil {
+			return nil, err
+		}
+		if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+			return nil, err
+		}
+		mailboxes = append(mailboxes, imap.CanonicalMailboxName(name))
+	}
+
+	return mailboxes, nil
+}
+
+func parseEvents(field interface{}) (map[string]bool, error) {
+	events := map[string]bool{}
+
+	if none, err := imap.ParseString(field); err == nil && strings.EqualFold(none, noneArg) {
+		return events, nil
+	}
+
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil, errors.New("events must be a list or NONE")
+	}
+
+	for _, item := range list {
+		// MessageNew can be followed by list of fetch attributes which we ignore.
+		if _, isList := item.([]interface{}); isList {
+			continue
+		}
+
+		name, err := imap.ParseString(item)
+		if err != nil {
+			return nil, err
+		}
+
+		event := canonicalEvent(name)
+		if event == "" {
+			return nil, badEventError()
+		}
+		events[event] = true
+	}
+
+	return events, nil
+}
+
+func canonicalEvent(name string) string {
+	for _, event := range supportedEvents {
+		if strings.EqualFold(event, name) {
+			return event
+		}
+	}
+	return ""
+}
+
+func badEventError() error {
+	args := []interface{}{}
+	for _, event := range supportedEvents {
+		args = append(args, imap.RawString(event))
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type:      imap.StatusRespNo,
+		Code:      codeBadEvent,
+		Arguments: []interface{}{args},
+		Info:      "Unsupported event",
+	})
+}
+
+// Handle sets or removes notifications for the connection.
+func (h *Handler) Handle(conn server.Conn) error {
+	if conn.Context().User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	if h.settings == nil {
+		h.ext.remove(conn)
+		return nil
+	}
+
+	state := h.ext.set(conn, h.settings)
+
+	if h.settings.status {
+		h.ext.sendInitialStatus(conn, state)
+	}
+
+	return nil
+}
+
+type panicHandler interface {
+	HandlePanic()
+}
+
+type extension struct {
+	panicHandler panicHandler
+	delimiter    string
+
+	conns     map[server.Conn]*connState
+	connsLock *sync.RWMutex
+}
+
+// connState holds settings of the connection and names of mailboxes the user
+// is subscribed to, so SUBSCRIBED filter does not list mailboxes on every
+// update. The subscribed names are loaded on first use and dropped after
+// SUBSCRIBE, UNSUBSCRIBE or a mailbox change.
+type connState struct {
+	settings *settings
+
+	subscribed     map[string]bool
+	subscribedLock sync.Mutex
+}
+
+// Extension is NOTIFY server extension which needs to receive all backend
+// updates, including those for not selected mailboxes.
+type Extension interface {
+	server.Extension
+
+	// Notify sends notifications about the update to all connections
+	// which asked for it.
+	Notify(update backend.Update)
+}
+
+// NewExtension of NOTIFY. Delimiter is used to match SUBTREE filter.
+// Panics in goroutines sending notifications are handled by panicHandler.
+func NewExtension(panicHandler panicHandler, delimiter string) Extension {
+	return &extension{
+		panicHandler: panicHandler,
+		delimiter:    delimiter,
+		conns:        map[server.Conn]*connState{},
+		connsLock:    &sync.RWMutex{},
+	}
+}
+
+func (ext *extension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{Capability}
+	}
+	return nil
+}
+
+func (ext *extension) Command(name string) server.HandlerFactory {
+	switch name {
+	case commandName:
+		return func() server.Handler {
+			return &Handler{ext: ext}
+		}
+	case "SUBSCRIBE":
+		return func() server.Handler {
+			return &subscribeHandler{Handler: &server.Subscribe{}, ext: ext}
+		}
+	case "UNSUBSCRIBE":
+		return func() server.Handler {
+			return &subscribeHandler{Handler: &server.Unsubscribe{}, ext: ext}
+		}
+	}
+	return nil
+}
+
+// subscribeHandler drops cached subscriptions of all connections of the user
+// after the subscription is changed.
+type subscribeHandler struct {
+	server.Handler
+
+	ext *extension
+}
+
+func (h *subscribeHandler) Handle(conn server.Conn) error {
+	if err := h.Handler.Handle(conn); err != nil {
+		return err
+	}
+
+	h.ext.connsLock.RLock()
+	defer h.ext.connsLock.RUnlock()
+
+	h.ext.resetSubscribed(conn.Context().User.Username())
+	return nil
+}
+
+func (ext *extension) set(conn server.Conn, connSettings *settings) *connState {
+	state := &connState{settings: connSettings}
+
+	ext.connsLock.Lock()
+	_, existed := ext.conns[conn]
+	ext.conns[conn] = state
+	ext.connsLock.Unlock()
+
+	if !existed {
+		go func() {
+			defer ext.panicHandler.HandlePanic()
+
+			<-conn.Context().LoggedOut
+			ext.remove(conn)
+		}()
+	}
+
+	return state
+}
+
+func (ext *extension) remove(conn server.Conn) {
+	ext.connsLock.Lock()
+	defer ext.connsLock.Unlock()
+
+	delete(ext.conns, conn)
+}
+
+func (ext *extension) Notify(update backend.Update) {
+	event := ""
+	switch update.(type) {
+	case *backend.MailboxUpdate:
+		event = EventMessageNew
+	case *backend.ExpungeUpdate:
+		event = EventMessageExpunge
+	case *backend.MessageUpdate:
+		event = EventFlagChange
+	case *backend.MailboxInfoUpdate:
+		event = EventMailboxName
+	default:
+		return
+	}
+
+	for _, target := range ext.getTargets(update) {
+		connSettings := target.state.settings
+		isSubscribed := target.state.isSubscribed(target.user)
+
+		if info, ok := update.(*backend.MailboxInfoUpdate); ok {
+			if connSettings.matches(info.Name, event, ext.delimiter, isSubscribed) {
+				go ext.send(target.conn, newListResponse(info.MailboxInfo))
+			}
+			continue
+		}
+
+		mailbox := update.Mailbox()
+		if !connSettings.matches(mailbox, event, ext.delimiter, isSubscribed) &&
+			!(event == EventMessageNew && connSettings.matches(mailbox, EventMessageExpunge, ext.delimiter, isSubscribed)) {
+			continue
+		}
+
+		go ext.sendStatus(target.conn, target.user, mailbox)
+	}
+}
+
+// notifyTarget is a connection which may be notified about the update with
+// the user logged in when the update was received.
+type notifyTarget struct {
+	conn  server.Conn
+	user  backend.User
+	state *connState
+}
+
+// getTargets returns connections of the user of the update. Matching them
+// needs subscriptions which are listed from the backend, so it is done by
+// the caller without holding connsLock.
+func (ext *extension) getTargets(update backend.Update) (targets []notifyTarget) {
+	ext.connsLock.RLock()
+	defer ext.connsLock.RUnlock()
+
+	_, isMailboxInfo := update.(*backend.MailboxInfoUpdate)
+	if isMailboxInfo {
+		ext.resetSubscribed(update.Username())
+	}
+
+	for conn, state := range ext.conns {
+		ctx := conn.Context()
+		user := ctx.User
+		if user == nil || (update.Username() != "" && user.Username() != update.Username()) {
+			continue
+		}
+
+		if !isMailboxInfo {
+			mailbox := update.Mailbox()
+			if mailbox == "" || (ctx.Mailbox != nil && ctx.Mailbox.Name() == mailbox) {
+				continue
+			}
+		}
+
+		targets = append(targets, notifyTarget{conn: conn, user: user, state: state})
+	}
+
+	return targets
+}
+
+// sendInitialStatus sends STATUS of all watched mailboxes (NOTIFY SET STATUS).
+func (ext *extension) sendInitialStatus(conn server.Conn, state *connState) {
+	ctx := conn.Context()
+	connSettings := state.settings
+
+	mailboxes, err := ctx.User.ListMailboxes(false)
+	if err != nil {
+		log.WithError(err).Warn("Cannot list mailboxes for initial status")
+		return
+	}
+
+	isSubscribed := state.isSubscribed(ctx.User)
+	for _, mailbox := range mailboxes {
+		name := mailbox.Name()
+		if ctx.Mailbox != nil && ctx.Mailbox.Name() == name {
+			continue
+		}
+		if !connSettings.matches(name, EventMessageNew, ext.delimiter, isSubscribed) &&
+			!connSettings.matches(name, EventMessageExpunge, ext.delimiter, isSubscribed) {
+			continue
+		}
+		if isNoSelect(mailbox) {
+			continue
+		}
+		if resp := statusResponse(mailbox); resp != nil {
+			if err := conn.WriteResp(resp); err != nil {
+				log.WithError(err).Warn("Cannot write initial status")
+			}
+		}
+	}
+}
+
+// resetSubscribed drops cached subscriptions of all connections of the user,
+// or of all connections when username is empty. The caller must hold
+// connsLock.
+func (ext *extension) resetSubscribed(username string) {
+	for conn, state := range ext.conns {
+		if user := conn.Context().User; username == "" || (user != nil && user.Username() == username) {
+			state.subscribedLock.Lock()
+			state.subscribed = nil
+			state.subscribedLock.Unlock()
+		}
+	}
+}
+
+func (state *connState) isSubscribed(user backend.User) func(string) bool {
+	return func(name string) bool {
+		state.subscribedLock.Lock()
+		defer state.subscribedLock.Unlock()
+
+		if state.subscribed == nil {
+			mailboxes, err := user.ListMailboxes(true)
+			if err != nil {
+				log.WithError(err).Warn("Cannot list subscribed mailboxes")
+				return false
+			}
+			state.subscribed = map[string]bool{}
+			for _, mailbox := range mailboxes {
+				state.subscribed[mailbox.Name()] = true
+			}
+		}
+
+		return state.subscribed[name]
+	}
+}
+
+func (ext *extension) sendStatus(conn server.Conn, user backend.User, name string) {
+	defer ext.panicHandler.HandlePanic()
+
+	mailbox, err := user.GetMailbox(name)
+	if err != nil {
+		log.WithError(err).WithField("mailbox", name).Warn("Cannot get mailbox for notification")
+		return
+	}
+
+	if resp := statusResponse(mailbox); resp != nil {
+		ext.send(conn, resp)
+	}
+}
+
+func (ext *extension) send(conn server.Conn, resp imap.WriterTo) {
+	defer ext.panicHandler.HandlePanic()
+
+	ctx := conn.Context()
+	select {
+	case ctx.Responses <- resp:
+	case <-ctx.LoggedOut:
+	case <-time.After(sendTimeout):
+		log.Warn("Notification could not be sent (timeout)")
+	}
+}
+
+func isNoSelect(mailbox backend.Mailbox) bool {
+	info, err := mailbox.Info()
+	if err != nil {
+		return true
+	}
+	for _, attr := range info.Attributes {
+		if attr == imap.NoSelectAttr {
+			return true
+		}
+	}
+	return false
+}
+
+func statusResponse(mailbox backend.Mailbox) imap.WriterTo {
+	status, err := mailbox.Status(statusItems)
+	if err != nil {
+		log.WithError(err).WithField("mailbox", mailbox.Name()).Warn("Cannot get status for notification")
+		return nil
+	}
+	return &responses.Status{Mailbox: status}
+}
+
+func newListResponse(info *imap.MailboxInfo) imap.WriterTo {
+	ch := make(chan *imap.MailboxInfo, 1)
+	ch <- info
+	close(ch)
+	return &responses.List{Mailboxes: ch}
+}
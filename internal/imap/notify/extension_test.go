@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func alwaysSubscribed(string) bool { return true }
+
+func TestParseNone(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{"NONE"}))
+	assert.Nil(t, h.settings)
+
+	require.Error(t, h.Parse([]interface{}{"NONE", "STATUS"}))
+	require.Error(t, h.Parse([]interface{}{}))
+	require.Error(t, h.Parse([]interface{}{"FOO"}))
+}
+
+func TestParseSet(t *testing.T) {
+	h := &Handler{}
+	require.NoError(t, h.Parse([]interface{}{
+		"SET", "STATUS",
+		[]interface{}{"SELECTED", []interface{}{"MessageNew", []interface{}{"UID"}, "MessageExpunge"}},
+		[]interface{}{"subtree", "Folders", []interface{}{"messagenew"}},
+		[]interface{}{"MAILBOXES", []interface{}{"INBOX", "Sent"}, []interface{}{"FlagChange"}},
+		[]interface{}{"PERSONAL", "NONE"},
+	}))
+
+	require.NotNil(t, h.settings)
+	assert.True(t, h.settings.status)
+	require.Len(t, h.settings.groups, 4)
+
+	assert.Equal(t, filterSelected, h.settings.groups[0].filter)
+	assert.Equal(t, map[string]bool{EventMessageNew: true, EventMessageExpunge: true}, h.settings.groups[0].events)
+	assert.Equal(t, filterSubtree, h.settings.groups[1].filter)
+	assert.Equal(t, []string{"Folders"}, h.settings.groups[1].mailboxes)
+	assert.Equal(t, []string{"INBOX", "Sent"}, h.settings.groups[2].mailboxes)
+	assert.Empty(t, h.settings.groups[3].events)
+}
+
+func TestParseSetErrors(t *testing.T) {
+	tests := [][]interface{}{
+		{"SET"},
+		{"SET", "STATUS"},
+		{"SET", "PERSONAL"},
+		{"SET", []interface{}{"UNKNOWN", []interface{}{"MessageNew"}}},
+		{"SET", []interface{}{"SUBTREE", []interface{}{"MessageNew"}}},
+		{"SET", []interface{}{"PERSONAL", []interface{}{"AnnotationChange"}}},
+	}
+	for _, fields := range tests {
+		assert.Error(t, (&Handler{}).Parse(fields), "%v", fields)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	s := &settings{groups: []eventGroup{
+		{filter: filterInboxes, events: map[string]bool{EventMessageNew: true}},
+		{filter: filterSubtree, mailboxes: []string{"Folders"}, events: map[string]bool{EventFlagChange: true}},
+		{filter: filterMailboxes, mailboxes: []string{"Labels/Work"}, events: map[string]bool{EventMessageExpunge: true}},
+	}}
+
+	tests := []struct {
+		mailbox, event string
+		want           bool
+	}{
+		{"INBOX", EventMessageNew, true},
+		{"INBOX", EventFlagChange, false},
+		{"Sent", EventMessageNew, false},
+		{"Folders", EventFlagChange, true},
+		{"Folders/Family", EventFlagChange, true},
+		{"FoldersAndMore", EventFlagChange, false},
+		{"Labels/Work", EventMessageExpunge, true},
+		{"Labels/Work/Sub", EventMessageExpunge, false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, s.matches(tc.mailbox, tc.event, "/", alwaysSubscribed), "%s %s", tc.mailbox, tc.event)
+	}
+}
+
+type testMailbox struct {
+	backend.Mailbox
+	name string
+}
+
+func (m *testMailbox) Name() string { return m.name }
+
+type testUser struct {
+	backend.User
+	subscribed []string
+	listCalls  int
+}
+
+func (u *testUser) Username() string { return "user" }
+
+func (u *testUser) ListMailboxes(subscribed bool) (mailboxes []backend.Mailbox, err error) {
+	u.listCalls++
+	for _, name := range u.subscribed {
+		mailboxes = append(mailboxes, &testMailbox{name: name})
+	}
+	return
+}
+
+type testConn struct {
+	server.Conn
+	ctx *server.Context
+}
+
+func (c *testConn) Context() *server.Context { return c.ctx }
+
+type testPanicHandler struct {
+	panics chan interface{}
+}
+
+func (h *testPanicHandler) HandlePanic() {
+	if r := recover(); r != nil {
+		h.panics <- r
+	}
+}
+
+type panickingUser struct {
+	testUser
+}
+
+func (u *panickingUser) GetMailbox(name string) (backend.Mailbox, error) {
+	panic("cannot get " + name)
+}
+
+type okHandler struct {
+	server.Handler
+}
+
+func (okHandler) Handle(server.Conn) error { return nil }
+
+func TestSubscribedCache(t *testing.T) {
+	ext := NewExtension(&testPanicHandler{}, "/").(*extension)
+	user := &testUser{subscribed: []string{"INBOX"}}
+	loggedOut := make(chan struct{})
+	defer close(loggedOut)
+	conn := &testConn{ctx: &server.Context{User: user, LoggedOut: loggedOut}}
+
+	isSubscribed := ext.set(conn, &settings{}).isSubscribed(user)
+	assert.True(t, isSubscribed("INBOX"))
+	assert.False(t, isSubscribed("Sent"))
+	assert.Equal(t, 1, user.listCalls)
+
+	// Subscription is cached until SUBSCRIBE or UNSUBSCRIBE.
+	user.subscribed = append(user.subscribed, "Sent")
+	assert.False(t, isSubscribed("Sent"))
+
+	require.NoError(t, (&subscribeHandler{Handler: okHandler{}, ext: ext}).Handle(conn))
+	assert.True(t, isSubscribed("Sent"))
+	assert.Equal(t, 2, user.listCalls)
+
+	// Renamed or deleted mailboxes are not cached either.
+	user.subscribed = []string{"INBOX"}
+	ext.Notify(&backend.MailboxInfoUpdate{
+		Update:      backend.NewUpdate(user.Username(), ""),
+		MailboxInfo: &imap.MailboxInfo{Name: "Sent"},
+	})
+	assert.False(t, isSubscribed("Sent"))
+	assert.Equal(t, 3, user.listCalls)
+}
+
+func TestNotifyHandlesPanic(t *testing.T) {
+	panicHandler := &testPanicHandler{panics: make(chan interface{}, 1)}
+	ext := NewExtension(panicHandler, "/").(*extension)
+	loggedOut := make(chan struct{})
+	defer close(loggedOut)
+
+	personal := eventGroup{filter: filterPersonal, events: map[string]bool{EventMessageNew: true}}
+	conn := &testConn{ctx: &server.Context{User: &panickingUser{}, LoggedOut: loggedOut}}
+	ext.set(conn, &settings{groups: []eventGroup{personal}})
+
+	// Connection which is not logged in anymore is skipped.
+	ext.set(&testConn{ctx: &server.Context{LoggedOut: loggedOut}}, &settings{groups: []eventGroup{personal}})
+
+	ext.Notify(&backend.MailboxUpdate{
+		Update:        backend.NewUpdate("user", "Archive"),
+		MailboxStatus: &imap.MailboxStatus{Name: "Archive"},
+	})
+
+	select {
+	case r := <-panicHandler.panics:
+		assert.Equal(t, "cannot get Archive", r)
+	case <-time.After(time.Second):
+		t.Fatal("panic was not handled")
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"sync"
+
+	"github.com/ProtonMail/proton-bridge/internal/imap/cache"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/store"
+)
+
+// prefetchMaxQueue limits how many messages of how many counts (see
+// preferences.PrefetchMessagesKey) can wait for prefetching. When client
+// jumps around, older requests are dropped instead of piling up.
+const prefetchMaxQueue = 4
+
+// prefetcher builds bodies of messages the client will most likely read next
+// in the background, one at a time, so they are served from the cache. All
+// requests go through the same API client, so prefetching is bounded by its
+// rate limit.
+type prefetcher struct {
+	panicHandler panicHandler
+
+	lock    sync.Mutex
+	queue   []prefetchItem
+	queued  map[string]bool
+	running bool
+
+	// lastSeqNums holds the last fetched sequence number per mailbox to
+	// detect whether client reads forward or backward.
+	lastSeqNums map[string]uint32
+}
+
+type prefetchItem struct {
+	apiID string
+	fetch func(apiID string)
+}
+
+func newPrefetcher(panicHandler panicHandler) *prefetcher {
+	return &prefetcher{
+		panicHandler: panicHandler,
+		queued:       map[string]bool{},
+		lastSeqNums:  map[string]uint32{},
+	}
+}
+
+// nextRange returns the range of sequence numbers of `count` messages after
+// `seqNum` in the direction the client reads the mailbox. Zero start means
+// there is nothing to prefetch.
+func (p *prefetcher) nextRange(mailboxID string, seqNum uint32, count int) (start, stop uint32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	lastSeqNum, ok := p.lastSeqNums[mailboxID]
+	p.lastSeqNums[mailboxID] = seqNum
+
+	if ok && seqNum < lastSeqNum {
+		if seqNum <= 1 {
+			return 0, 0
+		}
+		if seqNum > uint32(count) {
+			start = seqNum - uint32(count)
+		} else {
+			start = 1
+		}
+		return start, seqNum - 1
+	}
+
+	return seqNum + 1, seqNum + uint32(count)
+}
+
+// add queues the message to be fetched by `fetch` unless it is already
+// queued. Oldest requests are dropped when the queue is full.
+func (p *prefetcher) add(maxQueue int, apiID string, fetch func(apiID string)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.queued[apiID] {
+		return
+	}
+	for len(p.queue) > 0 && len(p.queue) >= maxQueue {
+		delete(p.queued, p.queue[0].apiID)
+		p.queue = p.queue[1:]
+	}
+	p.queue = append(p.queue, prefetchItem{apiID: apiID, fetch: fetch})
+	p.queued[apiID] = true
+
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+}
+
+// run fetches queued messages until the queue is empty.
+func (p *prefetcher) run() {
+	defer p.panicHandler.HandlePanic()
+
+	for {
+		p.lock.Lock()
+		if len(p.queue) == 0 {
+			p.running = false
+			p.lock.Unlock()
+			return
+		}
+		item := p.queue[0]
+		p.queue = p.queue[1:]
+		p.lock.Unlock()
+
+		item.fetch(item.apiID)
+
+		p.lock.Lock()
+		delete(p.queued, item.apiID)
+		p.lock.Unlock()
+	}
+}
+
+// prefetchAfter schedules prefetching of messages following the message
+// with `seqNum` in the direction the client reads the mailbox. Bodies are
+// never downloaded ahead in metadata-only sync modes.
+func (im *imapMailbox) prefetchAfter(seqNum uint32) {
+	if im.storeUser.GetSyncMode() != store.SyncModeFull {
+		return
+	}
+
+	if !preferences.GetNetworkProfile(im.user.backend.preferences).Prefetch {
+		return
+	}
+
+	count := im.user.backend.preferences.GetInt(preferences.PrefetchMessagesKey)
+	if count <= 0 {
+		return
+	}
+
+	start, stop := im.user.prefetcher.nextRange(im.storeMailbox.LabelID(), seqNum, count)
+	if start == 0 {
+		return
+	}
+
+	apiIDs, err := im.storeMailbox.GetAPIIDsFromSequenceRange(start, stop)
+	if err != nil {
+		im.log.WithError(err).Warn("Cannot get messages to prefetch")
+		return
+	}
+
+	// Reading backward, the closest message is the last one.
+	if start < seqNum {
+		for i, j := 0, len(apiIDs)-1; i < j; i, j = i+1, j-1 {
+			apiIDs[i], apiIDs[j] = apiIDs[j], apiIDs[i]
+		}
+	}
+
+	for _, apiID := range apiIDs {
+		im.user.prefetcher.add(prefetchMaxQueue*count, apiID, im.prefetchMessage)
+	}
+}
+
+// prefetchMessage builds the message so it is in the cache when the client
+// asks for it. Drafts are skipped as they are never cached.
+func (im *imapMailbox) prefetchMessage(apiID string) {
+	storeMessage, err := im.storeMailbox.GetMessage(apiID)
+	if err != nil {
+		return
+	}
+	if isMessageInDraftFolder(storeMessage.Message()) {
+		return
+	}
+	body, structure := cache.LoadMail(im.storeUser.UserID() + apiID)
+	releaseBody(body)
+	if body != nil && body.Size() != 0 && structure != nil {
+		return
+	}
+
+	im.log.WithField("msgID", apiID).Trace("Prefetching message")
+	_, body, err = im.getBodyStructure(storeMessage)
+	if err != nil {
+		im.log.WithError(err).WithField("msgID", apiID).Debug("Cannot prefetch message")
+	}
+	releaseBody(body)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type noopPanicHandler struct{}
+
+func (noopPanicHandler) HandlePanic() {}
+
+func TestPrefetcherNextRange(t *testing.T) {
+	p := newPrefetcher(noopPanicHandler{})
+
+	start, stop := p.nextRange("inbox", 10, 3)
+	require.Equal(t, []uint32{11, 13}, []uint32{start, stop})
+
+	// Reading backward prefetches previous messages.
+	start, stop = p.nextRange("inbox", 9, 3)
+	require.Equal(t, []uint32{6, 8}, []uint32{start, stop})
+	start, stop = p.nextRange("inbox", 2, 3)
+	require.Equal(t, []uint32{1, 1}, []uint32{start, stop})
+	start, _ = p.nextRange("inbox", 1, 3)
+	require.Equal(t, uint32(0), start)
+
+	// Direction is tracked per mailbox.
+	start, stop = p.nextRange("archive", 5, 3)
+	require.Equal(t, []uint32{6, 8}, []uint32{start, stop})
+}
+
+func TestPrefetcherFetchesQueuedOnce(t *testing.T) {
+	p := newPrefetcher(noopPanicHandler{})
+
+	var lock sync.Mutex
+	fetched := []string{}
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+
+	blocker := make(chan struct{})
+	fetch := func(apiID string) {
+		<-blocker
+		lock.Lock()
+		fetched = append(fetched, apiID)
+		lock.Unlock()
+		wg.Done()
+	}
+
+	// Messages wait until the first one is fetched; msg2 is queued only once.
+	p.add(10, "msg1", fetch)
+	p.add(10, "msg2", fetch)
+	p.add(10, "msg2", fetch)
+	p.add(10, "msg3", fetch)
+
+	close(blocker)
+	wg.Wait()
+
+	require.Equal(t, []string{"msg1", "msg2", "msg3"}, fetched)
+}
+
+func TestPrefetcherDropsOldest(t *testing.T) {
+	p := newPrefetcher(noopPanicHandler{})
+	p.running = true // Keep items in the queue.
+
+	fetch := func(string) {}
+	for _, apiID := range []string{"msg1", "msg2", "msg3", "msg4"} {
+		p.add(2, apiID, fetch)
+	}
+
+	require.Len(t, p.queue, 2)
+	require.Equal(t, "msg3", p.queue[0].apiID)
+	require.Equal(t, "msg4", p.queue[1].apiID)
+	require.False(t, p.queued["msg1"])
+}
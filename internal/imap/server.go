@@ -19,25 +19,33 @@ package imap
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	imapid "github.com/ProtonMail/go-imap-id"
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/internal/imap/id"
+	"github.com/ProtonMail/proton-bridge/internal/imap/idle"
+	"github.com/ProtonMail/proton-bridge/internal/imap/listextended"
+	"github.com/ProtonMail/proton-bridge/internal/imap/namespace"
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
+	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/saslbearer"
 	"github.com/emersion/go-imap"
 	imapappendlimit "github.com/emersion/go-imap-appendlimit"
-	imapidle "github.com/emersion/go-imap-idle"
 	imapmove "github.com/emersion/go-imap-move"
 	imapquota "github.com/emersion/go-imap-quota"
 	imapspecialuse "github.com/emersion/go-imap-specialuse"
 	imapunselect "github.com/emersion/go-imap-unselect"
+	goIMAPBackend "github.com/emersion/go-imap/backend"
 	imapserver "github.com/emersion/go-imap/server"
 	"github.com/emersion/go-sasl"
 	"github.com/sirupsen/logrus"
@@ -45,19 +53,47 @@ import (
 
 type imapServer struct {
 	server        *imapserver.Server
+	listenerCfg   bridge.ListenerConfig
+	limits        *sharedLimits
+	timeouts      Timeouts
+	tracer        *tracer
+	clients       *clientRegistry
+	commands      *commandTrackers
 	eventListener listener.Listener
 	debugClient   bool
 	debugServer   bool
+	listening     int32
+
+	lock     sync.Mutex
+	listener *bridge.DrainListener
 }
 
 // NewIMAPServer constructs a new IMAP server configured with the given options.
-func NewIMAPServer(debugClient, debugServer bool, port int, tls *tls.Config, imapBackend *imapBackend, eventListener listener.Listener) *imapServer { //nolint[golint]
-	s := imapserver.New(imapBackend)
-	s.Addr = fmt.Sprintf("%v:%v", bridge.Host, port)
+// Authentication without TLS is allowed only when the server is not reachable
+// from other machines. Listener dedicated to an account refuses other accounts.
+func NewIMAPServer(debugClient, debugServer, trace bool, listenerCfg bridge.ListenerConfig, limits ConnectionLimits, timeouts Timeouts, useNamespace bool, tls *tls.Config, imapBackend *imapBackend, eventListener listener.Listener) *imapServer { //nolint[golint]
+	tracer := newTracer(trace)
+	clients := newClientRegistry()
+
+	backend := &serverBackend{
+		imapBackend: imapBackend,
+		account:     listenerCfg.Account,
+		tracer:      tracer,
+		clients:     clients,
+	}
+	s := imapserver.New(backend)
+	s.Addr = listenerCfg.Address()
 	s.TLSConfig = tls
-	s.AllowInsecureAuth = true
+	s.AllowInsecureAuth = !listenerCfg.IsRemote()
+	s.MaxLiteralSize = maxLiteralSize
 	s.ErrorLog = newServerErrorLogger("server-imap")
-	s.AutoLogout = 30 * time.Minute
+	if timeouts.AutoLogout <= 0 {
+		timeouts.AutoLogout = defaultAutoLogout
+	}
+	if timeouts.AutoLogout < minAutoLogout {
+		timeouts.AutoLogout = minAutoLogout
+	}
+	s.AutoLogout = timeouts.AutoLogout
 
 	serverID := imapid.ID{
 		imapid.FieldName:       "ProtonMail Bridge",
@@ -65,33 +101,85 @@ func NewIMAPServer(debugClient, debugServer bool, port int, tls *tls.Config, ima
 		imapid.FieldSupportURL: "https://protonmail.com/support",
 	}
 
+	login := func(conn imapserver.Conn, address, password string) error {
+		user, err := conn.Server().Backend.Login(conn.Info(), address, password)
+		if err != nil {
+			return err
+		}
+
+		ctx := conn.Context()
+		ctx.State = imap.AuthenticatedState
+		ctx.User = user
+		return nil
+	}
+
+	loginWithToken := func(conn imapserver.Conn, address, token string) error {
+		user, err := backend.LoginWithToken(conn.Info(), address, token)
+		if err != nil {
+			return err
+		}
+
+		ctx := conn.Context()
+		ctx.State = imap.AuthenticatedState
+		ctx.User = user
+		return nil
+	}
+
 	s.EnableAuth(sasl.Login, func(conn imapserver.Conn) sasl.Server {
 		return sasl.NewLoginServer(func(address, password string) error {
-			user, err := conn.Server().Backend.Login(nil, address, password)
-			if err != nil {
-				return err
-			}
+			return login(conn, address, password)
+		})
+	})
 
-			ctx := conn.Context()
-			ctx.State = imap.AuthenticatedState
-			ctx.User = user
-			return nil
+	// OAuth mechanisms accept only bearer tokens issued by bridge, not the
+	// bridge password.
+	s.EnableAuth(saslbearer.OAuthBearer, func(conn imapserver.Conn) sasl.Server {
+		return saslbearer.NewOAuthBearerServer(func(address, token string) error {
+			return loginWithToken(conn, address, token)
+		})
+	})
+	s.EnableAuth(saslbearer.XOAuth2, func(conn imapserver.Conn) sasl.Server {
+		return saslbearer.NewXOAuth2Server(func(address, token string) error {
+			return loginWithToken(conn, address, token)
 		})
 	})
 
 	s.Enable(
-		imapidle.NewExtension(),
+		idle.NewExtension(timeouts.IdleKeepalive),
 		imapmove.NewExtension(),
 		imapspecialuse.NewExtension(),
-		id.NewExtension(serverID, imapBackend.bridge),
+		id.NewExtension(serverID, imapBackend.bridge, clients),
 		imapquota.NewExtension(),
 		imapappendlimit.NewExtension(),
 		imapunselect.NewExtension(),
 		uidplus.NewExtension(),
+		imapBackend.notify,
+		&statusExtension{},
+		listextended.NewExtension(),
 	)
 
+	// Folders, labels and virtual mailboxes are announced as separate
+	// namespaces so clients can tell exclusive folders from labels.
+	if useNamespace {
+		s.Enable(namespace.NewExtension(
+			namespace.Namespace{Prefix: "", Delimiter: store.PathDelimiter},
+			namespace.Namespace{Prefix: store.UserFoldersPrefix, Delimiter: store.PathDelimiter},
+			namespace.Namespace{Prefix: store.UserLabelsPrefix, Delimiter: store.PathDelimiter},
+			namespace.Namespace{Prefix: store.VirtualMailboxesPrefix, Delimiter: store.PathDelimiter},
+		))
+	}
+
+	sharedLimits := newSharedLimits(limits)
+	s.Enable(&accountLimitExtension{forEachConn: s.ForEachConn, limits: sharedLimits})
+
 	return &imapServer{
 		server:        s,
+		listenerCfg:   listenerCfg,
+		limits:        sharedLimits,
+		timeouts:      timeouts,
+		tracer:        tracer,
+		clients:       clients,
+		commands:      newCommandTrackers(),
 		eventListener: eventListener,
 		debugClient:   debugClient,
 		debugServer:   debugServer,
@@ -103,18 +191,25 @@ func (s *imapServer) ListenAndServe() {
 	go s.monitorDisconnectedUsers()
 
 	log.Info("IMAP server listening at ", s.server.Addr)
-	l, err := net.Listen("tcp", s.server.Addr)
+	l, err := s.listenerCfg.Listen()
 	if err != nil {
 		s.eventListener.Emit(events.ErrorEvent, "IMAP failed: "+err.Error())
 		log.Error("IMAP failed: ", err)
 		return
 	}
 
+	dl := bridge.NewDrainListener(l)
+	s.lock.Lock()
+	s.listener = dl
+	s.lock.Unlock()
+
+	atomic.StoreInt32(&s.listening, 1)
 	err = s.server.Serve(&debugListener{
-		Listener: l,
+		Listener: newClientLimitListener(dl, s.limits),
 		server:   s,
 	})
-	if err != nil {
+	atomic.StoreInt32(&s.listening, 0)
+	if err != nil && !dl.IsDraining() {
 		s.eventListener.Emit(events.ErrorEvent, "IMAP failed: "+err.Error())
 		log.Error("IMAP failed: ", err)
 		return
@@ -124,6 +219,49 @@ func (s *imapServer) ListenAndServe() {
 	log.Info("IMAP server stopped")
 }
 
+// IsListening returns whether the server accepts connections.
+func (s *imapServer) IsListening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
+}
+
+// SetLimits changes the connection limits of the running server. Already
+// open connections over the new limits are kept.
+func (s *imapServer) SetLimits(limits ConnectionLimits) {
+	s.limits.set(limits)
+}
+
+// Connection describes a client connected to the server.
+type Connection struct {
+	UserID     string // Empty before the client logs in.
+	Address    string
+	RemoteAddr string
+	Client     string // Name reported by ID command.
+	Mailbox    string // Selected mailbox.
+}
+
+// Connections returns clients currently connected to the server.
+func (s *imapServer) Connections() (conns []Connection) {
+	s.server.ForEachConn(func(conn imapserver.Conn) {
+		info := conn.Info()
+		c := Connection{Client: s.clients.name(info)}
+		if info.RemoteAddr != nil {
+			c.RemoteAddr = info.RemoteAddr.String()
+		}
+
+		ctx := conn.Context()
+		if user, ok := ctx.User.(*imapUser); ok {
+			c.UserID = user.user.ID()
+			c.Address = user.currentAddressLowercase
+		}
+		if ctx.Mailbox != nil {
+			c.Mailbox = ctx.Mailbox.Name()
+		}
+
+		conns = append(conns, c)
+	})
+	return conns
+}
+
 // Stops the server.
 func (s *imapServer) Close() {
 	_ = s.server.Close()
@@ -146,8 +284,74 @@ func (s *imapServer) monitorDisconnectedUsers() {
 	}
 }
 
-// debugListener sets debug loggers on server containing fields with local
-// and remote addresses right after new connection is accepted.
+// failedLoginDelay slows down clients repeating failed logins. Apple Mail
+// sometimes generates a lot of requests very quickly.
+const failedLoginDelay = 10 * time.Second
+
+// maxLiteralSize bounds the memory taken by a literal, which go-imap reads
+// whole before the command is handled (e.g. the message of APPEND). It leaves
+// room for MIME encoding of the biggest upload allowed by API.
+const maxLiteralSize = 64 * 1024 * 1024
+
+// serverBackend handles login options specific to the server: it lets only
+// the dedicated account log in and turns on the trace requested by login
+// suffix. It embeds the backend so extensions still find all its methods.
+type serverBackend struct {
+	*imapBackend
+
+	account string
+	tracer  *tracer
+	clients *clientRegistry
+}
+
+func (sb *serverBackend) Login(connInfo *imap.ConnInfo, username, password string) (goIMAPBackend.User, error) {
+	return sb.login(connInfo, username, func(username string) (goIMAPBackend.User, error) {
+		return sb.imapBackend.Login(connInfo, username, password)
+	})
+}
+
+// LoginWithToken logs in by the bearer token. The user is found by the token
+// when OAUTHBEARER client did not send the username.
+func (sb *serverBackend) LoginWithToken(connInfo *imap.ConnInfo, username, token string) (goIMAPBackend.User, error) {
+	if username == "" {
+		address, err := sb.getAddressByToken(token)
+		if err != nil {
+			log.WithError(err).Warn("Cannot get user by token")
+			time.Sleep(failedLoginDelay)
+			return nil, err
+		}
+		username = address
+	}
+
+	return sb.login(connInfo, username, func(username string) (goIMAPBackend.User, error) {
+		return sb.imapBackend.LoginWithToken(username, token)
+	})
+}
+
+// login logs in by the given login function. Every failed login is delayed,
+// including the one refused by the port of another account.
+func (sb *serverBackend) login(connInfo *imap.ConnInfo, username string, login func(username string) (goIMAPBackend.User, error)) (user goIMAPBackend.User, err error) {
+	defer func() {
+		if err != nil {
+			time.Sleep(failedLoginDelay)
+		}
+	}()
+
+	username, trace := stripLoginSuffix(username)
+
+	if sb.account != "" && !sb.isAccountAddress(sb.account, username) {
+		return nil, errors.New("this port is dedicated to another account")
+	}
+
+	if trace && connInfo != nil {
+		sb.tracer.enable(connInfo.RemoteAddr)
+	}
+
+	return login(username)
+}
+
+// debugListener sets debug and trace loggers on server containing fields with
+// local and remote addresses right after new connection is accepted.
 type debugListener struct {
 	net.Listener
 
@@ -156,8 +360,23 @@ type debugListener struct {
 
 func (dl *debugListener) Accept() (net.Conn, error) {
 	conn, err := dl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if autoLogout := dl.server.timeouts.AutoLogout; autoLogout < imapserver.MinAutoLogout {
+		conn = &autoLogoutConn{Conn: conn, timeout: autoLogout}
+	}
+
+	cc := dl.server.commands.wrap(dl.server.clients.wrap(conn))
+	tc := dl.server.tracer.wrap(cc)
+	var localDebug, remoteDebug io.Writer = io.MultiWriter(tc.server, cc.tracker.server), io.MultiWriter(tc.client, cc.tracker.client)
 
-	if err == nil && (dl.server.debugServer || dl.server.debugClient) {
+	if literal := dl.server.timeouts.Literal; literal > 0 {
+		localDebug = io.MultiWriter(localDebug, &literalDeadlineWriter{conn: conn, timeout: literal})
+	}
+
+	if dl.server.debugServer || dl.server.debugClient {
 		debugLog := log
 		if addr := conn.LocalAddr(); addr != nil {
 			debugLog = debugLog.WithField("loc", addr.String())
@@ -166,18 +385,17 @@ func (dl *debugListener) Accept() (net.Conn, error) {
 			debugLog = debugLog.WithField("rem", addr.String())
 		}
 
-		var localDebug, remoteDebug io.Writer
 		if dl.server.debugServer {
-			localDebug = debugLog.WithField("pkg", "imap/server").WriterLevel(logrus.DebugLevel)
+			localDebug = io.MultiWriter(localDebug, debugLog.WithField("pkg", "imap/server").WriterLevel(logrus.DebugLevel))
 		}
 		if dl.server.debugClient {
-			remoteDebug = debugLog.WithField("pkg", "imap/client").WriterLevel(logrus.DebugLevel)
+			remoteDebug = io.MultiWriter(remoteDebug, debugLog.WithField("pkg", "imap/client").WriterLevel(logrus.DebugLevel))
 		}
-
-		dl.server.server.Debug = imap.NewDebugWriter(localDebug, remoteDebug)
 	}
 
-	return conn, err
+	dl.server.server.Debug = imap.NewDebugWriter(localDebug, remoteDebug)
+
+	return tc, nil
 }
 
 // serverErrorLogger implements go-imap/logger interface.
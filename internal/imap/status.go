@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// cachedStatusMailbox is a mailbox which can answer STATUS from cached counts.
+type cachedStatusMailbox interface {
+	StatusFromCache(items []imap.StatusItem) (*imap.MailboxStatus, error)
+}
+
+// statusExtension replaces STATUS command to use cached counts when possible.
+// SELECT and EXAMINE keep using Status which also provides first unseen
+// sequence number.
+type statusExtension struct{}
+
+func (ext *statusExtension) Capabilities(c imapserver.Conn) []string {
+	return nil
+}
+
+func (ext *statusExtension) Command(name string) imapserver.HandlerFactory {
+	if name != "STATUS" {
+		return nil
+	}
+	return func() imapserver.Handler {
+		return &statusHandler{}
+	}
+}
+
+type statusHandler struct {
+	imapserver.Status
+}
+
+func (cmd *statusHandler) Handle(conn imapserver.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return imapserver.ErrNotAuthenticated
+	}
+
+	mbox, err := ctx.User.GetMailbox(cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	var status *imap.MailboxStatus
+	if cached, ok := mbox.(cachedStatusMailbox); ok {
+		status, err = cached.StatusFromCache(cmd.Items)
+	} else {
+		status, err = mbox.Status(cmd.Items)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only keep items that have been requested.
+	items := make(map[imap.StatusItem]interface{})
+	for _, k := range cmd.Items {
+		items[k] = status.Items[k]
+	}
+	status.Items = items
+
+	return conn.WriteResp(&responses.Status{Mailbox: status})
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/ProtonMail/proton-bridge/internal/imap/uidplus"
 	"github.com/ProtonMail/proton-bridge/internal/store"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 )
 
@@ -43,6 +44,24 @@ type storeUserProvider interface {
 		parentID string) (*pmapi.Message, []*pmapi.Attachment, error)
 
 	PauseEventLoop(bool)
+	ClientConnected()
+	ClientDisconnected()
+
+	FindSentMessage(header mail.Header) (apiID string, ok bool)
+
+	IsSubscribed(labelID string) bool
+	SetSubscribed(labelID string, subscribed bool) error
+	ImportUnsubscribed(labelIDs []string) error
+
+	SearchIndexed(bodyTexts, texts []string) (map[string]bool, error)
+
+	LoadCachedBody(apiID string) ([]byte, bool)
+	SaveCachedBody(apiID string, body []byte)
+	LoadCachedAttachment(attID string) ([]byte, bool)
+	SaveCachedAttachment(attID string, data []byte)
+
+	GetSyncMode() string
+	KeepsBodies() bool
 }
 
 type storeAddressProvider interface {
@@ -61,6 +80,7 @@ type storeMailboxProvider interface {
 	Color() string
 	IsSystem() bool
 	IsFolder() bool
+	IsVirtual() bool
 	UIDValidity() uint32
 
 	Rename(newName string) error
@@ -72,6 +92,7 @@ type storeMailboxProvider interface {
 	GetNextUID() (uint32, error)
 	GetDeletedAPIIDs() ([]string, error)
 	GetCounts() (dbTotal, dbUnread, dbUnreadSeqNum uint, err error)
+	GetCachedCounts() (total, unread uint, ok bool)
 	GetUIDList(apiIDs []string) *uidplus.OrderedSeq
 	GetUIDByHeader(header *mail.Header) uint32
 	GetDelimiter() string
@@ -86,8 +107,8 @@ type storeMailboxProvider interface {
 	MarkMessagesUnstarred(apiID []string) error
 	MarkMessagesDeleted(apiID []string) error
 	MarkMessagesUndeleted(apiID []string) error
-	ImportMessage(msg *pmapi.Message, body []byte, labelIDs []string) error
-	RemoveDeleted() error
+	ImportMessage(msg *pmapi.Message, body io.Reader, labelIDs []string) error
+	RemoveDeleted(policy string) error
 }
 
 type storeMessageProvider interface {
@@ -99,6 +120,8 @@ type storeMessageProvider interface {
 
 	SetSize(int64) error
 	SetContentTypeAndHeader(string, mail.Header) error
+	SetBodyStructure(*message.BodyStructure) error
+	GetBodyStructure() (*message.BodyStructure, error)
 }
 
 type storeUserWrap struct {
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// Timeouts of IMAP connections. Zero means default.
+type Timeouts struct {
+	// AutoLogout closes connections without any command. Values under
+	// minAutoLogout are raised to it. Values under RFC3501 minimum of 30
+	// minutes are allowed for networks dropping idle connections sooner.
+	AutoLogout time.Duration
+
+	// IdleKeepalive is how often untagged OK is sent during IDLE.
+	IdleKeepalive time.Duration
+
+	// Literal limits time the client has to send a literal once the server
+	// asks for it.
+	Literal time.Duration
+}
+
+const (
+	defaultAutoLogout = 30 * time.Minute
+	minAutoLogout     = time.Minute
+)
+
+// literalContinuation is what go-imap sends when it is ready for a literal.
+var literalContinuation = []byte("+ send literal") //nolint[gochecknoglobals]
+
+// literalDeadlineWriter is passed as server debug writer to go-imap so it sees
+// the dialogue after STARTTLS as well. When the server asks for a literal it
+// shortens the read deadline of the connection; go-imap sets the deadline
+// back after the whole command is read.
+type literalDeadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *literalDeadlineWriter) Write(b []byte) (int, error) {
+	if bytes.HasPrefix(b, literalContinuation) {
+		_ = w.conn.SetReadDeadline(time.Now().Add(w.timeout))
+	}
+	return len(b), nil
+}
+
+// autoLogoutConn makes auto-logout shorter than go-imap allows. go-imap
+// raises AutoLogout to 30 minutes when it sets the deadline after each
+// command and response; the deadline is moved to the configured time instead.
+type autoLogoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *autoLogoutConn) SetDeadline(t time.Time) error {
+	if !t.IsZero() {
+		t = time.Now().Add(c.timeout)
+	}
+	return c.Conn.SetDeadline(t)
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiteralDeadlineWriter(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close() //nolint[errcheck]
+	defer client.Close() //nolint[errcheck]
+
+	w := &literalDeadlineWriter{conn: server, timeout: 10 * time.Millisecond}
+
+	_, err := w.Write([]byte("* OK still here\r\n"))
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte("+ send literal\r\n"))
+	require.NoError(t, err)
+
+	_, err = server.Read(make([]byte, 1))
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
+
+func TestAutoLogoutConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close() //nolint[errcheck]
+	defer client.Close() //nolint[errcheck]
+
+	conn := &autoLogoutConn{Conn: server, timeout: 10 * time.Millisecond}
+	require.NoError(t, conn.SetDeadline(time.Now().Add(30*time.Minute)))
+
+	_, err := conn.Read(make([]byte, 1))
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// traceLoginSuffix appended to the username (e.g. `user@pm.me#trace`) turns on
+// the trace of the connection. The suffix is removed before the login.
+const traceLoginSuffix = "#trace"
+
+var (
+	literalRegexp      = regexp.MustCompile(`\{(\d+)\+?\}\r?\n$`)                         //nolint[gochecknoglobals]
+	credentialsRegexp  = regexp.MustCompile(`(?i)^(\S+ (LOGIN|AUTHENTICATE))(?: |\r?\n)`) //nolint[gochecknoglobals]
+	saslResponseRegexp = regexp.MustCompile(`^[A-Za-z0-9+/=*]*\r?\n$`)                    //nolint[gochecknoglobals]
+)
+
+// tracer keeps traced connections to turn on the trace after the login with
+// the trace suffix. Connections are identified by remote address, so unix
+// socket connections can be traced only with the trace turned on for all.
+type tracer struct {
+	traceAll bool
+
+	lock  sync.Mutex
+	conns map[string]*traceConn
+}
+
+func newTracer(traceAll bool) *tracer {
+	return &tracer{
+		traceAll: traceAll,
+		conns:    map[string]*traceConn{},
+	}
+}
+
+// stripLoginSuffix returns username without the trace suffix and whether it
+// was present.
+func stripLoginSuffix(username string) (string, bool) {
+	if strings.HasSuffix(strings.ToLower(username), traceLoginSuffix) {
+		return username[:len(username)-len(traceLoginSuffix)], true
+	}
+	return username, false
+}
+
+// wrap registers the connection so its trace can be turned on later.
+func (t *tracer) wrap(conn net.Conn) *traceConn {
+	tc := &traceConn{Conn: conn, tracer: t}
+	if t.traceAll {
+		tc.enabled = 1
+	}
+
+	traceLog := log.WithField("pkg", "imap/trace").WithField("rem", conn.RemoteAddr().String())
+	tc.client = &traceLogger{log: traceLog.WithField("dir", "C"), conn: tc, isClient: true}
+	tc.server = &traceLogger{log: traceLog.WithField("dir", "S"), conn: tc}
+
+	if key := conn.RemoteAddr().String(); key != "" && key != "@" {
+		t.lock.Lock()
+		t.conns[key] = tc
+		t.lock.Unlock()
+	}
+
+	return tc
+}
+
+// enable turns on the trace for connection from remoteAddr.
+func (t *tracer) enable(remoteAddr net.Addr) {
+	if remoteAddr == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if tc, ok := t.conns[remoteAddr.String()]; ok {
+		tc.client.log.Warn("Protocol trace turned on by login suffix")
+		atomic.StoreInt32(&tc.enabled, 1)
+	}
+}
+
+func (t *tracer) remove(tc *traceConn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if key := tc.RemoteAddr().String(); t.conns[key] == tc {
+		delete(t.conns, key)
+	}
+}
+
+// traceConn holds trace state of one connection. The dialogue itself is
+// passed to client and server loggers by go-imap debug writer so it is
+// readable also after STARTTLS.
+type traceConn struct {
+	net.Conn
+
+	tracer  *tracer
+	enabled int32
+
+	client, server *traceLogger
+}
+
+func (tc *traceConn) isEnabled() bool {
+	return atomic.LoadInt32(&tc.enabled) == 1
+}
+
+func (tc *traceConn) Close() error {
+	tc.tracer.remove(tc)
+	return tc.Conn.Close()
+}
+
+// traceLogger logs one direction of the dialogue line by line with literals
+// and credentials redacted.
+//
+// Lines are parsed even when the trace is off to not lose track of literals
+// when it is turned on in the middle of the dialogue.
+type traceLogger struct {
+	log      *logrus.Entry
+	conn     *traceConn
+	isClient bool
+
+	line           []byte
+	literalLeft    int
+	inCredentials  bool // The line continues LOGIN or AUTHENTICATE after a literal.
+	inSASLExchange bool
+}
+
+func (tl *traceLogger) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if tl.literalLeft > 0 {
+			skip := tl.literalLeft
+			if skip > len(b) {
+				skip = len(b)
+			}
+			tl.literalLeft -= skip
+			b = b[skip:]
+			continue
+		}
+
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			tl.line = append(tl.line, b...)
+			break
+		}
+		tl.line = append(tl.line, b[:i+1]...)
+		b = b[i+1:]
+
+		tl.logLine(tl.line)
+		tl.line = tl.line[:0]
+	}
+	return n, nil
+}
+
+func (tl *traceLogger) logLine(line []byte) {
+	text := string(line)
+
+	match := literalRegexp.FindStringSubmatch(text)
+	if match != nil {
+		tl.literalLeft, _ = strconv.Atoi(match[1])
+	}
+
+	redacted := false
+	if tl.isClient {
+		text, redacted = tl.redactCredentials(text, match != nil)
+	}
+	if match != nil && !redacted {
+		text = strings.TrimRight(text, "\r\n") + " [literal of " + match[1] + " bytes redacted]"
+	}
+
+	if !tl.conn.isEnabled() {
+		return
+	}
+	tl.log.Info(strings.TrimRight(text, "\r\n"))
+}
+
+// redactCredentials hides everything after LOGIN or AUTHENTICATE command
+// including lines continuing the command after its literals, and all SASL
+// responses following AUTHENTICATE. It returns whether the text was redacted.
+func (tl *traceLogger) redactCredentials(text string, hasLiteral bool) (string, bool) {
+	if tl.inCredentials {
+		tl.inCredentials = hasLiteral
+		return "[redacted]", true
+	}
+
+	if match := credentialsRegexp.FindStringSubmatch(text); match != nil {
+		tl.inSASLExchange = strings.EqualFold(match[2], "AUTHENTICATE")
+		tl.inCredentials = hasLiteral
+		return match[1] + " [redacted]", true
+	}
+
+	if tl.inSASLExchange && saslResponseRegexp.MatchString(text) {
+		return "[redacted]", true
+	}
+
+	tl.inSASLExchange = false
+	return text, false
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package imap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTraceLogger(isClient bool) (*traceLogger, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	tc := &traceConn{enabled: 1}
+	return &traceLogger{log: logrus.NewEntry(logger), conn: tc, isClient: isClient}, hook
+}
+
+func getLoggedLines(hook *test.Hook) (lines []string) {
+	for _, entry := range hook.AllEntries() {
+		lines = append(lines, entry.Message)
+	}
+	return
+}
+
+func TestTraceRedactsLogin(t *testing.T) {
+	tl, hook := newTestTraceLogger(true)
+
+	_, _ = tl.Write([]byte("a1 LOGIN user@pm.me secret\r\na2 SEL"))
+	_, _ = tl.Write([]byte("ECT INBOX\r\n"))
+
+	require.Equal(t, []string{"a1 LOGIN [redacted]", "a2 SELECT INBOX"}, getLoggedLines(hook))
+}
+
+func TestTraceRedactsLoginLiterals(t *testing.T) {
+	tl, hook := newTestTraceLogger(true)
+
+	_, _ = tl.Write([]byte("a1 LOGIN {10}\r\nuser@pm.me {6}\r\nsecret\r\n"))
+	_, _ = tl.Write([]byte("a2 LOGIN {10}\r\nuser@pm.me \"secret\"\r\n"))
+	_, _ = tl.Write([]byte("a3 LOGIN user@pm.me {6+}\r\nsecret\r\na4 NOOP\r\n"))
+
+	require.Equal(t, []string{
+		"a1 LOGIN [redacted]", "[redacted]", "[redacted]",
+		"a2 LOGIN [redacted]", "[redacted]",
+		"a3 LOGIN [redacted]", "[redacted]",
+		"a4 NOOP",
+	}, getLoggedLines(hook))
+	for _, entry := range hook.AllEntries() {
+		require.NotContains(t, entry.Message, "secret")
+	}
+}
+
+func TestTraceRedactsAuthenticate(t *testing.T) {
+	tl, hook := newTestTraceLogger(true)
+
+	_, _ = tl.Write([]byte("a1 AUTHENTICATE PLAIN\r\nAHVzZXIAc2VjcmV0\r\na2 LIST \"\" *\r\n"))
+
+	require.Equal(t, []string{"a1 AUTHENTICATE [redacted]", "[redacted]", "a2 LIST \"\" *"}, getLoggedLines(hook))
+}
+
+func TestTraceRedactsLiterals(t *testing.T) {
+	tl, hook := newTestTraceLogger(false)
+
+	_, _ = tl.Write([]byte("* 1 FETCH (BODY[] {11}\r\nHello "))
+	_, _ = tl.Write([]byte("world)\r\na1 OK FETCH completed\r\n"))
+
+	require.Equal(t, []string{
+		"* 1 FETCH (BODY[] {11} [literal of 11 bytes redacted]",
+		")",
+		"a1 OK FETCH completed",
+	}, getLoggedLines(hook))
+}
+
+func TestTraceDisabled(t *testing.T) {
+	tl, hook := newTestTraceLogger(true)
+	tl.conn.enabled = 0
+
+	_, _ = tl.Write([]byte("a1 APPEND INBOX {5}\r\n"))
+	_, _ = tl.Write([]byte("Hello\r\n"))
+	require.Empty(t, hook.AllEntries())
+
+	tl.conn.enabled = 1
+	_, _ = tl.Write([]byte("a2 NOOP\r\n"))
+	require.Equal(t, []string{"a2 NOOP"}, getLoggedLines(hook))
+}
+
+func TestTracerEnable(t *testing.T) {
+	tracer := newTracer(false)
+	server, client := net.Pipe()
+	defer client.Close() //nolint[errcheck]
+
+	tc := tracer.wrap(&fakeAddrConn{Conn: server, remote: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}})
+	require.False(t, tc.isEnabled())
+
+	tracer.enable(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5001})
+	require.False(t, tc.isEnabled())
+
+	tracer.enable(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000})
+	require.True(t, tc.isEnabled())
+
+	require.NoError(t, tc.Close())
+	require.Empty(t, tracer.conns)
+}
+
+func TestStripLoginSuffix(t *testing.T) {
+	username, trace := stripLoginSuffix("user@pm.me#TRACE")
+	require.True(t, trace)
+	require.Equal(t, "user@pm.me", username)
+
+	username, trace = stripLoginSuffix("user@pm.me")
+	require.False(t, trace)
+	require.Equal(t, "user@pm.me", username)
+}
+
+type fakeAddrConn struct {
+	net.Conn
+
+	remote net.Addr
+}
+
+func (c *fakeAddrConn) RemoteAddr() net.Addr { return c.remote }
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	imapquota "github.com/emersion/go-imap-quota"
 	goIMAPBackend "github.com/emersion/go-imap/backend"
@@ -37,6 +38,7 @@ type imapUser struct {
 
 	storeUser    storeUserProvider
 	storeAddress storeAddressProvider
+	prefetcher   *prefetcher
 
 	currentAddressLowercase string
 }
@@ -66,6 +68,12 @@ func newIMAPUser(
 		return nil, err
 	}
 
+	if exceptions := backend.getLegacySubscriptionExceptions(storeUser.UserID()); len(exceptions) > 0 {
+		if err := storeUser.ImportUnsubscribed(exceptions); err != nil {
+			log.WithError(err).Warn("Could not import subscriptions")
+		}
+	}
+
 	return &imapUser{
 		panicHandler: panicHandler,
 		backend:      backend,
@@ -73,29 +81,14 @@ func newIMAPUser(
 
 		storeUser:    storeUser,
 		storeAddress: storeAddress,
+		prefetcher:   newPrefetcher(panicHandler),
 
 		currentAddressLowercase: strings.ToLower(address),
 	}, err
 }
 
 func (iu *imapUser) isSubscribed(labelID string) bool {
-	subscriptionExceptions := iu.backend.getCacheList(iu.storeUser.UserID(), SubscriptionException)
-	exceptions := strings.Split(subscriptionExceptions, ";")
-
-	for _, exception := range exceptions {
-		if exception == labelID {
-			return false
-		}
-	}
-	return true
-}
-
-func (iu *imapUser) removeFromCache(label, value string) {
-	iu.backend.removeFromCache(iu.storeUser.UserID(), label, value)
-}
-
-func (iu *imapUser) addToCache(label, value string) {
-	iu.backend.addToCache(iu.storeUser.UserID(), label, value)
+	return iu.storeUser.IsSubscribed(labelID)
 }
 
 // Username returns this user's username.
@@ -117,12 +110,16 @@ func (iu *imapUser) ListMailboxes(showOnlySubcribed bool) ([]goIMAPBackend.Mailb
 		if showOnlySubcribed && !iu.isSubscribed(storeMailbox.LabelID()) {
 			continue
 		}
+		if storeMailbox.LabelID() == pmapi.AllMailLabel && iu.backend.allMailPolicy() == preferences.AllMailHidden {
+			continue
+		}
 		mailbox := newIMAPMailbox(iu.panicHandler, iu, storeMailbox)
 		mailboxes = append(mailboxes, mailbox)
 	}
 
 	mailboxes = append(mailboxes, newLabelsRootMailbox())
 	mailboxes = append(mailboxes, newFoldersRootMailbox())
+	mailboxes = append(mailboxes, newVirtualRootMailbox())
 
 	log.WithField("mailboxes", mailboxes).Trace("Listing mailboxes")
 
@@ -140,6 +137,10 @@ func (iu *imapUser) GetMailbox(name string) (mb goIMAPBackend.Mailbox, err error
 		return
 	}
 
+	if storeMailbox.LabelID() == pmapi.AllMailLabel && iu.backend.allMailPolicy() == preferences.AllMailHidden {
+		return nil, goIMAPBackend.ErrNoSuchMailbox
+	}
+
 	return newIMAPMailbox(iu.panicHandler, iu, storeMailbox), nil
 }
 
@@ -189,6 +190,8 @@ func (iu *imapUser) Logout() (err error) {
 
 	log.Debug("IMAP client logged out address ", iu.storeAddress.AddressID())
 
+	iu.storeUser.ClientDisconnected()
+
 	iu.backend.deleteUser(iu.currentAddressLowercase)
 
 	return nil
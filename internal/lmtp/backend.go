@@ -0,0 +1,122 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package lmtp
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+)
+
+type panicHandler interface {
+	HandlePanic()
+}
+
+type bridger interface {
+	GetUser(query string) (bridgeUser, error)
+}
+
+type bridgeUser interface {
+	GetAddressID(address string) (string, error)
+	GetTemporaryPMAPIClient() pmapi.Client
+}
+
+type bridgeWrap struct {
+	*bridge.Bridge
+}
+
+// GetUser returns bridgeUser instead of *users.User to implement bridger.
+func (b *bridgeWrap) GetUser(query string) (bridgeUser, error) {
+	user, err := b.Bridge.GetUser(query)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// deliverer delivers message to local recipient.
+type deliverer interface {
+	isRecipient(address string) bool
+	deliver(recipient string, literal []byte) error
+}
+
+type lmtpBackend struct {
+	panicHandler panicHandler
+	bridge       bridger
+}
+
+// NewLMTPBackend returns backend importing delivered messages to accounts
+// of the bridge.
+func NewLMTPBackend(panicHandler panicHandler, bridge *bridge.Bridge) *lmtpBackend { //nolint[golint]
+	return &lmtpBackend{
+		panicHandler: panicHandler,
+		bridge:       &bridgeWrap{Bridge: bridge},
+	}
+}
+
+// isRecipient returns whether address belongs to any account of the bridge.
+func (lb *lmtpBackend) isRecipient(address string) bool {
+	_, err := lb.bridge.GetUser(address)
+	return err == nil
+}
+
+// deliver encrypts the message by the key of the recipient address and
+// imports it into Inbox as a received unread message.
+func (lb *lmtpBackend) deliver(recipient string, literal []byte) error {
+	user, err := lb.bridge.GetUser(recipient)
+	if err != nil {
+		return err
+	}
+
+	addressID, err := user.GetAddressID(recipient)
+	if err != nil {
+		return err
+	}
+
+	client := user.GetTemporaryPMAPIClient()
+
+	kr, err := client.KeyRingForAddressID(addressID)
+	if err != nil {
+		return errors.Wrap(err, "cannot get keyring")
+	}
+
+	m, _, _, readers, err := message.Parse(bytes.NewReader(literal), "", "")
+	if err != nil {
+		return errors.Wrap(err, "cannot parse message")
+	}
+	m.AddressID = addressID
+
+	body := new(bytes.Buffer)
+	if err := message.WriteEncrypted(body, m, readers, kr); err != nil {
+		return errors.Wrap(err, "cannot encrypt message")
+	}
+
+	_, err = client.Import([]*pmapi.ImportMsgReq{{
+		AddressID:  addressID,
+		BodyReader: body,
+		Unread:     1,
+		Flags:      pmapi.FlagReceived,
+		Time:       time.Now().Unix(),
+		LabelIDs:   []string{pmapi.InboxLabel},
+	}})
+	return errors.Wrap(err, "cannot import message")
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package lmtp provides LMTP server (RFC2033) delivering local mail, e.g. from
+// fetchmail or cron, into the Inbox of Bridge accounts.
+package lmtp
+
+import "github.com/sirupsen/logrus"
+
+const (
+	// maxMessageSize is the biggest message accepted; the import endpoint
+	// refuses bigger ones anyway.
+	maxMessageSize = 25 * 1024 * 1024
+)
+
+var (
+	log = logrus.WithField("pkg", "lmtp") //nolint[gochecknoglobals]
+)
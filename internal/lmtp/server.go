@@ -0,0 +1,266 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package lmtp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+)
+
+// idleTimeout closes connections of clients which stopped talking.
+const idleTimeout = 5 * time.Minute
+
+type lmtpServer struct {
+	panicHandler  panicHandler
+	listenerCfg   bridge.ListenerConfig
+	backend       deliverer
+	eventListener listener.Listener
+	listener      net.Listener
+	listening     int32
+}
+
+// NewLMTPServer returns an LMTP server delivering to accounts of the backend.
+// LMTP has no authentication, therefore the listener should be either a unix
+// socket or a loopback address.
+func NewLMTPServer(panicHandler panicHandler, listenerCfg bridge.ListenerConfig, lmtpBackend *lmtpBackend, eventListener listener.Listener) *lmtpServer { //nolint[golint]
+	return &lmtpServer{
+		panicHandler:  panicHandler,
+		listenerCfg:   listenerCfg,
+		backend:       lmtpBackend,
+		eventListener: eventListener,
+	}
+}
+
+// Starts the server.
+func (s *lmtpServer) ListenAndServe() {
+	l := log.WithField("address", s.listenerCfg.Address())
+
+	if s.listenerCfg.IsRemote() {
+		l.Error("LMTP refuses to listen on remote address")
+		return
+	}
+
+	l.Info("LMTP server is starting")
+	if err := s.serve(); err != nil {
+		s.eventListener.Emit(events.ErrorEvent, "LMTP failed: "+err.Error())
+		l.Error("LMTP failed: ", err)
+		return
+	}
+
+	l.Info("LMTP server stopped")
+}
+
+func (s *lmtpServer) serve() (err error) {
+	if s.listener, err = s.listenerCfg.Listen(); err != nil {
+		return err
+	}
+	defer s.listener.Close() //nolint[errcheck]
+
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// IsListening returns whether the server accepts connections.
+func (s *lmtpServer) IsListening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
+}
+
+// Stops the server.
+func (s *lmtpServer) Close() {
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+}
+
+func (s *lmtpServer) handleConn(conn net.Conn) {
+	defer s.panicHandler.HandlePanic()
+	defer conn.Close() //nolint[errcheck]
+
+	sess := &session{backend: s.backend, conn: conn, text: textproto.NewConn(conn)}
+	if err := sess.serve(); err != nil && err != io.EOF {
+		log.WithError(err).Warn("LMTP connection failed")
+	}
+}
+
+// session holds the state of one LMTP connection.
+type session struct {
+	backend deliverer
+	conn    net.Conn
+	text    *textproto.Conn
+
+	greeted    bool
+	hasSender  bool
+	recipients []string
+}
+
+func (sess *session) serve() error {
+	if err := sess.text.PrintfLine("220 %v LMTP Bridge ready", bridge.Host); err != nil {
+		return err
+	}
+
+	for {
+		_ = sess.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		quit, err := sess.handle(strings.ToUpper(cmd), arg)
+		if err != nil || quit {
+			return err
+		}
+	}
+}
+
+func (sess *session) handle(cmd, arg string) (quit bool, err error) { //nolint[funlen]
+	switch cmd {
+	case "LHLO":
+		sess.greeted = true
+		sess.reset()
+		return false, sess.text.PrintfLine("250-%v\r\n250-PIPELINING\r\n250-ENHANCEDSTATUSCODES\r\n250 8BITMIME", bridge.Host)
+
+	case "HELO", "EHLO":
+		return false, sess.text.PrintfLine("500 5.5.1 This is LMTP server, use LHLO")
+
+	case "MAIL":
+		switch {
+		case !sess.greeted:
+			return false, sess.text.PrintfLine("503 5.5.1 Send LHLO first")
+		case sess.hasSender:
+			return false, sess.text.PrintfLine("503 5.5.1 Sender already specified")
+		}
+		if _, ok := parsePath(arg, "FROM:"); !ok {
+			return false, sess.text.PrintfLine("501 5.5.4 Syntax: MAIL FROM:<address>")
+		}
+		sess.hasSender = true
+		return false, sess.text.PrintfLine("250 2.1.0 OK")
+
+	case "RCPT":
+		if !sess.hasSender {
+			return false, sess.text.PrintfLine("503 5.5.1 Send MAIL first")
+		}
+		address, ok := parsePath(arg, "TO:")
+		if !ok || address == "" {
+			return false, sess.text.PrintfLine("501 5.5.4 Syntax: RCPT TO:<address>")
+		}
+		if !sess.backend.isRecipient(address) {
+			return false, sess.text.PrintfLine("550 5.1.1 No such user here")
+		}
+		sess.recipients = append(sess.recipients, address)
+		return false, sess.text.PrintfLine("250 2.1.5 OK")
+
+	case "DATA":
+		if len(sess.recipients) == 0 {
+			return false, sess.text.PrintfLine("503 5.5.1 Send RCPT first")
+		}
+		return false, sess.data()
+
+	case "RSET":
+		sess.reset()
+		return false, sess.text.PrintfLine("250 2.0.0 OK")
+
+	case "NOOP":
+		return false, sess.text.PrintfLine("250 2.0.0 OK")
+
+	case "VRFY":
+		return false, sess.text.PrintfLine("252 2.5.0 Cannot verify user")
+
+	case "QUIT":
+		return true, sess.text.PrintfLine("221 2.0.0 Bye")
+	}
+
+	return false, sess.text.PrintfLine("500 5.5.2 Unknown command")
+}
+
+// data reads the message and replies for every recipient separately.
+func (sess *session) data() error {
+	if err := sess.text.PrintfLine("354 End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+
+	dr := sess.text.DotReader()
+	literal, err := ioutil.ReadAll(io.LimitReader(dr, maxMessageSize+1))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+		return err
+	}
+
+	recipients := sess.recipients
+	sess.reset()
+
+	for _, recipient := range recipients {
+		if len(literal) > maxMessageSize {
+			err = sess.text.PrintfLine("552 5.3.4 Message too big for <%v>", recipient)
+		} else if deliverErr := sess.backend.deliver(recipient, literal); deliverErr != nil {
+			log.WithError(deliverErr).WithField("recipient", recipient).Error("Cannot deliver message")
+			err = sess.text.PrintfLine("451 4.3.0 Cannot deliver to <%v>", recipient)
+		} else {
+			err = sess.text.PrintfLine("250 2.0.0 Delivered to <%v>", recipient)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sess *session) reset() {
+	sess.hasSender = false
+	sess.recipients = nil
+}
+
+// parsePath returns the address from `FROM:<address> params` argument.
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+
+	end := strings.IndexByte(arg, '>')
+	if !strings.HasPrefix(arg, "<") || end < 0 {
+		return "", false
+	}
+
+	return strings.TrimSpace(arg[1:end]), true
+}
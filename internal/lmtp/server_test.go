@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package lmtp
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDeliverer struct {
+	delivered map[string]string
+}
+
+func (td *testDeliverer) isRecipient(address string) bool {
+	return address != "unknown@pm.me"
+}
+
+func (td *testDeliverer) deliver(recipient string, literal []byte) error {
+	if recipient == "broken@pm.me" {
+		return errors.New("import failed")
+	}
+	td.delivered[recipient] = string(literal)
+	return nil
+}
+
+func newTestSession(t *testing.T) (*testDeliverer, *textproto.Conn) {
+	serverConn, clientConn := net.Pipe()
+	backend := &testDeliverer{delivered: map[string]string{}}
+
+	go func() {
+		sess := &session{backend: backend, conn: serverConn, text: textproto.NewConn(serverConn)}
+		_ = sess.serve()
+		_ = serverConn.Close()
+	}()
+
+	client := textproto.NewConn(clientConn)
+	_, _, err := client.ReadResponse(220)
+	require.NoError(t, err)
+
+	return backend, client
+}
+
+func cmd(t *testing.T, client *textproto.Conn, expectCode int, format string, args ...interface{}) string {
+	require.NoError(t, client.PrintfLine(format, args...))
+	_, msg, err := client.ReadResponse(expectCode)
+	require.NoError(t, err, msg)
+	return msg
+}
+
+func TestLMTPDelivery(t *testing.T) {
+	backend, client := newTestSession(t)
+	defer client.Close() //nolint[errcheck]
+
+	cmd(t, client, 503, "MAIL FROM:<cron@localhost>")
+	cmd(t, client, 500, "EHLO localhost")
+	assert.Contains(t, cmd(t, client, 250, "LHLO localhost"), "PIPELINING")
+
+	cmd(t, client, 503, "RCPT TO:<user@pm.me>")
+	cmd(t, client, 250, "MAIL FROM:<>")
+	cmd(t, client, 250, "RCPT TO:<user@pm.me>")
+	cmd(t, client, 550, "RCPT TO:<unknown@pm.me>")
+	cmd(t, client, 250, "RCPT TO:<broken@pm.me> NOTIFY=NEVER")
+	cmd(t, client, 354, "DATA")
+
+	w := client.DotWriter()
+	_, err := w.Write([]byte("Subject: test\r\n\r\n.hello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, _, err = client.ReadResponse(250)
+	require.NoError(t, err)
+	_, _, err = client.ReadResponse(451)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"user@pm.me": "Subject: test\n\n.hello\n"}, backend.delivered)
+
+	cmd(t, client, 503, "DATA")
+	cmd(t, client, 221, "QUIT")
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		arg, prefix, address string
+		ok                   bool
+	}{
+		{"FROM:<a@pm.me>", "FROM:", "a@pm.me", true},
+		{"from: <a@pm.me> SIZE=100", "FROM:", "a@pm.me", true},
+		{"FROM:<>", "FROM:", "", true},
+		{"TO:a@pm.me", "TO:", "", false},
+		{"FROM:<a@pm.me>", "TO:", "", false},
+		{"TO", "TO:", "", false},
+	}
+
+	for _, test := range tests {
+		address, ok := parsePath(test.arg, test.prefix)
+		assert.Equal(t, test.address, address, test.arg)
+		assert.Equal(t, test.ok, ok, test.arg)
+	}
+}
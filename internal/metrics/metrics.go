@@ -18,12 +18,73 @@
 // Package metrics collects string constants used to report anonymous usage metrics.
 package metrics
 
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
 type (
 	Category string
 	Action   string
 	Label    string
+
+	// Group is a set of categories which user opts in to separately.
+	Group string
 )
 
+// Groups of metrics. Each is sent only when enabled, none is by default.
+const (
+	// GroupCrashes contains counts of crashes, not their content (which is
+	// controlled by crash reports).
+	GroupCrashes = Group("crashes")
+
+	// GroupUsage contains metrics of setup, heartbeats and import-export.
+	GroupUsage = Group("usage")
+
+	// GroupPerformance contains durations of operations such as sync.
+	GroupPerformance = Group("performance")
+)
+
+//nolint[gochecknoglobals]
+var (
+	categoryGroups = map[Category]Group{
+		Setup:       GroupUsage,
+		Heartbeat:   GroupUsage,
+		Import:      GroupUsage,
+		Export:      GroupUsage,
+		Crash:       GroupCrashes,
+		Performance: GroupPerformance,
+	}
+
+	enabledGroups     = map[Group]bool{}
+	enabledGroupsLock = &sync.RWMutex{}
+)
+
+// GetGroups returns all groups of metrics.
+func GetGroups() []Group {
+	return []Group{GroupCrashes, GroupUsage, GroupPerformance}
+}
+
+// SetEnabledGroups sets which groups of metrics can be sent, others are not.
+func SetEnabledGroups(groups []Group) {
+	enabledGroupsLock.Lock()
+	defer enabledGroupsLock.Unlock()
+
+	enabledGroups = map[Group]bool{}
+	for _, group := range groups {
+		enabledGroups[group] = true
+	}
+}
+
+// IsEnabled returns whether the group of metrics can be sent.
+func IsEnabled(group Group) bool {
+	enabledGroupsLock.RLock()
+	defer enabledGroupsLock.RUnlock()
+
+	return enabledGroups[group]
+}
+
 // Metric represents a single metric that can be reported and contains the necessary fields
 // of category, action and label that the /metrics endpoint expects.
 type Metric struct {
@@ -45,6 +106,18 @@ func (m Metric) Get() (Category, Action, Label) {
 	return m.c, m.a, m.l
 }
 
+// Group returns the group of the metric or empty string for unknown category.
+func (m Metric) Group() Group {
+	return categoryGroups[m.c]
+}
+
+// IsAllowed returns whether the metric can be sent, i.e. its group is
+// enabled. Metrics of unknown category are never sent.
+func (m Metric) IsAllowed() bool {
+	group := m.Group()
+	return group != "" && IsEnabled(group)
+}
+
 // Metrics related to bridge/account setup.
 const (
 	// Setup is used to group metrics related to bridge setup e.g. first start, new user.
@@ -93,4 +166,42 @@ const (
 	TransferFail = Action("fail")
 )
 
+// Metrics related to crashes.
+const (
+	// Crash is used to group crash metrics.
+	Crash = Category("crash")
+
+	// CrashDumps signifies that the app crashed since the previous start.
+	// With this will be reported also label with number of crash dumps.
+	CrashDumps = Action("dumps")
+)
+
+// Metrics related to performance.
+const (
+	// Performance is used to group performance metrics.
+	Performance = Category("performance")
+
+	// SyncDuration signifies finished sync of an account.
+	// With this will be reported also label with duration, see DurationLabel.
+	SyncDuration = Action("sync_duration")
+)
+
 const NoLabel = Label("")
+
+// CountLabel returns the label of a count.
+func CountLabel(count int) Label {
+	return Label(strconv.Itoa(count))
+}
+
+// DurationLabel returns the label of a duration rounded to a bucket so the
+// exact value cannot tell anything about the account.
+func DurationLabel(d time.Duration) Label {
+	for _, bucket := range []time.Duration{
+		time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 4 * time.Hour,
+	} {
+		if d < bucket {
+			return Label("<" + bucket.String())
+		}
+	}
+	return Label(">=4h0m0s")
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricGroups(t *testing.T) {
+	defer SetEnabledGroups(nil)
+
+	usage := New(Setup, FirstStart, NoLabel)
+	crash := New(Crash, CrashDumps, CountLabel(2))
+	unknown := New(Category("unknown"), Action("unknown"), NoLabel)
+
+	require.Equal(t, GroupUsage, usage.Group())
+	require.Equal(t, GroupCrashes, crash.Group())
+	require.Equal(t, Group(""), unknown.Group())
+
+	require.False(t, usage.IsAllowed())
+	require.False(t, crash.IsAllowed())
+
+	SetEnabledGroups([]Group{GroupCrashes})
+	require.False(t, usage.IsAllowed())
+	require.True(t, crash.IsAllowed())
+
+	SetEnabledGroups(GetGroups())
+	require.True(t, usage.IsAllowed())
+	require.False(t, unknown.IsAllowed())
+}
+
+func TestDurationLabel(t *testing.T) {
+	require.Equal(t, Label("<1m0s"), DurationLabel(59*time.Second))
+	require.Equal(t, Label("<5m0s"), DurationLabel(time.Minute))
+	require.Equal(t, Label("<1h0m0s"), DurationLabel(30*time.Minute))
+	require.Equal(t, Label(">=4h0m0s"), DurationLabel(5*time.Hour))
+}
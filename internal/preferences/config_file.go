@@ -0,0 +1,400 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package preferences
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/metrics"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of values of settings.
+const (
+	KindString = "string"
+	KindInt    = "int"
+	KindBool   = "bool"
+	KindJSON   = "json" // Object or list written in YAML or as JSON string.
+)
+
+// envPrefix is the prefix of environment variables overriding settings, e.g.
+// BRIDGE_IMAP_PORT for imap.port.
+const envPrefix = "BRIDGE_"
+
+// Setting is one setting of the configuration file overriding a preference.
+type Setting struct {
+	Name   string   // Path in the configuration file, e.g. imap.port.
+	Key    string   // Key of the preference.
+	Kind   string   // One of kinds, e.g. KindInt.
+	Values []string // Allowed values, empty when any value of the kind is allowed.
+	Usage  string
+
+	check func(value string) error // Additional validation, optional.
+}
+
+// EnvName returns the name of environment variable overriding the setting.
+func (s *Setting) EnvName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.Name, ".", "_", -1))
+}
+
+// logLevels are values of log level settings, empty for the default.
+var logLevels = []string{"", "panic", "fatal", "error", "warn", "info", "debug", "trace"} //nolint[gochecknoglobals]
+
+// GetSettings returns all settings which can be set in the configuration
+// file. Internal preferences, e.g. last used version, are not among them.
+func GetSettings() []*Setting { //nolint[funlen]
+	return []*Setting{
+		{Name: "api.port", Key: APIPortKey, Kind: KindInt, Usage: "Port of local bridge API"},
+		{Name: "api.grpc_port", Key: GRPCPortKey, Kind: KindInt, Usage: "Port of gRPC API of the frontend started by --grpc"},
+		{Name: "api.debug_port", Key: DebugPortKey, Kind: KindInt, Usage: "Port on localhost serving pprof profiles and runtime metrics, 0 turns it off"},
+		{Name: "imap.port", Key: IMAPPortKey, Kind: KindInt, Usage: "IMAP port"},
+		{Name: "imap.socket", Key: IMAPSocketKey, Kind: KindString, Usage: "Unix socket for IMAP instead of the port"},
+		{Name: "imap.namespace", Key: IMAPNamespaceKey, Kind: KindBool, Usage: "Announce folders, labels and views as separate namespaces"},
+		{Name: "imap.max_connections_per_account", Key: IMAPMaxConnAccountKey, Kind: KindInt, Usage: "Concurrent connections of one account"},
+		{Name: "imap.max_connections_per_client", Key: IMAPMaxConnClientKey, Kind: KindInt, Usage: "Concurrent connections from one client address"},
+		{Name: "imap.max_connections_per_client_per_minute", Key: IMAPMaxConnRateKey, Kind: KindInt, Usage: "New connections from one client address in a minute"},
+		{Name: "imap.autologout_minutes", Key: IMAPAutoLogoutKey, Kind: KindInt, Usage: "Logout of inactive connections, at least 1"},
+		{Name: "imap.idle_keepalive_seconds", Key: IMAPIdleKeepaliveKey, Kind: KindInt, Usage: "Keepalive response during IDLE, 0 turns it off"},
+		{Name: "imap.literal_timeout_seconds", Key: IMAPLiteralTimeoutKey, Kind: KindInt, Usage: "Time to send a literal, 0 for default"},
+		{Name: "imap.all_mail_policy", Key: AllMailPolicyKey, Kind: KindString, Values: []string{AllMailFlagsOnly, AllMailReadOnly, AllMailHidden}, Usage: "What clients can do in All Mail"},
+		{Name: "imap.expunge_policy", Key: ExpungePolicyKey, Kind: KindString, Values: []string{"", "trash", "label", "permanent"}, Usage: "Meaning of EXPUNGE, empty removes the label"},
+		{Name: "imap.expunge_policy_overrides", Key: ExpungeOverridesKey, Kind: KindJSON, Usage: "Expunge policy by mailbox name"},
+		{Name: "imap.saved_searches", Key: SavedSearchesKey, Kind: KindJSON, Usage: "Virtual mailboxes by name with IMAP search criteria"},
+		{Name: "smtp.port", Key: SMTPPortKey, Kind: KindInt, Usage: "SMTP port"},
+		{Name: "smtp.ssl", Key: SMTPSSLKey, Kind: KindBool, Usage: "Use SSL instead of STARTTLS on SMTP port"},
+		{Name: "smtp.ssl_port", Key: SMTPSPortKey, Kind: KindInt, Usage: "Additional SMTPS port, 0 turns it off"},
+		{Name: "smtp.socket", Key: SMTPSocketKey, Kind: KindString, Usage: "Unix socket for SMTP instead of the port"},
+		{Name: "smtp.bcc_policy", Key: SMTPBccPolicyKey, Kind: KindString, Values: []string{"keep", "strip"}, Usage: "Whether Bcc recipients are kept in own Sent copy"},
+		{Name: "smtp.auto_bcc", Key: SMTPAutoBccKey, Kind: KindString, Usage: "Addresses added as Bcc to every message separated by comma, self for the sender"},
+		{Name: "smtp.outgoing_rules", Key: SMTPOutgoingRulesKey, Kind: KindJSON, Usage: "Rules for sent messages (footer, Bcc and headers)"},
+		{Name: "smtp.retry_queue", Key: SMTPRetryQueueKey, Kind: KindBool, Usage: "Queue messages which failed to send and retry them"},
+		{Name: "smtp.no_key_policy", Key: SMTPNoKeyPolicyKey, Kind: KindString, Values: []string{"fail", "plaintext", "ask"}, Usage: "External recipients without key when encryption is requested"},
+		{Name: "smtp.max_messages_per_minute", Key: SMTPMaxMessagesKey, Kind: KindInt, Usage: "Messages sent by one account in a minute"},
+		{Name: "smtp.max_recipients_per_hour", Key: SMTPMaxRecipientsKey, Kind: KindInt, Usage: "Recipients of one account in an hour"},
+		{Name: "smtp.max_upload_mb", Key: SMTPMaxUploadKey, Kind: KindInt, Usage: "Attachments of one message announced by SIZE before login, 0 for no limit"},
+		{Name: "smtp.reply_keys", Key: SMTPReplyKeysKey, Kind: KindString, Usage: "Contacts whose key attached in the replied message encrypts replies, separated by comma"},
+		{Name: "smtp.report_outgoing_without_encryption", Key: ReportOutgoingNoEncKey, Kind: KindBool, Usage: "Ask before sending messages without encryption"},
+		{Name: "smtp.mdn_policy", Key: MDNPolicyKey, Kind: KindString, Values: []string{"never", "allowed", "always"}, Usage: "When read receipts are sent"},
+		{Name: "smtp.mdn_allow", Key: MDNAllowKey, Kind: KindString, Usage: "Senders getting read receipts with allowed policy"},
+		{Name: "smtp.mdn_deny", Key: MDNDenyKey, Kind: KindString, Usage: "Senders not getting read receipts with always policy"},
+		{Name: "lmtp.port", Key: LMTPPortKey, Kind: KindInt, Usage: "Loopback LMTP port, 0 turns it off"},
+		{Name: "lmtp.socket", Key: LMTPSocketKey, Kind: KindString, Usage: "Unix socket for LMTP"},
+		{Name: "network.bind_host", Key: BindHostKey, Kind: KindString, Usage: "Address IMAP and SMTP listen on, loopback by default"},
+		{Name: "network.allowed_clients", Key: AllowedClientsKey, Kind: KindString, Usage: "Remote client IPs or CIDR ranges separated by comma"},
+		{Name: "network.account_ports", Key: AccountPortsKey, Kind: KindJSON, Usage: "Dedicated IMAP and SMTP ports by account"},
+		{Name: "network.profile", Key: NetworkProfileKey, Kind: KindString, Values: GetNetworkProfileNames(), Usage: "Limits of bandwidth, parallel transfers and polling"},
+		{Name: "network.allow_proxy", Key: AllowProxyKey, Kind: KindBool, Usage: "Use alternative routing when API is blocked"},
+		{Name: "network.proxy", Key: ProxyKey, Kind: KindString, Usage: "Proxy of API traffic: auto for environment and system settings incl. PAC and WPAD, direct or URL of HTTP or SOCKS5 proxy", check: checkProxy},
+		{Name: "tls.cert", Key: TLSCertPathKey, Kind: KindString, Usage: "Certificate for IMAP and SMTP instead of generated one"},
+		{Name: "tls.key", Key: TLSKeyPathKey, Kind: KindString, Usage: "Private key of the certificate"},
+		{Name: "cache.body_size_mb", Key: BodyCacheSizeKey, Kind: KindInt, Usage: "Size of cache of message bodies, 0 turns it off"},
+		{Name: "cache.body_accounts_mb", Key: BodyCacheAccountsKey, Kind: KindJSON, Usage: "Size of cache of message bodies by account"},
+		{Name: "cache.body_eviction", Key: BodyCacheEvictionKey, Kind: KindString, Values: []string{"lru", "oldest"}, Usage: "Which bodies are evicted first"},
+		{Name: "cache.attachment_size_mb", Key: AttCacheSizeKey, Kind: KindInt, Usage: "Size of cache of attachments"},
+		{Name: "search.index", Key: SearchIndexKey, Kind: KindBool, Usage: "Local full-text search index"},
+		{Name: "search.index_days", Key: SearchIndexDaysKey, Kind: KindInt, Usage: "Age of indexed messages, 0 for all"},
+		{Name: "sync.workers", Key: SyncWorkersKey, Kind: KindInt, Usage: "Parallel workers of initial sync"},
+		{Name: "sync.memory_limit_mb", Key: SyncMemoryLimitKey, Kind: KindInt, Usage: "Memory for fetched pages of sync before spilling to disk, at least 16"},
+		{Name: "sync.excluded", Key: SyncExcludedKey, Kind: KindJSON, Usage: "Mailboxes not synced by account"},
+		{Name: "sync.prefetch_messages", Key: PrefetchMessagesKey, Kind: KindInt, Usage: "Messages built ahead of IMAP client, 0 turns it off"},
+		{Name: "sync.mode", Key: SyncModeKey, Kind: KindString, Values: []string{"full", "metadata", "metadata-nocache"}, Usage: "What is kept of messages locally"},
+		{Name: "sync.poll_min_seconds", Key: PollMinIntervalKey, Kind: KindInt, Usage: "Shortest interval of event polling"},
+		{Name: "sync.poll_max_seconds", Key: PollMaxIntervalKey, Kind: KindInt, Usage: "Longest interval of event polling"},
+		{Name: "sync.metered_connection", Key: MeteredConnectionKey, Kind: KindBool, Usage: "Poll less often and do not prefetch"},
+		{Name: "keychain.backend", Key: KeychainBackendKey, Kind: KindString, Values: []string{"", "native", "file", "pass", "secret-service", "kwallet", "vault"}, Usage: "Where credentials are kept, empty for file when BRIDGE_KEYCHAIN_FILE is set or native otherwise"},
+		{Name: "app.webhooks", Key: WebhooksKey, Kind: KindJSON, Usage: "URLs receiving JSON about new mail, sending and sync"},
+		{Name: "app.update_channel", Key: UpdateChannelKey, Kind: KindString, Values: []string{"stable", "early"}, Usage: "Channel of updates, early gets new versions first"},
+		{Name: "app.crash_reports", Key: CrashReportsKey, Kind: KindString, Values: []string{"off", "anonymous", "full"}, Usage: "What is sent about crashes and errors, local crash dumps are written always"},
+		{Name: "app.autostart", Key: AutostartKey, Kind: KindBool, Usage: "Start with the system"},
+		{Name: "app.log_level", Key: LogLevelKey, Kind: KindString, Values: logLevels, Usage: "Log level when not set by --log-level, empty for info"},
+		{Name: "app.log_level_pmapi", Key: LogLevelSubsystemKey("pmapi"), Kind: KindString, Values: logLevels, Usage: "Log level of API client, empty for app.log_level"},
+		{Name: "app.log_level_imap", Key: LogLevelSubsystemKey("imap"), Kind: KindString, Values: logLevels, Usage: "Log level of IMAP server, empty for app.log_level"},
+		{Name: "app.log_level_smtp", Key: LogLevelSubsystemKey("smtp"), Kind: KindString, Values: logLevels, Usage: "Log level of SMTP server, empty for app.log_level"},
+		{Name: "app.log_level_store", Key: LogLevelSubsystemKey("store"), Kind: KindString, Values: logLevels, Usage: "Log level of local store and sync, empty for app.log_level"},
+		{Name: "app.log_level_frontend", Key: LogLevelSubsystemKey("frontend"), Kind: KindString, Values: logLevels, Usage: "Log level of GUI, CLI and gRPC frontends, empty for app.log_level"},
+		{Name: "app.log_max_file_size_mb", Key: LogMaxFileSizeKey, Kind: KindInt, Usage: "Size of log file after which a new one is opened"},
+		{Name: "app.log_max_files", Key: LogMaxFilesKey, Kind: KindInt, Usage: "Log files and crash dumps kept, including the current log"},
+		{Name: "app.log_max_total_mb", Key: LogMaxTotalSizeKey, Kind: KindInt, Usage: "Size of all logs and crash dumps, oldest are removed first, 0 for no limit"},
+		{Name: "app.log_max_age_days", Key: LogMaxAgeKey, Kind: KindInt, Usage: "Logs and crash dumps not written longer are removed, 0 for no limit"},
+		{Name: "app.log_format", Key: LogFormatKey, Kind: KindString, Values: []string{"", "text", "json"}, Usage: "Format of logs, empty for JSON in file and text in console"},
+		{Name: "telemetry.crashes", Key: TelemetryGroupKey(metrics.GroupCrashes), Kind: KindBool, Usage: "Send anonymous count of crashes"},
+		{Name: "telemetry.usage", Key: TelemetryGroupKey(metrics.GroupUsage), Kind: KindBool, Usage: "Send anonymous metrics of setup, daily usage and import-export"},
+		{Name: "telemetry.performance", Key: TelemetryGroupKey(metrics.GroupPerformance), Kind: KindBool, Usage: "Send anonymous duration of sync"},
+	}
+}
+
+// SetSetting changes the setting by its name in the configuration file,
+// e.g. from a frontend. Settings overridden by the configuration file or
+// environment cannot be changed.
+func SetSetting(pref *config.Preferences, name, value string) (*Setting, error) {
+	for _, setting := range GetSettings() {
+		if setting.Name != name {
+			continue
+		}
+		if pref.IsOverridden(setting.Key) {
+			return nil, errors.Errorf("%s is set by the configuration file or %s", name, setting.EnvName())
+		}
+		if err := setting.validate(value); err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		pref.Set(setting.Key, value)
+		return setting, nil
+	}
+	return nil, errors.Errorf("unknown setting %q", name)
+}
+
+// LoadConfig overrides preferences by the configuration file on the path
+// and then by environment variables. Missing file is not an error. Invalid
+// settings are skipped and returned as one error, valid ones are applied.
+// Overrides of previous load are replaced, so it can be called again to
+// reload the configuration. When the file cannot be read or parsed as whole,
+// overrides of previous load are kept as they are and the error is returned.
+func LoadConfig(pref *config.Preferences, path string) error {
+	var result *multierror.Error
+
+	values, err := readConfigFile(path)
+	if values == nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			env, envErr := readConfigEnv()
+			for key, value := range env {
+				pref.SetOverride(key, value)
+			}
+			return multierror.Append(result, err, envErr).ErrorOrNil()
+		}
+		values = map[string]string{}
+	} else if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	env, err := readConfigEnv()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	for key, value := range env {
+		values[key] = value
+	}
+
+	pref.SetOverrides(values)
+
+	return result.ErrorOrNil()
+}
+
+// readConfigEnv returns valid values of settings set by environment variables
+// by preference keys and problems of the others.
+func readConfigEnv() (map[string]string, error) {
+	var result *multierror.Error
+
+	values := map[string]string{}
+	for _, setting := range GetSettings() {
+		if env, ok := os.LookupEnv(setting.EnvName()); ok {
+			if err := setting.validate(env); err != nil {
+				result = multierror.Append(result, errors.Wrap(err, setting.EnvName()))
+				continue
+			}
+			values[setting.Key] = env
+		}
+	}
+	return values, result.ErrorOrNil()
+}
+
+// ValidateConfigFile returns all problems of the configuration file.
+func ValidateConfigFile(path string) error {
+	_, err := readConfigFile(path)
+	return err
+}
+
+// readConfigFile returns valid values of settings by preference keys and
+// problems of the others. Values are nil when the file cannot be read or
+// parsed at all.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path) //nolint[gosec]
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read configuration file")
+	}
+
+	tree := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, errors.Wrap(err, "cannot parse configuration file")
+	}
+
+	values := map[string]string{}
+
+	settings := map[string]*Setting{}
+	for _, setting := range GetSettings() {
+		settings[setting.Name] = setting
+	}
+
+	var result *multierror.Error
+	var walk func(prefix string, tree map[string]interface{})
+	walk = func(prefix string, tree map[string]interface{}) {
+		for name, node := range tree {
+			name = prefix + name
+			if setting, ok := settings[name]; ok {
+				value, err := setting.convert(node)
+				if err != nil {
+					result = multierror.Append(result, errors.Wrap(err, name))
+					continue
+				}
+				values[setting.Key] = value
+				continue
+			}
+			if subtree, ok := node.(map[string]interface{}); ok {
+				walk(name+".", subtree)
+				continue
+			}
+			result = multierror.Append(result, errors.Errorf("%s: unknown setting", name))
+		}
+	}
+	walk("", tree)
+
+	return values, result.ErrorOrNil()
+}
+
+// convert returns the preference value of the node parsed from YAML.
+func (s *Setting) convert(node interface{}) (string, error) {
+	var value string
+
+	switch node := node.(type) {
+	case map[string]interface{}, []interface{}:
+		if s.Kind != KindJSON {
+			return "", errors.Errorf("expected %s, not object or list", s.Kind)
+		}
+		data, err := json.Marshal(node)
+		if err != nil {
+			return "", err
+		}
+		value = string(data)
+	case nil:
+		value = ""
+	default:
+		value = fmt.Sprint(node)
+	}
+
+	return value, s.validate(value)
+}
+
+// validate checks the preference value against kind and allowed values.
+func (s *Setting) validate(value string) error {
+	switch s.Kind {
+	case KindInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.Errorf("expected integer, got %q", value)
+		}
+	case KindBool:
+		if value != "true" && value != "false" {
+			return errors.Errorf("expected true or false, got %q", value)
+		}
+	case KindJSON:
+		if !json.Valid([]byte(value)) {
+			return errors.Errorf("expected object or list, got %q", value)
+		}
+	}
+
+	if s.check != nil {
+		if err := s.check(value); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Values) == 0 {
+		return nil
+	}
+	for _, allowed := range s.Values {
+		if value == allowed {
+			return nil
+		}
+	}
+	return errors.Errorf("expected one of %q, got %q", s.Values, value)
+}
+
+func checkProxy(value string) error {
+	_, _, err := sysproxy.ParseMode(value)
+	return err
+}
+
+// WriteConfig writes the configuration file with current values of all
+// settings documented by comments.
+func WriteConfig(w io.Writer, pref *config.Preferences) error {
+	section := ""
+	for _, setting := range GetSettings() {
+		split := strings.SplitN(setting.Name, ".", 2)
+		if split[0] != section {
+			section = split[0]
+			if _, err := fmt.Fprintf(w, "%s:\n", section); err != nil {
+				return err
+			}
+		}
+
+		usage := setting.Usage + " (" + setting.EnvName() + ")"
+		if len(setting.Values) != 0 {
+			usage += fmt.Sprintf(", one of %q", setting.Values)
+		}
+
+		value := pref.Get(setting.Key)
+		switch setting.Kind {
+		case KindString:
+			value = strconv.Quote(value)
+		case KindJSON:
+			// JSON is valid YAML, only empty value needs to be written as null.
+			if value == "" {
+				value = "null"
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "  # %s\n  %s: %s\n", usage, split[1], value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSummary writes values of settings which cannot identify the user,
+// e.g. for crash dumps. Free-form settings such as paths, addresses or
+// JSON are only marked as set or not set.
+func WriteSummary(w io.Writer, pref *config.Preferences) error {
+	for _, setting := range GetSettings() {
+		value := pref.Get(setting.Key)
+		if setting.Kind != KindBool && setting.Kind != KindInt && len(setting.Values) == 0 {
+			switch value {
+			case "", "{}", "[]", "null":
+				value = "not set"
+			default:
+				value = "set"
+			}
+		}
+
+		if pref.IsOverridden(setting.Key) {
+			value += " (overridden)"
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: %s\n", setting.Name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package preferences
+
+// Names of network profiles.
+const (
+	NetworkProfileNormal       = "normal"
+	NetworkProfileMetered      = "metered"
+	NetworkProfileLowBandwidth = "low_bandwidth"
+)
+
+// NetworkProfile limits how much Bridge uses the network in total.
+// Zero limits mean unlimited.
+type NetworkProfile struct {
+	Name              string `json:"name"`
+	BandwidthKBps     int64  `json:"bandwidthKBps"`
+	ParallelTransfers int    `json:"parallelTransfers"`
+	Prefetch          bool   `json:"prefetch"`
+	MeteredPolling    bool   `json:"meteredPolling"`
+}
+
+// networkProfiles are in the order shown to users.
+var networkProfiles = []NetworkProfile{ //nolint[gochecknoglobals]
+	{Name: NetworkProfileNormal, Prefetch: true},
+	{Name: NetworkProfileMetered, BandwidthKBps: 512, ParallelTransfers: 2, MeteredPolling: true},
+	{Name: NetworkProfileLowBandwidth, BandwidthKBps: 128, ParallelTransfers: 2},
+}
+
+type networkProfileGetter interface {
+	Get(key string) string
+	GetBool(key string) bool
+}
+
+// GetNetworkProfileNames returns names of all network profiles.
+func GetNetworkProfileNames() []string {
+	names := make([]string, len(networkProfiles))
+	for i, profile := range networkProfiles {
+		names[i] = profile.Name
+	}
+	return names
+}
+
+// FindNetworkProfile returns the profile of the name and whether it exists.
+func FindNetworkProfile(name string) (NetworkProfile, bool) {
+	for _, profile := range networkProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return NetworkProfile{}, false
+}
+
+// GetNetworkProfile returns the selected network profile. The older metered
+// connection preference selects the metered profile when no other is set.
+func GetNetworkProfile(pref networkProfileGetter) NetworkProfile {
+	name := pref.Get(NetworkProfileKey)
+	if name == "" {
+		name = NetworkProfileNormal
+	}
+	if name == NetworkProfileNormal && pref.GetBool(MeteredConnectionKey) {
+		name = NetworkProfileMetered
+	}
+
+	profile, ok := FindNetworkProfile(name)
+	if !ok {
+		log.WithField("profile", name).Warn("Unknown network profile, using normal")
+		profile, _ = FindNetworkProfile(NetworkProfileNormal)
+	}
+
+	return profile
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package preferences
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProfilePreferences map[string]string
+
+func (p fakeProfilePreferences) Get(key string) string   { return p[key] }
+func (p fakeProfilePreferences) GetBool(key string) bool { return p[key] == "true" }
+
+func TestGetNetworkProfile(t *testing.T) {
+	tests := []struct {
+		profile, metered, want string
+	}{
+		{"", "false", NetworkProfileNormal},
+		{NetworkProfileNormal, "false", NetworkProfileNormal},
+		{NetworkProfileNormal, "true", NetworkProfileMetered},
+		{NetworkProfileLowBandwidth, "true", NetworkProfileLowBandwidth},
+		{NetworkProfileMetered, "false", NetworkProfileMetered},
+		{"unknown", "false", NetworkProfileNormal},
+	}
+	for _, tc := range tests {
+		pref := fakeProfilePreferences{NetworkProfileKey: tc.profile, MeteredConnectionKey: tc.metered}
+		require.Equal(t, tc.want, GetNetworkProfile(pref).Name, "profile %q metered %s", tc.profile, tc.metered)
+	}
+}
+
+func TestNetworkProfileSetting(t *testing.T) {
+	path, clear := newTestConfigFile(t, "")
+	defer clear()
+
+	pref := config.NewPreferences(filepath.Join(filepath.Dir(path), "prefs.json"))
+	require.NoError(t, LoadConfig(pref, path))
+
+	_, err := SetSetting(pref, "network.profile", NetworkProfileLowBandwidth)
+	require.NoError(t, err)
+	require.False(t, GetNetworkProfile(pref).Prefetch)
+
+	_, err = SetSetting(pref, "network.profile", "fast")
+	require.Error(t, err)
+}
@@ -22,7 +22,9 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/internal/metrics"
 	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,20 +32,111 @@ import (
 const (
 	FirstStartKey          = "first_time_start"
 	FirstStartGUIKey       = "first_time_start_gui"
+	FirstStartCLIKey       = "first_time_start_cli"
 	NextHeartbeatKey       = "next_heartbeat"
 	APIPortKey             = "user_port_api"
 	IMAPPortKey            = "user_port_imap"
 	SMTPPortKey            = "user_port_smtp"
 	SMTPSSLKey             = "user_ssl_smtp"
+	SMTPSPortKey           = "user_port_smtps"
 	AllowProxyKey          = "allow_proxy"
 	AutostartKey           = "autostart"
 	CookiesKey             = "cookies"
 	ReportOutgoingNoEncKey = "report_outgoing_email_without_encryption"
 	LastVersionKey         = "last_used_version"
+	SavedSearchesKey       = "saved_searches"
+	IMAPNamespaceKey       = "imap_namespace"
+	TLSCertPathKey         = "user_tls_cert"
+	TLSKeyPathKey          = "user_tls_key"
+	IMAPSocketKey          = "user_socket_imap"
+	SMTPSocketKey          = "user_socket_smtp"
+	BindHostKey            = "user_bind_host"
+	AllowedClientsKey      = "user_allowed_clients"
+	AccountPortsKey        = "user_account_ports"
+	IMAPMaxConnAccountKey  = "imap_max_connections_per_account"
+	IMAPMaxConnClientKey   = "imap_max_connections_per_client"
+	IMAPMaxConnRateKey     = "imap_max_connections_per_client_per_minute"
+	IMAPAutoLogoutKey      = "imap_autologout_minutes"
+	IMAPIdleKeepaliveKey   = "imap_idle_keepalive_seconds"
+	IMAPLiteralTimeoutKey  = "imap_literal_timeout_seconds"
+	AllMailPolicyKey       = "all_mail_policy"
+	ExpungePolicyKey       = "expunge_policy"
+	ExpungeOverridesKey    = "expunge_policy_overrides"
+	SMTPBccPolicyKey       = "smtp_bcc_policy"
+	SMTPAutoBccKey         = "smtp_auto_bcc"
+	SMTPOutgoingRulesKey   = "smtp_outgoing_rules"
+	SMTPRetryQueueKey      = "smtp_retry_queue"
+	SMTPNoKeyPolicyKey     = "smtp_no_key_policy"
+	SMTPMaxMessagesKey     = "smtp_max_messages_per_minute"
+	SMTPMaxRecipientsKey   = "smtp_max_recipients_per_hour"
+	SMTPMaxUploadKey       = "smtp_max_upload_mb"
+	SMTPReplyKeysKey       = "smtp_reply_keys"
+	MDNPolicyKey           = "mdn_policy"
+	MDNAllowKey            = "mdn_allow"
+	MDNDenyKey             = "mdn_deny"
+	LMTPPortKey            = "user_port_lmtp"
+	LMTPSocketKey          = "user_socket_lmtp"
+	SearchIndexKey         = "search_index"
+	SearchIndexDaysKey     = "search_index_days"
+	BodyCacheSizeKey       = "body_cache_size_mb"
+	BodyCacheAccountsKey   = "body_cache_accounts_mb"
+	BodyCacheEvictionKey   = "body_cache_eviction"
+	AttCacheSizeKey        = "attachment_cache_size_mb"
+	SyncWorkersKey         = "sync_workers"
+	SyncMemoryLimitKey     = "sync_memory_limit_mb"
+	SyncExcludedKey        = "sync_excluded"
+	PrefetchMessagesKey    = "prefetch_messages"
+	SyncModeKey            = "sync_mode"
+	PollMinIntervalKey     = "event_poll_min_seconds"
+	PollMaxIntervalKey     = "event_poll_max_seconds"
+	MeteredConnectionKey   = "metered_connection"
+	NetworkProfileKey      = "network_profile"
+	LogLevelKey            = "log_level"
+	LogFormatKey           = "log_format"
+	LogMaxFileSizeKey      = "log_max_file_size_mb"
+	LogMaxFilesKey         = "log_max_files"
+	LogMaxTotalSizeKey     = "log_max_total_mb"
+	LogMaxAgeKey           = "log_max_age_days"
+	KeychainBackendKey     = "keychain_backend"
+	GRPCPortKey            = "user_port_grpc"
+	DebugPortKey           = "user_port_debug"
+	WebhooksKey            = "webhooks"
+	ProxyKey               = "user_proxy"
+	UpdateChannelKey       = "update_channel"
+	CrashReportsKey        = "crash_reports"
+	LastCrashCheckKey      = "last_crash_check"
+	TelemetryKey           = "telemetry"
 )
 
+// All Mail policies of AllMailPolicyKey.
+const (
+	// AllMailFlagsOnly allows to change flags except \Deleted; expunge does
+	// nothing and move out of All Mail is copy.
+	AllMailFlagsOnly = "flags-only"
+
+	// AllMailReadOnly selects All Mail with [READ-ONLY] so clients do not try
+	// to change anything.
+	AllMailReadOnly = "read-only"
+
+	// AllMailHidden does not show All Mail at all.
+	AllMailHidden = "hidden"
+)
+
+// TelemetryGroupKey returns the key of opt-in to the group of anonymous
+// metrics, see metrics.GetGroups.
+func TelemetryGroupKey(group metrics.Group) string {
+	return TelemetryKey + "_" + string(group)
+}
+
+// LogLevelSubsystemKey returns the key of log level of the subsystem, see
+// config.GetLogSubsystems.
+func LogLevelSubsystemKey(subsystem string) string {
+	return LogLevelKey + "_" + subsystem
+}
+
 type configProvider interface {
 	GetPreferencesPath() string
+	GetConfigFilePath() string
 	GetDefaultAPIPort() int
 	GetDefaultIMAPPort() int
 	GetDefaultSMTPPort() int
@@ -51,12 +144,17 @@ type configProvider interface {
 
 var log = logrus.WithField("pkg", "store") //nolint[gochecknoglobals]
 
-// New returns loaded preferences with Bridge defaults when values are not set yet.
+// New returns loaded preferences with Bridge defaults when values are not set
+// yet. Values from the configuration file and environment take precedence.
 func New(cfg configProvider) (pref *config.Preferences) {
 	path := cfg.GetPreferencesPath()
 	pref = config.NewPreferences(path)
 	setDefaults(pref, cfg)
 
+	if err := LoadConfig(pref, cfg.GetConfigFilePath()); err != nil {
+		log.WithError(err).Error("Invalid configuration skipped, check it by config validate command")
+	}
+
 	log.WithField("path", path).Trace("Opened preferences")
 
 	return
@@ -65,15 +163,105 @@ func New(cfg configProvider) (pref *config.Preferences) {
 func setDefaults(preferences *config.Preferences, cfg configProvider) {
 	preferences.SetDefault(FirstStartKey, "true")
 	preferences.SetDefault(FirstStartGUIKey, "true")
+	preferences.SetDefault(FirstStartCLIKey, "true")
 	preferences.SetDefault(NextHeartbeatKey, strconv.FormatInt(time.Now().Unix(), 10))
 	preferences.SetDefault(APIPortKey, strconv.Itoa(cfg.GetDefaultAPIPort()))
 	preferences.SetDefault(IMAPPortKey, strconv.Itoa(cfg.GetDefaultIMAPPort()))
 	preferences.SetDefault(SMTPPortKey, strconv.Itoa(cfg.GetDefaultSMTPPort()))
+	preferences.SetDefault(SMTPSPortKey, "0")
+	preferences.SetDefault(GRPCPortKey, "1043")
+	preferences.SetDefault(DebugPortKey, "0")
 	preferences.SetDefault(AllowProxyKey, "true")
-	preferences.SetDefault(AutostartKey, "true")
+	// Portable mode does not register autostart in the system.
+	preferences.SetDefault(AutostartKey, strconv.FormatBool(!config.IsPortable()))
 	preferences.SetDefault(ReportOutgoingNoEncKey, "false")
 	preferences.SetDefault(LastVersionKey, "")
+	preferences.SetDefault(SavedSearchesKey, "{}")
+	preferences.SetDefault(IMAPNamespaceKey, "true")
+	preferences.SetDefault(TLSCertPathKey, "")
+	preferences.SetDefault(TLSKeyPathKey, "")
+	preferences.SetDefault(IMAPSocketKey, "")
+	preferences.SetDefault(SMTPSocketKey, "")
+	preferences.SetDefault(BindHostKey, "")
+	preferences.SetDefault(AllowedClientsKey, "")
+	preferences.SetDefault(AccountPortsKey, "{}")
+	preferences.SetDefault(IMAPMaxConnAccountKey, "20")
+	preferences.SetDefault(IMAPMaxConnClientKey, "50")
+	preferences.SetDefault(IMAPMaxConnRateKey, "60")
+	preferences.SetDefault(IMAPAutoLogoutKey, "30")
+	preferences.SetDefault(IMAPIdleKeepaliveKey, "0")
+	preferences.SetDefault(IMAPLiteralTimeoutKey, "0")
+	preferences.SetDefault(AllMailPolicyKey, AllMailFlagsOnly)
+	preferences.SetDefault(ExpungePolicyKey, "")
+	preferences.SetDefault(ExpungeOverridesKey, "{}")
+	preferences.SetDefault(SMTPBccPolicyKey, "keep")
+	preferences.SetDefault(SMTPAutoBccKey, "")
+	preferences.SetDefault(SMTPOutgoingRulesKey, "[]")
+	preferences.SetDefault(SMTPRetryQueueKey, "true")
+	preferences.SetDefault(SMTPNoKeyPolicyKey, "fail")
+	preferences.SetDefault(SMTPMaxMessagesKey, "20")
+	preferences.SetDefault(SMTPMaxRecipientsKey, "500")
+	preferences.SetDefault(SMTPMaxUploadKey, "25")
+	preferences.SetDefault(SMTPReplyKeysKey, "")
+	preferences.SetDefault(MDNPolicyKey, "never")
+	preferences.SetDefault(MDNAllowKey, "")
+	preferences.SetDefault(MDNDenyKey, "")
+	preferences.SetDefault(LMTPPortKey, "0")
+	preferences.SetDefault(LMTPSocketKey, "")
+	preferences.SetDefault(SearchIndexKey, "false")
+	preferences.SetDefault(SearchIndexDaysKey, "0")
+	preferences.SetDefault(BodyCacheSizeKey, "500")
+	preferences.SetDefault(BodyCacheAccountsKey, "{}")
+	preferences.SetDefault(BodyCacheEvictionKey, "lru")
+	preferences.SetDefault(AttCacheSizeKey, "1000")
+	preferences.SetDefault(SyncWorkersKey, "5")
+	preferences.SetDefault(SyncMemoryLimitKey, "256")
+	preferences.SetDefault(SyncExcludedKey, "{}")
+	preferences.SetDefault(PrefetchMessagesKey, "5")
+	preferences.SetDefault(SyncModeKey, "full")
+	preferences.SetDefault(PollMinIntervalKey, "30")
+	preferences.SetDefault(PollMaxIntervalKey, "300")
+	preferences.SetDefault(MeteredConnectionKey, "false")
+	preferences.SetDefault(NetworkProfileKey, NetworkProfileNormal)
+	preferences.SetDefault(ProxyKey, sysproxy.ModeAuto)
+	preferences.SetDefault(LogLevelKey, "")
+	preferences.SetDefault(LogFormatKey, "")
+	preferences.SetDefault(LogMaxFileSizeKey, "10")
+	preferences.SetDefault(LogMaxFilesKey, "3")
+	preferences.SetDefault(LogMaxTotalSizeKey, "0")
+	preferences.SetDefault(LogMaxAgeKey, "0")
+	for _, subsystem := range config.GetLogSubsystems() {
+		preferences.SetDefault(LogLevelSubsystemKey(subsystem), "")
+	}
+	preferences.SetDefault(KeychainBackendKey, "")
+	preferences.SetDefault(WebhooksKey, "[]")
+	preferences.SetDefault(UpdateChannelKey, "stable")
+	preferences.SetDefault(CrashReportsKey, "full")
+	preferences.SetDefault(LastCrashCheckKey, strconv.FormatInt(time.Now().Unix(), 10))
+	for _, group := range metrics.GetGroups() {
+		preferences.SetDefault(TelemetryGroupKey(group), "false")
+	}
 
 	// By default, stick to STARTTLS. If the user uses catalina+applemail they'll have to change to SSL.
 	preferences.SetDefault(SMTPSSLKey, "false")
 }
+
+// GetTelemetryGroups returns groups of anonymous metrics user opted in to.
+func GetTelemetryGroups(pref *config.Preferences) (groups []metrics.Group) {
+	for _, group := range metrics.GetGroups() {
+		if pref.GetBool(TelemetryGroupKey(group)) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// GetLogRetention returns limits of log files from preferences.
+func GetLogRetention(pref *config.Preferences) config.LogRetention {
+	return config.LogRetention{
+		MaxFileSize:  int64(pref.GetInt(LogMaxFileSizeKey)) * 1024 * 1024,
+		MaxFiles:     pref.GetInt(LogMaxFilesKey),
+		MaxTotalSize: int64(pref.GetInt(LogMaxTotalSizeKey)) * 1024 * 1024,
+		MaxAge:       time.Duration(pref.GetInt(LogMaxAgeKey)) * 24 * time.Hour,
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sendmail submits messages read by sendmail-compatible command to
+// SMTP server of running Bridge, so they go through the same send pipeline
+// as messages of email clients.
+package sendmail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/pkg/errors"
+)
+
+// Options of one submission.
+type Options struct {
+	Listener bridge.ListenerConfig // SMTP listener of Bridge.
+	UseSSL   bool                  // Listener uses implicit TLS instead of STARTTLS.
+
+	Username, Password string
+
+	From string   // Envelope sender; From header is used when empty.
+	To   []string // Envelope recipients.
+
+	// ExtractRecipients adds recipients of To, Cc and Bcc headers (-t).
+	// Bcc header is removed from the message then.
+	ExtractRecipients bool
+}
+
+// Submit sends the message through Bridge SMTP server.
+func Submit(opts Options, literal []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(literal))
+	if err != nil {
+		return errors.Wrap(err, "cannot parse message")
+	}
+
+	from := opts.From
+	if from == "" {
+		if from, err = headerSender(msg.Header); err != nil {
+			return err
+		}
+	}
+
+	to := opts.To
+	if opts.ExtractRecipients {
+		recipients, err := headerRecipients(msg.Header)
+		if err != nil {
+			return err
+		}
+		to = append(to, recipients...)
+		literal = removeBcc(literal)
+	}
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+
+	client, host, err := dial(opts.Listener, opts.UseSSL)
+	if err != nil {
+		return errors.Wrap(err, "cannot connect to Bridge SMTP server")
+	}
+	defer client.Close() //nolint[errcheck]
+
+	if err := client.Auth(smtp.PlainAuth("", opts.Username, opts.Password, host)); err != nil {
+		return errors.Wrap(err, "cannot authenticate")
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return errors.Wrapf(err, "recipient %v refused", recipient)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(literal); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// HeaderSender returns the address of From header.
+func HeaderSender(literal []byte) (string, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(literal))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot parse message")
+	}
+	return headerSender(msg.Header)
+}
+
+func headerSender(header mail.Header) (string, error) {
+	addresses, err := header.AddressList("From")
+	if err != nil || len(addresses) == 0 {
+		return "", errors.New("no sender, use -f or From header")
+	}
+	return addresses[0].Address, nil
+}
+
+func headerRecipients(header mail.Header) (recipients []string, err error) {
+	for _, key := range []string{"To", "Cc", "Bcc"} {
+		addresses, err := header.AddressList(key)
+		if err == mail.ErrHeaderNotPresent {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse %v header", key)
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	return recipients, nil
+}
+
+// removeBcc returns the message without Bcc header, including its folded
+// lines, so the recipients are not disclosed. The rest is kept as it is.
+func removeBcc(literal []byte) []byte {
+	out := make([]byte, 0, len(literal))
+	rest := literal
+	skipping := false
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+
+		// Body starts after the first empty line.
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			out = append(out, line...)
+			return append(out, rest...)
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			colon := bytes.IndexByte(line, ':')
+			skipping = colon > 0 && strings.EqualFold(string(bytes.TrimSpace(line[:colon])), "Bcc")
+		}
+		if !skipping {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// dial connects to the listener from the same machine. Unspecified bind
+// address (e.g. 0.0.0.0) is reached through loopback.
+func dial(listenerCfg bridge.ListenerConfig, useSSL bool) (client *smtp.Client, host string, err error) {
+	network, host := "tcp", listenerCfg.Host
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(listenerCfg.Port))
+	if listenerCfg.SocketPath != "" {
+		network, host, address = "unix", "localhost", listenerCfg.SocketPath
+	}
+
+	// The connection does not leave the machine and Bridge uses self-signed
+	// certificate unless the user supplied one.
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} //nolint[gosec]
+
+	var conn net.Conn
+	if useSSL {
+		conn, err = tls.Dial(network, address, tlsConfig)
+	} else {
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if client, err = smtp.NewClient(conn, host); err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !useSSL {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, "", err
+		}
+	}
+
+	return client, host, nil
+}
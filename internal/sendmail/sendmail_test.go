@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sendmail
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMessage = "From: Me <me@pm.me>\r\nTo: a@pm.me, B <b@pm.me>\r\nBcc: c@pm.me\r\nSubject: cron\r\n\r\nhello\r\n"
+
+type testBackend struct {
+	from string
+	to   []string
+	body string
+}
+
+func (tb *testBackend) Login(username, password string) (smtpserver.User, error) {
+	if username != "me@pm.me" || password != "secret" {
+		return nil, errors.New("invalid credentials")
+	}
+	return tb, nil
+}
+
+func (tb *testBackend) Send(envelope *smtpserver.Envelope, r io.Reader) error {
+	body, err := ioutil.ReadAll(r)
+	tb.from, tb.to, tb.body = envelope.From, envelope.Addresses(), string(body)
+	return err
+}
+
+func (tb *testBackend) Logout() error {
+	return nil
+}
+
+func startTestServer(t *testing.T) (*testBackend, bridge.ListenerConfig, func()) {
+	backend := &testBackend{}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := smtpserver.NewServer(backend)
+	s.AllowInsecureAuth = true
+	go s.Serve(l) //nolint[errcheck]
+
+	listenerCfg := bridge.ListenerConfig{Host: "0.0.0.0", Port: l.Addr().(*net.TCPAddr).Port}
+	return backend, listenerCfg, s.Close
+}
+
+func TestSubmitExtractRecipients(t *testing.T) {
+	backend, listenerCfg, stop := startTestServer(t)
+	defer stop()
+
+	err := Submit(Options{
+		Listener:          listenerCfg,
+		Username:          "me@pm.me",
+		Password:          "secret",
+		To:                []string{"d@pm.me"},
+		ExtractRecipients: true,
+	}, []byte(testMessage))
+	require.NoError(t, err)
+
+	assert.Equal(t, "me@pm.me", backend.from)
+	assert.Equal(t, []string{"d@pm.me", "a@pm.me", "b@pm.me", "c@pm.me"}, backend.to)
+	assert.Equal(t, strings.ReplaceAll(strings.Replace(testMessage, "Bcc: c@pm.me\r\n", "", 1), "\r\n", "\n"), backend.body)
+}
+
+func TestRemoveBcc(t *testing.T) {
+	tests := []struct{ literal, want string }{
+		{testMessage, "From: Me <me@pm.me>\r\nTo: a@pm.me, B <b@pm.me>\r\nSubject: cron\r\n\r\nhello\r\n"},
+		{"BCC: a@pm.me,\r\n b@pm.me\r\nTo: c@pm.me\r\n\r\nBcc: body\r\n", "To: c@pm.me\r\n\r\nBcc: body\r\n"},
+		{"To: a@pm.me\nBcc: b@pm.me\n\nhello\n", "To: a@pm.me\n\nhello\n"},
+		{"To: a@pm.me\r\nX-Bcc: kept\r\n\r\n", "To: a@pm.me\r\nX-Bcc: kept\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, string(removeBcc([]byte(test.literal))))
+	}
+}
+
+func TestSubmitFailures(t *testing.T) {
+	_, listenerCfg, stop := startTestServer(t)
+	defer stop()
+
+	opts := Options{Listener: listenerCfg, Username: "me@pm.me", Password: "wrong", To: []string{"a@pm.me"}}
+	assert.Error(t, Submit(opts, []byte(testMessage)))
+
+	opts.Password = "secret"
+	opts.To = nil
+	assert.EqualError(t, Submit(opts, []byte(testMessage)), "no recipients")
+
+	opts.To = []string{"a@pm.me"}
+	assert.EqualError(t, Submit(opts, []byte("Subject: no sender\r\n\r\nhello\r\n")), "no sender, use -f or From header")
+}
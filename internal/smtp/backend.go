@@ -19,6 +19,7 @@ package smtp
 
 import (
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
@@ -26,7 +27,7 @@ import (
 	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/confirmer"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
-	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,16 +42,32 @@ type smtpBackend struct {
 	bridge        bridger
 	confirmer     *confirmer.Confirmer
 	sendRecorder  *sendRecorder
+	limiter       *sendLimiter
+	queue         *sendQueue
+	sending       int32 // Number of messages from clients being sent.
+
+	stopQueue    chan struct{}
+	queueStopped chan struct{}
 }
 
-// NewSMTPBackend returns struct implementing go-smtp/backend interface.
+// drainPollInterval is how often draining checks whether sending is done.
+const drainPollInterval = 100 * time.Millisecond
+
+// NewSMTPBackend returns struct implementing smtpserver.Backend interface.
 func NewSMTPBackend(
 	panicHandler panicHandler,
 	eventListener listener.Listener,
 	preferences *config.Preferences,
 	bridge *bridge.Bridge,
+	queueDir string,
 ) *smtpBackend { //nolint[golint]
-	return newSMTPBackend(panicHandler, eventListener, preferences, newBridgeWrap(bridge))
+	sb := newSMTPBackend(panicHandler, eventListener, preferences, newBridgeWrap(bridge))
+	sb.queue = newSendQueue(queueDir)
+	sb.stopQueue = make(chan struct{})
+	sb.queueStopped = make(chan struct{})
+	go sb.retryQueuedMessages()
+	go sb.watchReadReceiptRequests()
+	return sb
 }
 
 func newSMTPBackend(
@@ -66,13 +83,51 @@ func newSMTPBackend(
 		bridge:        bridge,
 		confirmer:     confirmer.New(),
 		sendRecorder:  newSendRecorder(),
+		limiter:       newSendLimiter(),
 	}
 }
 
 // Login authenticates a user.
-func (sb *smtpBackend) Login(username, password string) (goSMTPBackend.User, error) {
-	// Called from go-smtp in goroutines - we need to handle panics for each function.
+func (sb *smtpBackend) Login(username, password string) (smtpserver.User, error) {
+	// Called from smtpserver in goroutines - we need to handle panics for each function.
+	defer sb.panicHandler.HandlePanic()
+
+	return sb.login(username, func(user bridgeUser) error {
+		return user.CheckBridgeLogin(password)
+	})
+}
+
+// LoginWithToken authenticates a user by the bearer token of OAuth mechanisms.
+// The user is found by the token when OAUTHBEARER client did not send the
+// username.
+func (sb *smtpBackend) LoginWithToken(username, token string) (smtpserver.User, error) {
 	defer sb.panicHandler.HandlePanic()
+
+	if username == "" {
+		address, err := sb.getAddressByToken(token)
+		if err != nil {
+			log.WithError(err).Warn("Cannot get user by token")
+			return nil, err
+		}
+		username = address
+	}
+
+	return sb.login(username, func(user bridgeUser) error {
+		return user.CheckBridgeToken(token)
+	})
+}
+
+// getAddressByToken returns the primary address of the user with the bearer
+// token.
+func (sb *smtpBackend) getAddressByToken(token string) (string, error) {
+	user, err := sb.bridge.GetUserByBearerToken(token)
+	if err != nil {
+		return "", err
+	}
+	return user.GetPrimaryAddress(), nil
+}
+
+func (sb *smtpBackend) login(username string, check func(bridgeUser) error) (smtpserver.User, error) {
 	username = strings.ToLower(username)
 
 	user, err := sb.bridge.GetUser(username)
@@ -80,7 +135,7 @@ func (sb *smtpBackend) Login(username, password string) (goSMTPBackend.User, err
 		log.Warn("Cannot get user: ", err)
 		return nil, err
 	}
-	if err := user.CheckBridgeLogin(password); err != nil {
+	if err := check(user); err != nil {
 		log.WithError(err).Error("Could not check bridge password")
 		// Apple Mail sometimes generates a lot of requests very quickly. It's good practice
 		// to have a timeout after bad logins so that we can slow those requests down a little bit.
@@ -100,6 +155,56 @@ func (sb *smtpBackend) Login(username, password string) (goSMTPBackend.User, err
 	return newSMTPUser(sb.panicHandler, sb.eventListener, sb, user, addressID)
 }
 
+// Drain stops retrying queued messages and waits for the retry in progress
+// until the deadline. Queued messages which are due are then tried once more
+// so they are not left for the next start; the rest stays in the queue.
+func (sb *smtpBackend) Drain(deadline time.Time) {
+	if sb.stopQueue == nil {
+		return
+	}
+	close(sb.stopQueue)
+
+	select {
+	case <-sb.queueStopped:
+	case <-time.After(time.Until(deadline)):
+		log.Warn("Retry of queued message did not finish before shutdown")
+		return
+	}
+
+	for _, m := range sb.queue.due(time.Now()) {
+		if time.Now().After(deadline) {
+			log.Warn("Not all due queued messages were sent before shutdown")
+			return
+		}
+		sb.retryQueuedMessage(m)
+	}
+}
+
+// waitForSending waits until messages from clients being sent are done or
+// the deadline passes. It returns whether all were done.
+func (sb *smtpBackend) waitForSending(deadline time.Time) bool {
+	for atomic.LoadInt32(&sb.sending) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// isAccountAddress returns whether address belongs to the account.
+func (sb *smtpBackend) isAccountAddress(account, address string) bool {
+	accountUser, err := sb.bridge.GetUser(account)
+	if err != nil {
+		return false
+	}
+	user, err := sb.bridge.GetUser(strings.ToLower(address))
+	if err != nil {
+		return false
+	}
+	return user.ID() == accountUser.ID()
+}
+
 func (sb *smtpBackend) shouldReportOutgoingNoEnc() bool {
 	return sb.preferences.GetBool(preferences.ReportOutgoingNoEncKey)
 }
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+// Values of preferences.SMTPBccPolicyKey.
+const (
+	BccKeep  = "keep"  // Bcc recipients are kept in own Sent copy only.
+	BccStrip = "strip" // Bcc recipients are not stored even in own Sent copy.
+)
+
+// autoBccSelf in preferences.SMTPAutoBccKey stands for the sender address.
+const autoBccSelf = "self"
+
+func (sb *smtpBackend) bccPolicy() string {
+	if policy := sb.preferences.Get(preferences.SMTPBccPolicyKey); policy == BccStrip {
+		return policy
+	}
+	return BccKeep
+}
+
+// autoBcc returns addresses from preferences.SMTPAutoBccKey (separated by
+// comma) which are added as Bcc to every message sent from `sender`.
+func (sb *smtpBackend) autoBcc(sender string) (addresses []string) {
+	for _, address := range strings.Split(sb.preferences.Get(preferences.SMTPAutoBccKey), ",") {
+		switch address = strings.TrimSpace(address); {
+		case address == "":
+		case strings.EqualFold(address, autoBccSelf):
+			addresses = append(addresses, pmapi.NormalizeEmail(sender))
+		case looksLikeEmail(address):
+			addresses = append(addresses, pmapi.NormalizeEmail(address))
+		default:
+			log.WithField("address", address).Warn("Ignoring invalid auto Bcc address")
+		}
+	}
+	return addresses
+}
+
+// addRecipients appends addresses to the envelope recipients unless they are
+// there already.
+func addRecipients(to []string, addresses []string) []string {
+	for _, address := range addresses {
+		found := false
+		for _, recipient := range to {
+			if recipient == address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			to = append(to, address)
+		}
+	}
+	return to
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoBcc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-prefs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{preferences: config.NewPreferences(filepath.Join(dir, "prefs.json"))}
+
+	assert.Empty(t, sb.autoBcc("me@pm.me"))
+	assert.Equal(t, BccKeep, sb.bccPolicy())
+
+	sb.preferences.Set(preferences.SMTPAutoBccKey, "SELF, archive@example.com, invalid,")
+	sb.preferences.Set(preferences.SMTPBccPolicyKey, BccStrip)
+
+	assert.Equal(t, []string{"me@pm.me", "archive@example.com"}, sb.autoBcc("me@pm.me"))
+	assert.Equal(t, BccStrip, sb.bccPolicy())
+}
+
+func TestAddRecipients(t *testing.T) {
+	to := addRecipients([]string{"a@pm.me", "b@pm.me"}, []string{"b@pm.me", "c@pm.me"})
+	assert.Equal(t, []string{"a@pm.me", "b@pm.me", "c@pm.me"}, to)
+}
@@ -25,10 +25,14 @@ import (
 
 type bridger interface {
 	GetUser(query string) (bridgeUser, error)
+	GetUserByBearerToken(token string) (bridgeUser, error)
 }
 
 type bridgeUser interface {
+	ID() string
 	CheckBridgeLogin(password string) error
+	CheckBridgeToken(token string) error
+	GetPrimaryAddress() string
 	IsCombinedAddressMode() bool
 	GetAddressID(address string) (string, error)
 	GetTemporaryPMAPIClient() pmapi.Client
@@ -54,6 +58,14 @@ func (b *bridgeWrap) GetUser(query string) (bridgeUser, error) {
 	return newBridgeUserWrap(user), nil
 }
 
+func (b *bridgeWrap) GetUserByBearerToken(token string) (bridgeUser, error) {
+	user, err := b.Bridge.GetUserByBearerToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return newBridgeUserWrap(user), nil
+}
+
 type bridgeUserWrap struct {
 	*users.User
 }
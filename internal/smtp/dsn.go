@@ -0,0 +1,183 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/internal/bridge"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/pkg/errors"
+)
+
+// dsnRecipient is an envelope recipient with its delivery status notification
+// parameters (RFC3461). API does not support DSN; the only thing which can be
+// done is reporting failures of sending by Bridge itself.
+type dsnRecipient struct {
+	address  string
+	notify   []string
+	original string
+}
+
+func newDSNRecipient(r smtpserver.Recipient) dsnRecipient {
+	return dsnRecipient{address: r.Address, notify: r.Notify, original: r.OriginalRecipient}
+}
+
+// wantsFailureReport returns whether the client explicitly asked for a report
+// if the message is not delivered. Without NOTIFY, the SMTP error is enough.
+func (r dsnRecipient) wantsFailureReport() bool {
+	for _, n := range r.notify {
+		if n == "FAILURE" {
+			return true
+		}
+	}
+	return false
+}
+
+// refusesReport returns whether the client asked for no report by NOTIFY=NEVER.
+func (r dsnRecipient) refusesReport() bool {
+	return len(r.notify) == 1 && r.notify[0] == "NEVER"
+}
+
+// reportFailure imports a non-delivery report (RFC3464) into inbox for the
+// recipients which asked for it by NOTIFY=FAILURE, or for all recipients
+// except NOTIFY=NEVER if the message was already accepted and the client does
+// not know about failure.
+func (su *smtpUser) reportFailure(addr *pmapi.Address, kr *crypto.KeyRing, envelope *smtpserver.Envelope, header mail.Header, literal []byte, recipients []dsnRecipient, sendErr error, all bool) {
+	failed := []dsnRecipient{}
+	for _, r := range recipients {
+		if r.wantsFailureReport() || (all && !r.refusesReport()) {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	report, err := buildFailureReport(addr.Email, envelope, header, literal, failed, sendErr)
+	if err != nil {
+		log.WithError(err).Error("Failed to build non-delivery report")
+		return
+	}
+
+	m, _, _, readers, err := message.Parse(bytes.NewReader(report), "", "")
+	if err != nil {
+		log.WithError(err).Error("Failed to parse non-delivery report")
+		return
+	}
+	m.AddressID = addr.ID
+
+	body := new(bytes.Buffer)
+	if err := message.WriteEncrypted(body, m, readers, kr); err != nil {
+		log.WithError(err).Error("Failed to encrypt non-delivery report")
+		return
+	}
+
+	if _, err := su.client().Import([]*pmapi.ImportMsgReq{{
+		AddressID:  addr.ID,
+		BodyReader: body,
+		Unread:     1,
+		Flags:      pmapi.FlagReceived,
+		Time:       time.Now().Unix(),
+		LabelIDs:   []string{pmapi.InboxLabel},
+	}}); err != nil {
+		log.WithError(err).Error("Failed to import non-delivery report")
+	}
+}
+
+// buildFailureReport returns the report with the full message if the client
+// asked for it by RET=FULL, otherwise only with its header.
+func buildFailureReport(to string, envelope *smtpserver.Envelope, header mail.Header, literal []byte, recipients []dsnRecipient, sendErr error) ([]byte, error) { //nolint[funlen]
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: Mail Delivery System <MAILER-DAEMON@%s>\r\n", bridge.Host)
+	fmt.Fprintf(buf, "To: <%s>\r\n", to)
+	buf.WriteString("Subject: Undelivered Mail Returned to Sender\r\n")
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/report; report-type=delivery-status; boundary=%s\r\n\r\n", w.Boundary())
+
+	addresses := []string{}
+	for _, r := range recipients {
+		addresses = append(addresses, r.address)
+	}
+
+	text, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(text, "Your message could not be sent to %s.\r\n\r\n%s\r\n", strings.Join(addresses, ", "), sendErr)
+
+	status, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(status, "Reporting-MTA: dns; %s\r\n", bridge.Host)
+	if envelope.EnvelopeID != "" {
+		fmt.Fprintf(status, "Original-Envelope-Id: %s\r\n", envelope.EnvelopeID)
+	}
+	for _, r := range recipients {
+		fmt.Fprintf(status, "\r\nFinal-Recipient: rfc822; %s\r\n", r.address)
+		if r.original != "" {
+			fmt.Fprintf(status, "Original-Recipient: %s\r\n", r.original)
+		}
+		fmt.Fprint(status, "Action: failed\r\nStatus: 5.0.0\r\n")
+		fmt.Fprintf(status, "Diagnostic-Code: smtp; %s\r\n", strings.ReplaceAll(sendErr.Error(), "\n", " "))
+	}
+
+	if envelope.Return == "FULL" && literal != nil {
+		original, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/rfc822"}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := original.Write(literal); err != nil {
+			return nil, err
+		}
+	} else if header != nil {
+		headers, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/rfc822-headers"}})
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(header))
+		for key := range header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, value := range header[key] {
+				fmt.Fprintf(headers, "%s: %s\r\n", key, value)
+			}
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close report")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSNRecipient(t *testing.T) {
+	r := newDSNRecipient(smtpserver.Recipient{Address: "a@b.c", Notify: []string{"DELAY", "FAILURE"}, OriginalRecipient: "rfc822;A@b.c"})
+	assert.Equal(t, dsnRecipient{address: "a@b.c", notify: []string{"DELAY", "FAILURE"}, original: "rfc822;A@b.c"}, r)
+	assert.True(t, r.wantsFailureReport())
+	assert.False(t, r.refusesReport())
+
+	r = newDSNRecipient(smtpserver.Recipient{Address: "a@b.c", Notify: []string{"NEVER"}})
+	assert.False(t, r.wantsFailureReport())
+	assert.True(t, r.refusesReport())
+
+	r = newDSNRecipient(smtpserver.Recipient{Address: "a@b.c"})
+	assert.False(t, r.wantsFailureReport())
+	assert.False(t, r.refusesReport())
+}
+
+func TestBuildFailureReport(t *testing.T) {
+	header := mail.Header{"Subject": {"Hello"}}
+	recipients := []dsnRecipient{{address: "user@example.com", original: "rfc822;user@example.com"}}
+
+	report, err := buildFailureReport("me@pm.me", &smtpserver.Envelope{}, header, []byte("Subject: Hello\r\n\r\nHi\r\n"), recipients, errors.New("no keys"))
+	require.NoError(t, err)
+
+	m, _, plainBody, readers, err := message.Parse(bytes.NewReader(report), "", "")
+	require.NoError(t, err)
+
+	assert.Equal(t, "me@pm.me", m.ToList[0].Address)
+	assert.Contains(t, plainBody, "user@example.com")
+	assert.Contains(t, plainBody, "no keys")
+	assert.Len(t, readers, 1) // message/delivery-status
+	assert.Contains(t, string(report), "Original-Recipient: rfc822;user@example.com\r\n")
+	assert.Contains(t, string(report), "Subject: Hello\r\n")
+}
+
+func TestBuildFailureReportFull(t *testing.T) {
+	header := mail.Header{"Subject": {"Hello"}}
+	recipients := []dsnRecipient{{address: "user@example.com"}}
+	envelope := &smtpserver.Envelope{Return: "FULL", EnvelopeID: "QQ314159"}
+
+	report, err := buildFailureReport("me@pm.me", envelope, header, []byte("Subject: Hello\r\n\r\nHi there\r\n"), recipients, errors.New("no keys"))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(report), "Original-Envelope-Id: QQ314159\r\n")
+	assert.Contains(t, string(report), "Content-Type: message/rfc822\r\n")
+	assert.Contains(t, string(report), "Hi there")
+	assert.NotContains(t, string(report), "text/rfc822-headers")
+}
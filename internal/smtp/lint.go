@@ -0,0 +1,179 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHeaderLineLength is the limit of a line without CRLF by RFC5322.
+const maxHeaderLineLength = 998
+
+// lintMessage checks the submitted message before it is parsed and built into
+// API packages, so the client gets a precise description of what is wrong
+// instead of a failure deep inside the building. Missing Date and Message-ID
+// are added and overlong header lines are folded. Broken multipart is
+// refused, because it usually means the message is truncated.
+func lintMessage(literal []byte, from string, now time.Time) ([]byte, error) {
+	newline := []byte("\r\n")
+	if i := bytes.IndexByte(literal, '\n'); i >= 0 && (i == 0 || literal[i-1] != '\r') {
+		newline = []byte("\n")
+	}
+
+	rawHeader, body := splitLiteral(literal, newline)
+
+	lines, err := foldHeaderLines(bytes.Split(rawHeader, newline))
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(append(bytes.Join(lines, []byte("\r\n")), "\r\n\r\n"...)))
+	if err != nil {
+		return nil, newSendError("5.6.0", "Message header is malformed", err)
+	}
+
+	if msg.Header.Get("Date") == "" {
+		lines = append([][]byte{[]byte("Date: " + now.Format(time.RFC1123Z))}, lines...)
+	}
+	if msg.Header.Get("Message-Id") == "" {
+		lines = append([][]byte{[]byte("Message-ID: " + newMessageID(msg.Header, from))}, lines...)
+	}
+
+	if err := lintPart(textproto.MIMEHeader(msg.Header), bytes.NewReader(body), "Message"); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	for _, line := range lines {
+		b.Write(line)
+		b.Write(newline)
+	}
+	b.Write(newline)
+	b.Write(body)
+	return b.Bytes(), nil
+}
+
+// splitLiteral returns header without the empty line and body.
+func splitLiteral(literal, newline []byte) (header, body []byte) {
+	if bytes.HasPrefix(literal, newline) {
+		return nil, literal[len(newline):]
+	}
+	separator := append(append([]byte{}, newline...), newline...)
+	if i := bytes.Index(literal, separator); i >= 0 {
+		return literal[:i], literal[i+len(separator):]
+	}
+	return bytes.TrimSuffix(literal, newline), nil
+}
+
+// foldHeaderLines folds header lines longer than maxHeaderLineLength at
+// whitespace. Lines without whitespace to fold at are refused.
+func foldHeaderLines(lines [][]byte) ([][]byte, error) {
+	var folded [][]byte
+	var name string
+	for _, line := range lines {
+		if colon := bytes.IndexByte(line, ':'); colon > 0 && line[0] != ' ' && line[0] != '\t' {
+			name = string(line[:colon])
+		}
+		for len(line) > maxHeaderLineLength {
+			i := bytes.LastIndexAny(line[:maxHeaderLineLength], " \t")
+			if i <= 0 {
+				return nil, newSendError("5.6.0", fmt.Sprintf("Header line %q is longer than %d characters and cannot be folded", name, maxHeaderLineLength), nil)
+			}
+			folded = append(folded, line[:i])
+			line = line[i:]
+		}
+		folded = append(folded, line)
+	}
+	return folded, nil
+}
+
+// newMessageID generates Message-ID with domain of the sender.
+func newMessageID(header mail.Header, from string) string {
+	if address, err := mail.ParseAddress(header.Get("From")); err == nil {
+		from = address.Address
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
+
+// lintPart checks boundaries of multipart `body` and its nested multiparts.
+// The section is described similarly to IMAP, e.g. "Message part 1.2".
+func lintPart(header textproto.MIMEHeader, body io.Reader, section string) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return newSendError("5.6.0", fmt.Sprintf("%s (%s) has no boundary", section, mediaType), nil)
+	}
+
+	r := multipart.NewReader(body, boundary)
+	for i := 1; ; i++ {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if i == 1 {
+				return newSendError("5.6.0", fmt.Sprintf("%s (%s) has no part delimited by boundary %s", section, mediaType, boundary), nil)
+			}
+			return newSendError("5.6.0", fmt.Sprintf("%s (%s) is broken", section, mediaType), err)
+		}
+
+		partSection := section + " part " + strconv.Itoa(i)
+		if section != "Message" {
+			partSection = section + "." + strconv.Itoa(i)
+		}
+
+		if err := lintPart(part.Header, part, partSection); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(ioutil.Discard, part); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return newSendError("5.6.0", fmt.Sprintf("%s (%s) is not closed by final boundary %s", section, mediaType, boundary), nil)
+			}
+			return newSendError("5.6.0", fmt.Sprintf("%s (%s) is broken", section, mediaType), err)
+		}
+	}
+}
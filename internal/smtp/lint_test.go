@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintMessageAddsDateAndMessageID(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	literal := "From: Sender <sender@example.com>\r\nSubject: Hello\r\n\r\nBody\r\n"
+
+	linted, err := lintMessage([]byte(literal), "envelope@pm.me", now)
+	require.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(linted))
+	require.NoError(t, err)
+	assert.Equal(t, now.Format(time.RFC1123Z), msg.Header.Get("Date"))
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-Id"), "@example.com>"))
+	assert.Equal(t, "Hello", msg.Header.Get("Subject"))
+	assert.True(t, bytes.HasSuffix(linted, []byte("\r\n\r\nBody\r\n")))
+}
+
+func TestLintMessageKeepsValidMessage(t *testing.T) {
+	literal := "Date: Thu, 02 Jan 2020 03:04:05 +0000\nMessage-Id: <id@example.com>\nSubject: Hello\n\nBody\n"
+
+	linted, err := lintMessage([]byte(literal), "sender@pm.me", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, literal, string(linted))
+}
+
+func TestLintMessageFoldsLongHeader(t *testing.T) {
+	subject := strings.Repeat("word ", 300)
+	literal := "Date: Thu, 02 Jan 2020 03:04:05 +0000\r\nMessage-Id: <id@example.com>\r\nSubject: " + subject + "\r\n\r\nBody\r\n"
+
+	linted, err := lintMessage([]byte(literal), "sender@pm.me", time.Now())
+	require.NoError(t, err)
+
+	for _, line := range strings.Split(string(linted), "\r\n") {
+		assert.True(t, len(line) <= maxHeaderLineLength)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(linted))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(strings.Fields(subject), " "), strings.Join(strings.Fields(msg.Header.Get("Subject")), " "))
+}
+
+func TestLintMessageRefusesUnfoldableHeader(t *testing.T) {
+	literal := "X-Long: " + strings.Repeat("a", 1000) + "\r\n\r\nBody\r\n"
+
+	_, err := lintMessage([]byte(literal), "sender@pm.me", time.Now())
+	require.Error(t, err)
+	assert.Equal(t, `5.6.0 Header line "X-Long" is longer than 998 characters and cannot be folded`, err.Error())
+}
+
+func TestLintMessageMultipart(t *testing.T) {
+	header := "Date: Thu, 02 Jan 2020 03:04:05 +0000\r\nMessage-Id: <id@example.com>\r\n"
+
+	tests := []struct {
+		contentType, body, wantErr string
+	}{
+		{
+			"multipart/mixed; boundary=b",
+			"--b\r\nContent-Type: text/plain\r\n\r\nText\r\n--b--\r\n",
+			"",
+		},
+		{
+			"multipart/mixed",
+			"--b\r\nContent-Type: text/plain\r\n\r\nText\r\n--b--\r\n",
+			"5.6.0 Message (multipart/mixed) has no boundary",
+		},
+		{
+			"multipart/mixed; boundary=b",
+			"Text without any part\r\n",
+			"5.6.0 Message (multipart/mixed) has no part delimited by boundary b",
+		},
+		{
+			"multipart/mixed; boundary=b",
+			"--b\r\nContent-Type: text/plain\r\n\r\nText\r\n",
+			"5.6.0 Message (multipart/mixed) is not closed by final boundary b",
+		},
+		{
+			"multipart/mixed; boundary=b",
+			"--b\r\nContent-Type: multipart/alternative; boundary=c\r\n\r\n--c\r\nContent-Type: text/plain\r\n\r\nText\r\n--b--\r\n",
+			"5.6.0 Message part 1 (multipart/alternative) is not closed by final boundary c",
+		},
+	}
+
+	for _, tc := range tests {
+		literal := header + "Content-Type: " + tc.contentType + "\r\n\r\n" + tc.body
+
+		_, err := lintMessage([]byte(literal), "sender@pm.me", time.Now())
+		if tc.wantErr == "" {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.Equal(t, tc.wantErr, err.Error())
+		}
+	}
+}
+
+func TestLintMessageRefusesMalformedHeader(t *testing.T) {
+	_, err := lintMessage([]byte("Not a header line\r\n\r\nBody\r\n"), "sender@pm.me", time.Now())
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "5.6.0 Message header is malformed"))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+// Values of preferences.SMTPNoKeyPolicyKey deciding what happens when
+// encryption is requested for an external recipient without any known key.
+const (
+	MissingKeyFail      = "fail"      // The whole message is not sent.
+	MissingKeyPlaintext = "plaintext" // Only those recipients get cleartext.
+	MissingKeyAsk       = "ask"       // User is asked in GUI whether to send cleartext.
+)
+
+func (sb *smtpBackend) missingKeyPolicy() string {
+	switch policy := sb.preferences.Get(preferences.SMTPNoKeyPolicyKey); policy {
+	case MissingKeyPlaintext, MissingKeyAsk:
+		return policy
+	default:
+		return MissingKeyFail
+	}
+}
+
+// isMissingKey returns whether the message should be encrypted for external
+// recipient but there is no key to do so.
+func isMissingKey(sendPreferences SendPreferences) bool {
+	return sendPreferences.Encrypt &&
+		sendPreferences.PublicKey == nil &&
+		sendPreferences.Scheme != pmapi.InternalPackage
+}
+
+// withoutEncryption turns preferences of recipient with missing key into
+// preferences for cleartext package in the format of composed message.
+func withoutEncryption(sendPreferences SendPreferences, messageMIMEType string) SendPreferences {
+	sendPreferences.Encrypt = false
+	sendPreferences.Sign = false
+	sendPreferences.Scheme = pmapi.ClearPackage
+	if sendPreferences.MIMEType == pmapi.ContentTypeMultipartMixed || sendPreferences.MIMEType == "" {
+		sendPreferences.MIMEType = messageMIMEType
+	}
+	return sendPreferences
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingKeyPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-prefs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{preferences: config.NewPreferences(filepath.Join(dir, "prefs.json"))}
+	assert.Equal(t, MissingKeyFail, sb.missingKeyPolicy())
+
+	sb.preferences.Set(preferences.SMTPNoKeyPolicyKey, MissingKeyAsk)
+	assert.Equal(t, MissingKeyAsk, sb.missingKeyPolicy())
+
+	sb.preferences.Set(preferences.SMTPNoKeyPolicyKey, "unknown")
+	assert.Equal(t, MissingKeyFail, sb.missingKeyPolicy())
+}
+
+func TestIsMissingKey(t *testing.T) {
+	assert.True(t, isMissingKey(SendPreferences{Encrypt: true, Scheme: pmapi.PGPMIMEPackage}))
+	assert.False(t, isMissingKey(SendPreferences{Encrypt: true, Scheme: pmapi.InternalPackage}))
+	assert.False(t, isMissingKey(SendPreferences{Scheme: pmapi.ClearPackage}))
+}
+
+func TestWithoutEncryption(t *testing.T) {
+	sendPreferences := withoutEncryption(SendPreferences{
+		Encrypt:  true,
+		Sign:     true,
+		Scheme:   pmapi.PGPMIMEPackage,
+		MIMEType: pmapi.ContentTypeMultipartMixed,
+	}, pmapi.ContentTypeHTML)
+
+	assert.Equal(t, SendPreferences{
+		Scheme:   pmapi.ClearPackage,
+		MIMEType: pmapi.ContentTypeHTML,
+	}, sendPreferences)
+
+	sendPreferences = withoutEncryption(SendPreferences{
+		Encrypt:  true,
+		Scheme:   pmapi.PGPInlinePackage,
+		MIMEType: pmapi.ContentTypePlainText,
+	}, pmapi.ContentTypeHTML)
+
+	assert.Equal(t, pmapi.ContentTypePlainText, sendPreferences.MIMEType)
+}
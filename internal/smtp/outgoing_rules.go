@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"encoding/json"
+	"net/textproto"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+)
+
+// outgoingRule is one rule of preferences.SMTPOutgoingRulesKey (JSON list).
+// The rule applies to messages sent from address matching From to at least one
+// recipient matching To. Both are shell patterns (e.g. `*@example.com`) and
+// empty pattern matches any address.
+type outgoingRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	Footer        string            `json:"footer"`
+	Bcc           []string          `json:"bcc"`
+	SetHeaders    map[string]string `json:"set_headers"`
+	RemoveHeaders []string          `json:"remove_headers"`
+}
+
+type outgoingRules []outgoingRule
+
+// protectedHeaders are used by Bridge or API to build the message and cannot be
+// changed by outgoing rules.
+var protectedHeaders = []string{ //nolint[gochecknoglobals]
+	"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-Id",
+	"References", "In-Reply-To", "Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+func (sb *smtpBackend) outgoingRules() (rules outgoingRules) {
+	value := sb.preferences.Get(preferences.SMTPOutgoingRulesKey)
+	if value == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		log.WithError(err).Warn("Cannot parse outgoing rules")
+		return nil
+	}
+	return rules
+}
+
+// matching returns rules which apply to the message sent from `from` to `to`.
+func (rules outgoingRules) matching(from string, to []string) (matched outgoingRules) {
+	for _, rule := range rules {
+		if !matchAddress(rule.From, from) {
+			continue
+		}
+		for _, recipient := range to {
+			if matchAddress(rule.To, recipient) {
+				matched = append(matched, rule)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func matchAddress(pattern, address string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(pmapi.NormalizeEmail(address)))
+	if err != nil {
+		log.WithError(err).WithField("pattern", pattern).Warn("Invalid address pattern of outgoing rule")
+	}
+	return ok
+}
+
+// bcc returns addresses which should be added as Bcc by the rules.
+func (rules outgoingRules) bcc() (addresses []string) {
+	for _, rule := range rules {
+		for _, address := range rule.Bcc {
+			if address = strings.TrimSpace(address); looksLikeEmail(address) {
+				addresses = append(addresses, pmapi.NormalizeEmail(address))
+			} else {
+				log.WithField("address", address).Warn("Ignoring invalid Bcc address of outgoing rule")
+			}
+		}
+	}
+	return addresses
+}
+
+// apply changes headers and appends footers to the parsed message and its
+// MIME body. Plain body with appended footers is returned.
+func (rules outgoingRules) apply(m *pmapi.Message, mimeBody, plainBody string) (string, string, error) {
+	for _, rule := range rules {
+		set := map[string]string{}
+		for key, value := range rule.SetHeaders {
+			if key = textproto.CanonicalMIMEHeaderKey(key); isProtectedHeader(key) {
+				log.WithField("header", key).Warn("Outgoing rule cannot set protected header")
+				continue
+			}
+			set[key] = value
+			m.Header[key] = []string{value}
+		}
+
+		remove := []string{}
+		for _, key := range rule.RemoveHeaders {
+			if key = textproto.CanonicalMIMEHeaderKey(key); isProtectedHeader(key) {
+				log.WithField("header", key).Warn("Outgoing rule cannot remove protected header")
+				continue
+			}
+			remove = append(remove, key)
+			delete(m.Header, key)
+		}
+
+		var err error
+		if len(set) > 0 {
+			if mimeBody, err = message.SetHeaders(mimeBody, set); err != nil {
+				return "", "", errors.Wrap(err, "failed to set headers by outgoing rule")
+			}
+		}
+		if len(remove) > 0 {
+			if mimeBody, err = message.RemoveHeaders(mimeBody, remove...); err != nil {
+				return "", "", errors.Wrap(err, "failed to remove headers by outgoing rule")
+			}
+		}
+
+		if rule.Footer != "" {
+			m.Body = message.AddFooter(m.Body, m.MIMEType, rule.Footer)
+			plainBody = message.AddFooter(plainBody, "text/plain", rule.Footer)
+			if mimeBody, err = message.AddFooterToMIMEBody(mimeBody, rule.Footer); err != nil {
+				return "", "", errors.Wrap(err, "failed to add footer by outgoing rule")
+			}
+		}
+	}
+
+	return mimeBody, plainBody, nil
+}
+
+func isProtectedHeader(key string) bool {
+	for _, protected := range protectedHeaders {
+		if strings.EqualFold(key, protected) {
+			return true
+		}
+	}
+	return false
+}
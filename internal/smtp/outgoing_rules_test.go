@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutgoingRulesMatching(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-prefs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{preferences: config.NewPreferences(filepath.Join(dir, "prefs.json"))}
+	assert.Empty(t, sb.outgoingRules())
+
+	sb.preferences.Set(preferences.SMTPOutgoingRulesKey, `[
+		{"from": "*@work.com", "bcc": ["archive@work.com", "invalid"]},
+		{"to": "*@partner.com", "footer": "Confidential"},
+		{"from": "me@pm.me", "to": "*@partner.com", "bcc": ["boss@pm.me"]}
+	]`)
+
+	rules := sb.outgoingRules()
+	require.Len(t, rules, 3)
+
+	assert.Empty(t, rules.matching("me@pm.me", []string{"bob@example.com"}))
+	assert.Equal(t, outgoingRules{rules[0]}, rules.matching("Me@Work.com", []string{"bob@example.com"}))
+	assert.Equal(t, outgoingRules{rules[1], rules[2]}, rules.matching("me@pm.me", []string{"bob@example.com", "alice@partner.com"}))
+
+	assert.Equal(t, []string{"archive@work.com"}, rules[:1].bcc())
+}
+
+func TestOutgoingRulesApply(t *testing.T) {
+	rules := outgoingRules{{
+		Footer:        "Confidential",
+		SetHeaders:    map[string]string{"x-archive": "yes", "Subject": "changed"},
+		RemoveHeaders: []string{"X-Mailer", "To"},
+	}}
+
+	m := &pmapi.Message{
+		Header:   mail.Header{"X-Mailer": {"client"}, "To": {"bob@example.com"}},
+		Body:     "<html><body>hello</body></html>",
+		MIMEType: "text/html",
+	}
+	mimeBody := "To: bob@example.com\r\nX-Mailer: client\r\nContent-Type: text/plain\r\n\r\nhello"
+
+	mimeBody, plainBody, err := rules.apply(m, mimeBody, "hello")
+	require.NoError(t, err)
+
+	assert.Equal(t, mail.Header{"X-Archive": {"yes"}, "To": {"bob@example.com"}}, m.Header)
+	assert.Equal(t, "<html><body>hello<br><br>Confidential</body></html>", m.Body)
+	assert.Equal(t, "hello\n\nConfidential", plainBody)
+
+	assert.Contains(t, mimeBody, "X-Archive: yes")
+	assert.Contains(t, mimeBody, "To: bob@example.com")
+	assert.NotContains(t, mimeBody, "X-Mailer")
+	assert.NotContains(t, mimeBody, "Subject")
+	assert.Contains(t, mimeBody, "hello\n\nConfidential")
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+// preferencesOverride is parsed from message header named the same as vCard
+// field (e.g. `X-Pm-Scheme: pgp-inline`). The value applies to all recipients
+// or only to those listed after semicolon, e.g.
+// `X-Pm-Encrypt: false; bob@example.com, carol@example.com`.
+type preferencesOverride struct {
+	field      string
+	value      string
+	recipients []string
+}
+
+// preferencesOverrideFields are vCard fields which can be overridden by header.
+var preferencesOverrideFields = []string{FieldPMScheme, FieldPMSign, FieldPMEncrypt} //nolint[gochecknoglobals]
+
+func parsePreferencesOverrides(header mail.Header) (overrides []preferencesOverride) {
+	for _, field := range preferencesOverrideFields {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(field)] {
+			override := preferencesOverride{field: field}
+
+			parts := strings.SplitN(value, ";", 2)
+			override.value = strings.ToLower(strings.TrimSpace(parts[0]))
+			if len(parts) == 2 {
+				for _, recipient := range strings.Split(parts[1], ",") {
+					if recipient = strings.TrimSpace(recipient); recipient != "" {
+						override.recipients = append(override.recipients, pmapi.NormalizeEmail(recipient))
+					}
+				}
+			}
+
+			overrides = append(overrides, override)
+		}
+	}
+	return overrides
+}
+
+func (o preferencesOverride) appliesTo(recipient string) bool {
+	if len(o.recipients) == 0 {
+		return true
+	}
+	for _, r := range o.recipients {
+		if r == recipient {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPreferencesOverrides changes contact settings of the recipient as if
+// they were set in the contact. Internal recipients are always encrypted and
+// signed regardless of them.
+func applyPreferencesOverrides(overrides []preferencesOverride, recipient string, vCardData *ContactMetadata) *ContactMetadata {
+	for _, o := range overrides {
+		if !o.appliesTo(recipient) {
+			continue
+		}
+
+		if vCardData == nil {
+			vCardData = &ContactMetadata{Email: recipient}
+		}
+
+		switch o.field {
+		case FieldPMScheme:
+			if o.value != pgpMIME && o.value != pgpInline {
+				log.WithField("value", o.value).Warn("Ignoring unknown scheme override")
+				continue
+			}
+			vCardData.Scheme = o.value
+
+		case FieldPMSign, FieldPMEncrypt:
+			v, err := strconv.ParseBool(o.value)
+			if err != nil {
+				log.WithField("field", o.field).WithField("value", o.value).Warn("Ignoring invalid override")
+				continue
+			}
+			if o.field == FieldPMSign {
+				vCardData.Sign = v
+				vCardData.SignIsSet = true
+			} else {
+				vCardData.Encrypt = v
+			}
+		}
+	}
+	return vCardData
+}
+
+// removePreferencesOverrides removes the override headers so they are not
+// sent to recipients, neither in header nor in MIME body.
+func removePreferencesOverrides(m *pmapi.Message, mimeBody string) (string, error) {
+	keys := make([]string, len(preferencesOverrideFields))
+	for i, field := range preferencesOverrideFields {
+		keys[i] = textproto.CanonicalMIMEHeaderKey(field)
+		delete(m.Header, keys[i])
+	}
+	return message.RemoveHeaders(mimeBody, keys...)
+}
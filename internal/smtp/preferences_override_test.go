@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreferencesOverrides(t *testing.T) {
+	overrides := parsePreferencesOverrides(mail.Header{
+		"X-Pm-Scheme":  {"PGP-Inline"},
+		"X-Pm-Sign":    {"true"},
+		"X-Pm-Encrypt": {"false; bob@example.com, Carol@Example.COM", "maybe"},
+	})
+	require.Len(t, overrides, 4)
+
+	assert.Equal(t, &ContactMetadata{
+		Email:     "alice@example.com",
+		Scheme:    pgpInline,
+		Sign:      true,
+		SignIsSet: true,
+	}, applyPreferencesOverrides(overrides, "alice@example.com", nil))
+
+	contact := &ContactMetadata{Email: "carol@example.com", Encrypt: true, Scheme: pgpMIME}
+	assert.Equal(t, &ContactMetadata{
+		Email:     "carol@example.com",
+		Scheme:    pgpInline,
+		Sign:      true,
+		SignIsSet: true,
+		Encrypt:   false,
+	}, applyPreferencesOverrides(overrides, "Carol@example.com", contact))
+
+	assert.Nil(t, applyPreferencesOverrides(nil, "alice@example.com", nil))
+}
+
+func TestRemovePreferencesOverrides(t *testing.T) {
+	m := &pmapi.Message{Header: mail.Header{"Subject": {"Hi"}, "X-Pm-Scheme": {"pgp-mime"}}}
+	mimeBody := "Subject: Hi\r\nX-Pm-Scheme: pgp-mime\r\nContent-Type: text/plain\r\n\r\nbody"
+
+	mimeBody, err := removePreferencesOverrides(m, mimeBody)
+	require.NoError(t, err)
+
+	assert.Equal(t, mail.Header{"Subject": {"Hi"}}, m.Header)
+	assert.NotContains(t, mimeBody, "X-Pm-Scheme")
+	assert.Contains(t, mimeBody, "Subject: Hi")
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/pkg/errors"
+)
+
+// Values of preferences.MDNPolicyKey deciding whether to send read receipt
+// (MDN, RFC8098) when a message requesting it is read.
+const (
+	MDNNever   = "never"   // Read receipts are not sent.
+	MDNAllowed = "allowed" // Sent only to senders matching preferences.MDNAllowKey.
+	MDNAlways  = "always"  // Sent to all senders except those matching preferences.MDNDenyKey.
+)
+
+const readReceiptBoundary = "read-receipt-boundary"
+
+func (sb *smtpBackend) mdnPolicy() string {
+	switch policy := sb.preferences.Get(preferences.MDNPolicyKey); policy {
+	case MDNAllowed, MDNAlways:
+		return policy
+	default:
+		return MDNNever
+	}
+}
+
+// mdnPatterns returns address patterns separated by comma in preference `key`.
+func (sb *smtpBackend) mdnPatterns(key string) (patterns []string) {
+	for _, pattern := range strings.Split(sb.preferences.Get(key), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func matchAnyAddress(patterns []string, address string) bool {
+	for _, pattern := range patterns {
+		if matchAddress(pattern, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// readReceiptAddress returns where to send the read receipt requested by the
+// message with `header` if allowed by preferences. Without explicit allow
+// rule, the receipt is sent only if the address is the same as Return-Path,
+// because otherwise it could be sent to someone else than the sender.
+func (sb *smtpBackend) readReceiptAddress(header mail.Header) (string, bool) {
+	policy := sb.mdnPolicy()
+	if policy == MDNNever {
+		return "", false
+	}
+
+	to, err := mail.ParseAddress(header.Get("Disposition-Notification-To"))
+	if err != nil {
+		log.WithError(err).Warn("Cannot parse Disposition-Notification-To")
+		return "", false
+	}
+
+	if matchAnyAddress(sb.mdnPatterns(preferences.MDNDenyKey), to.Address) {
+		return "", false
+	}
+	if matchAnyAddress(sb.mdnPatterns(preferences.MDNAllowKey), to.Address) {
+		return to.Address, true
+	}
+	if policy != MDNAlways {
+		return "", false
+	}
+
+	returnPath, err := mail.ParseAddress(header.Get("Return-Path"))
+	if err != nil || !strings.EqualFold(returnPath.Address, to.Address) {
+		log.WithField("to", to.Address).Info("Not sending read receipt to address other than Return-Path")
+		return "", false
+	}
+	return to.Address, true
+}
+
+// watchReadReceiptRequests sends read receipts for messages read in IMAP.
+func (sb *smtpBackend) watchReadReceiptRequests() {
+	defer sb.panicHandler.HandlePanic()
+
+	ch := make(chan string)
+	sb.eventListener.Add(events.ReadReceiptRequestEvent, ch)
+
+	for request := range ch {
+		ids := strings.SplitN(request, ":", 2)
+		if len(ids) != 2 || sb.mdnPolicy() == MDNNever {
+			continue
+		}
+		if err := sb.sendReadReceipt(ids[0], ids[1]); err != nil {
+			log.WithError(err).WithField("messageID", ids[1]).Warn("Cannot send read receipt")
+		}
+	}
+}
+
+// sendReadReceipt sends the read receipt by the normal send path with the
+// address which received the message as the sender. The receipt is sent only
+// once per message; the store remembers it was sent.
+func (sb *smtpBackend) sendReadReceipt(userID, messageID string) error {
+	user, err := sb.bridge.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	session, err := newSMTPUser(sb.panicHandler, sb.eventListener, sb, user, "")
+	if err != nil {
+		return err
+	}
+	su := session.(*smtpUser)
+
+	if su.storeUser.IsReadReceiptSent(messageID) {
+		return nil
+	}
+
+	original, err := su.client().GetMessage(messageID)
+	if err != nil {
+		return err
+	}
+
+	to, ok := sb.readReceiptAddress(original.Header)
+	if !ok {
+		return nil
+	}
+
+	addr := su.client().Addresses().ByID(original.AddressID)
+	if addr == nil {
+		return errors.New("address of message not found")
+	}
+
+	log.WithField("messageID", messageID).Info("Sending read receipt")
+	literal := buildReadReceipt(addr.Email, to, original, time.Now())
+	envelope := &smtpserver.Envelope{From: addr.Email, To: []smtpserver.Recipient{{Address: to}}}
+	if err := su.send(envelope, literal, false, true, nil); err != nil {
+		return err
+	}
+	return su.storeUser.SetReadReceiptSent(messageID)
+}
+
+// buildReadReceipt returns the read receipt of `original` message displayed
+// by `from` in the format of RFC8098.
+func buildReadReceipt(from, to string, original *pmapi.Message, now time.Time) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Read: "+original.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Auto-Submitted: auto-replied\r\n")
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=disposition-notification; boundary=%q\r\n", readReceiptBoundary)
+	fmt.Fprintf(&b, "\r\n")
+
+	fmt.Fprintf(&b, "--%s\r\n", readReceiptBoundary)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: 8bit\r\n\r\n")
+	fmt.Fprintf(&b, "The message sent on %s to %s with subject \"%s\" has been displayed.\r\n",
+		time.Unix(original.Time, 0).Format(time.RFC1123Z), from, original.Subject)
+	fmt.Fprintf(&b, "This is no guarantee that the message has been read or understood.\r\n")
+	fmt.Fprintf(&b, "\r\n")
+
+	fmt.Fprintf(&b, "--%s\r\n", readReceiptBoundary)
+	fmt.Fprintf(&b, "Content-Type: message/disposition-notification\r\n\r\n")
+	fmt.Fprintf(&b, "Reporting-UA: ProtonMail Bridge\r\n")
+	fmt.Fprintf(&b, "Final-Recipient: rfc822; %s\r\n", from)
+	if messageID := original.Header.Get("Message-Id"); messageID != "" {
+		fmt.Fprintf(&b, "Original-Message-ID: %s\r\n", messageID)
+	}
+	fmt.Fprintf(&b, "Disposition: manual-action/MDN-sent-automatically; displayed\r\n")
+	fmt.Fprintf(&b, "\r\n")
+
+	fmt.Fprintf(&b, "--%s--\r\n", readReceiptBoundary)
+
+	return b.Bytes()
+}
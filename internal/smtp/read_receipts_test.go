@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReceiptAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-prefs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{preferences: config.NewPreferences(filepath.Join(dir, "prefs.json"))}
+
+	header := func(dnt, returnPath string) mail.Header {
+		return mail.Header{
+			"Disposition-Notification-To": []string{dnt},
+			"Return-Path":                 []string{returnPath},
+		}
+	}
+
+	tests := []struct {
+		policy, allow, deny string
+		header              mail.Header
+		wantTo              string
+	}{
+		{MDNNever, "*", "", header("a@example.com", "a@example.com"), ""},
+		{MDNAllowed, "", "", header("a@example.com", "a@example.com"), ""},
+		{MDNAllowed, "*@example.com", "", header("A <a@example.com>", "b@example.com"), "a@example.com"},
+		{MDNAllowed, "*@example.com", "a@*", header("a@example.com", "a@example.com"), ""},
+		{MDNAlways, "", "", header("<a@example.com>", "<a@example.com>"), "a@example.com"},
+		{MDNAlways, "", "", header("a@example.com", "b@example.com"), ""},
+		{MDNAlways, "", "", header("a@example.com", ""), ""},
+		{MDNAlways, "", "b@*, a@*", header("a@example.com", "a@example.com"), ""},
+		{MDNAlways, "", "", header("invalid", "invalid"), ""},
+	}
+
+	for _, tc := range tests {
+		sb.preferences.Set(preferences.MDNPolicyKey, tc.policy)
+		sb.preferences.Set(preferences.MDNAllowKey, tc.allow)
+		sb.preferences.Set(preferences.MDNDenyKey, tc.deny)
+
+		to, ok := sb.readReceiptAddress(tc.header)
+		assert.Equal(t, tc.wantTo, to, "%+v", tc)
+		assert.Equal(t, tc.wantTo != "", ok, "%+v", tc)
+	}
+}
+
+func TestBuildReadReceipt(t *testing.T) {
+	original := &pmapi.Message{
+		Subject: "Hello",
+		Time:    time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC).Unix(),
+		Header:  mail.Header{"Message-Id": []string{"<original@example.com>"}},
+	}
+
+	literal := buildReadReceipt("me@pm.me", "a@example.com", original, time.Now())
+
+	m, _, plainBody, _, err := message.Parse(bytes.NewReader(literal), "", "")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Read: Hello", m.Subject)
+	assert.Equal(t, "auto-replied", m.Header.Get("Auto-Submitted"))
+	assert.Contains(t, plainBody, `with subject "Hello" has been displayed`)
+	assert.Contains(t, string(literal), "Original-Message-ID: <original@example.com>\r\n")
+	assert.Contains(t, string(literal), "Disposition: manual-action/MDN-sent-automatically; displayed\r\n")
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/gopenpgp/v2/helper"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"golang.org/x/crypto/openpgp"
+)
+
+// replyKeyMaxSize is the biggest attachment still read as a public key.
+const replyKeyMaxSize = 1 << 20
+
+// rxArmoredPublicKey finds public keys pasted in the body of a message.
+var rxArmoredPublicKey = regexp.MustCompile(`(?s)-----BEGIN PGP PUBLIC KEY BLOCK-----.*?-----END PGP PUBLIC KEY BLOCK-----`) //nolint[gochecknoglobals]
+
+// rxClearSigned finds inline signed text in the body of a message.
+var rxClearSigned = regexp.MustCompile(`(?s)-----BEGIN PGP SIGNED MESSAGE-----.*?-----END PGP SIGNATURE-----`) //nolint[gochecknoglobals]
+
+// replyKeys are public keys found in the message being replied to, attached
+// or pasted in the body, by the address of its sender. They are used to
+// encrypt the reply to external recipients without any other known key.
+//
+// A key is taken only if it belongs to the sender and the message is signed
+// by it, so a key cannot be planted by anyone else, e.g. by forwarding.
+type replyKeys map[string][]byte
+
+func (keys replyKeys) get(email string) []byte {
+	return keys[replyKeyAddress(email)]
+}
+
+// add parses armored or binary keys and remembers the one of `sender`
+// usable for encryption which signed the `body`.
+func (keys replyKeys) add(data []byte, sender, body string) {
+	var entities openpgp.EntityList
+	var err error
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		log.WithError(err).Debug("Ignoring invalid reply key")
+		return
+	}
+
+	sender = replyKeyAddress(sender)
+
+	for _, entity := range entities {
+		if !hasIdentity(entity, sender) {
+			log.Debug("Ignoring reply key not belonging to the sender")
+			continue
+		}
+		if _, ok := entity.EncryptionKey(time.Now()); !ok {
+			continue
+		}
+
+		// Only the public part is serialized, in the same form as keys
+		// of contacts.
+		var b bytes.Buffer
+		if err := entity.Serialize(&b); err != nil {
+			continue
+		}
+
+		if !isSignedBy(body, b.Bytes()) {
+			log.Debug("Ignoring reply key which did not sign the message")
+			continue
+		}
+
+		keys[sender] = b.Bytes()
+	}
+}
+
+func replyKeyAddress(email string) string {
+	return strings.ToLower(pmapi.NormalizeEmail(email))
+}
+
+func hasIdentity(entity *openpgp.Entity, email string) bool {
+	for _, identity := range entity.Identities {
+		if identity.UserId != nil && replyKeyAddress(identity.UserId.Email) == email {
+			return true
+		}
+	}
+	return false
+}
+
+// isSignedBy checks whether the body contains inline signed text verified by
+// the key. Signatures of PGP/MIME are not checked, the signed part is not
+// available in the form it was signed.
+func isSignedBy(body string, publicKey []byte) bool {
+	key, err := crypto.NewKey(publicKey)
+	if err != nil {
+		return false
+	}
+	kr, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return false
+	}
+
+	for _, signed := range rxClearSigned.FindAllString(body, -1) {
+		if _, err := helper.VerifyCleartextMessage(kr, signed, crypto.GetUnixTime()); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// replyKeyContacts returns addresses of contacts whose keys may be taken from
+// replied messages. They are listed one by one in the preference, none by
+// default, so no key is ever used without the user asking for it.
+func (sb *smtpBackend) replyKeyContacts() map[string]bool {
+	contacts := map[string]bool{}
+	for _, address := range strings.Split(sb.preferences.Get(preferences.SMTPReplyKeysKey), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			contacts[replyKeyAddress(address)] = true
+		}
+	}
+	return contacts
+}
+
+// getReplyKeys collects the public key of the sender of the message with
+// `parentID` if the sender is allowed to provide it. Failures are only
+// logged because the keys are just a bonus.
+func (su *smtpUser) getReplyKeys(parentID string) replyKeys {
+	keys := replyKeys{}
+	if parentID == "" {
+		return keys
+	}
+
+	contacts := su.backend.replyKeyContacts()
+	if len(contacts) == 0 {
+		return keys
+	}
+
+	parent, err := su.client().GetMessage(parentID)
+	if err != nil {
+		log.WithError(err).Warn("Cannot get parent message to find reply keys")
+		return keys
+	}
+
+	if parent.Sender == nil || !contacts[replyKeyAddress(parent.Sender.Address)] {
+		return keys
+	}
+
+	kr, err := su.client().KeyRingForAddressID(parent.AddressID)
+	if err != nil {
+		log.WithError(err).Warn("Cannot get keyring to find reply keys")
+		return keys
+	}
+
+	// Body is needed to check the signature.
+	if err := parent.Decrypt(kr); err != nil {
+		log.WithError(err).Warn("Cannot decrypt parent message to find reply keys")
+		return keys
+	}
+
+	for _, armored := range rxArmoredPublicKey.FindAllString(parent.Body, -1) {
+		keys.add([]byte(armored), parent.Sender.Address, parent.Body)
+	}
+
+	for _, att := range parent.Attachments {
+		if !isPublicKeyAttachment(att) {
+			continue
+		}
+		data, err := su.readAttachment(att, kr)
+		if err != nil {
+			log.WithError(err).Warn("Cannot read attached reply key")
+			continue
+		}
+		keys.add(data, parent.Sender.Address, parent.Body)
+	}
+
+	return keys
+}
+
+func isPublicKeyAttachment(att *pmapi.Attachment) bool {
+	if att.Size > replyKeyMaxSize {
+		return false
+	}
+	return strings.HasPrefix(att.MIMEType, "application/pgp-keys") ||
+		strings.HasSuffix(strings.ToLower(att.Name), ".asc")
+}
+
+func (su *smtpUser) readAttachment(att *pmapi.Attachment, kr *crypto.KeyRing) ([]byte, error) {
+	r, err := su.client().GetAttachment(att.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() //nolint[errcheck]
+
+	dec, err := att.Decrypt(r, kr)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(io.LimitReader(dec, replyKeyMaxSize))
+}
+
+// withReplyKey returns contact data of external recipient with only the key
+// from the parent message, which means the reply is encrypted.
+func withReplyKey(vCardData *ContactMetadata, key []byte) *ContactMetadata {
+	data := ContactMetadata{}
+	if vCardData != nil {
+		data = *vCardData
+	}
+	data.Keys = []string{string(key)}
+	data.Encrypt = true
+	return &data
+}
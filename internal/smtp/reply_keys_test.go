@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/gopenpgp/v2/helper"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/config"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSignedBody returns body with the public key of a new sender's key
+// pasted in text signed by the key.
+func newSignedBody(t *testing.T, email string) (string, []byte) {
+	key, err := crypto.GenerateKey("Sender", email, "x25519", 0)
+	require.NoError(t, err)
+	armored, err := key.GetArmoredPublicKey()
+	require.NoError(t, err)
+	binary, err := key.GetPublicKey()
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+	signed, err := helper.SignCleartextMessage(kr, "Hi, my key is below.\n\n"+armored)
+	require.NoError(t, err)
+
+	return "Forwarded:\n\n" + signed + "\n\nBye", binary
+}
+
+func TestReplyKeysFromSignedBody(t *testing.T) {
+	body, _ := newSignedBody(t, "sender@example.com")
+
+	keys := replyKeys{}
+	for _, armored := range rxArmoredPublicKey.FindAllString(body, -1) {
+		keys.add([]byte(armored), "Sender@Example.com", body)
+	}
+
+	require.NotNil(t, keys.get("sender@example.com"))
+	assert.Len(t, keys, 1)
+
+	key, err := crypto.NewKey(keys.get("sender@example.com"))
+	require.NoError(t, err)
+	assert.False(t, key.IsPrivate())
+}
+
+func TestReplyKeysBinary(t *testing.T) {
+	body, binary := newSignedBody(t, "sender@example.com")
+
+	keys := replyKeys{}
+	keys.add(binary, "sender@example.com", body)
+	keys.add([]byte("garbage"), "sender@example.com", body)
+
+	assert.Len(t, keys, 1)
+	assert.NotNil(t, keys.get("sender@example.com"))
+}
+
+func TestReplyKeysOnlyOfSender(t *testing.T) {
+	body, binary := newSignedBody(t, "sender@example.com")
+
+	keys := replyKeys{}
+	keys.add(binary, "forwarder@example.com", body)
+	assert.Len(t, keys, 0)
+}
+
+func TestReplyKeysOnlySigning(t *testing.T) {
+	// Key not signing the message, e.g. only attached, is ignored.
+	key, err := crypto.NewKeyFromArmored(testOtherPublicKey)
+	require.NoError(t, err)
+	binary, err := key.GetPublicKey()
+	require.NoError(t, err)
+
+	body, _ := newSignedBody(t, "testtest@pm.me")
+
+	keys := replyKeys{}
+	keys.add(binary, "testtest@pm.me", body)
+	keys.add(binary, "testtest@pm.me", "Unsigned body")
+	assert.Len(t, keys, 0)
+}
+
+func TestReplyKeyContacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-prefs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{preferences: config.NewPreferences(filepath.Join(dir, "prefs.json"))}
+	assert.Empty(t, sb.replyKeyContacts())
+
+	sb.preferences.Set(preferences.SMTPReplyKeysKey, " Alice@Example.com, bob@example.com,")
+	assert.Equal(t, map[string]bool{"alice@example.com": true, "bob@example.com": true}, sb.replyKeyContacts())
+}
+
+func TestIsPublicKeyAttachment(t *testing.T) {
+	assert.True(t, isPublicKeyAttachment(&pmapi.Attachment{Name: "key", MIMEType: "application/pgp-keys"}))
+	assert.True(t, isPublicKeyAttachment(&pmapi.Attachment{Name: "publickey.ASC", MIMEType: "application/octet-stream"}))
+	assert.False(t, isPublicKeyAttachment(&pmapi.Attachment{Name: "photo.jpg", MIMEType: "image/jpeg"}))
+	assert.False(t, isPublicKeyAttachment(&pmapi.Attachment{Name: "big.asc", Size: replyKeyMaxSize + 1}))
+}
+
+func TestWithReplyKey(t *testing.T) {
+	key, err := crypto.NewKeyFromArmored(testOtherPublicKey)
+	require.NoError(t, err)
+	binary, err := key.GetPublicKey()
+	require.NoError(t, err)
+
+	b := &sendPreferencesBuilder{}
+	require.NoError(t, b.setPGPSettings(withReplyKey(nil, binary), nil, false))
+	b.setEncryptionPreferences(pmapi.MailSettings{PGPScheme: pmapi.PGPMIMEPackage})
+	b.setMIMEPreferences("text/html")
+
+	sendPreferences := b.build()
+	assert.True(t, sendPreferences.Encrypt)
+	assert.True(t, sendPreferences.Sign)
+	assert.Equal(t, pmapi.PGPMIMEPackage, sendPreferences.Scheme)
+	assert.NotNil(t, sendPreferences.PublicKey)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/pkg/errors"
+)
+
+// sendError describes why sending failed by enhanced status code (RFC3463)
+// and human-readable text.
+type sendError struct {
+	status string
+	text   string
+	err    error
+}
+
+func newSendError(status, text string, err error) *sendError {
+	return &sendError{status: status, text: text, err: err}
+}
+
+func (e *sendError) Error() string {
+	return e.status + " " + e.message()
+}
+
+func (e *sendError) message() string {
+	if e.err == nil {
+		return e.text
+	}
+	return e.text + ": " + e.err.Error()
+}
+
+// Cause lets errors.Cause find the original error.
+func (e *sendError) Cause() error {
+	return e.err
+}
+
+// toSMTPError converts error of sending to the error sent to the client.
+func (su *smtpUser) toSMTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*sendError); ok {
+		return err
+	}
+
+	cause := errors.Cause(err)
+
+	switch cause {
+	case smtpserver.ErrDataTooLarge:
+		return cause
+	case errStillSending:
+		return newSendError("4.3.0", "The same message is still being sent, try again later", nil)
+	case errSendingCanceled:
+		return newSendError("5.7.0", "Sending without encryption was canceled by user", nil)
+	case pmapi.ErrUpgradeApplication:
+		return newSendError("5.3.0", "Bridge has to be upgraded", nil)
+	}
+
+	// Sending the same message again is recognized by sendRecorder, which
+	// does not send it twice if the first attempt succeeded after all.
+	if _, ok := err.(*sendRequestError); ok && isRetryableSendError(cause) {
+		return newSendError("4.4.1", "Server did not confirm sending, the message may have been sent already", err)
+	}
+
+	if isRetryableSendError(err) {
+		return newSendError("4.4.1", "Server cannot be reached, try again later", err)
+	}
+
+	if su.isOverQuota() {
+		return newSendError("5.2.2", "Mailbox storage quota exceeded", err)
+	}
+
+	if apiErr, ok := cause.(*pmapi.Error); ok {
+		switch apiErr.StatusCode {
+		case http.StatusRequestEntityTooLarge:
+			return smtpserver.ErrDataTooLarge
+		case http.StatusPaymentRequired:
+			return newSendError("5.7.1", "Paid plan is required", err)
+		}
+	}
+
+	if _, ok := cause.(*pmapi.ErrUnprocessableEntity); ok {
+		return newSendError("5.7.1", "Message was rejected by server", err)
+	}
+
+	return newSendError("5.3.0", "Sending failed", err)
+}
+
+// toReply converts error of sending to the reply to the client. Transient
+// failures (4.x.x) are replied by 451 so the client tries again later,
+// permanent ones by 554.
+func toReply(err error) error {
+	e, ok := err.(*sendError)
+	if !ok {
+		return err
+	}
+
+	code := 554
+	if strings.HasPrefix(e.status, "4.") {
+		code = 451
+	}
+
+	return &smtpserver.SMTPError{Code: code, EnhancedCode: e.status, Message: e.message()}
+}
+
+// isOverQuota returns whether the account has no space left, because the draft
+// and its attachments cannot be uploaded then.
+func (su *smtpUser) isOverQuota() bool {
+	user, err := su.client().CurrentUser()
+	if err != nil {
+		return false
+	}
+	return user.MaxSpace > 0 && user.UsedSpace >= user.MaxSpace
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	pmapimocks "github.com/ProtonMail/proton-bridge/pkg/pmapi/mocks"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBridgeUser struct {
+	bridgeUser
+
+	client pmapi.Client
+}
+
+func (u *testBridgeUser) GetTemporaryPMAPIClient() pmapi.Client {
+	return u.client
+}
+
+func TestToSMTPError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := pmapimocks.NewMockClient(ctrl)
+	su := &smtpUser{user: &testBridgeUser{client: client}}
+
+	client.EXPECT().CurrentUser().Return(&pmapi.User{UsedSpace: 10, MaxSpace: 100}, nil).AnyTimes()
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errStillSending, "4.3.0 The same message is still being sent, try again later"},
+		{errors.Wrap(pmapi.ErrAPINotReachable, "upload"), "4.4.1 Server cannot be reached, try again later: upload: cannot reach the server"},
+		{&pmapi.Error{StatusCode: http.StatusPaymentRequired, ErrorMessage: "upgrade"}, "5.7.1 Paid plan is required: upgrade"},
+		{newSendError("5.1.3", "bad address", nil), "5.1.3 bad address"},
+		{errors.New("something"), "5.3.0 Sending failed: something"},
+	}
+
+	for _, test := range tests {
+		assert.EqualError(t, su.toSMTPError(test.err), test.want)
+	}
+
+	assert.Nil(t, su.toSMTPError(nil))
+	assert.Equal(t, smtpserver.ErrDataTooLarge, su.toSMTPError(&pmapi.Error{StatusCode: http.StatusRequestEntityTooLarge}))
+}
+
+func TestToSMTPErrorOverQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := pmapimocks.NewMockClient(ctrl)
+	su := &smtpUser{user: &testBridgeUser{client: client}}
+
+	client.EXPECT().CurrentUser().Return(&pmapi.User{UsedSpace: 100, MaxSpace: 100}, nil)
+
+	assert.EqualError(t, su.toSMTPError(errors.New("upload failed")), "5.2.2 Mailbox storage quota exceeded: upload failed")
+}
+
+func TestToReply(t *testing.T) {
+	assert.Equal(t, &smtpserver.SMTPError{
+		Code:         451,
+		EnhancedCode: "4.4.1",
+		Message:      "Server cannot be reached, try again later: upload",
+	}, toReply(newSendError("4.4.1", "Server cannot be reached, try again later", errors.New("upload"))))
+
+	assert.Equal(t, &smtpserver.SMTPError{
+		Code:         554,
+		EnhancedCode: "5.7.1",
+		Message:      "Paid plan is required",
+	}, toReply(newSendError("5.7.1", "Paid plan is required", nil)))
+
+	assert.Equal(t, smtpserver.ErrDataTooLarge, toReply(smtpserver.ErrDataTooLarge))
+	assert.Nil(t, toReply(nil))
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/pkg/errors"
+)
+
+// errRateLimited means the queued message has to wait for the sending limits.
+var errRateLimited = errors.New("sending rate limit reached") //nolint[gochecknoglobals]
+
+type sentRecord struct {
+	time       time.Time
+	recipients int
+}
+
+// sendLimiter counts messages and recipients sent by each account in the last
+// hour so that a runaway script does not get the account limited or flagged
+// by API. Messages over the limit wait in the send queue.
+type sendLimiter struct {
+	lock *sync.Mutex
+	sent map[string][]sentRecord
+}
+
+func newSendLimiter() *sendLimiter {
+	return &sendLimiter{
+		lock: &sync.Mutex{},
+		sent: map[string][]sentRecord{},
+	}
+}
+
+// reserve records a message for `recipients` sent by the user at `now` and
+// returns zero if it fits into limits of messages per minute and recipients
+// per hour (zero means no limit). Otherwise nothing is recorded and it returns
+// how long to wait before the message fits.
+func (l *sendLimiter) reserve(userID string, recipients, maxMessages, maxRecipients int, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var records []sentRecord
+	for _, record := range l.sent[userID] {
+		if now.Sub(record.time) < time.Hour {
+			records = append(records, record)
+		}
+	}
+	l.sent[userID] = records
+
+	var wait time.Duration
+
+	if maxMessages > 0 && len(records) >= maxMessages {
+		// Records are in order of time, so the message to expire to make
+		// the space is maxMessages from the end.
+		if expires := records[len(records)-maxMessages].time.Add(time.Minute); expires.After(now) {
+			wait = expires.Sub(now)
+		}
+	}
+
+	if maxRecipients > 0 {
+		total := recipients
+		for _, record := range records {
+			total += record.recipients
+		}
+		for _, record := range records {
+			if total <= maxRecipients {
+				break
+			}
+			total -= record.recipients
+			if expires := record.time.Add(time.Hour).Sub(now); expires > wait {
+				wait = expires
+			}
+		}
+	}
+
+	if wait == 0 {
+		l.sent[userID] = append(records, sentRecord{time: now, recipients: recipients})
+	}
+
+	return wait
+}
+
+func (sb *smtpBackend) maxMessagesPerMinute() int {
+	return sb.preferences.GetInt(preferences.SMTPMaxMessagesKey)
+}
+
+func (sb *smtpBackend) maxRecipientsPerHour() int {
+	return sb.preferences.GetInt(preferences.SMTPMaxRecipientsKey)
+}
+
+// reserveSending returns how long the message of the user has to wait to not
+// exceed the sending limits or zero if it can be sent now.
+func (sb *smtpBackend) reserveSending(userID string, recipients int) time.Duration {
+	return sb.limiter.reserve(userID, recipients, sb.maxMessagesPerMinute(), sb.maxRecipientsPerHour(), time.Now())
+}
+
+// checkRecipientsLimit refuses message which would never fit into the limit
+// of recipients per hour.
+func (sb *smtpBackend) checkRecipientsLimit(recipients int) error {
+	if max := sb.maxRecipientsPerHour(); max > 0 && recipients > max {
+		return newSendError("5.5.3", "Too many recipients, the limit is "+strconv.Itoa(max)+" per hour", nil)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package smtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendLimiterMessagesPerMinute(t *testing.T) {
+	l := newSendLimiter()
+	now := time.Now()
+
+	assert.Zero(t, l.reserve("user", 1, 2, 0, now))
+	assert.Zero(t, l.reserve("user", 1, 2, 0, now.Add(10*time.Second)))
+	assert.Equal(t, 40*time.Second, l.reserve("user", 1, 2, 0, now.Add(20*time.Second)))
+
+	// Other accounts are not affected.
+	assert.Zero(t, l.reserve("other", 1, 2, 0, now.Add(20*time.Second)))
+
+	assert.Zero(t, l.reserve("user", 1, 2, 0, now.Add(time.Minute)))
+	assert.Equal(t, 10*time.Second, l.reserve("user", 1, 2, 0, now.Add(time.Minute)))
+}
+
+func TestSendLimiterRecipientsPerHour(t *testing.T) {
+	l := newSendLimiter()
+	now := time.Now()
+
+	assert.Zero(t, l.reserve("user", 5, 0, 10, now))
+	assert.Zero(t, l.reserve("user", 3, 0, 10, now.Add(time.Minute)))
+	assert.Equal(t, 59*time.Minute, l.reserve("user", 3, 0, 10, now.Add(time.Minute)))
+	assert.Zero(t, l.reserve("user", 2, 0, 10, now.Add(time.Minute)))
+
+	// Both older messages have to expire to make space for eight recipients.
+	assert.Equal(t, time.Hour, l.reserve("user", 8, 0, 10, now.Add(time.Minute)))
+	assert.Zero(t, l.reserve("user", 8, 0, 10, now.Add(61*time.Minute)))
+}
+
+func TestSendLimiterUnlimited(t *testing.T) {
+	l := newSendLimiter()
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		assert.Zero(t, l.reserve("user", 100, 0, 0, now))
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/pkg/errors"
+)
+
+const (
+	sendQueueMaxAttempts   = 10               // Including the first attempt before the message is queued.
+	sendQueueFirstDelay    = time.Minute      // Delay before the first retry, doubled with each attempt.
+	sendQueueMaxDelay      = time.Hour        // The longest delay between attempts.
+	sendQueueCheckInterval = 30 * time.Second // How often the queue is checked for messages to retry.
+)
+
+// errQueuedUserNotReady means the queued message cannot be decrypted because
+// the user is logged out or not loaded yet.
+var errQueuedUserNotReady = errors.New("user of queued message is not ready") //nolint[gochecknoglobals]
+
+// sendQueue keeps messages which failed to be sent for a reason which can go
+// away (e.g. network outage) in a folder until they are sent. The message
+// with its envelope is encrypted by the key of the sender address.
+type sendQueue struct {
+	dir  string
+	lock sync.Mutex
+}
+
+type queuedMessage struct {
+	UserID       string
+	AddressID    string // Address of the SMTP session in split mode.
+	KeyAddressID string // Address whose key encrypts Data.
+	DraftID      string // Draft created by a previous attempt, reused by the next one.
+	Reserved     bool   // Sending limits already count the message.
+	Created      time.Time
+	Attempts     int
+	NextAttempt  time.Time
+	Data         []byte // Encrypted queuedEnvelope.
+
+	path string
+}
+
+type queuedEnvelope struct {
+	smtpserver.Envelope
+
+	Literal []byte
+}
+
+func newSendQueue(dir string) *sendQueue {
+	return &sendQueue{dir: dir}
+}
+
+func (q *sendQueue) add(m *queuedMessage) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return err
+	}
+
+	m.path = filepath.Join(q.dir, hex.EncodeToString(id)+".json")
+	if m.Created.IsZero() {
+		m.Created = time.Now()
+	}
+	return q.write(m)
+}
+
+// update saves the changed attempts of the message.
+func (q *sendQueue) update(m *queuedMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.write(m)
+}
+
+func (q *sendQueue) write(m *queuedMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	// Written to temporary file first to not leave half of the message
+	// in the queue when Bridge is closed in the middle.
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func (q *sendQueue) remove(m *queuedMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return os.Remove(m.path)
+}
+
+// due returns messages which should be tried again at `now`, the oldest
+// first so messages are sent in the order they were queued.
+func (q *sendQueue) due(now time.Time) (messages []*queuedMessage) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		log.WithError(err).Error("Cannot list send queue")
+		return nil
+	}
+
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path) //nolint[gosec]
+		if err != nil {
+			log.WithError(err).WithField("path", path).Warn("Cannot read queued message")
+			continue
+		}
+
+		m := &queuedMessage{path: path}
+		if err := json.Unmarshal(b, m); err != nil {
+			log.WithError(err).WithField("path", path).Warn("Cannot parse queued message")
+			continue
+		}
+
+		if !m.NextAttempt.After(now) {
+			messages = append(messages, m)
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Created.Before(messages[j].Created)
+	})
+
+	return messages
+}
+
+// retryDelay returns how long to wait after given number of attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := sendQueueFirstDelay
+	for i := 1; i < attempts && delay < sendQueueMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > sendQueueMaxDelay {
+		return sendQueueMaxDelay
+	}
+	return delay
+}
+
+// sendRequestError is failure of the request sending the prepared draft.
+// The message could be sent even then (e.g. only the response was lost), so
+// it is never retried by Bridge, which could send it twice.
+type sendRequestError struct {
+	err error
+}
+
+func (e *sendRequestError) Error() string {
+	return e.err.Error()
+}
+
+// Cause lets errors.Cause find the original error.
+func (e *sendRequestError) Cause() error {
+	return e.err
+}
+
+// isRetryableSendError returns whether sending can succeed later without
+// any change, i.e. API was not reachable or failed by itself before the
+// message was sent.
+func isRetryableSendError(err error) bool {
+	if _, ok := err.(*sendRequestError); ok {
+		return false
+	}
+
+	cause := errors.Cause(err)
+
+	if cause == pmapi.ErrAPINotReachable || cause == pmapi.ErrConnectionSlow {
+		return true
+	}
+
+	if apiErr, ok := cause.(*pmapi.Error); ok {
+		return apiErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (sb *smtpBackend) isRetryQueueEnabled() bool {
+	return sb.queue != nil && sb.preferences.GetBool(preferences.SMTPRetryQueueKey)
+}
+
+// queueForRetry adds the message which failed to be sent to the send queue.
+// The draft created by the attempt, if any, is sent by the next one. The
+// attempt already reserved the message in sending limits.
+func (su *smtpUser) queueForRetry(envelope *smtpserver.Envelope, literal []byte, draftID string) error {
+	return su.queueMessage(envelope, literal, draftID, 1, true, time.Now().Add(retryDelay(1)))
+}
+
+// queueMessage encrypts the message and its envelope by the key of the
+// sender address and adds it to the send queue.
+func (su *smtpUser) queueMessage(envelope *smtpserver.Envelope, literal []byte, draftID string, attempts int, reserved bool, nextAttempt time.Time) error {
+	addr := su.client().Addresses().ByEmailForSending(envelope.From)
+	if addr == nil {
+		return errors.New("sender address not found")
+	}
+
+	kr, err := su.client().KeyRingForAddressID(addr.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(queuedEnvelope{Envelope: *envelope, Literal: literal})
+	if err != nil {
+		return err
+	}
+
+	enc, err := kr.Encrypt(crypto.NewPlainMessage(data), nil)
+	if err != nil {
+		return errors.Wrap(err, "cannot encrypt queued message")
+	}
+
+	return su.backend.queue.add(&queuedMessage{
+		UserID:       su.user.ID(),
+		AddressID:    su.addressID,
+		KeyAddressID: addr.ID,
+		DraftID:      draftID,
+		Reserved:     reserved,
+		Attempts:     attempts,
+		NextAttempt:  nextAttempt,
+		Data:         enc.GetBinary(),
+	})
+}
+
+// retryQueuedMessages periodically tries to send queued messages until the
+// backend is drained.
+func (sb *smtpBackend) retryQueuedMessages() {
+	defer sb.panicHandler.HandlePanic()
+	defer close(sb.queueStopped)
+
+	ticker := time.NewTicker(sendQueueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-sb.stopQueue:
+			return
+		}
+
+		for _, m := range sb.queue.due(time.Now()) {
+			select {
+			case <-sb.stopQueue:
+				return
+			default:
+			}
+			sb.retryQueuedMessage(m)
+		}
+	}
+}
+
+func (sb *smtpBackend) retryQueuedMessage(m *queuedMessage) {
+	l := log.WithField("path", m.path).WithField("attempts", m.Attempts)
+
+	lastAttempt := m.Attempts+1 >= sendQueueMaxAttempts
+	err := sb.sendQueuedMessage(m, !lastAttempt)
+	if err == errRateLimited {
+		l.Debug("Queued message is waiting for sending limits")
+		return
+	}
+	m.Attempts++
+
+	switch {
+	case err == nil:
+		l.Info("Queued message was sent")
+	case !lastAttempt && isRetryableQueueError(err):
+		m.NextAttempt = time.Now().Add(retryDelay(m.Attempts))
+		if err := sb.queue.update(m); err != nil {
+			l.WithError(err).Error("Cannot update queued message")
+		}
+		l.WithError(err).Warn("Sending queued message failed, will try again")
+		return
+	default:
+		l.WithError(err).Error("Sending queued message failed")
+	}
+
+	if err := sb.queue.remove(m); err != nil {
+		l.WithError(err).Error("Cannot remove queued message")
+	}
+}
+
+// isRetryableQueueError returns whether the queued message which failed to
+// be sent should be tried again later.
+func isRetryableQueueError(err error) bool {
+	return err == errStillSending || isRetryableSendError(err) || errors.Cause(err) == errQueuedUserNotReady
+}
+
+// sendQueuedMessage sends the queued message. Failures of sending itself are
+// reported by send, failures before are reported here once the message will
+// not be tried again, so no queued message is dropped silently.
+func (sb *smtpBackend) sendQueuedMessage(m *queuedMessage, willRetry bool) (err error) {
+	var envelope queuedEnvelope
+	sending := false
+	defer func() {
+		if err == nil || err == errRateLimited || sending {
+			return
+		}
+		if willRetry && isRetryableQueueError(err) {
+			return
+		}
+		sb.emitQueuedFailure(m, &envelope.Envelope, err)
+	}()
+
+	user, err := sb.bridge.GetUser(m.UserID)
+	if err != nil {
+		return errors.Wrap(errQueuedUserNotReady, err.Error())
+	}
+
+	session, err := newSMTPUser(sb.panicHandler, sb.eventListener, sb, user, m.AddressID)
+	if err != nil {
+		return errors.Wrap(errQueuedUserNotReady, err.Error())
+	}
+	su := session.(*smtpUser)
+
+	kr, err := su.client().KeyRingForAddressID(m.KeyAddressID)
+	if err != nil {
+		return errors.Wrap(errQueuedUserNotReady, err.Error())
+	}
+
+	dec, err := kr.Decrypt(crypto.NewPGPMessage(m.Data), nil, 0)
+	if err != nil {
+		return errors.Wrap(err, "cannot decrypt queued message")
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(dec.GetBinary())).Decode(&envelope); err != nil {
+		return errors.Wrap(err, "cannot parse queued message")
+	}
+
+	// Message is reserved in sending limits only once, retries after failed
+	// attempts do not take another slot.
+	if !m.Reserved {
+		if err := sb.checkRecipientsLimit(len(envelope.To)); err != nil {
+			return err
+		}
+		if wait := sb.reserveSending(m.UserID, len(envelope.To)); wait > 0 {
+			return errRateLimited
+		}
+		m.Reserved = true
+	}
+
+	sending = true
+	return su.send(&envelope.Envelope, envelope.Literal, willRetry, true, &m.DraftID)
+}
+
+// emitQueuedFailure emits the failure of the queued message which could not
+// be sent at all. The envelope is empty when the message cannot be decrypted.
+func (sb *smtpBackend) emitQueuedFailure(m *queuedMessage, envelope *smtpserver.Envelope, sendErr error) {
+	result := events.SendResult{UserID: m.UserID, From: envelope.From, Error: sendErr.Error()}
+	for _, r := range envelope.To {
+		result.To = append(result.To, r.Address)
+	}
+	sb.eventListener.Emit(events.SendFailedEvent, result.String())
+	sb.eventListener.Emit(events.ErrorEvent, "Queued message could not be sent: "+sendErr.Error())
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	q := newSendQueue(filepath.Join(dir, "queue"))
+	now := time.Now()
+
+	assert.Empty(t, q.due(now))
+
+	require.NoError(t, q.add(&queuedMessage{UserID: "user", Attempts: 1, NextAttempt: now, Data: []byte("encrypted")}))
+	require.NoError(t, q.add(&queuedMessage{UserID: "user", Attempts: 1, NextAttempt: now.Add(time.Hour)}))
+
+	due := q.due(now)
+	require.Len(t, due, 1)
+	assert.Equal(t, "user", due[0].UserID)
+	assert.Equal(t, []byte("encrypted"), due[0].Data)
+
+	due[0].NextAttempt = now.Add(time.Minute)
+	require.NoError(t, q.update(due[0]))
+	assert.Empty(t, q.due(now))
+	assert.Len(t, q.due(now.Add(2*time.Hour)), 2)
+
+	require.NoError(t, q.remove(due[0]))
+	assert.Len(t, q.due(now.Add(2*time.Hour)), 1)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, retryDelay(1))
+	assert.Equal(t, 2*time.Minute, retryDelay(2))
+	assert.Equal(t, 32*time.Minute, retryDelay(6))
+	assert.Equal(t, time.Hour, retryDelay(7))
+	assert.Equal(t, time.Hour, retryDelay(100))
+}
+
+func TestIsRetryableSendError(t *testing.T) {
+	assert.True(t, isRetryableSendError(pmapi.ErrAPINotReachable))
+	assert.True(t, isRetryableSendError(errors.Wrap(pmapi.ErrConnectionSlow, "upload")))
+	assert.True(t, isRetryableSendError(&pmapi.Error{Code: 2500, StatusCode: 503}))
+	assert.True(t, isRetryableSendError(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+
+	assert.False(t, isRetryableSendError(&pmapi.Error{Code: 2001, StatusCode: 422}))
+	assert.False(t, isRetryableSendError(errSendingCanceled))
+	assert.False(t, isRetryableSendError(errors.New("failed to add recipient")))
+
+	// Failed request to send could still send the message.
+	assert.False(t, isRetryableSendError(&sendRequestError{err: &pmapi.Error{Code: 2500, StatusCode: 503}}))
+	assert.False(t, isRetryableSendError(&sendRequestError{err: &net.OpError{Op: "read", Err: errors.New("reset")}}))
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	q := newSendQueue(filepath.Join(dir, "queue"))
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.add(&queuedMessage{
+			UserID:      string(rune('a' + i)),
+			DraftID:     "draft",
+			Created:     now.Add(time.Duration(i) * time.Second),
+			NextAttempt: now,
+		}))
+	}
+
+	due := q.due(now)
+	require.Len(t, due, 10)
+	for i, m := range due {
+		assert.Equal(t, string(rune('a'+i)), m.UserID)
+		assert.Equal(t, "draft", m.DraftID)
+	}
+}
+
+type testPanicHandler struct{}
+
+func (testPanicHandler) HandlePanic() {}
+
+func TestDrainStopsQueueRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	sb := &smtpBackend{
+		panicHandler: testPanicHandler{},
+		queue:        newSendQueue(filepath.Join(dir, "queue")),
+		stopQueue:    make(chan struct{}),
+		queueStopped: make(chan struct{}),
+	}
+	go sb.retryQueuedMessages()
+
+	sb.Drain(time.Now().Add(time.Second))
+
+	select {
+	case <-sb.queueStopped:
+	default:
+		t.Fatal("queue retries were not stopped")
+	}
+}
+
+func TestWaitForSending(t *testing.T) {
+	sb := &smtpBackend{sending: 1}
+	assert.False(t, sb.waitForSending(time.Now().Add(10*time.Millisecond)))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&sb.sending, -1)
+	}()
+	assert.True(t, sb.waitForSending(time.Now().Add(time.Second)))
+}
+
+type testNoUserBridge struct {
+	bridger
+}
+
+func (testNoUserBridge) GetUser(query string) (bridgeUser, error) {
+	return nil, errors.New("user " + query + " not found")
+}
+
+func TestRetryQueuedMessageOfMissingUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-smtp-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	failed := make(chan string)
+	eventListener := listener.New()
+	eventListener.Add(events.SendFailedEvent, failed)
+
+	sb := &smtpBackend{
+		eventListener: eventListener,
+		bridge:        testNoUserBridge{},
+		queue:         newSendQueue(filepath.Join(dir, "queue")),
+	}
+	require.NoError(t, sb.queue.add(&queuedMessage{UserID: "user", Attempts: 1}))
+
+	// User which is not loaded yet is waited for.
+	m := sb.queue.due(time.Now())[0]
+	sb.retryQueuedMessage(m)
+	assert.Equal(t, 2, m.Attempts)
+	assert.Len(t, sb.queue.due(time.Now().Add(time.Hour)), 1)
+
+	// The message is not removed silently after the last attempt.
+	m.Attempts = sendQueueMaxAttempts - 1
+	sb.retryQueuedMessage(m)
+	assert.Empty(t, sb.queue.due(time.Now().Add(time.Hour)))
+
+	select {
+	case data := <-failed:
+		result, err := events.ParseSendResult(data)
+		require.NoError(t, err)
+		assert.Equal(t, "user", result.UserID)
+		assert.Contains(t, result.Error, "not found")
+	case <-time.After(time.Second):
+		t.Fatal("failure of queued message was not emitted")
+	}
+}
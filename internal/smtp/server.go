@@ -19,29 +19,59 @@ package smtp
 
 import (
 	"crypto/tls"
-	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/ProtonMail/proton-bridge/pkg/saslbearer"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
 	"github.com/emersion/go-sasl"
-	goSMTP "github.com/emersion/go-smtp"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// maxFutureRelease is the longest hold of message announced by FUTURERELEASE,
+// matching how far ahead scheduled sending is offered by web client.
+const maxFutureRelease = 30 * 24 * time.Hour
+
 type smtpServer struct {
-	server        *goSMTP.Server
+	server        *smtpserver.Server
+	backend       *smtpBackend
+	listenerCfg   bridge.ListenerConfig
 	eventListener listener.Listener
 	useSSL        bool
+	listening     int32
+
+	lock     sync.Mutex
+	listener *bridge.DrainListener
 }
 
 // NewSMTPServer returns an SMTP server configured with the given options.
-func NewSMTPServer(debug bool, port int, useSSL bool, tls *tls.Config, smtpBackend goSMTP.Backend, eventListener listener.Listener) *smtpServer { //nolint[golint]
-	s := goSMTP.NewServer(smtpBackend)
-	s.Addr = fmt.Sprintf("%v:%v", bridge.Host, port)
+// Authentication without TLS is allowed only when the server is not reachable
+// from other machines. Listener dedicated to an account refuses other accounts.
+func NewSMTPServer(debug bool, listenerCfg bridge.ListenerConfig, useSSL bool, tls *tls.Config, smtpBackend *smtpBackend, eventListener listener.Listener) *smtpServer { //nolint[golint]
+	var backend tokenBackend = smtpBackend
+	if listenerCfg.Account != "" {
+		backend = &accountBackend{smtpBackend: smtpBackend, account: listenerCfg.Account}
+	}
+
+	s := smtpserver.NewServer(backend)
+	s.Addr = listenerCfg.Address()
 	s.TLSConfig = tls
 	s.Domain = bridge.Host
-	s.AllowInsecureAuth = true
+	s.AllowInsecureAuth = !listenerCfg.IsRemote()
+
+	// Announced by SIZE extension so clients do not send messages which would
+	// be refused by API anyway; bigger MAIL FROM SIZE is refused with 552.
+	if maxUpload := smtpBackend.announcedMaxUpload(); maxUpload > 0 {
+		s.MaxMessageBytes = maxMessageSize(maxUpload)
+	}
+
+	// HOLDFOR and HOLDUNTIL of FUTURERELEASE are sent as scheduled sending.
+	s.MaxFutureRelease = maxFutureRelease
 
 	if debug {
 		s.Debug = logrus.
@@ -49,20 +79,49 @@ func NewSMTPServer(debug bool, port int, useSSL bool, tls *tls.Config, smtpBacke
 			WriterLevel(logrus.DebugLevel)
 	}
 
-	s.EnableAuth(sasl.Login, func(conn *goSMTP.Conn) sasl.Server {
+	login := func(conn *smtpserver.Conn, address, password string) error {
+		user, err := conn.Server().Backend.Login(address, password)
+		if err != nil {
+			return err
+		}
+
+		conn.SetUser(user)
+		return nil
+	}
+
+	s.EnableAuth(sasl.Login, func(conn *smtpserver.Conn) sasl.Server {
 		return sasl.NewLoginServer(func(address, password string) error {
-			user, err := conn.Server().Backend.Login(address, password)
-			if err != nil {
-				return err
-			}
+			return login(conn, address, password)
+		})
+	})
 
-			conn.SetUser(user)
-			return nil
+	loginWithToken := func(conn *smtpserver.Conn, address, token string) error {
+		user, err := backend.LoginWithToken(address, token)
+		if err != nil {
+			return err
+		}
+
+		conn.SetUser(user)
+		return nil
+	}
+
+	// OAuth mechanisms accept only bearer tokens issued by bridge, not the
+	// bridge password.
+	s.EnableAuth(saslbearer.OAuthBearer, func(conn *smtpserver.Conn) sasl.Server {
+		return saslbearer.NewOAuthBearerServer(func(address, token string) error {
+			return loginWithToken(conn, address, token)
+		})
+	})
+	s.EnableAuth(saslbearer.XOAuth2, func(conn *smtpserver.Conn) sasl.Server {
+		return saslbearer.NewXOAuth2Server(func(address, token string) error {
+			return loginWithToken(conn, address, token)
 		})
 	})
 
 	return &smtpServer{
 		server:        s,
+		backend:       smtpBackend,
+		listenerCfg:   listenerCfg,
 		eventListener: eventListener,
 		useSSL:        useSSL,
 	}
@@ -74,13 +133,7 @@ func (s *smtpServer) ListenAndServe() {
 	l := log.WithField("useSSL", s.useSSL).WithField("address", s.server.Addr)
 
 	l.Info("SMTP server is starting")
-	var err error
-	if s.useSSL {
-		err = s.server.ListenAndServeTLS()
-	} else {
-		err = s.server.ListenAndServe()
-	}
-	if err != nil {
+	if err := s.serve(); err != nil {
 		s.eventListener.Emit(events.ErrorEvent, "SMTP failed: "+err.Error())
 		l.Error("SMTP failed: ", err)
 		return
@@ -90,18 +143,101 @@ func (s *smtpServer) ListenAndServe() {
 	l.Info("SMTP server stopped")
 }
 
+func (s *smtpServer) serve() error {
+	l, err := s.listenerCfg.Listen()
+	if err != nil {
+		return err
+	}
+	if s.useSSL {
+		l = tls.NewListener(l, s.server.TLSConfig)
+	}
+
+	dl := bridge.NewDrainListener(l)
+	s.lock.Lock()
+	s.listener = dl
+	s.lock.Unlock()
+
+	atomic.StoreInt32(&s.listening, 1)
+	defer atomic.StoreInt32(&s.listening, 0)
+
+	if err := s.server.Serve(dl); err != nil && !dl.IsDraining() {
+		return err
+	}
+	return nil
+}
+
+// IsListening returns whether the server accepts connections.
+func (s *smtpServer) IsListening() bool {
+	return atomic.LoadInt32(&s.listening) == 1
+}
+
+// Drain stops accepting new connections and waits until messages being sent
+// are done or the deadline passes. Then it closes all connections.
+func (s *smtpServer) Drain(deadline time.Time) {
+	s.lock.Lock()
+	l := s.listener
+	s.lock.Unlock()
+
+	if l == nil {
+		return
+	}
+	l.Drain()
+	atomic.StoreInt32(&s.listening, 0)
+
+	if !s.backend.waitForSending(deadline) {
+		log.WithField("address", s.server.Addr).Warn("Closing SMTP connections with messages still being sent")
+	}
+	s.Close()
+}
+
 // Stops the server.
 func (s *smtpServer) Close() {
 	s.server.Close()
 }
 
+// tokenBackend can log in also by the bearer token of OAuth mechanisms.
+type tokenBackend interface {
+	smtpserver.Backend
+
+	LoginWithToken(username, token string) (smtpserver.User, error)
+}
+
+// accountBackend lets only the dedicated account log in.
+type accountBackend struct {
+	*smtpBackend
+
+	account string
+}
+
+func (ab *accountBackend) Login(username, password string) (smtpserver.User, error) {
+	if !ab.isAccountAddress(ab.account, username) {
+		return nil, errors.New("this port is dedicated to another account")
+	}
+	return ab.smtpBackend.Login(username, password)
+}
+
+func (ab *accountBackend) LoginWithToken(username, token string) (smtpserver.User, error) {
+	if username == "" {
+		address, err := ab.getAddressByToken(token)
+		if err != nil {
+			return nil, err
+		}
+		username = address
+	}
+
+	if !ab.isAccountAddress(ab.account, username) {
+		return nil, errors.New("this port is dedicated to another account")
+	}
+	return ab.smtpBackend.LoginWithToken(username, token)
+}
+
 func (s *smtpServer) monitorDisconnectedUsers() {
 	ch := make(chan string)
 	s.eventListener.Add(events.CloseConnectionEvent, ch)
 
 	for address := range ch {
 		log.Info("Disconnecting all open SMTP connections for ", address)
-		disconnectUser := func(conn *goSMTP.Conn) {
+		disconnectUser := func(conn *smtpserver.Conn) {
 			connUser := conn.User()
 			if connUser != nil {
 				_ = conn.Close()
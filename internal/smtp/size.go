@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+)
+
+const (
+	// encryptionOverheadPercent estimates how much bigger the attachment is
+	// once it is encrypted (packet headers, session key and signature).
+	encryptionOverheadPercent = 2
+
+	// headersAllowance is added for headers, text parts and MIME boundaries
+	// which do not count into the upload limit.
+	headersAllowance = 1024 * 1024
+)
+
+// maxMessageSize estimates the biggest MIME message which fits into maxUpload
+// after its attachments are decoded from base64 (76 characters per line plus
+// line break for every 57 bytes) and encrypted.
+func maxMessageSize(maxUpload int) int {
+	plain := maxUpload * 100 / (100 + encryptionOverheadPercent)
+	encoded := (plain + 56) / 57 * 78
+	return encoded + headersAllowance
+}
+
+// announcedMaxUpload returns the size of attachments of one message from
+// which SIZE extension is announced, or zero for no limit. The limit of the
+// account, MaxUpload of the user returned by API (see pmapi.User), is not
+// known before login, so it is configured by preferences.SMTPMaxUploadKey;
+// the default is the limit of API for regular accounts, 25 MB.
+func (sb *smtpBackend) announcedMaxUpload() int {
+	return sb.preferences.GetInt(preferences.SMTPMaxUploadKey) * 1024 * 1024
+}
+
+// checkMessageSize refuses message which does not fit into MaxUpload of the
+// user returned by API, which can differ from the limit announced by SIZE.
+// If the user cannot be loaded, API refuses too big message by itself.
+func (su *smtpUser) checkMessageSize(size int) error {
+	apiUser, err := su.client().CurrentUser()
+	if err != nil || apiUser.MaxUpload <= 0 {
+		return nil
+	}
+	if size > maxMessageSize(int(apiUser.MaxUpload)) {
+		return smtpserver.ErrDataTooLarge
+	}
+	return nil
+}
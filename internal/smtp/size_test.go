@@ -0,0 +1,56 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	pmapimocks "github.com/ProtonMail/proton-bridge/pkg/pmapi/mocks"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+	maxUpload := 25 * 1024 * 1024
+	size := maxMessageSize(maxUpload)
+
+	// Base64 makes the message about one third bigger than the attachments.
+	assert.Greater(t, size, maxUpload*4/3)
+	assert.Less(t, size, maxUpload*3/2)
+
+	assert.Equal(t, headersAllowance, maxMessageSize(0))
+}
+
+func TestCheckMessageSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := pmapimocks.NewMockClient(ctrl)
+	su := &smtpUser{user: &testBridgeUser{client: client}}
+
+	client.EXPECT().CurrentUser().Return(&pmapi.User{MaxUpload: 10 * 1024 * 1024}, nil).Times(2)
+	assert.NoError(t, su.checkMessageSize(maxMessageSize(10*1024*1024)))
+	assert.Equal(t, smtpserver.ErrDataTooLarge, su.checkMessageSize(maxMessageSize(10*1024*1024)+1))
+
+	// API refuses the message by itself when the user is not known.
+	client.EXPECT().CurrentUser().Return(nil, errors.New("offline"))
+	assert.NoError(t, su.checkMessageSize(100*1024*1024))
+}
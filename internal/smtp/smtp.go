@@ -20,6 +20,10 @@ package smtp
 
 import "github.com/sirupsen/logrus"
 
+const (
+	sendPreferencesWorkers = 5 // In how many workers to look up keys of recipients.
+)
+
 var (
 	log = logrus.WithField("pkg", "smtp") //nolint[gochecknoglobals]
 )
@@ -19,6 +19,7 @@ package smtp
 
 import (
 	"io"
+	"net/mail"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
@@ -33,4 +34,7 @@ type storeUserProvider interface {
 		attachedPublicKeyName string,
 		parentID string) (*pmapi.Message, []*pmapi.Attachment, error)
 	SendMessage(messageID string, req *pmapi.SendMessageReq) error
+	RecordSentMessage(apiID string, header mail.Header)
+	IsReadReceiptSent(apiID string) bool
+	SetReadReceiptSent(apiID string) error
 }
@@ -20,24 +20,34 @@
 package smtp
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/mail"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/parallel"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
-	goSMTPBackend "github.com/emersion/go-smtp"
+	"github.com/ProtonMail/proton-bridge/pkg/smtpserver"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// Errors which are not reported by non-delivery report, see reportFailure.
+var (
+	errStillSending    = errors.New("original message is still being sent") //nolint[gochecknoglobals]
+	errSendingCanceled = errors.New("sending was canceled by user")         //nolint[gochecknoglobals]
+)
+
 type smtpUser struct {
 	panicHandler  panicHandler
 	eventListener listener.Listener
@@ -47,14 +57,14 @@ type smtpUser struct {
 	addressID     string
 }
 
-// newSMTPUser returns struct implementing go-smtp/session interface.
+// newSMTPUser returns struct implementing smtpserver.User interface.
 func newSMTPUser(
 	panicHandler panicHandler,
 	eventListener listener.Listener,
 	smtpBackend *smtpBackend,
 	user bridgeUser,
 	addressID string,
-) (goSMTPBackend.User, error) {
+) (smtpserver.User, error) {
 	storeUser := user.GetStore()
 	if storeUser == nil {
 		return nil, errors.New("user database is not initialized")
@@ -79,14 +89,17 @@ func (su *smtpUser) client() pmapi.Client {
 func (su *smtpUser) getSendPreferences(
 	recipient, messageMIMEType string,
 	mailSettings pmapi.MailSettings,
+	overrides []preferencesOverride,
+	replyKey []byte,
 ) (preferences SendPreferences, err error) {
 	b := &sendPreferencesBuilder{}
 
-	// 1. contact vcard data
+	// 1. contact vcard data, possibly overridden by message headers
 	vCardData, err := su.getContactVCardData(recipient)
 	if err != nil {
 		return
 	}
+	vCardData = applyPreferencesOverrides(overrides, recipient, vCardData)
 
 	// 2. api key data
 	apiKeys, isInternal, err := su.getAPIKeyData(recipient)
@@ -94,6 +107,12 @@ func (su *smtpUser) getSendPreferences(
 		return
 	}
 
+	// Key from the message being replied to is used only if there is no
+	// other key of the external recipient.
+	if replyKey != nil && !isInternal && len(apiKeys) == 0 && (vCardData == nil || len(vCardData.Keys) == 0) {
+		vCardData = withReplyKey(vCardData, replyKey)
+	}
+
 	// 1 + 2 -> 3. advanced PGP settings
 	if err = b.setPGPSettings(vCardData, apiKeys, isInternal); err != nil {
 		return
@@ -146,23 +165,145 @@ func (su *smtpUser) getAPIKeyData(recipient string) (apiKeys []pmapi.PublicKey,
 }
 
 // Send sends an email from the given address to the given addresses with the given body.
-func (su *smtpUser) Send(from string, to []string, messageReader io.Reader) (err error) { //nolint[funlen]
-	// Called from go-smtp in goroutines - we need to handle panics for each function.
+func (su *smtpUser) Send(envelope *smtpserver.Envelope, messageReader io.Reader) error {
+	// Called from smtpserver in goroutines - we need to handle panics for each function.
 	defer su.panicHandler.HandlePanic()
 
-	mailSettings, err := su.client().GetMailSettings()
+	return toReply(su.submit(envelope, messageReader))
+}
+
+// submit sends the message or, when sending fails for a reason which can go
+// away (e.g. API is not reachable), queues it and sends it later, see
+// sendQueue.
+func (su *smtpUser) submit(envelope *smtpserver.Envelope, messageReader io.Reader) error {
+	// Shutdown waits for the message before it closes the connection.
+	atomic.AddInt32(&su.backend.sending, 1)
+	defer atomic.AddInt32(&su.backend.sending, -1)
+
+	literal, err := ioutil.ReadAll(messageReader)
 	if err != nil {
+		return su.toSMTPError(err)
+	}
+
+	if err := su.checkMessageSize(len(literal)); err != nil {
+		return err
+	}
+
+	// Linted message is also what is queued, so repairs are done only once.
+	if literal, err = lintMessage(literal, envelope.From, time.Now()); err != nil {
+		return err
+	}
+
+	if err := su.backend.checkRecipientsLimit(len(envelope.To)); err != nil {
 		return err
 	}
+	wait := su.backend.reserveSending(su.user.ID(), len(envelope.To))
+
+	if !su.backend.isRetryQueueEnabled() {
+		if wait > 0 {
+			return newSendError("4.7.0", "Sending rate limit reached, try again in "+wait.Round(time.Second).String(), nil)
+		}
+		return su.toSMTPError(su.send(envelope, literal, false, false, nil))
+	}
+
+	if wait > 0 {
+		if err := su.queueMessage(envelope, literal, "", 0, false, time.Now().Add(wait)); err != nil {
+			log.WithError(err).Error("Cannot queue message over sending limit")
+			return newSendError("4.7.0", "Sending rate limit reached, try again later", nil)
+		}
+		log.WithField("wait", wait).Warn("Sending rate limit reached, message is queued")
+		return nil
+	}
+
+	var draftID string
+	err = su.send(envelope, literal, true, false, &draftID)
+	if err == nil || err == errStillSending || !isRetryableSendError(err) {
+		return su.toSMTPError(err)
+	}
+
+	if queueErr := su.queueForRetry(envelope, literal, draftID); queueErr != nil {
+		log.WithError(queueErr).Error("Cannot queue message for another attempt")
+		return su.toSMTPError(err)
+	}
+
+	log.WithError(err).Warn("Sending failed, message is queued for another attempt")
+	return nil
+}
+
+// emitSendResult emits the final result of sending, i.e. not failures which
+// will be retried, e.g. for webhooks.
+func (su *smtpUser) emitSendResult(from string, recipients []dsnRecipient, header mail.Header, sendErr error) {
+	result := events.SendResult{UserID: su.user.ID(), From: from}
+	for _, r := range recipients {
+		result.To = append(result.To, r.address)
+	}
+	if header != nil {
+		subject := header.Get("Subject")
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		result.Subject = subject
+	}
 
-	var addr *pmapi.Address = su.client().Addresses().ByEmail(from)
-	if addr == nil {
-		err = errors.New("backend: invalid email address: not owned by user")
+	if sendErr != nil {
+		result.Error = sendErr.Error()
+		su.eventListener.Emit(events.SendFailedEvent, result.String())
 		return
 	}
+	su.eventListener.Emit(events.MessageSentEvent, result.String())
+}
+
+// send does the actual sending. If willRetry is set, failures which would be
+// retried are not reported to the sender. If accepted is set, the client was
+// already told the message is sent, so failure is reported for all recipients.
+// If draftID is set, the draft it points to, created by previous attempt, is
+// sent instead of a new one, and it is set to the draft of this attempt.
+func (su *smtpUser) send(envelope *smtpserver.Envelope, literal []byte, willRetry, accepted bool, draftID *string) (err error) { //nolint[funlen,gocyclo]
+	// Internationalized addresses can come in different forms in envelope,
+	// headers and contacts, see pmapi.NormalizeEmail.
+	from := envelope.From
+	to := make([]string, len(envelope.To))
+	recipients := make([]dsnRecipient, len(envelope.To))
+	for i := range envelope.To {
+		recipients[i] = newDSNRecipient(envelope.To[i])
+		recipients[i].address = pmapi.NormalizeEmail(recipients[i].address)
+		to[i] = recipients[i].address
+	}
+
+	var addr *pmapi.Address
+	var kr *crypto.KeyRing
+	var header mail.Header
+	alreadySent := false
+	defer func() {
+		if err == errSendingCanceled || err == errStillSending || alreadySent {
+			return
+		}
+		if err != nil && willRetry && isRetryableSendError(err) {
+			return
+		}
+		// Without the sender address and its keys no report can be imported,
+		// the failure is at least emitted.
+		if addr == nil || kr == nil {
+			su.emitSendResult(from, recipients, header, err)
+			return
+		}
+		su.emitSendResult(addr.Email, recipients, header, err)
+		if err != nil {
+			su.reportFailure(addr, kr, envelope, header, literal, recipients, err, accepted)
+		}
+	}()
 
-	kr, err := su.client().KeyRingForAddressID(addr.ID)
+	mailSettings, err := su.client().GetMailSettings()
 	if err != nil {
+		return err
+	}
+
+	if addr = su.client().Addresses().ByEmailForSending(from); addr == nil {
+		err = newSendError("5.7.1", "Sender address is not owned by the account", nil)
+		return
+	}
+
+	if kr, err = su.client().KeyRingForAddressID(addr.ID); err != nil {
 		return
 	}
 
@@ -182,22 +323,43 @@ func (su *smtpUser) Send(from string, to []string, messageReader io.Reader) (err
 		attachedPublicKeyName = fmt.Sprintf("publickey - %v - %v", kr.GetIdentities()[0].Name, firstKey.GetFingerprint()[:8])
 	}
 
-	message, mimeBody, plainBody, attReaders, err := message.Parse(messageReader, attachedPublicKey, attachedPublicKeyName)
+	message, mimeBody, plainBody, attReaders, err := message.Parse(bytes.NewReader(literal), attachedPublicKey, attachedPublicKeyName)
 	if err != nil {
 		log.WithError(err).Error("Failed to parse message")
-		return
+		return newSendError("5.6.0", "Message cannot be parsed", err)
+	}
+
+	overrides := parsePreferencesOverrides(message.Header)
+	if len(overrides) > 0 {
+		if mimeBody, err = removePreferencesOverrides(message, mimeBody); err != nil {
+			return err
+		}
+	}
+
+	rules := su.backend.outgoingRules().matching(from, to)
+	if mimeBody, plainBody, err = rules.apply(message, mimeBody, plainBody); err != nil {
+		return err
 	}
 	richBody := message.Body
 
-	externalID := message.Header.Get("Message-Id")
+	header = message.Header
+	externalID := header.Get("Message-Id")
 	externalID = strings.Trim(externalID, "<>")
 
-	draftID, parentID := su.handleReferencesHeader(message)
+	clientDraftID, parentID := su.handleReferencesHeader(message)
+
+	to = addRecipients(to, su.backend.autoBcc(addr.Email))
+	to = addRecipients(to, rules.bcc())
 
 	if err = su.handleSenderAndRecipients(message, addr, from, to); err != nil {
 		return err
 	}
 
+	// Recipients get the message based on packages, not on the Bcc list.
+	if su.backend.bccPolicy() == BccStrip {
+		message.BCCList = nil
+	}
+
 	message.AddressID = addr.ID
 
 	// Apple Mail Message-Id has to be stored to avoid recovered message after each send.
@@ -226,30 +388,54 @@ func (su *smtpUser) Send(from string, to []string, messageReader io.Reader) (err
 	}
 	if isSending {
 		log.Debug("Message is still in send queue, returning error to prevent client from adding it to the sent folder prematurely")
-		return errors.New("original message is still being sent")
+		return errStillSending
 	}
 	if wasSent {
 		log.Debug("Message was already sent")
+		alreadySent = true
 		return nil
 	}
 
 	su.backend.sendRecorder.addMessage(sendRecorderMessageHash)
-	message, atts, err := su.storeUser.CreateDraft(kr, message, attReaders, attachedPublicKey, attachedPublicKeyName, parentID)
-	if err != nil {
-		su.backend.sendRecorder.removeMessage(sendRecorderMessageHash)
-		log.WithError(err).Error("Draft could not be created")
-		return err
+	defer func() {
+		// Only the request to send can leave the message sent. After any
+		// earlier failure, there is just the draft for the next attempt.
+		if _, ok := err.(*sendRequestError); err != nil && !ok {
+			su.backend.sendRecorder.removeMessage(sendRecorderMessageHash)
+		}
+	}()
+
+	var atts []*pmapi.Attachment
+	var previousDraft *pmapi.Message
+	if draftID != nil && *draftID != "" {
+		if previousDraft, err = su.getPreviousDraft(*draftID); err != nil {
+			return err
+		}
+	}
+
+	if previousDraft != nil {
+		message = previousDraft
+		log.WithField("messageID", message.ID).Debug("Draft of previous attempt is reused")
+	} else {
+		message, atts, err = su.storeUser.CreateDraft(kr, message, attReaders, attachedPublicKey, attachedPublicKeyName, parentID)
+		if err != nil {
+			log.WithError(err).Error("Draft could not be created")
+			return err
+		}
+		log.WithField("messageID", message.ID).Debug("Draft was created successfully")
 	}
 	su.backend.sendRecorder.setMessageID(sendRecorderMessageHash, message.ID)
-	log.WithField("messageID", message.ID).Debug("Draft was created successfully")
+	if draftID != nil {
+		*draftID = message.ID
+	}
 
 	// We always have to create a new draft even if there already is one,
 	// because clients don't necessarily save the draft before sending, which
 	// can lead to sending the wrong message. Also clients do not necessarily
 	// delete the old draft.
-	if draftID != "" {
-		if err := su.client().DeleteMessages([]string{draftID}); err != nil {
-			log.WithError(err).WithField("draftID", draftID).Warn("Original draft cannot be deleted")
+	if clientDraftID != "" && previousDraft == nil {
+		if err := su.client().DeleteMessages([]string{clientDraftID}); err != nil {
+			log.WithError(err).WithField("draftID", clientDraftID).Warn("Original draft cannot be deleted")
 		}
 	}
 
@@ -267,20 +453,47 @@ func (su *smtpUser) Send(from string, to []string, messageReader io.Reader) (err
 		}
 	}
 
-	req := pmapi.NewSendMessageReq(kr, mimeBody, plainBody, richBody, attkeys)
-	containsUnencryptedRecipients := false
+	replyKeys := su.getReplyKeys(parentID)
 
-	for _, email := range to {
-		if !looksLikeEmail(email) {
-			return errors.New(`"` + email + `" is not a valid recipient.`)
-		}
+	recipientPreferences, err := su.getRecipientsPreferences(to, message.MIMEType, mailSettings, overrides, replyKeys)
+	if err != nil {
+		return err
+	}
 
-		sendPreferences, err := su.getSendPreferences(email, message.MIMEType, mailSettings)
+	containsUnencryptedRecipients := false
+	for _, sendPreferences := range recipientPreferences {
 		if !sendPreferences.Encrypt {
 			containsUnencryptedRecipients = true
 		}
-		if err != nil {
-			return err
+	}
+
+	if mimeBody, err = encodeMIMEBody(mimeBody, recipientPreferences); err != nil {
+		return err
+	}
+
+	req := pmapi.NewSendMessageReq(kr, mimeBody, plainBody, richBody, attkeys)
+
+	// Release time which already passed (e.g. when retried from the queue)
+	// is sent right away.
+	if envelope.HoldUntil.After(time.Now()) {
+		req.DeliveryTime = envelope.HoldUntil.Unix()
+	}
+
+	askForMissingKeys := false
+	for i, email := range to {
+		sendPreferences := recipientPreferences[i]
+
+		if isMissingKey(sendPreferences) {
+			switch su.backend.missingKeyPolicy() {
+			case MissingKeyPlaintext:
+			case MissingKeyAsk:
+				askForMissingKeys = true
+			default:
+				return newSendError("5.7.1", "No public key to encrypt message for "+email, nil)
+			}
+			log.WithField("recipient", email).Warn("Sending cleartext to recipient without public key")
+			sendPreferences = withoutEncryption(sendPreferences, message.MIMEType)
+			containsUnencryptedRecipients = true
 		}
 
 		var signature pmapi.SignatureFlag
@@ -301,17 +514,88 @@ func (su *smtpUser) Send(from string, to []string, messageReader io.Reader) (err
 		if err != nil {
 			return errors.New("error decoding subject message " + message.Header.Get("Subject"))
 		}
-		if !su.continueSendingUnencryptedMail(subject) {
+		if !su.continueSendingUnencryptedMail(subject, askForMissingKeys) {
 			if err := su.client().DeleteMessages([]string{message.ID}); err != nil {
 				log.WithError(err).Warn("Failed to delete canceled messages")
 			}
-			return errors.New("sending was canceled by user")
+			return errSendingCanceled
 		}
 	}
 
 	req.PreparePackages()
 
-	return su.storeUser.SendMessage(message.ID, req)
+	if err := su.storeUser.SendMessage(message.ID, req); err != nil {
+		return &sendRequestError{err: err}
+	}
+
+	// Client will most probably APPEND the message to Sent as well.
+	su.storeUser.RecordSentMessage(message.ID, header)
+	return nil
+}
+
+// getPreviousDraft returns the draft created by previous attempt to send the
+// message, nil if it is gone and a new one has to be created.
+func (su *smtpUser) getPreviousDraft(draftID string) (*pmapi.Message, error) {
+	draft, err := su.client().GetMessage(draftID)
+	if err != nil {
+		if isRetryableSendError(err) {
+			return nil, err
+		}
+		log.WithError(err).WithField("draftID", draftID).Warn("Draft of previous attempt is gone")
+		return nil, nil
+	}
+	if draft.Type != pmapi.MessageTypeDraft {
+		log.WithField("draftID", draftID).Warn("Draft of previous attempt is not a draft anymore")
+		return nil, nil
+	}
+	return draft, nil
+}
+
+// getRecipientsPreferences looks up keys and contacts of all recipients in
+// parallel, because each recipient needs a few API calls.
+func (su *smtpUser) getRecipientsPreferences(
+	to []string,
+	messageMIMEType string,
+	mailSettings pmapi.MailSettings,
+	overrides []preferencesOverride,
+	replyKeys replyKeys,
+) ([]SendPreferences, error) {
+	input := make([]interface{}, len(to))
+	for i, email := range to {
+		if !looksLikeEmail(email) {
+			return nil, newSendError("5.1.3", `"`+email+`" is not a valid recipient address`, nil)
+		}
+		input[i] = email
+	}
+
+	processCallback := func(value interface{}) (interface{}, error) {
+		email := value.(string)
+		return su.getSendPreferences(email, messageMIMEType, mailSettings, overrides, replyKeys.get(email))
+	}
+
+	recipientPreferences := make([]SendPreferences, len(to))
+	collectCallback := func(idx int, value interface{}) error {
+		recipientPreferences[idx] = value.(SendPreferences)
+		return nil
+	}
+
+	if err := parallel.RunParallel(sendPreferencesWorkers, input, processCallback, collectCallback); err != nil {
+		return nil, err
+	}
+
+	return recipientPreferences, nil
+}
+
+// encodeMIMEBody keeps 8-bit parts of MIME body unless it is signed for some
+// recipient, because signature has to be done over 7-bit data.
+func encodeMIMEBody(mimeBody string, recipientPreferences []SendPreferences) (string, error) {
+	for _, sendPreferences := range recipientPreferences {
+		if sendPreferences.Sign && sendPreferences.Scheme.HasAtLeastOne(pmapi.PGPMIMEPackage|pmapi.ClearMIMEPackage) {
+			body, err := message.Make7Bit(mimeBody)
+			return body, errors.Wrap(err, "failed to encode MIME body to 7-bit")
+		}
+	}
+	return mimeBody, nil
 }
 
 func (su *smtpUser) handleReferencesHeader(m *pmapi.Message) (draftID, parentID string) {
@@ -361,7 +645,11 @@ func (su *smtpUser) handleReferencesHeader(m *pmapi.Message) (draftID, parentID
 }
 
 func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, addr *pmapi.Address, from string, to []string) (err error) {
-	from = pmapi.ConstructAddress(from, addr.Email)
+	// Sending as the address or its plus alias uses the address as it is
+	// on API; other addresses are sent through catch-all as they are.
+	if strings.EqualFold(pmapi.NormalizeEmail(pmapi.SanitizeEmail(from)), pmapi.NormalizeEmail(addr.Email)) {
+		from = pmapi.ConstructAddress(from, addr.Email)
+	}
 
 	// Check sender.
 	if m.Sender == nil {
@@ -372,7 +660,7 @@ func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, addr *pmapi.Addr
 
 	// Check recipients.
 	if len(to) == 0 {
-		err = errors.New("backend: no recipient specified")
+		err = newSendError("5.5.1", "No recipient specified", nil)
 		return
 	}
 
@@ -381,7 +669,7 @@ func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, addr *pmapi.Addr
 	for _, keep := range m.ToList {
 		keepThis := false
 		for _, addr := range to {
-			if addr == keep.Address {
+			if addr == pmapi.NormalizeEmail(keep.Address) {
 				keepThis = true
 				break
 			}
@@ -402,7 +690,7 @@ func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, addr *pmapi.Addr
 
 	rm := map[string]bool{}
 	for _, r := range recipients {
-		rm[r.Address] = true
+		rm[pmapi.NormalizeEmail(r.Address)] = true
 	}
 
 	for _, r := range to {
@@ -415,8 +703,11 @@ func (su *smtpUser) handleSenderAndRecipients(m *pmapi.Message, addr *pmapi.Addr
 	return nil
 }
 
-func (su *smtpUser) continueSendingUnencryptedMail(subject string) bool {
-	if !su.backend.shouldReportOutgoingNoEnc() {
+// continueSendingUnencryptedMail asks user in GUI whether to send the message
+// unencrypted. The question is asked only when reporting is enabled in
+// preferences unless `force` is set.
+func (su *smtpUser) continueSendingUnencryptedMail(subject string, force bool) bool {
+	if !force && !su.backend.shouldReportOutgoingNoEnc() {
 		return true
 	}
 
@@ -43,6 +43,18 @@ const (
 	FieldPMMIMEType = "X-PM-MIMETYPE"
 )
 
+// getGroupByEmail returns group of the email field matching the email
+// regardless of the form of internationalized address.
+func getGroupByEmail(card vcard.Card, email string) string {
+	email = pmapi.NormalizeEmail(email)
+	for _, field := range card[vcard.FieldEmail] {
+		if pmapi.NormalizeEmail(field.Value) == email {
+			return field.Group
+		}
+	}
+	return ""
+}
+
 func GetContactMetadataFromVCards(cards []pmapi.Card, email string) (contactMeta *ContactMetadata, err error) {
 	for _, card := range cards {
 		dec := vcard.NewDecoder(strings.NewReader(card.Data))
@@ -50,7 +62,7 @@ func GetContactMetadataFromVCards(cards []pmapi.Card, email string) (contactMeta
 		if err != nil {
 			return nil, err
 		}
-		group := parsedCard.GetGroupByValue(vcard.FieldEmail, email)
+		group := getGroupByEmail(parsedCard, email)
 		if len(group) == 0 {
 			continue
 		}
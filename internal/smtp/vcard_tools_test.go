@@ -0,0 +1,41 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-vcard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetGroupByEmailInternationalized(t *testing.T) {
+	card, err := vcard.NewDecoder(strings.NewReader("BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"item1.EMAIL:jöe@xn--bcher-kva.de\r\n" +
+		"item2.EMAIL:用户@例子.广告\r\n" +
+		"END:VCARD\r\n")).Decode()
+	require.NoError(t, err)
+
+	assert.Equal(t, "item1", getGroupByEmail(card, "jöe@bücher.de"))
+	assert.Equal(t, "item1", getGroupByEmail(card, "jöe@xn--bcher-kva.de"))
+	assert.Equal(t, "item2", getGroupByEmail(card, "用户@例子.广告"))
+	assert.Equal(t, "", getGroupByEmail(card, "joe@bücher.de"))
+}
@@ -66,6 +66,13 @@ func (storeAddress *Address) init(foldersAndLabels []*pmapi.Label) (err error) {
 
 	err = storeAddress.store.db.Update(func(tx *bolt.Tx) error {
 		for _, label := range foldersAndLabels {
+			if storeAddress.store.isLabelExcluded(label) {
+				if err := tx.Bucket(mailboxesBucket).DeleteBucket(getMailboxBucketName(storeAddress.addressID, label.ID)); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+				continue
+			}
+
 			prefix := getLabelPrefix(label)
 
 			var mailbox *Mailbox
@@ -79,7 +86,7 @@ func (storeAddress *Address) init(foldersAndLabels []*pmapi.Label) (err error) {
 
 			storeAddress.mailboxes[label.ID] = mailbox
 		}
-		return nil
+		return storeAddress.txInitVirtualMailboxes(tx, storeAddress.store.getVirtualMailboxes(foldersAndLabels))
 	})
 
 	return
@@ -0,0 +1,67 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// GetAttachmentCacheDir returns the directory of cached attachments for the
+// store database on the path.
+func GetAttachmentCacheDir(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + "-attachments"
+}
+
+// LoadCachedAttachment returns the decrypted attachment from the on-disk
+// cache if it is enabled and the attachment is there.
+func (store *Store) LoadCachedAttachment(attID string) ([]byte, bool) {
+	if !store.unlockCache(store.attCache, attCacheKeyBucket) {
+		return nil, false
+	}
+	return store.attCache.load(attID)
+}
+
+// SaveCachedAttachment stores the decrypted attachment to the on-disk cache
+// if it is enabled. Attachments are not removed together with their messages
+// because metadata do not list them; the least recently used are evicted.
+func (store *Store) SaveCachedAttachment(attID string, data []byte) {
+	if !store.unlockCache(store.attCache, attCacheKeyBucket) {
+		return
+	}
+	if err := store.attCache.save(attID, data, time.Now()); err != nil {
+		store.log.WithError(err).WithField("attID", attID).Warn("Cannot cache attachment")
+	}
+}
+
+// removeAttachmentCache removes all cached attachments and the key.
+func (store *Store) removeAttachmentCache() error {
+	if err := os.RemoveAll(GetAttachmentCacheDir(store.filePath)); err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(attCacheKeyBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAttachmentCacheDir(t *testing.T) {
+	require.Equal(t, filepath.Join("dir", "mailbox-userID-attachments"), GetAttachmentCacheDir(filepath.Join("dir", "mailbox-userID.db")))
+}
+
+func TestAttachmentCacheIsIndependentOfBodies(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	bc, cleanupBodyCache := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024})
+	defer cleanupBodyCache()
+	m.store.bodyCache = bc
+
+	ac, cleanupAttCache := newTestBodyCache(t, BodyCachePolicy{MaxSize: 4096})
+	defer cleanupAttCache()
+	m.store.attCache = ac
+
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		insertMessage(t, m, id, "Test message "+id, addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+		m.store.SaveCachedBody(id, getTestCachedBody(t, m, id, "body of "+id))
+	}
+	m.store.SaveCachedAttachment("att1", bytes.Repeat([]byte{'a'}, 2048))
+	m.store.SaveCachedAttachment("att2", bytes.Repeat([]byte{'b'}, 3000))
+
+	// Big attachment evicts only other attachments.
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		_, ok := m.store.LoadCachedBody(id)
+		require.True(t, ok, id)
+	}
+	_, ok := m.store.LoadCachedAttachment("att1")
+	require.False(t, ok)
+	data, ok := m.store.LoadCachedAttachment("att2")
+	require.True(t, ok)
+	require.Len(t, data, 3000)
+
+	usage, err := m.store.GetDiskUsage()
+	require.NoError(t, err)
+	require.Equal(t, 3, usage.CachedBodies)
+	require.Equal(t, 1, usage.CachedAttachments)
+	require.Equal(t, int64(4096), usage.AttachmentCacheLimit)
+
+	require.NoError(t, m.store.ClearBodyCache())
+	_, ok = m.store.LoadCachedAttachment("att2")
+	require.False(t, ok)
+}
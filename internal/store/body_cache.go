@@ -0,0 +1,886 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bodyCacheReencryptBatch is the number of files re-encrypted at once after
+// the key is rotated.
+const bodyCacheReencryptBatch = 100
+
+// Eviction policies deciding which bodies are removed first when the body
+// cache is full. Only bodies are evicted, metadata stay in the database.
+const (
+	// BodyCacheEvictLRU evicts the least recently used bodies.
+	BodyCacheEvictLRU = "lru"
+
+	// BodyCacheEvictOldest evicts bodies of the oldest messages, so recent
+	// mail stays available e.g. for offline use.
+	BodyCacheEvictOldest = "oldest"
+)
+
+// IsBodyCacheEviction returns whether `policy` is one of known eviction
+// policies.
+func IsBodyCacheEviction(policy string) bool {
+	return policy == BodyCacheEvictLRU || policy == BodyCacheEvictOldest
+}
+
+// BodyCachePolicy configures the on-disk cache of built message bodies. The
+// cache is used only when the policy is set in Options.
+type BodyCachePolicy struct {
+	// MaxSize is the maximal size of all cached bodies in bytes.
+	MaxSize int64
+
+	// Eviction decides which bodies are evicted when MaxSize is exceeded,
+	// see BodyCacheEvictLRU (the default) and BodyCacheEvictOldest.
+	Eviction string
+
+	// AttachmentMaxSize is the maximal size of all cached decrypted
+	// attachments in bytes. Attachments are evicted by LRU independently of
+	// bodies. Zero turns the attachment cache off.
+	AttachmentMaxSize int64
+}
+
+// DiskUsage describes how much disk space the store of one account takes.
+type DiskUsage struct {
+	DatabaseSize   int64
+	DatabaseFree   int64 // Space reclaimable by Compact.
+	BodyCacheSize  int64
+	CachedBodies   int
+	BodyCacheLimit int64 // Zero when the body cache is turned off.
+
+	AttachmentCacheSize  int64
+	CachedAttachments    int
+	AttachmentCacheLimit int64 // Zero when the attachment cache is turned off.
+}
+
+// bodyCache keeps built messages (as sent to IMAP clients) in files encrypted
+// by a locally held key, so repeated fetches do not download and decrypt them
+// again. The key itself is stored in the database encrypted by the primary
+// address key. Another instance keyed by attachment IDs keeps decrypted
+// attachments.
+//
+// Files are content-addressed: they are named by a keyed hash of the content,
+// so the same content cached under several IDs is stored only once. Index of
+// IDs and the number of IDs sharing each file are kept in the database next
+// to the key. Bodies are cached without the header which contains IDs of the
+// message, so copies of the same message (e.g. sent to several own addresses)
+// share the file; the header is built again from metadata when loading.
+type bodyCache struct {
+	dir    string
+	policy BodyCachePolicy
+	db     *storage
+	bucket []byte
+
+	lock   sync.Mutex
+	aead   cipher.AEAD
+	macKey []byte
+	size   int64
+
+	// Key replaced by rotation, used until all content is re-encrypted.
+	prevAEAD cipher.AEAD
+	rotating int32
+}
+
+func newBodyCache(dir string, policy BodyCachePolicy, db *storage, bucket []byte) *bodyCache {
+	return &bodyCache{
+		dir:    dir,
+		policy: policy,
+		db:     db,
+		bucket: bucket,
+	}
+}
+
+// GetBodyCacheDir returns the directory of cached bodies for the store
+// database on the path.
+func GetBodyCacheDir(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + "-bodies"
+}
+
+func (bc *bodyCache) isUnlocked() bool {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	return bc.aead != nil
+}
+
+// unlock makes the cache usable with the key. When the key is new, content
+// encrypted by the previous one is removed. Otherwise the index is reconciled
+// with files on disk: IDs of missing files are dropped and files no ID refers
+// to (e.g. left by an interrupted write) are removed.
+func (bc *bodyCache) unlock(key []byte, isNew bool) error {
+	aead, err := newLocalKeyAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if isNew {
+		if err := os.RemoveAll(bc.dir); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(bc.dir, 0700); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(bc.dir)
+	if err != nil {
+		return err
+	}
+	sizes := map[string]int64{}
+	for _, file := range files {
+		sizes[file.Name()] = file.Size()
+	}
+
+	refs := map[string]uint32{}
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		if isNew {
+			if err := bc.txDeleteIndex(tx); err != nil {
+				return err
+			}
+		}
+		ids, err := bc.txIndexBucket(tx, cacheIDsBucket)
+		if err != nil {
+			return err
+		}
+
+		var dangling [][]byte
+		if err := ids.ForEach(func(id, name []byte) error {
+			if _, ok := sizes[string(name)]; !ok {
+				dangling = append(dangling, copyBytes(id))
+				return nil
+			}
+			refs[string(name)]++
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, id := range dangling {
+			if err := ids.Delete(id); err != nil {
+				return err
+			}
+		}
+
+		// Counts are rebuilt from the IDs to fix them after a crash.
+		if err := tx.Bucket(bc.bucket).DeleteBucket(cacheRefsBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := bc.txIndexBucket(tx, cacheRefsBucket)
+		if err != nil {
+			return err
+		}
+		for name, count := range refs {
+			if err := b.Put([]byte(name), itob(count)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.size = 0
+	for name, size := range sizes {
+		if _, ok := refs[name]; !ok {
+			_ = os.Remove(filepath.Join(bc.dir, name))
+			continue
+		}
+		bc.size += size
+	}
+	bc.aead = aead
+	bc.macKey = getContentMACKey(key)
+
+	return nil
+}
+
+// getContentMACKey derives the key of content names from the local key.
+func getContentMACKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte("content address"))
+	return mac.Sum(nil)
+}
+
+// txIndexBucket returns the sub-bucket of the index, creating it if needed.
+func (bc *bodyCache) txIndexBucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(bc.bucket)
+	if err != nil {
+		return nil, err
+	}
+	return b.CreateBucketIfNotExists(name)
+}
+
+func (bc *bodyCache) txDeleteIndex(tx *bolt.Tx) error {
+	b := tx.Bucket(bc.bucket)
+	if b == nil {
+		return nil
+	}
+	for _, name := range [][]byte{cacheIDsBucket, cacheRefsBucket} {
+		if err := b.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// getContentName returns the name of the file for the content. The hash is
+// keyed, so names do not reveal what is cached.
+func (bc *bodyCache) getContentName(content []byte) string {
+	mac := hmac.New(sha256.New, bc.macKey)
+	_, _ = mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getPath returns the path of the file cached under the ID, or an empty string
+// when there is none.
+func (bc *bodyCache) getPath(id string) string {
+	var name []byte
+	_ = bc.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bc.bucket); b != nil {
+			if b = b.Bucket(cacheIDsBucket); b != nil {
+				name = copyBytes(b.Get([]byte(id)))
+			}
+		}
+		return nil
+	})
+	if len(name) == 0 {
+		return ""
+	}
+	return filepath.Join(bc.dir, string(name))
+}
+
+// load returns the content cached under the ID. Content which cannot be
+// decrypted is removed.
+func (bc *bodyCache) load(id string) ([]byte, bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.aead == nil {
+		return nil, false
+	}
+
+	path := bc.getPath(id)
+	if path == "" {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(path) //nolint[gosec]
+	if err != nil {
+		bc.unref([]string{id})
+		return nil, false
+	}
+
+	body, err := bc.open(data, filepath.Base(path))
+	if err != nil {
+		log.WithError(err).WithField("id", id).Warn("Cannot decrypt cached content")
+		bc.removeFile(path)
+		bc.unref([]string{id})
+		return nil, false
+	}
+
+	// Modification time decides eviction order. For LRU it is the last use,
+	// otherwise the time of the newest message set when saving.
+	if bc.policy.Eviction != BodyCacheEvictOldest {
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+	}
+
+	return body, true
+}
+
+// save stores the content under the ID of the message received at msgTime.
+// Content already cached under another ID is only referenced. Other content
+// is evicted if the cache is too big.
+func (bc *bodyCache) save(id string, body []byte, msgTime time.Time) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.aead == nil {
+		return errors.New("body cache is locked")
+	}
+
+	name := bc.getContentName(body)
+	path := filepath.Join(bc.dir, name)
+
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := bc.writeFile(path, body); err != nil {
+			return err
+		}
+		info = nil
+	case err != nil:
+		return err
+	}
+
+	if bc.policy.Eviction == BodyCacheEvictOldest && (info == nil || msgTime.After(info.ModTime())) {
+		if err := os.Chtimes(path, msgTime, msgTime); err != nil {
+			return err
+		}
+	}
+
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		ids, err := bc.txIndexBucket(tx, cacheIDsBucket)
+		if err != nil {
+			return err
+		}
+		if old := ids.Get([]byte(id)); old != nil {
+			if string(old) == name {
+				return nil
+			}
+			if err := bc.txUnref(tx, id); err != nil {
+				return err
+			}
+		}
+		if err := ids.Put([]byte(id), []byte(name)); err != nil {
+			return err
+		}
+		refs, err := bc.txIndexBucket(tx, cacheRefsBucket)
+		if err != nil {
+			return err
+		}
+		count := uint32(1)
+		if v := refs.Get([]byte(name)); v != nil {
+			count += btoi(v)
+		}
+		return refs.Put([]byte(name), itob(count))
+	})
+	if err != nil {
+		return err
+	}
+
+	if bc.size > bc.policy.MaxSize {
+		return bc.evict()
+	}
+	return nil
+}
+
+// open decrypts the content of the file with the name, by the previous key
+// too while it is being rotated.
+func (bc *bodyCache) open(data []byte, name string) ([]byte, error) {
+	err := errors.New("cached content is too short")
+	for _, aead := range []cipher.AEAD{bc.aead, bc.prevAEAD} {
+		if aead == nil || len(data) < aead.NonceSize() {
+			continue
+		}
+		var body []byte
+		if body, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name)); err == nil {
+			return body, nil
+		}
+	}
+	return nil, err
+}
+
+func (bc *bodyCache) writeFile(path string, body []byte) error {
+	nonce := make([]byte, bc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := bc.aead.Seal(nonce, nonce, body, []byte(filepath.Base(path)))
+
+	// Write to temporary file first so unfinished content is never loaded.
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	bc.size += int64(len(data))
+	return nil
+}
+
+// remove deletes the IDs from the cache. Content is removed once no other ID
+// refers to it.
+func (bc *bodyCache) remove(ids []string) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if bc.aead == nil {
+		return
+	}
+
+	bc.unref(ids)
+}
+
+func (bc *bodyCache) unref(ids []string) {
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := bc.txUnref(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Warn("Cannot remove IDs from cache index")
+	}
+}
+
+// txUnref removes the ID from the index and its content when it was the
+// last reference.
+func (bc *bodyCache) txUnref(tx *bolt.Tx, id string) error {
+	ids, err := bc.txIndexBucket(tx, cacheIDsBucket)
+	if err != nil {
+		return err
+	}
+	name := copyBytes(ids.Get([]byte(id)))
+	if len(name) == 0 {
+		return nil
+	}
+	if err := ids.Delete([]byte(id)); err != nil {
+		return err
+	}
+
+	refs, err := bc.txIndexBucket(tx, cacheRefsBucket)
+	if err != nil {
+		return err
+	}
+	if v := refs.Get(name); v != nil && btoi(v) > 1 {
+		return refs.Put(name, itob(btoi(v)-1))
+	}
+	bc.removeFile(filepath.Join(bc.dir, string(name)))
+	return refs.Delete(name)
+}
+
+func (bc *bodyCache) removeFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.WithError(err).Warn("Cannot remove cached content")
+		return
+	}
+	bc.size -= info.Size()
+}
+
+// evict removes content with the oldest modification time until the cache
+// fits into nine tenths of the limit, so not every save has to evict again.
+// IDs referring to the evicted content are removed from the index.
+func (bc *bodyCache) evict() error {
+	files, err := ioutil.ReadDir(bc.dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	target := bc.policy.MaxSize / 10 * 9
+	evicted := map[string]bool{}
+	for _, file := range files {
+		if bc.size <= target {
+			break
+		}
+		bc.removeFile(filepath.Join(bc.dir, file.Name()))
+		evicted[file.Name()] = true
+	}
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		ids, err := bc.txIndexBucket(tx, cacheIDsBucket)
+		if err != nil {
+			return err
+		}
+		var dangling [][]byte
+		if err := ids.ForEach(func(id, name []byte) error {
+			if evicted[string(name)] {
+				dangling = append(dangling, copyBytes(id))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, id := range dangling {
+			if err := ids.Delete(id); err != nil {
+				return err
+			}
+		}
+
+		refs, err := bc.txIndexBucket(tx, cacheRefsBucket)
+		if err != nil {
+			return err
+		}
+		for name := range evicted {
+			if err := refs.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setKeys switches the cache to the key after rotation. Content encrypted by
+// the previous key stays readable until reencrypt moves it.
+func (bc *bodyCache) setKeys(key, prevKey []byte) error {
+	aead, err := newLocalKeyAEAD(key)
+	if err != nil {
+		return err
+	}
+	prevAEAD, err := newLocalKeyAEAD(prevKey)
+	if err != nil {
+		return err
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.aead, bc.prevAEAD, bc.macKey = aead, prevAEAD, getContentMACKey(key)
+	return nil
+}
+
+// reencrypt moves content encrypted by the previous key to files named and
+// encrypted by the current one. It works in batches so the cache stays
+// usable in between; new content is written with the current key right
+// away. Only one re-encryption runs at a time, false is returned when
+// another one is already running.
+func (bc *bodyCache) reencrypt() (bool, error) {
+	if !atomic.CompareAndSwapInt32(&bc.rotating, 0, 1) {
+		return false, nil
+	}
+	defer atomic.StoreInt32(&bc.rotating, 0)
+
+	idsByName := map[string][]string{}
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bc.bucket)
+		if b == nil {
+			return nil
+		}
+		if b = b.Bucket(cacheIDsBucket); b == nil {
+			return nil
+		}
+		return b.ForEach(func(id, name []byte) error {
+			idsByName[string(name)] = append(idsByName[string(name)], string(id))
+			return nil
+		})
+	})
+	if err != nil {
+		return true, err
+	}
+
+	names := make([]string, 0, len(idsByName))
+	for name := range idsByName {
+		names = append(names, name)
+	}
+	for len(names) > 0 {
+		n := bodyCacheReencryptBatch
+		if n > len(names) {
+			n = len(names)
+		}
+		if err := bc.reencryptBatch(names[:n], idsByName); err != nil {
+			return true, err
+		}
+		names = names[n:]
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.prevAEAD = nil
+	return true, nil
+}
+
+func (bc *bodyCache) reencryptBatch(names []string, idsByName map[string][]string) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		ids, err := bc.txIndexBucket(tx, cacheIDsBucket)
+		if err != nil {
+			return err
+		}
+		refs, err := bc.txIndexBucket(tx, cacheRefsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			path := filepath.Join(bc.dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // Evicted meanwhile.
+			}
+			data, err := ioutil.ReadFile(path) //nolint[gosec]
+			if err != nil {
+				return err
+			}
+			body, err := bc.open(data, name)
+			if err != nil {
+				continue // Removed by the next load.
+			}
+
+			newName := bc.getContentName(body)
+			if newName == name {
+				continue // Written by the current key already.
+			}
+
+			newPath := filepath.Join(bc.dir, newName)
+			if _, err := os.Stat(newPath); os.IsNotExist(err) {
+				if err := bc.writeFile(newPath, body); err != nil {
+					return err
+				}
+				// Keep the eviction order.
+				if err := os.Chtimes(newPath, info.ModTime(), info.ModTime()); err != nil {
+					return err
+				}
+			}
+
+			count := uint32(0)
+			if v := refs.Get([]byte(newName)); v != nil {
+				count = btoi(v)
+			}
+			for _, id := range idsByName[name] {
+				if string(ids.Get([]byte(id))) != name {
+					continue // Changed meanwhile.
+				}
+				if err := ids.Put([]byte(id), []byte(newName)); err != nil {
+					return err
+				}
+				count++
+			}
+			if count > 0 {
+				if err := refs.Put([]byte(newName), itob(count)); err != nil {
+					return err
+				}
+			}
+			if err := refs.Delete([]byte(name)); err != nil {
+				return err
+			}
+			bc.removeFile(path)
+		}
+		return nil
+	})
+}
+
+// usage returns the number of cached IDs and the total size of the cache.
+func (bc *bodyCache) usage() (count int, size int64, err error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	files, err := ioutil.ReadDir(bc.dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bc.bucket)
+		if b == nil {
+			return nil
+		}
+		if b = b.Bucket(cacheIDsBucket); b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, _ []byte) error {
+			count++
+			return nil
+		})
+	})
+	return count, size, err
+}
+
+// clear removes all cached content but keeps the cache usable.
+func (bc *bodyCache) clear() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	if err := os.RemoveAll(bc.dir); err != nil {
+		return err
+	}
+	bc.size = 0
+
+	if err := bc.db.Update(bc.txDeleteIndex); err != nil {
+		return err
+	}
+
+	if bc.aead == nil {
+		return nil
+	}
+	return os.MkdirAll(bc.dir, 0700)
+}
+
+// LoadCachedBody returns the built body of the message from the on-disk
+// cache if it is enabled and the body is there.
+func (store *Store) LoadCachedBody(apiID string) ([]byte, bool) {
+	if !store.unlockBodyCache() {
+		return nil, false
+	}
+	content, ok := store.bodyCache.load(apiID)
+	if !ok {
+		return nil, false
+	}
+	header, _, err := store.getBuiltHeader(apiID)
+	if err != nil {
+		return nil, false
+	}
+	return append(header, content...), true
+}
+
+// SaveCachedBody stores the built body of the message to the on-disk cache
+// if it is enabled. Only the content after the header is stored. Body whose
+// header does not match the one built from metadata is not cached.
+func (store *Store) SaveCachedBody(apiID string, body []byte) {
+	if !store.unlockBodyCache() {
+		return
+	}
+	header, msg, err := store.getBuiltHeader(apiID)
+	if err != nil {
+		store.log.WithError(err).WithField("msgID", apiID).Warn("Cannot cache body")
+		return
+	}
+	if !bytes.HasPrefix(body, header) {
+		store.log.WithField("msgID", apiID).Debug("Not caching body with header different from metadata")
+		return
+	}
+
+	if err := store.bodyCache.save(apiID, body[len(header):], time.Unix(msg.Time, 0)); err != nil {
+		store.log.WithError(err).WithField("msgID", apiID).Warn("Cannot cache body")
+	}
+}
+
+// getBuiltHeader returns the header of the message as written by the builder
+// at the beginning of the built body, together with the message metadata.
+func (store *Store) getBuiltHeader(apiID string) ([]byte, *pmapi.Message, error) {
+	msg, err := store.getMessageFromDB(apiID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var b bytes.Buffer
+	if err := pkgMsg.WriteHeader(&b, pkgMsg.GetHeader(msg)); err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), msg, nil
+}
+
+// unlockBodyCache returns whether the body cache is enabled and ready to be
+// used, unlocking it first if needed.
+func (store *Store) unlockBodyCache() bool {
+	return store.unlockCache(store.bodyCache, bodyCacheKeyBucket)
+}
+
+// unlockCache returns whether the cache is enabled and ready to be used,
+// unlocking it with the local key stored in keyBucket first if needed.
+func (store *Store) unlockCache(bc *bodyCache, keyBucket []byte) bool {
+	if bc == nil {
+		return false
+	}
+	if bc.isUnlocked() {
+		return true
+	}
+
+	key, isNew, err := store.getLocalKey(keyBucket)
+	if err != nil {
+		store.log.WithError(err).WithField("cache", bc.dir).Warn("Cache is not available")
+		return false
+	}
+
+	if err := bc.unlock(key, isNew); err != nil {
+		store.log.WithError(err).WithField("cache", bc.dir).Warn("Cannot open cache")
+		return false
+	}
+
+	store.resumeCacheKeyRotation(bc, keyBucket, key)
+
+	return true
+}
+
+// GetDiskUsage returns the size of the database and the body cache.
+func (store *Store) GetDiskUsage() (usage DiskUsage, err error) {
+	if _, err = os.Stat(store.filePath); err != nil {
+		return usage, err
+	}
+	usage.DatabaseSize = getDatabaseSize(store.filePath)
+
+	if usage.DatabaseFree, err = store.db.FreeSize(); err != nil {
+		return usage, err
+	}
+
+	if store.bodyCache != nil {
+		usage.BodyCacheLimit = store.bodyCache.policy.MaxSize
+		if usage.CachedBodies, usage.BodyCacheSize, err = store.bodyCache.usage(); err != nil {
+			return usage, err
+		}
+	}
+
+	if store.attCache != nil {
+		usage.AttachmentCacheLimit = store.attCache.policy.MaxSize
+		if usage.CachedAttachments, usage.AttachmentCacheSize, err = store.attCache.usage(); err != nil {
+			return usage, err
+		}
+	}
+
+	return usage, nil
+}
+
+// ClearBodyCache removes all cached bodies and attachments. Metadata are
+// kept, so bodies are only downloaded again when requested.
+func (store *Store) ClearBodyCache() error {
+	if store.bodyCache != nil {
+		if err := store.bodyCache.clear(); err != nil {
+			return err
+		}
+	}
+	if store.attCache != nil {
+		return store.attCache.clear()
+	}
+	return nil
+}
+
+// removeBodyCache removes all cached bodies and the key.
+func (store *Store) removeBodyCache() error {
+	if err := os.RemoveAll(GetBodyCacheDir(store.filePath)); err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bodyCacheKeyBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
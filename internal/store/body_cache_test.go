@@ -0,0 +1,341 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBodyCache(t *testing.T, policy BodyCachePolicy) (*bodyCache, func()) {
+	dir, err := ioutil.TempDir("", "body-cache-test")
+	require.NoError(t, err)
+
+	db, err := openStorage(filepath.Join(dir, "mailbox-test.db"))
+	require.NoError(t, err)
+
+	bc := newBodyCache(filepath.Join(dir, "mailbox-test-bodies"), policy, db, bodyCacheKeyBucket)
+	require.NoError(t, bc.unlock(bytes.Repeat([]byte{1}, localKeySize), false))
+
+	return bc, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// getTestCachedBody returns the body of the message in the database with the
+// header written by the builder followed by the content.
+func getTestCachedBody(t *testing.T, m *mocksForStore, apiID, content string) []byte {
+	header, _, err := m.store.getBuiltHeader(apiID)
+	require.NoError(t, err)
+	return append(header, content...)
+}
+
+func TestGetBodyCacheDir(t *testing.T) {
+	require.Equal(t, filepath.Join("dir", "mailbox-userID-bodies"), GetBodyCacheDir(filepath.Join("dir", "mailbox-userID.db")))
+}
+
+func TestBodyCacheSaveLoadRemove(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	_, ok := bc.load("msg1")
+	require.False(t, ok)
+
+	require.NoError(t, bc.save("msg1", []byte("Subject: secret\r\n\r\nbody"), time.Now()))
+
+	body, ok := bc.load("msg1")
+	require.True(t, ok)
+	require.Equal(t, "Subject: secret\r\n\r\nbody", string(body))
+
+	path := bc.getPath("msg1")
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "secret")
+
+	bc.remove([]string{"msg1"})
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	_, ok = bc.load("msg1")
+	require.False(t, ok)
+	require.Equal(t, int64(0), bc.size)
+}
+
+func TestBodyCacheWrongKey(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	require.NoError(t, bc.save("msg1", []byte("body"), time.Now()))
+
+	// Body encrypted by other key is not loaded and removed.
+	path := bc.getPath("msg1")
+	other := newBodyCache(bc.dir, bc.policy, bc.db, bc.bucket)
+	require.NoError(t, other.unlock(bytes.Repeat([]byte{2}, localKeySize), false))
+	_, ok := other.load("msg1")
+	require.False(t, ok)
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, "", bc.getPath("msg1"))
+}
+
+func TestBodyCacheNewKeyClears(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	require.NoError(t, bc.save("msg1", []byte("body"), time.Now()))
+
+	require.NoError(t, bc.unlock(bytes.Repeat([]byte{2}, localKeySize), true))
+	require.Equal(t, int64(0), bc.size)
+	_, ok := bc.load("msg1")
+	require.False(t, ok)
+}
+
+func TestBodyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// Each cached body takes 128 bytes with nonce and tag.
+	body := func(apiID string) []byte { return bytes.Repeat([]byte(apiID), 25) }
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 450, Eviction: BodyCacheEvictLRU})
+	defer cleanup()
+
+	old := time.Now().Add(-time.Hour)
+	for i, apiID := range []string{"msg1", "msg2", "msg3"} {
+		require.NoError(t, bc.save(apiID, body(apiID), time.Now()))
+		modTime := old.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(bc.getPath(apiID), modTime, modTime))
+	}
+
+	// Loading msg1 makes it the most recently used.
+	_, ok := bc.load("msg1")
+	require.True(t, ok)
+
+	require.NoError(t, bc.save("msg4", body("msg4"), time.Now()))
+	require.LessOrEqual(t, bc.size, int64(450))
+
+	for apiID, cached := range map[string]bool{"msg1": true, "msg2": false, "msg3": true, "msg4": true} {
+		_, ok := bc.load(apiID)
+		require.Equal(t, cached, ok, apiID)
+	}
+}
+
+func TestBodyCacheEvictsOldestMessages(t *testing.T) {
+	body := func(apiID string) []byte { return bytes.Repeat([]byte(apiID), 25) }
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 450, Eviction: BodyCacheEvictOldest})
+	defer cleanup()
+
+	now := time.Now()
+	require.NoError(t, bc.save("msg1", body("msg1"), now.Add(-3*time.Hour)))
+	require.NoError(t, bc.save("msg2", body("msg2"), now.Add(-time.Hour)))
+	require.NoError(t, bc.save("msg3", body("msg3"), now.Add(-2*time.Hour)))
+
+	// Loading does not matter, the oldest message is evicted.
+	_, ok := bc.load("msg1")
+	require.True(t, ok)
+
+	require.NoError(t, bc.save("msg4", body("msg4"), now))
+
+	for apiID, cached := range map[string]bool{"msg1": false, "msg2": true, "msg3": true, "msg4": true} {
+		_, ok := bc.load(apiID)
+		require.Equal(t, cached, ok, apiID)
+	}
+}
+
+func TestBodyCacheUsageAndClear(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	require.NoError(t, bc.save("msg1", []byte("body1"), time.Now()))
+	require.NoError(t, bc.save("msg2", []byte("body2"), time.Now()))
+
+	count, size, err := bc.usage()
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, bc.size, size)
+
+	require.NoError(t, bc.clear())
+	count, size, err = bc.usage()
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+	require.Equal(t, int64(0), size)
+
+	// Cache is still usable after clearing.
+	require.NoError(t, bc.save("msg1", []byte("body1"), time.Now()))
+	_, ok := bc.load("msg1")
+	require.True(t, ok)
+}
+
+func TestBodyCacheUnlockRemovesUnreferencedFiles(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	require.NoError(t, bc.save("msg1", []byte("body1"), time.Now()))
+	require.NoError(t, bc.save("msg2", []byte("body2"), time.Now()))
+
+	// File left by old layout and index entry of a lost file.
+	leftover := filepath.Join(bc.dir, "abcd")
+	require.NoError(t, ioutil.WriteFile(leftover, []byte("body"), 0600))
+	require.NoError(t, os.Remove(bc.getPath("msg2")))
+
+	other := newBodyCache(bc.dir, bc.policy, bc.db, bc.bucket)
+	require.NoError(t, other.unlock(bytes.Repeat([]byte{1}, localKeySize), false))
+
+	_, err := os.Stat(leftover)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, "", other.getPath("msg2"))
+
+	count, size, err := other.usage()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, other.size, size)
+
+	_, ok := other.load("msg1")
+	require.True(t, ok)
+}
+
+func TestBodyCacheRotateKey(t *testing.T) {
+	bc, cleanup := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanup()
+
+	oldKey, newKey := bytes.Repeat([]byte{1}, localKeySize), bytes.Repeat([]byte{2}, localKeySize)
+	require.NoError(t, bc.save("msg1", []byte("same body"), time.Now()))
+	require.NoError(t, bc.save("msg2", []byte("same body"), time.Now()))
+	require.NoError(t, bc.save("msg3", []byte("diff body"), time.Now()))
+	oldPath := bc.getPath("msg1")
+
+	// Content of the previous key is readable before it is re-encrypted.
+	require.NoError(t, bc.setKeys(newKey, oldKey))
+	body, ok := bc.load("msg1")
+	require.True(t, ok)
+	require.Equal(t, "same body", string(body))
+
+	// New content shared with old one is moved to a single file.
+	require.NoError(t, bc.save("msg4", []byte("same body"), time.Now()))
+
+	done, err := bc.reencrypt()
+	require.NoError(t, err)
+	require.True(t, done)
+
+	_, err = os.Stat(oldPath)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, bc.getPath("msg1"), bc.getPath("msg4"))
+
+	// Nothing is left for the previous key.
+	other := newBodyCache(bc.dir, bc.policy, bc.db, bc.bucket)
+	require.NoError(t, other.unlock(newKey, false))
+	for apiID, expected := range map[string]string{"msg1": "same body", "msg2": "same body", "msg3": "diff body", "msg4": "same body"} {
+		body, ok := other.load(apiID)
+		require.True(t, ok, apiID)
+		require.Equal(t, expected, string(body))
+	}
+
+	count, size, err := other.usage()
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+	require.Equal(t, bc.size, size)
+	require.Equal(t, other.size, size)
+}
+
+func TestBodyCacheSharesContentOfCopies(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	bc, cleanupBodyCache := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	defer cleanupBodyCache()
+	m.store.bodyCache = bc
+
+	key, err := crypto.GenerateKey("Test", addr1, "x25519", 0)
+	require.NoError(t, err)
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	// The same message delivered to two addresses, each copy with own ID
+	// and encrypted separately, built as IMAP builds messages without
+	// attachments.
+	bodies := map[string][]byte{}
+	for id, addressID := range map[string]string{"msg1": addrID1, "msg2": addrID2} {
+		encrypted, err := kr.Encrypt(crypto.NewPlainMessageFromString("Hello, this is the body."), nil)
+		require.NoError(t, err)
+		armored, err := encrypted.GetArmored()
+		require.NoError(t, err)
+
+		msg := &pmapi.Message{
+			ID:             id,
+			AddressID:      addressID,
+			ConversationID: "conv-" + id,
+			Subject:        "Hello",
+			Sender:         &mail.Address{Address: "sender@example.com"},
+			ToList:         []*mail.Address{{Address: addr1}, {Address: addr2}},
+			LabelIDs:       []string{pmapi.AllMailLabel, pmapi.InboxLabel},
+			Time:           1600000000,
+			MIMEType:       "text/plain",
+			ExternalID:     "hello@example.com",
+			Body:           armored,
+		}
+		header := textproto.MIMEHeader{}
+		pkgMsg.SetBodyContentFields(&header, msg)
+		msg.Header = mail.Header(header)
+		require.NoError(t, m.store.createOrUpdateMessageEvent(msg))
+
+		var b bytes.Buffer
+		require.NoError(t, pkgMsg.WriteHeader(&b, pkgMsg.GetHeader(msg)))
+		_, _ = b.WriteString("\r\n")
+		require.NoError(t, pkgMsg.WriteBody(&b, kr, msg))
+
+		bodies[id] = b.Bytes()
+		m.store.SaveCachedBody(id, bodies[id])
+	}
+	require.NotEqual(t, bodies["msg1"], bodies["msg2"])
+
+	require.Equal(t, bc.getPath("msg1"), bc.getPath("msg2"))
+	count, _, err := bc.usage()
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	files, err := ioutil.ReadDir(bc.dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	for id, want := range bodies {
+		body, ok := m.store.LoadCachedBody(id)
+		require.True(t, ok, id)
+		require.Equal(t, string(want), string(body), id)
+	}
+
+	// Shared content stays until the last message is removed.
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+	_, ok := m.store.LoadCachedBody("msg1")
+	require.False(t, ok)
+	body, ok := m.store.LoadCachedBody("msg2")
+	require.True(t, ok)
+	require.Equal(t, string(bodies["msg2"]), string(body))
+
+	require.NoError(t, m.store.deleteMessageEvent("msg2"))
+	files, err = ioutil.ReadDir(bc.dir)
+	require.NoError(t, err)
+	require.Len(t, files, 0)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"github.com/ProtonMail/proton-bridge/pkg/changes"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	bolt "go.etcd.io/bbolt"
+)
+
+// GetChangeJournalPath returns the path of the journal of mailbox changes of
+// the store on the path. The journal is not locked with the database, so it
+// can be read while Bridge is running, see package changes.
+func GetChangeJournalPath(path string) string {
+	return path + "-changes.jsonl"
+}
+
+// openChangeJournal opens the journal of mailbox changes. The store works
+// without the journal if it cannot be opened.
+func (store *Store) openChangeJournal() {
+	journal, err := changes.OpenWriter(GetChangeJournalPath(store.filePath))
+	if err != nil {
+		store.log.WithError(err).Warn("Cannot open change journal")
+		return
+	}
+	store.changeJournal = journal
+}
+
+// recordChange appends the change to the change journal. Failure is only
+// logged, the journal must not stop changes of the store.
+func (store *Store) recordChange(change *changes.Change) {
+	if store.changeJournal == nil {
+		return
+	}
+	if err := store.changeJournal.Append(change); err != nil {
+		store.log.WithError(err).Warn("Cannot record change")
+	}
+}
+
+// txRecordFlagsChange records the change of IMAP flags of the stored message
+// in the labels it stays in. Added and removed labels are recorded by
+// mailboxes.
+func (store *Store) txRecordFlagsChange(metaBucket *bolt.Bucket, msg *pmapi.Message) {
+	if store.changeJournal == nil {
+		return
+	}
+
+	stored, err := store.txGetMessageFromBucket(metaBucket, msg.ID)
+	if err != nil {
+		return
+	}
+
+	flags := message.GetFlags(msg)
+	if equalFlags(message.GetFlags(stored), flags) {
+		return
+	}
+
+	for _, labelID := range msg.LabelIDs {
+		if skipThisLabel(labelID) || store.isLabelIDExcluded(labelID) || !stored.HasLabelID(labelID) {
+			continue
+		}
+		store.recordChange(&changes.Change{
+			Type:      changes.Flags,
+			AddressID: msg.AddressID,
+			LabelID:   labelID,
+			MessageID: msg.ID,
+			Flags:     flags,
+		})
+	}
+}
+
+func equalFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/changes"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+type testChange struct {
+	Type, LabelID, MessageID string
+}
+
+func readTestChanges(t *testing.T, m *mocksForStore, since uint64) (found []testChange, last uint64) {
+	all, err := changes.Read(GetChangeJournalPath(m.store.filePath), changes.Filter{Since: since})
+	require.NoError(t, err)
+	for _, change := range all {
+		found = append(found, testChange{change.Type, change.LabelID, change.MessageID})
+		last = change.Seq
+	}
+	return found, last
+}
+
+func TestChangeJournal(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	found, last := readTestChanges(t, m, 0)
+	require.ElementsMatch(t, []testChange{
+		{changes.Added, pmapi.AllMailLabel, "msg1"},
+		{changes.Added, pmapi.InboxLabel, "msg1"},
+	}, found)
+
+	// Update without change of flags is not recorded.
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	found, _ = readTestChanges(t, m, last)
+	require.Empty(t, found)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	found, last = readTestChanges(t, m, last)
+	require.ElementsMatch(t, []testChange{
+		{changes.Flags, pmapi.AllMailLabel, "msg1"},
+		{changes.Flags, pmapi.InboxLabel, "msg1"},
+	}, found)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel})
+	found, last = readTestChanges(t, m, last)
+	require.ElementsMatch(t, []testChange{
+		{changes.Removed, pmapi.InboxLabel, "msg1"},
+		{changes.Added, pmapi.ArchiveLabel, "msg1"},
+	}, found)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+	found, _ = readTestChanges(t, m, last)
+	require.ElementsMatch(t, []testChange{
+		{changes.Removed, pmapi.AllMailLabel, "msg1"},
+		{changes.Removed, pmapi.ArchiveLabel, "msg1"},
+	}, found)
+}
@@ -0,0 +1,637 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// CheckProblem is one integrity problem found by CheckStore.
+type CheckProblem struct {
+	Mailbox     string // Empty for problems of the whole store.
+	Description string
+
+	// Repaired is set when the problem was fixed in the database. Some
+	// problems are fixed only by the sync (NeedsResync), e.g. when the
+	// metadata of a message is missing.
+	Repaired    bool
+	NeedsResync bool
+}
+
+// CheckResult is the outcome of CheckStore.
+type CheckResult struct {
+	Messages  int
+	Mailboxes int
+	Problems  []*CheckProblem
+
+	// ResyncScheduled is set when the repair scheduled a sync, which runs
+	// next time the store is opened.
+	ResyncScheduled bool
+}
+
+// CheckStore checks integrity of the store database on the path and of its
+// body cache: UIDs are below UIDNEXT, mailbox indexes refer to each other and
+// to the metadata, mailboxes contain the messages the metadata says they
+// should, no data is left behind deleted messages and local counts match
+// the counts on API. With repair, problems are fixed where the database
+// allows it and a sync is scheduled for the rest. The store must not be
+// opened by running Bridge.
+func CheckStore(path string, repair bool) (*CheckResult, error) {
+	db, err := openStorage(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	c := &storeChecker{
+		repair: repair,
+		result: &CheckResult{},
+	}
+
+	run := db.View
+	if repair {
+		run = db.Update
+	}
+	if err := run(c.txCheck); err != nil {
+		return nil, err
+	}
+
+	err = run(func(tx *bolt.Tx) error {
+		return c.txCheckBodyCache(tx, GetBodyCacheDir(path))
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check body cache")
+	}
+
+	return c.result, nil
+}
+
+type storeChecker struct {
+	repair bool
+	result *CheckResult
+
+	// messages are labels and address of messages in the metadata.
+	messages map[string]*checkedMessage
+
+	// totals are numbers of messages in mailboxes per label ID.
+	totals map[string]uint
+}
+
+type checkedMessage struct {
+	addressID string
+	labelIDs  []string
+}
+
+func (c *storeChecker) report(mailbox string, needsResync bool, format string, args ...interface{}) {
+	c.result.Problems = append(c.result.Problems, &CheckProblem{
+		Mailbox:     mailbox,
+		Description: fmt.Sprintf(format, args...),
+		Repaired:    c.repair,
+		NeedsResync: needsResync,
+	})
+}
+
+func (c *storeChecker) needsResync() bool {
+	for _, problem := range c.result.Problems {
+		if problem.NeedsResync {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *storeChecker) txCheck(tx *bolt.Tx) error {
+	for _, name := range [][]byte{metadataBucket, mailboxesBucket, addressInfoBucket} {
+		if tx.Bucket(name) == nil {
+			return errors.Errorf("missing bucket %s, not a store database", name)
+		}
+	}
+
+	if err := c.txCheckMetadata(tx); err != nil {
+		return err
+	}
+
+	if err := c.txCheckMailboxes(tx); err != nil {
+		return err
+	}
+
+	for _, name := range [][]byte{bodystructureBucket, searchIndexBucket} {
+		if err := c.txCheckOrphanedEntries(tx, name); err != nil {
+			return err
+		}
+	}
+
+	if err := c.txCheckCounts(tx); err != nil {
+		return err
+	}
+
+	if c.repair && c.needsResync() {
+		if err := txScheduleResync(tx); err != nil {
+			return errors.Wrap(err, "failed to schedule sync")
+		}
+		c.result.ResyncScheduled = true
+	}
+
+	return nil
+}
+
+// txCheckMetadata loads labels of all messages. Metadata which cannot be
+// parsed are removed and downloaded again by the sync.
+func (c *storeChecker) txCheckMetadata(tx *bolt.Tx) error {
+	c.messages = map[string]*checkedMessage{}
+
+	var broken [][]byte
+	err := tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+		var msg struct {
+			AddressID string
+			LabelIDs  []string
+		}
+		if v == nil || json.Unmarshal(v, &msg) != nil {
+			broken = append(broken, copyBytes(k))
+			return nil
+		}
+		c.messages[string(k)] = &checkedMessage{addressID: msg.AddressID, labelIDs: msg.LabelIDs}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.result.Messages = len(c.messages)
+
+	for _, apiID := range broken {
+		c.report("", true, "metadata of message %s cannot be parsed", apiID)
+		if c.repair {
+			if err := tx.Bucket(metadataBucket).Delete(apiID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkedMailbox is the mailbox bucket together with the owner address.
+type checkedMailbox struct {
+	name       string
+	bucketName []byte
+	addressID  string
+	labelID    string
+	isCombined bool
+}
+
+func (c *storeChecker) txCheckMailboxes(tx *bolt.Tx) error {
+	addresses, isCombined, err := txGetActiveAddresses(tx)
+	if err != nil {
+		return err
+	}
+
+	labelNames := map[string]string{}
+	if counts, err := txGetAllCounts(tx); err == nil {
+		for _, mbCounts := range counts {
+			labelNames[mbCounts.LabelID] = mbCounts.LabelName
+		}
+	}
+
+	var mailboxes []*checkedMailbox
+	var stale [][]byte
+
+	err = tx.Bucket(mailboxesBucket).ForEach(func(k, _ []byte) error {
+		for _, address := range addresses {
+			if !strings.HasPrefix(string(k), address.AddressID+"-") {
+				continue
+			}
+			labelID := strings.TrimPrefix(string(k), address.AddressID+"-")
+
+			name := getCheckedMailboxName(labelID, labelNames)
+			if len(addresses) > 1 {
+				name += " (" + address.Address + ")"
+			}
+
+			mailboxes = append(mailboxes, &checkedMailbox{
+				name:       name,
+				bucketName: copyBytes(k),
+				addressID:  address.AddressID,
+				labelID:    labelID,
+				isCombined: isCombined,
+			})
+			return nil
+		}
+		stale = append(stale, copyBytes(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.result.Mailboxes = len(mailboxes)
+
+	for _, bucketName := range stale {
+		c.report("", false, "mailbox %s does not belong to any address", bucketName)
+		if c.repair {
+			if err := tx.Bucket(mailboxesBucket).DeleteBucket(bucketName); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.totals = map[string]uint{}
+	for _, mailbox := range mailboxes {
+		total, err := c.txCheckMailbox(tx, mailbox)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check mailbox %s", mailbox.name)
+		}
+		c.totals[mailbox.labelID] += total
+	}
+
+	return nil
+}
+
+// getCheckedMailboxName returns the name of the mailbox used in reports.
+func getCheckedMailboxName(labelID string, labelNames map[string]string) string {
+	if IsVirtualLabel(labelID) {
+		return VirtualMailboxesPrefix + strings.TrimPrefix(strings.TrimPrefix(labelID, virtualSavedSearchPrefix), virtualLabelPrefix)
+	}
+	if name := labelNames[labelID]; name != "" {
+		return name
+	}
+	if name := getSystemFolderName(labelID); name != "" {
+		return name
+	}
+	return labelID
+}
+
+// txGetActiveAddresses returns addresses which have mailboxes in the current
+// address mode.
+func txGetActiveAddresses(tx *bolt.Tx) (addresses []AddressInfo, isCombined bool, err error) {
+	if addresses, err = txGetAllAddresses(tx); err != nil {
+		return nil, false, err
+	}
+
+	if b := tx.Bucket(addressModeBucket); b != nil {
+		isCombined = addressMode(b.Get([]byte(modeKey))) != splitMode
+	}
+	if isCombined && len(addresses) > 1 {
+		addresses = addresses[:1]
+	}
+
+	return addresses, isCombined, nil
+}
+
+func txGetAllCounts(tx *bolt.Tx) (counts []*mailboxCounts, err error) {
+	b := tx.Bucket(countsBucket)
+	if b == nil {
+		return nil, nil
+	}
+
+	err = b.ForEach(func(k, v []byte) error {
+		mbCounts := &mailboxCounts{}
+		if err := json.Unmarshal(v, mbCounts); err != nil {
+			return err
+		}
+		counts = append(counts, mbCounts)
+		return nil
+	})
+	return counts, err
+}
+
+// txCheckMailbox checks the mailbox and returns the number of its messages
+// after the repair.
+func (c *storeChecker) txCheckMailbox(tx *bolt.Tx, mailbox *checkedMailbox) (uint, error) { //nolint[funlen]
+	b := tx.Bucket(mailboxesBucket).Bucket(mailbox.bucketName)
+
+	for _, name := range [][]byte{imapIDsBucket, apiIDsBucket, deletedIDsBucket} {
+		if b.Bucket(name) != nil {
+			continue
+		}
+		c.report(mailbox.name, true, "missing bucket %s", name)
+		if !c.repair {
+			return 0, nil
+		}
+		if _, err := b.CreateBucketIfNotExists(name); err != nil {
+			return 0, err
+		}
+	}
+
+	imapIDs, apiIDs, deletedIDs := b.Bucket(imapIDsBucket), b.Bucket(apiIDsBucket), b.Bucket(deletedIDsBucket)
+
+	// UIDs are checked first, so messages added by the repair get new UIDs
+	// above all existing ones.
+	if k, _ := imapIDs.Cursor().Last(); len(k) == 4 && uint64(btoi(k)) > imapIDs.Sequence() {
+		c.report(mailbox.name, false, "UID %d is above UIDNEXT %d", btoi(k), imapIDs.Sequence()+1)
+		if c.repair {
+			if err := imapIDs.SetSequence(uint64(btoi(k))); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var removeUIDs, removeAPIIDs, removeDeletedIDs [][]byte
+	members := map[string]bool{}
+	removed := map[string]bool{} // Removed API ID entries.
+	reported := map[string]bool{}
+
+	err := imapIDs.ForEach(func(uidb, apiID []byte) error {
+		switch {
+		case len(uidb) != 4 || apiID == nil:
+			c.report(mailbox.name, false, "invalid UID entry %x", uidb)
+		case string(apiIDs.Get(apiID)) != string(uidb):
+			c.report(mailbox.name, false, "UID %d of message %s has no matching API ID entry", btoi(uidb), apiID)
+			reported[string(apiID)] = true
+		case c.messages[string(apiID)] == nil:
+			c.report(mailbox.name, true, "message %s has no metadata", apiID)
+			removeAPIIDs = append(removeAPIIDs, copyBytes(apiID))
+			removed[string(apiID)] = true
+			reported[string(apiID)] = true
+		case !c.belongsTo(c.messages[string(apiID)], mailbox):
+			c.report(mailbox.name, false, "message %s is not labeled by the mailbox", apiID)
+			removeAPIIDs = append(removeAPIIDs, copyBytes(apiID))
+			removed[string(apiID)] = true
+			reported[string(apiID)] = true
+		default:
+			members[string(apiID)] = true
+			return nil
+		}
+		removeUIDs = append(removeUIDs, copyBytes(uidb))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = apiIDs.ForEach(func(apiID, _ []byte) error {
+		if members[string(apiID)] || removed[string(apiID)] {
+			return nil
+		}
+		c.report(mailbox.name, false, "message %s has no matching UID entry", apiID)
+		removeAPIIDs = append(removeAPIIDs, copyBytes(apiID))
+		removed[string(apiID)] = true
+		reported[string(apiID)] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = deletedIDs.ForEach(func(apiID, _ []byte) error {
+		if !members[string(apiID)] {
+			c.report(mailbox.name, false, "message %s marked as deleted is not in the mailbox", apiID)
+			removeDeletedIDs = append(removeDeletedIDs, copyBytes(apiID))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Messages which should be in the mailbox but are not, including those
+	// with broken entries removed above, are added with new UIDs, so clients
+	// notice the change. Membership of virtual mailboxes is decided by
+	// filters, which are not known without running Bridge.
+	var missing []string
+	if !IsVirtualLabel(mailbox.labelID) {
+		for apiID, msg := range c.messages {
+			if members[apiID] || !c.belongsTo(msg, mailbox) {
+				continue
+			}
+			if !reported[apiID] {
+				c.report(mailbox.name, false, "message %s is missing in the mailbox", apiID)
+			}
+			missing = append(missing, apiID)
+		}
+	}
+
+	if !c.repair {
+		return uint(len(members) + len(missing)), nil
+	}
+
+	for _, del := range []struct {
+		b    *bolt.Bucket
+		keys [][]byte
+	}{{imapIDs, removeUIDs}, {apiIDs, removeAPIIDs}, {deletedIDs, removeDeletedIDs}} {
+		for _, key := range del.keys {
+			if err := del.b.Delete(key); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	for _, apiID := range missing {
+		uid, err := imapIDs.NextSequence()
+		if err != nil {
+			return 0, err
+		}
+		if err := imapIDs.Put(itob(uint32(uid)), []byte(apiID)); err != nil {
+			return 0, err
+		}
+		if err := apiIDs.Put([]byte(apiID), itob(uint32(uid))); err != nil {
+			return 0, err
+		}
+		members[apiID] = true
+	}
+
+	return uint(len(members)), nil
+}
+
+// belongsTo returns whether the message should be in the mailbox according
+// to its metadata, the same way as txSkipAndRemoveFromMailbox decides.
+func (c *storeChecker) belongsTo(msg *checkedMessage, mailbox *checkedMailbox) bool {
+	if IsVirtualLabel(mailbox.labelID) {
+		return true
+	}
+	if !mailbox.isCombined && msg.addressID != mailbox.addressID {
+		return false
+	}
+	for _, labelID := range msg.labelIDs {
+		if labelID == mailbox.labelID {
+			return true
+		}
+	}
+	return false
+}
+
+// copyBytes copies the key or value, which is valid only until the bucket is
+// changed.
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// txCheckOrphanedEntries checks the bucket keyed by message IDs does not keep
+// data of messages which are not in the metadata anymore.
+func (c *storeChecker) txCheckOrphanedEntries(tx *bolt.Tx, name []byte) error {
+	b := tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+
+	var orphaned [][]byte
+	err := b.ForEach(func(k, _ []byte) error {
+		if c.messages[string(k)] == nil {
+			orphaned = append(orphaned, copyBytes(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(orphaned) == 0 {
+		return nil
+	}
+
+	c.report("", false, "%d entries in %s belong to no message", len(orphaned), name)
+	if !c.repair {
+		return nil
+	}
+	for _, k := range orphaned {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txCheckCounts compares local totals with counts on API. It makes sense
+// only when the sync is finished; mismatch means messages are missing or
+// left behind and only the sync can tell which.
+func (c *storeChecker) txCheckCounts(tx *bolt.Tx) error {
+	b := tx.Bucket(syncStateBucket)
+	if b == nil || b.Get([]byte(syncFinishTimeKey)) == nil {
+		return nil
+	}
+
+	counts, err := txGetAllCounts(tx)
+	if err != nil {
+		c.report("", true, "counts cannot be parsed")
+		return nil
+	}
+
+	for _, mbCounts := range counts {
+		total, ok := c.totals[mbCounts.LabelID]
+		if !ok || total == mbCounts.TotalOnAPI {
+			continue
+		}
+		name := mbCounts.LabelName
+		if name == "" {
+			name = getSystemFolderName(mbCounts.LabelID)
+		}
+		c.report(name, true, "%d messages locally but %d on server", total, mbCounts.TotalOnAPI)
+	}
+
+	return nil
+}
+
+// txScheduleResync makes the store run the sync once it is opened. An
+// interrupted sync is extended to the full range, because problems could
+// be in the already synced part.
+func txScheduleResync(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists(syncStateBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete([]byte(syncFinishTimeKey)); err != nil {
+		return err
+	}
+
+	if b.Get([]byte(syncIDRangesKey)) == nil {
+		return nil
+	}
+
+	idRanges, err := json.Marshal([]*syncIDRange{{}})
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(syncIDRangesKey), idRanges)
+}
+
+// txCheckBodyCache checks all cached bodies belong to messages in the
+// metadata and all files in the cache are referenced by some message.
+func (c *storeChecker) txCheckBodyCache(tx *bolt.Tx, dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Files referenced only by orphaned IDs are removed too, but counted
+	// once as the orphaned ID.
+	referenced, indexed := map[string]bool{}, map[string]bool{}
+	var orphanedIDs [][]byte
+	if b := tx.Bucket(bodyCacheKeyBucket); b != nil {
+		if b = b.Bucket(cacheIDsBucket); b != nil {
+			if err := b.ForEach(func(apiID, name []byte) error {
+				indexed[string(name)] = true
+				if _, ok := c.messages[string(apiID)]; !ok {
+					orphanedIDs = append(orphanedIDs, copyBytes(apiID))
+					return nil
+				}
+				referenced[string(name)] = true
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	orphaned := len(orphanedIDs)
+	var orphanedFiles []string
+	for _, file := range files {
+		if file.Mode().IsRegular() && !strings.HasSuffix(file.Name(), ".tmp") && !referenced[file.Name()] {
+			orphanedFiles = append(orphanedFiles, file.Name())
+			if !indexed[file.Name()] {
+				orphaned++
+			}
+		}
+	}
+
+	if orphaned == 0 {
+		return nil
+	}
+
+	c.report("", false, "%d cached bodies belong to no message", orphaned)
+	if !c.repair {
+		return nil
+	}
+
+	// Counts of references are rebuilt when the cache is unlocked.
+	b := tx.Bucket(bodyCacheKeyBucket)
+	for _, apiID := range orphanedIDs {
+		if err := b.Bucket(cacheIDsBucket).Delete(apiID); err != nil {
+			return err
+		}
+	}
+	for _, name := range orphanedFiles {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+// initCheckMocks creates store with two messages, finished sync and matching
+// counts, and closes it, so the database can be checked.
+func initCheckMocks(t *testing.T) (string, func()) {
+	m, clear := initMocks(t)
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel})
+	require.NoError(t, m.store.createOrUpdateOnAPICounts([]*pmapi.MessagesCount{
+		{LabelID: pmapi.AllMailLabel, Total: 2},
+		{LabelID: pmapi.InboxLabel, Total: 1},
+		{LabelID: pmapi.ArchiveLabel, Total: 1},
+	}))
+	require.Eventually(t, m.store.isSyncFinished, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, m.store.Close())
+	m.store = nil
+
+	return filepath.Join(m.tmpDir, "mailbox-test.db"), clear
+}
+
+func getCheckProblems(result *CheckResult) (problems []string) {
+	for _, problem := range result.Problems {
+		problems = append(problems, problem.Mailbox+": "+problem.Description)
+	}
+	return
+}
+
+func TestCheckStoreOK(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	result, err := CheckStore(path, true)
+	require.NoError(t, err)
+	require.Empty(t, result.Problems)
+	require.False(t, result.ResyncScheduled)
+	require.Equal(t, 2, result.Messages)
+}
+
+func TestCheckStoreRepair(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	mailboxBucket := func(tx *bolt.Tx, labelID string) *bolt.Bucket {
+		return tx.Bucket(mailboxesBucket).Bucket(getMailboxBucketName(addrID1, labelID))
+	}
+
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		// Broken cross-reference in INBOX.
+		require.NoError(t, mailboxBucket(tx, pmapi.InboxLabel).Bucket(apiIDsBucket).Delete([]byte("msg1")))
+
+		// Message without metadata above UIDNEXT in Archive.
+		archive := mailboxBucket(tx, pmapi.ArchiveLabel)
+		require.NoError(t, archive.Bucket(imapIDsBucket).Put(itob(10), []byte("ghost")))
+		require.NoError(t, archive.Bucket(apiIDsBucket).Put([]byte("ghost"), itob(10)))
+
+		// Missing message in All Mail.
+		allMail := mailboxBucket(tx, pmapi.AllMailLabel)
+		uidb := allMail.Bucket(apiIDsBucket).Get([]byte("msg2"))
+		require.NoError(t, allMail.Bucket(imapIDsBucket).Delete(uidb))
+		require.NoError(t, allMail.Bucket(apiIDsBucket).Delete([]byte("msg2")))
+
+		// Left-overs of deleted message and address.
+		require.NoError(t, tx.Bucket(bodystructureBucket).Put([]byte("gone"), []byte("{}")))
+		_, err := tx.Bucket(mailboxesBucket).CreateBucketIfNotExists([]byte("oldAddressID-0"))
+		if err != nil {
+			return err
+		}
+
+		// Cached body of deleted message and file without any message.
+		cachedIDs, err := tx.CreateBucketIfNotExists(bodyCacheKeyBucket)
+		if err != nil {
+			return err
+		}
+		if cachedIDs, err = cachedIDs.CreateBucketIfNotExists(cacheIDsBucket); err != nil {
+			return err
+		}
+		return cachedIDs.Put([]byte("gone"), []byte("abcd"))
+	}))
+	require.NoError(t, db.Close())
+
+	bodyCacheDir := GetBodyCacheDir(path)
+	require.NoError(t, os.MkdirAll(bodyCacheDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bodyCacheDir, "abcd"), []byte("body"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bodyCacheDir, "ef01"), []byte("body"), 0600))
+
+	expected := []string{
+		": mailbox oldAddressID-0 does not belong to any address",
+		"INBOX: UID 1 of message msg1 has no matching API ID entry",
+		"Archive: UID 10 is above UIDNEXT 2",
+		"Archive: message ghost has no metadata",
+		"All Mail: message msg2 is missing in the mailbox",
+		": 1 entries in bodystructure belong to no message",
+		": 2 cached bodies belong to no message",
+	}
+
+	result, err := CheckStore(path, false)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, getCheckProblems(result))
+	require.False(t, result.ResyncScheduled)
+
+	result, err = CheckStore(path, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, getCheckProblems(result))
+	require.True(t, result.ResyncScheduled)
+	for _, problem := range result.Problems {
+		require.True(t, problem.Repaired)
+		require.Equal(t, problem.Description == "message ghost has no metadata", problem.NeedsResync)
+	}
+
+	result, err = CheckStore(path, false)
+	require.NoError(t, err)
+	require.Empty(t, result.Problems)
+
+	db, err = openStorage(path)
+	require.NoError(t, err)
+	defer db.Close() //nolint[errcheck]
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		// Message with broken entry gets new UID.
+		inbox := mailboxBucket(tx, pmapi.InboxLabel)
+		require.Equal(t, []byte("msg1"), inbox.Bucket(imapIDsBucket).Get(itob(2)))
+		require.Nil(t, inbox.Bucket(imapIDsBucket).Get(itob(1)))
+
+		archive := mailboxBucket(tx, pmapi.ArchiveLabel)
+		require.Nil(t, archive.Bucket(apiIDsBucket).Get([]byte("ghost")))
+		require.Equal(t, uint64(10), archive.Bucket(imapIDsBucket).Sequence())
+
+		allMail := mailboxBucket(tx, pmapi.AllMailLabel)
+		require.Equal(t, itob(3), allMail.Bucket(apiIDsBucket).Get([]byte("msg2")))
+
+		require.Nil(t, tx.Bucket(syncStateBucket).Get([]byte(syncFinishTimeKey)))
+		return nil
+	}))
+}
+
+func TestCheckStoreCountsMismatch(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		counts, err := txGetCountsFromBucketOrNew(tx.Bucket(countsBucket), pmapi.InboxLabel)
+		require.NoError(t, err)
+		counts.TotalOnAPI = 5
+		return counts.txWriteToBucket(tx.Bucket(countsBucket))
+	}))
+	require.NoError(t, db.Close())
+
+	result, err := CheckStore(path, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"INBOX: 1 messages locally but 5 on server"}, getCheckProblems(result))
+	require.True(t, result.ResyncScheduled)
+}
@@ -36,6 +36,7 @@ type eventLoop struct {
 	currentEventID string
 	currentEvent   *pmapi.Event
 	pollCh         chan chan struct{}
+	pollSoonCh     chan struct{}
 	stopCh         chan struct{}
 	notifyStopCh   chan struct{}
 	isRunning      bool // The whole event loop is running.
@@ -59,6 +60,7 @@ func newEventLoop(cache *Cache, store *Store, user BridgeUser, events listener.L
 		cache:          cache,
 		currentEventID: cache.getEventID(user.ID()),
 		pollCh:         make(chan chan struct{}),
+		pollSoonCh:     make(chan struct{}, 1),
 		isRunning:      false,
 		isTickerPaused: false,
 
@@ -75,15 +77,22 @@ func (loop *eventLoop) client() pmapi.Client {
 }
 
 func (loop *eventLoop) setFirstEventID() (err error) {
-	loop.log.Info("Setting first event ID")
+	// Interrupted sync does not fetch already synced ranges again, so events
+	// since its start have to be processed to not miss their changes.
+	if eventID := loop.store.getSyncEventID(); eventID != "" {
+		loop.log.WithField("eventID", eventID).Info("Setting first event ID from interrupted sync")
+		loop.currentEventID = eventID
+	} else {
+		loop.log.Info("Setting first event ID")
 
-	event, err := loop.client().GetEvent("")
-	if err != nil {
-		loop.log.WithError(err).Error("Could not get latest event ID")
-		return
-	}
+		event, err := loop.client().GetEvent("")
+		if err != nil {
+			loop.log.WithError(err).Error("Could not get latest event ID")
+			return err
+		}
 
-	loop.currentEventID = event.EventID
+		loop.currentEventID = event.EventID
+	}
 
 	if err = loop.cache.setEventID(loop.user.ID(), loop.currentEventID); err != nil {
 		loop.log.WithError(err).Error("Could not set latest event ID in user cache")
@@ -102,6 +111,15 @@ func (loop *eventLoop) pollNow() {
 	close(eventProcessedCh)
 }
 
+// pollSoon makes the loop poll events once it is free to do so. Unlike
+// pollNow it does not block; one pending request is enough.
+func (loop *eventLoop) pollSoon() {
+	select {
+	case loop.pollSoonCh <- struct{}{}:
+	default:
+	}
+}
+
 func (loop *eventLoop) stop() {
 	if loop.isRunning {
 		loop.isRunning = false
@@ -142,9 +160,17 @@ func (loop *eventLoop) start() {
 	loop.loop()
 }
 
+// isPaused returns whether polling is paused by IMAP or by the user.
+func (loop *eventLoop) isPaused() bool {
+	return loop.isTickerPaused || loop.store.IsPaused()
+}
+
 // loop is the main body of the event loop.
 func (loop *eventLoop) loop() {
-	t := time.NewTicker(pollInterval - pollIntervalSpread)
+	activity := loop.store.pollActivity
+	lastPoll := time.Now()
+
+	t := time.NewTimer(loop.nextPollWait())
 	defer t.Stop()
 
 	for {
@@ -154,16 +180,31 @@ func (loop *eventLoop) loop() {
 			close(loop.notifyStopCh)
 			return
 		case <-t.C:
-			if loop.isTickerPaused {
+			if loop.isPaused() {
 				loop.log.Trace("Event loop paused, skipping")
+				t.Reset(loop.nextPollWait())
+				continue
+			}
+		case <-activity.wakeCh:
+			if loop.isPaused() {
+				continue
+			}
+			// Activity can shorten the interval so the poll might be due already.
+			if wait := activity.interval(time.Now()) - time.Since(lastPoll); wait > 0 {
+				resetTimer(t, wait)
+				continue
+			}
+		case <-loop.pollSoonCh:
+			if loop.isPaused() {
 				continue
 			}
-			// Randomise periodic calls within range pollInterval ± pollSpread to reduces potential load spikes on API.
-			time.Sleep(time.Duration(rand.Intn(2*int(pollIntervalSpread.Milliseconds()))) * time.Millisecond)
 		case eventProcessedCh = <-loop.pollCh:
 			// We don't want to wait here. Polling should happen instantly.
 		}
 
+		lastPoll = time.Now()
+		resetTimer(t, loop.nextPollWait())
+
 		// Before we fetch the first event, check whether this is the first time we've
 		// started the event loop, and if so, trigger a full sync.
 		// In case internet connection was not available during start, it will be
@@ -202,6 +243,32 @@ func (loop *eventLoop) loop() {
 	}
 }
 
+// nextPollWait returns the current poll interval randomised by up to
+// pollIntervalSpread to reduce potential load spikes on API.
+func (loop *eventLoop) nextPollWait() time.Duration {
+	interval := loop.store.pollActivity.interval(time.Now())
+
+	spread := pollIntervalSpread
+	if spread > interval/4 {
+		spread = interval / 4
+	}
+	if spread <= 0 {
+		return interval
+	}
+	return interval - spread + time.Duration(rand.Int63n(int64(2*spread)))
+}
+
+// resetTimer changes the timer to fire after `d` whether it fired already or not.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
 // isBeforeFirstStart returns whether the initial event ID was already set or not.
 func (loop *eventLoop) isBeforeFirstStart() bool {
 	return loop.currentEventID == ""
@@ -289,7 +356,15 @@ func (loop *eventLoop) processNextEvent() (more bool, err error) { // nolint[fun
 		}
 	}
 
-	return event.More == 1, err
+	// API is reachable again, operations done while offline can be replayed.
+	// It is done after the event is processed to know which messages were
+	// deleted on the server meanwhile.
+	replayed, err := loop.store.replayJournal()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to replay journal")
+	}
+
+	return event.More == 1 || replayed, err
 }
 
 func (loop *eventLoop) processEvent(event *pmapi.Event) (err error) {
@@ -434,6 +509,12 @@ func (loop *eventLoop) processMessages(eventLog *logrus.Entry, messages []*pmapi
 				return errors.Wrap(err, "failed to put message into DB")
 			}
 
+			// Desktop integration notifies only about new unread mail in
+			// Inbox, not about own sent messages or drafts.
+			if message.Created.Unread == 1 && message.Created.HasLabelID(pmapi.InboxLabel) {
+				loop.events.Emit(bridgeEvents.NewMessageEvent, loop.store.UserID()+":"+message.ID)
+			}
+
 		case pmapi.EventUpdate, pmapi.EventUpdateFlags:
 			msgLog.Debug("Processing EventUpdate(Flags) for message")
 
@@ -562,6 +643,8 @@ func (loop *eventLoop) processMessageCounts(l *logrus.Entry, messageCounts []*pm
 		log.Error("The counts between DB and API are not matching")
 	}
 
+	loop.events.Emit(bridgeEvents.UnreadChangedEvent, loop.store.UserID())
+
 	return nil
 }
 
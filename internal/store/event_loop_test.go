@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -155,3 +156,20 @@ func TestEventLoopUpdateMessage(t *testing.T) {
 
 	require.Equal(t, newMsg, msg)
 }
+
+func TestEventLoopEmitsNewMessage(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	unreadInbox := getTestMessage("msg1", "New", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	readInbox := getTestMessage("msg2", "Read", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	unreadSent := getTestMessage("msg3", "Sent", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.SentLabel})
+
+	m.events.EXPECT().Emit(bridgeEvents.NewMessageEvent, "userID:msg1")
+	require.NoError(t, m.store.eventLoop.processMessages(m.store.log, []*pmapi.EventMessage{
+		{EventItem: pmapi.EventItem{ID: "msg1", Action: pmapi.EventCreate}, Created: unreadInbox},
+		{EventItem: pmapi.EventItem{ID: "msg2", Action: pmapi.EventCreate}, Created: readInbox},
+		{EventItem: pmapi.EventItem{ID: "msg3", Action: pmapi.EventCreate}, Created: unreadSent},
+	}))
+}
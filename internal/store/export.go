@@ -0,0 +1,299 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-mbox"
+	"github.com/pkg/errors"
+)
+
+// Formats of Export.
+const (
+	ExportMBOX    = "mbox"
+	ExportMaildir = "maildir"
+)
+
+// IsExportFormat returns whether the format is known to Export.
+func IsExportFormat(format string) bool {
+	return format == ExportMBOX || format == ExportMaildir
+}
+
+// exportWriter writes messages of one mailbox.
+type exportWriter interface {
+	writeMessage(msg *pmapi.Message, body []byte) error
+	Close() error
+}
+
+// Export writes messages of the mailbox with the given IMAP name to the
+// directory `dir` in the given format, keeping their flags and dates.
+// Empty name exports all mailboxes except All Mail, which would only
+// duplicate the others. In split address mode every address has its own
+// subdirectory. Bodies are taken from the body cache, or downloaded when not
+// cached. Messages which cannot be exported are skipped and reported in the
+// returned error together with the number of exported messages.
+func (store *Store) Export(dir, format, mailboxName string) (exported int, err error) {
+	if !IsExportFormat(format) {
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	store.lock.RLock()
+	addresses := make([]*Address, 0, len(store.addresses))
+	for _, address := range store.addresses {
+		addresses = append(addresses, address)
+	}
+	store.lock.RUnlock()
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].address < addresses[j].address })
+
+	failed := 0
+	found := mailboxName == ""
+	for _, address := range addresses {
+		addressDir := dir
+		if len(addresses) > 1 {
+			addressDir = filepath.Join(dir, sanitizeExportName(address.address))
+		}
+
+		for _, mailbox := range address.ListMailboxes() {
+			if mailbox.IsVirtual() {
+				continue
+			}
+			if mailboxName == "" && mailbox.labelID == pmapi.AllMailLabel {
+				continue
+			}
+			if mailboxName != "" && !strings.EqualFold(mailbox.Name(), mailboxName) {
+				continue
+			}
+			found = true
+
+			mailboxExported, mailboxFailed, err := store.exportMailbox(addressDir, format, mailbox)
+			exported += mailboxExported
+			failed += mailboxFailed
+			if err != nil {
+				return exported, errors.Wrapf(err, "cannot export mailbox %s", mailbox.Name())
+			}
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("mailbox %v does not exist", mailboxName)
+	}
+	if failed != 0 {
+		return exported, fmt.Errorf("%d messages could not be exported", failed)
+	}
+	return exported, nil
+}
+
+func (store *Store) exportMailbox(dir, format string, mailbox *Mailbox) (exported, failed int, err error) {
+	apiIDs, err := mailbox.GetAPIIDsFromSequenceRange(1, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	path := filepath.Join(dir, exportPath(mailbox.Name()))
+	var w exportWriter
+	if format == ExportMBOX {
+		w, err = newMBOXExportWriter(path + ".mbox")
+	} else {
+		w, err = newMaildirExportWriter(path)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	l := store.log.WithField("mailbox", mailbox.Name())
+	for _, apiID := range apiIDs {
+		msg, err := store.getMessageFromDB(apiID)
+		if err != nil {
+			l.WithError(err).WithField("msgID", apiID).Warn("Cannot load message to export")
+			failed++
+			continue
+		}
+
+		body, err := store.getExportBody(msg)
+		if err != nil {
+			l.WithError(err).WithField("msgID", apiID).Warn("Cannot build message to export")
+			failed++
+			continue
+		}
+
+		if err := w.writeMessage(msg, body); err != nil {
+			_ = w.Close()
+			return exported, failed, err
+		}
+		exported++
+	}
+
+	return exported, failed, w.Close()
+}
+
+// getExportBody returns the message from the body cache or downloads and
+// builds it. The message is not modified.
+func (store *Store) getExportBody(msg *pmapi.Message) ([]byte, error) {
+	if body, ok := store.LoadCachedBody(msg.ID); ok {
+		return body, nil
+	}
+	msgCopy := *msg
+	_, body, err := pkgMsg.NewBuilder(store.client(), &msgCopy).BuildMessage()
+	return body, err
+}
+
+// exportPath returns relative file path for the IMAP mailbox name. Every
+// level is sanitized so names coming from API cannot escape the directory.
+func exportPath(name string) string {
+	levels := strings.Split(name, PathDelimiter)
+	for i, level := range levels {
+		levels[i] = sanitizeExportName(level)
+	}
+	return filepath.Join(levels...)
+}
+
+func sanitizeExportName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, name)
+	if strings.Trim(name, ". ") == "" {
+		return strings.Repeat("_", len(name)+1)
+	}
+	return name
+}
+
+type mboxExportWriter struct {
+	file *os.File
+	w    *mbox.Writer
+}
+
+func newMBOXExportWriter(path string) (*mboxExportWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &mboxExportWriter{file: file, w: mbox.NewWriter(file)}, nil
+}
+
+// writeMessage writes the message with flags in Status and X-Status headers
+// understood by most of mbox readers.
+func (e *mboxExportWriter) writeMessage(msg *pmapi.Message, body []byte) error {
+	from := ""
+	if msg.Sender != nil {
+		from = msg.Sender.Address
+	}
+
+	w, err := e.w.CreateMessage(from, time.Unix(msg.Time, 0))
+	if err != nil {
+		return err
+	}
+
+	status, xStatus := "O", ""
+	for _, flag := range pkgMsg.GetFlags(msg) {
+		switch flag {
+		case imap.SeenFlag:
+			status = "RO"
+		case imap.AnsweredFlag:
+			xStatus += "A"
+		case imap.FlaggedFlag:
+			xStatus += "F"
+		case imap.DraftFlag:
+			xStatus += "T"
+		}
+	}
+
+	header := "Status: " + status + "\r\n"
+	if xStatus != "" {
+		header += "X-Status: " + xStatus + "\r\n"
+	}
+	if _, err := w.Write([]byte(header)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func (e *mboxExportWriter) Close() error {
+	if err := e.w.Close(); err != nil {
+		_ = e.file.Close()
+		return err
+	}
+	return e.file.Close()
+}
+
+type maildirExportWriter struct {
+	dir string
+}
+
+func newMaildirExportWriter(dir string) (*maildirExportWriter, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &maildirExportWriter{dir: dir}, nil
+}
+
+// writeMessage writes the message to `cur` with flags in the file name and
+// the message time as modification time. The message is written to `tmp`
+// first as the Maildir specification requires.
+func (e *maildirExportWriter) writeMessage(msg *pmapi.Message, body []byte) error {
+	flags := []string{}
+	for _, flag := range pkgMsg.GetFlags(msg) {
+		switch flag {
+		case imap.DraftFlag:
+			flags = append(flags, "D")
+		case imap.FlaggedFlag:
+			flags = append(flags, "F")
+		case imap.AnsweredFlag:
+			flags = append(flags, "R")
+		case imap.SeenFlag:
+			flags = append(flags, "S")
+		}
+	}
+	// Maildir flags must be in ASCII order.
+	sort.Strings(flags)
+
+	msgTime := time.Unix(msg.Time, 0)
+	name := fmt.Sprintf("%d.%s.bridge", msg.Time, sanitizeExportName(msg.ID))
+
+	tmpPath := filepath.Join(e.dir, "tmp", name)
+	if err := ioutil.WriteFile(tmpPath, body, 0600); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, msgTime, msgTime); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(e.dir, "cur", name+":2,"+strings.Join(flags, "")))
+}
+
+func (e *maildirExportWriter) Close() error {
+	return nil
+}
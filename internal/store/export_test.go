@@ -0,0 +1,151 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func initExportMocks(t *testing.T) (*mocksForStore, string, func()) {
+	m, clear := initMocks(t)
+	m.newStoreNoEvents(true)
+
+	bc, cleanupBodyCache := newTestBodyCache(t, BodyCachePolicy{MaxSize: 1024 * 1024})
+	m.store.bodyCache = bc
+
+	exportDir, err := ioutil.TempDir("", "export-test")
+	require.NoError(t, err)
+
+	insertExportMessage(t, m, "msg1", 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel, pmapi.StarredLabel})
+	insertExportMessage(t, m, "msg2", 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertExportMessage(t, m, "msg3", 0, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel})
+
+	// Message which is not cached has to be downloaded.
+	m.client.EXPECT().GetMessage("msg3").Return(nil, errors.New("offline")).AnyTimes()
+
+	return m, exportDir, func() {
+		_ = os.RemoveAll(exportDir)
+		cleanupBodyCache()
+		clear()
+	}
+}
+
+func insertExportMessage(t *testing.T, m *mocksForStore, id string, unread int, labelIDs []string) {
+	msg := getTestMessage(id, "Subject "+id, addr1, unread, labelIDs)
+	msg.Flags = pmapi.FlagReceived
+	msg.Time = 1600000000
+	require.NoError(t, m.store.createOrUpdateMessageEvent(msg))
+
+	if id != "msg3" {
+		m.store.SaveCachedBody(id, getTestCachedBody(t, m, id, "From the body of "+id+"\r\n"))
+	}
+}
+
+func TestExportMBOX(t *testing.T) {
+	m, exportDir, clear := initExportMocks(t)
+	defer clear()
+
+	exported, err := m.store.Export(exportDir, ExportMBOX, "inbox")
+	require.NoError(t, err)
+	require.Equal(t, 2, exported)
+
+	mbox, err := ioutil.ReadFile(filepath.Join(exportDir, "INBOX.mbox"))
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"From " + addr1 + " Sun Sep 13 12:26:40 2020",
+		"Status: RO",
+		"X-Status: F",
+		"Date: Sun, 13 Sep 2020 12:26:40 +0000",
+		"From: <" + addr1 + ">",
+		"Message-Id: <msg1@protonmail.internalid>",
+		"References: <msg1@protonmail.internalid>",
+		"Subject: Subject msg1",
+		"To: <" + addr1 + ">",
+		"X-Pm-Date: Sun, 13 Sep 2020 12:26:40 +0000",
+		"X-Pm-Internal-Id: msg1",
+		"",
+		">From the body of msg1",
+		"",
+		"",
+		"From " + addr1 + " Sun Sep 13 12:26:40 2020",
+		"Status: O",
+		"Date: Sun, 13 Sep 2020 12:26:40 +0000",
+		"From: <" + addr1 + ">",
+		"Message-Id: <msg2@protonmail.internalid>",
+		"References: <msg2@protonmail.internalid>",
+		"Subject: Subject msg2",
+		"To: <" + addr1 + ">",
+		"X-Pm-Date: Sun, 13 Sep 2020 12:26:40 +0000",
+		"X-Pm-Internal-Id: msg2",
+		"",
+		">From the body of msg2",
+		"",
+		"",
+		"",
+	}, "\n"), string(mbox))
+
+	_, err = os.Stat(filepath.Join(exportDir, "Archive.mbox"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExportMaildirAll(t *testing.T) {
+	m, exportDir, clear := initExportMocks(t)
+	defer clear()
+
+	exported, err := m.store.Export(exportDir, ExportMaildir, "")
+	require.EqualError(t, err, "1 messages could not be exported")
+	require.Equal(t, 2, exported)
+
+	files, err := ioutil.ReadDir(filepath.Join(exportDir, "INBOX", "cur"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "1600000000.msg1.bridge:2,FS", files[0].Name())
+	require.Equal(t, "1600000000.msg2.bridge:2,", files[1].Name())
+	require.Equal(t, int64(1600000000), files[0].ModTime().Unix())
+
+	// All Mail is not exported as it only duplicates other mailboxes.
+	_, err = os.Stat(filepath.Join(exportDir, "All Mail"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(exportDir, "Archive", "cur"))
+	require.NoError(t, err)
+}
+
+func TestExportUnknownMailbox(t *testing.T) {
+	m, exportDir, clear := initExportMocks(t)
+	defer clear()
+
+	_, err := m.store.Export(exportDir, ExportMBOX, "Folders/Unknown")
+	require.EqualError(t, err, "mailbox Folders/Unknown does not exist")
+
+	_, err = m.store.Export(exportDir, "pst", "")
+	require.EqualError(t, err, `unknown export format "pst"`)
+}
+
+func TestExportPath(t *testing.T) {
+	require.Equal(t, "INBOX", exportPath("INBOX"))
+	require.Equal(t, filepath.Join("Folders", "Work"), exportPath("Folders/Work"))
+	require.Equal(t, filepath.Join("Folders", "___", "___", "a_b"), exportPath("Folders/../../a\\b"))
+}
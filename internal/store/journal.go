@@ -0,0 +1,265 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Journaled operations changing messages on API.
+const (
+	journalLabel   = "label"
+	journalUnlabel = "unlabel"
+	journalRead    = "read"
+	journalUnread  = "unread"
+	journalDelete  = "delete"
+)
+
+// journalEntry is an operation which could not be done on API because it was
+// not reachable. It is applied to the local database immediately so IMAP
+// clients see the change and replayed on API when it is reachable again.
+type journalEntry struct {
+	Action  string
+	IDs     []string
+	LabelID string `json:",omitempty"`
+}
+
+func (store *Store) labelMessages(apiIDs []string, labelID string) error {
+	return store.runOrJournal(&journalEntry{Action: journalLabel, IDs: apiIDs, LabelID: labelID})
+}
+
+func (store *Store) unlabelMessages(apiIDs []string, labelID string) error {
+	return store.runOrJournal(&journalEntry{Action: journalUnlabel, IDs: apiIDs, LabelID: labelID})
+}
+
+func (store *Store) markMessagesRead(apiIDs []string) error {
+	return store.runOrJournal(&journalEntry{Action: journalRead, IDs: apiIDs})
+}
+
+func (store *Store) markMessagesUnread(apiIDs []string) error {
+	return store.runOrJournal(&journalEntry{Action: journalUnread, IDs: apiIDs})
+}
+
+func (store *Store) deleteMessages(apiIDs []string) error {
+	return store.runOrJournal(&journalEntry{Action: journalDelete, IDs: apiIDs})
+}
+
+// runOrJournal does the operation on API. When API is not reachable or there
+// are older operations still waiting in the journal (to keep the order), the
+// operation is journaled and applied only locally.
+func (store *Store) runOrJournal(entry *journalEntry) error {
+	store.journalLock.Lock()
+	defer store.journalLock.Unlock()
+
+	if !store.hasJournal() {
+		err := store.applyOnAPI(entry)
+		if errors.Cause(err) != pmapi.ErrAPINotReachable {
+			return err
+		}
+		store.log.WithField("action", entry.Action).Warn("API is not reachable, journaling operation")
+	}
+
+	if err := store.appendJournal(entry); err != nil {
+		return errors.Wrap(err, "cannot journal operation")
+	}
+
+	return store.applyLocally(entry)
+}
+
+func (store *Store) hasJournal() bool {
+	hasJournal := false
+	_ = store.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(journalBucket); b != nil {
+			k, _ := b.Cursor().First()
+			hasJournal = k != nil
+		}
+		return nil
+	})
+	return hasJournal
+}
+
+func (store *Store) appendJournal(entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(journalBucket)
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(uint32(seq)), data)
+	})
+}
+
+func (store *Store) applyOnAPI(entry *journalEntry) error {
+	switch entry.Action {
+	case journalLabel:
+		return store.client().LabelMessages(entry.IDs, entry.LabelID)
+	case journalUnlabel:
+		return store.client().UnlabelMessages(entry.IDs, entry.LabelID)
+	case journalRead:
+		return store.client().MarkMessagesRead(entry.IDs)
+	case journalUnread:
+		return store.client().MarkMessagesUnread(entry.IDs)
+	case journalDelete:
+		return store.client().DeleteMessages(entry.IDs)
+	}
+	return errors.Errorf("unknown journal action %q", entry.Action)
+}
+
+// applyLocally changes messages in the database the way API would. Moving to
+// a folder is only approximated (other folders are removed); the exact state
+// comes with events after the journal is replayed.
+func (store *Store) applyLocally(entry *journalEntry) error {
+	if entry.Action == journalDelete {
+		return store.deleteMessagesEvent(entry.IDs)
+	}
+
+	var msgs []*pmapi.Message
+	for _, apiID := range entry.IDs {
+		msg, err := store.getMessageFromDB(apiID)
+		if err != nil {
+			continue
+		}
+
+		switch entry.Action {
+		case journalLabel:
+			if store.isFolderLabel(entry.LabelID) {
+				msg.LabelIDs = removeLabels(msg.LabelIDs, store.isFolderLabel)
+			}
+			msg.LabelIDs = append(removeLabels(msg.LabelIDs, isLabel(entry.LabelID)), entry.LabelID)
+		case journalUnlabel:
+			msg.LabelIDs = removeLabels(msg.LabelIDs, isLabel(entry.LabelID))
+		case journalRead:
+			msg.Unread = 0
+		case journalUnread:
+			msg.Unread = 1
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) == 0 {
+		return nil
+	}
+	return store.createOrUpdateMessagesEvent(msgs)
+}
+
+// isFolderLabel returns whether the message can be only in one such mailbox
+// at once, i.e. whether it is a system or custom folder.
+func (store *Store) isFolderLabel(labelID string) bool {
+	switch labelID {
+	case pmapi.InboxLabel, pmapi.ArchiveLabel, pmapi.TrashLabel, pmapi.SpamLabel, pmapi.SentLabel, pmapi.DraftLabel:
+		return true
+	}
+
+	for _, address := range store.addresses {
+		if mailbox, ok := address.mailboxes[labelID]; ok {
+			return mailbox.IsFolder()
+		}
+	}
+	return false
+}
+
+func isLabel(labelID string) func(string) bool {
+	return func(id string) bool { return id == labelID }
+}
+
+func removeLabels(labelIDs []string, remove func(string) bool) []string {
+	kept := []string{}
+	for _, labelID := range labelIDs {
+		if !remove(labelID) {
+			kept = append(kept, labelID)
+		}
+	}
+	return kept
+}
+
+// replayJournal does journaled operations on API after it is reachable again.
+// Server wins for deletions: operations on messages deleted in the meantime
+// (already removed from the database by events) are dropped. Otherwise the
+// journaled operation wins because it is the later intent of the user.
+// Operations refused by API are dropped so they do not block the journal.
+// It returns whether any operation was replayed.
+func (store *Store) replayJournal() (replayed bool, err error) {
+	store.journalLock.Lock()
+	defer store.journalLock.Unlock()
+
+	for {
+		var key []byte
+		entry := &journalEntry{}
+
+		err = store.db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(journalBucket)
+			if b == nil {
+				return nil
+			}
+			var data []byte
+			if key, data = b.Cursor().First(); key == nil {
+				return nil
+			}
+			key = append([]byte{}, key...)
+			return json.Unmarshal(data, entry)
+		})
+		if err != nil || key == nil {
+			return replayed, err
+		}
+
+		// Deleted messages were already removed from the database locally.
+		if entry.Action != journalDelete {
+			entry.IDs = store.filterExistingMessages(entry.IDs)
+		}
+		if len(entry.IDs) > 0 {
+			err := store.applyOnAPI(entry)
+			if errors.Cause(err) == pmapi.ErrAPINotReachable {
+				return replayed, err
+			}
+			if err != nil {
+				store.log.WithError(err).WithField("action", entry.Action).Warn("Journaled operation refused by API, dropping it")
+			}
+		}
+
+		err = store.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(journalBucket).Delete(key)
+		})
+		if err != nil {
+			return replayed, err
+		}
+		replayed = true
+	}
+}
+
+func (store *Store) filterExistingMessages(apiIDs []string) []string {
+	existing := []string{}
+	for _, apiID := range apiIDs {
+		if _, err := store.getMessageFromDB(apiID); err == nil {
+			existing = append(existing, apiID)
+		}
+	}
+	return existing
+}
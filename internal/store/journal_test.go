@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalOfflineOperationsAreAppliedLocallyAndReplayed(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	// Store functions are used directly, mailbox ones would poll the event
+	// loop which replays the journal.
+	m.client.EXPECT().MarkMessagesRead([]string{"msg1"}).Return(pmapi.ErrAPINotReachable)
+	require.NoError(t, m.store.markMessagesRead([]string{"msg1"}))
+
+	// Journal is not empty, so the following operation is not even tried.
+	require.NoError(t, m.store.labelMessages([]string{"msg1"}, pmapi.ArchiveLabel))
+
+	msg, err := m.store.getMessageFromDB("msg1")
+	require.NoError(t, err)
+	require.Equal(t, 0, msg.Unread)
+	require.ElementsMatch(t, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel}, msg.LabelIDs)
+	require.True(t, m.store.hasJournal())
+
+	gomock.InOrder(
+		m.client.EXPECT().MarkMessagesRead([]string{"msg1"}),
+		m.client.EXPECT().LabelMessages([]string{"msg1"}, pmapi.ArchiveLabel),
+	)
+	replayed, err := m.store.replayJournal()
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.False(t, m.store.hasJournal())
+}
+
+func TestJournalReplayDropsOperationsOnDeletedMessages(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	m.client.EXPECT().MarkMessagesUnread([]string{"msg1"}).Return(pmapi.ErrAPINotReachable)
+	require.NoError(t, m.store.markMessagesUnread([]string{"msg1"}))
+
+	// Message was deleted on the server meanwhile.
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+
+	replayed, err := m.store.replayJournal()
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.False(t, m.store.hasJournal())
+}
+
+func TestJournalReplayKeepsOperationsWhenOffline(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	m.client.EXPECT().DeleteMessages([]string{"msg1"}).Return(pmapi.ErrAPINotReachable).Times(2)
+	require.NoError(t, m.store.deleteMessages([]string{"msg1"}))
+
+	_, err := m.store.getMessageFromDB("msg1")
+	require.Equal(t, ErrNoSuchAPIID, err)
+
+	replayed, err := m.store.replayJournal()
+	require.Equal(t, pmapi.ErrAPINotReachable, err)
+	require.False(t, replayed)
+	require.True(t, m.store.hasJournal())
+
+	m.client.EXPECT().DeleteMessages([]string{"msg1"})
+	replayed, err = m.store.replayJournal()
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.False(t, m.store.hasJournal())
+}
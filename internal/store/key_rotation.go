@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrKeyRotationInProgress is returned when local keys are being rotated
+// already.
+var ErrKeyRotationInProgress = errors.New("local keys are being rotated already") //nolint[gochecknoglobals]
+
+// RotateLocalKeys replaces the local keys of the search index and of the body
+// and attachment caches by new ones and re-encrypts the local data in the
+// background, so nothing has to be synced or downloaded again. It is meant
+// for cases when the keys may have leaked, e.g. together with a password.
+// Rotation interrupted by closing the store is finished next time the data
+// are used.
+func (store *Store) RotateLocalKeys() error {
+	if !atomic.CompareAndSwapInt32(&store.rotatingKeys, 0, 1) {
+		return ErrKeyRotationInProgress
+	}
+
+	go func() {
+		defer store.panicHandler.HandlePanic()
+		defer atomic.StoreInt32(&store.rotatingKeys, 0)
+
+		store.rotateLocalKeys()
+	}()
+
+	return nil
+}
+
+func (store *Store) rotateLocalKeys() {
+	store.log.Info("Rotating local keys")
+
+	if store.searchIndex != nil {
+		if err := store.rotateSearchIndexKey(); err != nil {
+			store.log.WithError(err).Error("Cannot rotate search index key")
+		}
+	}
+
+	for _, cache := range []struct {
+		bc     *bodyCache
+		bucket []byte
+	}{
+		{store.bodyCache, bodyCacheKeyBucket},
+		{store.attCache, attCacheKeyBucket},
+	} {
+		if !store.unlockCache(cache.bc, cache.bucket) {
+			continue
+		}
+		if err := store.rotateCacheKey(cache.bc, cache.bucket); err != nil {
+			store.log.WithError(err).WithField("cache", cache.bc.dir).Error("Cannot rotate cache key")
+		}
+	}
+
+	store.log.Info("Local keys rotated")
+}
+
+func (store *Store) rotateSearchIndexKey() error {
+	key, prevKey, err := store.rotateLocalKey(searchKeyBucket)
+	if err != nil || key == nil {
+		return err
+	}
+
+	if err := store.searchIndex.rotate(key, prevKey); err != nil {
+		return err
+	}
+
+	return store.finishLocalKeyRotation(searchKeyBucket)
+}
+
+// resumeSearchIndexKeyRotation re-encrypts documents still encrypted by the
+// previous key after rotation was interrupted.
+func (store *Store) resumeSearchIndexKeyRotation(key []byte) error {
+	prevKey, err := store.getPreviousLocalKey(searchKeyBucket)
+	if err != nil || prevKey == nil {
+		return err
+	}
+
+	if err := store.searchIndex.rotate(key, prevKey); err != nil {
+		return err
+	}
+
+	return store.finishLocalKeyRotation(searchKeyBucket)
+}
+
+func (store *Store) rotateCacheKey(bc *bodyCache, keyBucket []byte) error {
+	key, prevKey, err := store.rotateLocalKey(keyBucket)
+	if err != nil || key == nil {
+		return err
+	}
+
+	if err := bc.setKeys(key, prevKey); err != nil {
+		return err
+	}
+
+	return store.reencryptCache(bc, keyBucket)
+}
+
+// reencryptCache moves the cache to the current key and forgets the previous
+// one. Nothing is done when another re-encryption of the cache is running,
+// that one finishes the rotation.
+func (store *Store) reencryptCache(bc *bodyCache, keyBucket []byte) error {
+	done, err := bc.reencrypt()
+	if err != nil || !done {
+		return err
+	}
+
+	return store.finishLocalKeyRotation(keyBucket)
+}
+
+// resumeCacheKeyRotation finishes in the background rotation of the cache key
+// interrupted e.g. by quitting Bridge.
+func (store *Store) resumeCacheKeyRotation(bc *bodyCache, keyBucket []byte, key []byte) {
+	prevKey, err := store.getPreviousLocalKey(keyBucket)
+	if err != nil {
+		store.log.WithError(err).WithField("cache", bc.dir).Warn("Cannot get previous cache key")
+		return
+	}
+	if prevKey == nil {
+		return
+	}
+
+	if err := bc.setKeys(key, prevKey); err != nil {
+		store.log.WithError(err).WithField("cache", bc.dir).Warn("Cannot resume rotation of cache key")
+		return
+	}
+
+	go func() {
+		defer store.panicHandler.HandlePanic()
+
+		if err := store.reencryptCache(bc, keyBucket); err != nil {
+			store.log.WithError(err).WithField("cache", bc.dir).Error("Cannot finish rotation of cache key")
+		}
+	}()
+}
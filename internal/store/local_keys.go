@@ -0,0 +1,200 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	bolt "go.etcd.io/bbolt"
+)
+
+const localKeySize = 32
+
+var (
+	localKeyName     = []byte("key")      //nolint[gochecknoglobals]
+	prevLocalKeyName = []byte("prev_key") //nolint[gochecknoglobals]
+)
+
+// getLocalKey returns the symmetric key stored in the bucket encrypted by the
+// primary address key. New key is generated when there is none or it cannot
+// be decrypted (e.g. after the primary address changed); isNew tells the
+// caller that data encrypted by the previous key should be dropped.
+func (store *Store) getLocalKey(bucket []byte) (key []byte, isNew bool, err error) {
+	kr, err := store.getLocalKeyRing()
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+
+		if encryptedKey := b.Get(localKeyName); encryptedKey != nil {
+			plainKey, err := kr.Decrypt(crypto.NewPGPMessage(encryptedKey), nil, 0)
+			if err == nil {
+				key = plainKey.GetBinary()
+				return nil
+			}
+			store.log.WithError(err).WithField("bucket", string(bucket)).Warn("Cannot decrypt local key, generating new one")
+		}
+
+		key = make([]byte, localKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		isNew = true
+
+		// Unfinished rotation is pointless, the data are dropped anyway.
+		if err := b.Delete(prevLocalKeyName); err != nil {
+			return err
+		}
+
+		encryptedKey, err := kr.Encrypt(crypto.NewPlainMessage(key), nil)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(localKeyName, encryptedKey.GetBinary())
+	})
+
+	return key, isNew, err
+}
+
+// getPreviousLocalKey returns the key replaced by rotateLocalKey if data
+// encrypted by it were not re-encrypted yet, or nil otherwise.
+func (store *Store) getPreviousLocalKey(bucket []byte) (prevKey []byte, err error) {
+	var encryptedKey []byte
+	_ = store.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			encryptedKey = copyBytes(b.Get(prevLocalKeyName))
+		}
+		return nil
+	})
+	if len(encryptedKey) == 0 {
+		return nil, nil
+	}
+
+	kr, err := store.getLocalKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	plainKey, err := kr.Decrypt(crypto.NewPGPMessage(encryptedKey), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return plainKey.GetBinary(), nil
+}
+
+// rotateLocalKey replaces the key stored in the bucket by a new one. The
+// replaced key is kept as previous until finishLocalKeyRotation is called,
+// so the caller can re-encrypt data and an interrupted rotation can be
+// resumed. When a previous key is still there, no new key is generated and
+// the unfinished rotation is returned instead. Both keys are nil when the
+// bucket has no key yet, i.e. there is nothing to rotate.
+func (store *Store) rotateLocalKey(bucket []byte) (key, prevKey []byte, err error) {
+	kr, err := store.getLocalKeyRing()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil || b.Get(localKeyName) == nil {
+			return nil
+		}
+
+		plainKey, err := kr.Decrypt(crypto.NewPGPMessage(b.Get(localKeyName)), nil, 0)
+		if err != nil {
+			return err
+		}
+
+		if encryptedPrevKey := b.Get(prevLocalKeyName); encryptedPrevKey != nil {
+			plainPrevKey, err := kr.Decrypt(crypto.NewPGPMessage(encryptedPrevKey), nil, 0)
+			if err != nil {
+				return err
+			}
+			key, prevKey = plainKey.GetBinary(), plainPrevKey.GetBinary()
+			return nil
+		}
+
+		newKey := make([]byte, localKeySize)
+		if _, err := rand.Read(newKey); err != nil {
+			return err
+		}
+		encryptedKey, err := kr.Encrypt(crypto.NewPlainMessage(newKey), nil)
+		if err != nil {
+			return err
+		}
+		encryptedPrevKey, err := kr.Encrypt(plainKey, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(prevLocalKeyName, encryptedPrevKey.GetBinary()); err != nil {
+			return err
+		}
+		if err := b.Put(localKeyName, encryptedKey.GetBinary()); err != nil {
+			return err
+		}
+		key, prevKey = newKey, plainKey.GetBinary()
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, prevKey, nil
+}
+
+// finishLocalKeyRotation forgets the previous key once all data are
+// encrypted by the current one.
+func (store *Store) finishLocalKeyRotation(bucket []byte) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(prevLocalKeyName)
+	})
+}
+
+// getLocalKeyRing returns the key ring local keys are encrypted by, i.e. of
+// the primary address.
+func (store *Store) getLocalKeyRing() (*crypto.KeyRing, error) {
+	addressID, err := store.GetAddressID(store.user.GetPrimaryAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	return store.client().KeyRingForAddressID(addressID)
+}
+
+// newLocalKeyAEAD returns the cipher data are encrypted by with the local key.
+func newLocalKeyAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
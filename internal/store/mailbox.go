@@ -38,6 +38,9 @@ type Mailbox struct {
 	labelName   string
 	color       string
 
+	// filter is set only for virtual mailboxes.
+	filter virtualFilter
+
 	log *logrus.Entry
 
 	isDeleting atomic.Value
@@ -69,21 +72,23 @@ func txNewMailbox(tx *bolt.Tx, storeAddress *Address, labelID, labelPrefix, labe
 		l.WithError(err).Error("Could not initialise mailbox buckets")
 	}
 
-	syncDraftsIfNecssary(tx, mb)
+	syncSystemMailboxIfNecessary(tx, mb)
 
 	return mb, err
 }
 
-func syncDraftsIfNecssary(tx *bolt.Tx, mb *Mailbox) { //nolint[funlen]
-	// We didn't support drafts before v1.2.6 and therefore if we now created
-	// Drafts mailbox we need to check whether counts match (drafts are synced).
-	// If not, sync them from local metadata without need to do full resync,
-	// Can be removed with 1.2.7 or later.
-	if mb.labelID != pmapi.DraftLabel {
+func syncSystemMailboxIfNecessary(tx *bolt.Tx, mb *Mailbox) { //nolint[funlen]
+	// We didn't support drafts before v1.2.6 (and scheduled and snoozed messages
+	// even later) and therefore if we now created such mailbox we need to check
+	// whether counts match (messages are synced). If not, sync them from local
+	// metadata without need to do full resync.
+	switch mb.labelID {
+	case pmapi.DraftLabel, pmapi.ScheduledLabel, pmapi.SnoozedLabel:
+	default:
 		return
 	}
 
-	// If the drafts mailbox total is non-zero, it means it has already been used
+	// If the mailbox total is non-zero, it means it has already been used
 	// and there is no need to continue. Otherwise, we may need to do an initial sync.
 	total, _, _, err := mb.txGetCounts(tx)
 	if err != nil || total != 0 {
@@ -98,11 +103,11 @@ func syncDraftsIfNecssary(tx *bolt.Tx, mb *Mailbox) { //nolint[funlen]
 	foundCounts := false
 	doSync := false
 	for _, count := range counts {
-		if count.LabelID != pmapi.DraftLabel {
+		if count.LabelID != mb.labelID {
 			continue
 		}
 		foundCounts = true
-		log.WithField("total", total).WithField("total-api", count.TotalOnAPI).Debug("Drafts mailbox created: checking need for sync")
+		mb.log.WithField("total", total).WithField("total-api", count.TotalOnAPI).Debug("Mailbox created: checking need for sync")
 		if count.TotalOnAPI == total {
 			continue
 		}
@@ -111,7 +116,7 @@ func syncDraftsIfNecssary(tx *bolt.Tx, mb *Mailbox) { //nolint[funlen]
 	}
 
 	if !foundCounts {
-		log.Debug("Drafts mailbox created: missing counts, refreshing")
+		mb.log.Debug("Mailbox created: missing counts, refreshing")
 		_ = mb.store.updateCountsFromServer()
 	}
 
@@ -122,15 +127,15 @@ func syncDraftsIfNecssary(tx *bolt.Tx, mb *Mailbox) { //nolint[funlen]
 				return err
 			}
 			for _, msgLabelID := range msg.LabelIDs {
-				if msgLabelID == pmapi.DraftLabel {
-					log.WithField("id", msg.ID).Trace("Drafts mailbox created: syncing draft locally")
+				if msgLabelID == mb.labelID {
+					mb.log.WithField("id", msg.ID).Trace("Mailbox created: syncing message locally")
 					_ = mb.txCreateOrUpdateMessages(tx, []*pmapi.Message{msg})
 					break
 				}
 			}
 			return nil
 		})
-		log.WithError(err).Info("Drafts mailbox created: synced localy")
+		mb.log.WithError(err).Info("Mailbox created: synced localy")
 	}
 }
 
@@ -192,6 +197,10 @@ func (storeMailbox *Mailbox) IsSystem() bool {
 // Change has to be propagated to all the same mailboxes in all addresses.
 // The propagation is processed by the event loop.
 func (storeMailbox *Mailbox) Rename(newName string) error {
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
+
 	if storeMailbox.IsSystem() {
 		return fmt.Errorf("cannot rename system mailboxes")
 	}
@@ -219,6 +228,10 @@ func (storeMailbox *Mailbox) Rename(newName string) error {
 // Deletion has to be propagated to all the same mailboxes in all addresses.
 // The propagation is processed by the event loop.
 func (storeMailbox *Mailbox) Delete() error {
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
+
 	storeMailbox.isDeleting.Store(true)
 	return storeMailbox.storeAddress.deleteMailbox(storeMailbox.labelID)
 }
@@ -284,6 +297,6 @@ func (storeMailbox *Mailbox) client() pmapi.Client {
 }
 
 // update is a proxy for the store's db's `Update`.
-func (storeMailbox *Mailbox) db() *bolt.DB {
+func (storeMailbox *Mailbox) db() *storage {
 	return storeMailbox.store.db
 }
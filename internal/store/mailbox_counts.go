@@ -36,6 +36,33 @@ func (storeMailbox *Mailbox) GetCounts() (total, unread, unseenSeqNum uint, err
 	return
 }
 
+// GetCachedCounts returns numbers of total and unread messages in this mailbox
+// as reported by API. They are available (ok is true) only if they matched the
+// DB on the last check and no message has changed since then.
+func (storeMailbox *Mailbox) GetCachedCounts() (total, unread uint, ok bool) {
+	if storeMailbox.IsVirtual() || !storeMailbox.store.countsSynced.Load().(bool) {
+		return 0, 0, false
+	}
+
+	err := storeMailbox.db().View(func(tx *bolt.Tx) error {
+		counts := tx.Bucket(countsBucket).Get([]byte(storeMailbox.labelID))
+		if counts == nil {
+			return nil
+		}
+		mc := &mailboxCounts{}
+		if err := json.Unmarshal(counts, mc); err != nil {
+			return err
+		}
+		total, unread, ok = mc.TotalOnAPI, mc.UnreadOnAPI, true
+		return nil
+	})
+	if err != nil {
+		storeMailbox.log.WithError(err).Warn("Cannot get cached counts")
+		return 0, 0, false
+	}
+	return total, unread, ok
+}
+
 func (storeMailbox *Mailbox) txGetCounts(tx *bolt.Tx) (total, unread, unseenSeqNum uint, err error) {
 	// For total it would be enough to use `bolt.Bucket.Stats().KeyN` but
 	// we also need to retrieve the count of unread emails therefore we are
@@ -102,6 +129,8 @@ func getSystemFolders() []*mailboxCounts {
 		{pmapi.TrashLabel, "Trash", "#000", -6, true, 0, 0},
 		{pmapi.AllMailLabel, "All Mail", "#000", -5, true, 0, 0},
 		{pmapi.DraftLabel, "Drafts", "#000", -4, true, 0, 0},
+		{pmapi.ScheduledLabel, "Scheduled", "#000", -3, true, 0, 0},
+		{pmapi.SnoozedLabel, "Snoozed", "#000", -2, true, 0, 0},
 	}
 }
 
@@ -189,6 +218,20 @@ func (store *Store) getLabelsFromLocalStorage() ([]*pmapi.Label, error) {
 	return labels, nil
 }
 
+// GetUnreadCount returns the number of unread messages with the label as
+// reported by API, e.g. of pmapi.InboxLabel for desktop integration.
+func (store *Store) GetUnreadCount(labelID string) (unread uint, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		mc, err := txGetCountsFromBucketOrNew(tx.Bucket(countsBucket), labelID)
+		if err != nil {
+			return err
+		}
+		unread = mc.UnreadOnAPI
+		return nil
+	})
+	return
+}
+
 func (store *Store) getOnAPICounts() (counts []*mailboxCounts, err error) {
 	err = store.db.View(func(tx *bolt.Tx) error {
 		counts, err = store.txGetOnAPICounts(tx)
@@ -0,0 +1,236 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// countsCheckInterval is how often the counts are checked with the API
+// besides the counts received in events.
+const countsCheckInterval = 15 * time.Minute
+
+// runCountsCheck periodically checks the counts until the store is closed.
+func (store *Store) runCountsCheck() {
+	ticker := time.NewTicker(countsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-store.maintenanceStopCh:
+			return
+		case <-ticker.C:
+			store.checkCounts()
+		}
+	}
+}
+
+// checkCounts downloads the counts and compares them with the database so
+// the drift not reported by events is repaired as well.
+func (store *Store) checkCounts() {
+	counts, err := store.client().CountMessages("")
+	if err != nil {
+		store.log.WithError(err).Warn("Cannot get counts to check")
+		return
+	}
+
+	if _, err := store.isSynced(counts); err != nil {
+		store.log.WithError(err).Warn("Cannot check counts")
+	}
+}
+
+// repairCountsDrift starts repair of labels which counts differ from the API
+// in `drift` and also differed on the previous check. Single mismatch is often
+// just a change which was not received by event yet. Labels are not repaired
+// while the full sync is not finished as the sync will fix them anyway.
+// It must be called with the store lock held.
+func (store *Store) repairCountsDrift(drift map[string]bool) {
+	labelIDs := []string{}
+	for labelID := range drift {
+		if store.countsDrift[labelID] {
+			labelIDs = append(labelIDs, labelID)
+			delete(drift, labelID)
+		}
+	}
+	store.countsDrift = drift
+
+	if len(labelIDs) == 0 || store.isSyncRunning || !store.isSyncFinished() {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&store.repairingCounts, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer store.panicHandler.HandlePanic()
+		defer atomic.StoreInt32(&store.repairingCounts, 0)
+
+		for _, labelID := range labelIDs {
+			if err := store.repairLabel(labelID); err != nil {
+				store.log.WithError(err).WithField("label", labelID).Warn("Cannot repair label counts")
+				return
+			}
+			store.log.WithField("label", labelID).Info("Label counts repaired")
+		}
+	}()
+}
+
+// repairLabel downloads metadata of all messages of the label and updates
+// messages in the database which are in the label only on one side.
+func (store *Store) repairLabel(labelID string) error {
+	api := store.client()
+
+	onAPI := map[string]bool{}
+	for page := 0; ; page++ {
+		msgs, total, err := api.ListMessages(&pmapi.MessagesFilter{
+			LabelID:  labelID,
+			Page:     page,
+			PageSize: maxFilterPageSize,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to list messages")
+		}
+		for _, msg := range msgs {
+			onAPI[msg.ID] = true
+		}
+		if len(msgs) != 0 {
+			if err := store.createOrUpdateMessagesEvent(msgs); err != nil {
+				return errors.Wrap(err, "failed to update messages")
+			}
+		}
+		if len(msgs) < maxFilterPageSize || len(onAPI) >= total {
+			break
+		}
+	}
+
+	localIDs, err := store.getLabelAPIIDs(labelID)
+	if err != nil {
+		return err
+	}
+
+	onlyLocal := []string{}
+	for _, apiID := range localIDs {
+		if !onAPI[apiID] {
+			onlyLocal = append(onlyLocal, apiID)
+		}
+	}
+
+	// Messages missing on API were either moved out of the label or deleted.
+	// Batch is split when the IDs do not fit into the request.
+	n := maxFilterPageSize
+	for len(onlyLocal) != 0 {
+		if n > len(onlyLocal) {
+			n = len(onlyLocal)
+		}
+		batch := onlyLocal[:n]
+
+		msgs, total, err := api.ListMessages(&pmapi.MessagesFilter{
+			ID:       batch,
+			PageSize: maxFilterPageSize,
+		})
+		if err == pmapi.ErrRequestURITooLong && n > 1 {
+			n /= 2
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to list messages")
+		}
+
+		if err := store.repairBatch(batch, msgs, total); err != nil {
+			return err
+		}
+		onlyLocal = onlyLocal[n:]
+	}
+
+	return nil
+}
+
+// repairBatch updates messages of the batch which were returned by the API
+// filtered by IDs of the batch and deletes the others. Nothing is deleted
+// unless the response is known to be filtered by exactly the batch, as any
+// message of the batch missing in an unfiltered response would be lost.
+func (store *Store) repairBatch(batch []string, msgs []*pmapi.Message, total int) error {
+	deleted := map[string]bool{}
+	for _, apiID := range batch {
+		deleted[apiID] = true
+	}
+
+	if total > len(batch) || total != len(msgs) {
+		return errors.Errorf("response is not filtered by requested IDs (total %d of %d IDs)", total, len(batch))
+	}
+	for _, msg := range msgs {
+		if !deleted[msg.ID] {
+			return errors.Errorf("response is not filtered by requested IDs (unexpected %s)", msg.ID)
+		}
+	}
+
+	updated := []*pmapi.Message{}
+	for _, msg := range msgs {
+		delete(deleted, msg.ID)
+		updated = append(updated, msg)
+	}
+
+	if len(updated) != 0 {
+		if err := store.createOrUpdateMessagesEvent(updated); err != nil {
+			return errors.Wrap(err, "failed to update messages")
+		}
+	}
+	if len(deleted) != 0 {
+		deletedIDs := []string{}
+		for apiID := range deleted {
+			deletedIDs = append(deletedIDs, apiID)
+		}
+		if err := store.deleteMessagesEvent(deletedIDs); err != nil {
+			return errors.Wrap(err, "failed to delete messages")
+		}
+	}
+
+	return nil
+}
+
+// getLabelAPIIDs returns API IDs of messages in mailboxes of the label of all
+// addresses.
+func (store *Store) getLabelAPIIDs(labelID string) (apiIDs []string, err error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	seen := map[string]bool{}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		for _, address := range store.addresses {
+			mbox, err := address.getMailboxByID(labelID)
+			if err != nil {
+				return errors.Wrapf(err, "cannot find mailbox for address %q", address.addressID)
+			}
+			c := mbox.txGetAPIIDsBucket(tx).Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if !seen[string(k)] {
+					seen[string(k)] = true
+					apiIDs = append(apiIDs, string(k))
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
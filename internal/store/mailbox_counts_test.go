@@ -19,9 +19,12 @@ package store
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	gomock "github.com/golang/mock/gomock"
 	a "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func newLabel(order int, id, name string) *pmapi.Label {
@@ -60,15 +63,17 @@ func TestSortByOrder(t *testing.T) {
 
 func TestMailboxNames(t *testing.T) {
 	want := map[string]string{
-		pmapi.InboxLabel:   "INBOX",
-		pmapi.SentLabel:    "Sent",
-		pmapi.ArchiveLabel: "Archive",
-		pmapi.SpamLabel:    "Spam",
-		pmapi.TrashLabel:   "Trash",
-		pmapi.AllMailLabel: "All Mail",
-		pmapi.DraftLabel:   "Drafts",
-		"labelID1":         "Labels/Label1",
-		"folderID1":        "Folders/Folder1",
+		pmapi.InboxLabel:     "INBOX",
+		pmapi.SentLabel:      "Sent",
+		pmapi.ArchiveLabel:   "Archive",
+		pmapi.SpamLabel:      "Spam",
+		pmapi.TrashLabel:     "Trash",
+		pmapi.AllMailLabel:   "All Mail",
+		pmapi.DraftLabel:     "Drafts",
+		pmapi.ScheduledLabel: "Scheduled",
+		pmapi.SnoozedLabel:   "Snoozed",
+		"labelID1":           "Labels/Label1",
+		"folderID1":          "Folders/Folder1",
 	}
 
 	foldersAndLabels := []*pmapi.Label{
@@ -91,7 +96,7 @@ func TestMailboxNames(t *testing.T) {
 func TestAddSystemLabels(t *testing.T) {}
 
 func checkCounts(t testing.TB, wantCounts []*pmapi.MessagesCount, haveStore *Store) {
-	nSystemFolders := 7
+	nSystemFolders := 9
 	haveCounts, err := haveStore.getOnAPICounts()
 	a.NoError(t, err)
 	a.Len(t, haveCounts, len(wantCounts)+nSystemFolders)
@@ -124,3 +129,175 @@ func TestMailboxCountRemove(t *testing.T) {
 	a.NoError(t, m.store.removeMailboxCount(pop.LabelID))
 	checkCounts(t, testCounts, m.store)
 }
+
+func TestMailboxCachedCounts(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	storeAddress, err := m.store.GetAddress(addrID1)
+	require.NoError(t, err)
+	inbox, err := storeAddress.getMailboxByID(pmapi.InboxLabel)
+	require.NoError(t, err)
+
+	_, _, ok := inbox.GetCachedCounts()
+	a.False(t, ok, "counts were not checked yet")
+
+	synced, err := m.store.isSynced([]*pmapi.MessagesCount{
+		{LabelID: pmapi.InboxLabel, Total: 1, Unread: 1},
+		{LabelID: pmapi.AllMailLabel, Total: 1, Unread: 1},
+	})
+	require.NoError(t, err)
+	require.True(t, synced)
+
+	total, unread, ok := inbox.GetCachedCounts()
+	a.True(t, ok)
+	a.Equal(t, uint(1), total)
+	a.Equal(t, uint(1), unread)
+
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	_, _, ok = inbox.GetCachedCounts()
+	a.False(t, ok, "message changed after the check")
+}
+
+func TestRepairLabel(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Moved", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Kept", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg4", "Deleted", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		LabelID:  pmapi.InboxLabel,
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{
+		getTestMessage("msg2", "Kept", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+		getTestMessage("msg3", "Missed", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+	}, 2, nil)
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		ID:       []string{"msg1", "msg4"},
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{
+		getTestMessage("msg1", "Moved", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel}),
+	}, 1, nil)
+
+	require.NoError(t, m.store.repairLabel(pmapi.InboxLabel))
+
+	storeAddress, err := m.store.GetAddress(addrID1)
+	require.NoError(t, err)
+	inbox, err := storeAddress.getMailboxByID(pmapi.InboxLabel)
+	require.NoError(t, err)
+	archive, err := storeAddress.getMailboxByID(pmapi.ArchiveLabel)
+	require.NoError(t, err)
+
+	total, unread, _, err := inbox.GetCounts()
+	require.NoError(t, err)
+	a.Equal(t, uint(2), total)
+	a.Equal(t, uint(2), unread)
+
+	total, _, _, err = archive.GetCounts()
+	require.NoError(t, err)
+	a.Equal(t, uint(1), total)
+
+	checkAllMessageIDs(t, m, []string{"msg1", "msg2", "msg3"})
+}
+
+func TestRepairLabelSplitsTooLongRequest(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Moved", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg4", "Deleted", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		LabelID:  pmapi.InboxLabel,
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{}, 0, nil)
+	gomock.InOrder(
+		m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+			ID:       []string{"msg1", "msg4"},
+			PageSize: maxFilterPageSize,
+		}).Return(nil, 0, pmapi.ErrRequestURITooLong),
+		m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+			ID:       []string{"msg1"},
+			PageSize: maxFilterPageSize,
+		}).Return([]*pmapi.Message{
+			getTestMessage("msg1", "Moved", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.ArchiveLabel}),
+		}, 1, nil),
+		m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+			ID:       []string{"msg4"},
+			PageSize: maxFilterPageSize,
+		}).Return([]*pmapi.Message{}, 0, nil),
+	)
+
+	require.NoError(t, m.store.repairLabel(pmapi.InboxLabel))
+
+	checkAllMessageIDs(t, m, []string{"msg1"})
+}
+
+func TestRepairLabelDeletesNothingWithoutIDFilter(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Local", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg4", "Local", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		LabelID:  pmapi.InboxLabel,
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{}, 0, nil)
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		ID:       []string{"msg1", "msg4"},
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{
+		getTestMessage("msg9", "Unrelated", addrID1, 0, []string{pmapi.AllMailLabel}),
+	}, 1000, nil)
+
+	require.Error(t, m.store.repairLabel(pmapi.InboxLabel))
+
+	checkAllMessageIDs(t, m, []string{"msg1", "msg4"})
+}
+
+func TestRepairCountsDriftOnlyWhenRepeated(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	require.Eventually(t, m.store.isSyncFinished, time.Second, 10*time.Millisecond)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	counts := []*pmapi.MessagesCount{
+		{LabelID: pmapi.InboxLabel, Total: 2, Unread: 0},
+		{LabelID: pmapi.AllMailLabel, Total: 1, Unread: 0},
+	}
+
+	// The first mismatch can be just an event which did not arrive yet.
+	synced, err := m.store.isSynced(counts)
+	require.NoError(t, err)
+	require.False(t, synced)
+
+	m.client.EXPECT().ListMessages(&pmapi.MessagesFilter{
+		LabelID:  pmapi.InboxLabel,
+		PageSize: maxFilterPageSize,
+	}).Return([]*pmapi.Message{
+		getTestMessage("msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+		getTestMessage("msg2", "Test message 2", addrID1, 0, []string{pmapi.InboxLabel}),
+	}, 2, nil)
+
+	synced, err = m.store.isSynced(counts)
+	require.NoError(t, err)
+	require.False(t, synced)
+
+	require.Eventually(t, func() bool {
+		synced, err := m.store.isSynced(counts)
+		return err == nil && synced
+	}, time.Second, 10*time.Millisecond)
+}
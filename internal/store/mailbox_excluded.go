@@ -0,0 +1,147 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newExcludedMailboxes returns set of lower-cased mailbox names or label IDs
+// which should not be synced nor exposed over IMAP.
+func newExcludedMailboxes(names []string) map[string]bool {
+	excluded := map[string]bool{}
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			excluded[strings.ToLower(name)] = true
+		}
+	}
+	return excluded
+}
+
+// isLabelExcluded returns whether the label is excluded from sync either by
+// its ID or by its full IMAP name (e.g. `Folders/Archive`). INBOX cannot be
+// excluded. The result is remembered so messages can be filtered by label ID.
+func (store *Store) isLabelExcluded(label *pmapi.Label) bool {
+	excluded := label.ID != pmapi.InboxLabel &&
+		(store.excludedMailboxes[strings.ToLower(label.ID)] ||
+			store.excludedMailboxes[strings.ToLower(getLabelPrefix(label)+label.Path)])
+
+	store.excludedLock.Lock()
+	defer store.excludedLock.Unlock()
+
+	if excluded {
+		store.excludedLabelIDs[label.ID] = true
+	} else {
+		delete(store.excludedLabelIDs, label.ID)
+	}
+	return excluded
+}
+
+// isLabelIDExcluded returns whether the label with given ID was excluded
+// from sync by isLabelExcluded.
+func (store *Store) isLabelIDExcluded(labelID string) bool {
+	store.excludedLock.RLock()
+	defer store.excludedLock.RUnlock()
+
+	return store.excludedLabelIDs[labelID]
+}
+
+// isMessageExcluded returns whether the message belongs only to excluded
+// mailboxes and therefore should not be stored locally.
+func (store *Store) isMessageExcluded(msg *pmapi.Message) bool {
+	if len(store.excludedMailboxes) == 0 {
+		return false
+	}
+
+	hasMailbox := false
+	for _, labelID := range msg.LabelIDs {
+		if skipThisLabel(labelID) {
+			continue
+		}
+		if !store.isLabelIDExcluded(labelID) {
+			return false
+		}
+		hasMailbox = true
+	}
+	return hasMailbox
+}
+
+// removeExcludedMessages removes messages which belong only to excluded
+// mailboxes, for example after the mailbox was excluded from sync.
+func (store *Store) removeExcludedMessages() error {
+	if len(store.excludedMailboxes) == 0 {
+		return nil
+	}
+
+	apiIDs := []string{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			msg := &pmapi.Message{}
+			if err := json.Unmarshal(v, msg); err != nil {
+				return err
+			}
+			if store.isMessageExcluded(msg) {
+				apiIDs = append(apiIDs, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(apiIDs) == 0 {
+		return err
+	}
+
+	store.log.WithField("messages", len(apiIDs)).Info("Removing messages of excluded mailboxes")
+	return store.deleteMessagesEvent(apiIDs)
+}
+
+// removeExcludedMessagesEvent returns only messages which should be stored.
+// Messages which belong only to excluded mailboxes are not stored and if they
+// were stored before (e.g. moved to excluded mailbox), they are removed.
+func (store *Store) removeExcludedMessagesEvent(msgs []*pmapi.Message) ([]*pmapi.Message, error) {
+	if len(store.excludedMailboxes) == 0 {
+		return msgs, nil
+	}
+
+	included := []*pmapi.Message{}
+	storedIDs := []string{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metadataBucket)
+		for _, msg := range msgs {
+			if !store.isMessageExcluded(msg) {
+				included = append(included, msg)
+			} else if b.Get([]byte(msg.ID)) != nil {
+				storedIDs = append(storedIDs, msg.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(storedIDs) != 0 {
+		if err := store.deleteMessagesEvent(storedIDs); err != nil {
+			return nil, err
+		}
+	}
+	return included, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLabelExcluded(t *testing.T) {
+	store := &Store{
+		excludedMailboxes: newExcludedMailboxes([]string{" spam ", "Folders/Archive", "labelID", ""}),
+		excludedLabelIDs:  map[string]bool{},
+	}
+
+	tests := []struct {
+		label *pmapi.Label
+		want  bool
+	}{
+		{&pmapi.Label{ID: pmapi.SpamLabel, Path: "Spam"}, true},
+		{&pmapi.Label{ID: pmapi.ArchiveLabel, Path: "Archive"}, false},
+		{&pmapi.Label{ID: "folderID", Path: "archive", Exclusive: 1}, true},
+		{&pmapi.Label{ID: "otherID", Path: "Archive"}, false},
+		{&pmapi.Label{ID: "labelID", Path: "Work"}, true},
+		{&pmapi.Label{ID: pmapi.InboxLabel, Path: "INBOX"}, false},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, store.isLabelExcluded(tc.label), tc.label.ID)
+		require.Equal(t, tc.want, store.isLabelIDExcluded(tc.label.ID), tc.label.ID)
+	}
+
+	require.True(t, store.isMessageExcluded(&pmapi.Message{LabelIDs: []string{pmapi.SpamLabel, pmapi.StarredLabel}}))
+	require.False(t, store.isMessageExcluded(&pmapi.Message{LabelIDs: []string{pmapi.SpamLabel, pmapi.InboxLabel}}))
+	require.False(t, store.isMessageExcluded(&pmapi.Message{LabelIDs: []string{}}))
+}
+
+func TestExcludedMailboxesAreNotSynced(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.excludedMailboxes = []string{"Spam", "All Mail"}
+	m.newStoreNoEvents(true)
+
+	_, err := m.store.addresses[addrID1].getMailboxByID(pmapi.SpamLabel)
+	require.Error(t, err)
+	_, err = m.store.addresses[addrID1].getMailboxByID(pmapi.AllMailLabel)
+	require.Error(t, err)
+	_, err = m.store.addresses[addrID1].getMailboxByID(pmapi.InboxLabel)
+	require.NoError(t, err)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.SpamLabel})
+	checkAllMessageIDs(t, m, []string{"msg1"})
+
+	// Message moved to excluded mailbox is removed.
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.SpamLabel})
+	checkAllMessageIDs(t, m, nil)
+}
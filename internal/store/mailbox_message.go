@@ -18,8 +18,11 @@
 package store
 
 import (
+	"io"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/pkg/changes"
+	"github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -50,9 +53,14 @@ func (storeMailbox *Mailbox) FetchMessage(apiID string) (*Message, error) {
 	return newStoreMessage(storeMailbox, msg), nil
 }
 
-// ImportMessage imports the message by calling an API.
+// ImportMessage imports the message by calling an API. The body is read while
+// the request is sent.
 // It has to be propagated to all mailboxes which is done by the event loop.
-func (storeMailbox *Mailbox) ImportMessage(msg *pmapi.Message, body []byte, labelIDs []string) error {
+func (storeMailbox *Mailbox) ImportMessage(msg *pmapi.Message, body io.Reader, labelIDs []string) error {
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
+
 	defer storeMailbox.pollNow()
 
 	if storeMailbox.labelID != pmapi.AllMailLabel {
@@ -60,12 +68,12 @@ func (storeMailbox *Mailbox) ImportMessage(msg *pmapi.Message, body []byte, labe
 	}
 
 	importReqs := &pmapi.ImportMsgReq{
-		AddressID: msg.AddressID,
-		Body:      body,
-		Unread:    msg.Unread,
-		Flags:     msg.Flags,
-		Time:      msg.Time,
-		LabelIDs:  labelIDs,
+		AddressID:  msg.AddressID,
+		BodyReader: body,
+		Unread:     msg.Unread,
+		Flags:      msg.Flags,
+		Time:       msg.Time,
+		LabelIDs:   labelIDs,
 	}
 
 	res, err := storeMailbox.client().Import([]*pmapi.ImportMsgReq{importReqs})
@@ -92,8 +100,11 @@ func (storeMailbox *Mailbox) LabelMessages(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
 	defer storeMailbox.pollNow()
-	return storeMailbox.client().LabelMessages(apiIDs, storeMailbox.labelID)
+	return storeMailbox.store.labelMessages(apiIDs, storeMailbox.labelID)
 }
 
 // UnlabelMessages removes the label by calling an API.
@@ -105,8 +116,11 @@ func (storeMailbox *Mailbox) UnlabelMessages(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
 	defer storeMailbox.pollNow()
-	return storeMailbox.client().UnlabelMessages(apiIDs, storeMailbox.labelID)
+	return storeMailbox.store.unlabelMessages(apiIDs, storeMailbox.labelID)
 }
 
 // MarkMessagesRead marks the message read by calling an API.
@@ -133,7 +147,11 @@ func (storeMailbox *Mailbox) MarkMessagesRead(apiIDs []string) error {
 	if len(ids) == 0 {
 		return nil
 	}
-	return storeMailbox.client().MarkMessagesRead(ids)
+	if err := storeMailbox.store.markMessagesRead(ids); err != nil {
+		return err
+	}
+	storeMailbox.store.requestReadReceipts(ids)
+	return nil
 }
 
 // MarkMessagesUnread marks the message unread by calling an API.
@@ -145,7 +163,7 @@ func (storeMailbox *Mailbox) MarkMessagesUnread(apiIDs []string) error {
 		"mailbox":  storeMailbox.Name,
 	}).Trace("Marking messages as unread")
 	defer storeMailbox.pollNow()
-	return storeMailbox.client().MarkMessagesUnread(apiIDs)
+	return storeMailbox.store.markMessagesUnread(apiIDs)
 }
 
 // MarkMessagesStarred adds the Starred label by calling an API.
@@ -158,7 +176,7 @@ func (storeMailbox *Mailbox) MarkMessagesStarred(apiIDs []string) error {
 		"mailbox":  storeMailbox.Name,
 	}).Trace("Marking messages as starred")
 	defer storeMailbox.pollNow()
-	return storeMailbox.client().LabelMessages(apiIDs, pmapi.StarredLabel)
+	return storeMailbox.store.labelMessages(apiIDs, pmapi.StarredLabel)
 }
 
 // MarkMessagesUnstarred removes the Starred label by calling an API.
@@ -171,7 +189,7 @@ func (storeMailbox *Mailbox) MarkMessagesUnstarred(apiIDs []string) error {
 		"mailbox":  storeMailbox.Name,
 	}).Trace("Marking messages as unstarred")
 	defer storeMailbox.pollNow()
-	return storeMailbox.client().UnlabelMessages(apiIDs, pmapi.StarredLabel)
+	return storeMailbox.store.unlabelMessages(apiIDs, pmapi.StarredLabel)
 }
 
 // MarkMessagesDeleted adds local flag \Deleted. This is not propagated to API
@@ -185,6 +203,9 @@ func (storeMailbox *Mailbox) MarkMessagesDeleted(apiIDs []string) error {
 	if storeMailbox.labelID == pmapi.AllMailLabel {
 		return ErrAllMailOpNotAllowed
 	}
+	if storeMailbox.IsVirtual() {
+		return ErrVirtualMailboxOpNotAllowed
+	}
 	return storeMailbox.store.db.Update(func(tx *bolt.Tx) error {
 		return storeMailbox.txMarkMessagesAsDeleted(tx, apiIDs, true)
 	})
@@ -206,12 +227,40 @@ func (storeMailbox *Mailbox) MarkMessagesUndeleted(apiIDs []string) error {
 	})
 }
 
+// Expunge policies deciding what RemoveDeleted does with the messages.
+const (
+	// ExpungeDefault removes the label of the mailbox, except Trash and Spam
+	// where messages are deleted unless they have some custom label.
+	ExpungeDefault = ""
+
+	// ExpungeMoveToTrash moves messages to Trash. In Trash and Spam it is
+	// the same as ExpungeDefault.
+	ExpungeMoveToTrash = "trash"
+
+	// ExpungeRemoveLabel only removes the label of the mailbox, also in Trash
+	// and Spam.
+	ExpungeRemoveLabel = "label"
+
+	// ExpungePermanent deletes messages from Trash and Spam even when they
+	// have custom labels. In other mailboxes it is the same as ExpungeDefault.
+	ExpungePermanent = "permanent"
+)
+
+// IsExpungePolicy returns whether `policy` is one of known expunge policies.
+func IsExpungePolicy(policy string) bool {
+	switch policy {
+	case ExpungeDefault, ExpungeMoveToTrash, ExpungeRemoveLabel, ExpungePermanent:
+		return true
+	}
+	return false
+}
+
 // RemoveDeleted sends request to API to remove message from mailbox.
 // If the mailbox is All Mail or All Sent, it does nothing.
-// If the mailbox is Trash or Spam and message is not in any other mailbox, messages is deleted.
-// In all other cases the message is only removed from the mailbox.
-func (storeMailbox *Mailbox) RemoveDeleted() error {
-	storeMailbox.log.Trace("Deleting messages")
+// If the mailbox is Drafts, messages are deleted.
+// Otherwise it depends on the expunge `policy`, see ExpungeDefault and others.
+func (storeMailbox *Mailbox) RemoveDeleted(policy string) error {
+	storeMailbox.log.WithField("policy", policy).Trace("Deleting messages")
 
 	apiIDs, err := storeMailbox.GetDeletedAPIIDs()
 	if err != nil {
@@ -227,21 +276,31 @@ func (storeMailbox *Mailbox) RemoveDeleted() error {
 
 	switch storeMailbox.labelID {
 	case pmapi.AllMailLabel, pmapi.AllSentLabel:
-		break
-	case pmapi.TrashLabel, pmapi.SpamLabel:
-		if err := storeMailbox.deleteFromTrashOrSpam(apiIDs); err != nil {
-			return err
-		}
+		return nil
 	case pmapi.DraftLabel:
-		if err := storeMailbox.client().DeleteMessages(apiIDs); err != nil {
-			return err
+		return storeMailbox.store.deleteMessages(apiIDs)
+	case pmapi.TrashLabel, pmapi.SpamLabel:
+		switch policy {
+		case ExpungeRemoveLabel:
+			return storeMailbox.store.unlabelMessages(apiIDs, storeMailbox.labelID)
+		case ExpungePermanent:
+			return storeMailbox.store.deleteMessages(apiIDs)
+		default:
+			return storeMailbox.deleteFromTrashOrSpam(apiIDs)
 		}
-	default:
-		if err := storeMailbox.client().UnlabelMessages(apiIDs, storeMailbox.labelID); err != nil {
-			return err
+	}
+
+	if policy == ExpungeMoveToTrash {
+		// Trash is a folder, so messages would stay in a label mailbox.
+		if storeMailbox.IsLabel() {
+			if err := storeMailbox.store.unlabelMessages(apiIDs, storeMailbox.labelID); err != nil {
+				return err
+			}
 		}
+		return storeMailbox.store.labelMessages(apiIDs, pmapi.TrashLabel)
 	}
-	return nil
+
+	return storeMailbox.store.unlabelMessages(apiIDs, storeMailbox.labelID)
 }
 
 // deleteFromTrashOrSpam will remove messages from API forever. If messages
@@ -275,12 +334,12 @@ func (storeMailbox *Mailbox) deleteFromTrashOrSpam(apiIDs []string) error {
 		}
 	}
 	if len(messageIDsToUnlabel) > 0 {
-		if err := storeMailbox.client().UnlabelMessages(messageIDsToUnlabel, storeMailbox.labelID); err != nil {
+		if err := storeMailbox.store.unlabelMessages(messageIDsToUnlabel, storeMailbox.labelID); err != nil {
 			l.WithError(err).Warning("Cannot unlabel before deleting")
 		}
 	}
 	if len(messageIDsToDelete) > 0 {
-		if err := storeMailbox.client().DeleteMessages(messageIDsToDelete); err != nil {
+		if err := storeMailbox.store.deleteMessages(messageIDsToDelete); err != nil {
 			return err
 		}
 	}
@@ -310,6 +369,12 @@ func (storeMailbox *Mailbox) txSkipAndRemoveFromMailbox(tx *bolt.Tx, msg *pmapi.
 		return
 	}
 
+	// Virtual mailboxes decide the membership by their own filter.
+	if storeMailbox.IsVirtual() {
+		skipAndRemove = !storeMailbox.filter(msg)
+		return
+	}
+
 	// If the message belongs in this mailbox, don't skip/remove it.
 	for _, labelID := range msg.LabelIDs {
 		if labelID == storeMailbox.labelID {
@@ -398,6 +463,7 @@ func (storeMailbox *Mailbox) txCreateOrUpdateMessages(tx *bolt.Tx, msgs []*pmapi
 			msg,
 			false, // new message is never marked as deleted
 		)
+		storeMailbox.recordChange(changes.Added, msg.ID, message.GetFlags(msg))
 		shouldSendMailboxUpdate = true
 	}
 
@@ -440,6 +506,8 @@ func (storeMailbox *Mailbox) txDeleteMessage(tx *bolt.Tx, apiID string) error {
 		return errors.Wrap(err, "cannot delete from mark-as-deleted bucket")
 	}
 
+	storeMailbox.recordChange(changes.Removed, apiID, nil)
+
 	if seqNumErr == nil {
 		storeMailbox.store.imapDeleteMessage(
 			storeMailbox.storeAddress.address,
@@ -454,6 +522,21 @@ func (storeMailbox *Mailbox) txDeleteMessage(tx *bolt.Tx, apiID string) error {
 	return nil
 }
 
+// recordChange records the change of the message in this mailbox to the change
+// journal. Virtual mailboxes are not labels on API, so they are not recorded.
+func (storeMailbox *Mailbox) recordChange(changeType, apiID string, flags []string) {
+	if storeMailbox.IsVirtual() {
+		return
+	}
+	storeMailbox.store.recordChange(&changes.Change{
+		Type:      changeType,
+		AddressID: storeMailbox.storeAddress.addressID,
+		LabelID:   storeMailbox.labelID,
+		MessageID: apiID,
+		Flags:     flags,
+	})
+}
+
 func (storeMailbox *Mailbox) txMailboxStatusUpdate(tx *bolt.Tx) error {
 	total, unread, unreadSeqNum, err := storeMailbox.txGetCounts(tx)
 	if err != nil {
@@ -0,0 +1,97 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveDeletedPolicies(t *testing.T) {
+	tests := []struct {
+		labelID string
+		policy  string
+		expect  func(m *mocksForStore)
+	}{
+		{pmapi.InboxLabel, ExpungeDefault, func(m *mocksForStore) {
+			m.client.EXPECT().UnlabelMessages([]string{"msg1"}, pmapi.InboxLabel)
+		}},
+		{pmapi.InboxLabel, ExpungePermanent, func(m *mocksForStore) {
+			m.client.EXPECT().UnlabelMessages([]string{"msg1"}, pmapi.InboxLabel)
+		}},
+		{pmapi.InboxLabel, ExpungeMoveToTrash, func(m *mocksForStore) {
+			m.client.EXPECT().LabelMessages([]string{"msg1"}, pmapi.TrashLabel)
+		}},
+		{pmapi.TrashLabel, ExpungeRemoveLabel, func(m *mocksForStore) {
+			m.client.EXPECT().UnlabelMessages([]string{"msg1"}, pmapi.TrashLabel)
+		}},
+		{pmapi.TrashLabel, ExpungePermanent, func(m *mocksForStore) {
+			m.client.EXPECT().DeleteMessages([]string{"msg1"})
+		}},
+		{pmapi.TrashLabel, ExpungeMoveToTrash, func(m *mocksForStore) {
+			m.client.EXPECT().DeleteMessages([]string{"msg1"})
+		}},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.labelID+"/"+tc.policy, func(t *testing.T) {
+			m, clear := initMocks(t)
+			defer clear()
+			m.newStoreNoEvents(true)
+
+			insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, tc.labelID})
+
+			mailbox, err := m.store.addresses[addrID1].getMailboxByID(tc.labelID)
+			require.NoError(t, err)
+			require.NoError(t, mailbox.MarkMessagesDeleted([]string{"msg1"}))
+
+			tc.expect(m)
+			require.NoError(t, mailbox.RemoveDeleted(tc.policy))
+		})
+	}
+}
+
+func TestIsExpungePolicy(t *testing.T) {
+	for _, policy := range []string{ExpungeDefault, ExpungeMoveToTrash, ExpungeRemoveLabel, ExpungePermanent} {
+		require.True(t, IsExpungePolicy(policy))
+	}
+	require.False(t, IsExpungePolicy("unknown"))
+}
+
+func TestMarkMessagesReadRequestsReadReceipts(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+	m.newStoreNoEvents(true)
+
+	withReceipt := getTestMessage("msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	withReceipt.Header = mail.Header{"Disposition-Notification-To": []string{"sender@example.com"}}
+	require.NoError(t, m.store.createOrUpdateMessageEvent(withReceipt))
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+
+	mailbox, err := m.store.addresses[addrID1].getMailboxByID(pmapi.InboxLabel)
+	require.NoError(t, err)
+
+	m.client.EXPECT().MarkMessagesRead([]string{"msg1", "msg2"})
+	m.events.EXPECT().Emit(events.ReadReceiptRequestEvent, "userID:msg1")
+	require.NoError(t, mailbox.MarkMessagesRead([]string{"msg1", "msg2"}))
+}
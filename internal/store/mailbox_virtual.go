@@ -0,0 +1,375 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Virtual mailboxes are read-only views on top of the local metadata.
+// They are not known to the API: the membership of a message is decided
+// by a filter every time the message is created or updated in the store.
+const (
+	virtualLabelPrefix       = "virtual:"
+	virtualStarredLabel      = virtualLabelPrefix + "starred"
+	virtualUnreadLabel       = virtualLabelPrefix + "unread"
+	virtualSavedSearchPrefix = virtualLabelPrefix + "search:"
+)
+
+// ErrVirtualMailboxOpNotAllowed is returned when a client tries to modify
+// the content or structure of a virtual mailbox.
+var ErrVirtualMailboxOpNotAllowed = errors.New("operation not allowed for virtual mailbox") //nolint[gochecknoglobals]
+
+// virtualFilter decides whether the message belongs to a virtual mailbox.
+type virtualFilter func(msg *pmapi.Message) bool
+
+type virtualMailbox struct {
+	labelID string
+	name    string
+	filter  virtualFilter
+
+	// query describes the filter with resolved labels. The mailbox is
+	// filled again when the query differs from the one it was filled by.
+	query string
+}
+
+// IsVirtualLabel returns whether the labelID belongs to a virtual mailbox.
+func IsVirtualLabel(labelID string) bool {
+	return strings.HasPrefix(labelID, virtualLabelPrefix)
+}
+
+// getVirtualMailboxes returns built-in virtual mailboxes followed by saved
+// searches. Saved searches which cannot be parsed are skipped.
+func (store *Store) getVirtualMailboxes(labels []*pmapi.Label) []*virtualMailbox {
+	virtuals := []*virtualMailbox{
+		{virtualStarredLabel, "Starred", isStarred, "is:starred"},
+		{virtualUnreadLabel, "Unread", isUnreadInMailbox, "is:unread -label:trash -label:spam"},
+	}
+
+	for name, query := range store.savedSearches {
+		l := store.log.WithField("search", name)
+
+		if name == "" || strings.Contains(name, PathDelimiter) {
+			l.Warn("Skipping saved search with invalid name")
+			continue
+		}
+
+		filter, resolved, err := parseSavedSearch(query, labels)
+		if err != nil {
+			l.WithError(err).Warn("Skipping saved search with invalid query")
+			continue
+		}
+
+		virtuals = append(virtuals, &virtualMailbox{virtualSavedSearchPrefix + name, name, filter, resolved})
+	}
+
+	return virtuals
+}
+
+// txInitVirtualMailboxes creates virtual mailboxes for the address and
+// deletes mailboxes of removed saved searches. Mailboxes which are new or
+// whose query changed are filled from the local metadata.
+func (storeAddress *Address) txInitVirtualMailboxes(tx *bolt.Tx, virtuals []*virtualMailbox) error {
+	queries, err := tx.CreateBucketIfNotExists(virtualQueryBucket)
+	if err != nil {
+		return err
+	}
+
+	if err := storeAddress.txDeleteRemovedSavedSearches(tx, queries, virtuals); err != nil {
+		return err
+	}
+
+	for _, virtual := range virtuals {
+		bucketName := getMailboxBucketName(storeAddress.addressID, virtual.labelID)
+		isNew := tx.Bucket(mailboxesBucket).Bucket(bucketName) == nil
+
+		mailbox, ok := storeAddress.mailboxes[virtual.labelID]
+		if !ok || isNew {
+			if mailbox, err = txNewMailbox(tx, storeAddress, virtual.labelID, VirtualMailboxesPrefix, virtual.name, ""); err != nil {
+				return err
+			}
+			storeAddress.mailboxes[virtual.labelID] = mailbox
+		}
+		mailbox.filter = virtual.filter
+
+		if !isNew && string(queries.Get(bucketName)) == virtual.query {
+			continue
+		}
+
+		if err := mailbox.txSyncFromMetadata(tx); err != nil {
+			mailbox.log.WithError(err).Warn("Could not fill virtual mailbox")
+			continue
+		}
+
+		if err := queries.Put(bucketName, []byte(virtual.query)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// txDeleteRemovedSavedSearches deletes mailbox buckets and queries of saved
+// searches of the address which are not in virtuals anymore.
+func (storeAddress *Address) txDeleteRemovedSavedSearches(tx *bolt.Tx, queries *bolt.Bucket, virtuals []*virtualMailbox) error {
+	current := map[string]bool{}
+	for _, virtual := range virtuals {
+		current[string(getMailboxBucketName(storeAddress.addressID, virtual.labelID))] = true
+	}
+
+	prefix := getMailboxBucketName(storeAddress.addressID, virtualSavedSearchPrefix)
+
+	for _, bucket := range []*bolt.Bucket{tx.Bucket(mailboxesBucket), queries} {
+		var removed [][]byte
+
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if !current[string(k)] {
+				removed = append(removed, append([]byte{}, k...))
+			}
+		}
+
+		for _, name := range removed {
+			storeAddress.log.WithField("mailbox", string(name)).Info("Deleting mailbox of removed saved search")
+			delete(storeAddress.mailboxes, strings.TrimPrefix(string(name), storeAddress.addressID+"-"))
+
+			if bucket.Bucket(name) != nil {
+				if err := bucket.DeleteBucket(name); err != nil {
+					return err
+				}
+			} else if err := bucket.Delete(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// txSyncFromMetadata adds all messages matching the mailbox filter from
+// the local metadata and removes messages which do not match anymore.
+// Messages which stay in the mailbox keep their UIDs.
+func (storeMailbox *Mailbox) txSyncFromMetadata(tx *bolt.Tx) error {
+	return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+		msg := &pmapi.Message{}
+		if err := json.Unmarshal(v, msg); err != nil {
+			return err
+		}
+		return storeMailbox.txCreateOrUpdateMessages(tx, []*pmapi.Message{msg})
+	})
+}
+
+// updateVirtualMailboxes resolves saved searches again, because their label
+// terms refer to labels by name which can be renamed or deleted. The caller
+// must hold the store lock.
+func (store *Store) updateVirtualMailboxes() error {
+	labels, err := store.getLabelsFromLocalStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, storeAddress := range store.addresses {
+		if err := storeAddress.updateVirtualMailboxes(store.getVirtualMailboxes(labels)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (storeAddress *Address) updateVirtualMailboxes(virtuals []*virtualMailbox) error {
+	existing := map[string]bool{}
+	for labelID, mailbox := range storeAddress.mailboxes {
+		if mailbox.IsVirtual() {
+			existing[labelID] = true
+		}
+	}
+
+	// Removed mailboxes are deleted the same way as deleted labels, so
+	// connections which selected them are closed.
+	kept := map[string]bool{}
+	for _, virtual := range virtuals {
+		kept[virtual.labelID] = true
+	}
+	for labelID := range existing {
+		if !kept[labelID] {
+			if err := storeAddress.deleteMailboxEvent(labelID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := storeAddress.store.db.Update(func(tx *bolt.Tx) error {
+		return storeAddress.txInitVirtualMailboxes(tx, virtuals)
+	}); err != nil {
+		return err
+	}
+
+	for _, virtual := range virtuals {
+		if !existing[virtual.labelID] {
+			storeAddress.store.imapMailboxCreated(storeAddress.address, storeAddress.mailboxes[virtual.labelID].labelName)
+		}
+	}
+
+	return nil
+}
+
+// IsVirtual returns whether the mailbox is a read-only view (has "Views/" prefix).
+func (storeMailbox *Mailbox) IsVirtual() bool {
+	return storeMailbox.filter != nil
+}
+
+func isStarred(msg *pmapi.Message) bool {
+	return hasLabel(msg, pmapi.StarredLabel)
+}
+
+// isUnreadInMailbox matches unread messages except those in Trash or Spam
+// which usually are not interesting for the user.
+func isUnreadInMailbox(msg *pmapi.Message) bool {
+	return msg.Unread == 1 && !hasLabel(msg, pmapi.TrashLabel) && !hasLabel(msg, pmapi.SpamLabel)
+}
+
+func hasLabel(msg *pmapi.Message, labelID string) bool {
+	for _, msgLabelID := range msg.LabelIDs {
+		if msgLabelID == labelID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSavedSearch converts the query to a filter. It also returns the query
+// with terms in canonical form and labels replaced by IDs, so it changes
+// when a label of the query is renamed. The query is a list of
+// space separated terms which all have to match:
+// * is:unread, is:read, is:starred
+// * has:attachment
+// * from:text, to:text (matches address or name of sender or recipients)
+// * subject:text
+// * label:name (name or ID of the label or folder)
+// * text without keyword is searched in the subject
+func parseSavedSearch(query string, labels []*pmapi.Label) (virtualFilter, string, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, "", errors.New("empty query")
+	}
+
+	filters := []virtualFilter{}
+	resolved := []string{}
+	for _, term := range terms {
+		filter, resolvedTerm, err := parseSavedSearchTerm(term, labels)
+		if err != nil {
+			return nil, "", err
+		}
+		filters = append(filters, filter)
+		resolved = append(resolved, resolvedTerm)
+	}
+
+	return func(msg *pmapi.Message) bool {
+		for _, filter := range filters {
+			if !filter(msg) {
+				return false
+			}
+		}
+		return true
+	}, strings.Join(resolved, " "), nil
+}
+
+func parseSavedSearchTerm(term string, labels []*pmapi.Label) (virtualFilter, string, error) {
+	key, value := "subject", term
+	if idx := strings.Index(term, ":"); idx > 0 {
+		key, value = strings.ToLower(term[:idx]), term[idx+1:]
+	}
+	if value == "" {
+		return nil, "", fmt.Errorf("missing value for %q", key)
+	}
+	value = strings.ToLower(value)
+
+	if key == "label" {
+		for _, label := range labels {
+			if strings.ToLower(label.ID) == value ||
+				strings.ToLower(label.Name) == value ||
+				strings.ToLower(label.Path) == value {
+				labelID := label.ID
+				return func(msg *pmapi.Message) bool { return hasLabel(msg, labelID) }, key + ":" + labelID, nil
+			}
+		}
+		return nil, "", fmt.Errorf("unknown label %q", value)
+	}
+
+	filter, err := parseSavedSearchFilter(key, value)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown search term %q", term)
+	}
+	return filter, key + ":" + value, nil
+}
+
+func parseSavedSearchFilter(key, value string) (virtualFilter, error) {
+	switch key {
+	case "is":
+		switch value {
+		case "unread":
+			return func(msg *pmapi.Message) bool { return msg.Unread == 1 }, nil
+		case "read":
+			return func(msg *pmapi.Message) bool { return msg.Unread == 0 }, nil
+		case "starred":
+			return isStarred, nil
+		}
+	case "has":
+		if value == "attachment" {
+			return func(msg *pmapi.Message) bool { return msg.NumAttachments > 0 }, nil
+		}
+	case "from":
+		return func(msg *pmapi.Message) bool {
+			return matchAddresses([]*mail.Address{msg.Sender}, value)
+		}, nil
+	case "to":
+		return func(msg *pmapi.Message) bool {
+			return matchAddresses(msg.ToList, value) ||
+				matchAddresses(msg.CCList, value) ||
+				matchAddresses(msg.BCCList, value)
+		}, nil
+	case "subject":
+		return func(msg *pmapi.Message) bool {
+			return strings.Contains(strings.ToLower(msg.Subject), value)
+		}, nil
+	}
+
+	return nil, errors.New("unknown search term")
+}
+
+func matchAddresses(addresses []*mail.Address, value string) bool {
+	for _, address := range addresses {
+		if address == nil {
+			continue
+		}
+		if strings.Contains(strings.ToLower(address.Address), value) ||
+			strings.Contains(strings.ToLower(address.Name), value) {
+			return true
+		}
+	}
+	return false
+}
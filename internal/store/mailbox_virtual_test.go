@@ -0,0 +1,188 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	a "github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestVirtualMailboxes(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	insertMessage(t, m, "msg2", "Test message 2", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel, pmapi.StarredLabel})
+	insertMessage(t, m, "msg3", "Test message 3", addrID1, 1, []string{pmapi.AllMailLabel, pmapi.TrashLabel})
+
+	checkVirtualMailboxTotal(t, m, virtualStarredLabel, 1)
+	checkVirtualMailboxTotal(t, m, virtualUnreadLabel, 1)
+
+	// Reading the message removes it from Unread, starring adds it to Starred.
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel, pmapi.StarredLabel})
+
+	checkVirtualMailboxTotal(t, m, virtualStarredLabel, 2)
+	checkVirtualMailboxTotal(t, m, virtualUnreadLabel, 0)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg2"))
+
+	checkVirtualMailboxTotal(t, m, virtualStarredLabel, 1)
+}
+
+func TestVirtualMailboxIsReadOnly(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	mailbox, err := m.store.addresses[addrID1].GetMailbox("Views/Starred")
+	require.NoError(t, err)
+	require.True(t, mailbox.IsVirtual())
+
+	a.Equal(t, ErrVirtualMailboxOpNotAllowed, mailbox.LabelMessages([]string{"msg1"}))
+	a.Equal(t, ErrVirtualMailboxOpNotAllowed, mailbox.UnlabelMessages([]string{"msg1"}))
+	a.Equal(t, ErrVirtualMailboxOpNotAllowed, mailbox.MarkMessagesDeleted([]string{"msg1"}))
+	a.Equal(t, ErrVirtualMailboxOpNotAllowed, mailbox.Rename("Views/Other"))
+	a.Equal(t, ErrVirtualMailboxOpNotAllowed, mailbox.Delete())
+}
+
+func TestParseSavedSearch(t *testing.T) {
+	labels := []*pmapi.Label{{ID: "labelID", Name: "Work", Path: "Work"}}
+
+	msg := &pmapi.Message{
+		Subject:        "Quarterly report",
+		Unread:         1,
+		Sender:         &mail.Address{Name: "Boss", Address: "boss@example.com"},
+		ToList:         []*mail.Address{{Address: "me@example.com"}},
+		LabelIDs:       []string{pmapi.InboxLabel, "labelID"},
+		NumAttachments: 1,
+	}
+
+	tests := []struct {
+		query     string
+		wantMatch bool
+		wantErr   bool
+	}{
+		{"report", true, false},
+		{"subject:invoice", false, false},
+		{"from:boss is:unread", true, false},
+		{"from:BOSS@example.com", true, false},
+		{"from:boss is:read", false, false},
+		{"to:me@example.com has:attachment", true, false},
+		{"is:starred", false, false},
+		{"label:work", true, false},
+		{"label:unknown", false, true},
+		{"is:something", false, true},
+		{"from:", false, true},
+		{"", false, true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.query, func(t *testing.T) {
+			filter, _, err := parseSavedSearch(tc.query, labels)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			a.Equal(t, tc.wantMatch, filter(msg))
+		})
+	}
+}
+
+func TestParseSavedSearchResolvesLabels(t *testing.T) {
+	_, resolved, err := parseSavedSearch("Report label:WORK", []*pmapi.Label{{ID: "labelID", Name: "Work", Path: "Work"}})
+	require.NoError(t, err)
+	a.Equal(t, "subject:report label:labelID", resolved)
+
+	_, renamed, err := parseSavedSearch("Report label:WORK", []*pmapi.Label{{ID: "otherID", Name: "Work", Path: "Work"}})
+	require.NoError(t, err)
+	a.NotEqual(t, resolved, renamed)
+}
+
+func TestSavedSearchMailboxes(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertMessage(t, m, "msg1", "Quarterly report", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	// Label of the message is not known yet.
+	m.client.EXPECT().ListLabels().Return(nil, nil)
+	insertMessage(t, m, "msg2", "Invoice", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel, "labelWork"})
+
+	const labelID = virtualSavedSearchPrefix + "Search"
+	m.store.savedSearches = map[string]string{"Search": "report"}
+	require.NoError(t, m.store.updateVirtualMailboxes())
+	checkVirtualMailboxTotal(t, m, labelID, 1)
+	uid := getVirtualMailboxUID(t, m, labelID, "msg1")
+
+	// Changed query fills the mailbox again, kept messages keep their UIDs.
+	m.store.savedSearches = map[string]string{"Search": "subject:r"}
+	require.NoError(t, m.store.updateVirtualMailboxes())
+	checkVirtualMailboxTotal(t, m, labelID, 1)
+	a.Equal(t, uid, getVirtualMailboxUID(t, m, labelID, "msg1"))
+
+	m.store.savedSearches = map[string]string{"Search": "invoice"}
+	require.NoError(t, m.store.updateVirtualMailboxes())
+	checkVirtualMailboxTotal(t, m, labelID, 1)
+	getVirtualMailboxUID(t, m, labelID, "msg2")
+
+	// Label terms are resolved again when labels change. Deleted mailboxes
+	// close connections, the same as deleted labels.
+	m.user.EXPECT().CloseAllConnections().Times(2)
+	m.store.savedSearches = map[string]string{"Search": "label:Work"}
+	require.NoError(t, m.store.updateVirtualMailboxes())
+	_, ok := m.store.addresses[addrID1].mailboxes[labelID]
+	a.False(t, ok, "unknown label")
+
+	require.NoError(t, m.store.createOrUpdateMailboxEvent(&pmapi.Label{ID: "labelWork", Name: "Work", Path: "Work", Type: pmapi.LabelTypeMailbox}))
+	checkVirtualMailboxTotal(t, m, labelID, 1)
+	getVirtualMailboxUID(t, m, labelID, "msg2")
+
+	// Removed search deletes the mailbox and its query.
+	m.store.savedSearches = map[string]string{}
+	require.NoError(t, m.store.updateVirtualMailboxes())
+	_, ok = m.store.addresses[addrID1].mailboxes[labelID]
+	a.False(t, ok)
+	require.NoError(t, m.store.db.View(func(tx *bolt.Tx) error {
+		bucketName := getMailboxBucketName(addrID1, labelID)
+		a.Nil(t, tx.Bucket(mailboxesBucket).Bucket(bucketName))
+		a.Nil(t, tx.Bucket(virtualQueryBucket).Get(bucketName))
+		return nil
+	}))
+}
+
+func getVirtualMailboxUID(t *testing.T, m *mocksForStore, labelID, apiID string) uint32 {
+	uid, err := m.store.addresses[addrID1].mailboxes[labelID].getUID(apiID)
+	require.NoError(t, err)
+	return uid
+}
+
+func checkVirtualMailboxTotal(t *testing.T, m *mocksForStore, labelID string, wantTotal uint) {
+	total, _, _, err := m.store.addresses[addrID1].mailboxes[labelID].GetCounts()
+	require.NoError(t, err)
+	a.Equal(t, wantTotal, total, "total of %s", labelID)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// maintenanceInterval is how often the store checks whether the database
+	// should be compacted.
+	maintenanceInterval = time.Hour
+
+	// Database is compacted when at least minCompactFreeSize bytes and at
+	// least minCompactFreeRatio of the database can be reclaimed, so it is
+	// not rewritten for little gain.
+	minCompactFreeSize  = 4 * 1024 * 1024
+	minCompactFreeRatio = 0.2
+)
+
+// shouldCompact returns whether the database of `size` bytes with `free`
+// reclaimable bytes is worth compacting.
+func shouldCompact(size, free int64) bool {
+	return free >= minCompactFreeSize && float64(free) >= minCompactFreeRatio*float64(size)
+}
+
+// runMaintenance periodically compacts the database when the store is idle
+// until the store is closed.
+func (store *Store) runMaintenance() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-store.maintenanceStopCh:
+			return
+		case <-ticker.C:
+			store.maintain()
+		}
+	}
+}
+
+// maintain compacts the database if no sync is running and there is enough
+// space to be reclaimed.
+func (store *Store) maintain() {
+	store.lock.RLock()
+	isSyncRunning := store.isSyncRunning
+	store.lock.RUnlock()
+
+	if isSyncRunning {
+		store.log.Debug("Skipping store maintenance, sync is running")
+		return
+	}
+
+	free, err := store.db.FreeSize()
+	if err != nil {
+		store.log.WithError(err).Warn("Cannot get free size of store database")
+		return
+	}
+
+	if !shouldCompact(getDatabaseSize(store.filePath), free) {
+		return
+	}
+
+	if _, err := store.Compact(); err != nil {
+		store.log.WithError(err).Error("Cannot compact store database")
+	}
+}
+
+// Compact rewrites the database to reclaim space left after deleted data and
+// returns the number of reclaimed bytes.
+func (store *Store) Compact() (reclaimed int64, err error) {
+	before := getDatabaseSize(store.filePath)
+	start := time.Now()
+
+	if err = store.db.Compact(); err != nil {
+		return 0, err
+	}
+
+	reclaimed = before - getDatabaseSize(store.filePath)
+	store.log.
+		WithField("reclaimed", reclaimed).
+		WithField("duration", time.Since(start)).
+		Info("Store database compacted")
+
+	return reclaimed, nil
+}
+
+// getDatabaseSize returns the size of the database file, or zero if it cannot
+// be read.
+func getDatabaseSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -20,6 +20,7 @@ package store
 import (
 	"net/mail"
 
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	bolt "go.etcd.io/bbolt"
 )
@@ -112,10 +113,45 @@ func (message *Message) SetContentTypeAndHeader(mimeType string, header mail.Hea
 		}
 		stored.MIMEType = mimeType
 		stored.Header = header
-		return message.store.txPutMessage(
-			tx.Bucket(metadataBucket),
-			stored,
-		)
+		if err := message.store.txPutMessage(tx.Bucket(metadataBucket), stored); err != nil {
+			return err
+		}
+		return txPutThreadInfo(tx, stored)
+	}
+	return message.store.db.Update(txUpdate)
+}
+
+// SetBodyStructure stores serialized body structure of the decrypted message
+// so it doesn't need to be built again for every FETCH of BODYSTRUCTURE.
+// This should not trigger any IMAP update. Nothing is stored when the store
+// does not keep bodies, see SyncModeMetadataNoCache.
+func (message *Message) SetBodyStructure(bs *pkgMsg.BodyStructure) error {
+	if !message.store.KeepsBodies() {
+		return nil
+	}
+	raw, err := bs.Serialize()
+	if err != nil {
+		return err
+	}
+	txUpdate := func(tx *bolt.Tx) error {
+		return tx.Bucket(bodystructureBucket).Put([]byte(message.ID()), raw)
 	}
 	return message.store.db.Update(txUpdate)
 }
+
+// GetBodyStructure returns stored body structure of the message. If there is
+// no body structure in the database yet, it returns nil without error.
+func (message *Message) GetBodyStructure() (bs *pkgMsg.BodyStructure, err error) {
+	txRead := func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bodystructureBucket).Get([]byte(message.ID()))
+		if raw == nil {
+			return nil
+		}
+		bs, err = pkgMsg.DeserializeBodyStructure(raw)
+		return err
+	}
+	if err = message.store.db.View(txRead); err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrStoreSchemaTooNew is returned when the store database was migrated by
+// newer Bridge to schema this version does not know.
+var ErrStoreSchemaTooNew = errors.New("store database has schema of newer Bridge") //nolint[gochecknoglobals]
+
+var schemaVersionKey = []byte("version") //nolint[gochecknoglobals]
+
+// migration changes schema of the store database by one version. Up migrates
+// from the previous version, down reverts it, so the store can be used by
+// older Bridge again. Each runs in one transaction together with the change
+// of the version.
+type migration struct {
+	description string
+	up, down    func(tx *bolt.Tx) error
+}
+
+// migrations of the store schema; version N is reached by applying the first
+// N of them. Released migrations must never change, new ones are appended.
+var migrations = []migration{ //nolint[gochecknoglobals]
+	{
+		description: "build threading data",
+		up:          txBuildThreads,
+		down:        txDeleteThreads,
+	},
+}
+
+// CurrentSchemaVersion returns the version of the store schema used by this
+// version of Bridge.
+func CurrentSchemaVersion() int {
+	return len(migrations)
+}
+
+// GetStoreBackupPath returns the path of the copy of the store database on
+// the path made before its last migration.
+func GetStoreBackupPath(storePath string) string {
+	return storePath + ".bak"
+}
+
+// GetSchemaVersion returns the schema version of the store database on the
+// path. The store must not be opened by running Bridge.
+func GetSchemaVersion(path string) (version int, err error) {
+	db, err := openStorage(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	err = db.View(func(tx *bolt.Tx) error {
+		version, _ = txGetSchemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+// MigrateStore migrates the store database on the path up or down to the
+// schema version, e.g. before Bridge is downgraded. The database is backed
+// up first. The store must not be opened by running Bridge.
+func MigrateStore(path string, version int) error {
+	db, err := openStorage(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	return migrateStorage(db, path, version)
+}
+
+// migrateStorage migrates the opened database on the path to the schema
+// version. Database with data is copied to GetStoreBackupPath before the
+// first migration, so it can be restored when the migration goes wrong.
+func migrateStorage(db *storage, path string, target int) error {
+	if target < 0 || target > len(migrations) {
+		return fmt.Errorf("unknown store schema version %d", target)
+	}
+
+	var version int
+	var hasData bool
+	_ = db.View(func(tx *bolt.Tx) error {
+		version, hasData = txGetSchemaVersion(tx)
+		if b := tx.Bucket(metadataBucket); b != nil && !hasData {
+			k, _ := b.Cursor().First()
+			hasData = k != nil
+		}
+		return nil
+	})
+
+	if version > len(migrations) {
+		return errors.Wrapf(ErrStoreSchemaTooNew, "version %d, known %d", version, len(migrations))
+	}
+	if version == target {
+		return nil
+	}
+
+	l := log.WithField("path", path).WithField("from", version).WithField("to", target)
+
+	if hasData {
+		l.Info("Backing up store database before migration")
+		backupPath := GetStoreBackupPath(path)
+		if err := os.RemoveAll(backupPath); err != nil {
+			return err
+		}
+		if err := db.Snapshot(backupPath); err != nil {
+			return errors.Wrap(err, "failed to back up database")
+		}
+	}
+
+	for ; version < target; version++ {
+		m := migrations[version]
+		l.WithField("migration", m.description).Info("Migrating store database up")
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return txSetSchemaVersion(tx, version+1)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to migrate to version %d", version+1)
+		}
+	}
+
+	for ; version > target; version-- {
+		m := migrations[version-1]
+		l.WithField("migration", m.description).Info("Migrating store database down")
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return txSetSchemaVersion(tx, version-1)
+		}); err != nil {
+			return errors.Wrapf(err, "failed to migrate to version %d", version-1)
+		}
+	}
+
+	return nil
+}
+
+// txGetSchemaVersion returns the schema version and whether it is set. Stores
+// created before versioning have version zero.
+func txGetSchemaVersion(tx *bolt.Tx) (int, bool) {
+	b := tx.Bucket(schemaVersionBucket)
+	if b == nil {
+		return 0, false
+	}
+	v := b.Get(schemaVersionKey)
+	if v == nil {
+		return 0, false
+	}
+	return int(btoi(v)), true
+}
+
+func txSetSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists(schemaVersionBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(schemaVersionKey, itob(uint32(version)))
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestMigrationPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "migrations-test")
+	require.NoError(t, err)
+
+	return filepath.Join(dir, "mailbox-test.db"), func() { _ = os.RemoveAll(dir) }
+}
+
+// createUnversionedStore creates database with one message as written
+// before schema versions.
+func createUnversionedStore(t *testing.T, path string) {
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	defer db.Close() //nolint[errcheck]
+
+	msg, err := json.Marshal(&pmapi.Message{ID: "msg1", ConversationID: "conv1", Time: 100})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(metadataBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("msg1"), msg)
+	}))
+}
+
+func getTestConversation(t *testing.T, db *storage) (apiIDs []string) {
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(conversationsBucket); b != nil {
+			if b = b.Bucket([]byte("conv1")); b != nil {
+				return b.ForEach(func(k, _ []byte) error {
+					apiIDs = append(apiIDs, string(k))
+					return nil
+				})
+			}
+		}
+		return nil
+	}))
+	return
+}
+
+func TestMigrateNewStore(t *testing.T) {
+	path, cleanup := newTestMigrationPath(t)
+	defer cleanup()
+
+	db, err := openDatabase(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	version, err := GetSchemaVersion(path)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSchemaVersion(), version)
+
+	// Empty database is not backed up.
+	_, err = os.Stat(GetStoreBackupPath(path))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestMigrateUnversionedStore(t *testing.T) {
+	path, cleanup := newTestMigrationPath(t)
+	defer cleanup()
+
+	createUnversionedStore(t, path)
+
+	db, err := openDatabase(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg1"}, getTestConversation(t, db))
+	require.NoError(t, db.Close())
+
+	version, err := GetSchemaVersion(path)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSchemaVersion(), version)
+
+	// Backup keeps the database before migration.
+	version, err = GetSchemaVersion(GetStoreBackupPath(path))
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+}
+
+func TestMigrateStoreDownAndUp(t *testing.T) {
+	path, cleanup := newTestMigrationPath(t)
+	defer cleanup()
+
+	createUnversionedStore(t, path)
+	require.NoError(t, MigrateStore(path, CurrentSchemaVersion()))
+
+	require.NoError(t, MigrateStore(path, 0))
+	version, err := GetSchemaVersion(path)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	require.Empty(t, getTestConversation(t, db))
+	require.NoError(t, db.Close())
+
+	require.Error(t, MigrateStore(path, CurrentSchemaVersion()+1))
+
+	db, err = openDatabase(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg1"}, getTestConversation(t, db))
+	require.NoError(t, db.Close())
+}
+
+func TestMigrateStoreTooNew(t *testing.T) {
+	path, cleanup := newTestMigrationPath(t)
+	defer cleanup()
+
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return txSetSchemaVersion(tx, CurrentSchemaVersion()+1)
+	}))
+	require.NoError(t, db.Close())
+
+	_, err = openDatabase(path)
+	require.Equal(t, ErrStoreSchemaTooNew, errors.Cause(err))
+}
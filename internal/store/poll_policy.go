@@ -0,0 +1,162 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPollInterval is the default upper bound of the event poll interval.
+const maxPollInterval = 5 * time.Minute
+
+// pollActivityWindow is how long after the last activity events are still
+// polled with the minimal interval; the interval doubles every further window.
+const pollActivityWindow = 10 * time.Minute
+
+// PollPolicy sets bounds of the adaptive event poll interval.
+type PollPolicy struct {
+	// MinInterval is used while an IMAP client is connected (including
+	// IDLEing ones) or the user was active recently.
+	MinInterval time.Duration
+
+	// MaxInterval is reached after long inactivity.
+	MaxInterval time.Duration
+
+	// Metered polls always with MaxInterval to save data.
+	Metered bool
+}
+
+// pollActivity tracks connected clients and user activity to adapt the event
+// poll interval to them.
+type pollActivity struct {
+	policy PollPolicy
+
+	lock       sync.Mutex
+	clients    int
+	lastActive time.Time
+
+	// wakeCh notifies the event loop the interval might have shortened.
+	wakeCh chan struct{}
+}
+
+func newPollActivity(policy *PollPolicy) *pollActivity {
+	return &pollActivity{
+		policy:     normalizePollPolicy(policy),
+		lastActive: time.Now(),
+		wakeCh:     make(chan struct{}, 1),
+	}
+}
+
+// normalizePollPolicy returns the policy with defaults instead of missing
+// or invalid bounds.
+func normalizePollPolicy(policy *PollPolicy) PollPolicy {
+	p := PollPolicy{MinInterval: pollInterval, MaxInterval: maxPollInterval}
+	if policy != nil {
+		p = *policy
+	}
+	if p.MinInterval <= 0 {
+		p.MinInterval = pollInterval
+	}
+	if p.MaxInterval < p.MinInterval {
+		p.MaxInterval = p.MinInterval
+	}
+	return p
+}
+
+// interval returns how long to wait between polls at time `now`.
+func (a *pollActivity) interval(now time.Time) time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.policy.Metered {
+		return a.policy.MaxInterval
+	}
+	if a.clients > 0 {
+		return a.policy.MinInterval
+	}
+
+	interval := a.policy.MinInterval
+	for idle := now.Sub(a.lastActive); idle >= pollActivityWindow && interval < a.policy.MaxInterval; idle -= pollActivityWindow {
+		interval *= 2
+	}
+	if interval > a.policy.MaxInterval {
+		interval = a.policy.MaxInterval
+	}
+	return interval
+}
+
+func (a *pollActivity) markActive() {
+	a.lock.Lock()
+	a.lastActive = time.Now()
+	a.lock.Unlock()
+
+	a.wake()
+}
+
+// setPolicy replaces the policy and wakes the event loop so the new interval
+// is used right away.
+func (a *pollActivity) setPolicy(policy *PollPolicy) {
+	a.lock.Lock()
+	a.policy = normalizePollPolicy(policy)
+	a.lock.Unlock()
+
+	a.wake()
+}
+
+// wake notifies the event loop without blocking; one pending notification
+// is enough.
+func (a *pollActivity) wake() {
+	select {
+	case a.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (a *pollActivity) addClient(delta int) {
+	a.lock.Lock()
+	a.clients += delta
+	if a.clients < 0 {
+		a.clients = 0
+	}
+	a.lock.Unlock()
+
+	a.markActive()
+}
+
+// ClientConnected notes an IMAP client logged in so events are polled with
+// the minimal interval until it disconnects.
+func (store *Store) ClientConnected() {
+	store.pollActivity.addClient(1)
+}
+
+// ClientDisconnected notes an IMAP client logged out.
+func (store *Store) ClientDisconnected() {
+	store.pollActivity.addClient(-1)
+}
+
+// MarkActive notes user activity, such as sending a message, which shortens
+// the event poll interval to the minimum for a while.
+func (store *Store) MarkActive() {
+	store.pollActivity.markActive()
+}
+
+// SetPollPolicy changes bounds of the event poll interval of the running
+// store, e.g. when the configuration is reloaded.
+func (store *Store) SetPollPolicy(policy *PollPolicy) {
+	store.pollActivity.setPolicy(policy)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollActivityInterval(t *testing.T) {
+	a := newPollActivity(&PollPolicy{MinInterval: 30 * time.Second, MaxInterval: 5 * time.Minute})
+	now := a.lastActive
+
+	require.Equal(t, 30*time.Second, a.interval(now))
+	require.Equal(t, 30*time.Second, a.interval(now.Add(pollActivityWindow-time.Second)))
+	require.Equal(t, time.Minute, a.interval(now.Add(pollActivityWindow)))
+	require.Equal(t, 4*time.Minute, a.interval(now.Add(3*pollActivityWindow)))
+	require.Equal(t, 5*time.Minute, a.interval(now.Add(10*pollActivityWindow)))
+
+	// Connected client keeps the minimum however long the user is idle.
+	a.addClient(1)
+	require.Equal(t, 30*time.Second, a.interval(now.Add(10*pollActivityWindow)))
+	a.addClient(-1)
+	a.addClient(-1)
+	require.Equal(t, 0, a.clients)
+}
+
+func TestPollActivityMetered(t *testing.T) {
+	a := newPollActivity(&PollPolicy{MinInterval: 30 * time.Second, MaxInterval: 5 * time.Minute, Metered: true})
+	a.addClient(1)
+	require.Equal(t, 5*time.Minute, a.interval(time.Now()))
+}
+
+func TestPollActivityBounds(t *testing.T) {
+	a := newPollActivity(nil)
+	require.Equal(t, pollInterval, a.policy.MinInterval)
+	require.Equal(t, maxPollInterval, a.policy.MaxInterval)
+
+	a = newPollActivity(&PollPolicy{MinInterval: time.Minute})
+	require.Equal(t, time.Minute, a.policy.MaxInterval)
+}
+
+func TestPollActivityWakesLoop(t *testing.T) {
+	a := newPollActivity(nil)
+	a.markActive()
+	a.markActive()
+
+	require.Len(t, a.wakeCh, 1)
+}
+
+func TestPollActivitySetPolicy(t *testing.T) {
+	a := newPollActivity(&PollPolicy{MinInterval: 30 * time.Second, MaxInterval: 5 * time.Minute})
+	a.setPolicy(&PollPolicy{MinInterval: time.Minute, Metered: true})
+
+	require.Equal(t, time.Minute, a.interval(time.Now()))
+	require.Len(t, a.wakeCh, 1)
+}
+
+func TestStoreSetPaused(t *testing.T) {
+	store := &Store{
+		log:          log,
+		pollActivity: newPollActivity(nil),
+	}
+	loop := &eventLoop{store: store}
+
+	store.SetPaused(true)
+	require.True(t, store.IsPaused())
+	require.True(t, loop.isPaused())
+	require.Len(t, store.pollActivity.wakeCh, 0)
+
+	// Resume wakes the loop to catch up with changes missed during pause.
+	store.SetPaused(false)
+	require.False(t, store.IsPaused())
+	require.False(t, loop.isPaused())
+	require.Len(t, store.pollActivity.wakeCh, 1)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"strconv"
+	"time"
+
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	bolt "go.etcd.io/bbolt"
+)
+
+// requestReadReceipts emits ReadReceiptRequestEvent with "userID:messageID"
+// for each of newly read messages whose sender asked for a read receipt
+// (RFC8098) so that SMTP can send it if allowed by preferences. The header
+// is known only for already fetched messages which is the case when the
+// message is read by the client. Messages with already sent receipt are
+// skipped so marking as unread and read again does not send another one.
+func (store *Store) requestReadReceipts(apiIDs []string) {
+	if store.eventLoop == nil {
+		return
+	}
+
+	for _, apiID := range apiIDs {
+		message, err := store.getMessageFromDB(apiID)
+		if err != nil || message.Header == nil || message.Type != pmapi.MessageTypeInbox {
+			continue
+		}
+		if message.Header.Get("Disposition-Notification-To") == "" || store.IsReadReceiptSent(apiID) {
+			continue
+		}
+		store.eventLoop.events.Emit(bridgeEvents.ReadReceiptRequestEvent, store.UserID()+":"+apiID)
+	}
+}
+
+// IsReadReceiptSent returns whether the read receipt for message with `apiID`
+// was already sent.
+func (store *Store) IsReadReceiptSent(apiID string) bool {
+	sent := false
+	_ = store.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(readReceiptsBucket); b != nil {
+			sent = b.Get([]byte(apiID)) != nil
+		}
+		return nil
+	})
+	return sent
+}
+
+// SetReadReceiptSent remembers that the read receipt for message with `apiID`
+// was sent. The mark is removed together with the message.
+func (store *Store) SetReadReceiptSent(apiID string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(readReceiptsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(apiID), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	})
+}
+
+func txDeleteReadReceiptSent(tx *bolt.Tx, apiID string) error {
+	b := tx.Bucket(readReceiptsBucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(apiID))
+}
@@ -0,0 +1,698 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/jaytaylor/html2text"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	searchIndexBatchSize     = 1000
+	searchIndexPruneInterval = 24 * time.Hour
+	maxIndexedBodySize       = 256 * 1024
+)
+
+// ErrSearchIndexUnavailable is returned when the search index is turned off or
+// not loaded yet.
+var ErrSearchIndexUnavailable = errors.New("search index is not available") //nolint[gochecknoglobals]
+
+// SearchIndexPolicy configures the local full-text search index. The index is
+// built only when the policy is set in Options.
+type SearchIndexPolicy struct {
+	// MaxAge limits the index to messages not older than MaxAge; older
+	// messages are pruned. Zero means all messages are indexed.
+	MaxAge time.Duration
+}
+
+// searchDocument is the indexed content of one message.
+type searchDocument struct {
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	Attachments string `json:"attachments"`
+	Time        int64  `json:"time"`
+}
+
+// searchIndex keeps decrypted texts of messages in the database encrypted by
+// the index key and builds in-memory bleve index from them when loaded. The
+// bleve index itself is never written to disk.
+type searchIndex struct {
+	db     *storage
+	policy SearchIndexPolicy
+
+	lock  sync.RWMutex
+	aead  cipher.AEAD
+	index bleve.Index
+	times map[string]int64
+
+	pendingLock sync.Mutex
+	pending     map[string]bool
+	wake        chan struct{}
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+}
+
+func newSearchIndex(db *storage, policy SearchIndexPolicy) *searchIndex {
+	return &searchIndex{
+		db:      db,
+		policy:  policy,
+		times:   map[string]int64{},
+		pending: map[string]bool{},
+		wake:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// isExpired returns whether the message with the given time is out of the
+// policy and should not be indexed.
+func (si *searchIndex) isExpired(msgTime int64, now time.Time) bool {
+	return si.policy.MaxAge > 0 && time.Unix(msgTime, 0).Before(now.Add(-si.policy.MaxAge))
+}
+
+// load decrypts stored documents by the key and builds the bleve index.
+// Expired and undecryptable documents are removed.
+func (si *searchIndex) load(key []byte, now time.Time) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.StoreDynamic = false
+	indexMapping.DocValuesDynamic = false
+	index, err := bleve.NewMemOnly(indexMapping)
+	if err != nil {
+		return err
+	}
+
+	times := map[string]int64{}
+	var obsolete []string
+
+	batch := index.NewBatch()
+	err = si.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(searchIndexBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			apiID := string(k)
+			doc, err := openSearchDocument(aead, apiID, v)
+			if err != nil || si.isExpired(doc.Time, now) {
+				obsolete = append(obsolete, apiID)
+				return nil
+			}
+			times[apiID] = doc.Time
+			if err := batch.Index(apiID, doc); err != nil {
+				return err
+			}
+			if batch.Size() >= searchIndexBatchSize {
+				if err := index.Batch(batch); err != nil {
+					return err
+				}
+				batch.Reset()
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		err = index.Batch(batch)
+	}
+	if err == nil {
+		err = si.deleteDocuments(obsolete)
+	}
+	if err != nil {
+		_ = index.Close()
+		return err
+	}
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	select {
+	case <-si.stopCh:
+		return index.Close()
+	default:
+	}
+
+	si.aead = aead
+	si.index = index
+	si.times = times
+	return nil
+}
+
+// enqueue marks the message to be indexed. Already indexed message is indexed
+// again only when reindex is set (e.g. for drafts which can change).
+func (si *searchIndex) enqueue(apiID string, reindex bool) {
+	si.pendingLock.Lock()
+	si.pending[apiID] = si.pending[apiID] || reindex
+	si.pendingLock.Unlock()
+
+	select {
+	case si.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (si *searchIndex) takePending() map[string]bool {
+	si.pendingLock.Lock()
+	defer si.pendingLock.Unlock()
+
+	pending := si.pending
+	si.pending = map[string]bool{}
+	return pending
+}
+
+func (si *searchIndex) isIndexed(apiID string) bool {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+
+	_, ok := si.times[apiID]
+	return ok
+}
+
+// run indexes enqueued messages using fetch and prunes expired ones until
+// the index is stopped. Fetch returns nil document for messages which should
+// not be indexed.
+func (si *searchIndex) run(fetch func(apiID string) (*searchDocument, error)) {
+	ticker := time.NewTicker(searchIndexPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-si.stopCh:
+			return
+		case <-ticker.C:
+			if err := si.prune(time.Now()); err != nil {
+				log.WithError(err).Warn("Cannot prune search index")
+			}
+		case <-si.wake:
+			for apiID, reindex := range si.takePending() {
+				select {
+				case <-si.stopCh:
+					return
+				default:
+				}
+
+				if !reindex && si.isIndexed(apiID) {
+					continue
+				}
+
+				doc, err := fetch(apiID)
+				if err != nil {
+					log.WithError(err).WithField("msgID", apiID).Warn("Cannot index message")
+					continue
+				}
+				if doc == nil {
+					continue
+				}
+				if err := si.put(apiID, doc); err != nil {
+					log.WithError(err).WithField("msgID", apiID).Warn("Cannot store indexed message")
+				}
+			}
+		}
+	}
+}
+
+// put stores the document encrypted and adds it to the index.
+func (si *searchIndex) put(apiID string, doc *searchDocument) error {
+	if err := si.putDocument(apiID, doc); err != nil {
+		return err
+	}
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	if si.index == nil {
+		return ErrSearchIndexUnavailable
+	}
+
+	si.times[apiID] = doc.Time
+	return si.index.Index(apiID, doc)
+}
+
+// putDocument stores the document encrypted. The key cannot be rotated until
+// the document is written.
+func (si *searchIndex) putDocument(apiID string, doc *searchDocument) error {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+
+	if si.aead == nil {
+		return ErrSearchIndexUnavailable
+	}
+
+	data, err := sealSearchDocument(si.aead, apiID, doc)
+	if err != nil {
+		return err
+	}
+
+	return si.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(searchIndexBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(apiID), data)
+	})
+}
+
+// rotate re-encrypts stored documents from the previous key to the key.
+// Documents readable by neither are left for load to remove.
+func (si *searchIndex) rotate(key, prevKey []byte) error {
+	aead, err := newLocalKeyAEAD(key)
+	if err != nil {
+		return err
+	}
+	prevAEAD, err := newLocalKeyAEAD(prevKey)
+	if err != nil {
+		return err
+	}
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	err = si.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(searchIndexBucket)
+		if b == nil {
+			return nil
+		}
+
+		resealed := map[string][]byte{}
+		if err := b.ForEach(func(k, v []byte) error {
+			doc, err := openSearchDocument(prevAEAD, string(k), v)
+			if err != nil {
+				return nil
+			}
+			data, err := sealSearchDocument(aead, string(k), doc)
+			if err != nil {
+				return err
+			}
+			resealed[string(k)] = data
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for apiID, data := range resealed {
+			if err := b.Put([]byte(apiID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Not loaded index loads by the key later.
+	if si.aead != nil {
+		si.aead = aead
+	}
+	return nil
+}
+
+// remove deletes the messages from the index.
+func (si *searchIndex) remove(apiIDs []string) error {
+	si.pendingLock.Lock()
+	for _, apiID := range apiIDs {
+		delete(si.pending, apiID)
+	}
+	si.pendingLock.Unlock()
+
+	if err := si.deleteDocuments(apiIDs); err != nil {
+		return err
+	}
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	if si.index == nil {
+		return nil
+	}
+
+	batch := si.index.NewBatch()
+	for _, apiID := range apiIDs {
+		delete(si.times, apiID)
+		batch.Delete(apiID)
+	}
+	return si.index.Batch(batch)
+}
+
+// prune removes messages which are out of the policy now.
+func (si *searchIndex) prune(now time.Time) error {
+	var expired []string
+
+	si.lock.RLock()
+	for apiID, msgTime := range si.times {
+		if si.isExpired(msgTime, now) {
+			expired = append(expired, apiID)
+		}
+	}
+	si.lock.RUnlock()
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	log.WithField("count", len(expired)).Debug("Pruning search index")
+	return si.remove(expired)
+}
+
+func (si *searchIndex) deleteAllDocuments() error {
+	return si.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(searchIndexBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (si *searchIndex) deleteDocuments(apiIDs []string) error {
+	if len(apiIDs) == 0 {
+		return nil
+	}
+
+	return si.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(searchIndexBucket)
+		if b == nil {
+			return nil
+		}
+		for _, apiID := range apiIDs {
+			if err := b.Delete([]byte(apiID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// search returns IDs of messages matching the query ordered by relevance.
+// Zero size means all matching messages.
+func (si *searchIndex) search(q query.Query, size int) ([]string, error) {
+	si.lock.RLock()
+	defer si.lock.RUnlock()
+
+	if si.index == nil {
+		return nil, ErrSearchIndexUnavailable
+	}
+
+	if size <= 0 {
+		count, err := si.index.DocCount()
+		if err != nil {
+			return nil, err
+		}
+		size = int(count)
+	}
+
+	result, err := si.index.Search(bleve.NewSearchRequestOptions(q, size, 0, false))
+	if err != nil {
+		return nil, err
+	}
+
+	apiIDs := make([]string, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		apiIDs = append(apiIDs, hit.ID)
+	}
+	return apiIDs, nil
+}
+
+// stop stops indexing and releases the in-memory index.
+func (si *searchIndex) stop() {
+	si.stopOnce.Do(func() {
+		close(si.stopCh)
+	})
+
+	si.lock.Lock()
+	defer si.lock.Unlock()
+
+	if si.index != nil {
+		if err := si.index.Close(); err != nil {
+			log.WithError(err).Warn("Cannot close search index")
+		}
+		si.index = nil
+	}
+}
+
+// sealSearchDocument encrypts the document. The message ID is authenticated
+// so the document cannot be swapped with another one.
+func sealSearchDocument(aead cipher.AEAD, apiID string, doc *searchDocument) ([]byte, error) {
+	plaintext, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, []byte(apiID)), nil
+}
+
+func openSearchDocument(aead cipher.AEAD, apiID string, data []byte) (*searchDocument, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("search document is too short")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(apiID))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &searchDocument{}
+	if err := json.Unmarshal(plaintext, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// runSearchIndex loads the search index and keeps it up to date until the
+// store is closed.
+func (store *Store) runSearchIndex() {
+	key, isNew, err := store.getLocalKey(searchKeyBucket)
+	if err != nil {
+		store.log.WithError(err).Warn("Search index is not available")
+		return
+	}
+
+	// Documents encrypted by the previous key cannot be read anymore.
+	if isNew {
+		if err := store.searchIndex.deleteAllDocuments(); err != nil {
+			store.log.WithError(err).Warn("Cannot remove old search index")
+		}
+	}
+
+	if err := store.resumeSearchIndexKeyRotation(key); err != nil {
+		store.log.WithError(err).Warn("Cannot finish rotation of search index key")
+	}
+
+	if err := store.searchIndex.load(key, time.Now()); err != nil {
+		store.log.WithError(err).Warn("Cannot load search index")
+		return
+	}
+
+	if err := store.enqueueUnindexedMessages(); err != nil {
+		store.log.WithError(err).Warn("Cannot enqueue messages for search index")
+	}
+
+	store.searchIndex.run(store.getSearchDocument)
+}
+
+// enqueueUnindexedMessages enqueues all messages in the database not indexed
+// yet, e.g. after the index was turned on or indexing was interrupted.
+func (store *Store) enqueueUnindexedMessages() error {
+	var msgs []*pmapi.Message
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			msg := &pmapi.Message{}
+			if err := json.Unmarshal(v, msg); err != nil {
+				return err
+			}
+			msgs = append(msgs, msg)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	unindexed := msgs[:0]
+	for _, msg := range msgs {
+		if !store.searchIndex.isIndexed(msg.ID) {
+			unindexed = append(unindexed, msg)
+		}
+	}
+
+	store.enqueueForSearchIndex(unindexed)
+	return nil
+}
+
+// enqueueForSearchIndex enqueues the created or updated messages to be
+// indexed if the search index is enabled.
+func (store *Store) enqueueForSearchIndex(msgs []*pmapi.Message) {
+	if store.searchIndex == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, msg := range msgs {
+		if store.searchIndex.isExpired(msg.Time, now) {
+			continue
+		}
+		store.searchIndex.enqueue(msg.ID, msg.IsDraft())
+	}
+}
+
+// getSearchDocument downloads and decrypts the message and returns its
+// indexed content.
+func (store *Store) getSearchDocument(apiID string) (*searchDocument, error) {
+	msg, err := store.client().GetMessage(apiID)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.searchIndex.isExpired(msg.Time, time.Now()) {
+		return nil, nil
+	}
+
+	kr, err := store.client().KeyRingForAddressID(msg.AddressID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := msg.Decrypt(kr); err != nil {
+		return nil, err
+	}
+
+	return newSearchDocument(msg), nil
+}
+
+func newSearchDocument(msg *pmapi.Message) *searchDocument {
+	body := msg.Body
+	if msg.MIMEType == pmapi.ContentTypeHTML {
+		if text, err := html2text.FromString(body); err == nil {
+			body = text
+		}
+	}
+	if len(body) > maxIndexedBodySize {
+		body = strings.ToValidUTF8(body[:maxIndexedBodySize], "")
+	}
+
+	attachmentNames := make([]string, 0, len(msg.Attachments))
+	for _, att := range msg.Attachments {
+		attachmentNames = append(attachmentNames, att.Name)
+	}
+
+	return &searchDocument{
+		Subject:     msg.Subject,
+		Body:        body,
+		Attachments: strings.Join(attachmentNames, "\n"),
+		Time:        msg.Time,
+	}
+}
+
+// removeSearchIndex removes all data of the search index.
+func (store *Store) removeSearchIndex() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{searchKeyBucket, searchIndexBucket} {
+			if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SearchIndexed returns IDs of messages containing all bodyTexts in their
+// body and all texts anywhere in subject, body or attachment names. Matching
+// is done by words, not substrings. Nil is returned when there is nothing to
+// search for.
+func (store *Store) SearchIndexed(bodyTexts, texts []string) (map[string]bool, error) {
+	if store.searchIndex == nil {
+		return nil, ErrSearchIndexUnavailable
+	}
+
+	var queries []query.Query
+	for _, text := range bodyTexts {
+		if text == "" {
+			continue
+		}
+		q := bleve.NewMatchPhraseQuery(text)
+		q.SetField("body")
+		queries = append(queries, q)
+	}
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		queries = append(queries, bleve.NewMatchPhraseQuery(text))
+	}
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	apiIDs, err := store.searchIndex.search(bleve.NewConjunctionQuery(queries...), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(apiIDs))
+	for _, apiID := range apiIDs {
+		found[apiID] = true
+	}
+	return found, nil
+}
+
+// SearchMessages returns at most limit messages matching the query ordered by
+// relevance. The query uses bleve query string syntax, e.g. `invoice
+// subject:paid -attachments:pdf`.
+func (store *Store) SearchMessages(queryString string, limit int) ([]*pmapi.Message, error) {
+	if store.searchIndex == nil {
+		return nil, ErrSearchIndexUnavailable
+	}
+
+	apiIDs, err := store.searchIndex.search(bleve.NewQueryStringQuery(queryString), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*pmapi.Message, 0, len(apiIDs))
+	for _, apiID := range apiIDs {
+		msg, err := store.getMessageFromDB(apiID)
+		if err != nil {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/blevesearch/bleve"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestSearchIndex(t *testing.T, policy SearchIndexPolicy) (*searchIndex, *storage, func()) {
+	dir, err := ioutil.TempDir("", "search-index-test")
+	require.NoError(t, err)
+
+	db, err := openDatabase(filepath.Join(dir, "mailbox-test.db"))
+	require.NoError(t, err)
+
+	return newSearchIndex(db, policy), db, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func searchIDs(t *testing.T, si *searchIndex, text string) []string {
+	apiIDs, err := si.search(bleve.NewMatchPhraseQuery(text), 0)
+	require.NoError(t, err)
+	return apiIDs
+}
+
+func TestSearchIndexPutSearchRemove(t *testing.T) {
+	si, _, cleanup := newTestSearchIndex(t, SearchIndexPolicy{})
+	defer cleanup()
+	defer si.stop()
+
+	key := bytes.Repeat([]byte{1}, localKeySize)
+	require.NoError(t, si.load(key, time.Now()))
+
+	require.NoError(t, si.put("msg1", &searchDocument{Subject: "Invoice", Body: "Please pay the invoice", Time: 1}))
+	require.NoError(t, si.put("msg2", &searchDocument{Subject: "Hello", Attachments: "Invoice March.pdf", Time: 2}))
+
+	require.ElementsMatch(t, []string{"msg1", "msg2"}, searchIDs(t, si, "invoice"))
+	require.Equal(t, []string{"msg1"}, searchIDs(t, si, "pay the invoice"))
+	require.True(t, si.isIndexed("msg1"))
+
+	require.NoError(t, si.remove([]string{"msg1"}))
+	require.Equal(t, []string{"msg2"}, searchIDs(t, si, "invoice"))
+	require.False(t, si.isIndexed("msg1"))
+}
+
+func TestSearchIndexIsEncryptedAndReloaded(t *testing.T) {
+	si, db, cleanup := newTestSearchIndex(t, SearchIndexPolicy{})
+	defer cleanup()
+
+	key := bytes.Repeat([]byte{1}, localKeySize)
+	require.NoError(t, si.load(key, time.Now()))
+	require.NoError(t, si.put("msg1", &searchDocument{Subject: "Confidential", Body: "secret plan", Time: 1}))
+	si.stop()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(searchIndexBucket).Get([]byte("msg1"))
+		require.NotNil(t, data)
+		require.NotContains(t, string(data), "secret")
+		return nil
+	}))
+
+	// Wrong key makes documents unreadable; they are dropped.
+	si = newSearchIndex(db, SearchIndexPolicy{})
+	require.NoError(t, si.load(bytes.Repeat([]byte{2}, localKeySize), time.Now()))
+	require.Empty(t, searchIDs(t, si, "secret"))
+	si.stop()
+
+	si = newSearchIndex(db, SearchIndexPolicy{})
+	require.NoError(t, si.load(key, time.Now()))
+	require.Empty(t, searchIDs(t, si, "secret"))
+	si.stop()
+}
+
+func TestSearchIndexRotate(t *testing.T) {
+	si, db, cleanup := newTestSearchIndex(t, SearchIndexPolicy{})
+	defer cleanup()
+
+	oldKey, newKey := bytes.Repeat([]byte{1}, localKeySize), bytes.Repeat([]byte{2}, localKeySize)
+	require.NoError(t, si.load(oldKey, time.Now()))
+	require.NoError(t, si.put("msg1", &searchDocument{Subject: "Invoice", Time: 1}))
+
+	require.NoError(t, si.rotate(newKey, oldKey))
+	require.NoError(t, si.put("msg2", &searchDocument{Subject: "Invoice", Time: 2}))
+	si.stop()
+
+	// All documents are readable by the new key only.
+	si = newSearchIndex(db, SearchIndexPolicy{})
+	require.NoError(t, si.load(newKey, time.Now()))
+	require.ElementsMatch(t, []string{"msg1", "msg2"}, searchIDs(t, si, "invoice"))
+	si.stop()
+
+	si = newSearchIndex(db, SearchIndexPolicy{})
+	require.NoError(t, si.load(oldKey, time.Now()))
+	require.Empty(t, searchIDs(t, si, "invoice"))
+	si.stop()
+}
+
+func TestSearchIndexPrune(t *testing.T) {
+	now := time.Now()
+	si, _, cleanup := newTestSearchIndex(t, SearchIndexPolicy{MaxAge: 24 * time.Hour})
+	defer cleanup()
+	defer si.stop()
+
+	require.True(t, si.isExpired(now.Add(-48*time.Hour).Unix(), now))
+	require.False(t, si.isExpired(now.Add(-time.Hour).Unix(), now))
+
+	require.NoError(t, si.load(bytes.Repeat([]byte{1}, localKeySize), now))
+	require.NoError(t, si.put("old", &searchDocument{Subject: "report", Time: now.Add(-2 * time.Hour).Unix()}))
+	require.NoError(t, si.put("new", &searchDocument{Subject: "report", Time: now.Unix()}))
+
+	require.NoError(t, si.prune(now.Add(23*time.Hour)))
+	require.Equal(t, []string{"new"}, searchIDs(t, si, "report"))
+}
+
+func TestSearchIndexRun(t *testing.T) {
+	si, _, cleanup := newTestSearchIndex(t, SearchIndexPolicy{})
+	defer cleanup()
+
+	require.NoError(t, si.load(bytes.Repeat([]byte{1}, localKeySize), time.Now()))
+
+	fetched := make(chan string, 10)
+	go si.run(func(apiID string) (*searchDocument, error) {
+		fetched <- apiID
+		return &searchDocument{Subject: "fetched " + apiID}, nil
+	})
+
+	si.enqueue("msg1", false)
+	require.Equal(t, "msg1", <-fetched)
+	require.Eventually(t, func() bool { return si.isIndexed("msg1") }, time.Second, 10*time.Millisecond)
+
+	// Indexed message is fetched again only when reindex is requested.
+	si.enqueue("msg1", false)
+	si.enqueue("msg1", true)
+	require.Equal(t, "msg1", <-fetched)
+
+	si.stop()
+	require.Empty(t, fetched)
+}
+
+func TestNewSearchDocument(t *testing.T) {
+	doc := newSearchDocument(&pmapi.Message{
+		Subject:  "Subject",
+		MIMEType: pmapi.ContentTypeHTML,
+		Body:     "<html><body><p>Hello <b>world</b></p></body></html>",
+		Time:     42,
+		Attachments: []*pmapi.Attachment{
+			{Name: "a.pdf"},
+			{Name: "b.txt"},
+		},
+	})
+
+	require.Equal(t, "Subject", doc.Subject)
+	require.Equal(t, "Hello *world*", doc.Body)
+	require.Equal(t, "a.pdf\nb.txt", doc.Attachments)
+	require.Equal(t, int64(42), doc.Time)
+}
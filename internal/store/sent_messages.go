@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sentMessageExpiration is how long sent messages are remembered. Clients
+// append the copy to Sent right after the message is sent.
+const sentMessageExpiration = 30 * time.Minute
+
+// sentMessages remembers messages recently sent through SMTP so the copy
+// which client APPENDs to Sent can be matched with the one created by API,
+// even before the event loop brings it to the local database.
+type sentMessages struct {
+	lock     sync.Mutex
+	messages map[string]sentMessage
+}
+
+type sentMessage struct {
+	apiID string
+	time  time.Time
+}
+
+func newSentMessages() *sentMessages {
+	return &sentMessages{messages: map[string]sentMessage{}}
+}
+
+// getSentMessageKeys returns keys identifying the message: its Message-Id,
+// and hash of headers which client keeps the same in both copies in case
+// there is no Message-Id.
+func getSentMessageKeys(header mail.Header) []string {
+	keys := []string{}
+
+	if messageID := strings.Trim(header.Get("Message-Id"), "<> "); messageID != "" {
+		keys = append(keys, "id:"+messageID)
+	}
+
+	h := sha256.New()
+	for _, key := range []string{"Date", "Subject", "From", "To", "Cc"} {
+		_, _ = h.Write([]byte(key + ":" + header.Get(key) + "\n"))
+	}
+	keys = append(keys, fmt.Sprintf("hash:%x", h.Sum(nil)))
+
+	return keys
+}
+
+func (s *sentMessages) add(apiID string, header mail.Header) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deleteExpired()
+	for _, key := range getSentMessageKeys(header) {
+		s.messages[key] = sentMessage{apiID: apiID, time: time.Now()}
+	}
+}
+
+func (s *sentMessages) find(header mail.Header) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deleteExpired()
+	keys := getSentMessageKeys(header)
+
+	// Hash is used only when message has no Message-Id. Otherwise two
+	// different messages with the same headers would be taken as one.
+	if len(keys) > 1 {
+		keys = keys[:1]
+	}
+
+	for _, key := range keys {
+		if sent, ok := s.messages[key]; ok {
+			return sent.apiID, true
+		}
+	}
+	return "", false
+}
+
+func (s *sentMessages) deleteExpired() {
+	for key, sent := range s.messages {
+		if time.Since(sent.time) > sentMessageExpiration {
+			delete(s.messages, key)
+		}
+	}
+}
+
+// RecordSentMessage remembers the message with `apiID` sent through SMTP
+// with given header.
+func (store *Store) RecordSentMessage(apiID string, header mail.Header) {
+	store.sentMessages.add(apiID, header)
+}
+
+// FindSentMessage returns API ID of message recently sent through SMTP
+// matching the header of message which is being appended to Sent.
+func (store *Store) FindSentMessage(header mail.Header) (apiID string, ok bool) {
+	return store.sentMessages.find(header)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSentMessagesByMessageID(t *testing.T) {
+	s := newSentMessages()
+	s.add("apiID", mail.Header{"Message-Id": {"<abc@example.com>"}, "Subject": {"Hello"}})
+
+	apiID, ok := s.find(mail.Header{"Message-Id": {"abc@example.com"}, "Subject": {"Hello"}})
+	require.True(t, ok)
+	require.Equal(t, "apiID", apiID)
+
+	// Different Message-Id means different message even with the same headers.
+	_, ok = s.find(mail.Header{"Message-Id": {"<other@example.com>"}, "Subject": {"Hello"}})
+	require.False(t, ok)
+}
+
+func TestSentMessagesByHash(t *testing.T) {
+	header := mail.Header{
+		"Date":    {"Mon, 02 Jan 2006 15:04:05 +0000"},
+		"Subject": {"Hello"},
+		"From":    {"from@pm.me"},
+		"To":      {"to@example.com"},
+	}
+
+	s := newSentMessages()
+	s.add("apiID", header)
+
+	apiID, ok := s.find(header)
+	require.True(t, ok)
+	require.Equal(t, "apiID", apiID)
+
+	_, ok = s.find(mail.Header{"Subject": {"Hello"}})
+	require.False(t, ok)
+}
+
+func TestSentMessagesExpire(t *testing.T) {
+	header := mail.Header{"Message-Id": {"<abc@example.com>"}}
+
+	s := newSentMessages()
+	s.add("apiID", header)
+	for key, sent := range s.messages {
+		sent.time = time.Now().Add(-sentMessageExpiration - time.Minute)
+		s.messages[key] = sent
+	}
+
+	_, ok := s.find(header)
+	require.False(t, ok)
+	require.Empty(t, s.messages)
+}
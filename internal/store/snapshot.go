@@ -0,0 +1,409 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// mailboxSnapshotVersion is increased with every incompatible change of
+// MailboxSnapshot.
+const mailboxSnapshotVersion = 1
+
+// MailboxSnapshot is the state of mailboxes of a store without any content of
+// messages: addresses, labels with counts on API, metadata of messages and
+// UIDs of messages in every mailbox. Snapshots serve as fixtures of tests and
+// sanitized snapshots of users help to reproduce their sync problems.
+type MailboxSnapshot struct {
+	Version     int
+	AddressMode string
+	Addresses   []AddressInfo
+	Labels      []*SnapshotLabel
+	Messages    []*pmapi.Message
+	Mailboxes   []*SnapshotMailbox
+}
+
+// SnapshotLabel is the label with its counts on API.
+type SnapshotLabel mailboxCounts
+
+// SnapshotMailbox is the content of one IMAP mailbox.
+type SnapshotMailbox struct {
+	AddressID string
+	LabelID   string
+	UIDNext   uint32
+	UIDs      []*SnapshotUID
+}
+
+// SnapshotUID is the message with its UID in the mailbox.
+type SnapshotUID struct {
+	UID       uint32
+	MessageID string
+	Deleted   bool `json:",omitempty"`
+}
+
+// SnapshotMailboxes takes the snapshot of mailboxes of the store database on
+// the path. With sanitize, subjects, addresses, names of custom labels and
+// other personal data are replaced; IDs, labels, flags, times and sizes which
+// drive the sync are kept. The store must not be opened by running Bridge.
+func SnapshotMailboxes(path string, sanitize bool) (snapshot *MailboxSnapshot, err error) {
+	db, err := openStorage(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	err = db.View(func(tx *bolt.Tx) error {
+		snapshot, err = txSnapshotMailboxes(tx, sanitize)
+		return err
+	})
+	return
+}
+
+// LoadMailboxSnapshot replaces mailboxes of the store database on the path by
+// the snapshot and marks the store as synced. Addresses of the snapshot are
+// mapped to addresses of the store by their order, the primary one to the
+// primary one, so snapshots can be loaded into stores of other accounts.
+// Everything derived from previous messages (bodies and attachments cached on
+// disk, search index, threads, pending operations) is dropped. The store must
+// not be opened by running Bridge.
+func LoadMailboxSnapshot(path string, snapshot *MailboxSnapshot) error {
+	if snapshot.Version != mailboxSnapshotVersion {
+		return errors.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	db, err := openStorage(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return txLoadMailboxSnapshot(tx, snapshot)
+	}); err != nil {
+		return err
+	}
+
+	// Keys of caches were removed, so content on disk cannot be read anyway.
+	for _, dir := range []string{GetBodyCacheDir(path), GetAttachmentCacheDir(path)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.Wrap(err, "failed to remove cache")
+		}
+	}
+	return nil
+}
+
+func txSnapshotMailboxes(tx *bolt.Tx, sanitize bool) (*MailboxSnapshot, error) {
+	for _, name := range [][]byte{metadataBucket, mailboxesBucket, addressInfoBucket} {
+		if tx.Bucket(name) == nil {
+			return nil, errors.Errorf("missing bucket %s, not a store database", name)
+		}
+	}
+
+	snapshot := &MailboxSnapshot{Version: mailboxSnapshotVersion}
+
+	addresses, err := txGetAllAddresses(tx)
+	if err != nil {
+		return nil, err
+	}
+	if b := tx.Bucket(addressModeBucket); b != nil {
+		snapshot.AddressMode = string(b.Get([]byte(modeKey)))
+	}
+	for i, address := range addresses {
+		if sanitize {
+			address.Address = fmt.Sprintf("address%d@example.com", i+1)
+		}
+		snapshot.Addresses = append(snapshot.Addresses, address)
+	}
+
+	counts, err := txGetAllCounts(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, mc := range counts {
+		if sanitize && !pmapi.IsSystemLabel(mc.LabelID) {
+			if mc.IsFolder {
+				mc.LabelName = fmt.Sprintf("Folder %d", i+1)
+			} else {
+				mc.LabelName = fmt.Sprintf("Label %d", i+1)
+			}
+		}
+		snapshot.Labels = append(snapshot.Labels, (*SnapshotLabel)(mc))
+	}
+
+	err = tx.Bucket(metadataBucket).ForEach(func(apiID, data []byte) error {
+		msg := &pmapi.Message{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			return errors.Wrapf(err, "cannot parse metadata of %s", apiID)
+		}
+		if sanitize {
+			sanitizeMessage(msg)
+		}
+		snapshot.Messages = append(snapshot.Messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mailboxes := tx.Bucket(mailboxesBucket)
+	err = mailboxes.ForEach(func(name, _ []byte) error {
+		mailbox := getSnapshotMailbox(addresses, string(name))
+		if mailbox == nil {
+			return errors.Errorf("mailbox %s does not belong to any address", name)
+		}
+
+		imapIDs := mailboxes.Bucket(name).Bucket(imapIDsBucket)
+		deletedIDs := mailboxes.Bucket(name).Bucket(deletedIDsBucket)
+		if imapIDs == nil {
+			return errors.Errorf("mailbox %s has no UIDs", name)
+		}
+
+		mailbox.UIDNext = uint32(imapIDs.Sequence() + 1)
+		if err := imapIDs.ForEach(func(uid, apiID []byte) error {
+			mailbox.UIDs = append(mailbox.UIDs, &SnapshotUID{
+				UID:       btoi(uid),
+				MessageID: string(apiID),
+				Deleted:   deletedIDs != nil && deletedIDs.Get(apiID) != nil,
+			})
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		snapshot.Mailboxes = append(snapshot.Mailboxes, mailbox)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// getSnapshotMailbox splits the name of mailbox bucket to address and label.
+// Both IDs can contain a dash, so the address is found by known addresses.
+func getSnapshotMailbox(addresses []AddressInfo, name string) *SnapshotMailbox {
+	for _, address := range addresses {
+		if strings.HasPrefix(name, address.AddressID+"-") {
+			return &SnapshotMailbox{
+				AddressID: address.AddressID,
+				LabelID:   strings.TrimPrefix(name, address.AddressID+"-"),
+			}
+		}
+	}
+	return nil
+}
+
+// sanitizeMessage replaces personal data in the metadata of the message.
+// External IDs are hashed to keep which messages share the same one.
+func sanitizeMessage(msg *pmapi.Message) {
+	msg.Subject = "Message " + msg.ID
+	msg.Sender = &mail.Address{Address: "sender@example.com"}
+	msg.ReplyTo = nil
+	msg.ReplyTos = nil
+	msg.ToList = []*mail.Address{{Address: "recipient@example.com"}}
+	msg.CCList = []*mail.Address{}
+	msg.BCCList = []*mail.Address{}
+	msg.Header = mail.Header{}
+	if msg.ExternalID != "" {
+		msg.ExternalID = fmt.Sprintf("%x@example.com", sha256.Sum256([]byte(msg.ExternalID)))
+	}
+	for _, att := range msg.Attachments {
+		att.Name = "attachment"
+		att.KeyPackets = ""
+		att.Signature = ""
+	}
+}
+
+func txLoadMailboxSnapshot(tx *bolt.Tx, snapshot *MailboxSnapshot) error {
+	addresses, err := txGetAllAddresses(tx)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		addresses = snapshot.Addresses
+		if err := txPutAddresses(tx, addresses); err != nil {
+			return err
+		}
+	}
+
+	addressIDs := map[string]string{}
+	for i, address := range snapshot.Addresses {
+		if i < len(addresses) {
+			addressIDs[address.AddressID] = addresses[i].AddressID
+		}
+	}
+	for _, mailbox := range snapshot.Mailboxes {
+		if _, ok := addressIDs[mailbox.AddressID]; !ok {
+			return errors.Errorf("store has no address for mailboxes of %s", mailbox.AddressID)
+		}
+	}
+
+	// Optional buckets are only removed, the store creates them if needed.
+	for _, name := range [][]byte{
+		searchIndexBucket, journalBucket, readReceiptsBucket, subscriptionsBucket,
+		bodyCacheKeyBucket, attCacheKeyBucket,
+	} {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	for _, name := range [][]byte{metadataBucket, bodystructureBucket, countsBucket, mailboxesBucket} {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	if snapshot.AddressMode != "" {
+		b, err := tx.CreateBucketIfNotExists(addressModeBucket)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(modeKey), []byte(snapshot.AddressMode)); err != nil {
+			return err
+		}
+	}
+
+	counts := tx.Bucket(countsBucket)
+	for _, label := range snapshot.Labels {
+		if err := (*mailboxCounts)(label).txWriteToBucket(counts); err != nil {
+			return err
+		}
+	}
+
+	metadata := tx.Bucket(metadataBucket)
+	for _, msg := range snapshot.Messages {
+		if addressID, ok := addressIDs[msg.AddressID]; ok {
+			msg.AddressID = addressID
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return errors.Wrap(err, "cannot marshal metadata")
+		}
+		if err := metadata.Put([]byte(msg.ID), data); err != nil {
+			return err
+		}
+	}
+
+	if err := txBuildThreads(tx); err != nil {
+		return errors.Wrap(err, "cannot build threads")
+	}
+
+	for _, mailbox := range snapshot.Mailboxes {
+		if err := txLoadSnapshotMailbox(tx, addressIDs[mailbox.AddressID], mailbox); err != nil {
+			return errors.Wrapf(err, "cannot load mailbox %s", mailbox.LabelID)
+		}
+	}
+
+	return txMarkSynced(tx)
+}
+
+func txLoadSnapshotMailbox(tx *bolt.Tx, addressID string, mailbox *SnapshotMailbox) error {
+	b, err := tx.Bucket(mailboxesBucket).CreateBucketIfNotExists(getMailboxBucketName(addressID, mailbox.LabelID))
+	if err != nil {
+		return err
+	}
+
+	var imapIDs, apiIDs, deletedIDs *bolt.Bucket
+	for _, nested := range []struct {
+		b    **bolt.Bucket
+		name []byte
+	}{{&imapIDs, imapIDsBucket}, {&apiIDs, apiIDsBucket}, {&deletedIDs, deletedIDsBucket}} {
+		if *nested.b, err = b.CreateBucketIfNotExists(nested.name); err != nil {
+			return err
+		}
+	}
+
+	uidNext := uint64(mailbox.UIDNext)
+	for _, uid := range mailbox.UIDs {
+		if err := imapIDs.Put(itob(uid.UID), []byte(uid.MessageID)); err != nil {
+			return err
+		}
+		if err := apiIDs.Put([]byte(uid.MessageID), itob(uid.UID)); err != nil {
+			return err
+		}
+		if uid.Deleted {
+			if err := deletedIDs.Put([]byte(uid.MessageID), []byte{1}); err != nil {
+				return err
+			}
+		}
+		if uint64(uid.UID) >= uidNext {
+			uidNext = uint64(uid.UID) + 1
+		}
+	}
+
+	// UIDNEXT is one above the sequence.
+	if uidNext == 0 {
+		return nil
+	}
+	return imapIDs.SetSequence(uidNext - 1)
+}
+
+func txGetAllAddresses(tx *bolt.Tx) (addresses []AddressInfo, err error) {
+	err = tx.Bucket(addressInfoBucket).ForEach(func(k, v []byte) error {
+		var info AddressInfo
+		if err := json.Unmarshal(v, &info); err != nil {
+			return errors.Wrap(err, "cannot parse address info")
+		}
+		addresses = append(addresses, info)
+		return nil
+	})
+	return
+}
+
+func txPutAddresses(tx *bolt.Tx, addresses []AddressInfo) error {
+	b := tx.Bucket(addressInfoBucket)
+	for index, address := range addresses {
+		info, err := json.Marshal(address)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(itob(uint32(index)), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txMarkSynced sets the sync state as finished now so the loaded state is
+// not replaced by a sync when the store is opened.
+func txMarkSynced(tx *bolt.Tx) error {
+	b, err := tx.CreateBucketIfNotExists(syncStateBucket)
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{syncIDRangesKey, syncIDsToBeDeletedKey, syncEventIDKey} {
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return b.Put([]byte(syncFinishTimeKey), []byte(fmt.Sprintf("%v", time.Now().UnixNano())))
+}
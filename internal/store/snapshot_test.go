@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestMailboxSnapshotSanitize(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	snapshot, err := SnapshotMailboxes(path, true)
+	require.NoError(t, err)
+
+	require.Len(t, snapshot.Messages, 2)
+	for _, msg := range snapshot.Messages {
+		require.Equal(t, "Message "+msg.ID, msg.Subject)
+		require.Equal(t, "sender@example.com", msg.Sender.Address)
+	}
+	for _, address := range snapshot.Addresses {
+		require.Contains(t, address.Address, "@example.com")
+	}
+}
+
+func TestMailboxSnapshotLoad(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	snapshot, err := SnapshotMailboxes(path, false)
+	require.NoError(t, err)
+
+	// Snapshot of another account with the message deleted in INBOX.
+	for i := range snapshot.Addresses {
+		snapshot.Addresses[i].AddressID = "other" + snapshot.Addresses[i].AddressID
+	}
+	for _, msg := range snapshot.Messages {
+		msg.AddressID = "other" + addrID1
+	}
+	for _, mailbox := range snapshot.Mailboxes {
+		mailbox.AddressID = "other" + mailbox.AddressID
+		if mailbox.LabelID == pmapi.InboxLabel {
+			mailbox.UIDs[0].Deleted = true
+			mailbox.UIDNext = 5
+		}
+	}
+
+	// Data of messages which are not in the snapshot.
+	db, err := openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, txPutThreadInfo(tx, &pmapi.Message{ID: "stale", ConversationID: "staleConv", ExternalID: "stale@example.com"}))
+		b, err := tx.CreateBucketIfNotExists(readReceiptsBucket)
+		require.NoError(t, err)
+		return b.Put([]byte("stale"), []byte("1"))
+	}))
+	require.NoError(t, db.Close())
+	require.NoError(t, os.MkdirAll(GetBodyCacheDir(path), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(GetBodyCacheDir(path), "stale"), []byte("body"), 0600))
+
+	require.NoError(t, LoadMailboxSnapshot(path, snapshot))
+
+	_, err = os.Stat(GetBodyCacheDir(path))
+	require.True(t, os.IsNotExist(err))
+	db, err = openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket(readReceiptsBucket))
+		if convs := tx.Bucket(conversationsBucket); convs != nil {
+			require.Nil(t, convs.Bucket([]byte("staleConv")))
+		}
+		_, err := txGetThreadInfo(tx, "stale")
+		require.Equal(t, ErrNoSuchAPIID, err)
+		for _, msg := range snapshot.Messages {
+			_, err := txGetThreadInfo(tx, msg.ID)
+			require.NoError(t, err)
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	result, err := CheckStore(path, false)
+	require.NoError(t, err)
+	require.Empty(t, result.Problems)
+
+	loaded, err := SnapshotMailboxes(path, false)
+	require.NoError(t, err)
+	require.Len(t, loaded.Messages, 2)
+	require.Equal(t, addrID1, loaded.Messages[0].AddressID)
+	for _, mailbox := range loaded.Mailboxes {
+		require.Equal(t, addrID1, mailbox.AddressID)
+		if mailbox.LabelID == pmapi.InboxLabel {
+			require.Equal(t, uint32(5), mailbox.UIDNext)
+			require.True(t, mailbox.UIDs[0].Deleted)
+		}
+	}
+}
+
+func TestMailboxSnapshotVersion(t *testing.T) {
+	path, clear := initCheckMocks(t)
+	defer clear()
+
+	require.Error(t, LoadMailboxSnapshot(path, &MailboxSnapshot{Version: mailboxSnapshotVersion + 1}))
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/allan-simon/go-singleinstance"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrStorageLocked is returned when the database is opened by another process,
+// for example by another running instance.
+var ErrStorageLocked = errors.New("store database is used by another process") //nolint[gochecknoglobals]
+
+// boltCompactTxSize is the size of data after which the compaction commits
+// the transaction, so the whole database does not have to be in memory.
+const boltCompactTxSize = 64 * 1024 * 1024
+
+// storage is the BoltDB database of the store. The database is wrapped only
+// because compaction replaces it by a new file while the store is running.
+type storage struct {
+	path string
+	db   *bolt.DB
+	lock *os.File
+
+	// The database is replaced during compaction. New transactions wait for
+	// it to finish and compaction waits for running transactions, but it
+	// does not block new transactions while waiting, so nested transactions
+	// cannot deadlock.
+	gate       *sync.Cond
+	active     int
+	compacting bool
+}
+
+// GetStorageLockPath returns the path of the file which locks the database on
+// the path while it is opened.
+func GetStorageLockPath(path string) string {
+	return path + ".lock"
+}
+
+// openStorage locks and opens the database on the path. ErrStorageLocked is
+// returned when the database is already opened.
+func openStorage(path string) (*storage, error) {
+	lock, err := singleinstance.CreateLockFile(GetStorageLockPath(path))
+	if err != nil {
+		log.WithError(err).WithField("path", path).Warn("Cannot lock store database")
+		return nil, ErrStorageLocked
+	}
+
+	db, err := openBoltDB(path)
+	if err != nil {
+		_ = lock.Close()
+		return nil, err
+	}
+
+	return &storage{path: path, db: db, lock: lock, gate: sync.NewCond(&sync.Mutex{})}, nil
+}
+
+// SnapshotStorage writes a consistent copy of the store database on the path
+// to dstPath. The database must not be opened by the running store.
+func SnapshotStorage(path, dstPath string) error {
+	db, err := openStorage(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer db.Close() //nolint[errcheck]
+
+	return db.Snapshot(dstPath)
+}
+
+func openBoltDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if val, set := os.LookupEnv("BRIDGESTRICTMODE"); set && val == "1" {
+		db.StrictMode = true
+	}
+
+	return db, nil
+}
+
+func (s *storage) begin() *bolt.DB {
+	s.gate.L.Lock()
+	defer s.gate.L.Unlock()
+
+	for s.compacting {
+		s.gate.Wait()
+	}
+	s.active++
+	return s.db
+}
+
+func (s *storage) end() {
+	s.gate.L.Lock()
+	defer s.gate.L.Unlock()
+
+	s.active--
+	s.gate.Broadcast()
+}
+
+func (s *storage) Update(fn func(tx *bolt.Tx) error) error {
+	db := s.begin()
+	defer s.end()
+
+	return db.Update(fn)
+}
+
+func (s *storage) View(fn func(tx *bolt.Tx) error) error {
+	db := s.begin()
+	defer s.end()
+
+	return db.View(fn)
+}
+
+func (s *storage) Close() error {
+	db := s.begin()
+	defer s.end()
+
+	err := db.Close()
+	if lockErr := s.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// FreeSize returns the size of free pages which compaction would reclaim.
+func (s *storage) FreeSize() (int64, error) {
+	db := s.begin()
+	defer s.end()
+
+	stats := db.Stats()
+	return int64(stats.FreePageN+stats.PendingPageN) * int64(db.Info().PageSize), nil
+}
+
+// Compact copies all buckets into a new file which then replaces the
+// database. BoltDB never shrinks the file, so it is the only way to reclaim
+// space after deleting data.
+func (s *storage) Compact() error {
+	s.gate.L.Lock()
+	for s.active > 0 || s.compacting {
+		s.gate.Wait()
+	}
+	s.compacting = true
+	s.gate.L.Unlock()
+
+	defer func() {
+		s.gate.L.Lock()
+		s.compacting = false
+		s.gate.Broadcast()
+		s.gate.L.Unlock()
+	}()
+
+	tmpPath := s.path + ".compact"
+	if err := copyBoltDB(s.db, tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to copy database")
+	}
+
+	if err := s.db.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	renameErr := os.Rename(tmpPath, s.path)
+	if renameErr != nil {
+		_ = os.Remove(tmpPath)
+	}
+
+	// The original database is opened again if the rename failed.
+	db, err := openBoltDB(s.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to reopen database")
+	}
+	s.db = db
+
+	return renameErr
+}
+
+// Snapshot writes a consistent copy of the database to a new file at dstPath
+// while other transactions may run. It copies the file within a read-only transaction.
+func (s *storage) Snapshot(dstPath string) error {
+	db := s.begin()
+	defer s.end()
+
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(dstPath, 0600)
+	})
+}
+
+// copyBoltDB copies all buckets, keys and sequences of src into new database
+// at dstPath. The transaction is committed every boltCompactTxSize bytes.
+func copyBoltDB(src *bolt.DB, dstPath string) error {
+	dst, err := openBoltDB(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close() //nolint[errcheck]
+
+	dstTx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dstTx.Rollback() }()
+
+	var size int64
+
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return walkBolt(srcTx, func(path [][]byte, k, v []byte, seq uint64) error {
+			if size += int64(len(k) + len(v)); size > boltCompactTxSize {
+				if err := dstTx.Commit(); err != nil {
+					return err
+				}
+				if dstTx, err = dst.Begin(true); err != nil {
+					return err
+				}
+				size = 0
+			}
+
+			// Top-level bucket.
+			if len(path) == 0 {
+				b, err := dstTx.CreateBucket(k)
+				if err != nil {
+					return err
+				}
+				return b.SetSequence(seq)
+			}
+
+			parent := dstTx.Bucket(path[0])
+			for _, name := range path[1:] {
+				parent = parent.Bucket(name)
+			}
+
+			// Nested bucket.
+			if v == nil {
+				b, err := parent.CreateBucket(k)
+				if err != nil {
+					return err
+				}
+				return b.SetSequence(seq)
+			}
+
+			return parent.Put(k, v)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return dstTx.Commit()
+}
+
+// walkBolt calls fn for every bucket and key in depth-first order. The path
+// contains names of parent buckets. Value is nil for buckets.
+func walkBolt(tx *bolt.Tx, fn func(path [][]byte, k, v []byte, seq uint64) error) error {
+	var walkBucket func(b *bolt.Bucket, path [][]byte) error
+
+	walkBucket = func(b *bolt.Bucket, path [][]byte) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return fn(path, k, v, 0)
+			}
+
+			nested := b.Bucket(k)
+			if err := fn(path, k, nil, nested.Sequence()); err != nil {
+				return err
+			}
+			return walkBucket(nested, append(append([][]byte{}, path...), k))
+		})
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if err := fn(nil, name, nil, b.Sequence()); err != nil {
+			return err
+		}
+		return walkBucket(b, [][]byte{name})
+	})
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func withTestStorage(t *testing.T, test func(t *testing.T, db *storage)) {
+	dir, err := ioutil.TempDir("", "storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	db, err := openStorage(filepath.Join(dir, "mailbox-test.db"))
+	require.NoError(t, err)
+	defer db.Close() //nolint[errcheck]
+
+	test(t, db)
+}
+
+func TestStorageLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	path := filepath.Join(dir, "mailbox-test.db")
+	db, err := openStorage(path)
+	require.NoError(t, err)
+
+	_, err = openStorage(path)
+	require.Equal(t, ErrStorageLocked, err)
+
+	require.NoError(t, db.Close())
+	db, err = openStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestStorageCompact(t *testing.T) {
+	withTestStorage(t, func(t *testing.T, db *storage) {
+		value := make([]byte, 4096)
+
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			mailboxes, err := tx.CreateBucketIfNotExists([]byte("mailboxes"))
+			require.NoError(t, err)
+			mailbox, err := mailboxes.CreateBucketIfNotExists([]byte("inbox"))
+			require.NoError(t, err)
+			_, err = mailbox.NextSequence()
+			require.NoError(t, err)
+			require.NoError(t, mailbox.Put([]byte("kept"), []byte("value")))
+
+			metadata, err := tx.CreateBucketIfNotExists([]byte("metadata"))
+			require.NoError(t, err)
+			for i := uint32(0); i < 2000; i++ {
+				require.NoError(t, metadata.Put(itob(i), value))
+			}
+			return nil
+		}))
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte("metadata"))
+		}))
+
+		free, err := db.FreeSize()
+		require.NoError(t, err)
+		require.True(t, free > 0)
+
+		require.NoError(t, db.Compact())
+
+		freeAfter, err := db.FreeSize()
+		require.NoError(t, err)
+		require.True(t, freeAfter < free, "free %d after %d", free, freeAfter)
+
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			require.Nil(t, tx.Bucket([]byte("metadata")))
+			mailbox := tx.Bucket([]byte("mailboxes")).Bucket([]byte("inbox"))
+			require.Equal(t, []byte("value"), mailbox.Get([]byte("kept")))
+			require.Equal(t, uint64(1), mailbox.Sequence())
+			return nil
+		}))
+	})
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	withTestStorage(t, func(t *testing.T, db *storage) {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			mailbox, err := tx.CreateBucketIfNotExists([]byte("inbox"))
+			require.NoError(t, err)
+			_, err = mailbox.NextSequence()
+			require.NoError(t, err)
+			return mailbox.Put([]byte("key"), []byte("value"))
+		}))
+
+		dir, err := ioutil.TempDir("", "snapshot")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir) //nolint[errcheck]
+
+		dstPath := filepath.Join(dir, "copy.db")
+		require.NoError(t, db.Snapshot(dstPath))
+
+		// The snapshot does not follow later changes.
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("inbox")).Put([]byte("later"), []byte("value"))
+		}))
+
+		snapshot, err := openStorage(dstPath)
+		require.NoError(t, err)
+		defer snapshot.Close() //nolint[errcheck]
+
+		require.NoError(t, snapshot.View(func(tx *bolt.Tx) error {
+			mailbox := tx.Bucket([]byte("inbox"))
+			require.Equal(t, []byte("value"), mailbox.Get([]byte("key")))
+			require.Nil(t, mailbox.Get([]byte("later")))
+			require.Equal(t, uint64(1), mailbox.Sequence())
+			return nil
+		}))
+	})
+}
+
+func TestShouldCompact(t *testing.T) {
+	const mb = 1024 * 1024
+
+	require.False(t, shouldCompact(10*mb, 1*mb), "too little to reclaim")
+	require.False(t, shouldCompact(100*mb, 10*mb), "too small part to reclaim")
+	require.True(t, shouldCompact(100*mb, 30*mb))
+}
+
+func TestStoreCompact(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	for i := 0; i < 200; i++ {
+		insertMessage(t, m, fmt.Sprintf("msg%d", i), strings.Repeat("subject ", 100), addrID1, 0, []string{pmapi.AllMailLabel})
+	}
+	var apiIDs []string
+	for i := 1; i < 200; i++ {
+		apiIDs = append(apiIDs, fmt.Sprintf("msg%d", i))
+	}
+	require.NoError(t, m.store.deleteMessagesEvent(apiIDs))
+
+	reclaimed, err := m.store.Compact()
+	require.NoError(t, err)
+	require.True(t, reclaimed > 0)
+
+	checkAllMessageIDs(t, m, []string{"msg0"})
+}
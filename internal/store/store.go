@@ -22,8 +22,10 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ProtonMail/proton-bridge/pkg/changes"
 	"github.com/ProtonMail/proton-bridge/pkg/listener"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	imapBackend "github.com/emersion/go-imap/backend"
@@ -44,6 +46,10 @@ const (
 	UserFoldersMailboxName = "Folders"
 	// UserFoldersPrefix contains name with delimiter for IMAP
 	UserFoldersPrefix = UserFoldersMailboxName + PathDelimiter
+	// VirtualMailboxesName for IMAP
+	VirtualMailboxesName = "Views"
+	// VirtualMailboxesPrefix contains name with delimiter for IMAP
+	VirtualMailboxesPrefix = VirtualMailboxesName + PathDelimiter
 )
 
 var (
@@ -52,6 +58,8 @@ var (
 	// Database structure:
 	// * metadata
 	//   * {messageID} -> message data (subject, from, to, time, headers, body size, ...)
+	// * bodystructure
+	//   * {messageID} -> serialized message body structure (see message.BodyStructure)
 	// * counts
 	//   * {mailboxID} -> mailboxCounts: totalOnAPI, unreadOnAPI, labelName, labelColor, labelIsExclusive
 	// * address_info
@@ -60,6 +68,30 @@ var (
 	//   * mode -> string split or combined
 	// * mailboxes_version
 	//     * version -> uint32 value
+	// * schema_version (missing in stores created before versioning)
+	//   * version -> uint32 number of applied migrations, see migrations
+	// * subscriptions
+	//   * {mailboxID} -> string true or false (when missing, mailbox is subscribed)
+	// * search_key (only when search index is enabled)
+	//   * key -> key of search index encrypted by the primary address key
+	//   * prev_key -> key replaced by rotation until data are re-encrypted
+	// * search_index (only when search index is enabled)
+	//   * {messageID} -> encrypted searchDocument (subject, body, attachment names, time)
+	// * read_receipts (only when some read receipt was sent)
+	//   * {messageID} -> string unix time when the read receipt was sent
+	// * virtual_queries
+	//   * {addressID+mailboxID} -> string query the virtual mailbox was filled by
+	// * journal (only when some operations wait for API to be reachable)
+	//   * {sequence} -> journalEntry (action, message IDs and label ID)
+	// * body_cache_key (only when body cache is enabled)
+	//   * key -> key of cached bodies encrypted by the primary address key
+	//   * prev_key -> key replaced by rotation until data are re-encrypted
+	//   * ids
+	//     * {messageID} -> string name of the file with cached content
+	//   * refs
+	//     * {name of file} -> uint32 number of IDs sharing the content
+	// * att_cache_key (only when attachment cache is enabled)
+	//   * same as body_cache_key, keyed by attachment IDs
 	// * sync_state
 	//   * sync_state -> string timestamp when it was last synced (when missing, sync should be ongoing)
 	//   * ids_ranges -> json array of groups with start and end message ID (when missing, there is no ongoing sync)
@@ -72,16 +104,28 @@ var (
 	//       * {messageID} -> uint32 imapUID
 	//     * deleted_ids (can be missing or have no keys)
 	//       * {messageID} -> true
-	metadataBucket    = []byte("metadata")          //nolint[gochecknoglobals]
-	countsBucket      = []byte("counts")            //nolint[gochecknoglobals]
-	addressInfoBucket = []byte("address_info")      //nolint[gochecknoglobals]
-	addressModeBucket = []byte("address_mode")      //nolint[gochecknoglobals]
-	syncStateBucket   = []byte("sync_state")        //nolint[gochecknoglobals]
-	mailboxesBucket   = []byte("mailboxes")         //nolint[gochecknoglobals]
-	imapIDsBucket     = []byte("imap_ids")          //nolint[gochecknoglobals]
-	apiIDsBucket      = []byte("api_ids")           //nolint[gochecknoglobals]
-	deletedIDsBucket  = []byte("deleted_ids")       //nolint[gochecknoglobals]
-	mboxVersionBucket = []byte("mailboxes_version") //nolint[gochecknoglobals]
+	metadataBucket      = []byte("metadata")          //nolint[gochecknoglobals]
+	bodystructureBucket = []byte("bodystructure")     //nolint[gochecknoglobals]
+	countsBucket        = []byte("counts")            //nolint[gochecknoglobals]
+	addressInfoBucket   = []byte("address_info")      //nolint[gochecknoglobals]
+	addressModeBucket   = []byte("address_mode")      //nolint[gochecknoglobals]
+	syncStateBucket     = []byte("sync_state")        //nolint[gochecknoglobals]
+	mailboxesBucket     = []byte("mailboxes")         //nolint[gochecknoglobals]
+	imapIDsBucket       = []byte("imap_ids")          //nolint[gochecknoglobals]
+	apiIDsBucket        = []byte("api_ids")           //nolint[gochecknoglobals]
+	deletedIDsBucket    = []byte("deleted_ids")       //nolint[gochecknoglobals]
+	mboxVersionBucket   = []byte("mailboxes_version") //nolint[gochecknoglobals]
+	subscriptionsBucket = []byte("subscriptions")     //nolint[gochecknoglobals]
+	searchKeyBucket     = []byte("search_key")        //nolint[gochecknoglobals]
+	searchIndexBucket   = []byte("search_index")      //nolint[gochecknoglobals]
+	bodyCacheKeyBucket  = []byte("body_cache_key")    //nolint[gochecknoglobals]
+	attCacheKeyBucket   = []byte("att_cache_key")     //nolint[gochecknoglobals]
+	cacheIDsBucket      = []byte("ids")               //nolint[gochecknoglobals]
+	cacheRefsBucket     = []byte("refs")              //nolint[gochecknoglobals]
+	journalBucket       = []byte("journal")           //nolint[gochecknoglobals]
+	readReceiptsBucket  = []byte("read_receipts")     //nolint[gochecknoglobals]
+	schemaVersionBucket = []byte("schema_version")    //nolint[gochecknoglobals]
+	virtualQueryBucket  = []byte("virtual_queries")   //nolint[gochecknoglobals]
 
 	// ErrNoSuchAPIID when mailbox does not have API ID.
 	ErrNoSuchAPIID = errors.New("no such api id") //nolint[gochecknoglobals]
@@ -102,14 +146,81 @@ type Store struct {
 
 	cache       *Cache
 	filePath    string
-	db          *bolt.DB
+	db          *storage
 	lock        *sync.RWMutex
 	addresses   map[string]*Address
 	imapUpdates chan imapBackend.Update
 
+	savedSearches map[string]string
+	sentMessages  *sentMessages
+	searchIndex   *searchIndex
+	bodyCache     *bodyCache
+	attCache      *bodyCache
+	journalLock   sync.Mutex
+
+	maintenanceStopCh chan struct{}
+	stopMaintenance   sync.Once
+
+	events listener.Listener
+
 	isSyncRunning bool
+	syncDuration  time.Duration // Of the last sync which was not resumed.
+	syncWorkers   int
+	syncMemLimit  int64 // Bytes of fetched messages kept in memory during sync.
+	syncMode      string
+	syncProgress  syncProgress
 	syncCooldown  cooldown
 	addressMode   addressMode
+
+	pollActivity *pollActivity
+	rotatingKeys int32
+	paused       int32 // Polling paused by the user, see SetPaused.
+
+	excludedMailboxes map[string]bool
+	excludedLabelIDs  map[string]bool
+	excludedLock      sync.RWMutex
+
+	// countsSynced is true when the on-API counts matched the DB on the last
+	// check and no message changed since then.
+	countsSynced atomic.Value
+
+	changeJournal *changes.Writer
+
+	// countsDrift holds labels whose counts differed on the last check.
+	countsDrift     map[string]bool
+	repairingCounts int32
+}
+
+// Options configure optional features and limits of the store. The zero
+// value turns the optional features off and uses the default limits.
+type Options struct {
+	// SavedSearches (name to query) are exposed as virtual mailboxes.
+	SavedSearches map[string]string
+
+	// SearchIndex turns the local full-text search index on.
+	SearchIndex *SearchIndexPolicy
+
+	// BodyCache turns the on-disk cache of built bodies on.
+	BodyCache *BodyCachePolicy
+
+	// SyncWorkers is the number of parallel workers of the sync; zero uses
+	// the default.
+	SyncWorkers int
+
+	// ExcludedMailboxes are names or label IDs of mailboxes which are not
+	// synced nor exposed over IMAP.
+	ExcludedMailboxes []string
+
+	// SyncMode is SyncModeFull (the default), SyncModeMetadata or
+	// SyncModeMetadataNoCache.
+	SyncMode string
+
+	// PollPolicy sets bounds of the event poll interval; nil uses defaults.
+	PollPolicy *PollPolicy
+
+	// SyncMemoryLimit is the number of bytes of fetched messages kept in
+	// memory during sync; zero keeps everything in memory.
+	SyncMemoryLimit int64
 }
 
 // New creates or opens a store for the given `user`.
@@ -120,6 +231,7 @@ func New(
 	events listener.Listener,
 	path string,
 	cache *Cache,
+	opts Options,
 ) (store *Store, err error) {
 	if user == nil || clientManager == nil || events == nil || cache == nil {
 		return nil, fmt.Errorf("missing parameters - user: %v, api: %v, events: %v, cache: %v", user, clientManager, events, cache)
@@ -136,7 +248,18 @@ func New(
 		firstInit = false
 	}
 
-	bdb, err := openBoltDatabase(path)
+	syncMode, searchPolicy, bodyCachePolicy := opts.SyncMode, opts.SearchIndex, opts.BodyCache
+	if syncMode == "" {
+		syncMode = SyncModeFull
+	}
+	if syncMode != SyncModeFull {
+		searchPolicy = nil
+	}
+	if syncMode == SyncModeMetadataNoCache {
+		bodyCachePolicy = nil
+	}
+
+	bdb, err := openDatabase(path)
 	if err != nil {
 		err = errors.Wrap(err, "failed to open store database")
 		return
@@ -146,12 +269,40 @@ func New(
 		panicHandler:  panicHandler,
 		clientManager: clientManager,
 		user:          user,
+		events:        events,
 		cache:         cache,
 		filePath:      path,
 		db:            bdb,
 		lock:          &sync.RWMutex{},
 		log:           l,
+
+		savedSearches: opts.SavedSearches,
+		sentMessages:  newSentMessages(),
+		syncWorkers:   opts.SyncWorkers,
+		syncMemLimit:  opts.SyncMemoryLimit,
+		syncMode:      syncMode,
+		pollActivity:  newPollActivity(opts.PollPolicy),
+
+		excludedMailboxes: newExcludedMailboxes(opts.ExcludedMailboxes),
+		excludedLabelIDs:  map[string]bool{},
+		countsDrift:       map[string]bool{},
+
+		maintenanceStopCh: make(chan struct{}),
+	}
+	if searchPolicy != nil {
+		store.searchIndex = newSearchIndex(bdb, *searchPolicy)
 	}
+	if bodyCachePolicy != nil {
+		store.bodyCache = newBodyCache(GetBodyCacheDir(path), *bodyCachePolicy, bdb, bodyCacheKeyBucket)
+		if bodyCachePolicy.AttachmentMaxSize > 0 {
+			store.attCache = newBodyCache(GetAttachmentCacheDir(path), BodyCachePolicy{
+				MaxSize:  bodyCachePolicy.AttachmentMaxSize,
+				Eviction: BodyCacheEvictLRU,
+			}, bdb, attCacheKeyBucket)
+		}
+	}
+	store.countsSynced.Store(false)
+	store.openChangeJournal()
 
 	// Minimal increase is event pollInterval, doubles every failed retry up to 5 minutes.
 	store.syncCooldown.setExponentialWait(pollInterval, 2, 5*time.Minute)
@@ -171,29 +322,46 @@ func New(
 			defer store.panicHandler.HandlePanic()
 			store.eventLoop.start()
 		}()
+
+		if store.searchIndex != nil {
+			go func() {
+				defer store.panicHandler.HandlePanic()
+				store.runSearchIndex()
+			}()
+		}
+
+		go func() {
+			defer store.panicHandler.HandlePanic()
+			store.runMaintenance()
+		}()
+
+		go func() {
+			defer store.panicHandler.HandlePanic()
+			store.runCountsCheck()
+		}()
 	}
 
 	return store, err
 }
 
-func openBoltDatabase(filePath string) (db *bolt.DB, err error) {
+func openDatabase(filePath string) (db *storage, err error) {
 	l := log.WithField("path", filePath)
-	l.Debug("Opening bolt database")
+	l.Debug("Opening store database")
 
-	if db, err = bolt.Open(filePath, 0600, &bolt.Options{Timeout: 1 * time.Second}); err != nil {
-		l.WithError(err).Error("Could not open bolt database")
+	if db, err = openStorage(filePath); err != nil {
+		l.WithError(err).Error("Could not open store database")
 		return
 	}
 
-	if val, set := os.LookupEnv("BRIDGESTRICTMODE"); set && val == "1" {
-		db.StrictMode = true
-	}
-
 	tx := func(tx *bolt.Tx) (err error) {
 		if _, err = tx.CreateBucketIfNotExists(metadataBucket); err != nil {
 			return
 		}
 
+		if _, err = tx.CreateBucketIfNotExists(bodystructureBucket); err != nil {
+			return
+		}
+
 		if _, err = tx.CreateBucketIfNotExists(countsBucket); err != nil {
 			return
 		}
@@ -218,11 +386,22 @@ func openBoltDatabase(filePath string) (db *bolt.DB, err error) {
 			return
 		}
 
+		if _, err = tx.CreateBucketIfNotExists(subscriptionsBucket); err != nil {
+			return
+		}
+
 		return
 	}
 
 	if err = db.Update(tx); err != nil {
-		return
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err = migrateStorage(db, filePath, CurrentSchemaVersion()); err != nil {
+		l.WithError(err).Error("Could not migrate store database")
+		_ = db.Close()
+		return nil, err
 	}
 
 	return db, err
@@ -266,7 +445,35 @@ func (store *Store) init(firstInit bool) (err error) {
 		return
 	}
 
-	return err
+	if err = store.removeExcludedMessages(); err != nil {
+		store.log.WithError(err).Warn("Could not remove messages of excluded mailboxes")
+	}
+
+	if store.searchIndex == nil {
+		if err = store.removeSearchIndex(); err != nil {
+			store.log.WithError(err).Warn("Could not remove disabled search index")
+		}
+	}
+
+	if store.bodyCache == nil {
+		if err = store.removeBodyCache(); err != nil {
+			store.log.WithError(err).Warn("Could not remove disabled body cache")
+		}
+	}
+
+	if store.attCache == nil {
+		if err = store.removeAttachmentCache(); err != nil {
+			store.log.WithError(err).Warn("Could not remove disabled attachment cache")
+		}
+	}
+
+	if !store.KeepsBodies() {
+		if err = store.removeBodyStructures(); err != nil {
+			store.log.WithError(err).Warn("Could not remove body structures")
+		}
+	}
+
+	return nil
 }
 
 func (store *Store) client() pmapi.Client {
@@ -307,6 +514,7 @@ func (store *Store) initCounts() (labels []*pmapi.Label, err error) {
 // In combined mode this means just one mailbox for all addresses but in split mode this means one mailbox per address.
 func (store *Store) initAddresses(labels []*pmapi.Label) (err error) {
 	store.addresses = make(map[string]*Address)
+	store.countsSynced.Store(false)
 
 	addrInfo, err := store.GetAddressInfo()
 	if err != nil {
@@ -363,6 +571,34 @@ func (store *Store) PauseEventLoop(pause bool) {
 	}
 }
 
+// SetPaused pauses or resumes polling of events requested by the user.
+// Unlike PauseEventLoop it is not changed by IMAP operations. Poll missed
+// during the pause is done right away after resume.
+func (store *Store) SetPaused(pause bool) {
+	store.log.WithField("pause", pause).Info("Setting user pause of event loop")
+
+	if !pause {
+		atomic.StoreInt32(&store.paused, 0)
+		store.pollActivity.wake()
+		return
+	}
+
+	atomic.StoreInt32(&store.paused, 1)
+}
+
+// PollEventsNow polls events right away, e.g. after the network changed when
+// waiting for the next regular poll would leave the account offline.
+func (store *Store) PollEventsNow() {
+	if store.eventLoop != nil {
+		store.eventLoop.pollSoon()
+	}
+}
+
+// IsPaused returns whether polling of events is paused by the user.
+func (store *Store) IsPaused() bool {
+	return atomic.LoadInt32(&store.paused) == 1
+}
+
 // Close stops the event loop and closes the database to free the file.
 func (store *Store) Close() error {
 	store.lock.Lock()
@@ -380,6 +616,15 @@ func (store *Store) CloseEventLoop() {
 
 func (store *Store) close() error {
 	store.CloseEventLoop()
+	if store.searchIndex != nil {
+		store.searchIndex.stop()
+	}
+	store.stopMaintenance.Do(func() { close(store.maintenanceStopCh) })
+	if store.changeJournal != nil {
+		if err := store.changeJournal.Close(); err != nil {
+			store.log.WithError(err).Warn("Cannot close change journal")
+		}
+	}
 	return store.db.Close()
 }
 
@@ -412,8 +657,24 @@ func RemoveStore(cache *Cache, path, userID string) error {
 	}
 
 	// RemoveAll will not return an error if the path does not exist.
-	if err := os.RemoveAll(path); err != nil {
-		result = multierror.Append(result, errors.Wrap(err, "failed to remove database file"))
+	for _, file := range []string{
+		path, GetStoreBackupPath(path), getSyncBufferPath(path), GetStorageLockPath(path),
+	} {
+		if err := os.RemoveAll(file); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "failed to remove database file"))
+		}
+	}
+
+	if err := changes.Remove(GetChangeJournalPath(path)); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove change journal"))
+	}
+
+	if err := os.RemoveAll(GetBodyCacheDir(path)); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove body cache"))
+	}
+
+	if err := os.RemoveAll(GetAttachmentCacheDir(path)); err != nil {
+		result = multierror.Append(result, errors.Wrap(err, "failed to remove attachment cache"))
 	}
 
 	return result.ErrorOrNil()
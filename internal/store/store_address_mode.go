@@ -18,6 +18,8 @@
 package store
 
 import (
+	"strings"
+
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
 )
@@ -72,7 +74,7 @@ func (store *Store) UseCombinedMode(useCombined bool) (err error) {
 	return
 }
 
-// switchAddressMode sets the address mode to the given value and rebuilds the mailboxes.
+// switchAddressMode sets the address mode to the given value and migrates the mailboxes.
 func (store *Store) switchAddressMode(mode addressMode) (err error) {
 	if store.addressMode == mode {
 		log.Debug("The store is using the correct address mode")
@@ -84,8 +86,8 @@ func (store *Store) switchAddressMode(mode addressMode) (err error) {
 		return
 	}
 
-	if err = store.RebuildMailboxes(); err != nil {
-		log.WithError(err).Error("Could not rebuild mailboxes after switching address mode")
+	if err = store.migrateMailboxes(); err != nil {
+		log.WithError(err).Error("Could not migrate mailboxes after switching address mode")
 		return
 	}
 
@@ -110,3 +112,86 @@ func (store *Store) setAddressMode(mode addressMode) (err error) {
 
 	return
 }
+
+// migrateMailboxes re-homes messages to mailboxes of the current address mode
+// using local metadata only. Mailboxes of the primary address, which is used
+// in both modes, keep UIDs of their messages and only gain or lose messages
+// of other addresses. Mailboxes of other addresses are emptied and filled
+// again in split mode. UIDVALIDITY stays the same, so clients do not have to
+// download all messages again.
+func (store *Store) migrateMailboxes() (err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.log.WithField("mode", store.addressMode).Info("Migrating mailboxes to address mode")
+
+	store.addresses = nil
+
+	if err = store.truncateAddressInfoBucket(); err != nil {
+		return errors.Wrap(err, "failed to truncate address info bucket")
+	}
+
+	if err = store.init(false); err != nil {
+		return errors.Wrap(err, "failed to init store")
+	}
+
+	addrInfo, err := store.GetAddressInfo()
+	if err != nil {
+		return errors.Wrap(err, "failed to get addresses")
+	}
+	if len(addrInfo) < 1 {
+		return errors.New("no addresses to migrate")
+	}
+
+	if err = store.db.Update(func(tx *bolt.Tx) error {
+		return txEmptyOtherMailboxes(tx, addrInfo[0].AddressID)
+	}); err != nil {
+		return errors.Wrap(err, "failed to empty mailboxes of other addresses")
+	}
+
+	return store.initMailboxesBucket()
+}
+
+// txEmptyOtherMailboxes empties all mailboxes not belonging to `addressID`.
+func txEmptyOtherMailboxes(tx *bolt.Tx, addressID string) error {
+	mbs := tx.Bucket(mailboxesBucket)
+
+	var names [][]byte
+	if err := mbs.ForEach(func(name, _ []byte) error {
+		if !strings.HasPrefix(string(name), addressID+"-") {
+			names = append(names, copyBytes(name))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if mbox := mbs.Bucket(name); mbox != nil {
+			if err := txEmptyMailbox(mbox); err != nil {
+				return errors.Wrapf(err, "mailbox %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// txEmptyMailbox removes all messages from the mailbox bucket but keeps its
+// UIDNEXT, so UIDs are not reused under the same UIDVALIDITY.
+func txEmptyMailbox(mbox *bolt.Bucket) error {
+	var uidNext uint64
+	if imapIDs := mbox.Bucket(imapIDsBucket); imapIDs != nil {
+		uidNext = imapIDs.Sequence()
+	}
+
+	for _, name := range [][]byte{imapIDsBucket, apiIDsBucket, deletedIDsBucket} {
+		if err := mbox.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := mbox.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+
+	return mbox.Bucket(imapIDsBucket).SetSequence(uidNext)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func getInboxAPIIDs(t *testing.T, m *mocksForStore, addressID string) map[string]uint32 {
+	address, err := m.store.GetAddress(addressID)
+	require.NoError(t, err)
+	inbox, err := address.GetMailbox("INBOX")
+	require.NoError(t, err)
+
+	apiIDs, err := inbox.GetAPIIDsFromUIDRange(1, 0)
+	require.NoError(t, err)
+
+	uids := map[string]uint32{}
+	for _, apiID := range apiIDs {
+		uids[apiID], err = inbox.getUID(apiID)
+		require.NoError(t, err)
+	}
+	return uids
+}
+
+func TestSwitchAddressModeKeepsPrimaryUIDs(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+	m.client.EXPECT().Addresses().Return(pmapi.AddressList{
+		{ID: addrID1, Email: addr1, Type: pmapi.OriginalAddress, Receive: pmapi.CanReceive},
+		{ID: addrID2, Email: addr2, Type: pmapi.AliasAddress, Receive: pmapi.CanReceive},
+	}).AnyTimes()
+	m.client.EXPECT().ListLabels().AnyTimes()
+	m.client.EXPECT().CountMessages(gomock.Any()).AnyTimes()
+
+	for _, msg := range []*pmapi.Message{
+		getTestMessage("msg1", "Test message 1", addr1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+		getTestMessage("msg2", "Test message 2", addr2, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+		getTestMessage("msg3", "Test message 3", addr1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel}),
+	} {
+		msg.AddressID = addrID1
+		if msg.ID == "msg2" {
+			msg.AddressID = addrID2
+		}
+		require.NoError(t, m.store.createOrUpdateMessageEvent(msg))
+	}
+
+	version := m.store.getMailboxesVersion()
+	combined := getInboxAPIIDs(t, m, addrID1)
+	require.Len(t, combined, 3)
+
+	require.NoError(t, m.store.UseCombinedMode(false))
+	require.Equal(t, version, m.store.getMailboxesVersion())
+	require.Equal(t, map[string]uint32{"msg1": combined["msg1"], "msg3": combined["msg3"]}, getInboxAPIIDs(t, m, addrID1))
+	require.Equal(t, []string{"msg2"}, keys(getInboxAPIIDs(t, m, addrID2)))
+
+	require.NoError(t, m.store.UseCombinedMode(true))
+	require.Equal(t, version, m.store.getMailboxesVersion())
+	back := getInboxAPIIDs(t, m, addrID1)
+	require.Equal(t, combined["msg1"], back["msg1"])
+	require.Equal(t, combined["msg3"], back["msg3"])
+	require.Greater(t, back["msg2"], combined["msg3"])
+
+	// Mailboxes of the alias are emptied but keep UIDNEXT.
+	require.NoError(t, m.store.db.View(func(tx *bolt.Tx) error {
+		mbox := tx.Bucket(mailboxesBucket).Bucket(getMailboxBucketName(addrID2, pmapi.InboxLabel))
+		require.Nil(t, mbox.Bucket(apiIDsBucket).Get([]byte("msg2")))
+		require.Equal(t, uint64(1), mbox.Bucket(imapIDsBucket).Sequence())
+		return nil
+	}))
+}
+
+func keys(uids map[string]uint32) (apiIDs []string) {
+	for apiID := range uids {
+		apiIDs = append(apiIDs, apiID)
+	}
+	return
+}
@@ -24,6 +24,7 @@ import (
 	"sync"
 	"testing"
 
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
 	storemocks "github.com/ProtonMail/proton-bridge/internal/store/mocks"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	pmapimocks "github.com/ProtonMail/proton-bridge/pkg/pmapi/mocks"
@@ -53,6 +54,9 @@ type mocksForStore struct {
 
 	tmpDir string
 	cache  *Cache
+
+	excludedMailboxes []string // Mailbox names or label IDs not synced.
+	syncMode          string
 }
 
 func initMocks(tb testing.TB) (*mocksForStore, func()) {
@@ -95,6 +99,8 @@ func (mocks *mocksForStore) newStoreNoEvents(combinedMode bool) { //nolint[unpar
 	mocks.user.EXPECT().IsCombinedAddressMode().Return(combinedMode)
 
 	mocks.clientManager.EXPECT().GetClient("userID").AnyTimes().Return(mocks.client)
+	mocks.events.EXPECT().Emit(bridgeEvents.SyncProgressEvent, "userID").AnyTimes()
+	mocks.events.EXPECT().Emit(bridgeEvents.SyncFinishedEvent, "userID").AnyTimes()
 
 	mocks.client.EXPECT().Addresses().Return(pmapi.AddressList{
 		{ID: addrID1, Email: addr1, Type: pmapi.OriginalAddress, Receive: pmapi.CanReceive},
@@ -125,6 +131,10 @@ func (mocks *mocksForStore) newStoreNoEvents(combinedMode bool) { //nolint[unpar
 		mocks.events,
 		filepath.Join(mocks.tmpDir, "mailbox-test.db"),
 		mocks.cache,
+		Options{
+			ExcludedMailboxes: mocks.excludedMailboxes,
+			SyncMode:          mocks.syncMode,
+		},
 	)
 	require.NoError(mocks.tb, err)
 
@@ -80,7 +80,7 @@ func (store *Store) TestDumpDB(tb assert.TestingT) {
 	}
 
 	dumpCounts := true
-	fmt.Printf(">>>>>>>> DUMP %s <<<<<\n\n", store.db.Path())
+	fmt.Printf(">>>>>>>> DUMP %s <<<<<\n\n", store.filePath)
 
 	txMails := txDumpMailsFactory(tb)
 
@@ -20,6 +20,7 @@ package store
 import (
 	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/pkg/errors"
@@ -29,22 +30,43 @@ const (
 	syncMinPagesPerWorker  = 10
 	syncMessagesMaxWorkers = 5
 	maxFilterPageSize      = 150
+
+	// syncMaxPagesPerRange splits big mailboxes to more ranges than workers,
+	// so workers taking ranges from the queue are evenly loaded.
+	syncMaxPagesPerRange = 50
+
+	// SyncMaxWorkers is the maximal number of parallel sync workers.
+	SyncMaxWorkers = 20
 )
 
 type storeSynchronizer interface {
 	getAllMessageIDs() ([]string, error)
 	createOrUpdateMessagesEvent([]*pmapi.Message) error
 	deleteMessagesEvent([]string) error
-	saveSyncState(finishTime int64, idRanges []*syncIDRange, idsToBeDeleted []string)
+	saveSyncState(finishTime int64, eventID string, idRanges []*syncIDRange, idsToBeDeleted []string)
+	updateSyncProgress(phase, labelID string, done, total int)
 }
 
 type messageLister interface {
 	ListMessages(*pmapi.MessagesFilter) ([]*pmapi.Message, int, error)
 }
 
-func syncAllMail(panicHandler PanicHandler, store storeSynchronizer, api func() messageLister, syncState *syncState) error {
+// syncAllMail syncs all messages by `workers` parallel workers, each taking
+// ID ranges from the queue until all are synced. Zero workers means default
+// number syncMessagesMaxWorkers. Fetched pages are passed through the buffer
+// to one writer, nil buffer keeps them all in memory.
+func syncAllMail( //nolint[funlen]
+	panicHandler PanicHandler,
+	store storeSynchronizer,
+	api func() messageLister,
+	syncState *syncState,
+	workers int,
+	buffer *syncBuffer,
+) error {
 	labelID := pmapi.AllMailLabel
 
+	store.updateSyncProgress(SyncPhasePreparing, labelID, 0, 0)
+
 	// When the full sync starts (i.e. is not already in progress), we need to load
 	//  - all message IDs in database, so we can see which messages we need to remove at the end of the sync
 	//  - ID ranges which indicate how to split work into multiple workers
@@ -57,31 +79,101 @@ func syncAllMail(panicHandler PanicHandler, store storeSynchronizer, api func()
 			return errors.Wrap(err, "failed to load IDs ranges")
 		}
 		syncState.save()
+	} else {
+		finished, total := syncState.progress()
+		log.WithField("finished", finished).WithField("total", total).Info("Resuming interrupted sync")
+
+		// Number of messages is only needed for progress, sync can continue without it.
+		_, count, err := getSplitIDAndCount(labelID, api(), 0)
+		if err != nil {
+			log.WithError(err).Warn("Cannot get number of messages to sync")
+		}
+		syncState.setTotalCount(count)
+	}
+
+	done, total := syncState.addSyncedCount(0)
+	store.updateSyncProgress(SyncPhaseMessages, labelID, done, total)
+
+	if workers <= 0 {
+		workers = syncMessagesMaxWorkers
+	}
+	if workers > SyncMaxWorkers {
+		workers = SyncMaxWorkers
+	}
+	if workers > len(syncState.idRanges) {
+		workers = len(syncState.idRanges)
+	}
+
+	queue := make(chan *syncIDRange, len(syncState.idRanges))
+	for _, idRange := range syncState.idRanges {
+		queue <- idRange
+	}
+	close(queue)
+
+	log.WithField("ranges", len(queue)).WithField("workers", workers).Info("Syncing messages")
+
+	if buffer == nil {
+		buffer = newSyncBuffer("", 0)
 	}
+	defer func() {
+		if err := buffer.remove(); err != nil {
+			log.WithError(err).Warn("Cannot remove sync buffer")
+		}
+	}()
 
 	wg := &sync.WaitGroup{}
 
-	shouldStop := 0 // Using integer to have it atomic.
+	var shouldStop int32
 	var resultError error
+	var resultLock sync.Mutex
 
-	for _, idRange := range syncState.idRanges {
+	setResultError := func(err error) {
+		atomic.StoreInt32(&shouldStop, 1)
+
+		resultLock.Lock()
+		if resultError == nil {
+			resultError = errors.Wrap(err, "failed to sync group")
+		}
+		resultLock.Unlock()
+	}
+
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		idRange := idRange // Bind for goroutine.
 		go func() {
 			defer panicHandler.HandlePanic()
 			defer wg.Done()
 
-			err := syncBatch(labelID, store, api(), syncState, idRange, &shouldStop)
-			if err != nil {
-				shouldStop = 1
-				resultError = errors.Wrap(err, "failed to sync group")
+			for idRange := range queue {
+				if atomic.LoadInt32(&shouldStop) == 1 {
+					return
+				}
+
+				if err := fetchBatch(labelID, api(), idRange, buffer, &shouldStop); err != nil {
+					setResultError(err)
+					return
+				}
 			}
 		}()
 	}
 
+	writerDone := make(chan struct{})
+	go func() {
+		defer panicHandler.HandlePanic()
+		defer close(writerDone)
+
+		if err := writeBatches(labelID, store, syncState, buffer, &shouldStop); err != nil {
+			setResultError(err)
+		}
+	}()
+
 	wg.Wait()
+	buffer.close()
+	<-writerDone
 
 	if resultError == nil {
+		done, total := syncState.addSyncedCount(0)
+		store.updateSyncProgress(SyncPhaseDeleting, labelID, done, total)
+
 		if err := syncState.deleteMessagesToBeDeleted(); err != nil {
 			return errors.Wrap(err, "failed to delete messages")
 		}
@@ -101,20 +193,24 @@ func findIDRanges(labelID string, api messageLister, syncState *syncState) error
 	}
 
 	syncState.initIDRanges()
+	syncState.setTotalCount(count)
 
 	pages := int(math.Ceil(float64(count) / float64(maxFilterPageSize)))
-	workers := (pages / syncMinPagesPerWorker) + 1
-	if workers > syncMessagesMaxWorkers {
-		workers = syncMessagesMaxWorkers
+	ranges := (pages / syncMinPagesPerWorker) + 1
+	if ranges > syncMessagesMaxWorkers {
+		ranges = syncMessagesMaxWorkers
+	}
+	if bigRanges := int(math.Ceil(float64(pages) / float64(syncMaxPagesPerRange))); bigRanges > ranges {
+		ranges = bigRanges
 	}
 
-	if workers == 1 {
+	if ranges == 1 {
 		return nil
 	}
 
-	step := int(math.Round(float64(pages) / float64(workers)))
-	// Increment steps in case there are more steps than max # of workers (due to rounding).
-	if (step*syncMessagesMaxWorkers)+1 < pages {
+	step := int(math.Round(float64(pages) / float64(ranges)))
+	// Increment steps in case there are more steps than # of ranges (due to rounding).
+	if (step*ranges)+1 < pages {
 		step++
 	}
 
@@ -157,17 +253,21 @@ func getSplitIDAndCount(labelID string, api messageLister, page int) (string, in
 	return messages[0].ID, total, nil
 }
 
-func syncBatch( //nolint[funlen]
+// fetchBatch fetches all messages of the ID range page by page into the
+// buffer. The range itself is moved only by writeBatches once the pages are
+// written, so interrupted sync continues from the last written page.
+func fetchBatch(
 	labelID string,
-	store storeSynchronizer,
 	api messageLister,
-	syncState *syncState,
 	idRange *syncIDRange,
-	shouldStop *int,
+	buffer *syncBuffer,
+	shouldStop *int32,
 ) error {
 	log.WithField("start", idRange.StartID).WithField("stop", idRange.StopID).Info("Starting sync batch")
+
+	startID, stopID := idRange.StartID, idRange.StopID
 	for {
-		if *shouldStop == 1 || idRange.isFinished() {
+		if atomic.LoadInt32(shouldStop) == 1 || (startID == stopID && startID != "") {
 			break
 		}
 
@@ -183,8 +283,8 @@ func syncBatch( //nolint[funlen]
 			// Messages with BeginID and EndID are included. We will process
 			// those messages twice, but that's OK.
 			// When message is completely removed, it still works as expected.
-			BeginID: idRange.StartID,
-			EndID:   idRange.StopID,
+			BeginID: startID,
+			EndID:   stopID,
 		}
 
 		log.WithField("begin", filter.BeginID).WithField("end", filter.EndID).Debug("Fetching page")
@@ -198,21 +298,11 @@ func syncBatch( //nolint[funlen]
 			break
 		}
 
-		for _, m := range messages {
-			syncState.doNotDeleteMessageID(m.ID)
-		}
-		syncState.save()
-
-		if err := store.createOrUpdateMessagesEvent(messages); err != nil {
-			return errors.Wrap(err, "failed to create or update messages")
-		}
-
 		pageLastMessageID := messages[len(messages)-1].ID
-		if !desc {
-			idRange.setStartID(pageLastMessageID)
-		} else {
-			idRange.setStopID(pageLastMessageID)
+		if err := buffer.push(idRange, pageLastMessageID, messages); err != nil {
+			return errors.Wrap(err, "failed to buffer messages")
 		}
+		stopID = pageLastMessageID
 
 		if len(messages) < maxFilterPageSize {
 			break
@@ -220,3 +310,41 @@ func syncBatch( //nolint[funlen]
 	}
 	return nil
 }
+
+// writeBatches writes pages from the buffer to the database until the buffer
+// is closed and empty, moving their ID ranges.
+func writeBatches(
+	labelID string,
+	store storeSynchronizer,
+	syncState *syncState,
+	buffer *syncBuffer,
+	shouldStop *int32,
+) error {
+	for {
+		page, err := buffer.pop()
+		if err != nil {
+			return errors.Wrap(err, "failed to read buffered messages")
+		}
+		if page == nil {
+			return nil
+		}
+		if atomic.LoadInt32(shouldStop) == 1 {
+			continue
+		}
+
+		// Synced IDs are saved together with the new range position below,
+		// so interrupted sync continues from the last finished page.
+		for _, m := range page.messages {
+			syncState.doNotDeleteMessageID(m.ID)
+		}
+
+		if err := store.createOrUpdateMessagesEvent(page.messages); err != nil {
+			return errors.Wrap(err, "failed to create or update messages")
+		}
+
+		done, total := syncState.addSyncedCount(len(page.messages))
+		store.updateSyncProgress(SyncPhaseMessages, labelID, done, total)
+
+		page.idRange.setStopID(page.lastID)
+	}
+}
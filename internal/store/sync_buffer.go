@@ -0,0 +1,209 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"encoding/json"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+)
+
+// syncMessageOverhead is the estimated memory taken by one message besides
+// its texts (struct, slices, maps, allocator overhead).
+const syncMessageOverhead = 1024
+
+// syncBuffer queues pages of messages fetched by sync workers until they are
+// written to the database. Pages are kept in memory up to the limit, the rest
+// is spilled to a temporary file, so fetching does not wait for slow writes
+// and memory taken by sync does not grow with the size of the mailbox.
+type syncBuffer struct {
+	path  string
+	limit int64
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	pages  []*syncPage
+	memory int64
+	closed bool
+
+	file    *os.File
+	fileEnd int64
+}
+
+// syncPage is one page of messages of the ID range. Messages are nil when
+// the page is spilled to the file.
+type syncPage struct {
+	idRange  *syncIDRange
+	lastID   string
+	messages []*pmapi.Message
+	size     int64
+	offset   int64
+}
+
+// newSyncBuffer returns buffer keeping at most limit bytes of messages in
+// memory and spilling the rest to the file on the path. Zero limit keeps
+// everything in memory.
+func newSyncBuffer(path string, limit int64) *syncBuffer {
+	b := &syncBuffer{
+		path:  path,
+		limit: limit,
+	}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// getSyncBufferPath returns the path of the file fetched messages are spilled
+// to during sync of the store database on the path.
+func getSyncBufferPath(storePath string) string {
+	return strings.TrimSuffix(storePath, filepath.Ext(storePath)) + "-sync.tmp"
+}
+
+// push queues the page of messages of the ID range, lastID being the ID the
+// range continues from once the page is written.
+func (b *syncBuffer) push(idRange *syncIDRange, lastID string, messages []*pmapi.Message) error {
+	page := &syncPage{
+		idRange:  idRange,
+		lastID:   lastID,
+		messages: messages,
+		size:     estimateMessagesSize(messages),
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.limit > 0 && b.memory+page.size > b.limit {
+		if err := b.spill(page); err != nil {
+			return err
+		}
+	} else {
+		b.memory += page.size
+	}
+
+	b.pages = append(b.pages, page)
+	b.cond.Signal()
+	return nil
+}
+
+func (b *syncBuffer) spill(page *syncPage) error {
+	data, err := json.Marshal(page.messages)
+	if err != nil {
+		return err
+	}
+
+	if b.file == nil {
+		if b.file, err = os.OpenFile(b.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600); err != nil {
+			return err
+		}
+	}
+	if _, err := b.file.WriteAt(data, b.fileEnd); err != nil {
+		return err
+	}
+
+	page.messages = nil
+	page.offset = b.fileEnd
+	page.size = int64(len(data))
+	b.fileEnd += page.size
+	return nil
+}
+
+// pop returns the oldest page, waiting for one if the buffer is empty. Nil
+// is returned once the buffer is closed and empty.
+func (b *syncBuffer) pop() (*syncPage, error) {
+	b.lock.Lock()
+	for len(b.pages) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.pages) == 0 {
+		b.lock.Unlock()
+		return nil, nil
+	}
+
+	page := b.pages[0]
+	b.pages[0] = nil
+	b.pages = b.pages[1:]
+	if page.messages != nil {
+		b.memory -= page.size
+	}
+	file := b.file
+	b.lock.Unlock()
+
+	if page.messages != nil {
+		return page, nil
+	}
+
+	data := make([]byte, page.size)
+	if _, err := file.ReadAt(data, page.offset); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &page.messages); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// close wakes up pop when no more pages are pushed.
+func (b *syncBuffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// remove drops all pages and removes the spill file.
+func (b *syncBuffer) remove() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.pages = nil
+	b.memory = 0
+	if b.file == nil {
+		return nil
+	}
+
+	_ = b.file.Close()
+	b.file, b.fileEnd = nil, 0
+	return os.Remove(b.path)
+}
+
+// estimateMessagesSize returns approximate memory taken by the messages.
+func estimateMessagesSize(messages []*pmapi.Message) (size int64) {
+	for _, m := range messages {
+		size += syncMessageOverhead
+		size += int64(len(m.Subject) + len(m.Body) + len(m.ExternalID) + len(m.ConversationID))
+		for _, values := range m.Header {
+			for _, value := range values {
+				size += int64(len(value))
+			}
+		}
+		for _, list := range [][]*mail.Address{m.ToList, m.CCList, m.BCCList, m.ReplyTos, {m.Sender}} {
+			for _, address := range list {
+				if address != nil {
+					size += int64(len(address.Name) + len(address.Address))
+				}
+			}
+		}
+		size += int64(len(m.Attachments)) * syncMessageOverhead / 4
+	}
+	return size
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSyncBufferPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sync-buffer-test")
+	require.NoError(t, err)
+
+	return getSyncBufferPath(filepath.Join(dir, "mailbox-test.db")), func() { _ = os.RemoveAll(dir) }
+}
+
+func TestGetSyncBufferPath(t *testing.T) {
+	require.Equal(t, filepath.Join("dir", "mailbox-userID-sync.tmp"), getSyncBufferPath(filepath.Join("dir", "mailbox-userID.db")))
+}
+
+func TestSyncBufferSpillsOverLimit(t *testing.T) {
+	path, cleanup := newTestSyncBufferPath(t)
+	defer cleanup()
+
+	idRange := &syncIDRange{}
+	page := func(id string) []*pmapi.Message {
+		return []*pmapi.Message{{ID: id, Subject: "Subject of " + id}}
+	}
+
+	// Limit fits one page only.
+	buffer := newSyncBuffer(path, estimateMessagesSize(page("msg1")))
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		require.NoError(t, buffer.push(idRange, id, page(id)))
+	}
+	require.Equal(t, estimateMessagesSize(page("msg1")), buffer.memory)
+	_, err := os.Stat(path)
+	require.NoError(t, err)
+	buffer.close()
+
+	// Pages come in order regardless where they were kept.
+	for _, id := range []string{"msg1", "msg2", "msg3"} {
+		p, err := buffer.pop()
+		require.NoError(t, err)
+		require.Equal(t, id, p.lastID)
+		require.Len(t, p.messages, 1)
+		require.Equal(t, "Subject of "+id, p.messages[0].Subject)
+	}
+	p, err := buffer.pop()
+	require.NoError(t, err)
+	require.Nil(t, p)
+	require.Equal(t, int64(0), buffer.memory)
+
+	require.NoError(t, buffer.remove())
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSyncAllMail_SpilledBuffer(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	path, cleanup := newTestSyncBufferPath(t)
+	defer cleanup()
+
+	store := newSyncer()
+	api := &mockLister{messageIDs: generateIDs(1, 1000)}
+	syncState := newTestSyncState(store, "200", "400", "600", "800")
+
+	buffer := newSyncBuffer(path, 10*syncMessageOverhead)
+	require.NoError(t, syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 3, buffer))
+
+	created := map[string]bool{}
+	for _, messageIDs := range store.createdMessageIDsByBatch {
+		for _, messageID := range messageIDs {
+			created[messageID] = true
+		}
+	}
+	require.Len(t, created, 1000)
+
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
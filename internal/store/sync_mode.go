@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import bolt "go.etcd.io/bbolt"
+
+// Sync modes decide what the store keeps of messages locally. Metadata and
+// headers are always synced; bodies are never synced, the modes differ in
+// what else downloads them ahead and whether they are stored.
+const (
+	// SyncModeFull keeps bodies in the body cache, builds the search index
+	// and lets IMAP prefetch bodies, all as set by their own settings.
+	SyncModeFull = "full"
+
+	// SyncModeMetadata downloads bodies only when a client requests them.
+	// The search index is turned off; downloaded bodies are cached as set
+	// by the body cache settings.
+	SyncModeMetadata = "metadata"
+
+	// SyncModeMetadataNoCache is SyncModeMetadata which never stores bodies
+	// or data derived from them (body structures), neither on disk nor in
+	// memory, so every request downloads the body again.
+	SyncModeMetadataNoCache = "metadata-nocache"
+)
+
+// IsSyncMode returns whether `mode` is one of known sync modes.
+func IsSyncMode(mode string) bool {
+	return mode == SyncModeFull || mode == SyncModeMetadata || mode == SyncModeMetadataNoCache
+}
+
+// GetSyncMode returns the sync mode of the store.
+func (store *Store) GetSyncMode() string {
+	return store.syncMode
+}
+
+// KeepsBodies returns whether bodies and data derived from them can be
+// stored, i.e. the store is not in SyncModeMetadataNoCache.
+func (store *Store) KeepsBodies() bool {
+	return store.syncMode != SyncModeMetadataNoCache
+}
+
+// removeBodyStructures removes all stored body structures.
+func (store *Store) removeBodyStructures() error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bodystructureBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bodystructureBucket)
+		return err
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func countBodyStructures(t *testing.T, m *mocksForStore) (count int) {
+	require.NoError(t, m.store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bodystructureBucket).ForEach(func(k, v []byte) error {
+			count++
+			return nil
+		})
+	}))
+	return
+}
+
+func TestSyncModeDefault(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	require.Equal(t, SyncModeFull, m.store.GetSyncMode())
+	require.True(t, m.store.KeepsBodies())
+}
+
+func TestSyncModeMetadataNoCache(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	require.NoError(t, getTestInboxMessage(t, m).SetBodyStructure(&pkgMsg.BodyStructure{}))
+	require.Equal(t, 1, countBodyStructures(t, m))
+	require.NoError(t, m.store.Close())
+
+	// Reopen offline in the mode which must not keep anything from bodies.
+	m.user.EXPECT().IsConnected().Return(false)
+	m.client.EXPECT().ListLabels().Return(nil, errors.New("offline"))
+	m.client.EXPECT().Addresses().Return(nil).AnyTimes()
+
+	var err error
+	m.store, err = New(m.panicHandler, m.user, m.clientManager, m.events, filepath.Join(m.tmpDir, "mailbox-test.db"), m.cache, Options{
+		SearchIndex: &SearchIndexPolicy{},
+		BodyCache:   &BodyCachePolicy{MaxSize: 1024},
+		SyncMode:    SyncModeMetadataNoCache,
+	})
+	require.NoError(t, err)
+
+	require.False(t, m.store.KeepsBodies())
+	require.Nil(t, m.store.searchIndex)
+	require.Nil(t, m.store.bodyCache)
+	require.Equal(t, 0, countBodyStructures(t, m))
+
+	require.NoError(t, getTestInboxMessage(t, m).SetBodyStructure(&pkgMsg.BodyStructure{}))
+	require.Equal(t, 0, countBodyStructures(t, m))
+}
+
+func TestSyncModeMetadataKeepsBodyCache(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.user.EXPECT().ID().Return("userID").AnyTimes()
+	m.user.EXPECT().IsConnected().Return(false)
+	m.user.EXPECT().IsCombinedAddressMode().Return(true)
+	m.clientManager.EXPECT().GetClient("userID").AnyTimes().Return(m.client)
+	m.client.EXPECT().ListLabels().Return(nil, errors.New("offline"))
+	m.client.EXPECT().Addresses().Return(pmapi.AddressList{
+		{ID: addrID1, Email: addr1, Type: pmapi.OriginalAddress, Receive: pmapi.CanReceive},
+	}).AnyTimes()
+
+	var err error
+	m.store, err = New(m.panicHandler, m.user, m.clientManager, m.events, filepath.Join(m.tmpDir, "mailbox-test.db"), m.cache, Options{
+		SearchIndex: &SearchIndexPolicy{},
+		BodyCache:   &BodyCachePolicy{MaxSize: 1024},
+		SyncMode:    SyncModeMetadata,
+	})
+	require.NoError(t, err)
+
+	require.True(t, m.store.KeepsBodies())
+	require.Nil(t, m.store.searchIndex)
+	require.NotNil(t, m.store.bodyCache)
+}
+
+func getTestInboxMessage(t *testing.T, m *mocksForStore) *Message {
+	msg, err := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel].GetMessage("msg1")
+	require.NoError(t, err)
+	return msg
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"sync"
+	"time"
+
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
+)
+
+// Phases of the sync reported by SyncProgress.
+const (
+	SyncPhaseIdle      = "idle"      // Sync is not running.
+	SyncPhasePreparing = "preparing" // Sync is splitting work to ID ranges.
+	SyncPhaseMessages  = "messages"  // Sync is downloading messages.
+	SyncPhaseDeleting  = "deleting"  // Sync is removing messages not on server.
+)
+
+// syncProgressEmitInterval is the minimal time between two progress events
+// of the same phase.
+const syncProgressEmitInterval = time.Second
+
+// SyncProgress describes the state of the running sync.
+type SyncProgress struct {
+	Phase  string
+	Folder string
+
+	// Done and Total are numbers of synced and all messages.
+	Done, Total int
+
+	// ETA is estimated remaining time; zero when it cannot be estimated yet.
+	ETA time.Duration
+}
+
+// syncProgress tracks the progress of the sync and notifies frontends.
+type syncProgress struct {
+	lock sync.Mutex
+
+	progress SyncProgress
+
+	// started and startedDone are time and number of synced messages when
+	// the download started; used to estimate remaining time.
+	started     time.Time
+	startedDone int
+
+	lastEmit time.Time
+}
+
+// updateSyncProgress is called by the sync to report its progress.
+// The progress event (with user ID as data) is emitted when the phase
+// changes or at most once per syncProgressEmitInterval.
+func (store *Store) updateSyncProgress(phase, labelID string, done, total int) {
+	p := &store.syncProgress
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	phaseChanged := p.progress.Phase != phase
+	if phase == SyncPhaseMessages && (phaseChanged || p.started.IsZero()) {
+		p.started = time.Now()
+		p.startedDone = done
+	}
+
+	p.progress = SyncProgress{
+		Phase:  phase,
+		Folder: getSystemFolderName(labelID),
+		Done:   done,
+		Total:  total,
+	}
+	if phase == SyncPhaseMessages && done > p.startedDone && total > done {
+		elapsed := time.Since(p.started)
+		p.progress.ETA = elapsed * time.Duration(total-done) / time.Duration(done-p.startedDone)
+	}
+	if phase == SyncPhaseIdle {
+		p.started = time.Time{}
+	}
+
+	if !phaseChanged && time.Since(p.lastEmit) < syncProgressEmitInterval {
+		return
+	}
+	p.lastEmit = time.Now()
+
+	store.events.Emit(bridgeEvents.SyncProgressEvent, store.UserID())
+}
+
+// GetSyncProgress returns the progress of the running sync.
+func (store *Store) GetSyncProgress() SyncProgress {
+	store.syncProgress.lock.Lock()
+	defer store.syncProgress.lock.Unlock()
+
+	if store.syncProgress.progress.Phase == "" {
+		return SyncProgress{Phase: SyncPhaseIdle}
+	}
+	return store.syncProgress.progress
+}
+
+// getSystemFolderName returns the IMAP name of system folder or empty
+// string if labelID is not a system folder.
+func getSystemFolderName(labelID string) string {
+	for _, counts := range getSystemFolders() {
+		if counts.LabelID == labelID {
+			return counts.LabelName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateSyncProgress(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.user.EXPECT().ID().Return("userID").AnyTimes()
+	store := &Store{user: m.user, events: m.events}
+
+	require.Equal(t, SyncProgress{Phase: SyncPhaseIdle}, store.GetSyncProgress())
+
+	// Event is emitted when phase changes, updates in between are throttled.
+	m.events.EXPECT().Emit(bridgeEvents.SyncProgressEvent, "userID").Times(3)
+
+	store.updateSyncProgress(SyncPhaseMessages, pmapi.AllMailLabel, 0, 1000)
+	require.Equal(t, SyncProgress{Phase: SyncPhaseMessages, Folder: "All Mail", Total: 1000}, store.GetSyncProgress())
+
+	store.syncProgress.started = store.syncProgress.started.Add(-10 * time.Second)
+	store.updateSyncProgress(SyncPhaseMessages, pmapi.AllMailLabel, 250, 1000)
+	progress := store.GetSyncProgress()
+	require.Equal(t, 250, progress.Done)
+	require.InDelta(t, 30*time.Second, progress.ETA, float64(time.Second))
+
+	store.updateSyncProgress(SyncPhaseDeleting, pmapi.AllMailLabel, 1000, 1000)
+	require.Equal(t, time.Duration(0), store.GetSyncProgress().ETA)
+
+	store.updateSyncProgress(SyncPhaseIdle, "", 0, 0)
+	require.Equal(t, SyncProgress{Phase: SyncPhaseIdle}, store.GetSyncProgress())
+}
@@ -32,6 +32,11 @@ type syncState struct {
 	// When it's zero, it was never finished or the sync is ongoing.
 	finishTime int64
 
+	// eventID is the ID of the last processed event when the sync started.
+	// If the event loop loses its position during interrupted sync, events
+	// are replayed from here so changes of already synced ranges are not lost.
+	eventID string
+
 	// idRanges are ID ranges which are used to split work in several workers.
 	// On the beginning of the sync it will find split IDs which are used to
 	// create this ranges. If we have 10000 messages and five workers, it will
@@ -46,6 +51,12 @@ type syncState struct {
 	// again. We do that because we don't want to remove everything on the
 	// beginning of the sync to keep client synced.
 	idsToBeDeletedMap map[string]bool
+
+	// syncedCount and totalCount are numbers of synced and all messages
+	// used to report progress. They are not persisted, the interrupted
+	// sync estimates them from finished ID ranges.
+	syncedCount int
+	totalCount  int
 }
 
 func newSyncState(store storeSynchronizer, finishTime int64, idRanges []*syncIDRange, idsToBeDeleted []string) *syncState {
@@ -74,7 +85,65 @@ func (s *syncState) save() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.store.saveSyncState(s.finishTime, s.idRanges, s.getIDsToBeDeleted())
+	s.store.saveSyncState(s.finishTime, s.eventID, s.idRanges, s.getIDsToBeDeleted())
+}
+
+// setEventID sets the ID of the last processed event when the sync started.
+func (s *syncState) setEventID(eventID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.eventID = eventID
+}
+
+// getEventID returns the ID of the last processed event when the sync started.
+func (s *syncState) getEventID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.eventID
+}
+
+// progress returns the number of finished and all ID ranges.
+func (s *syncState) progress() (finished, total int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, idRange := range s.idRanges {
+		if idRange.isFinished() {
+			finished++
+		}
+	}
+	return finished, len(s.idRanges)
+}
+
+// setTotalCount sets the number of all messages to be synced. When some ID
+// ranges are already finished (interrupted sync), the number of synced
+// messages is estimated by the ratio of finished ranges.
+func (s *syncState) setTotalCount(total int) {
+	finished, ranges := s.progress()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.totalCount = total
+	s.syncedCount = 0
+	if ranges != 0 {
+		s.syncedCount = total * finished / ranges
+	}
+}
+
+// addSyncedCount adds the number of synced messages and returns the numbers
+// of synced and all messages.
+func (s *syncState) addSyncedCount(synced int) (done, total int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.syncedCount += synced
+	if s.syncedCount > s.totalCount {
+		s.totalCount = s.syncedCount
+	}
+	return s.syncedCount, s.totalCount
 }
 
 // isIncomplete returns whether the sync is in progress (no matter whether
@@ -110,6 +179,7 @@ func (s *syncState) setFinishTime() {
 	defer s.lock.Unlock()
 
 	s.finishTime = time.Now().UnixNano()
+	s.eventID = ""
 }
 
 // initIDRanges inits the main full range. Then each range is added
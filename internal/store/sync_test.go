@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/pkg/errors"
@@ -84,6 +85,7 @@ type mockStoreSynchronizer struct {
 	allMessageIDs                  []string
 	errCreateOrUpdateMessagesEvent error
 	createdMessageIDsByBatch       [][]string
+	progress                       []SyncProgress
 }
 
 func newSyncer() *mockStoreSynchronizer {
@@ -121,11 +123,18 @@ func (m *mockStoreSynchronizer) deleteMessagesEvent([]string) error {
 	return nil
 }
 
-func (m *mockStoreSynchronizer) saveSyncState(finishTime int64, idRanges []*syncIDRange, idsToBeDeleted []string) {
+func (m *mockStoreSynchronizer) saveSyncState(finishTime int64, eventID string, idRanges []*syncIDRange, idsToBeDeleted []string) {
 	m.locker.Lock()
 	defer m.locker.Unlock()
 }
 
+func (m *mockStoreSynchronizer) updateSyncProgress(phase, labelID string, done, total int) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	m.progress = append(m.progress, SyncProgress{Phase: phase, Folder: labelID, Done: done, Total: total})
+}
+
 func newTestSyncState(store storeSynchronizer, splitIDs ...string) *syncState {
 	syncState := newSyncState(store, 0, []*syncIDRange{}, []string{})
 	syncState.initIDRanges()
@@ -197,7 +206,7 @@ func TestSyncAllMail(t *testing.T) { //nolint[funlen]
 
 			syncState := newSyncState(store, 0, tc.idRanges, tc.idsToBeDeleted)
 
-			err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState)
+			err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 0, nil)
 			require.Nil(t, err)
 
 			// Check all messages were created or updated.
@@ -222,6 +231,17 @@ func TestSyncAllMail(t *testing.T) { //nolint[funlen]
 	}
 }
 
+// generateBatches returns ID ranges of `step` messages up to `total`.
+func generateBatches(step, total int) [][]string {
+	batches := [][]string{}
+	start := ""
+	for stop := step; stop < total; stop += step {
+		batches = append(batches, []string{start, strconv.Itoa(stop)})
+		start = strconv.Itoa(stop)
+	}
+	return append(batches, []string{start, ""})
+}
+
 func mergeArrays(arrays ...[]string) []string {
 	result := []string{}
 	for _, array := range arrays {
@@ -230,6 +250,94 @@ func mergeArrays(arrays ...[]string) []string {
 	return result
 }
 
+// concurrentLister counts maximal number of concurrent calls.
+type concurrentLister struct {
+	messageLister
+
+	lock          sync.Mutex
+	running, peak int
+}
+
+func (l *concurrentLister) ListMessages(filter *pmapi.MessagesFilter) ([]*pmapi.Message, int, error) {
+	l.lock.Lock()
+	l.running++
+	if l.running > l.peak {
+		l.peak = l.running
+	}
+	l.lock.Unlock()
+
+	defer func() {
+		l.lock.Lock()
+		l.running--
+		l.lock.Unlock()
+	}()
+
+	time.Sleep(time.Millisecond)
+	return l.messageLister.ListMessages(filter)
+}
+
+func TestSyncAllMail_Workers(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	for _, workers := range []int{1, 3} {
+		store := newSyncer()
+		api := &concurrentLister{messageLister: &mockLister{messageIDs: generateIDs(1, 10000)}}
+		syncState := newTestSyncState(store, "2000", "4000", "6000", "8000")
+
+		require.NoError(t, syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, workers, nil))
+
+		created := map[string]bool{}
+		for _, messageIDs := range store.createdMessageIDsByBatch {
+			for _, messageID := range messageIDs {
+				created[messageID] = true
+			}
+		}
+		require.Len(t, created, 10000)
+		require.True(t, api.peak <= workers, "%d workers, peak %d", workers, api.peak)
+	}
+}
+
+func TestSyncAllMail_Progress(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	store := newSyncer()
+	api := &mockLister{messageIDs: generateIDs(1, 10000)}
+	syncState := newSyncState(store, 0, []*syncIDRange{}, []string{})
+
+	require.NoError(t, syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 0, nil))
+
+	progress := store.progress
+	require.Equal(t, SyncProgress{Phase: SyncPhasePreparing, Folder: pmapi.AllMailLabel}, progress[0])
+	require.Equal(t, SyncProgress{Phase: SyncPhaseMessages, Folder: pmapi.AllMailLabel, Total: 10000}, progress[1])
+	for i := 2; i < len(progress)-1; i++ {
+		require.Equal(t, SyncPhaseMessages, progress[i].Phase)
+		require.True(t, progress[i].Done > progress[i-1].Done)
+	}
+	last := progress[len(progress)-1]
+	require.Equal(t, SyncPhaseDeleting, last.Phase)
+	require.True(t, last.Done >= 10000)
+	require.Equal(t, last.Done, last.Total)
+}
+
+func TestSyncAllMail_ProgressOfInterruptedSync(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	store := newSyncer()
+	api := &mockLister{messageIDs: generateIDs(1, 10000)}
+	syncState := newSyncState(store, 0, []*syncIDRange{
+		{StartID: "", StopID: "5000"},
+		{StartID: "5000", StopID: "5000"},
+	}, []string{})
+
+	require.NoError(t, syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 0, nil))
+
+	// Half of ranges is finished, so half of messages is estimated as synced.
+	require.Equal(t, SyncProgress{Phase: SyncPhaseMessages, Folder: pmapi.AllMailLabel, Done: 5000, Total: 10000}, store.progress[1])
+}
+
 func TestSyncAllMail_FailedListing(t *testing.T) {
 	m, clear := initMocks(t)
 	defer clear()
@@ -245,7 +353,7 @@ func TestSyncAllMail_FailedListing(t *testing.T) {
 	}
 	syncState := newTestSyncState(store)
 
-	err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState)
+	err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 0, nil)
 	require.EqualError(t, err, "failed to sync group: failed to list messages: error")
 }
 
@@ -264,7 +372,7 @@ func TestSyncAllMail_FailedCreateOrUpdateMessage(t *testing.T) {
 	}
 	syncState := newTestSyncState(store)
 
-	err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState)
+	err := syncAllMail(m.panicHandler, store, func() messageLister { return api }, syncState, 0, nil)
 	require.EqualError(t, err, "failed to sync group: failed to create or update messages: error")
 }
 
@@ -330,16 +438,21 @@ func TestFindIDRanges(t *testing.T) { //nolint[funlen]
 			},
 		},
 		{
-			"150k messages - 5 batches",
-			generateIDs(1, 150000),
+			"30k messages - 5 batches",
+			generateIDs(1, 30000),
 			[][]string{
-				{"", "30000"},
-				{"30000", "60000"},
-				{"60000", "90000"},
-				{"90000", "120000"},
-				{"120000", ""},
+				{"", "6000"},
+				{"6000", "12000"},
+				{"12000", "18000"},
+				{"18000", "24000"},
+				{"24000", ""},
 			},
 		},
+		{
+			"150k messages - 20 batches for workers queue",
+			generateIDs(1, 150000),
+			generateBatches(7500, 150000),
+		},
 	}
 	for _, tc := range tests {
 		tc := tc
@@ -522,6 +635,11 @@ func TestSyncBatch_FailedCreateOrUpdateMessage(t *testing.T) {
 func testSyncBatch(t *testing.T, store storeSynchronizer, api messageLister, rangeIdx int, splitIDs ...string) error { //nolint[unparam]
 	syncState := newTestSyncState(store, splitIDs...)
 	idRange := syncState.idRanges[rangeIdx]
-	shouldStop := 0
-	return syncBatch(pmapi.AllMailLabel, store, api, syncState, idRange, &shouldStop)
+	buffer := newSyncBuffer("", 0)
+	var shouldStop int32
+	if err := fetchBatch(pmapi.AllMailLabel, api, idRange, buffer, &shouldStop); err != nil {
+		return err
+	}
+	buffer.close()
+	return writeBatches(pmapi.AllMailLabel, store, syncState, buffer, &shouldStop)
 }
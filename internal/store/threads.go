@@ -0,0 +1,290 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Threading data is derived from metadata when it is stored, so clients can
+// be served threads and whole conversations without parsing headers again.
+var (
+	threadsBucket          = []byte("threads")            //nolint[gochecknoglobals]
+	conversationsBucket    = []byte("conversations")      //nolint[gochecknoglobals]
+	threadMessageIDsBucket = []byte("thread_message_ids") //nolint[gochecknoglobals]
+)
+
+// ThreadInfo is what the store keeps about a message to thread it.
+// Message-IDs are without angle brackets.
+type ThreadInfo struct {
+	ConversationID string
+	MessageID      string
+	InReplyTo      string   `json:",omitempty"`
+	References     []string `json:",omitempty"`
+	Time           int64
+}
+
+// newThreadInfo returns threading data of the message. Message-ID falls back
+// to the same values as used in the header of the message served by IMAP.
+func newThreadInfo(msg *pmapi.Message) *ThreadInfo {
+	info := &ThreadInfo{
+		ConversationID: msg.ConversationID,
+		Time:           msg.Time,
+	}
+
+	if ids := parseMessageIDs(msg.Header.Get("Message-Id")); len(ids) > 0 {
+		info.MessageID = ids[0]
+	} else if msg.ExternalID != "" {
+		info.MessageID = strings.Trim(msg.ExternalID, "<>")
+	} else {
+		info.MessageID = msg.ID + "@" + pmapi.InternalIDDomain
+	}
+
+	if ids := parseMessageIDs(msg.Header.Get("In-Reply-To")); len(ids) > 0 {
+		info.InReplyTo = ids[0]
+	}
+	info.References = parseMessageIDs(msg.Header.Get("References"))
+
+	return info
+}
+
+// parseMessageIDs returns Message-IDs listed in the header value.
+func parseMessageIDs(value string) (ids []string) {
+	for _, field := range strings.Fields(value) {
+		if id := strings.Trim(field, "<>,"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+// Parents returns Message-IDs of possible parents starting with the closest.
+func (info *ThreadInfo) Parents() (ids []string) {
+	if info.InReplyTo != "" {
+		ids = append(ids, info.InReplyTo)
+	}
+	for i := len(info.References) - 1; i >= 0; i-- {
+		if ref := info.References[i]; ref != info.InReplyTo && ref != info.MessageID {
+			ids = append(ids, ref)
+		}
+	}
+	return
+}
+
+// GetThreadInfo returns threading data of the message.
+func (store *Store) GetThreadInfo(apiID string) (info *ThreadInfo, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		info, err = txGetThreadInfo(tx, apiID)
+		return err
+	})
+	return
+}
+
+// GetThreadParent returns API ID of the closest known message the message
+// replies to.
+func (store *Store) GetThreadParent(apiID string) (parentID string, ok bool, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		info, err := txGetThreadInfo(tx, apiID)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket(threadMessageIDsBucket)
+		if b == nil {
+			return nil
+		}
+		for _, id := range info.Parents() {
+			if v := b.Get([]byte(id)); v != nil && string(v) != apiID {
+				parentID, ok = string(v), true
+				return nil
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// GetAPIIDByMessageID returns API ID of the message with the Message-ID.
+func (store *Store) GetAPIIDByMessageID(messageID string) (apiID string, ok bool) {
+	_ = store.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(threadMessageIDsBucket); b != nil {
+			if v := b.Get([]byte(strings.Trim(messageID, "<>"))); v != nil {
+				apiID, ok = string(v), true
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// GetConversation returns API IDs of all stored messages of the conversation
+// from the oldest.
+func (store *Store) GetConversation(conversationID string) (apiIDs []string, err error) {
+	times := map[string]uint32{}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		convs := tx.Bucket(conversationsBucket)
+		if convs == nil {
+			return nil
+		}
+		b := convs.Bucket([]byte(conversationID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			apiIDs = append(apiIDs, string(k))
+			times[string(k)] = btoi(v)
+			return nil
+		})
+	})
+	sort.SliceStable(apiIDs, func(i, j int) bool {
+		return times[apiIDs[i]] < times[apiIDs[j]]
+	})
+	return
+}
+
+func txGetThreadInfo(tx *bolt.Tx, apiID string) (*ThreadInfo, error) {
+	b := tx.Bucket(threadsBucket)
+	if b == nil {
+		return nil, ErrNoSuchAPIID
+	}
+	raw := b.Get([]byte(apiID))
+	if raw == nil {
+		return nil, ErrNoSuchAPIID
+	}
+	info := &ThreadInfo{}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, errors.Wrap(err, "cannot unmarshal thread info")
+	}
+	return info, nil
+}
+
+// txPutThreadInfo stores threading data of the message and updates indexes
+// of conversations and Message-IDs.
+func txPutThreadInfo(tx *bolt.Tx, msg *pmapi.Message) error {
+	info := newThreadInfo(msg)
+
+	if old, err := txGetThreadInfo(tx, msg.ID); err == nil {
+		if err := txRemoveThreadIndexes(tx, msg.ID, old); err != nil {
+			return err
+		}
+	}
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal thread info")
+	}
+	threads, err := tx.CreateBucketIfNotExists(threadsBucket)
+	if err != nil {
+		return err
+	}
+	if err := threads.Put([]byte(msg.ID), raw); err != nil {
+		return errors.Wrap(err, "cannot add to threads bucket")
+	}
+
+	if info.ConversationID != "" {
+		convs, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		if err != nil {
+			return err
+		}
+		conv, err := convs.CreateBucketIfNotExists([]byte(info.ConversationID))
+		if err != nil {
+			return err
+		}
+		if err := conv.Put([]byte(msg.ID), itob(uint32(info.Time))); err != nil {
+			return errors.Wrap(err, "cannot add to conversations bucket")
+		}
+	}
+
+	messageIDs, err := tx.CreateBucketIfNotExists(threadMessageIDsBucket)
+	if err != nil {
+		return err
+	}
+	return messageIDs.Put([]byte(info.MessageID), []byte(msg.ID))
+}
+
+// txDeleteThreadInfo removes threading data of the message.
+func txDeleteThreadInfo(tx *bolt.Tx, apiID string) error {
+	info, err := txGetThreadInfo(tx, apiID)
+	if err == ErrNoSuchAPIID {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := txRemoveThreadIndexes(tx, apiID, info); err != nil {
+		return err
+	}
+	return tx.Bucket(threadsBucket).Delete([]byte(apiID))
+}
+
+func txRemoveThreadIndexes(tx *bolt.Tx, apiID string, info *ThreadInfo) error {
+	if convs := tx.Bucket(conversationsBucket); convs != nil && info.ConversationID != "" {
+		if conv := convs.Bucket([]byte(info.ConversationID)); conv != nil {
+			if err := conv.Delete([]byte(apiID)); err != nil {
+				return err
+			}
+			if k, _ := conv.Cursor().First(); k == nil {
+				if err := convs.DeleteBucket([]byte(info.ConversationID)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Another message (e.g. a copy of the same email) can own the Message-ID.
+	if messageIDs := tx.Bucket(threadMessageIDsBucket); messageIDs != nil {
+		if string(messageIDs.Get([]byte(info.MessageID))) == apiID {
+			return messageIDs.Delete([]byte(info.MessageID))
+		}
+	}
+	return nil
+}
+
+// txBuildThreads builds threading data of all stored messages. It migrates
+// stores created before the data were kept.
+func txBuildThreads(tx *bolt.Tx) error {
+	if err := txDeleteThreads(tx); err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(threadsBucket); err != nil {
+		return err
+	}
+
+	return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+		msg := &pmapi.Message{}
+		if err := json.Unmarshal(v, msg); err != nil {
+			log.WithError(err).WithField("apiID", string(k)).Warn("Cannot unmarshal metadata")
+			return nil
+		}
+		return txPutThreadInfo(tx, msg)
+	})
+}
+
+// txDeleteThreads removes all threading data.
+func txDeleteThreads(tx *bolt.Tx) error {
+	for _, name := range [][]byte{threadsBucket, conversationsBucket, threadMessageIDsBucket} {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
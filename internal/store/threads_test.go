@@ -0,0 +1,149 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package store
+
+import (
+	"net/mail"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func insertThreadMessage(t *testing.T, m *mocksForStore, id, conversationID string, time int64, header mail.Header) {
+	msg := getTestMessage(id, "Subject "+id, addr1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	msg.ConversationID = conversationID
+	msg.Time = time
+	msg.ExternalID = id + "@example.com"
+	msg.Header = header
+	require.NoError(t, m.store.createOrUpdateMessageEvent(msg))
+}
+
+func TestThreadInfo(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertThreadMessage(t, m, "msg2", "conv1", 200, mail.Header{
+		"In-Reply-To": {"<msg1@example.com>"},
+		"References":  {"<msg1@example.com>"},
+	})
+	insertThreadMessage(t, m, "msg1", "conv1", 100, nil)
+	insertThreadMessage(t, m, "msg3", "conv2", 300, mail.Header{
+		"References": {"<unknown@example.com> <msg2@example.com>"},
+	})
+
+	info, err := m.store.GetThreadInfo("msg2")
+	require.NoError(t, err)
+	require.Equal(t, &ThreadInfo{
+		ConversationID: "conv1",
+		MessageID:      "msg2@example.com",
+		InReplyTo:      "msg1@example.com",
+		References:     []string{"msg1@example.com"},
+		Time:           200,
+	}, info)
+
+	conversation, err := m.store.GetConversation("conv1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg1", "msg2"}, conversation)
+
+	parentID, ok, err := m.store.GetThreadParent("msg3")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "msg2", parentID)
+
+	_, ok, err = m.store.GetThreadParent("msg1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	apiID, ok := m.store.GetAPIIDByMessageID("<msg1@example.com>")
+	require.True(t, ok)
+	require.Equal(t, "msg1", apiID)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg1"))
+	conversation, err = m.store.GetConversation("conv1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg2"}, conversation)
+	_, ok = m.store.GetAPIIDByMessageID("msg1@example.com")
+	require.False(t, ok)
+	_, err = m.store.GetThreadInfo("msg1")
+	require.Equal(t, ErrNoSuchAPIID, err)
+
+	require.NoError(t, m.store.deleteMessageEvent("msg3"))
+	require.NoError(t, m.store.db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket(conversationsBucket).Bucket([]byte("conv2")))
+		return nil
+	}))
+}
+
+func TestThreadInfoFromFetchedHeader(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertThreadMessage(t, m, "msg1", "conv1", 100, nil)
+	msg := getTestMessage("msg2", "Re: Subject", addr1, 0, []string{pmapi.AllMailLabel, pmapi.InboxLabel})
+	msg.ConversationID = "conv1"
+	require.NoError(t, m.store.createOrUpdateMessageEvent(msg))
+
+	info, err := m.store.GetThreadInfo("msg2")
+	require.NoError(t, err)
+	require.Equal(t, "msg2@"+pmapi.InternalIDDomain, info.MessageID)
+	require.Empty(t, info.Parents())
+
+	storeMsg, err := m.store.addresses[addrID1].mailboxes[pmapi.InboxLabel].GetMessage("msg2")
+	require.NoError(t, err)
+	require.NoError(t, storeMsg.SetContentTypeAndHeader("text/plain", mail.Header{
+		"Message-Id":  {"<reply@example.com>"},
+		"In-Reply-To": {"<msg1@example.com>"},
+	}))
+
+	parentID, ok, err := m.store.GetThreadParent("msg2")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "msg1", parentID)
+
+	apiID, ok := m.store.GetAPIIDByMessageID("reply@example.com")
+	require.True(t, ok)
+	require.Equal(t, "msg2", apiID)
+	_, ok = m.store.GetAPIIDByMessageID("msg2@" + pmapi.InternalIDDomain)
+	require.False(t, ok)
+}
+
+func TestBuildThreadsOfOldStore(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	insertThreadMessage(t, m, "msg1", "conv1", 100, nil)
+	insertThreadMessage(t, m, "msg2", "conv1", 200, nil)
+
+	require.NoError(t, m.store.db.Update(txDeleteThreads))
+	conversation, err := m.store.GetConversation("conv1")
+	require.NoError(t, err)
+	require.Empty(t, conversation)
+
+	require.NoError(t, m.store.db.Update(txBuildThreads))
+
+	conversation, err = m.store.GetConversation("conv1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg1", "msg2"}, conversation)
+}
@@ -156,7 +156,7 @@ func (store *Store) deleteMailbox(labelID, addressID string) error {
 func (store *Store) createLabelsIfMissing(affectedLabelIDs map[string]bool) error {
 	newLabelIDs := []string{}
 	for labelID := range affectedLabelIDs {
-		if pmapi.IsSystemLabel(labelID) || store.allAddressesHaveMailbox(labelID) {
+		if pmapi.IsSystemLabel(labelID) || store.isLabelIDExcluded(labelID) || store.allAddressesHaveMailbox(labelID) {
 			continue
 		}
 		newLabelIDs = append(newLabelIDs, labelID)
@@ -196,12 +196,22 @@ func (store *Store) createOrUpdateMailboxEvent(label *pmapi.Label) error {
 		return errors.Wrap(err, "cannot update counts")
 	}
 
+	// Mailbox which became excluded, e.g. by rename, is removed.
+	if store.isLabelExcluded(label) {
+		for _, a := range store.addresses {
+			if err := a.deleteMailboxEvent(label.ID); err != nil {
+				return err
+			}
+		}
+		return store.updateVirtualMailboxes()
+	}
+
 	for _, a := range store.addresses {
 		if err := a.createOrUpdateMailboxEvent(label); err != nil {
 			return err
 		}
 	}
-	return nil
+	return store.updateVirtualMailboxes()
 }
 
 // deleteMailboxEvent deletes the mailbox in the store.
@@ -219,5 +229,5 @@ func (store *Store) deleteMailboxEvent(labelID string) error {
 			return err
 		}
 	}
-	return nil
+	return store.updateVirtualMailboxes()
 }
@@ -116,6 +116,7 @@ func (store *Store) createAttachment(kr *crypto.KeyRing, attachment *pmapi.Attac
 // SendMessage sends the message.
 func (store *Store) SendMessage(messageID string, req *pmapi.SendMessageReq) error {
 	defer store.eventLoop.pollNow()
+	store.MarkActive()
 	_, _, err := store.client().SendMessage(messageID, req)
 	return err
 }
@@ -132,6 +133,12 @@ func (store *Store) getAllMessageIDs() (apiIDs []string, err error) {
 	return
 }
 
+// GetMessageMetadata returns the message as kept in DB, i.e. without body
+// unless it was fetched already.
+func (store *Store) GetMessageMetadata(apiID string) (*pmapi.Message, error) {
+	return store.getMessageFromDB(apiID)
+}
+
 // getMessageFromDB returns pmapi struct of message by API ID.
 func (store *Store) getMessageFromDB(apiID string) (msg *pmapi.Message, err error) {
 	err = store.db.View(func(tx *bolt.Tx) error {
@@ -182,6 +189,8 @@ func (store *Store) createOrUpdateMessageEvent(msg *pmapi.Message) error {
 func (store *Store) createOrUpdateMessagesEvent(msgs []*pmapi.Message) error { //nolint[funlen]
 	store.log.WithField("msgs", msgs).Trace("Creating or updating messages in the store")
 
+	store.countsSynced.Store(false)
+
 	// Strip non meta first to reduce memory (no need to keep all old msg ID data during update).
 	err := store.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(metadataBucket)
@@ -205,6 +214,13 @@ func (store *Store) createOrUpdateMessagesEvent(msgs []*pmapi.Message) error { /
 		return err
 	}
 
+	if msgs, err = store.removeExcludedMessagesEvent(msgs); err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
 	// Updating metadata and mailboxes is not atomic, but this is OK.
 	// The worst case scenario is we have metadata but not updated mailboxes
 	// which is OK as without information in mailboxes IMAP we will never ask
@@ -216,10 +232,14 @@ func (store *Store) createOrUpdateMessagesEvent(msgs []*pmapi.Message) error { /
 	err = store.db.Update(func(tx *bolt.Tx) error {
 		metaBucket := tx.Bucket(metadataBucket)
 		for _, msg := range msgs {
+			store.txRecordFlagsChange(metaBucket, msg)
 			err := store.txPutMessage(metaBucket, msg)
 			if err != nil {
 				return err
 			}
+			if err := txPutThreadInfo(tx, msg); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -241,6 +261,8 @@ func (store *Store) createOrUpdateMessagesEvent(msgs []*pmapi.Message) error { /
 		return err
 	}
 
+	store.enqueueForSearchIndex(msgs)
+
 	return nil
 }
 
@@ -301,12 +323,26 @@ func (store *Store) deleteMessageEvent(apiID string) error {
 
 // deleteMessagesEvent deletes the message from metadata and all mailbox buckets.
 func (store *Store) deleteMessagesEvent(apiIDs []string) error {
-	return store.db.Update(func(tx *bolt.Tx) error {
+	store.countsSynced.Store(false)
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
 		for _, apiID := range apiIDs {
 			if err := tx.Bucket(metadataBucket).Delete([]byte(apiID)); err != nil {
 				return err
 			}
 
+			if err := tx.Bucket(bodystructureBucket).Delete([]byte(apiID)); err != nil {
+				return err
+			}
+
+			if err := txDeleteThreadInfo(tx, apiID); err != nil {
+				return err
+			}
+
+			if err := txDeleteReadReceiptSent(tx, apiID); err != nil {
+				return err
+			}
+
 			for _, a := range store.addresses {
 				if err := a.txDeleteMessage(tx, apiID); err != nil {
 					return err
@@ -315,4 +351,19 @@ func (store *Store) deleteMessagesEvent(apiIDs []string) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if store.searchIndex != nil {
+		if err := store.searchIndex.remove(apiIDs); err != nil {
+			store.log.WithError(err).Warn("Cannot remove messages from search index")
+		}
+	}
+
+	if store.bodyCache != nil {
+		store.bodyCache.remove(apiIDs)
+	}
+
+	return nil
 }
@@ -19,8 +19,10 @@ package store
 
 import (
 	"net/mail"
+	"strings"
 	"testing"
 
+	pkgMsg "github.com/ProtonMail/proton-bridge/pkg/message"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	a "github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -125,6 +127,40 @@ func TestDeleteMessage(t *testing.T) {
 	checkMailboxMessageIDs(t, m, pmapi.AllMailLabel, []wantID{{"msg2", 2}})
 }
 
+func TestBodyStructure(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+	insertMessage(t, m, "msg1", "Test message 1", addrID1, 0, []string{pmapi.AllMailLabel})
+
+	storeMsg, err := m.store.addresses[addrID1].mailboxes[pmapi.AllMailLabel].GetMessage("msg1")
+	require.Nil(t, err)
+
+	// Nothing is stored before the message is built.
+	bs, err := storeMsg.GetBodyStructure()
+	require.Nil(t, err)
+	require.Nil(t, bs)
+
+	wantBS, err := pkgMsg.NewBodyStructure(strings.NewReader("Content-Type: text/plain\r\n\r\nbody\r\n"))
+	require.Nil(t, err)
+	require.Nil(t, storeMsg.SetBodyStructure(wantBS))
+
+	bs, err = storeMsg.GetBodyStructure()
+	require.Nil(t, err)
+	wantIMAP, err := wantBS.IMAPBodyStructure([]int{})
+	require.Nil(t, err)
+	haveIMAP, err := bs.IMAPBodyStructure([]int{})
+	require.Nil(t, err)
+	require.Equal(t, wantIMAP, haveIMAP)
+
+	// Body structure is removed together with the message.
+	require.Nil(t, m.store.deleteMessageEvent("msg1"))
+	bs, err = storeMsg.GetBodyStructure()
+	require.Nil(t, err)
+	require.Nil(t, bs)
+}
+
 func insertMessage(t *testing.T, m *mocksForStore, id, subject, sender string, unread int, labelIDs []string) { //nolint[unparam]
 	msg := getTestMessage(id, subject, sender, unread, labelIDs)
 	require.Nil(t, m.store.createOrUpdateMessageEvent(msg))
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// IsSubscribed returns whether the mailbox with labelID is subscribed.
+// All mailboxes are subscribed unless the user unsubscribed them.
+func (store *Store) IsSubscribed(labelID string) bool {
+	subscribed := true
+	err := store.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(subscriptionsBucket).Get([]byte(labelID)); value != nil {
+			subscribed, _ = strconv.ParseBool(string(value))
+		}
+		return nil
+	})
+	if err != nil {
+		store.log.WithError(err).WithField("labelID", labelID).Warn("Cannot get subscription")
+	}
+	return subscribed
+}
+
+// SetSubscribed stores the subscription of the mailbox with labelID.
+func (store *Store) SetSubscribed(labelID string, subscribed bool) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).Put([]byte(labelID), []byte(strconv.FormatBool(subscribed)))
+	})
+}
+
+// ImportUnsubscribed marks mailboxes as unsubscribed unless their subscription
+// was already set. It is used to migrate subscriptions kept by older versions.
+func (store *Store) ImportUnsubscribed(labelIDs []string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(subscriptionsBucket)
+		for _, labelID := range labelIDs {
+			if labelID == "" || b.Get([]byte(labelID)) != nil {
+				continue
+			}
+			if err := b.Put([]byte(labelID), []byte(strconv.FormatBool(false))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
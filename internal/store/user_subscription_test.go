@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptions(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	require.True(t, m.store.IsSubscribed(pmapi.AllMailLabel))
+
+	require.NoError(t, m.store.SetSubscribed(pmapi.AllMailLabel, false))
+	require.False(t, m.store.IsSubscribed(pmapi.AllMailLabel))
+	require.True(t, m.store.IsSubscribed(pmapi.InboxLabel))
+
+	require.NoError(t, m.store.SetSubscribed(pmapi.AllMailLabel, true))
+	require.True(t, m.store.IsSubscribed(pmapi.AllMailLabel))
+}
+
+func TestImportUnsubscribed(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	// Explicit subscription has priority over imported one.
+	require.NoError(t, m.store.SetSubscribed(pmapi.InboxLabel, true))
+
+	require.NoError(t, m.store.ImportUnsubscribed([]string{pmapi.AllMailLabel, pmapi.InboxLabel, ""}))
+	require.False(t, m.store.IsSubscribed(pmapi.AllMailLabel))
+	require.True(t, m.store.IsSubscribed(pmapi.InboxLabel))
+}
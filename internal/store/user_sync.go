@@ -21,7 +21,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 
+	bridgeEvents "github.com/ProtonMail/proton-bridge/internal/events"
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -31,6 +33,7 @@ import (
 const syncFinishTimeKey = "sync_state" // The original key was sync_state and we want to keep compatibility.
 const syncIDRangesKey = "id_ranges"
 const syncIDsToBeDeletedKey = "ids_to_be_deleted"
+const syncEventIDKey = "event_id"
 
 // updateCountsFromServer will download and set the counts.
 func (store *Store) updateCountsFromServer() error {
@@ -63,7 +66,12 @@ func (store *Store) isSynced(countsOnAPI []*pmapi.MessagesCount) (bool, error) {
 	defer store.lock.Unlock()
 
 	countsAreOK := true
+	drift := map[string]bool{}
 	for _, counts := range allCounts {
+		if store.isLabelIDExcluded(counts.LabelID) {
+			continue
+		}
+
 		total, unread := uint(0), uint(0)
 		for _, address := range store.addresses {
 			mbox, err := address.getMailboxByID(counts.LabelID)
@@ -98,9 +106,16 @@ func (store *Store) isSynced(countsOnAPI []*pmapi.MessagesCount) (bool, error) {
 				"api-unread": counts.UnreadOnAPI,
 			}).Warning("counts differ")
 			countsAreOK = false
+			drift[counts.LabelID] = true
 		}
 	}
 
+	store.repairCountsDrift(drift)
+
+	// API counts are per label for the whole account, they can be used as
+	// mailbox counts only if there is just one address.
+	store.countsSynced.Store(countsAreOK && len(store.addresses) == 1)
+
 	return countsAreOK, nil
 }
 
@@ -112,7 +127,8 @@ func (store *Store) isSynced(countsOnAPI []*pmapi.MessagesCount) (bool, error) {
 //    `triggerSync` will start full sync.
 //  * Database has syncIDRangesKey and syncIDsToBeDeletedKey keys with data.
 //    Sync is in progress or was interrupted. In later case when, `triggerSync`
-//    will continue where it left off.
+//    will continue where it left off. Ranges are updated after each synced
+//    page and syncEventIDKey holds the event ID from the start of the sync.
 //  * Database has only syncStateKey with time when database was last synced.
 //    `triggerSync` will reset it and start full sync again.
 func (store *Store) triggerSync() {
@@ -141,6 +157,14 @@ func (store *Store) triggerSync() {
 			return
 		}
 
+		// Another sync triggered before this one could finish in the meantime;
+		// the state loaded above is stale then and new sync is not needed.
+		if store.isSyncFinished() {
+			store.lock.Unlock()
+			store.log.Info("Skipping sync: store was synced in the meantime")
+			return
+		}
+
 		store.isSyncRunning = true
 		store.lock.Unlock()
 
@@ -148,11 +172,20 @@ func (store *Store) triggerSync() {
 			store.lock.Lock()
 			store.isSyncRunning = false
 			store.lock.Unlock()
+
+			store.updateSyncProgress(SyncPhaseIdle, "", 0, 0)
 		}()
 
-		store.log.WithField("isIncomplete", syncState.isIncomplete()).Info("Store sync started")
+		isResumed := syncState.isIncomplete()
+		store.log.WithField("isIncomplete", isResumed).Info("Store sync started")
+
+		startTime := time.Now()
+		if !isResumed {
+			syncState.setEventID(store.cache.getEventID(store.UserID()))
+		}
 
-		err := syncAllMail(store.panicHandler, store, func() messageLister { return store.client() }, syncState)
+		buffer := newSyncBuffer(getSyncBufferPath(store.filePath), store.syncMemLimit)
+		err := syncAllMail(store.panicHandler, store, func() messageLister { return store.client() }, syncState, store.syncWorkers, buffer)
 		if err != nil {
 			log.WithError(err).Error("Store sync failed")
 			store.syncCooldown.increaseWaitTime()
@@ -161,9 +194,38 @@ func (store *Store) triggerSync() {
 
 		store.syncCooldown.reset()
 		syncState.setFinishTime()
+
+		store.lock.Lock()
+		if isResumed {
+			store.syncDuration = 0
+		} else {
+			store.syncDuration = time.Since(startTime)
+		}
+		store.lock.Unlock()
+
+		store.events.Emit(bridgeEvents.SyncFinishedEvent, store.UserID())
 	}()
 }
 
+// GetLastSyncDuration returns how long the last sync took or zero when
+// there was no sync yet or it was resumed after restart.
+func (store *Store) GetLastSyncDuration() time.Duration {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.syncDuration
+}
+
+// getSyncEventID returns the ID of the last processed event when the
+// unfinished sync started or empty string if there is no such sync.
+func (store *Store) getSyncEventID() string {
+	syncState := store.loadSyncState()
+	if !syncState.isIncomplete() {
+		return ""
+	}
+	return syncState.getEventID()
+}
+
 // isSyncFinished returns whether the database has finished a sync.
 func (store *Store) isSyncFinished() (isSynced bool) {
 	return store.loadSyncState().isFinished()
@@ -173,6 +235,7 @@ func (store *Store) isSyncFinished() (isSynced bool) {
 // See `triggerSync` to learn more about possible states.
 func (store *Store) loadSyncState() *syncState {
 	finishTime := int64(0)
+	eventID := ""
 	idRanges := []*syncIDRange{}
 	idsToBeDeleted := []string{}
 
@@ -187,6 +250,8 @@ func (store *Store) loadSyncState() *syncState {
 			}
 		}
 
+		eventID = string(b.Get([]byte(syncEventIDKey)))
+
 		idRangesData := b.Get([]byte(syncIDRangesKey))
 		if idRangesData != nil {
 			if err := json.Unmarshal(idRangesData, &idRanges); err != nil {
@@ -208,12 +273,14 @@ func (store *Store) loadSyncState() *syncState {
 		store.log.WithError(err).Error("Failed to load sync state")
 	}
 
-	return newSyncState(store, finishTime, idRanges, idsToBeDeleted)
+	syncState := newSyncState(store, finishTime, idRanges, idsToBeDeleted)
+	syncState.eventID = eventID
+	return syncState
 }
 
 // saveSyncState saves information about sync to database.
 // See `triggerSync` to learn more about possible states.
-func (store *Store) saveSyncState(finishTime int64, idRanges []*syncIDRange, idsToBeDeleted []string) {
+func (store *Store) saveSyncState(finishTime int64, eventID string, idRanges []*syncIDRange, idsToBeDeleted []string) {
 	idRangesData, err := json.Marshal(idRanges)
 	if err != nil {
 		store.log.WithError(err).Error("Failed to marshall sync IDs ranges")
@@ -237,6 +304,9 @@ func (store *Store) saveSyncState(finishTime int64, idRanges []*syncIDRange, ids
 			if err := b.Delete([]byte(syncIDsToBeDeletedKey)); err != nil {
 				return err
 			}
+			if err := b.Delete([]byte(syncEventIDKey)); err != nil {
+				return err
+			}
 		} else {
 			if err := b.Delete([]byte(syncFinishTimeKey)); err != nil {
 				return err
@@ -247,6 +317,9 @@ func (store *Store) saveSyncState(finishTime int64, idRanges []*syncIDRange, ids
 			if err := b.Put([]byte(syncIDsToBeDeletedKey), idsToBeDeletedData); err != nil {
 				return err
 			}
+			if err := b.Put([]byte(syncEventIDKey), []byte(eventID)); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -20,6 +20,7 @@ package store
 import (
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
 	"github.com/stretchr/testify/assert"
@@ -49,10 +50,13 @@ func TestLoadSaveSyncState(t *testing.T) {
 	syncState.initIDRanges()
 	syncState.addIDRange("100")
 	syncState.addIDRange("200")
+	syncState.setEventID("event1")
 	syncState.save()
 
 	syncState = m.store.loadSyncState()
 	checkSyncStateAfterLoad(t, syncState, false, true, []string{})
+	assert.Equal(t, "event1", syncState.getEventID())
+	assert.Equal(t, "event1", m.store.getSyncEventID())
 
 	// Save IDs to be deleted and check everything is properly loaded.
 
@@ -67,6 +71,31 @@ func TestLoadSaveSyncState(t *testing.T) {
 
 	syncState = m.store.loadSyncState()
 	checkSyncStateAfterLoad(t, syncState, true, false, []string{})
+	assert.Equal(t, "", m.store.getSyncEventID())
+}
+
+func TestFirstEventIDFromInterruptedSync(t *testing.T) {
+	m, clear := initMocks(t)
+	defer clear()
+
+	m.newStoreNoEvents(true)
+
+	// Running event loop would continue the sync.
+	require.Eventually(t, m.store.isSyncFinished, time.Second, 10*time.Millisecond)
+	m.store.CloseEventLoop()
+
+	syncState := m.store.loadSyncState()
+	syncState.clearFinishTime()
+	syncState.initIDRanges()
+	syncState.addIDRange("100")
+	syncState.setEventID("eventAtSyncStart")
+	syncState.save()
+
+	// No latest event is requested from API.
+	loop := newEventLoop(m.cache, m.store, m.user, m.events)
+	require.NoError(t, loop.setFirstEventID())
+	require.Equal(t, "eventAtSyncStart", loop.currentEventID)
+	require.Equal(t, "eventAtSyncStart", m.cache.getEventID("userID"))
 }
 
 func checkSyncStateAfterLoad(t *testing.T, syncState *syncState, wantIsFinished bool, wantIDRanges bool, wantIDsToBeDeleted []string) {
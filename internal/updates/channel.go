@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+// Update channels. Early access gets new versions before they are released
+// to everybody.
+const (
+	StableChannel = "stable"
+	EarlyChannel  = "early"
+
+	earlyChannelDir = "early"
+)
+
+// GetChannels returns names of all update channels.
+func GetChannels() []string {
+	return []string{StableChannel, EarlyChannel}
+}
+
+// SetChannel selects the channel of updates. Unknown channel means stable.
+// A newer version already seen on the previous channel is forgotten.
+func (u *Updates) SetChannel(channel string) {
+	if channel != EarlyChannel {
+		channel = StableChannel
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.channel != channel {
+		log.WithField("channel", channel).Info("Update channel changed")
+		u.cachedNewerVersion = nil
+	}
+	u.channel = channel
+}
+
+// GetChannel returns the channel of updates.
+func (u *Updates) GetChannel() string {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.channel
+}
+
+// downloadPath returns path of version and update files of the channel.
+func (u *Updates) downloadPath() string {
+	if u.GetChannel() == EarlyChannel {
+		return DownloadPath + "/" + earlyChannelDir
+	}
+	return DownloadPath
+}
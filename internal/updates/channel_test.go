@@ -0,0 +1,41 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannel(t *testing.T) {
+	u := newTestUpdates("1.1.5")
+	require.Equal(t, StableChannel, u.GetChannel())
+	require.Equal(t, Host+"/"+DownloadPath+"/current_version_linux.json", u.versionFileURL("linux"))
+
+	u.cachedNewerVersion = &VersionInfo{Version: "1.1.6"}
+
+	u.SetChannel(EarlyChannel)
+	require.Equal(t, EarlyChannel, u.GetChannel())
+	require.Nil(t, u.cachedNewerVersion)
+	require.Equal(t, Host+"/"+DownloadPath+"/early/current_version_linux.json", u.versionFileURL("linux"))
+	require.Equal(t, Host+"/"+DownloadPath+"/early/bridge_upgrade_linux.tgz", u.updateFileURL("linux"))
+
+	u.SetChannel("unknown")
+	require.Equal(t, StableChannel, u.GetChannel())
+}
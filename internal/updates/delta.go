@@ -0,0 +1,346 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Deltas are made of uncompressed tar files of updates because compressed
+// archives differ completely after the first changed byte. The format is
+// the magic followed by operations: copy of a range of the old file, literal
+// data, and the end with SHA-256 of the new file.
+const (
+	deltaMagic     = "PMDELTA1"
+	deltaBlockSize = 4096
+	deltaMaxData   = 1 << 20
+
+	deltaOpCopy = 'C'
+	deltaOpData = 'D'
+	deltaOpEnd  = 'E'
+)
+
+// previousDir is the folder in deploy folder with updates of previous
+// versions in subfolders named by version, e.g. previous/1.5.0/.
+const previousDir = "previous"
+
+var (
+	errDeltaCorrupted = errors.New("delta is corrupted")        //nolint[gochecknoglobals]
+	errNoDelta        = errors.New("no delta for this version") //nolint[gochecknoglobals]
+)
+
+// DeltaInfo describes a delta from an older version in the version file.
+// The version file is signed, so the hash of the result proves the update
+// is genuine even though the delta itself is not signed.
+type DeltaInfo struct {
+	From   string // Version the delta applies to.
+	File   string // URL of the delta file.
+	SHA256 string // Hex SHA-256 of uncompressed update archive of the new version.
+}
+
+// weakSum is the rolling checksum of rsync over the block.
+func weakSum(block []byte) (a, b uint32) {
+	for i, x := range block {
+		a += uint32(x)
+		b += uint32(len(block)-i) * uint32(x)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+type deltaWriter struct {
+	w   *bufio.Writer
+	err error
+
+	copyOffset, copyLength int
+}
+
+func (d *deltaWriter) write(data ...interface{}) {
+	for _, v := range data {
+		if d.err == nil {
+			d.err = binary.Write(d.w, binary.BigEndian, v)
+		}
+	}
+}
+
+// copy adds the range of old file, merged with the previous one if adjacent.
+func (d *deltaWriter) copy(offset, length int) {
+	if d.copyLength > 0 && d.copyOffset+d.copyLength == offset {
+		d.copyLength += length
+		return
+	}
+	d.flushCopy()
+	d.copyOffset, d.copyLength = offset, length
+}
+
+func (d *deltaWriter) flushCopy() {
+	if d.copyLength == 0 {
+		return
+	}
+	d.write(byte(deltaOpCopy), uint64(d.copyOffset), uint32(d.copyLength))
+	d.copyLength = 0
+}
+
+func (d *deltaWriter) data(data []byte) {
+	d.flushCopy()
+	for len(data) > 0 {
+		n := len(data)
+		if n > deltaMaxData {
+			n = deltaMaxData
+		}
+		d.write(byte(deltaOpData), uint32(n), data[:n])
+		data = data[n:]
+	}
+}
+
+// createDelta writes the delta which makes newData from oldData.
+func createDelta(oldData, newData []byte, w io.Writer) error {
+	index := map[uint32][]int{}
+	for offset := 0; offset+deltaBlockSize <= len(oldData); offset += deltaBlockSize {
+		a, b := weakSum(oldData[offset : offset+deltaBlockSize])
+		index[a|b<<16] = append(index[a|b<<16], offset)
+	}
+
+	d := &deltaWriter{w: bufio.NewWriter(w)}
+	d.write([]byte(deltaMagic))
+
+	literal := 0
+	var a, b uint32
+	if len(newData) >= deltaBlockSize {
+		a, b = weakSum(newData[:deltaBlockSize])
+	}
+
+	for i := 0; i+deltaBlockSize <= len(newData); {
+		block := newData[i : i+deltaBlockSize]
+
+		match := -1
+		for _, offset := range index[a|b<<16] {
+			if bytes.Equal(oldData[offset:offset+deltaBlockSize], block) {
+				match = offset
+				break
+			}
+		}
+
+		if match >= 0 {
+			if literal < i {
+				d.data(newData[literal:i])
+			}
+			d.copy(match, deltaBlockSize)
+			i += deltaBlockSize
+			literal = i
+			if i+deltaBlockSize <= len(newData) {
+				a, b = weakSum(newData[i : i+deltaBlockSize])
+			}
+			continue
+		}
+
+		// Roll the checksum by one byte.
+		out := uint32(newData[i])
+		if i+deltaBlockSize < len(newData) {
+			in := uint32(newData[i+deltaBlockSize])
+			a = (a - out + in) & 0xffff
+			b = (b - deltaBlockSize*out + a) & 0xffff
+		}
+		i++
+	}
+
+	if literal < len(newData) {
+		d.data(newData[literal:])
+	}
+	d.flushCopy()
+
+	hash := sha256.Sum256(newData)
+	d.write(byte(deltaOpEnd), hash[:])
+
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
+
+// applyDelta returns the new file made of oldData by the delta.
+func applyDelta(oldData []byte, delta io.Reader) ([]byte, error) {
+	r := bufio.NewReader(delta)
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, errDeltaCorrupted
+	}
+
+	var newData bytes.Buffer
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, errDeltaCorrupted
+		}
+
+		switch op {
+		case deltaOpCopy:
+			var offset uint64
+			var length uint32
+			if binary.Read(r, binary.BigEndian, &offset) != nil || binary.Read(r, binary.BigEndian, &length) != nil {
+				return nil, errDeltaCorrupted
+			}
+			if offset > uint64(len(oldData)) || uint64(length) > uint64(len(oldData))-offset {
+				return nil, errDeltaCorrupted
+			}
+			newData.Write(oldData[offset : offset+uint64(length)])
+
+		case deltaOpData:
+			var length uint32
+			if binary.Read(r, binary.BigEndian, &length) != nil || length > deltaMaxData {
+				return nil, errDeltaCorrupted
+			}
+			if _, err := io.CopyN(&newData, r, int64(length)); err != nil {
+				return nil, errDeltaCorrupted
+			}
+
+		case deltaOpEnd:
+			var hash [sha256.Size]byte
+			if _, err := io.ReadFull(r, hash[:]); err != nil {
+				return nil, errDeltaCorrupted
+			}
+			if sha256.Sum256(newData.Bytes()) != hash {
+				return nil, errDeltaCorrupted
+			}
+			return newData.Bytes(), nil
+
+		default:
+			return nil, errDeltaCorrupted
+		}
+	}
+}
+
+// createDeltas makes deltas from updates of previous versions in
+// deploy folder to the update of this version and lists them in the
+// version info.
+func createDeltas(deployDir string, versionInfo *VersionInfo) error {
+	updateFileName := filepath.Base(versionInfo.UpdateFile)
+
+	dirs, err := ioutil.ReadDir(filepath.Join(deployDir, previousDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	newData, err := readTar(filepath.Join(deployDir, updateFileName))
+	if err != nil {
+		return err
+	}
+	hash := sha256Hex(newData)
+
+	for _, dir := range dirs {
+		from := sanitizeVersion(dir.Name())
+		oldData, err := readTar(filepath.Join(deployDir, previousDir, dir.Name(), updateFileName))
+		if err != nil {
+			log.WithError(err).WithField("version", from).Warn("Skipping delta")
+			continue
+		}
+
+		deltaName := strings.TrimSuffix(updateFileName, ".tgz") + "_from_" + from + ".delta"
+		if err := writeDelta(oldData, newData, filepath.Join(deployDir, deltaName)); err != nil {
+			return err
+		}
+
+		versionInfo.Deltas = append(versionInfo.Deltas, DeltaInfo{
+			From:   from,
+			File:   strings.TrimSuffix(versionInfo.UpdateFile, updateFileName) + deltaName,
+			SHA256: hash,
+		})
+	}
+
+	return nil
+}
+
+func writeDelta(oldData, newData []byte, deltaPath string) error {
+	f, err := os.Create(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint[errcheck]
+
+	if err := createDelta(oldData, newData, f); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// downloadDelta downloads the delta from the installed version and makes
+// the update archive of it. The archive is verified by the hash from the
+// signed version file.
+func (u *Updates) downloadDelta(status *Progress, verInfo VersionInfo) (string, *SignatureReport, error) {
+	var delta *DeltaInfo
+	for i := range verInfo.Deltas {
+		if sanitizeVersion(verInfo.Deltas[i].From) == sanitizeVersion(u.version) {
+			delta = &verInfo.Deltas[i]
+		}
+	}
+	if delta == nil {
+		return "", nil, errNoDelta
+	}
+
+	oldData, err := u.loadArchivedTar(u.version)
+	if os.IsNotExist(err) {
+		return "", nil, errNoDelta
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	deltaPath := filepath.Join(u.updateTempDir, filepath.Base(delta.File))
+	if err := downloadWithProgress(status, delta.File, deltaPath); err != nil {
+		return "", nil, err
+	}
+
+	deltaFile, err := os.Open(deltaPath) //nolint[gosec]
+	if err != nil {
+		return "", nil, err
+	}
+	defer deltaFile.Close() //nolint[errcheck]
+
+	status.UpdateDescription(InfoVerifying)
+
+	newData, err := applyDelta(oldData, deltaFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if sha256Hex(newData) != strings.ToLower(delta.SHA256) {
+		return "", nil, errors.New("update made of delta does not match version file")
+	}
+
+	updateTar := filepath.Join(u.updateTempDir, filepath.Base(verInfo.UpdateFile))
+	if err := writeTgz(updateTar, newData); err != nil {
+		return "", nil, err
+	}
+
+	return updateTar, &SignatureReport{
+		File:     filepath.Base(updateTar),
+		Method:   VerifiedByDelta,
+		Verified: true,
+	}, nil
+}
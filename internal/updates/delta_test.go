@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data) //nolint[gosec]
+	return data
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	oldData := newTestData(300*1024, 1)
+
+	// Changed, inserted and removed parts.
+	newData := append([]byte{}, oldData[:50000]...)
+	newData = append(newData, newTestData(1000, 2)...)
+	newData = append(newData, oldData[60000:200000]...)
+	newData = append(newData, oldData[250000:]...)
+	newData[100000] ^= 0xff
+
+	var delta bytes.Buffer
+	require.NoError(t, createDelta(oldData, newData, &delta))
+	require.True(t, delta.Len() < len(newData)/10, "delta has %d bytes", delta.Len())
+
+	result, err := applyDelta(oldData, bytes.NewReader(delta.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, newData, result)
+
+	// Delta applied to other data is detected.
+	_, err = applyDelta(newTestData(300*1024, 3), bytes.NewReader(delta.Bytes()))
+	require.Equal(t, errDeltaCorrupted, err)
+
+	_, err = applyDelta(oldData, bytes.NewReader(delta.Bytes()[:delta.Len()-1]))
+	require.Equal(t, errDeltaCorrupted, err)
+}
+
+func TestDeltaFromEmpty(t *testing.T) {
+	newData := newTestData(10000, 4)
+
+	var delta bytes.Buffer
+	require.NoError(t, createDelta(nil, newData, &delta))
+
+	result, err := applyDelta(nil, &delta)
+	require.NoError(t, err)
+	require.Equal(t, newData, result)
+}
+
+func TestDownloadDelta(t *testing.T) {
+	deployDir, err := ioutil.TempDir("", "deploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(deployDir) //nolint[errcheck]
+
+	oldData := newTestData(100*1024, 5)
+	newData := append(append([]byte{}, oldData[:40000]...), newTestData(500, 6)...)
+	newData = append(newData, oldData[40000:]...)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(deployDir, previousDir, "1.0.1"), 0700))
+	require.NoError(t, writeTgz(filepath.Join(deployDir, previousDir, "1.0.1", "bridge_upgrade_linux.tgz"), oldData))
+	require.NoError(t, writeTgz(filepath.Join(deployDir, "bridge_upgrade_linux.tgz"), newData))
+
+	http.Handle("/download/delta/", http.StripPrefix("/download/delta/", http.FileServer(http.Dir(deployDir))))
+
+	verInfo := VersionInfo{Version: "1.0.2", UpdateFile: Host + "/download/delta/bridge_upgrade_linux.tgz"}
+	require.NoError(t, createDeltas(deployDir, &verInfo))
+	require.Equal(t, []DeltaInfo{{
+		From:   "1.0.1",
+		File:   Host + "/download/delta/bridge_upgrade_linux_from_1.0.1.delta",
+		SHA256: sha256Hex(newData),
+	}}, verInfo.Deltas)
+
+	u := newTestUpdates("1.0.1")
+	u.updateTempDir, err = ioutil.TempDir("", "upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(u.updateTempDir) //nolint[errcheck]
+	defer os.RemoveAll(u.archiveDir())  //nolint[errcheck]
+
+	progress := make(chan Progress)
+	go func() {
+		for range progress {
+		}
+	}()
+	defer close(progress)
+	status := &Progress{channel: progress}
+
+	// Nothing is kept yet, so the whole update must be downloaded.
+	_, _, err = u.downloadDelta(status, verInfo)
+	require.Equal(t, errNoDelta, err)
+
+	require.NoError(t, u.archiveUpdate("1.0.1", filepath.Join(deployDir, previousDir, "1.0.1", "bridge_upgrade_linux.tgz"), &SignatureReport{Method: VerifiedBySignature}))
+
+	updateTar, report, err := u.downloadDelta(status, verInfo)
+	require.NoError(t, err)
+	require.True(t, report.Verified)
+	require.Equal(t, VerifiedByDelta, report.Method)
+
+	result, err := readTar(updateTar)
+	require.NoError(t, err)
+	require.Equal(t, newData, result)
+
+	// Hash not matching the version file is refused.
+	verInfo.Deltas[0].SHA256 = sha256Hex(oldData)
+	_, _, err = u.downloadDelta(status, verInfo)
+	require.Error(t, err)
+}
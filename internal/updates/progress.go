@@ -28,9 +28,10 @@ const (
 )
 
 type Progress struct {
-	Processed   float32 // fraction of finished procedure [0.0-1.0]
-	Description int     // description by code (needs to be translated anyway)
-	Err         error   // occurred error
+	Processed   float32          // fraction of finished procedure [0.0-1.0]
+	Description int              // description by code (needs to be translated anyway)
+	Err         error            // occurred error
+	Signature   *SignatureReport // verification of the update once downloaded
 	channel     chan<- Progress
 }
 
@@ -0,0 +1,288 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// archiveKeep is how many verified updates are kept: the installed one
+	// as a base of deltas and the previous one for rollback.
+	archiveKeep = 2
+
+	rollbackFile = "rollback.json"
+	bundleDir    = "previous_app"
+)
+
+// ErrNoRollback is returned when no previous version is kept on disk.
+var ErrNoRollback = errors.New("no previous version kept to roll back to") //nolint[gochecknoglobals]
+
+// archivedUpdate is the metadata of a kept update.
+type archivedUpdate struct {
+	Version  string
+	SHA256   string // Hex SHA-256 of uncompressed archive.
+	Verified string // How the update was verified when downloaded.
+}
+
+// rollbackPoint is the version used before the last update.
+type rollbackPoint struct {
+	Version string
+	Bundle  string `json:",omitempty"` // Copy of the macOS app bundle.
+}
+
+// archiveDir is next to the update folder which is cleared by every update.
+func (u *Updates) archiveDir() string {
+	return u.updateTempDir + "_archive"
+}
+
+func (u *Updates) archivePath(version, extension string) string {
+	return filepath.Join(u.archiveDir(), sanitizeVersion(version)+extension)
+}
+
+// readTar returns uncompressed content of the update archive.
+func readTar(tgzPath string) ([]byte, error) {
+	f, err := os.Open(tgzPath) //nolint[gosec]
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint[errcheck]
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(gz)
+}
+
+func writeTgz(tgzPath string, data []byte) error {
+	f, err := os.Create(tgzPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint[errcheck]
+
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// archiveUpdate keeps the verified update of the version and removes old
+// ones.
+func (u *Updates) archiveUpdate(version, updateTar string, report *SignatureReport) error {
+	data, err := readTar(updateTar)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(u.archiveDir(), 0750); err != nil {
+		return err
+	}
+
+	if err := writeTgz(u.archivePath(version, ".tgz"), data); err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(archivedUpdate{
+		Version:  sanitizeVersion(version),
+		SHA256:   sha256Hex(data),
+		Verified: report.Method,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(u.archivePath(version, ".json"), meta, 0600); err != nil {
+		return err
+	}
+
+	u.pruneArchive()
+	return nil
+}
+
+// loadArchivedTar returns uncompressed archive of the version if it is kept
+// and intact.
+func (u *Updates) loadArchivedTar(version string) ([]byte, error) {
+	meta, err := ioutil.ReadFile(u.archivePath(version, ".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var archived archivedUpdate
+	if err := json.Unmarshal(meta, &archived); err != nil {
+		return nil, err
+	}
+
+	data, err := readTar(u.archivePath(version, ".tgz"))
+	if err != nil {
+		return nil, err
+	}
+
+	if sha256Hex(data) != archived.SHA256 {
+		return nil, errors.New("kept update of " + version + " is corrupted")
+	}
+	return data, nil
+}
+
+// getArchivedVersions returns versions of kept updates, newest first.
+func (u *Updates) getArchivedVersions() []string {
+	paths, _ := filepath.Glob(filepath.Join(u.archiveDir(), "*.json"))
+
+	versions := []string{}
+	for _, path := range paths {
+		if name := filepath.Base(path); name != rollbackFile {
+			versions = append(versions, strings.TrimSuffix(name, ".json"))
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		newer, _ := isFirstVersionNewer(versions[i], versions[j])
+		return newer
+	})
+	return versions
+}
+
+func (u *Updates) pruneArchive() {
+	versions := u.getArchivedVersions()
+	if len(versions) <= archiveKeep {
+		return
+	}
+
+	for _, version := range versions[archiveKeep:] {
+		log.WithField("version", version).Debug("Removing kept update")
+		_ = os.Remove(u.archivePath(version, ".tgz"))
+		_ = os.Remove(u.archivePath(version, ".json"))
+	}
+}
+
+// saveRollbackPoint remembers the installed version before it is replaced
+// by the update. On macOS the app bundle is copied as it is.
+func (u *Updates) saveRollbackPoint(appPath string) error {
+	point := rollbackPoint{Version: sanitizeVersion(u.version)}
+
+	if appPath != "" {
+		point.Bundle = filepath.Join(u.archiveDir(), bundleDir)
+		if err := createBackup(appPath, point.Bundle); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(point)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(u.archiveDir(), 0750); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(u.archiveDir(), rollbackFile), data, 0600)
+}
+
+func (u *Updates) loadRollbackPoint() (*rollbackPoint, error) {
+	data, err := ioutil.ReadFile(filepath.Join(u.archiveDir(), rollbackFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoRollback
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var point rollbackPoint
+	if err := json.Unmarshal(data, &point); err != nil {
+		return nil, err
+	}
+	return &point, nil
+}
+
+// GetRollbackVersion returns the version Rollback would install or empty
+// string when there is none.
+func (u *Updates) GetRollbackVersion() string {
+	point, err := u.loadRollbackPoint()
+	if err != nil {
+		return ""
+	}
+	return point.Version
+}
+
+// Rollback installs the version used before the last update again and
+// returns it. On Windows it starts the installer of that version, so the
+// app has to quit; on macOS the app bundle is replaced and the app has to
+// restart. Linux packages are rolled back by the package manager.
+func (u *Updates) Rollback() (string, error) {
+	point, err := u.loadRollbackPoint()
+	if err != nil {
+		return "", err
+	}
+
+	log.WithField("version", point.Version).Info("Rolling back")
+
+	switch runtime.GOOS {
+	case "darwin": //nolint[goconst]
+		if point.Bundle == "" {
+			return "", ErrNoRollback
+		}
+		appPath, err := getMacAppPath()
+		if err != nil {
+			return "", err
+		}
+		if err := syncFolders(appPath, point.Bundle); err != nil {
+			return "", err
+		}
+	case "windows": //nolint[goconst]
+		if _, err := u.loadArchivedTar(point.Version); err != nil {
+			log.WithError(err).Warn("Previous version is not kept")
+			return "", ErrNoRollback
+		}
+		if err := mkdirAllClear(u.updateTempDir); err != nil {
+			return "", err
+		}
+		if err := untarToDir(u.archivePath(point.Version, ".tgz"), u.updateTempDir, nil); err != nil {
+			return "", err
+		}
+		if err := u.startInstaller(); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("rollback on " + runtime.GOOS + " is done by the package manager")
+	}
+
+	if err := os.Remove(filepath.Join(u.archiveDir(), rollbackFile)); err != nil {
+		log.WithError(err).Warn("Cannot remove rollback point")
+	}
+
+	return point.Version, nil
+}
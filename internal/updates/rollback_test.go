@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package updates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestArchive(t *testing.T, version string) (*Updates, func()) {
+	u := newTestUpdates(version)
+
+	var err error
+	u.updateTempDir, err = ioutil.TempDir("", "upgrade")
+	require.NoError(t, err)
+
+	return u, func() {
+		_ = os.RemoveAll(u.updateTempDir)
+		_ = os.RemoveAll(u.archiveDir())
+	}
+}
+
+func TestArchiveKeepsNewestUpdates(t *testing.T) {
+	u, clear := newTestArchive(t, "1.2.0")
+	defer clear()
+
+	for i, version := range []string{"1.10.0", "1.2.0", "1.9.1"} {
+		updateTar := filepath.Join(u.updateTempDir, "update.tgz")
+		require.NoError(t, writeTgz(updateTar, newTestData(1000, int64(i))))
+		require.NoError(t, u.archiveUpdate(version, updateTar, &SignatureReport{Method: VerifiedBySignature}))
+	}
+
+	require.Equal(t, []string{"1.10.0", "1.9.1"}, u.getArchivedVersions())
+
+	data, err := u.loadArchivedTar("1.10.0")
+	require.NoError(t, err)
+	require.Equal(t, newTestData(1000, 0), data)
+
+	_, err = u.loadArchivedTar("1.2.0")
+	require.True(t, os.IsNotExist(err))
+
+	// Changed archive is not used.
+	require.NoError(t, writeTgz(u.archivePath("1.9.1", ".tgz"), newTestData(1000, 7)))
+	_, err = u.loadArchivedTar("1.9.1")
+	require.Error(t, err)
+}
+
+func TestRollbackPoint(t *testing.T) {
+	u, clear := newTestArchive(t, "1.5.0 beta")
+	defer clear()
+
+	require.Equal(t, "", u.GetRollbackVersion())
+	_, err := u.Rollback()
+	require.Equal(t, ErrNoRollback, err)
+
+	require.NoError(t, u.saveRollbackPoint(""))
+	require.Equal(t, "1.5.0", u.GetRollbackVersion())
+
+	if runtime.GOOS == "linux" {
+		_, err = u.Rollback()
+		require.Error(t, err)
+		require.Equal(t, "1.5.0", u.GetRollbackVersion())
+	}
+}
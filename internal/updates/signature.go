@@ -21,9 +21,11 @@ import (
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 
 	"golang.org/x/crypto/openpgp"
@@ -39,10 +41,37 @@ var (
 	pubkeyRing = openpgp.EntityList{} //nolint[gochecknoglobals]
 )
 
+// Methods of verification of downloaded files.
+const (
+	VerifiedBySignature = "signature"
+	VerifiedByDelta     = "delta" // SHA-256 from the signed version file.
+)
+
+// SignatureReport is the result of verification of a downloaded file shown
+// to the user.
+type SignatureReport struct {
+	File        string // Name of the verified file.
+	Method      string // VerifiedBySignature or VerifiedByDelta.
+	Verified    bool
+	Fingerprint string // Of the signing key, empty for delta.
+	Signer      string // Identity of the signing key, empty for delta.
+	Err         error
+}
+
+func (r *SignatureReport) String() string {
+	if !r.Verified {
+		return fmt.Sprintf("%s: verification failed: %v", r.File, r.Err)
+	}
+	if r.Method == VerifiedByDelta {
+		return fmt.Sprintf("%s: verified by SHA-256 from signed version file", r.File)
+	}
+	return fmt.Sprintf("%s: signed by %s (%s)", r.File, r.Signer, r.Fingerprint)
+}
+
 func singAndVerify(pathToFile string) (err error) {
 	err = signFile(pathToFile)
 	if err != nil {
-		err = verifyFile(pathToFile)
+		_, err = verifyFile(pathToFile)
 	}
 	return
 }
@@ -60,28 +89,45 @@ func signFile(pathToFile string) (err error) {
 	return cmd.Run()
 }
 
-func verifyFile(pathToFile string) error {
+func verifyFile(pathToFile string) (*SignatureReport, error) {
+	report := &SignatureReport{File: filepath.Base(pathToFile), Method: VerifiedBySignature}
+
 	fileReader, err := os.Open(pathToFile) //nolint[gosec]
 	if err != nil {
-		return err
+		report.Err = err
+		return report, err
 	}
 	defer fileReader.Close() //nolint[errcheck]
 
 	signatureReader, err := os.Open(pathToFile + sigExtension) //nolint[gosec]
 	if err != nil {
-		return err
+		report.Err = err
+		return report, err
 	}
 	defer signatureReader.Close() //nolint[errcheck]
 
-	return verifyBytes(fileReader, signatureReader)
+	return verifyBytes(report.File, fileReader, signatureReader)
 }
 
-func verifyBytes(fileReader, signatureReader io.Reader) (err error) {
+func verifyBytes(name string, fileReader, signatureReader io.Reader) (report *SignatureReport, err error) {
+	report = &SignatureReport{File: name, Method: VerifiedBySignature}
+	defer func() {
+		report.Err = err
+	}()
+
 	if _, err = getPubKey(); err != nil {
-		return err
+		return report, err
 	}
 
-	_, err = openpgp.CheckDetachedSignature(pubkeyRing, fileReader, signatureReader, nil)
+	signer, err := openpgp.CheckDetachedSignature(pubkeyRing, fileReader, signatureReader, nil)
+	if err == nil && signer != nil {
+		report.Verified = true
+		report.Fingerprint = fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+		for name := range signer.Identities {
+			report.Signer = name
+			break
+		}
+	}
 	/*
 		if err != nil {
 			return err
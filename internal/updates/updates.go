@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/ProtonMail/proton-bridge/internal/bridge"
 	"github.com/ProtonMail/proton-bridge/internal/importexport"
@@ -69,6 +70,10 @@ type Updates struct {
 	linuxFileBaseName   string       // Prefix of linux package names.
 	macAppBundleName    string       // Name of Mac app file in the bundle for update procedure.
 	cachedNewerVersion  *VersionInfo // To have info about latest version even when the internet connection drops.
+
+	lock            sync.Mutex
+	channel         string
+	signatureReport *SignatureReport // Of the last downloaded version file or update.
 }
 
 // NewBridge inits Updates struct for bridge.
@@ -88,6 +93,7 @@ func NewBridge(updateTempDir string) *Updates {
 		updateFileBaseName:  "bridge_upgrade",
 		linuxFileBaseName:   "protonmail-bridge",
 		macAppBundleName:    "ProtonMail Bridge.app",
+		channel:             StableChannel,
 	}
 }
 
@@ -108,13 +114,21 @@ func NewImportExport(updateTempDir string) *Updates {
 		updateFileBaseName:  "ie/ie_upgrade",
 		linuxFileBaseName:   "ie/protonmail-import-export-app",
 		macAppBundleName:    "ProtonMail Import-Export app.app",
+		channel:             StableChannel,
 	}
 }
 
+// CreateJSONAndSign writes the signed version file for the update in deploy
+// folder. Deltas are made from updates of previous versions placed in
+// previous/<version>/ subfolders of the deploy folder.
 func (u *Updates) CreateJSONAndSign(deployDir, goos string) error {
 	versionInfo := u.getLocalVersion(goos)
 	versionInfo.Version = sanitizeVersion(versionInfo.Version)
 
+	if err := createDeltas(deployDir, &versionInfo); err != nil {
+		return err
+	}
+
 	versionFileName := filepath.Base(u.versionFileURL(goos))
 	versionFilePath := filepath.Join(deployDir, versionFileName)
 
@@ -202,23 +216,21 @@ func (u *Updates) getLocalVersion(goos string) VersionInfo {
 }
 
 func (u *Updates) getLatestVersion() (latestVersion VersionInfo, err error) {
-	version, err := downloadToBytes(u.versionFileURL(runtime.GOOS))
+	versionURL := u.versionFileURL(runtime.GOOS)
+
+	version, err := downloadToBytes(versionURL)
 	if err != nil {
-		if u.cachedNewerVersion != nil {
-			return *u.cachedNewerVersion, nil
-		}
-		return
+		return u.getCachedNewerVersion(err)
 	}
 
 	signature, err := downloadToBytes(u.signatureFileURL(runtime.GOOS))
 	if err != nil {
-		if u.cachedNewerVersion != nil {
-			return *u.cachedNewerVersion, nil
-		}
-		return
+		return u.getCachedNewerVersion(err)
 	}
 
-	if err = verifyBytes(bytes.NewReader(version), bytes.NewReader(signature)); err != nil {
+	report, err := verifyBytes(filepath.Base(versionURL), bytes.NewReader(version), bytes.NewReader(signature))
+	u.setSignatureReport(report)
+	if err != nil {
 		return
 	}
 
@@ -226,11 +238,43 @@ func (u *Updates) getLatestVersion() (latestVersion VersionInfo, err error) {
 		return
 	}
 	if localIsOld, _ := isFirstVersionNewer(latestVersion.Version, u.version); localIsOld {
+		u.lock.Lock()
 		u.cachedNewerVersion = &latestVersion
+		u.lock.Unlock()
 	}
 	return
 }
 
+// getCachedNewerVersion returns the newer version seen before or the error
+// when there is none.
+func (u *Updates) getCachedNewerVersion(err error) (VersionInfo, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.cachedNewerVersion != nil {
+		return *u.cachedNewerVersion, nil
+	}
+	return VersionInfo{}, err
+}
+
+func (u *Updates) setSignatureReport(report *SignatureReport) {
+	log.WithField("verified", report.Verified).Info("Update verification: ", report)
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.signatureReport = report
+}
+
+// GetSignatureReport returns the verification of the last downloaded version
+// file or update, nil before the first download.
+func (u *Updates) GetSignatureReport() *SignatureReport {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.signatureReport
+}
+
 func (u *Updates) landingPageURL() string {
 	return strings.Join([]string{Host, u.landingPagePath}, "/")
 }
@@ -240,7 +284,7 @@ func (u *Updates) signatureFileURL(goos string) string {
 }
 
 func (u *Updates) versionFileURL(goos string) string {
-	return strings.Join([]string{Host, DownloadPath, u.versionFileBaseName + "_" + goos + ".json"}, "/")
+	return strings.Join([]string{Host, u.downloadPath(), u.versionFileBaseName + "_" + goos + ".json"}, "/")
 }
 
 func (u *Updates) installerFileURL(goos string) string {
@@ -251,11 +295,11 @@ func (u *Updates) installerFileURL(goos string) string {
 	case "windows": //nolint[goconst]
 		installerFile = u.winInstallerFile
 	}
-	return strings.Join([]string{Host, DownloadPath, installerFile}, "/")
+	return strings.Join([]string{Host, u.downloadPath(), installerFile}, "/")
 }
 
 func (u *Updates) updateFileURL(goos string) string {
-	return strings.Join([]string{Host, DownloadPath, u.updateFileBaseName + "_" + goos + ".tgz"}, "/")
+	return strings.Join([]string{Host, u.downloadPath(), u.updateFileBaseName + "_" + goos + ".tgz"}, "/")
 }
 
 func (u *Updates) StartUpgrade(currentStatus chan<- Progress) { // nolint[funlen]
@@ -275,27 +319,22 @@ func (u *Updates) StartUpgrade(currentStatus chan<- Progress) { // nolint[funlen
 		return
 	}
 
-	// Download.
+	// Download and check signature.
 	status.UpdateDescription(InfoDownloading)
 	if status.Err = mkdirAllClear(u.updateTempDir); status.Err != nil {
 		return
 	}
-	var updateTar string
-	updateTar, status.Err = downloadWithSignature(
-		status,
-		verInfo.UpdateFile,
-		u.updateTempDir,
-	)
-	if status.Err != nil {
-		return
+	updateTar, report, err := u.downloadUpdate(status, verInfo)
+	if report != nil {
+		u.setSignatureReport(report)
+		status.Signature = report
 	}
-
-	// Check signature.
-	status.UpdateDescription(InfoVerifying)
-	status.Err = verifyFile(updateTar)
-	if status.Err != nil {
-		log.Warnf("Cannot verify update file %s: %v", updateTar, status.Err)
-		status.Err = ErrUpdateVerifyFailed
+	if err != nil {
+		log.Warnf("Cannot get verified update file %s: %v", updateTar, err)
+		status.Err = err
+		if report != nil {
+			status.Err = ErrUpdateVerifyFailed
+		}
 		return
 	}
 
@@ -306,26 +345,30 @@ func (u *Updates) StartUpgrade(currentStatus chan<- Progress) { // nolint[funlen
 		return
 	}
 
+	// Keep the update as a base of the next delta. Failure only means the
+	// next update is downloaded whole.
+	if err := u.archiveUpdate(verInfo.Version, updateTar, report); err != nil {
+		log.WithError(err).Warn("Cannot keep update")
+	}
+
 	// Run upgrade (OS specific).
 	status.UpdateDescription(InfoUpgrading)
 	switch runtime.GOOS {
 	case "windows": //nolint[goconst]
-		// Cannot use filepath.Base on windows it has different delimiter
-		split := strings.Split(u.winInstallerFile, "/")
-		installerFile := split[len(split)-1]
-		cmd := exec.Command("./" + installerFile) // nolint[gosec]
-		cmd.Dir = u.updateTempDir
-		status.Err = cmd.Start()
+		if err := u.saveRollbackPoint(""); err != nil {
+			log.WithError(err).Warn("Cannot keep previous version for rollback")
+		}
+		status.Err = u.startInstaller()
 	case "darwin": //nolint[goconst]
-		// current path is better then appDir = filepath.Join("/Applications")
-		var exePath string
-		exePath, status.Err = osext.Executable()
+		var localPath string
+		localPath, status.Err = getMacAppPath()
 		if status.Err != nil {
 			return
 		}
-		localPath := filepath.Dir(exePath)  // Macos
-		localPath = filepath.Dir(localPath) // Contents
-		localPath = filepath.Dir(localPath) // .app
+
+		if err := u.saveRollbackPoint(localPath); err != nil {
+			log.WithError(err).Warn("Cannot keep previous version for rollback")
+		}
 
 		updatePath := filepath.Join(u.updateTempDir, u.macAppBundleName)
 		log.WithField("local", localPath).
@@ -347,3 +390,48 @@ func (u *Updates) StartUpgrade(currentStatus chan<- Progress) { // nolint[funlen
 
 	status.UpdateDescription(InfoQuitApp)
 }
+
+// downloadUpdate downloads the update as a delta from the installed version
+// when possible and whole otherwise. It returns path of the update archive
+// and its verification which is nil when download failed.
+func (u *Updates) downloadUpdate(status *Progress, verInfo VersionInfo) (string, *SignatureReport, error) {
+	updateTar, report, err := u.downloadDelta(status, verInfo)
+	if err == nil {
+		return updateTar, report, nil
+	}
+	if err != errNoDelta {
+		log.WithError(err).Warn("Cannot update by delta, downloading whole update")
+		status.UpdateDescription(InfoDownloading)
+	}
+
+	if updateTar, err = downloadWithSignature(status, verInfo.UpdateFile, u.updateTempDir); err != nil {
+		return updateTar, nil, err
+	}
+
+	status.UpdateDescription(InfoVerifying)
+	report, err = verifyFile(updateTar)
+	return updateTar, report, err
+}
+
+// startInstaller starts the installer unpacked from the update on Windows.
+func (u *Updates) startInstaller() error {
+	// Cannot use filepath.Base on windows it has different delimiter
+	split := strings.Split(u.winInstallerFile, "/")
+	installerFile := split[len(split)-1]
+	cmd := exec.Command("./" + installerFile) // nolint[gosec]
+	cmd.Dir = u.updateTempDir
+	return cmd.Start()
+}
+
+// getMacAppPath returns path of the running .app bundle.
+func getMacAppPath() (string, error) {
+	// current path is better then appDir = filepath.Join("/Applications")
+	exePath, err := osext.Executable()
+	if err != nil {
+		return "", err
+	}
+	localPath := filepath.Dir(exePath)  // Macos
+	localPath = filepath.Dir(localPath) // Contents
+	localPath = filepath.Dir(localPath) // .app
+	return localPath, nil
+}
@@ -143,6 +143,12 @@ func TestGetLatestVersion(t *testing.T) {
 	version, err := updates.getLatestVersion()
 	require.NoError(t, err)
 	require.Equal(t, expectedVersion, version)
+
+	report := updates.GetSignatureReport()
+	require.True(t, report.Verified)
+	require.Equal(t, VerifiedBySignature, report.Method)
+	require.Equal(t, "current_version_"+runtime.GOOS+".json", report.File)
+	require.Equal(t, keyID, report.Fingerprint)
 }
 
 func TestStartUpgrade(t *testing.T) {
@@ -37,6 +37,8 @@ type VersionInfo struct {
 	DebFile       string `json:",omitempty"` // debian package file
 	RpmFile       string `json:",omitempty"` // red hat package file
 	PkgFile       string `json:",omitempty"` // arch PKGBUILD file
+
+	Deltas []DeltaInfo `json:",omitempty"` // deltas from older versions
 }
 
 func (info *VersionInfo) GetDownloadLink() string {
@@ -23,6 +23,7 @@ package credentials
 import (
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -33,7 +34,8 @@ import (
 const (
 	sep = "\x00"
 
-	itemLengthBridge       = 9
+	itemLengthBridge       = 10
+	itemLengthBridgeOld    = 9 // Format before app passwords.
 	itemLengthImportExport = 6 // Old format for Import-Export.
 )
 
@@ -41,8 +43,21 @@ var (
 	log = logrus.WithField("pkg", "credentials") //nolint[gochecknoglobals]
 
 	ErrWrongFormat = errors.New("malformed credentials")
+
+	ErrAppPasswordExists   = errors.New("app password with the same name already exists")
+	ErrAppPasswordNotFound = errors.New("app password not found")
 )
 
+// AppPassword is an additional named bridge password, usually one for each
+// device, which can be revoked without changing the others. Bearer token is
+// accepted only by OAUTHBEARER and XOAUTH2 mechanisms instead of the password.
+type AppPassword struct {
+	Name          string
+	Password      string
+	Timestamp     int64
+	IsBearerToken bool `json:",omitempty"`
+}
+
 type Credentials struct {
 	UserID, // Do not marshal; used as a key.
 	Name,
@@ -54,6 +69,7 @@ type Credentials struct {
 	Timestamp int64
 	IsHidden, // Deprecated.
 	IsCombinedAddressMode bool
+	AppPasswords          []AppPassword
 }
 
 func (s *Credentials) Marshal() string {
@@ -67,6 +83,7 @@ func (s *Credentials) Marshal() string {
 		"",                // 6
 		"",                // 7
 		"",                // 8
+		"",                // 9
 	}
 
 	items[6] = fmt.Sprint(s.Timestamp)
@@ -79,6 +96,12 @@ func (s *Credentials) Marshal() string {
 		items[8] = "1"
 	}
 
+	if len(s.AppPasswords) != 0 {
+		// Marshaling of plain strings cannot fail.
+		appPasswords, _ := json.Marshal(s.AppPasswords)
+		items[9] = string(appPasswords)
+	}
+
 	str := strings.Join(items, sep)
 	return base64.StdEncoding.EncodeToString([]byte(str))
 }
@@ -90,7 +113,7 @@ func (s *Credentials) Unmarshal(secret string) error {
 	}
 	items := strings.Split(string(b), sep)
 
-	if len(items) != itemLengthBridge && len(items) != itemLengthImportExport {
+	if len(items) != itemLengthBridge && len(items) != itemLengthBridgeOld && len(items) != itemLengthImportExport {
 		return ErrWrongFormat
 	}
 
@@ -100,7 +123,7 @@ func (s *Credentials) Unmarshal(secret string) error {
 	s.MailboxPassword = items[3]
 
 	switch len(items) {
-	case itemLengthBridge:
+	case itemLengthBridge, itemLengthBridgeOld:
 		s.BridgePassword = items[4]
 		s.Version = items[5]
 		if _, err = fmt.Sscan(items[6], &s.Timestamp); err != nil {
@@ -112,6 +135,12 @@ func (s *Credentials) Unmarshal(secret string) error {
 		if s.IsCombinedAddressMode = false; items[8] == "1" {
 			s.IsCombinedAddressMode = true
 		}
+		s.AppPasswords = nil
+		if len(items) == itemLengthBridge && items[9] != "" {
+			if err = json.Unmarshal([]byte(items[9]), &s.AppPasswords); err != nil {
+				return ErrWrongFormat
+			}
+		}
 
 	case itemLengthImportExport:
 		s.Version = items[4]
@@ -130,8 +159,17 @@ func (s *Credentials) EmailList() []string {
 	return strings.Split(s.Emails, ";")
 }
 
+// CheckPassword checks whether the password is the bridge password or one
+// of the app passwords.
 func (s *Credentials) CheckPassword(password string) error {
-	if subtle.ConstantTimeCompare([]byte(s.BridgePassword), []byte(password)) != 1 {
+	match := subtle.ConstantTimeCompare([]byte(s.BridgePassword), []byte(password))
+	for _, appPassword := range s.AppPasswords {
+		if !appPassword.IsBearerToken {
+			match |= subtle.ConstantTimeCompare([]byte(appPassword.Password), []byte(password))
+		}
+	}
+
+	if match != 1 {
 		log.WithFields(logrus.Fields{
 			"userID": s.UserID,
 		}).Debug("Incorrect bridge password")
@@ -141,6 +179,55 @@ func (s *Credentials) CheckPassword(password string) error {
 	return nil
 }
 
+// CheckBearerToken checks whether the token is one of the bearer tokens.
+// The bridge password and app passwords are not accepted as tokens.
+func (s *Credentials) CheckBearerToken(token string) error {
+	match := 0
+	for _, appPassword := range s.AppPasswords {
+		if appPassword.IsBearerToken {
+			match |= subtle.ConstantTimeCompare([]byte(appPassword.Password), []byte(token))
+		}
+	}
+
+	if match != 1 {
+		log.WithFields(logrus.Fields{
+			"userID": s.UserID,
+		}).Debug("Incorrect bearer token")
+
+		return fmt.Errorf("backend/credentials: incorrect token")
+	}
+	return nil
+}
+
+// AppPasswordNames returns names of app passwords in order of creation.
+func (s *Credentials) AppPasswordNames() []string {
+	names := make([]string, len(s.AppPasswords))
+	for i, appPassword := range s.AppPasswords {
+		names[i] = appPassword.Name
+	}
+	return names
+}
+
+func (s *Credentials) addAppPassword(name, password string, timestamp int64, isBearerToken bool) error {
+	for _, appPassword := range s.AppPasswords {
+		if appPassword.Name == name {
+			return ErrAppPasswordExists
+		}
+	}
+	s.AppPasswords = append(s.AppPasswords, AppPassword{Name: name, Password: password, Timestamp: timestamp, IsBearerToken: isBearerToken})
+	return nil
+}
+
+func (s *Credentials) removeAppPassword(name string) error {
+	for i, appPassword := range s.AppPasswords {
+		if appPassword.Name == name {
+			s.AppPasswords = append(s.AppPasswords[:i], s.AppPasswords[i+1:]...)
+			return nil
+		}
+	}
+	return ErrAppPasswordNotFound
+}
+
 func (s *Credentials) Logout() {
 	s.APIToken = ""
 	s.MailboxPassword = ""
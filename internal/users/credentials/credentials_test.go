@@ -65,3 +65,61 @@ func TestUnmarshallImportExport(t *testing.T) {
 	r.NoError(t, haveCredentials.Unmarshal(encoded))
 	r.Equal(t, wantCredentials, haveCredentials)
 }
+
+func TestUnmarshallBridgeWithoutAppPasswords(t *testing.T) {
+	items := strings.Split(mustDecode(t, wantCredentials.Marshal()), sep)
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Join(items[:itemLengthBridgeOld], sep)))
+
+	haveCredentials := Credentials{UserID: "1"}
+	r.NoError(t, haveCredentials.Unmarshal(encoded))
+	r.Equal(t, wantCredentials, haveCredentials)
+}
+
+func TestAppPasswords(t *testing.T) {
+	creds := wantCredentials
+	r.NoError(t, creds.addAppPassword("phone", "phone pass", 1, false))
+	r.NoError(t, creds.addAppPassword("laptop", "laptop pass", 2, false))
+	r.Equal(t, ErrAppPasswordExists, creds.addAppPassword("phone", "other pass", 3, false))
+	r.Equal(t, []string{"phone", "laptop"}, creds.AppPasswordNames())
+
+	haveCredentials := Credentials{UserID: "1"}
+	r.NoError(t, haveCredentials.Unmarshal(creds.Marshal()))
+	r.Equal(t, creds, haveCredentials)
+
+	r.NoError(t, creds.CheckPassword("bridge pass"))
+	r.NoError(t, creds.CheckPassword("phone pass"))
+	r.NoError(t, creds.CheckPassword("laptop pass"))
+	r.Error(t, creds.CheckPassword("other pass"))
+
+	r.NoError(t, creds.removeAppPassword("phone"))
+	r.Equal(t, ErrAppPasswordNotFound, creds.removeAppPassword("phone"))
+	r.Error(t, creds.CheckPassword("phone pass"))
+	r.NoError(t, creds.CheckPassword("laptop pass"))
+}
+
+func TestBearerTokens(t *testing.T) {
+	creds := wantCredentials
+	r.NoError(t, creds.addAppPassword("phone", "phone pass", 1, false))
+	r.NoError(t, creds.addAppPassword("outlook", "outlook token", 2, true))
+	r.Equal(t, []string{"phone", "outlook"}, creds.AppPasswordNames())
+
+	haveCredentials := Credentials{UserID: "1"}
+	r.NoError(t, haveCredentials.Unmarshal(creds.Marshal()))
+	r.Equal(t, creds, haveCredentials)
+
+	// Passwords and tokens are not interchangeable.
+	r.NoError(t, creds.CheckBearerToken("outlook token"))
+	r.Error(t, creds.CheckBearerToken("bridge pass"))
+	r.Error(t, creds.CheckBearerToken("phone pass"))
+	r.Error(t, creds.CheckBearerToken(""))
+	r.Error(t, creds.CheckPassword("outlook token"))
+
+	r.NoError(t, creds.removeAppPassword("outlook"))
+	r.Error(t, creds.CheckBearerToken("outlook token"))
+}
+
+func mustDecode(t *testing.T, encoded string) string {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	r.NoError(t, err)
+	return string(b)
+}
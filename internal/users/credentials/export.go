@@ -0,0 +1,151 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// exportVersion is increased when the content of the export changes in a
+// way older versions cannot import.
+const exportVersion = 1
+
+var ErrWrongExportPassphrase = errors.New("cannot decrypt credentials, wrong passphrase") //nolint[golint]
+
+// exportData is the content of the export before encryption.
+type exportData struct {
+	Version int    `json:"version"`
+	App     string `json:"app"`
+
+	// Secrets are marshalled credentials by user IDs.
+	Secrets map[string]string `json:"secrets"`
+}
+
+// Export writes credentials of all accounts, including their bridge and app
+// passwords, to w as OpenPGP message encrypted by the passphrase. It returns
+// IDs of exported users. App name is checked on import, so credentials of
+// Bridge are not imported to Import-Export and vice versa.
+func (s *Store) Export(w io.Writer, appName string, passphrase []byte) (userIDs []string, err error) {
+	if userIDs, err = s.List(); err != nil {
+		return nil, err
+	}
+
+	data := exportData{Version: exportVersion, App: appName, Secrets: map[string]string{}}
+
+	storeLocker.RLock()
+	for _, userID := range userIDs {
+		secret, err := s.secrets.Get(userID)
+		if err != nil {
+			storeLocker.RUnlock()
+			return nil, fmt.Errorf("cannot get credentials of %v: %v", userID, err)
+		}
+		data.Secrets[userID] = secret
+	}
+	storeLocker.RUnlock()
+
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := crypto.EncryptMessageWithPassword(crypto.NewPlainMessage(plain), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	armored, err := msg.GetArmored()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(w, armored); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// Import adds credentials written by Export. Accounts which already have
+// credentials are skipped unless replace is set. It returns IDs of imported
+// and skipped users.
+func (s *Store) Import(r io.Reader, appName string, passphrase []byte, replace bool) (imported, skipped []string, err error) {
+	armored, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := crypto.NewPGPMessageFromArmored(string(armored))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not an export of credentials: %v", err)
+	}
+
+	plain, err := crypto.DecryptMessageWithPassword(msg, passphrase)
+	if err != nil {
+		return nil, nil, ErrWrongExportPassphrase
+	}
+
+	var data exportData
+	if err := json.Unmarshal(plain.GetBinary(), &data); err != nil {
+		return nil, nil, err
+	}
+	if data.Version != exportVersion {
+		return nil, nil, fmt.Errorf("unsupported export version %d", data.Version)
+	}
+	if data.App != appName {
+		return nil, nil, fmt.Errorf("credentials were exported from %v, not %v", data.App, appName)
+	}
+
+	// All secrets are checked first, so broken export does not import
+	// only some of the accounts.
+	userIDs := []string{}
+	for userID, secret := range data.Secrets {
+		creds := &Credentials{UserID: userID}
+		if err := creds.Unmarshal(secret); err != nil {
+			return nil, nil, fmt.Errorf("malformed credentials of %v: %v", userID, err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	storeLocker.Lock()
+	defer storeLocker.Unlock()
+
+	if err := s.checkKeychain(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, userID := range userIDs {
+		if _, err := s.secrets.Get(userID); err == nil && !replace {
+			skipped = append(skipped, userID)
+			continue
+		}
+
+		if err := s.secrets.Put(userID, data.Secrets[userID]); err != nil {
+			return imported, skipped, fmt.Errorf("cannot save credentials of %v: %v", userID, err)
+		}
+		imported = append(imported, userID)
+	}
+
+	return imported, skipped, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package credentials
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/keychain"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFileStore returns store in the file keychain in the directory.
+func newTestFileStore(t *testing.T, dir, name string) *Store {
+	require.NoError(t, os.Setenv(keychain.KeychainFileEnv, filepath.Join(dir, name)))
+	require.NoError(t, os.Setenv(keychain.KeychainPassphraseEnv, "keychain"))
+	defer os.Unsetenv(keychain.KeychainFileEnv)       //nolint[errcheck]
+	defer os.Unsetenv(keychain.KeychainPassphraseEnv) //nolint[errcheck]
+
+	store, err := NewStore("bridge", keychain.BackendFile)
+	require.NoError(t, err)
+	return store
+}
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	source := newTestFileStore(t, dir, "source.json")
+	alice, err := source.Add("alice-id", "alice", "token", "mailbox", []string{"alice@pm.me"})
+	require.NoError(t, err)
+	_, err = source.Add("bob-id", "bob", "token", "mailbox", []string{"bob@pm.me"})
+	require.NoError(t, err)
+	appPassword, err := source.AddAppPassword("alice-id", "phone")
+	require.NoError(t, err)
+
+	var export bytes.Buffer
+	userIDs, err := source.Export(&export, "bridge", []byte("passphrase"))
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"alice-id", "bob-id"}, userIDs)
+	require.NotContains(t, export.String(), alice.BridgePassword)
+
+	target := newTestFileStore(t, dir, "target.json")
+	_, err = target.Add("bob-id", "bob", "other-token", "mailbox", []string{"bob@pm.me"})
+	require.NoError(t, err)
+
+	_, _, err = target.Import(bytes.NewReader(export.Bytes()), "bridge", []byte("wrong"), false)
+	require.Equal(t, ErrWrongExportPassphrase, err)
+
+	_, _, err = target.Import(bytes.NewReader(export.Bytes()), "importExport", []byte("passphrase"), false)
+	require.Error(t, err)
+
+	imported, skipped, err := target.Import(bytes.NewReader(export.Bytes()), "bridge", []byte("passphrase"), false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice-id"}, imported)
+	require.Equal(t, []string{"bob-id"}, skipped)
+
+	creds, err := target.Get("alice-id")
+	require.NoError(t, err)
+	require.Equal(t, alice.BridgePassword, creds.BridgePassword)
+	require.NoError(t, creds.CheckPassword(appPassword))
+
+	creds, err = target.Get("bob-id")
+	require.NoError(t, err)
+	require.Equal(t, "other-token", creds.APIToken)
+
+	imported, skipped, err = target.Import(bytes.NewReader(export.Bytes()), "bridge", []byte("passphrase"), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice-id", "bob-id"}, imported)
+	require.Empty(t, skipped)
+
+	creds, err = target.Get("bob-id")
+	require.NoError(t, err)
+	require.Equal(t, "token", creds.APIToken)
+}
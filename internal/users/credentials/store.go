@@ -34,9 +34,10 @@ type Store struct {
 	secrets *keychain.Access
 }
 
-// NewStore creates a new encrypted credentials store.
-func NewStore(appName string) (*Store, error) {
-	secrets, err := keychain.NewAccess(appName)
+// NewStore creates a new encrypted credentials store in the keychain of
+// the given backend, see keychain.NewAccess.
+func NewStore(appName, keychainBackend string) (*Store, error) {
+	secrets, err := keychain.NewAccess(appName, keychainBackend)
 	return &Store{
 		secrets: secrets,
 	}, err
@@ -101,6 +102,51 @@ func (s *Store) SwitchAddressMode(userID string) error {
 	return s.saveCredentials(credentials)
 }
 
+// AddAppPassword generates a new app password with the given name.
+func (s *Store) AddAppPassword(userID, name string) (password string, err error) {
+	return s.addAppPassword(userID, name, false)
+}
+
+// AddBearerToken generates a new bearer token for OAuth mechanisms with the
+// given name. It is revoked the same way as app passwords.
+func (s *Store) AddBearerToken(userID, name string) (token string, err error) {
+	return s.addAppPassword(userID, name, true)
+}
+
+func (s *Store) addAppPassword(userID, name string, isBearerToken bool) (password string, err error) {
+	storeLocker.Lock()
+	defer storeLocker.Unlock()
+
+	credentials, err := s.get(userID)
+	if err != nil {
+		return "", err
+	}
+
+	password = generatePassword()
+	if err = credentials.addAppPassword(name, password, time.Now().Unix(), isBearerToken); err != nil {
+		return "", err
+	}
+
+	return password, s.saveCredentials(credentials)
+}
+
+// RemoveAppPassword revokes the app password with the given name.
+func (s *Store) RemoveAppPassword(userID, name string) error {
+	storeLocker.Lock()
+	defer storeLocker.Unlock()
+
+	credentials, err := s.get(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := credentials.removeAppPassword(name); err != nil {
+		return err
+	}
+
+	return s.saveCredentials(credentials)
+}
+
 func (s *Store) UpdateEmails(userID string, emails []string) error {
 	storeLocker.Lock()
 	defer storeLocker.Unlock()
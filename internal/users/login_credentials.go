@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package users
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1" //nolint[gosec]
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	"github.com/pkg/errors"
+)
+
+// Keys of login credentials in environment variables and credentials files.
+const (
+	LoginUsernameKey        = "BRIDGE_LOGIN_USERNAME"
+	LoginPasswordKey        = "BRIDGE_LOGIN_PASSWORD"
+	LoginMailboxPasswordKey = "BRIDGE_LOGIN_MAILBOX_PASSWORD"
+	LoginTOTPKey            = "BRIDGE_LOGIN_TOTP"
+	LoginTOTPSecretKey      = "BRIDGE_LOGIN_TOTP_SECRET"
+	LoginSessionKey         = "BRIDGE_LOGIN_SESSION"
+)
+
+// totpPeriod is the number of seconds for which two factor code is valid.
+const totpPeriod = 30
+
+// LoginCredentials are credentials to log in without user interaction, e.g.
+// by scripted deployments of headless Bridge.
+type LoginCredentials struct {
+	Username        string
+	Password        string
+	MailboxPassword string // Password is used for unlocking when empty.
+	TOTP            string // Current two factor code.
+	TOTPSecret      string // Base32 secret to generate two factor code.
+	Session         string // UID and refresh token of existing session to use instead of password.
+}
+
+// ReadLoginCredentialsFromEnv reads credentials from environment variables
+// and removes them from the environment, so they are not passed to processes
+// started by Bridge.
+func ReadLoginCredentialsFromEnv() (*LoginCredentials, error) {
+	log.Warn("Login credentials in environment variables can be read by other processes of the same user, prefer a credentials file")
+
+	values := map[string]string{}
+	for _, key := range getLoginKeys() {
+		values[key] = os.Getenv(key)
+		if err := os.Unsetenv(key); err != nil {
+			return nil, err
+		}
+	}
+	return newLoginCredentials(values)
+}
+
+// ReadLoginCredentialsFile reads credentials from the file which must not be
+// accessible by other users. See ReadLoginCredentials for the format.
+func ReadLoginCredentialsFile(path string) (*LoginCredentials, error) {
+	f, err := os.Open(path) //nolint[gosec]
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint[errcheck]
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Windows does not have unix permissions, files are protected by ACL
+	// of the user profile.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, errors.Errorf("credentials file %s can be accessed by other users, allow access only to the owner (chmod 600)", path)
+	}
+
+	return ReadLoginCredentials(f)
+}
+
+// ReadLoginCredentials reads credentials in the format of environment files,
+// one `KEY=value` per line with the same keys as environment variables.
+// Empty lines and lines starting with # are ignored.
+func ReadLoginCredentials(r io.Reader) (*LoginCredentials, error) {
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		split := strings.SplitN(text, "=", 2)
+		if len(split) != 2 {
+			return nil, errors.Errorf("line %d is not KEY=value", line)
+		}
+		values[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return newLoginCredentials(values)
+}
+
+func getLoginKeys() []string {
+	return []string{LoginUsernameKey, LoginPasswordKey, LoginMailboxPasswordKey, LoginTOTPKey, LoginTOTPSecretKey, LoginSessionKey}
+}
+
+func newLoginCredentials(values map[string]string) (*LoginCredentials, error) {
+	known := map[string]bool{}
+	for _, key := range getLoginKeys() {
+		known[key] = true
+	}
+	for key := range values {
+		if !known[key] {
+			return nil, errors.Errorf("unknown key %s", key)
+		}
+	}
+
+	creds := &LoginCredentials{
+		Username:        values[LoginUsernameKey],
+		Password:        values[LoginPasswordKey],
+		MailboxPassword: values[LoginMailboxPasswordKey],
+		TOTP:            values[LoginTOTPKey],
+		TOTPSecret:      values[LoginTOTPSecretKey],
+		Session:         values[LoginSessionKey],
+	}
+
+	if creds.Username == "" {
+		return nil, errors.Errorf("%s is required", LoginUsernameKey)
+	}
+	if creds.Password == "" && creds.Session == "" {
+		return nil, errors.Errorf("%s or %s is required", LoginPasswordKey, LoginSessionKey)
+	}
+	if creds.Session != "" && creds.MailboxPassword == "" {
+		return nil, errors.Errorf("%s is required with %s", LoginMailboxPasswordKey, LoginSessionKey)
+	}
+
+	return creds, nil
+}
+
+// LoginWithCredentials logs in the user by the credentials unless the user is
+// already logged in and connected.
+func (u *Users) LoginWithCredentials(creds *LoginCredentials) (*User, error) {
+	if user, err := u.GetUser(creds.Username); err == nil && user.IsConnected() {
+		log.WithField("username", creds.Username).Info("User is already logged in")
+		return user, nil
+	}
+
+	authClient, auth, err := u.authWithCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	mailboxPassword := creds.MailboxPassword
+	if mailboxPassword == "" {
+		if auth.HasMailboxPassword() {
+			authClient.Logout()
+			return nil, errors.Errorf("account has mailbox password, %s is required", LoginMailboxPasswordKey)
+		}
+		mailboxPassword = creds.Password
+	}
+
+	return u.FinishLogin(authClient, auth, mailboxPassword)
+}
+
+// authWithCredentials authenticates by password and two factor code or by
+// refreshing the existing session.
+func (u *Users) authWithCredentials(creds *LoginCredentials) (pmapi.Client, *pmapi.Auth, error) {
+	if creds.Session != "" {
+		authClient := u.clientManager.GetAnonymousClient()
+		auth, err := authClient.AuthRefresh(creds.Session)
+		if err != nil {
+			authClient.Logout()
+			return nil, nil, errors.Wrap(err, "failed to refresh session")
+		}
+		return authClient, auth, nil
+	}
+
+	authClient, auth, err := u.Login(creds.Username, creds.Password)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to authenticate")
+	}
+
+	if auth.HasTwoFactor() {
+		code := creds.TOTP
+		if code == "" && creds.TOTPSecret != "" {
+			if code, err = generateTOTP(creds.TOTPSecret, time.Now()); err != nil {
+				authClient.Logout()
+				return nil, nil, err
+			}
+		}
+		if code == "" {
+			authClient.Logout()
+			return nil, nil, errors.Errorf("account has two factor authentication, %s or %s is required", LoginTOTPKey, LoginTOTPSecretKey)
+		}
+		if err := authClient.Auth2FA(code, auth); err != nil {
+			authClient.Logout()
+			return nil, nil, errors.Wrap(err, "failed two factor authentication")
+		}
+	}
+
+	return authClient, auth, nil
+}
+
+// generateTOTP returns two factor code for the base32 secret at the time
+// as defined by RFC6238 with default parameters used by authenticator apps.
+func generateTOTP(secret string, now time.Time) (string, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid TOTP secret")
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(now.Unix()/totpPeriod))
+
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package users
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/pmapi"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	// Test vectors of RFC6238 for SHA1 truncated to six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		2000000000: "279037",
+	} {
+		code, err := generateTOTP(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, want, code)
+	}
+
+	code, err := generateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+	require.NoError(t, err)
+	require.Equal(t, "287082", code)
+
+	_, err = generateTOTP("not base32!", time.Now())
+	require.Error(t, err)
+}
+
+func TestReadLoginCredentials(t *testing.T) {
+	creds, err := ReadLoginCredentials(strings.NewReader(`
+# Deployment credentials
+BRIDGE_LOGIN_USERNAME=user@pm.me
+BRIDGE_LOGIN_PASSWORD = pass=word
+BRIDGE_LOGIN_TOTP_SECRET=GEZDGNBVGY3TQOJQ
+`))
+	require.NoError(t, err)
+	require.Equal(t, &LoginCredentials{
+		Username:   "user@pm.me",
+		Password:   "pass=word",
+		TOTPSecret: "GEZDGNBVGY3TQOJQ",
+	}, creds)
+
+	for _, invalid := range []string{
+		"BRIDGE_LOGIN_PASSWORD=pass",
+		"BRIDGE_LOGIN_USERNAME=user",
+		"BRIDGE_LOGIN_USERNAME=user\nBRIDGE_LOGIN_SESSION=uid:token",
+		"BRIDGE_LOGIN_USERNAME=user\nBRIDGE_LOGIN_PASSWORD=pass\nBRIDGE_LOGIN_PASWORD=typo",
+		"BRIDGE_LOGIN_USERNAME user",
+	} {
+		_, err := ReadLoginCredentials(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestReadLoginCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "login")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	path := filepath.Join(dir, "credentials")
+	require.NoError(t, ioutil.WriteFile(path, []byte("BRIDGE_LOGIN_USERNAME=user\nBRIDGE_LOGIN_PASSWORD=pass\n"), 0644))
+
+	_, err = ReadLoginCredentialsFile(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.Chmod(path, 0600))
+	creds, err := ReadLoginCredentialsFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "user", creds.Username)
+}
+
+func TestUsersLoginWithCredentialsTwoFactor(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	auth := &pmapi.Auth{TwoFA: &pmapi.TwoFactorInfo{Enabled: 1}}
+	errWrongCode := errors.New("wrong code")
+
+	m.clientManager.EXPECT().GetAnonymousClient().Return(m.pmapiClient).Times(2)
+	gomock.InOrder(
+		m.credentialsStore.EXPECT().List().Return([]string{}, nil),
+
+		// Without code the login stops after authentication.
+		m.pmapiClient.EXPECT().AuthInfo("user").Return(&pmapi.AuthInfo{}, nil),
+		m.pmapiClient.EXPECT().Auth("user", "pass", &pmapi.AuthInfo{}).Return(auth, nil),
+		m.pmapiClient.EXPECT().Logout(),
+
+		// Supplied code is used for two factor authentication.
+		m.pmapiClient.EXPECT().AuthInfo("user").Return(&pmapi.AuthInfo{}, nil),
+		m.pmapiClient.EXPECT().Auth("user", "pass", &pmapi.AuthInfo{}).Return(auth, nil),
+		m.pmapiClient.EXPECT().Auth2FA("123456", auth).Return(errWrongCode),
+		m.pmapiClient.EXPECT().Logout(),
+	)
+
+	users := testNewUsers(t, m)
+	defer cleanUpUsersData(users)
+
+	_, err := users.LoginWithCredentials(&LoginCredentials{Username: "user", Password: "pass"})
+	require.Error(t, err)
+
+	_, err = users.LoginWithCredentials(&LoginCredentials{Username: "user", Password: "pass", TOTP: "123456"})
+	require.Equal(t, errWrongCode, errors.Cause(err))
+}
@@ -216,6 +216,30 @@ func (mr *MockClientManagerMockRecorder) GetClient(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetClient", reflect.TypeOf((*MockClientManager)(nil).GetClient), arg0)
 }
 
+// ResetConnections mocks base method
+func (m *MockClientManager) ResetConnections() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ResetConnections")
+}
+
+// ResetConnections indicates an expected call of ResetConnections
+func (mr *MockClientManagerMockRecorder) ResetConnections() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetConnections", reflect.TypeOf((*MockClientManager)(nil).ResetConnections))
+}
+
+// SetThrottle mocks base method
+func (m *MockClientManager) SetThrottle(arg0 int64, arg1 int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetThrottle", arg0, arg1)
+}
+
+// SetThrottle indicates an expected call of SetThrottle
+func (mr *MockClientManagerMockRecorder) SetThrottle(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetThrottle", reflect.TypeOf((*MockClientManager)(nil).SetThrottle), arg0, arg1)
+}
+
 // SetUserAgent mocks base method
 func (m *MockClientManager) SetUserAgent(arg0, arg1, arg2 string) {
 	m.ctrl.T.Helper()
@@ -266,6 +290,36 @@ func (mr *MockCredentialsStorerMockRecorder) Add(arg0, arg1, arg2, arg3, arg4 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockCredentialsStorer)(nil).Add), arg0, arg1, arg2, arg3, arg4)
 }
 
+// AddAppPassword mocks base method
+func (m *MockCredentialsStorer) AddAppPassword(arg0, arg1 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddAppPassword", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddAppPassword indicates an expected call of AddAppPassword
+func (mr *MockCredentialsStorerMockRecorder) AddAppPassword(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAppPassword", reflect.TypeOf((*MockCredentialsStorer)(nil).AddAppPassword), arg0, arg1)
+}
+
+// AddBearerToken mocks base method
+func (m *MockCredentialsStorer) AddBearerToken(arg0, arg1 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddBearerToken", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddBearerToken indicates an expected call of AddBearerToken
+func (mr *MockCredentialsStorerMockRecorder) AddBearerToken(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBearerToken", reflect.TypeOf((*MockCredentialsStorer)(nil).AddBearerToken), arg0, arg1)
+}
+
 // Delete mocks base method
 func (m *MockCredentialsStorer) Delete(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -324,6 +378,20 @@ func (mr *MockCredentialsStorerMockRecorder) Logout(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockCredentialsStorer)(nil).Logout), arg0)
 }
 
+// RemoveAppPassword mocks base method
+func (m *MockCredentialsStorer) RemoveAppPassword(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveAppPassword", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveAppPassword indicates an expected call of RemoveAppPassword
+func (mr *MockCredentialsStorerMockRecorder) RemoveAppPassword(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveAppPassword", reflect.TypeOf((*MockCredentialsStorer)(nil).RemoveAppPassword), arg0, arg1)
+}
+
 // SwitchAddressMode mocks base method
 func (m *MockCredentialsStorer) SwitchAddressMode(arg0 string) error {
 	m.ctrl.T.Helper()
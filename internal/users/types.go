@@ -38,6 +38,9 @@ type CredentialsStorer interface {
 	Add(userID, userName, apiToken, mailboxPassword string, emails []string) (*credentials.Credentials, error)
 	Get(userID string) (*credentials.Credentials, error)
 	SwitchAddressMode(userID string) error
+	AddAppPassword(userID, name string) (string, error)
+	AddBearerToken(userID, name string) (string, error)
+	RemoveAppPassword(userID, name string) error
 	UpdateEmails(userID string, emails []string) error
 	UpdatePassword(userID, password string) error
 	UpdateToken(userID, apiToken string) error
@@ -52,6 +55,8 @@ type ClientManager interface {
 	DisallowProxy()
 	GetAuthUpdateChannel() chan pmapi.ClientAuth
 	CheckConnection() error
+	ResetConnections()
+	SetThrottle(bytesPerSecond int64, parallel int)
 	SetUserAgent(clientName, clientVersion, os string)
 }
 
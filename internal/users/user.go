@@ -382,9 +382,242 @@ func (u *User) GetBridgePassword() string {
 	return u.creds.BridgePassword
 }
 
+// hasBearerToken returns whether the token is a bearer token of the user.
+func (u *User) hasBearerToken(token string) bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.creds.CheckBearerToken(token) == nil
+}
+
+// GetAppPasswordNames returns names of additional bridge passwords.
+func (u *User) GetAppPasswordNames() []string {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.creds.AppPasswordNames()
+}
+
+// AddAppPassword generates a new bridge password with the given name, usually
+// one for each device, which can be revoked without changing the others.
+func (u *User) AddAppPassword(name string) (string, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	password, err := u.credStorer.AddAppPassword(u.userID, name)
+	if err != nil {
+		return "", err
+	}
+
+	u.refreshFromCredentials()
+
+	return password, nil
+}
+
+// AddBearerToken generates a new token for OAUTHBEARER and XOAUTH2
+// mechanisms with the given name. It identifies the account, so clients do
+// not need to send the username, and it is revoked by RemoveAppPassword.
+func (u *User) AddBearerToken(name string) (string, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	token, err := u.credStorer.AddBearerToken(u.userID, name)
+	if err != nil {
+		return "", err
+	}
+
+	u.refreshFromCredentials()
+
+	return token, nil
+}
+
+// RemoveAppPassword revokes the app password with the given name. All
+// connections are closed because it is not known which password they used;
+// clients with other passwords simply log in again. Revoked password may have
+// leaked, so local keys of the store are rotated too.
+func (u *User) RemoveAppPassword(name string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.credStorer.RemoveAppPassword(u.userID, name); err != nil {
+		return err
+	}
+
+	u.refreshFromCredentials()
+	u.CloseAllConnections()
+
+	if u.store != nil {
+		if err := u.store.RotateLocalKeys(); err != nil && err != store.ErrKeyRotationInProgress {
+			u.log.WithError(err).Warn("Cannot rotate local keys")
+		}
+	}
+
+	return nil
+}
+
+// SearchMessages returns at most limit messages matching the query in the
+// local search index.
+func (u *User) SearchMessages(query string, limit int) ([]*pmapi.Message, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return nil, store.ErrSearchIndexUnavailable
+	}
+
+	return u.store.SearchMessages(query, limit)
+}
+
+// GetDiskUsage returns how much disk space the local store of the user takes.
+func (u *User) GetDiskUsage() (store.DiskUsage, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return store.DiskUsage{}, errors.New("store is not initialised")
+	}
+
+	return u.store.GetDiskUsage()
+}
+
+// Export writes messages of the mailbox, or of all mailboxes when the name
+// is empty, from the user's store to the directory in the given format.
+func (u *User) Export(dir, format, mailboxName string) (int, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return 0, errors.New("store is not initialised")
+	}
+
+	return u.store.Export(dir, format, mailboxName)
+}
+
+// GetSyncProgress returns the progress of the sync of the user's store.
+func (u *User) GetSyncProgress() store.SyncProgress {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return store.SyncProgress{Phase: store.SyncPhaseIdle}
+	}
+
+	return u.store.GetSyncProgress()
+}
+
+// GetUnreadCount returns the number of unread messages in Inbox of the user.
+func (u *User) GetUnreadCount() (uint, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return 0, errors.New("store is not initialised")
+	}
+
+	return u.store.GetUnreadCount(pmapi.InboxLabel)
+}
+
+// GetMessageMetadata returns subject, sender and other metadata of the
+// user's message from the local store.
+func (u *User) GetMessageMetadata(apiID string) (*pmapi.Message, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return nil, errors.New("store is not initialised")
+	}
+
+	return u.store.GetMessageMetadata(apiID)
+}
+
+// ClearBodyCache removes locally cached message bodies of the user.
+func (u *User) ClearBodyCache() error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.ClearBodyCache()
+}
+
+// CompactStore rewrites the local store database of the user to reclaim
+// free space and returns the number of reclaimed bytes.
+func (u *User) CompactStore() (int64, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return 0, errors.New("store is not initialised")
+	}
+
+	return u.store.Compact()
+}
+
+// RotateStoreKeys replaces the keys the local store of the user encrypts
+// cached data by and re-encrypts the data in the background.
+func (u *User) RotateStoreKeys() error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	return u.store.RotateLocalKeys()
+}
+
+// SetPollPolicy changes bounds of the event poll interval of the user's store.
+func (u *User) SetPollPolicy(policy *store.PollPolicy) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return
+	}
+
+	u.store.SetPollPolicy(policy)
+}
+
+// SetPaused pauses or resumes polling of changes of the user's account.
+func (u *User) SetPaused(pause bool) error {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if u.store == nil {
+		return errors.New("store is not initialised")
+	}
+
+	u.store.SetPaused(pause)
+	return nil
+}
+
+// IsPaused returns whether polling of changes of the user's account is paused.
+func (u *User) IsPaused() bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.store != nil && u.store.IsPaused()
+}
+
 // CheckBridgeLogin checks whether the user is logged in and the bridge
 // IMAP/SMTP password is correct.
 func (u *User) CheckBridgeLogin(password string) error {
+	return u.checkBridgeLogin(func(creds *credentials.Credentials) error {
+		return creds.CheckPassword(password)
+	})
+}
+
+// CheckBridgeToken checks whether the user is logged in and the bearer token
+// of OAuth mechanisms is correct.
+func (u *User) CheckBridgeToken(token string) error {
+	return u.checkBridgeLogin(func(creds *credentials.Credentials) error {
+		return creds.CheckBearerToken(token)
+	})
+}
+
+func (u *User) checkBridgeLogin(check func(*credentials.Credentials) error) error {
 	if isApplicationOutdated {
 		u.listener.Emit(events.UpgradeApplicationEvent, "")
 		return pmapi.ErrUpgradeApplication
@@ -395,11 +628,16 @@ func (u *User) CheckBridgeLogin(password string) error {
 
 	// True here because users should be notified by popup of auth failure.
 	if err := u.authorizeIfNecessary(true); err != nil {
-		u.log.WithError(err).Error("Failed to authorize user")
-		return err
+		// Locally cached mailbox is served while API is not reachable;
+		// changes are journaled by the store and replayed later.
+		if errors.Cause(err) != pmapi.ErrAPINotReachable || u.store == nil {
+			u.log.WithError(err).Error("Failed to authorize user")
+			return err
+		}
+		u.log.WithError(err).Warn("API is not reachable, serving offline mailbox")
 	}
 
-	return u.creds.CheckPassword(password)
+	return check(u.creds)
 }
 
 // UpdateUser updates user details from API and saves to the credentials.
@@ -543,6 +781,25 @@ func (u *User) CloseAllConnections() {
 	}
 }
 
+// reconnect authorizes the user again if it failed before because API was
+// not reachable and polls events right away.
+func (u *User) reconnect() {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	if !u.creds.IsConnected() {
+		return
+	}
+
+	if err := u.authorizeIfNecessary(false); err != nil {
+		u.log.WithError(err).Warn("Cannot authorize user after network change")
+	}
+
+	if u.store != nil {
+		u.store.PollEventsNow()
+	}
+}
+
 // CloseConnection emits closeConnection event on `address` which should close all active connection.
 func (u *User) CloseConnection(address string) {
 	u.listener.Emit(events.CloseConnectionEvent, address)
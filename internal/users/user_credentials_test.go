@@ -249,3 +249,27 @@ func TestCheckBridgeLoginBadPassword(t *testing.T) {
 	waitForEvents()
 	assert.Equal(t, "backend/credentials: incorrect password", err.Error())
 }
+
+func TestCheckBridgeLoginOffline(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	user := testNewUser(m)
+	defer cleanUpUserData(user)
+
+	gomock.InOrder(
+		m.pmapiClient.EXPECT().IsUnlocked().Return(false),
+		m.pmapiClient.EXPECT().Unlock([]byte("pass")).Return(pmapi.ErrAPINotReachable),
+		m.pmapiClient.EXPECT().IsUnlocked().Return(false),
+		m.pmapiClient.EXPECT().Unlock([]byte("pass")).Return(pmapi.ErrAPINotReachable),
+	)
+
+	// Local store is served while API is not reachable.
+	err := user.CheckBridgeLogin(testCredentials.BridgePassword)
+	waitForEvents()
+	assert.NoError(t, err)
+
+	err = user.CheckBridgeLogin("wrong!")
+	waitForEvents()
+	assert.Equal(t, "backend/credentials: incorrect password", err.Error())
+}
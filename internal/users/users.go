@@ -392,6 +392,21 @@ func (u *Users) GetUser(query string) (*User, error) {
 	return nil, errors.New("user " + query + " not found")
 }
 
+// GetUserByBearerToken returns the user with the bearer token, so OAuth
+// clients which do not send the username can log in.
+func (u *Users) GetUserByBearerToken(token string) (*User, error) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	for _, user := range u.users {
+		if user.hasBearerToken(token) {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("user with the token not found")
+}
+
 // ClearData closes all connections (to release db files and so on) and clears all data.
 func (u *Users) ClearData() error {
 	var result *multierror.Error
@@ -409,6 +424,19 @@ func (u *Users) ClearData() error {
 	return result.ErrorOrNil()
 }
 
+// CloseStores stops event loops and closes stores of all users before the
+// app quits. Progress of unfinished sync is saved after each page, so the
+// next start continues where this one stopped.
+func (u *Users) CloseStores() error {
+	var result *multierror.Error
+	for _, user := range u.GetUsers() {
+		if err := user.closeStore(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
 // DeleteUser deletes user completely; it logs user out from the API, stops any
 // active connection, deletes from credentials store and removes from the Bridge struct.
 func (u *Users) DeleteUser(userID string, clearStore bool) error {
@@ -448,11 +476,21 @@ func (u *Users) DeleteUser(userID string, clearStore bool) error {
 }
 
 // SendMetric sends a metric. We don't want to return any errors, only log them.
+// Metrics of groups which user did not opt in to are not sent.
 func (u *Users) SendMetric(m metrics.Metric) {
+	cat, act, lab := m.Get()
+	if !m.IsAllowed() {
+		log.WithFields(logrus.Fields{
+			"cat":   cat,
+			"act":   act,
+			"group": m.Group(),
+		}).Debug("Metric not sent, its group is disabled")
+		return
+	}
+
 	c := u.clientManager.GetAnonymousClient()
 	defer c.Logout()
 
-	cat, act, lab := m.Get()
 	if err := c.SendSimpleMetric(string(cat), string(act), string(lab)); err != nil {
 		log.Error("Sending metric failed: ", err)
 	}
@@ -491,6 +529,21 @@ func (u *Users) CheckConnection() error {
 	return u.clientManager.CheckConnection()
 }
 
+// NetworkChanged reconnects to API after the network changed or the computer
+// woke up. Idle and broken connections to API are closed because requests
+// would wait for TCP timeouts on them; users are authorized again if it
+// failed while offline and events are polled without waiting for next poll.
+func (u *Users) NetworkChanged() {
+	u.clientManager.ResetConnections()
+
+	for _, user := range u.GetUsers() {
+		go func(user *User) {
+			defer u.panicHandler.HandlePanic()
+			user.reconnect()
+		}(user)
+	}
+}
+
 // StopWatchers stops all goroutines.
 func (u *Users) StopWatchers() {
 	close(u.stopAll)
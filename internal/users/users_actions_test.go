@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
 	gomock "github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -71,6 +72,28 @@ func TestGetUserByEmail(t *testing.T) {
 	checkUsersGetUser(t, m, "alsouser@pm.me", 1, "")
 }
 
+func TestGetUserByBearerToken(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	m.clientManager.EXPECT().GetClient("user").Return(m.pmapiClient).MinTimes(1)
+	m.clientManager.EXPECT().GetClient("users").Return(m.pmapiClient).MinTimes(1)
+
+	users := testNewUsersWithUsers(t, m)
+	defer cleanUpUsersData(users)
+
+	creds := *users.users[1].creds
+	creds.AppPasswords = []credentials.AppPassword{{Name: "outlook", Password: "outlook token", IsBearerToken: true}}
+	users.users[1].creds = &creds
+
+	user, err := users.GetUserByBearerToken("outlook token")
+	assert.NoError(t, err)
+	assert.Equal(t, users.users[1], user)
+
+	_, err = users.GetUserByBearerToken(creds.BridgePassword)
+	assert.Error(t, err)
+}
+
 func TestDeleteUser(t *testing.T) {
 	m := initMocks(t)
 	defer m.ctrl.Finish()
@@ -86,6 +86,9 @@ func TestUsersFinishLoginNewUser(t *testing.T) {
 	m := initMocks(t)
 	defer m.ctrl.Finish()
 
+	metrics.SetEnabledGroups([]metrics.Group{metrics.GroupUsage})
+	defer metrics.SetEnabledGroups(nil)
+
 	// Basically every call client has get client manager
 	m.clientManager.EXPECT().GetClient("user").Return(m.pmapiClient).MinTimes(1)
 
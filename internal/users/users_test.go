@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/metrics"
 	"github.com/ProtonMail/proton-bridge/internal/store"
 	"github.com/ProtonMail/proton-bridge/internal/users/credentials"
 	usersmocks "github.com/ProtonMail/proton-bridge/internal/users/mocks"
@@ -183,11 +184,16 @@ func initMocks(t *testing.T) mocks {
 	// Called during clean-up.
 	m.PanicHandler.EXPECT().HandlePanic().AnyTimes()
 
+	// Store reports the progress and the end of its sync running in the
+	// background.
+	m.eventListener.EXPECT().Emit(events.SyncProgressEvent, gomock.Any()).AnyTimes()
+	m.eventListener.EXPECT().Emit(events.SyncFinishedEvent, gomock.Any()).AnyTimes()
+
 	// Set up store factory.
 	m.storeMaker.EXPECT().New(gomock.Any()).DoAndReturn(func(user store.BridgeUser) (*store.Store, error) {
 		dbFile, err := ioutil.TempFile("", "bridge-store-db-*.db")
 		require.NoError(t, err, "could not get temporary file for store db")
-		return store.New(m.PanicHandler, user, m.clientManager, m.eventListener, dbFile.Name(), m.storeCache)
+		return store.New(m.PanicHandler, user, m.clientManager, m.eventListener, dbFile.Name(), m.storeCache, store.Options{})
 	}).AnyTimes()
 	m.storeMaker.EXPECT().Remove(gomock.Any()).AnyTimes()
 
@@ -281,6 +287,70 @@ func TestClearData(t *testing.T) {
 	waitForEvents()
 }
 
+func TestCloseStores(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	m.clientManager.EXPECT().GetClient("user").Return(m.pmapiClient).MinTimes(1)
+	m.clientManager.EXPECT().GetClient("users").Return(m.pmapiClient).MinTimes(1)
+
+	users := testNewUsersWithUsers(t, m)
+	defer cleanUpUsersData(users)
+
+	require.NoError(t, users.CloseStores())
+
+	for _, user := range users.GetUsers() {
+		_, err := user.store.GetUnreadCount(pmapi.InboxLabel)
+		require.Error(t, err)
+	}
+}
+
+func TestNetworkChanged(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	m.clientManager.EXPECT().GetClient("user").Return(m.pmapiClient).MinTimes(1)
+	m.clientManager.EXPECT().GetClient("users").Return(m.pmapiClient).MinTimes(1)
+
+	users := testNewUsersWithUsers(t, m)
+	defer cleanUpUsersData(users)
+
+	for _, user := range users.GetUsers() {
+		user.isAuthorized = true
+	}
+
+	m.clientManager.EXPECT().ResetConnections()
+	m.pmapiClient.EXPECT().IsUnlocked().Return(true).Times(2)
+	m.pmapiClient.EXPECT().GetEvent(testPMAPIEvent.EventID).Return(testPMAPIEvent, nil).Times(2)
+
+	users.NetworkChanged()
+
+	waitForEvents()
+}
+
+func TestSendMetric(t *testing.T) {
+	m := initMocks(t)
+	defer m.ctrl.Finish()
+
+	users := &Users{clientManager: m.clientManager}
+	defer metrics.SetEnabledGroups(nil)
+
+	// Nothing is sent by default.
+	users.SendMetric(metrics.New(metrics.Heartbeat, metrics.Daily, metrics.NoLabel))
+
+	// Only enabled groups are sent.
+	metrics.SetEnabledGroups([]metrics.Group{metrics.GroupPerformance})
+	users.SendMetric(metrics.New(metrics.Heartbeat, metrics.Daily, metrics.NoLabel))
+	users.SendMetric(metrics.New(metrics.Crash, metrics.CrashDumps, metrics.CountLabel(1)))
+
+	gomock.InOrder(
+		m.clientManager.EXPECT().GetAnonymousClient().Return(m.pmapiClient),
+		m.pmapiClient.EXPECT().SendSimpleMetric(string(metrics.Performance), string(metrics.SyncDuration), "<1m0s"),
+		m.pmapiClient.EXPECT().Logout(),
+	)
+	users.SendMetric(metrics.New(metrics.Performance, metrics.SyncDuration, metrics.DurationLabel(time.Second)))
+}
+
 func mockEventLoopNoAction(m mocks) {
 	// Set up mocks for starting the store's event loop (in store.New).
 	// The event loop runs in another goroutine so this might happen at any time.
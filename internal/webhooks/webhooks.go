@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package webhooks posts JSON about new mail, sending and sync to URLs set
+// by preferences.WebhooksKey, e.g. for home automation or notification
+// services like ntfy or Gotify.
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/sirupsen/logrus"
+)
+
+// Names of events in the configuration and in payloads.
+const (
+	EventNewMail      = "new_mail"
+	EventSent         = "sent"
+	EventSendFailed   = "send_failed"
+	EventSyncFinished = "sync_finished"
+)
+
+const (
+	queueSize    = 100              // Payloads waiting for delivery, more are dropped.
+	postTimeout  = 10 * time.Second // Timeout of one delivery attempt.
+	postAttempts = 3                // Attempts before the payload is dropped.
+	retryDelay   = 5 * time.Second  // Delay before the next attempt, doubled with each one.
+)
+
+var log = logrus.WithField("pkg", "webhooks") //nolint[gochecknoglobals]
+
+// webhook is one item of preferences.WebhooksKey (JSON list). Events are
+// names of events posted to the URL, all of them when empty. Headers are
+// added to the request, e.g. for authorization.
+type webhook struct {
+	URL     string            `json:"url"`
+	Events  []string          `json:"events"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (h *webhook) wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON posted to webhooks. Title and Message are texts for
+// people, so the payload can be posted to Gotify as it is.
+type Payload struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	Account   string    `json:"account"`
+	MessageID string    `json:"messageId,omitempty"`
+	From      string    `json:"from,omitempty"`
+	To        []string  `json:"to,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type panicHandler interface {
+	HandlePanic()
+}
+
+type preferenceProvider interface {
+	Get(key string) string
+}
+
+type usersProvider interface {
+	GetUser(query string) (*users.User, error)
+}
+
+type delivery struct {
+	hook    webhook
+	payload *Payload
+}
+
+type notifier struct {
+	panicHandler panicHandler
+	pref         preferenceProvider
+	users        usersProvider
+	client       *http.Client
+	queue        chan delivery
+}
+
+// Serve posts payloads for events to configured webhooks. The configuration
+// is read for each event, so it is changed by reload of preferences without
+// restart. It never returns.
+func Serve(panicHandler panicHandler, eventListener listener.Listener, pref preferenceProvider, users usersProvider) {
+	n := newNotifier(panicHandler, pref, users)
+
+	go func() {
+		defer panicHandler.HandlePanic()
+		n.deliver()
+	}()
+
+	n.watchEvents(eventListener)
+}
+
+func newNotifier(panicHandler panicHandler, pref preferenceProvider, users usersProvider) *notifier {
+	return &notifier{
+		panicHandler: panicHandler,
+		pref:         pref,
+		users:        users,
+		client:       &http.Client{Timeout: postTimeout},
+		queue:        make(chan delivery, queueSize),
+	}
+}
+
+func (n *notifier) watchEvents(eventListener listener.Listener) {
+	newMessageCh := make(chan string)
+	sentCh := make(chan string)
+	failedCh := make(chan string)
+	syncCh := make(chan string)
+	eventListener.Add(events.NewMessageEvent, newMessageCh)
+	eventListener.Add(events.MessageSentEvent, sentCh)
+	eventListener.Add(events.SendFailedEvent, failedCh)
+	eventListener.Add(events.SyncFinishedEvent, syncCh)
+
+	for {
+		var payload *Payload
+		select {
+		case ids := <-newMessageCh:
+			payload = n.newMailPayload(ids)
+		case data := <-sentCh:
+			payload = n.sendPayload(EventSent, data)
+		case data := <-failedCh:
+			payload = n.sendPayload(EventSendFailed, data)
+		case userID := <-syncCh:
+			payload = n.syncPayload(userID)
+		}
+		if payload != nil {
+			n.post(payload)
+		}
+	}
+}
+
+// post queues the payload for all webhooks which want its event.
+func (n *notifier) post(payload *Payload) {
+	for _, hook := range n.webhooks() {
+		if !hook.wants(payload.Event) {
+			continue
+		}
+		select {
+		case n.queue <- delivery{hook: hook, payload: payload}:
+		default:
+			log.WithField("url", hook.URL).Warn("Too many webhooks waiting, payload is dropped")
+		}
+	}
+}
+
+func (n *notifier) webhooks() (hooks []webhook) {
+	value := n.pref.Get(preferences.WebhooksKey)
+	if value == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(value), &hooks); err != nil {
+		log.WithError(err).Warn("Cannot parse webhooks")
+		return nil
+	}
+	return hooks
+}
+
+// deliver posts queued payloads one by one, so a slow webhook delays others
+// but Bridge itself is never blocked.
+func (n *notifier) deliver() {
+	for d := range n.queue {
+		delay := retryDelay
+		for attempt := 1; ; attempt++ {
+			err := n.postOnce(d.hook, d.payload)
+			if err == nil {
+				break
+			}
+			l := log.WithError(err).WithField("url", d.hook.URL).WithField("attempt", attempt)
+			if attempt == postAttempts {
+				l.Warn("Cannot post webhook, payload is dropped")
+				break
+			}
+			l.Debug("Cannot post webhook, trying again")
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (n *notifier) postOnce(hook webhook, payload *Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// accountName returns the username of the account or its ID when the user
+// is not known anymore, e.g. right after logout.
+func (n *notifier) accountName(userID string) string {
+	if user, err := n.users.GetUser(userID); err == nil {
+		return user.Username()
+	}
+	return userID
+}
+
+// newMailPayload returns the payload for "userID:messageID" of the event.
+func (n *notifier) newMailPayload(ids string) *Payload {
+	parts := strings.SplitN(ids, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	user, err := n.users.GetUser(parts[0])
+	if err != nil {
+		return nil
+	}
+	message, err := user.GetMessageMetadata(parts[1])
+	if err != nil {
+		log.WithError(err).Warn("Cannot get new message for webhook")
+		return nil
+	}
+
+	payload := &Payload{
+		Event:     EventNewMail,
+		Time:      time.Now(),
+		Title:     "New mail",
+		Message:   message.Subject,
+		Account:   user.Username(),
+		MessageID: message.ID,
+		Subject:   message.Subject,
+	}
+	if message.Sender != nil {
+		payload.From = message.Sender.Address
+		payload.Title = "New mail from " + message.Sender.String()
+	}
+	return payload
+}
+
+func (n *notifier) sendPayload(event, data string) *Payload {
+	result, err := events.ParseSendResult(data)
+	if err != nil {
+		log.WithError(err).Warn("Cannot parse send result")
+		return nil
+	}
+
+	payload := &Payload{
+		Event:   event,
+		Time:    time.Now(),
+		Account: n.accountName(result.UserID),
+		From:    result.From,
+		To:      result.To,
+		Subject: result.Subject,
+		Error:   result.Error,
+	}
+	if event == EventSent {
+		payload.Title = "Message sent"
+		payload.Message = fmt.Sprintf("%s to %s", result.Subject, strings.Join(result.To, ", "))
+	} else {
+		payload.Title = "Sending failed"
+		payload.Message = fmt.Sprintf("%s: %s", result.Subject, result.Error)
+	}
+	return payload
+}
+
+func (n *notifier) syncPayload(userID string) *Payload {
+	account := n.accountName(userID)
+	return &Payload{
+		Event:   EventSyncFinished,
+		Time:    time.Now(),
+		Title:   "Sync finished",
+		Message: "Account " + account + " is synced",
+		Account: account,
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/internal/events"
+	"github.com/ProtonMail/proton-bridge/internal/preferences"
+	"github.com/ProtonMail/proton-bridge/internal/users"
+	"github.com/ProtonMail/proton-bridge/pkg/listener"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePanicHandler struct{}
+
+func (fakePanicHandler) HandlePanic() {}
+
+type fakePreferences map[string]string
+
+func (p fakePreferences) Get(key string) string { return p[key] }
+
+type fakeUsers struct{}
+
+func (fakeUsers) GetUser(query string) (*users.User, error) { return nil, errors.New("no user") }
+
+func TestWebhookWants(t *testing.T) {
+	all := webhook{}
+	require.True(t, all.wants(EventNewMail))
+	require.True(t, all.wants(EventSyncFinished))
+
+	failures := webhook{Events: []string{EventSendFailed}}
+	require.True(t, failures.wants(EventSendFailed))
+	require.False(t, failures.wants(EventSent))
+}
+
+func TestServePostsPayload(t *testing.T) {
+	received := make(chan *Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		payload := &Payload{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(payload))
+		select {
+		case received <- payload:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	hooks, err := json.Marshal([]webhook{
+		{URL: server.URL, Events: []string{EventSendFailed}, Headers: map[string]string{"Authorization": "Bearer secret"}},
+	})
+	require.NoError(t, err)
+	pref := fakePreferences{preferences.WebhooksKey: string(hooks)}
+
+	eventListener := listener.New()
+	go Serve(fakePanicHandler{}, eventListener, pref, fakeUsers{})
+
+	result := events.SendResult{UserID: "userID", From: "jane@pm.me", To: []string{"bob@example.com"}, Subject: "Hello", Error: "no key"}
+	var payload *Payload
+	require.Eventually(t, func() bool {
+		// Not wanted event must not be posted, only the failure.
+		eventListener.Emit(events.MessageSentEvent, result.String())
+		eventListener.Emit(events.SendFailedEvent, result.String())
+		select {
+		case payload = <-received:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, EventSendFailed, payload.Event)
+	require.Equal(t, "Sending failed", payload.Title)
+	require.Equal(t, "Hello: no key", payload.Message)
+	require.Equal(t, "userID", payload.Account)
+	require.Equal(t, []string{"bob@example.com"}, payload.To)
+}
+
+func TestPostOnceFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	n := newNotifier(fakePanicHandler{}, fakePreferences{}, fakeUsers{})
+	require.Error(t, n.postOnce(webhook{URL: server.URL}, n.syncPayload("userID")))
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package changes implements the append-only journal of mailbox changes which
+// Bridge writes next to each store, so other tools can react to changes of
+// mailboxes without polling IMAP.
+//
+// The journal is a file with one JSON encoded Change per line. When it grows
+// over MaxSize, it is moved to the path with ".1" suffix and a new file is
+// started, so only the last two generations are kept.
+package changes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of changes.
+const (
+	Added   = "added"   // Message was added to the label.
+	Removed = "removed" // Message was removed from the label or deleted.
+	Flags   = "flags"   // IMAP flags of the message in the label changed.
+)
+
+// MaxSize is the size of the journal file after which it is rotated.
+const MaxSize = 10 * 1024 * 1024
+
+// tailInterval is how often Tail checks the journal for new changes.
+const tailInterval = time.Second
+
+// Change is one change of a message in a label.
+type Change struct {
+	Seq       uint64 // Increasing number of the change in the journal.
+	Time      int64  // Unix time of the change.
+	Type      string
+	AddressID string `json:",omitempty"`
+	LabelID   string
+	MessageID string
+	Flags     []string `json:",omitempty"` // IMAP flags after the change.
+}
+
+// Filter selects changes returned by Read and Tail. Empty fields match all.
+type Filter struct {
+	Since   uint64 // Only changes with higher Seq are matched.
+	LabelID string
+	Type    string
+}
+
+func (f *Filter) match(change *Change) bool {
+	return change.Seq > f.Since &&
+		(f.LabelID == "" || f.LabelID == change.LabelID) &&
+		(f.Type == "" || f.Type == change.Type)
+}
+
+// getRotatedPath returns the path of the previous generation of the journal.
+func getRotatedPath(path string) string {
+	return path + ".1"
+}
+
+// Writer appends changes to the journal. It is safe for concurrent use.
+type Writer struct {
+	path    string
+	maxSize int64
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+	seq     uint64
+}
+
+// OpenWriter opens the journal on the path for appending. Numbering continues
+// after the last change in the journal.
+func OpenWriter(path string) (*Writer, error) {
+	w := &Writer{path: path, maxSize: MaxSize}
+
+	for _, p := range []string{getRotatedPath(path), path} {
+		if _, err := readFile(p, 0, func(change *Change) error {
+			w.seq = change.Seq
+			return nil
+		}); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+// Append numbers the changes and writes them to the journal.
+func (w *Writer) Append(changes ...*Change) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return errors.New("journal is closed")
+	}
+
+	now := time.Now().Unix()
+	var data []byte
+	for _, change := range changes {
+		w.seq++
+		change.Seq = w.seq
+		change.Time = now
+		line, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if w.size >= w.maxSize {
+		return w.rotate()
+	}
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	if err := os.Rename(w.path, getRotatedPath(w.path)); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// Close closes the journal file.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Remove removes the journal on the path including its previous generation.
+func Remove(path string) error {
+	for _, p := range []string{getRotatedPath(path), path} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read returns changes of the journal on the path matching the filter.
+// Missing journal has no changes.
+func Read(path string, filter Filter) ([]*Change, error) {
+	changes := []*Change{}
+	for _, p := range []string{getRotatedPath(path), path} {
+		if _, err := readFile(p, 0, func(change *Change) error {
+			if filter.match(change) {
+				changes = append(changes, change)
+			}
+			return nil
+		}); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// Tail calls fn for every change of the journal on the path matching the
+// filter and then for new ones as they are written until `stop` is closed
+// or fn returns an error.
+func Tail(path string, filter Filter, stop <-chan struct{}, fn func(*Change) error) error {
+	emit := func(change *Change) error {
+		if !filter.match(change) {
+			return nil
+		}
+		filter.Since = change.Seq
+		return fn(change)
+	}
+
+	if _, err := readFile(getRotatedPath(path), 0, emit); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var offset int64
+	var last os.FileInfo
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			offset, last = 0, nil
+		case err != nil:
+			return err
+		default:
+			// The journal was rotated, the rest of the old file is the
+			// previous generation now.
+			if last != nil && !os.SameFile(last, info) {
+				if _, err := readFile(getRotatedPath(path), offset, emit); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				offset = 0
+			}
+			last = info
+			if offset, err = readFile(path, offset, emit); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(tailInterval):
+		}
+	}
+}
+
+// readFile calls fn for every complete change in the file from the offset and
+// returns the offset after the last one.
+func readFile(path string, offset int64, fn func(*Change) error) (int64, error) {
+	file, err := os.Open(path) //nolint[gosec]
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close() //nolint[errcheck]
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Partially written line is read next time.
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+
+		change := &Change{}
+		if err := json.Unmarshal(line, change); err != nil {
+			return offset, errors.Wrap(err, "malformed change")
+		}
+		if err := fn(change); err != nil {
+			return offset, err
+		}
+	}
+}
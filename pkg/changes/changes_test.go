@@ -0,0 +1,146 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package changes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestJournal(t *testing.T) (path string, clear func()) {
+	dir, err := ioutil.TempDir("", "changes")
+	require.NoError(t, err)
+	return filepath.Join(dir, "changes.jsonl"), func() { _ = os.RemoveAll(dir) }
+}
+
+func getSeqs(changes []*Change) (seqs []uint64) {
+	for _, change := range changes {
+		seqs = append(seqs, change.Seq)
+	}
+	return
+}
+
+func TestReadFilter(t *testing.T) {
+	path, clear := newTestJournal(t)
+	defer clear()
+
+	w, err := OpenWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(
+		&Change{Type: Added, LabelID: "0", MessageID: "msg1"},
+		&Change{Type: Added, LabelID: "5", MessageID: "msg1"},
+		&Change{Type: Flags, LabelID: "0", MessageID: "msg1", Flags: []string{`\Seen`}},
+		&Change{Type: Removed, LabelID: "0", MessageID: "msg1"},
+	))
+	require.NoError(t, w.Close())
+
+	changes, err := Read(path, Filter{})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3, 4}, getSeqs(changes))
+	require.Equal(t, []string{`\Seen`}, changes[2].Flags)
+
+	changes, err = Read(path, Filter{Since: 1, LabelID: "0"})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3, 4}, getSeqs(changes))
+
+	changes, err = Read(path, Filter{Type: Removed})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4}, getSeqs(changes))
+
+	changes, err = Read(path+"-missing", Filter{})
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func TestWriterContinuesAfterRotation(t *testing.T) {
+	path, clear := newTestJournal(t)
+	defer clear()
+
+	w, err := OpenWriter(path)
+	require.NoError(t, err)
+	w.maxSize = 1
+	require.NoError(t, w.Append(&Change{Type: Added, LabelID: "0", MessageID: "msg1"}))
+	require.NoError(t, w.Append(&Change{Type: Added, LabelID: "0", MessageID: "msg2"}))
+	require.NoError(t, w.Close())
+
+	// Only the last two generations are kept.
+	changes, err := Read(path, Filter{})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2}, getSeqs(changes))
+
+	w, err = OpenWriter(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Append(&Change{Type: Removed, LabelID: "0", MessageID: "msg2"}))
+	require.NoError(t, w.Close())
+
+	changes, err = Read(path, Filter{})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2, 3}, getSeqs(changes))
+
+	require.NoError(t, Remove(path))
+	changes, err = Read(path, Filter{})
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func TestTail(t *testing.T) {
+	path, clear := newTestJournal(t)
+	defer clear()
+
+	w, err := OpenWriter(path)
+	require.NoError(t, err)
+	defer w.Close() //nolint[errcheck]
+	require.NoError(t, w.Append(&Change{Type: Added, LabelID: "0", MessageID: "msg1"}))
+
+	stop := make(chan struct{})
+	tailed := make(chan *Change)
+	done := make(chan error)
+	go func() {
+		done <- Tail(path, Filter{LabelID: "0"}, stop, func(change *Change) error {
+			tailed <- change
+			return nil
+		})
+	}()
+
+	require.Equal(t, "msg1", (<-tailed).MessageID)
+
+	w.maxSize = 1
+	require.NoError(t, w.Append(
+		&Change{Type: Added, LabelID: "5", MessageID: "msg2"},
+		&Change{Type: Added, LabelID: "0", MessageID: "msg3"},
+	))
+	w.maxSize = MaxSize
+	require.NoError(t, w.Append(&Change{Type: Removed, LabelID: "0", MessageID: "msg3"}))
+
+	for _, want := range []uint64{3, 4} {
+		select {
+		case change := <-tailed:
+			require.Equal(t, want, change.Seq)
+		case <-time.After(5 * time.Second):
+			t.Fatal("change was not tailed")
+		}
+	}
+
+	close(stop)
+	require.NoError(t, <-done)
+}
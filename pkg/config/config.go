@@ -18,12 +18,12 @@
 package config
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 
-	"github.com/ProtonMail/go-appdir"
 	"github.com/hashicorp/go-multierror"
 	"github.com/sirupsen/logrus"
 )
@@ -45,6 +45,8 @@ type Config struct {
 	cacheVersion   string
 	appDirs        appDirProvider
 	appDirsVersion appDirProvider
+
+	crashSummary func(io.Writer) error
 }
 
 // New returns fully initialized config struct.
@@ -52,9 +54,9 @@ type Config struct {
 // as `AppVersion` which is converted to CamelCase.
 // `version` is the version of the app (e.g. v1.2.3).
 // `cacheVersion` is the version of the cache files (setting a different number will remove the old ones).
+// Folders are selected by the profile, see SetProfile.
 func New(appName, version, revision, cacheVersion string) *Config {
-	appDirs := appdir.New(filepath.Join("protonmail", appName))
-	appDirsVersion := appdir.New(filepath.Join("protonmail", appName, cacheVersion))
+	appDirs, appDirsVersion := getAppDirs(appName, cacheVersion)
 	return newConfig(appName, version, revision, cacheVersion, appDirs, appDirsVersion)
 }
 
@@ -114,6 +116,7 @@ func (c *Config) ClearOldData() error {
 			filePath != c.GetLogDir() &&
 			filePath != c.GetTLSCertPath() &&
 			filePath != c.GetTLSKeyPath() &&
+			filePath != c.GetTLSExceptionsPath() &&
 			filePath != c.GetEventsPath() &&
 			filePath != c.GetIMAPCachePath() &&
 			filePath != c.GetLockPath() &&
@@ -214,6 +217,18 @@ func (c *Config) GetIMAPCachePath() string {
 	return filepath.Join(c.appDirsVersion.UserCache(), "user_info.json")
 }
 
+// GetIMAPSpoolDir returns folder for temporary files of messages being
+// appended or fetched through IMAP.
+func (c *Config) GetIMAPSpoolDir() string {
+	return filepath.Join(c.appDirsVersion.UserCache(), "imap_spool")
+}
+
+// GetSMTPQueueDir returns folder for encrypted messages waiting for another
+// attempt to send them.
+func (c *Config) GetSMTPQueueDir() string {
+	return filepath.Join(c.appDirsVersion.UserCache(), "smtp_queue")
+}
+
 // GetLockPath returns path to lock file to check if bridge is already running.
 func (c *Config) GetLockPath() string {
 	return filepath.Join(c.appDirsVersion.UserCache(), c.appName+".lock")
@@ -229,6 +244,18 @@ func (c *Config) GetPreferencesPath() string {
 	return filepath.Join(c.appDirsVersion.UserCache(), "prefs.json")
 }
 
+// GetConfigFilePath returns path to configuration file edited by the user.
+// It is not in the versioned cache folder so it survives cache upgrades.
+func (c *Config) GetConfigFilePath() string {
+	return filepath.Join(c.appDirs.UserConfig(), "config.yaml")
+}
+
+// GetAPITokenPath returns path to file with token protecting control
+// endpoints of the local API. It is kept next to the TLS certificate.
+func (c *Config) GetAPITokenPath() string {
+	return filepath.Join(c.appDirs.UserConfig(), "api_token")
+}
+
 // GetTransferDir returns folder for import-export rules files.
 func (c *Config) GetTransferDir() string {
 	return c.appDirsVersion.UserCache()
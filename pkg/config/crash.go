@@ -0,0 +1,171 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sentry"
+)
+
+// Only the end of the current log is included in the crash dump to keep it
+// small enough for bug report attachments.
+const maxCrashDumpLogTail = 512 * 1024
+
+var crashDumpRgx = regexp.MustCompile("^v.*_crash_.*\\.zip$") //nolint[gochecknoglobals]
+
+// SetCrashSummary sets the function writing the summary of configuration
+// into crash dumps. It should not write anything identifying the user.
+func (c *Config) SetCrashSummary(summary func(io.Writer) error) {
+	c.crashSummary = summary
+}
+
+// WriteCrashDump writes the crash dump bundle to the log folder and returns
+// its path. The bundle contains stack traces, the redacted end of the current
+// log and the summary of configuration so users can attach it to bug reports.
+func WriteCrashDump(cfg *Config, output string) (string, error) {
+	about := fmt.Sprintf(
+		"Version: %s\nRevision: %s\nOS: %s/%s\nGo: %s\nCrash reports: %s\n\n",
+		cfg.version, cfg.revision, runtime.GOOS, runtime.GOARCH, runtime.Version(), sentry.GetReportLevel(),
+	)
+
+	summary := func(w io.Writer) error {
+		if _, err := io.WriteString(w, about); err != nil {
+			return err
+		}
+		if cfg.crashSummary == nil {
+			return nil
+		}
+		return cfg.crashSummary(w)
+	}
+
+	return writeCrashDump(cfg.GetLogDir(), cfg.GetLogPrefix(), output, summary)
+}
+
+// CountCrashDumps returns the number of crash dumps written after since.
+func (c *Config) CountCrashDumps(since time.Time) int {
+	return countCrashDumps(c.GetLogDir(), since)
+}
+
+func countCrashDumps(logDir string, since time.Time) (count int) {
+	files, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return 0
+	}
+
+	for _, file := range files {
+		if crashDumpRgx.MatchString(file.Name()) && file.ModTime().After(since) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func writeCrashDump(logDir, logPrefix, output string, summary func(io.Writer) error) (path string, err error) {
+	stack := bytes.NewBufferString(output + "\n\n")
+	_ = pprof.Lookup("goroutine").WriteTo(stack, 2)
+
+	var logTail []byte
+	if logFile != nil {
+		if logTail, err = readFileTail(logFile.Name(), maxCrashDumpLogTail); err != nil {
+			logTail = []byte("Cannot read log: " + err.Error())
+		}
+	}
+
+	config := &bytes.Buffer{}
+	if err := summary(config); err != nil {
+		_, _ = fmt.Fprintf(config, "\nCannot write summary: %v\n", err)
+	}
+
+	path = filepath.Join(logDir, logPrefix+"_crash_"+strconv.FormatInt(time.Now().Unix(), 10)+".zip")
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	zw := zip.NewWriter(f)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{"stack.txt", []byte(sentry.Redact(stack.String()))},
+		{"log.txt", []byte(sentry.Redact(string(logTail)))},
+		{"config.txt", config.Bytes()},
+	} {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			return "", err
+		}
+	}
+
+	return path, zw.Close()
+}
+
+// readFileTail returns at most limit bytes from the end of the file starting
+// at the beginning of a line.
+func readFileTail(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path) //nolint[gosec]
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint[errcheck]
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := stat.Size() - limit
+	if offset < 0 {
+		offset = 0
+	}
+
+	data := make([]byte, stat.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCrashDump(t *testing.T) {
+	dir := beforeEachCreateTestDir(t, "crashDump")
+
+	logPath := filepath.Join(dir, "v1.0.0_abc_1.log")
+	require.NoError(t, ioutil.WriteFile(logPath, []byte("start\nlogin john@example.com\n"), 0600))
+	var err error
+	logFile, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	defer closeLogFile()
+
+	path, err := writeCrashDump(dir, "v1.0.0_abc", "Recover: boom", func(w io.Writer) error {
+		_, err := io.WriteString(w, "imap.port: 1143\n")
+		return err
+	})
+	require.NoError(t, err)
+	require.True(t, crashDumpRgx.MatchString(filepath.Base(path)))
+
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer r.Close() //nolint[errcheck]
+
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	require.True(t, strings.HasPrefix(files["stack.txt"], "Recover: boom\n\ngoroutine"))
+	require.Equal(t, "start\nlogin [email]\n", files["log.txt"])
+	require.Equal(t, "imap.port: 1143\n", files["config.txt"])
+}
+
+func TestCountCrashDumps(t *testing.T) {
+	dir := beforeEachCreateTestDir(t, "countCrashDumps")
+
+	for _, name := range []string{"v1.0.0_abc_crash_1.zip", "v1.0.0_abc_crash_2.zip", "v1.0.0_abc_1.log"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0600))
+	}
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "v1.0.0_abc_crash_1.zip"), old, old))
+
+	require.Equal(t, 2, countCrashDumps(dir, old.Add(-time.Minute)))
+	require.Equal(t, 1, countCrashDumps(dir, old.Add(time.Minute)))
+	require.Equal(t, 0, countCrashDumps(filepath.Join(dir, "missing"), old))
+}
+
+func TestReadFileTail(t *testing.T) {
+	dir := beforeEachCreateTestDir(t, "fileTail")
+	path := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first\nsecond\nthird\n"), 0600))
+
+	tail, err := readFileTail(path, 10)
+	require.NoError(t, err)
+	require.Equal(t, "third\n", string(tail))
+
+	tail, err = readFileTail(path, 100)
+	require.NoError(t, err)
+	require.Equal(t, "first\nsecond\nthird\n", string(tail))
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of log output.
+const (
+	// LogFormatDefault writes JSON to the log file and text to the console.
+	LogFormatDefault = ""
+	LogFormatText    = "text"
+	LogFormatJSON    = "json"
+)
+
+// logSubsystems have their own log level. Subsystem of the entry is given by
+// its `pkg` field up to the first slash or dash, e.g. `imap/server` or
+// `pmapi-manager`.
+var logSubsystems = []string{"pmapi", "imap", "smtp", "store", "frontend"} //nolint[gochecknoglobals]
+
+// logLevels is the formatter of all logrus entries; it drops entries under
+// the level of their subsystem before formatting by the chosen format.
+var logLevels = newLevelFormatter() //nolint[gochecknoglobals]
+
+type levelFormatter struct {
+	lock sync.RWMutex
+
+	formatter  logrus.Formatter
+	toFile     bool
+	level      logrus.Level
+	subsystems map[string]logrus.Level
+}
+
+func newLevelFormatter() *levelFormatter {
+	return &levelFormatter{
+		formatter:  newLogFormatter(LogFormatDefault, false),
+		level:      logrus.InfoLevel,
+		subsystems: map[string]logrus.Level{},
+	}
+}
+
+// GetLogSubsystems returns names of subsystems which can have their own
+// log level.
+func GetLogSubsystems() []string {
+	return append([]string{}, logSubsystems...)
+}
+
+// GetLogLevel returns the general log level used by entries of subsystems
+// without their own level.
+func GetLogLevel() logrus.Level {
+	logLevels.lock.RLock()
+	defer logLevels.lock.RUnlock()
+
+	return logLevels.level
+}
+
+// SetLogLevels sets the general log level and levels of subsystems. Logrus
+// itself is set to the most verbose of them so entries of every subsystem
+// reach the formatter.
+func SetLogLevels(level logrus.Level, subsystems map[string]logrus.Level) {
+	logLevels.lock.Lock()
+	defer logLevels.lock.Unlock()
+
+	logLevels.level = level
+	logLevels.subsystems = map[string]logrus.Level{}
+
+	maxLevel := level
+	for subsystem, subsystemLevel := range subsystems {
+		logLevels.subsystems[subsystem] = subsystemLevel
+		if subsystemLevel > maxLevel {
+			maxLevel = subsystemLevel
+		}
+	}
+
+	logrus.SetLevel(maxLevel)
+}
+
+// SetLogFormat changes the format of log output, see LogFormat constants.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatDefault, LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	logLevels.lock.Lock()
+	defer logLevels.lock.Unlock()
+
+	logLevels.formatter = newLogFormatter(format, logLevels.toFile)
+	return nil
+}
+
+// setLogOutput installs the formatter for output to a file or console.
+func setLogOutput(toFile bool) {
+	logLevels.lock.Lock()
+	logLevels.toFile = toFile
+	logLevels.formatter = newLogFormatter(LogFormatDefault, toFile)
+	logLevels.lock.Unlock()
+
+	logrus.SetFormatter(logLevels)
+}
+
+func newLogFormatter(format string, toFile bool) logrus.Formatter {
+	if format == LogFormatJSON || (format == LogFormatDefault && toFile) {
+		return &logrus.JSONFormatter{}
+	}
+
+	return &logrus.TextFormatter{
+		ForceColors:     !toFile,
+		DisableColors:   toFile,
+		FullTimestamp:   true,
+		TimestampFormat: time.StampMilli,
+	}
+}
+
+// Format makes the levelFormatter implement logrus.Formatter. Dropped
+// entries are formatted as nothing.
+func (f *levelFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if entry.Level > f.levelOf(entry) {
+		return nil, nil
+	}
+
+	return f.formatter.Format(entry)
+}
+
+func (f *levelFormatter) levelOf(entry *logrus.Entry) logrus.Level {
+	pkg, ok := entry.Data["pkg"].(string)
+	if !ok {
+		return f.level
+	}
+
+	if i := strings.IndexAny(pkg, "/-"); i >= 0 {
+		pkg = pkg[:i]
+	}
+
+	if level, ok := f.subsystems[pkg]; ok {
+		return level
+	}
+
+	return f.level
+}
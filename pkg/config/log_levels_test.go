@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLevelsOfSubsystems(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+	setLogOutput(false)
+	require.NoError(t, SetLogFormat(LogFormatText))
+	defer SetLogLevels(logrus.InfoLevel, nil)
+
+	SetLogLevels(logrus.WarnLevel, map[string]logrus.Level{
+		"imap":  logrus.DebugLevel,
+		"pmapi": logrus.ErrorLevel,
+	})
+	require.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+	require.Equal(t, logrus.WarnLevel, GetLogLevel())
+
+	logrus.WithField("pkg", "imap/server").Debug("imap debug")
+	logrus.WithField("pkg", "pmapi-manager").Warn("pmapi warn")
+	logrus.WithField("pkg", "pmapi").Error("pmapi error")
+	logrus.WithField("pkg", "smtp").Info("smtp info")
+	logrus.WithField("pkg", "smtp").Warn("smtp warn")
+	logrus.Debug("general debug")
+
+	out := buf.String()
+	require.Contains(t, out, "imap debug")
+	require.NotContains(t, out, "pmapi warn")
+	require.Contains(t, out, "pmapi error")
+	require.NotContains(t, out, "smtp info")
+	require.Contains(t, out, "smtp warn")
+	require.NotContains(t, out, "general debug")
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+	setLogOutput(false)
+	SetLogLevels(logrus.InfoLevel, nil)
+
+	require.Error(t, SetLogFormat("xml"))
+	require.NoError(t, SetLogFormat(LogFormatJSON))
+	defer func() { _ = SetLogFormat(LogFormatDefault) }()
+
+	logrus.WithField("pkg", "store").Info("json message")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &entry))
+	require.Equal(t, "json message", entry["msg"])
+	require.Equal(t, "store", entry["pkg"])
+	require.Equal(t, "info", entry["level"])
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogRetention limits the space taken by log files and crash dumps.
+type LogRetention struct {
+	// MaxFileSize is the size in bytes after which a new log file is opened.
+	MaxFileSize int64
+
+	// MaxFiles is the number of kept log files, including the current one,
+	// and the number of kept crash dumps.
+	MaxFiles int
+
+	// MaxTotalSize is the size in bytes of all logs and crash dumps together,
+	// zero for no limit. The oldest are removed first.
+	MaxTotalSize int64
+
+	// MaxAge removes logs and crash dumps not written for longer time, zero
+	// for no limit.
+	MaxAge time.Duration
+}
+
+var (
+	logRetention     = DefaultLogRetention() //nolint[gochecknoglobals]
+	logRetentionLock sync.RWMutex            //nolint[gochecknoglobals]
+)
+
+// DefaultLogRetention returns the retention used until SetLogRetention is
+// called. Zendesk has a file size limit of 20MB; the last files zipped
+// for a bug report should fit under it (average file has few hundreds kB).
+func DefaultLogRetention() LogRetention {
+	return LogRetention{
+		MaxFileSize: 10 * 1024 * 1024,
+		MaxFiles:    3,
+	}
+}
+
+// SetLogRetention changes the limits of logs. They are applied by the next
+// periodic check of the log file.
+func SetLogRetention(retention LogRetention) {
+	defaults := DefaultLogRetention()
+	if retention.MaxFileSize <= 0 {
+		retention.MaxFileSize = defaults.MaxFileSize
+	}
+	if retention.MaxFiles <= 0 {
+		retention.MaxFiles = defaults.MaxFiles
+	}
+
+	logRetentionLock.Lock()
+	defer logRetentionLock.Unlock()
+
+	logRetention = retention
+}
+
+func getLogRetention() LogRetention {
+	logRetentionLock.RLock()
+	defer logRetentionLock.RUnlock()
+
+	return logRetention
+}
+
+// PruneLogs removes log files and crash dumps over the limits of retention
+// and returns paths of removed files. The newest log file is always kept as
+// it is the one which is being written.
+func PruneLogs(logDir string, retention LogRetention) ([]string, error) {
+	files, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs, crashes []os.FileInfo
+	var removed []string
+
+	for _, file := range files {
+		switch {
+		case crashDumpRgx.MatchString(file.Name()), logCrashRgx.MatchString(file.Name()):
+			crashes = append(crashes, file)
+		case logFileRgx.MatchString(file.Name()):
+			logs = append(logs, file)
+		case file.IsDir():
+			// Older versions of Bridge stored logs in subfolders for each version.
+			// That also has to be cleared and the functionality can be removed after some time.
+			removedInDir, err := PruneLogs(filepath.Join(logDir, file.Name()), retention)
+			if err != nil {
+				return removed, err
+			}
+			removed = append(removed, removedInDir...)
+		}
+	}
+
+	// Sorted by timestamp in the name: oldest first.
+	sortByName := func(files []os.FileInfo) {
+		sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	}
+	sortByName(logs)
+	sortByName(crashes)
+
+	var current os.FileInfo
+	for _, file := range logs {
+		if current == nil || !file.ModTime().Before(current.ModTime()) {
+			current = file
+		}
+	}
+
+	var kept []os.FileInfo
+	for _, files := range [][]os.FileInfo{logs, crashes} {
+		for i, file := range files {
+			if file != current && i < len(files)-retention.MaxFiles {
+				removed = appendRemovedLog(removed, logDir, file.Name())
+				continue
+			}
+			if file != current && retention.MaxAge > 0 && time.Since(file.ModTime()) > retention.MaxAge {
+				removed = appendRemovedLog(removed, logDir, file.Name())
+				continue
+			}
+			kept = append(kept, file)
+		}
+	}
+
+	if retention.MaxTotalSize > 0 {
+		var total int64
+		for _, file := range kept {
+			total += file.Size()
+		}
+
+		sort.Slice(kept, func(i, j int) bool { return kept[i].ModTime().Before(kept[j].ModTime()) })
+		for _, file := range kept {
+			if total <= retention.MaxTotalSize {
+				break
+			}
+			if file == current {
+				continue
+			}
+			removed = appendRemovedLog(removed, logDir, file.Name())
+			total -= file.Size()
+		}
+	}
+
+	return removed, nil
+}
+
+func appendRemovedLog(removed []string, logDir, filename string) []string {
+	if removeLog(logDir, filename) {
+		removed = append(removed, filepath.Join(logDir, filename))
+	}
+	return removed
+}
+
+func removeLog(logDir, filename string) bool {
+	// We need to be sure to delete only log files.
+	// Directory with logs can also contain other files.
+	if !logFileRgx.MatchString(filename) && !crashDumpRgx.MatchString(filename) {
+		return false
+	}
+	if err := os.RemoveAll(filepath.Join(logDir, filename)); err != nil {
+		log.Error("Cannot remove old logs ", err)
+		return false
+	}
+	return true
+}
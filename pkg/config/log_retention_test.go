@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createTestLogs(t *testing.T, dir string, ages map[string]time.Duration) {
+	for name, age := range ages {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, 100), 0600))
+		modTime := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+}
+
+func TestPruneLogsByAge(t *testing.T) {
+	dir := beforeEachCreateTestDir(t, "pruneByAge")
+
+	createTestLogs(t, dir, map[string]time.Duration{
+		"other.log":            60 * 24 * time.Hour,
+		"v1_10.log":            40 * 24 * time.Hour,
+		"v1_11.log":            35 * 24 * time.Hour,
+		"v1_crash_12.zip":      40 * 24 * time.Hour,
+		"v1_crash_13.zip":      time.Hour,
+		"v2_14.log":            32 * 24 * time.Hour, // The newest log is kept even when old.
+		"v2_14.log.attachment": 60 * 24 * time.Hour,
+	})
+
+	removed, err := PruneLogs(dir, LogRetention{MaxFiles: 3, MaxAge: 30 * 24 * time.Hour})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(dir, "v1_10.log"),
+		filepath.Join(dir, "v1_11.log"),
+		filepath.Join(dir, "v1_crash_12.zip"),
+	}, removed)
+
+	checkFileNames(t, dir, []string{
+		"other.log",
+		"v1_crash_13.zip",
+		"v2_14.log",
+		"v2_14.log.attachment",
+	})
+}
+
+func TestPruneLogsByTotalSize(t *testing.T) {
+	dir := beforeEachCreateTestDir(t, "pruneByTotalSize")
+
+	createTestLogs(t, dir, map[string]time.Duration{
+		"v1_10.log":       4 * time.Hour,
+		"v1_crash_11.zip": 3 * time.Hour,
+		"v1_12.log":       2 * time.Hour,
+		"v1_13.log":       time.Hour,
+	})
+
+	removed, err := PruneLogs(dir, LogRetention{MaxFiles: 10, MaxTotalSize: 250})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "v1_10.log"),
+		filepath.Join(dir, "v1_crash_11.zip"),
+	}, removed)
+
+	checkFileNames(t, dir, []string{
+		"v1_12.log",
+		"v1_13.log",
+	})
+}
+
+func TestSetLogRetentionDefaults(t *testing.T) {
+	defer SetLogRetention(DefaultLogRetention())
+
+	SetLogRetention(LogRetention{MaxTotalSize: 1024})
+	require.Equal(t, LogRetention{
+		MaxFileSize:  DefaultLogRetention().MaxFileSize,
+		MaxFiles:     DefaultLogRetention().MaxFiles,
+		MaxTotalSize: 1024,
+	}, getLogRetention())
+}
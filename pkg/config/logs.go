@@ -21,13 +21,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"runtime/pprof"
-	"sort"
 	"strconv"
 	"time"
 
@@ -40,22 +37,14 @@ type logConfiger interface {
 	GetLogPrefix() string
 }
 
-const (
-	// Zendesk now has a file size limit of 20MB. When the last N log files
-	// are zipped, it should fit under 20MB. Value in MB (average file has
-	// few hundreds kB).
-	maxLogFileSize = 10 * 1024 * 1024 //nolint[gochecknoglobals]
-	// Including the current logfile.
-	maxNumberLogFiles = 3 //nolint[gochecknoglobals]
-)
-
 // logFile is pointer to currently open file used by logrus.
 var logFile *os.File //nolint[gochecknoglobals]
 
 var logFileRgx = regexp.MustCompile("^v.*\\.log$")           //nolint[gochecknoglobals]
 var logCrashRgx = regexp.MustCompile("^v.*_crash_.*\\.log$") //nolint[gochecknoglobals]
 
-// HandlePanic reports the crash to sentry or local file when sentry fails.
+// HandlePanic reports the crash to sentry unless it is turned off and always
+// saves the local crash dump.
 func HandlePanic(cfg *Config, output string) {
 	if !cfg.IsDevMode() {
 		apiCfg := cfg.GetAPIConfig()
@@ -64,18 +53,13 @@ func HandlePanic(cfg *Config, output string) {
 		}
 	}
 
-	filename := getLogFilename(cfg.GetLogPrefix() + "_crash_")
-	filepath := filepath.Join(cfg.GetLogDir(), filename)
-	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	path, err := WriteCrashDump(cfg, output)
 	if err != nil {
-		log.Error("Cannot open file to write crash report: ", err)
+		log.Error("Cannot write crash dump: ", err)
 		return
 	}
 
-	_, _ = f.WriteString(output)
-	_ = pprof.Lookup("goroutine").WriteTo(f, 2)
-
-	log.Warn("Crash report saved to ", filepath)
+	log.Warn("Crash dump saved to ", path)
 }
 
 // GetGID returns goroutine number which can be used to distiguish logs from
@@ -95,18 +79,13 @@ func GetGID() uint64 {
 func SetupLog(cfg logConfiger, levelFlag string) (debugClient, debugServer bool) {
 	level, useFile := getLogLevelAndFile(levelFlag)
 
-	logrus.SetLevel(level)
+	SetLogLevels(level, nil)
+	setLogOutput(useFile)
 
 	if useFile {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
 		setLogFile(cfg.GetLogDir(), cfg.GetLogPrefix())
 		watchLogFileSize(cfg.GetLogDir(), cfg.GetLogPrefix())
 	} else {
-		logrus.SetFormatter(&logrus.TextFormatter{
-			ForceColors:     true,
-			FullTimestamp:   true,
-			TimestampFormat: time.StampMilli,
-		})
 		logrus.SetOutput(os.Stdout)
 	}
 
@@ -173,13 +152,14 @@ func checkLogFileSize(logDir, logPrefix string) {
 		return
 	}
 
-	if stat.Size() >= maxLogFileSize {
+	retention := getLogRetention()
+	if stat.Size() >= retention.MaxFileSize {
 		log.Warn("Current log file ", logFile.Name(), " is too big, opening new file")
 		closeLogFile()
 		setLogFile(logDir, logPrefix)
 	}
 
-	if err := clearLogs(logDir); err != nil {
+	if _, err := PruneLogs(logDir, retention); err != nil {
 		log.Error("Cannot clear logs ", err)
 	}
 }
@@ -190,60 +170,3 @@ func closeLogFile() {
 		logFile = nil
 	}
 }
-
-func clearLogs(logDir string) error {
-	files, err := ioutil.ReadDir(logDir)
-	if err != nil {
-		return err
-	}
-
-	var logsWithPrefix []string
-	var crashesWithPrefix []string
-
-	for _, file := range files {
-		if logFileRgx.MatchString(file.Name()) {
-			if logCrashRgx.MatchString(file.Name()) {
-				crashesWithPrefix = append(crashesWithPrefix, file.Name())
-			} else {
-				logsWithPrefix = append(logsWithPrefix, file.Name())
-			}
-		} else {
-			// Older versions of Bridge stored logs in subfolders for each version.
-			// That also has to be cleared and the functionality can be removed after some time.
-			if file.IsDir() {
-				if err := clearLogs(filepath.Join(logDir, file.Name())); err != nil {
-					return err
-				}
-			} else {
-				removeLog(logDir, file.Name())
-			}
-		}
-	}
-
-	removeOldLogs(logDir, logsWithPrefix)
-	removeOldLogs(logDir, crashesWithPrefix)
-	return nil
-}
-
-func removeOldLogs(logDir string, filenames []string) {
-	count := len(filenames)
-	if count <= maxNumberLogFiles {
-		return
-	}
-
-	sort.Strings(filenames) // Sorted by timestamp: oldest first.
-	for _, filename := range filenames[:count-maxNumberLogFiles] {
-		removeLog(logDir, filename)
-	}
-}
-
-func removeLog(logDir, filename string) {
-	// We need to be sure to delete only log files.
-	// Directory with logs can also contain other files.
-	if !logFileRgx.MatchString(filename) {
-		return
-	}
-	if err := os.RemoveAll(filepath.Join(logDir, filename)); err != nil {
-		log.Error("Cannot remove old logs ", err)
-	}
-}
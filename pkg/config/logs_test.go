@@ -142,7 +142,8 @@ func TestClearLogsLinux(t *testing.T) {
 	dir := beforeEachCreateTestDir(t, "clearLogs")
 
 	createTestStructureLinux(m, dir)
-	require.NoError(t, clearLogs(dir))
+	_, err := PruneLogs(dir, DefaultLogRetention())
+	require.NoError(t, err)
 	checkFileNames(t, dir, []string{
 		"cache",
 		"cache/c1",
@@ -177,7 +178,8 @@ func TestClearLogsWindows(t *testing.T) {
 	dir := beforeEachCreateTestDir(t, "clearLogs")
 
 	createTestStructureWindows(m, dir)
-	require.NoError(t, clearLogs(dir))
+	_, err := PruneLogs(dir, DefaultLogRetention())
+	require.NoError(t, err)
 	checkFileNames(t, dir, []string{
 		"cache",
 		"cache/c1",
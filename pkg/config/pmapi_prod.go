@@ -39,6 +39,28 @@ func (c *Config) GetAPIConfig() *pmapi.ClientConfig {
 	}
 }
 
+// getPMAPITLSExceptions loads the exceptions, invalid ones are skipped.
+func (c *Config) getPMAPITLSExceptions() (exceptions []pmapi.TLSException) {
+	list, err := LoadTLSExceptions(c.GetTLSExceptionsPath())
+	if err != nil {
+		log.WithError(err).Error("Cannot load TLS exceptions")
+		return nil
+	}
+
+	for _, exception := range list {
+		ca, err := exception.GetCA()
+		if err != nil {
+			log.WithError(err).WithField("fingerprint", exception.Fingerprint).Error("Skipping invalid TLS exception")
+			continue
+		}
+		log.WithField("subject", exception.Subject).
+			WithField("hosts", exception.Hosts).
+			Warn("TLS exception is active, the CA is trusted instead of pinned keys")
+		exceptions = append(exceptions, pmapi.TLSException{CA: ca, Hosts: exception.Hosts})
+	}
+	return exceptions
+}
+
 func (c *Config) GetRoundTripper(cm *pmapi.ClientManager, listener listener.Listener) http.RoundTripper {
 	// We use a TLS dialer.
 	basicDialer := pmapi.NewBasicTLSDialer()
@@ -50,8 +72,18 @@ func (c *Config) GetRoundTripper(cm *pmapi.ClientManager, listener listener.List
 	pinningDialer.SetTLSIssueNotifier(func() { listener.Emit(events.TLSCertIssue, "") })
 	pinningDialer.EnableRemoteTLSIssueReporting(c.GetAPIConfig().AppVersion, c.GetAPIConfig().UserAgent)
 
+	// CAs imported by the user are trusted for their hosts instead of the
+	// pinned keys and the user is warned whenever they are used.
+	pinningDialer.SetTLSExceptions(c.getPMAPITLSExceptions(), func(host string) {
+		listener.Emit(events.TLSExceptionUsedEvent, host)
+	})
+
 	// We wrap the pinning dialer in a layer which adds "alternative routing" feature.
 	proxyDialer := pmapi.NewProxyTLSDialer(pinningDialer, cm)
 
-	return pmapi.CreateTransportWithDialer(proxyDialer)
+	// We track the connections so they can be replaced when the network changes.
+	trackingDialer := pmapi.NewTrackingTLSDialer(proxyDialer)
+	cm.SetConnectionTracker(trackingDialer)
+
+	return pmapi.CreateTransportWithDialer(trackingDialer)
 }
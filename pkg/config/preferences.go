@@ -26,9 +26,10 @@ import (
 )
 
 type Preferences struct {
-	cache map[string]string
-	path  string
-	lock  *sync.RWMutex
+	cache     map[string]string
+	overrides map[string]string
+	path      string
+	lock      *sync.RWMutex
 }
 
 // NewPreferences returns loaded preferences.
@@ -89,9 +90,48 @@ func (p *Preferences) Get(key string) string {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
+	if value, ok := p.overrides[key]; ok {
+		return value
+	}
 	return p.cache[key]
 }
 
+// SetOverride sets the value which takes precedence over the saved one, e.g.
+// from the configuration file. Overrides are not saved.
+func (p *Preferences) SetOverride(key, value string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.overrides == nil {
+		p.overrides = map[string]string{}
+	}
+	p.overrides[key] = value
+}
+
+// SetOverrides replaces all overrides by the values at once, so values which
+// are no longer overridden fall back to the saved ones.
+func (p *Preferences) SetOverrides(values map[string]string) {
+	overrides := make(map[string]string, len(values))
+	for key, value := range values {
+		overrides[key] = value
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.overrides = overrides
+}
+
+// IsOverridden returns whether the value is set by SetOverride. Changes by
+// Set do not have any effect on such value.
+func (p *Preferences) IsOverridden(key string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	_, ok := p.overrides[key]
+	return ok
+}
+
 func (p *Preferences) GetBool(key string) bool {
 	return p.Get(key) == "true"
 }
@@ -105,6 +145,10 @@ func (p *Preferences) GetInt(key string) int {
 }
 
 func (p *Preferences) Set(key, value string) {
+	if p.IsOverridden(key) {
+		log.WithField("key", key).Warn("Preference is overridden by configuration, change has no effect")
+	}
+
 	p.lock.Lock()
 	p.cache[key] = value
 	p.lock.Unlock()
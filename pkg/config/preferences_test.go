@@ -92,6 +92,28 @@ func TestPreferencesSetBool(t *testing.T) {
 	checkSavedPreferences(t, "{\"falseBool\":\"false\",\"trueBool\":\"true\"}")
 }
 
+func TestPreferencesOverride(t *testing.T) {
+	pref := newTestPreferences(t)
+	pref.SetOverride("str", "overridden")
+	require.Equal(t, "overridden", pref.Get("str"))
+	require.True(t, pref.IsOverridden("str"))
+	require.False(t, pref.IsOverridden("int"))
+
+	pref.Set("str", "changed")
+	require.Equal(t, "overridden", pref.Get("str"))
+	checkSavedPreferences(t, "{\"bool\":\"true\",\"falseBool\":\"t\",\"int\":\"42\",\"str\":\"changed\"}")
+}
+
+func TestPreferencesSetOverrides(t *testing.T) {
+	pref := newTestPreferences(t)
+	pref.SetOverride("str", "overridden")
+
+	pref.SetOverrides(map[string]string{"int": "7"})
+	require.Equal(t, "value", pref.Get("str"))
+	require.False(t, pref.IsOverridden("str"))
+	require.Equal(t, 7, pref.GetInt("int"))
+}
+
 func newTestEmptyPreferences(t *testing.T) *Preferences {
 	require.NoError(t, os.RemoveAll(testPrefFilePath))
 	return NewPreferences(testPrefFilePath)
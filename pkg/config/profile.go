@@ -0,0 +1,165 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-appdir"
+)
+
+// PortableDirName is the folder next to the executable keeping all files of
+// portable mode unless another folder is given.
+const PortableDirName = "portable"
+
+var (
+	profileLock     sync.RWMutex //nolint[gochecknoglobals]
+	profileName     string       //nolint[gochecknoglobals]
+	profileDir      string       //nolint[gochecknoglobals]
+	profilePortable bool         //nolint[gochecknoglobals]
+
+	// Profile name is used in folder, keychain and D-Bus names.
+	profileNameRgx    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`) //nolint[gochecknoglobals]
+	profileInvalidRgx = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)          //nolint[gochecknoglobals]
+)
+
+// SetProfile selects the instance for all configs created afterwards, so
+// several instances with their own accounts, preferences and lock can run
+// side by side. Profile `name` keeps files in folders of the app suffixed by
+// the name. Non-empty `dir` keeps all files in that folder instead; the name
+// is then derived from the folder when not given. Empty both select the
+// default instance.
+func SetProfile(name, dir string) error {
+	if dir != "" {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		dir = absDir
+
+		if name == "" {
+			name = strings.Trim(profileInvalidRgx.ReplaceAllString(filepath.Base(dir), "-"), "-_")
+		}
+	}
+
+	if name != "" && !profileNameRgx.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use letters, digits, - and _ starting with a letter", name)
+	}
+
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	profileName = name
+	profileDir = dir
+	if dir == "" {
+		profilePortable = false
+	}
+	return nil
+}
+
+// GetProfile returns the name of the selected profile, empty for default.
+func GetProfile() string {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+
+	return profileName
+}
+
+// SetPortable turns portable mode on or off. In portable mode the instance
+// keeps everything in the folder of the profile and changes nothing in the
+// system, e.g. no system keychain, autostart or trusted certificate. It
+// requires the folder set by SetProfile.
+func SetPortable(portable bool) error {
+	profileLock.Lock()
+	defer profileLock.Unlock()
+
+	if portable && profileDir == "" {
+		return errors.New("portable mode needs a folder")
+	}
+	profilePortable = portable
+	return nil
+}
+
+// IsPortable returns whether portable mode is on.
+func IsPortable() bool {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+
+	return profilePortable
+}
+
+// GetPortableKeychainPath returns the path of the file keychain of portable
+// mode, empty when it is off.
+func GetPortableKeychainPath() string {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+
+	if !profilePortable {
+		return ""
+	}
+	return filepath.Join(profileDir, "config", "credentials.json")
+}
+
+// WithProfile returns the name suffixed by the selected profile, e.g. for
+// keychain so accounts of profiles are kept separately.
+func WithProfile(name string) string {
+	if profile := GetProfile(); profile != "" {
+		return name + "-" + profile
+	}
+	return name
+}
+
+// fixedAppDirs keeps all files of the instance in one folder.
+type fixedAppDirs struct {
+	config, cache, logs string
+}
+
+func (d fixedAppDirs) UserConfig() string { return d.config }
+func (d fixedAppDirs) UserCache() string  { return d.cache }
+func (d fixedAppDirs) UserLogs() string   { return d.logs }
+
+// getAppDirs returns folders of the app and of the cache version for the
+// selected profile.
+func getAppDirs(appName, cacheVersion string) (appDirs, appDirsVersion appDirProvider) {
+	profileLock.RLock()
+	defer profileLock.RUnlock()
+
+	if profileDir != "" {
+		dirs := fixedAppDirs{
+			config: filepath.Join(profileDir, "config"),
+			cache:  filepath.Join(profileDir, "cache"),
+			logs:   filepath.Join(profileDir, "logs"),
+		}
+		dirsVersion := dirs
+		dirsVersion.cache = filepath.Join(dirs.cache, cacheVersion)
+		return dirs, dirsVersion
+	}
+
+	// Profiles are next to the default folder, not inside, because old data
+	// in the default cache folder are removed by ClearOldData.
+	name := appName
+	if profileName != "" {
+		name += "-" + profileName
+	}
+	return appdir.New(filepath.Join("protonmail", name)), appdir.New(filepath.Join("protonmail", name, cacheVersion))
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfile(t *testing.T) {
+	defer func() { require.NoError(t, SetProfile("", "")) }()
+
+	def := New(testAppName, "v1", "rev123", "c2")
+
+	require.NoError(t, SetProfile("work", ""))
+	require.Equal(t, "work", GetProfile())
+	require.Equal(t, "bridge-work", WithProfile("bridge"))
+
+	work := New(testAppName, "v1", "rev123", "c2")
+	require.NotEqual(t, def.GetLockPath(), work.GetLockPath())
+	require.NotEqual(t, def.GetPreferencesPath(), work.GetPreferencesPath())
+	require.NotContains(t, work.GetLogDir(), def.GetLogDir()+string(filepath.Separator))
+
+	dir := filepath.Join(testConfigDir, "my.profile")
+	require.NoError(t, SetProfile("", dir))
+	require.Equal(t, "my-profile", GetProfile())
+
+	fixed := New(testAppName, "v1", "rev123", "c2")
+	require.Equal(t, filepath.Join(dir, "cache", "c2", "prefs.json"), fixed.GetPreferencesPath())
+	require.Equal(t, filepath.Join(dir, "config", "cert.pem"), fixed.GetTLSCertPath())
+	require.Equal(t, filepath.Join(dir, "logs"), fixed.GetLogDir())
+
+	require.False(t, IsPortable())
+	require.Empty(t, GetPortableKeychainPath())
+	require.NoError(t, SetPortable(true))
+	require.True(t, IsPortable())
+	require.Equal(t, filepath.Join(dir, "config", "credentials.json"), GetPortableKeychainPath())
+
+	require.Error(t, SetProfile("1st", ""))
+	require.Error(t, SetProfile("work/other", ""))
+
+	require.NoError(t, SetProfile("", ""))
+	require.False(t, IsPortable())
+	require.Error(t, SetPortable(true))
+	require.Equal(t, "bridge", WithProfile("bridge"))
+	require.Equal(t, def.GetLockPath(), New(testAppName, "v1", "rev123", "c2").GetLockPath())
+}
@@ -30,6 +30,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -69,7 +70,9 @@ func GetTLSConfig(cfg tlsConfiger) (tlsConfig *tls.Config, err error) {
 			return
 		}
 
-		if runtime.GOOS == "darwin" {
+		// Portable mode does not change the system, the certificate is
+		// trusted in clients by the user instead.
+		if runtime.GOOS == "darwin" && !IsPortable() {
 			if err := exec.Command( // nolint[gosec]
 				"/usr/bin/security",
 				"execute-with-privileges",
@@ -171,3 +174,93 @@ func GenerateTLSConfig(certPath, keyPath string) (tlsConfig *tls.Config, err err
 
 	return loadTLSConfig(certPath, keyPath)
 }
+
+// userCertificate keeps the user-supplied certificate and reloads it when
+// the files change, e.g. after renewal by ACME client such as certbot.
+type userCertificate struct {
+	certPath, keyPath string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetUserTLSConfig loads the user-supplied certificate and key and returns
+// a TLS config for local IMAP and SMTP listeners. The certificate is
+// reloaded whenever the files are modified.
+func GetUserTLSConfig(certPath, keyPath string) (*tls.Config, error) {
+	uc := &userCertificate{certPath: certPath, keyPath: keyPath}
+	if _, err := uc.getCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		GetCertificate: uc.getCertificate,
+	}, nil
+}
+
+func (uc *userCertificate) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	uc.lock.Lock()
+	defer uc.lock.Unlock()
+
+	modTime, err := uc.getModTime()
+	if err != nil {
+		if uc.cert != nil {
+			log.WithError(err).Warn("Cannot check user certificate, using the loaded one")
+			return uc.cert, nil
+		}
+		return nil, err
+	}
+
+	if uc.cert != nil && !modTime.After(uc.modTime) {
+		return uc.cert, nil
+	}
+
+	// Files are tried once per modification. Broken files are not loaded
+	// again with every connection, only after they change again.
+	uc.modTime = modTime
+
+	cert, err := uc.load()
+	if err != nil {
+		if uc.cert != nil {
+			log.WithError(err).Warn("Cannot reload user certificate, using the loaded one")
+			return uc.cert, nil
+		}
+		return nil, err
+	}
+
+	if time.Now().Add(31 * 24 * time.Hour).After(cert.Leaf.NotAfter) {
+		log.WithField("notAfter", cert.Leaf.NotAfter).Warn("User certificate will expire soon")
+	}
+
+	log.WithField("cert", uc.certPath).Info("User certificate loaded")
+	uc.cert = cert
+	return uc.cert, nil
+}
+
+// load returns the certificate from the files with parsed leaf.
+func (uc *userCertificate) load() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(uc.certPath, uc.keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// getModTime returns the latest modification time of certificate and key.
+func (uc *userCertificate) getModTime() (modTime time.Time, err error) {
+	for _, path := range []string{uc.certPath, uc.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
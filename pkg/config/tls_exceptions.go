@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tlsExceptionHostRgx allows host names, optionally with `*.` matching
+// subdomains. Wildcard of all hosts is not allowed, the exception has to be
+// scoped.
+var tlsExceptionHostRgx = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`) //nolint[gochecknoglobals]
+
+// TLSException is a root CA imported by the user which is trusted for API
+// connections to the hosts instead of the pinned keys, e.g. CA of
+// TLS-inspecting corporate proxy. It is independent of the system store.
+type TLSException struct {
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the certificate in hex.
+	Subject     string    `json:"subject"`
+	Hosts       []string  `json:"hosts"`
+	Certificate string    `json:"certificate"` // PEM.
+	Added       time.Time `json:"added"`
+}
+
+// GetTLSExceptionsPath returns path to the list of imported CAs.
+func (c *Config) GetTLSExceptionsPath() string {
+	return filepath.Join(c.appDirs.UserConfig(), "tls_exceptions.json")
+}
+
+// NewTLSException checks the CA certificate in PEM and the hosts and
+// returns the exception.
+func NewTLSException(certPEM []byte, hosts []string) (*TLSException, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, errors.Errorf("certificate expired on %s", cert.NotAfter.Format("2006-01-02"))
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("no host given")
+	}
+	for i, host := range hosts {
+		hosts[i] = strings.ToLower(strings.TrimSpace(host))
+		if !tlsExceptionHostRgx.MatchString(hosts[i]) {
+			return nil, errors.Errorf("invalid host %q", host)
+		}
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	return &TLSException{
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Subject:     cert.Subject.String(),
+		Hosts:       hosts,
+		Certificate: string(pem.EncodeToMemory(block)),
+		Added:       time.Now(),
+	}, nil
+}
+
+// GetCA returns the parsed certificate of the exception.
+func (e *TLSException) GetCA() (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(e.Certificate))
+	if block == nil {
+		return nil, errors.Errorf("exception %s has no certificate", e.Fingerprint)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// LoadTLSExceptions reads the list of exceptions. Missing file means no
+// exceptions.
+func LoadTLSExceptions(path string) ([]*TLSException, error) {
+	data, err := ioutil.ReadFile(path) //nolint[gosec]
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var exceptions []*TLSException
+	if err := json.Unmarshal(data, &exceptions); err != nil {
+		return nil, errors.Wrap(err, "invalid list of TLS exceptions")
+	}
+	return exceptions, nil
+}
+
+// SaveTLSExceptions writes the list of exceptions, the file is removed when
+// the list is empty.
+func SaveTLSExceptions(path string, exceptions []*TLSException) error {
+	if len(exceptions) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(exceptions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
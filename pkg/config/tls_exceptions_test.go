@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCertPEM(t *testing.T, isCA bool) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Corporate CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestNewTLSException(t *testing.T) {
+	caPEM := newTestCertPEM(t, true)
+
+	exception, err := NewTLSException(caPEM, []string{"API.protonmail.ch", "*.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"api.protonmail.ch", "*.example.com"}, exception.Hosts)
+	require.Equal(t, "CN=Corporate CA", exception.Subject)
+	require.Len(t, exception.Fingerprint, 64)
+
+	ca, err := exception.GetCA()
+	require.NoError(t, err)
+	require.Equal(t, "Corporate CA", ca.Subject.CommonName)
+
+	_, err = NewTLSException(newTestCertPEM(t, false), []string{"api.protonmail.ch"})
+	require.Error(t, err)
+	_, err = NewTLSException([]byte("not a certificate"), []string{"api.protonmail.ch"})
+	require.Error(t, err)
+	_, err = NewTLSException(caPEM, nil)
+	require.Error(t, err)
+	for _, host := range []string{"*", "*.com.", "localhost", "-api.protonmail.ch"} {
+		_, err = NewTLSException(caPEM, []string{host})
+		require.Error(t, err, host)
+	}
+}
+
+func TestSaveLoadTLSExceptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-exceptions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+	path := filepath.Join(dir, "tls_exceptions.json")
+
+	exceptions, err := LoadTLSExceptions(path)
+	require.NoError(t, err)
+	require.Empty(t, exceptions)
+
+	exception, err := NewTLSException(newTestCertPEM(t, true), []string{"api.protonmail.ch"})
+	require.NoError(t, err)
+	require.NoError(t, SaveTLSExceptions(path, []*TLSException{exception}))
+
+	exceptions, err = LoadTLSExceptions(path)
+	require.NoError(t, err)
+	require.Len(t, exceptions, 1)
+	require.Equal(t, exception.Fingerprint, exceptions[0].Fingerprint)
+	require.Equal(t, exception.Certificate, exceptions[0].Certificate)
+
+	require.NoError(t, SaveTLSExceptions(path, nil))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
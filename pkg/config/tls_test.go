@@ -18,6 +18,7 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -61,3 +62,63 @@ func TestTLSKeyRenewal(t *testing.T) {
 	now, notValidAfter = time.Now(), cert.Certificates[0].Leaf.NotAfter
 	require.False(t, now.After(notValidAfter), "new certificate expected to be valid at %v but have valid until %v", now, notValidAfter)
 }
+
+func TestUserTLSConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "user-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	_, err = GetUserTLSConfig(certPath, keyPath)
+	require.Error(t, err)
+
+	tlsTemplate.NotBefore = time.Now()
+	tlsTemplate.NotAfter = time.Now().Add(2 * 365 * 24 * time.Hour)
+	_, err = GenerateTLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+
+	userTLS, err := GetUserTLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+	oldCert, err := userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+
+	// Same files give the same certificate.
+	cert, err := userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, oldCert.Leaf.SerialNumber, cert.Leaf.SerialNumber)
+
+	// Renewed files are picked up.
+	_, err = GenerateTLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, future, future))
+
+	cert, err = userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, oldCert.Leaf.SerialNumber, cert.Leaf.SerialNumber)
+
+	// Broken files keep the loaded certificate.
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("broken"), 0600))
+	require.NoError(t, os.Chtimes(certPath, future.Add(time.Minute), future.Add(time.Minute)))
+
+	brokenCert, err := userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert.Leaf.SerialNumber, brokenCert.Leaf.SerialNumber)
+
+	// Files are not tried again until they are modified.
+	_, err = GenerateTLSConfig(certPath, keyPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(certPath, future.Add(time.Minute), future.Add(time.Minute)))
+	require.NoError(t, os.Chtimes(keyPath, future, future))
+
+	sameCert, err := userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert.Leaf.SerialNumber, sameCert.Leaf.SerialNumber)
+
+	require.NoError(t, os.Chtimes(certPath, future.Add(2*time.Minute), future.Add(2*time.Minute)))
+	newCert, err := userTLS.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, cert.Leaf.SerialNumber, newCert.Leaf.SerialNumber)
+}
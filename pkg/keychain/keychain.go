@@ -20,6 +20,9 @@ package keychain
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -41,14 +44,69 @@ var (
 	accessLocker           = &sync.Mutex{} //nolint[gochecknoglobals]
 )
 
-// NewAccess creates a new native keychain.
-func NewAccess(appName string) (*Access, error) {
-	newHelper, err := newKeychain()
+// Backends of the keychain. Not all of them are available on every
+// platform, see GetBackends.
+const (
+	BackendAuto          = ""               // File when BRIDGE_KEYCHAIN_FILE is set or in portable mode, native otherwise.
+	BackendNative        = "native"         // Default of the platform.
+	BackendFile          = "file"           // Encrypted file, see KeychainFileEnv.
+	BackendPass          = "pass"           // pass(1) password store.
+	BackendSecretService = "secret-service" // Secret Service, e.g. GNOME Keyring.
+	BackendKWallet       = "kwallet"        // KDE Wallet.
+	BackendVault         = "vault"          // HashiCorp Vault KV v2 secrets engine.
+)
+
+// backendFactory creates the credentials helper of a backend.
+type backendFactory func() (credentials.Helper, error)
+
+// getBackendFactories returns factories of backends available on this
+// platform by their names.
+func getBackendFactories() map[string]backendFactory {
+	factories := map[string]backendFactory{
+		BackendNative: newKeychain,
+		BackendFile:   newFileKeychainFromEnv,
+		BackendVault:  newVaultKeychainFromEnv,
+	}
+	addPlatformBackends(factories)
+	return factories
+}
+
+// GetBackends returns sorted names of backends available on this platform.
+func GetBackends() (backends []string) {
+	for backend := range getBackendFactories() {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return
+}
+
+// NewAccess creates a new keychain using the given backend.
+func NewAccess(appName, backend string) (*Access, error) {
+	// Portable mode must not write to the keychain of the system.
+	if path := getPortableFile(); path != "" && backend != BackendFile {
+		log.WithField("backend", backend).Info("Using file keychain of portable mode instead of selected backend")
+		backend = BackendFile
+	}
+
+	if backend == BackendAuto {
+		backend = BackendNative
+		if os.Getenv(KeychainFileEnv) != "" {
+			backend = BackendFile
+		}
+	}
+
+	newHelper, ok := getBackendFactories()[backend]
+	if !ok {
+		return nil, fmt.Errorf("keychain backend %q is not available on this platform", backend)
+	}
+
+	log.WithField("backend", backend).Debug("Creating keychain")
+	helper, err := newHelper()
 	if err != nil {
 		return nil, err
 	}
 	return &Access{
-		helper:            newHelper,
+		helper:            helper,
 		KeychainURL:       "protonmail/" + appName + "/users",
 		KeychainOldURL:    "protonmail/users",
 		KeychainMacURL:    "ProtonMail" + strings.Title(appName) + "Service",
@@ -56,6 +114,13 @@ func NewAccess(appName string) (*Access, error) {
 	}, nil
 }
 
+// keychainItem is one credential kept by backends which store username and
+// secret together.
+type keychainItem struct {
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
 type Access struct {
 	helper credentials.Helper
 	KeychainURL,
@@ -40,6 +40,8 @@ func newKeychain() (credentials.Helper, error) {
 	return &osxkeychain{}, nil
 }
 
+func addPlatformBackends(map[string]backendFactory) {}
+
 func newQuery(serviceName, username string) mackeychain.Item {
 	query := mackeychain.NewItem()
 	query.SetSecClass(mackeychain.SecClassGenericPassword)
@@ -108,6 +110,10 @@ func (s *osxkeychain) Get(serverURL string) (userID string, secret string, err e
 
 // ListKeychain lists items in our services.
 func (s *Access) ListKeychain() (userIDByURL map[string]string, err error) {
+	if _, ok := s.helper.(*osxkeychain); !ok {
+		return s.helper.List()
+	}
+
 	// Pick up correct service name and trim '/'.
 	serviceName, _, err := splitServiceAndID(s.KeychainOldName("not-id"))
 	if err != nil {
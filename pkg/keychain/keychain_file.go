@@ -0,0 +1,395 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"golang.org/x/crypto/argon2"
+)
+
+// Environment variables configuring the file keychain. It is meant for
+// containers and servers without Secret Service or pass.
+const (
+	KeychainFileEnv       = "BRIDGE_KEYCHAIN_FILE"       //nolint[golint]
+	KeychainPassphraseEnv = "BRIDGE_KEYCHAIN_PASSPHRASE" //nolint[golint]
+	KeychainKeyFileEnv    = "BRIDGE_KEYCHAIN_KEY_FILE"   //nolint[golint]
+)
+
+const (
+	fileKeychainVersion = 1
+	fileKeychainSaltLen = 16
+	fileKeychainKeyLen  = 32
+
+	// Argon2id parameters recommended by RFC 9106 for memory constrained
+	// environments. The key is derived only once per run.
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+)
+
+var (
+	ErrFileKeychainNoPath   = errors.New("file keychain needs " + KeychainFileEnv)
+	ErrFileKeychainNoSecret = errors.New("file keychain needs " + KeychainPassphraseEnv + " or " + KeychainKeyFileEnv)
+	ErrFileKeychainDecrypt  = errors.New("cannot decrypt file keychain, wrong passphrase or key file")
+)
+
+// fileKeychainData is the content of the keychain file. Credentials are
+// encrypted by AES-GCM with a key derived from the secret by Argon2id.
+type fileKeychainData struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// fileKeychain is a credentials helper keeping items in an encrypted file.
+// The file is read and written on every change, so items added by another
+// Bridge process, e.g. by --cli, are seen.
+type fileKeychain struct {
+	path string
+
+	lock sync.Mutex
+	salt []byte
+	aead cipher.AEAD
+}
+
+// portablePromptAttempts is how many times the passphrase of portable mode
+// is asked again when it cannot decrypt the file.
+const portablePromptAttempts = 3
+
+var (
+	portableLock   sync.Mutex                 //nolint[gochecknoglobals]
+	portableFile   string                     //nolint[gochecknoglobals]
+	portablePrompt func(bool) ([]byte, error) //nolint[gochecknoglobals]
+	portableSecret []byte                     //nolint[gochecknoglobals]
+)
+
+// SetPortable selects the file keychain on the path for all backends, so
+// credentials stay in the folder of portable mode. Prompt is called for the
+// secret when it is not set by environment; its argument tells whether the
+// file is new, e.g. to ask for the passphrase twice. Only the secret which
+// decrypted the file is kept, so wrong or failed one is asked again. Empty
+// path turns it off.
+func SetPortable(path string, prompt func(newFile bool) ([]byte, error)) {
+	portableLock.Lock()
+	defer portableLock.Unlock()
+
+	portableFile = path
+	portablePrompt = prompt
+	portableSecret = nil
+}
+
+func getPortableFile() string {
+	portableLock.Lock()
+	defer portableLock.Unlock()
+
+	return portableFile
+}
+
+// newFileKeychainFromEnv returns the file keychain on the path set by
+// environment or by portable mode.
+func newFileKeychainFromEnv() (credentials.Helper, error) {
+	path := os.Getenv(KeychainFileEnv)
+	if path == "" {
+		path = getPortableFile()
+	}
+	if path == "" {
+		return nil, ErrFileKeychainNoPath
+	}
+
+	secret, err := getFileKeychainSecret()
+	if err == ErrFileKeychainNoSecret {
+		return newPromptedFileKeychain(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("path", path).Debug("Creating file keychain")
+	return newFileKeychain(path, secret)
+}
+
+// newPromptedFileKeychain opens the keychain file by the secret of portable
+// mode which decrypted it before or, if none, by the secret from the prompt.
+func newPromptedFileKeychain(path string) (*fileKeychain, error) {
+	portableLock.Lock()
+	secret := portableSecret
+	portableLock.Unlock()
+
+	if secret != nil {
+		return newFileKeychain(path, secret)
+	}
+
+	for attempt := 1; ; attempt++ {
+		secret, err := promptFileKeychainSecret(path)
+		if err != nil {
+			return nil, err
+		}
+
+		log.WithField("path", path).Debug("Creating file keychain")
+		kc, err := newFileKeychain(path, secret)
+		if err == ErrFileKeychainDecrypt && attempt < portablePromptAttempts {
+			log.WithField("attempt", attempt).Warn("Wrong passphrase of file keychain")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		portableLock.Lock()
+		portableSecret = secret
+		portableLock.Unlock()
+
+		return kc, nil
+	}
+}
+
+// getFileKeychainSecret returns the passphrase or the content of the key
+// file. Key file is preferred as it does not leak to environment of other
+// processes.
+func getFileKeychainSecret() ([]byte, error) {
+	if keyFile := os.Getenv(KeychainKeyFileEnv); keyFile != "" {
+		secret, err := ioutil.ReadFile(keyFile) //nolint[gosec]
+		if err != nil {
+			return nil, err
+		}
+		secret = []byte(strings.TrimRight(string(secret), "\r\n"))
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("key file %v is empty", keyFile)
+		}
+		return secret, nil
+	}
+
+	if passphrase := os.Getenv(KeychainPassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	return nil, ErrFileKeychainNoSecret
+}
+
+// promptFileKeychainSecret asks for the secret by the prompt of portable
+// mode, if any.
+func promptFileKeychainSecret(path string) ([]byte, error) {
+	portableLock.Lock()
+	prompt := portablePrompt
+	portableLock.Unlock()
+
+	if prompt == nil {
+		return nil, ErrFileKeychainNoSecret
+	}
+
+	_, err := os.Stat(path)
+	secret, promptErr := prompt(os.IsNotExist(err))
+	if promptErr != nil {
+		return nil, promptErr
+	}
+	if len(secret) == 0 {
+		return nil, ErrFileKeychainNoSecret
+	}
+	return secret, nil
+}
+
+// newFileKeychain opens the keychain file or prepares a new one when there
+// is no file yet. Existing file is decrypted to check the secret.
+func newFileKeychain(path string, secret []byte) (*fileKeychain, error) {
+	kc := &fileKeychain{path: path}
+
+	data, err := kc.readData()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if data != nil {
+		kc.salt = data.Salt
+	} else {
+		kc.salt = make([]byte, fileKeychainSaltLen)
+		if _, err := rand.Read(kc.salt); err != nil {
+			return nil, err
+		}
+	}
+
+	key := argon2.IDKey(secret, kc.salt, argon2Time, argon2Memory, argon2Threads, fileKeychainKeyLen)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if kc.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	if data != nil {
+		if _, err := kc.decrypt(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return kc, nil
+}
+
+func (kc *fileKeychain) Add(cred *credentials.Credentials) error {
+	return kc.update(func(items map[string]keychainItem) {
+		items[cred.ServerURL] = keychainItem{Username: cred.Username, Secret: cred.Secret}
+	})
+}
+
+func (kc *fileKeychain) Delete(serverURL string) error {
+	return kc.update(func(items map[string]keychainItem) {
+		delete(items, serverURL)
+	})
+}
+
+func (kc *fileKeychain) Get(serverURL string) (string, string, error) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	items, err := kc.load()
+	if err != nil {
+		return "", "", err
+	}
+
+	item, ok := items[serverURL]
+	if !ok {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+	return item.Username, item.Secret, nil
+}
+
+func (kc *fileKeychain) List() (map[string]string, error) {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	items, err := kc.load()
+	if err != nil {
+		return nil, err
+	}
+
+	userIDByURL := map[string]string{}
+	for serverURL, item := range items {
+		userIDByURL[serverURL] = item.Username
+	}
+	return userIDByURL, nil
+}
+
+// update loads items, changes them and saves them back.
+func (kc *fileKeychain) update(change func(map[string]keychainItem)) error {
+	kc.lock.Lock()
+	defer kc.lock.Unlock()
+
+	items, err := kc.load()
+	if err != nil {
+		return err
+	}
+
+	change(items)
+
+	return kc.save(items)
+}
+
+// load returns decrypted items, no items when there is no file yet.
+func (kc *fileKeychain) load() (map[string]keychainItem, error) {
+	data, err := kc.readData()
+	if os.IsNotExist(err) {
+		return map[string]keychainItem{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return kc.decrypt(data)
+}
+
+func (kc *fileKeychain) readData() (*fileKeychainData, error) {
+	b, err := ioutil.ReadFile(kc.path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &fileKeychainData{}
+	if err := json.Unmarshal(b, data); err != nil {
+		return nil, fmt.Errorf("invalid keychain file %v: %v", kc.path, err)
+	}
+	if data.Version != fileKeychainVersion {
+		return nil, fmt.Errorf("unsupported keychain file version %d", data.Version)
+	}
+	return data, nil
+}
+
+func (kc *fileKeychain) decrypt(data *fileKeychainData) (map[string]keychainItem, error) {
+	plain, err := kc.aead.Open(nil, data.Nonce, data.Data, nil)
+	if err != nil {
+		return nil, ErrFileKeychainDecrypt
+	}
+
+	items := map[string]keychainItem{}
+	if err := json.Unmarshal(plain, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// save encrypts items by a new nonce and replaces the file atomically.
+func (kc *fileKeychain) save(items map[string]keychainItem) error {
+	plain, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, kc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&fileKeychainData{
+		Version: fileKeychainVersion,
+		Salt:    kc.salt,
+		Nonce:   nonce,
+		Data:    kc.aead.Seal(nil, nonce, plain, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Temporary file is created readable only by the owner.
+	tmp, err := ioutil.TempFile(filepath.Dir(kc.path), filepath.Base(kc.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint[errcheck]
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), kc.path)
+}
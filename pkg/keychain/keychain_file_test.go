@@ -0,0 +1,168 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeychainFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keychain")
+	require.NoError(t, err)
+	return filepath.Join(dir, "credentials.json"), func() { _ = os.RemoveAll(dir) }
+}
+
+func TestFileKeychain(t *testing.T) {
+	path, clear := newTestKeychainFile(t)
+	defer clear()
+
+	kc, err := newFileKeychain(path, []byte("passphrase"))
+	require.NoError(t, err)
+
+	list, err := kc.List()
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	for id, secret := range testData {
+		require.NoError(t, kc.Add(&credentials.Credentials{ServerURL: "protonmail/bridge/users/" + id, Username: id, Secret: secret}))
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	if os.PathSeparator == '/' {
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Other instance sees the items with the same passphrase.
+	kc, err = newFileKeychain(path, []byte("passphrase"))
+	require.NoError(t, err)
+
+	username, secret, err := kc.Get("protonmail/bridge/users/user1")
+	require.NoError(t, err)
+	require.Equal(t, "user1", username)
+	require.Equal(t, testData["user1"], secret)
+
+	require.NoError(t, kc.Delete("protonmail/bridge/users/user1"))
+	_, _, err = kc.Get("protonmail/bridge/users/user1")
+	require.True(t, credentials.IsErrCredentialsNotFound(err))
+
+	list, err = kc.List()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"protonmail/bridge/users/user2": "user2"}, list)
+
+	_, err = newFileKeychain(path, []byte("wrong"))
+	require.Equal(t, ErrFileKeychainDecrypt, err)
+}
+
+func TestFileKeychainFromEnv(t *testing.T) {
+	path, clear := newTestKeychainFile(t)
+	defer clear()
+
+	defer os.Unsetenv(KeychainFileEnv)       //nolint[errcheck]
+	defer os.Unsetenv(KeychainPassphraseEnv) //nolint[errcheck]
+	defer os.Unsetenv(KeychainKeyFileEnv)    //nolint[errcheck]
+
+	_, err := newFileKeychainFromEnv()
+	require.Equal(t, ErrFileKeychainNoPath, err)
+
+	require.NoError(t, os.Setenv(KeychainFileEnv, path))
+	_, err = newFileKeychainFromEnv()
+	require.Equal(t, ErrFileKeychainNoSecret, err)
+
+	keyFile := filepath.Join(filepath.Dir(path), "key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("secret key\n"), 0600))
+	require.NoError(t, os.Setenv(KeychainKeyFileEnv, keyFile))
+
+	access, err := NewAccess("bridge", BackendAuto)
+	require.NoError(t, err)
+	require.NoError(t, access.Put("user1", testData["user1"]))
+
+	ids, err := access.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"user1"}, ids)
+
+	// Trailing new line of the key file is not part of the key.
+	_, err = newFileKeychain(path, []byte("secret key"))
+	require.NoError(t, err)
+}
+
+func TestFileKeychainPortable(t *testing.T) {
+	path, clear := newTestKeychainFile(t)
+	defer clear()
+
+	var prompted []bool
+	SetPortable(path, func(newFile bool) ([]byte, error) {
+		prompted = append(prompted, newFile)
+		return []byte("passphrase"), nil
+	})
+	defer SetPortable("", nil)
+
+	access, err := NewAccess("bridge", BackendNative)
+	require.NoError(t, err)
+	require.NoError(t, access.Put("user1", testData["user1"]))
+
+	access, err = NewAccess("bridge", BackendAuto)
+	require.NoError(t, err)
+	secret, err := access.Get("user1")
+	require.NoError(t, err)
+	require.Equal(t, testData["user1"], secret)
+
+	// Secret which decrypted the file is not asked again.
+	require.Equal(t, []bool{true}, prompted)
+
+	SetPortable(path, nil)
+	_, err = NewAccess("bridge", BackendAuto)
+	require.Equal(t, ErrFileKeychainNoSecret, err)
+}
+
+func TestFileKeychainPortableWrongPassphrase(t *testing.T) {
+	path, clear := newTestKeychainFile(t)
+	defer clear()
+
+	kc, err := newFileKeychain(path, []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, kc.Add(&credentials.Credentials{ServerURL: "bridge/user1", Username: "user1", Secret: "secret"}))
+
+	var answers []string
+	prompt := func(newFile bool) ([]byte, error) {
+		require.False(t, newFile)
+		passphrase := answers[0]
+		answers = answers[1:]
+		return []byte(passphrase), nil
+	}
+	SetPortable(path, prompt)
+	defer SetPortable("", nil)
+
+	answers = []string{"wrong", "wrong", "wrong"}
+	_, err = NewAccess("bridge", BackendAuto)
+	require.Equal(t, ErrFileKeychainDecrypt, err)
+
+	answers = []string{"wrong", "passphrase"}
+	_, err = NewAccess("bridge", BackendAuto)
+	require.NoError(t, err)
+	require.Empty(t, answers)
+
+	_, err = NewAccess("bridge", BackendAuto)
+	require.NoError(t, err)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	kwalletAppID  = "ProtonMail Bridge"
+	kwalletFolder = "ProtonMail"
+)
+
+// kwalletServices are D-Bus names and paths of KDE Wallet daemons from the
+// newest one.
+var kwalletServices = [][2]string{ //nolint[gochecknoglobals]
+	{"org.kde.kwalletd6", "/modules/kwalletd6"},
+	{"org.kde.kwalletd5", "/modules/kwalletd5"},
+}
+
+// kwalletKeychain keeps credentials in the local KDE Wallet. Username and
+// secret are stored together as JSON password of the entry. Secrets are
+// passed only over the session bus, never as arguments of a process.
+type kwalletKeychain struct {
+	wallet string
+
+	// call invokes the method of the wallet daemon and returns values of
+	// the reply. It is replaced in tests.
+	call func(method string, args ...interface{}) ([]interface{}, error)
+}
+
+func newKWalletKeychain() (credentials.Helper, error) {
+	log.Debug("Creating kwallet")
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to session bus: %v", err)
+	}
+
+	var lastErr error
+	for _, service := range kwalletServices {
+		obj := conn.Object(service[0], dbus.ObjectPath(service[1]))
+		kc := &kwalletKeychain{call: func(method string, args ...interface{}) ([]interface{}, error) {
+			call := obj.Call("org.kde.KWallet."+method, 0, args...)
+			return call.Body, call.Err
+		}}
+
+		if err := kc.callStore("localWallet", &kc.wallet); err != nil {
+			lastErr = err
+			continue
+		}
+		if kc.wallet == "" {
+			return nil, fmt.Errorf("no local KDE wallet")
+		}
+
+		return kc, nil
+	}
+
+	return nil, fmt.Errorf("KDE Wallet is not running: %v", lastErr)
+}
+
+func (kc *kwalletKeychain) Add(cred *credentials.Credentials) error {
+	handle, err := kc.open()
+	if err != nil {
+		return err
+	}
+	defer kc.close(handle)
+
+	value, err := json.Marshal(keychainItem{Username: cred.Username, Secret: cred.Secret})
+	if err != nil {
+		return err
+	}
+
+	return kc.callStatus("writePassword", handle, kwalletFolder, cred.ServerURL, string(value), kwalletAppID)
+}
+
+func (kc *kwalletKeychain) Delete(serverURL string) error {
+	handle, err := kc.open()
+	if err != nil {
+		return err
+	}
+	defer kc.close(handle)
+
+	if has, err := kc.hasEntry(handle, serverURL); err != nil {
+		return err
+	} else if !has {
+		return credentials.NewErrCredentialsNotFound()
+	}
+
+	return kc.callStatus("removeEntry", handle, kwalletFolder, serverURL, kwalletAppID)
+}
+
+func (kc *kwalletKeychain) Get(serverURL string) (string, string, error) {
+	handle, err := kc.open()
+	if err != nil {
+		return "", "", err
+	}
+	defer kc.close(handle)
+
+	if has, err := kc.hasEntry(handle, serverURL); err != nil {
+		return "", "", err
+	} else if !has {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+
+	item, err := kc.read(handle, serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	return item.Username, item.Secret, nil
+}
+
+func (kc *kwalletKeychain) List() (map[string]string, error) {
+	handle, err := kc.open()
+	if err != nil {
+		return nil, err
+	}
+	defer kc.close(handle)
+
+	userIDByURL := map[string]string{}
+
+	var hasFolder bool
+	if err := kc.callStore("hasFolder", &hasFolder, handle, kwalletFolder, kwalletAppID); err != nil {
+		return nil, err
+	}
+	if !hasFolder {
+		return userIDByURL, nil
+	}
+
+	var serverURLs []string
+	if err := kc.callStore("entryList", &serverURLs, handle, kwalletFolder, kwalletAppID); err != nil {
+		return nil, err
+	}
+
+	for _, serverURL := range serverURLs {
+		item, err := kc.read(handle, serverURL)
+		if err != nil {
+			return nil, err
+		}
+		userIDByURL[serverURL] = item.Username
+	}
+	return userIDByURL, nil
+}
+
+// open opens the wallet and returns the handle argument of other methods.
+// The wallet daemon may ask the user to unlock the wallet.
+func (kc *kwalletKeychain) open() (int32, error) {
+	var handle int32
+	if err := kc.callStore("open", &handle, kc.wallet, int64(0), kwalletAppID); err != nil {
+		return 0, err
+	}
+	if handle < 0 {
+		return 0, fmt.Errorf("cannot open KDE wallet %q", kc.wallet)
+	}
+	return handle, nil
+}
+
+// close releases the handle; the wallet stays open for other applications.
+func (kc *kwalletKeychain) close(handle int32) {
+	var status int32
+	if err := kc.callStore("close", &status, handle, false, kwalletAppID); err != nil {
+		log.WithError(err).Warn("Cannot close KDE wallet")
+	}
+}
+
+func (kc *kwalletKeychain) hasEntry(handle int32, serverURL string) (has bool, err error) {
+	err = kc.callStore("hasEntry", &has, handle, kwalletFolder, serverURL, kwalletAppID)
+	return
+}
+
+func (kc *kwalletKeychain) read(handle int32, serverURL string) (item keychainItem, err error) {
+	var value string
+	if err = kc.callStore("readPassword", &value, handle, kwalletFolder, serverURL, kwalletAppID); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(value), &item)
+	return
+}
+
+// callStatus invokes the method returning zero on success.
+func (kc *kwalletKeychain) callStatus(method string, args ...interface{}) error {
+	var status int32
+	if err := kc.callStore(method, &status, args...); err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("%v failed with status %d", method, status)
+	}
+	return nil
+}
+
+// callStore invokes the method and stores the only value of the reply.
+func (kc *kwalletKeychain) callStore(method string, result interface{}, args ...interface{}) error {
+	reply, err := kc.call(method, args...)
+	if err != nil {
+		return err
+	}
+	if len(reply) != 1 {
+		return fmt.Errorf("unexpected reply of %v: %v", method, reply)
+	}
+	return dbus.Store(reply, result)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKWallet emulates methods of the wallet daemon used by the keychain.
+type fakeKWallet struct {
+	entries map[string]string
+	handles int
+}
+
+func (w *fakeKWallet) call(method string, args ...interface{}) ([]interface{}, error) {
+	switch method {
+	case "open":
+		w.handles++
+		return []interface{}{int32(7)}, nil
+	case "close":
+		w.handles--
+	case "hasFolder":
+		return []interface{}{true}, nil
+	case "hasEntry":
+		_, ok := w.entries[args[2].(string)]
+		return []interface{}{ok}, nil
+	case "writePassword":
+		w.entries[args[2].(string)] = args[3].(string)
+	case "readPassword":
+		return []interface{}{w.entries[args[2].(string)]}, nil
+	case "removeEntry":
+		delete(w.entries, args[2].(string))
+	case "entryList":
+		keys := []string{}
+		for key := range w.entries {
+			keys = append(keys, key)
+		}
+		return []interface{}{keys}, nil
+	}
+	return []interface{}{int32(0)}, nil
+}
+
+func TestKWalletKeychain(t *testing.T) {
+	wallet := &fakeKWallet{entries: map[string]string{}}
+	kc := &kwalletKeychain{wallet: "kdewallet", call: wallet.call}
+
+	require.NoError(t, kc.Add(&credentials.Credentials{ServerURL: "protonmail/bridge/users/user1", Username: "user1", Secret: testData["user1"]}))
+
+	list, err := kc.List()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"protonmail/bridge/users/user1": "user1"}, list)
+
+	username, secret, err := kc.Get("protonmail/bridge/users/user1")
+	require.NoError(t, err)
+	require.Equal(t, "user1", username)
+	require.Equal(t, testData["user1"], secret)
+
+	require.NoError(t, kc.Delete("protonmail/bridge/users/user1"))
+	_, _, err = kc.Get("protonmail/bridge/users/user1")
+	require.True(t, credentials.IsErrCredentialsNotFound(err))
+	require.True(t, credentials.IsErrCredentialsNotFound(kc.Delete("protonmail/bridge/users/user1")))
+
+	require.Zero(t, wallet.handles, "all handles are closed")
+}
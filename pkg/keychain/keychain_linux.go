@@ -24,16 +24,12 @@ import (
 )
 
 func newKeychain() (credentials.Helper, error) {
-	log.Debug("Creating pass")
-	passHelper := &pass.Pass{}
-	passErr := checkPassIsUsable(passHelper)
+	passHelper, passErr := newPassKeychain()
 	if passErr == nil {
 		return passHelper, nil
 	}
 
-	log.Debug("Creating secretservice")
-	sserviceHelper := &secretservice.Secretservice{}
-	_, sserviceErr := sserviceHelper.List()
+	sserviceHelper, sserviceErr := newSecretServiceKeychain()
 	if sserviceErr == nil {
 		return sserviceHelper, nil
 	}
@@ -42,6 +38,30 @@ func newKeychain() (credentials.Helper, error) {
 	return nil, ErrNoKeychainInstalled
 }
 
+func addPlatformBackends(factories map[string]backendFactory) {
+	factories[BackendPass] = newPassKeychain
+	factories[BackendSecretService] = newSecretServiceKeychain
+	factories[BackendKWallet] = newKWalletKeychain
+}
+
+func newPassKeychain() (credentials.Helper, error) {
+	log.Debug("Creating pass")
+	passHelper := &pass.Pass{}
+	if err := checkPassIsUsable(passHelper); err != nil {
+		return nil, err
+	}
+	return passHelper, nil
+}
+
+func newSecretServiceKeychain() (credentials.Helper, error) {
+	log.Debug("Creating secretservice")
+	sserviceHelper := &secretservice.Secretservice{}
+	if _, err := sserviceHelper.List(); err != nil {
+		return nil, err
+	}
+	return sserviceHelper, nil
+}
+
 func checkPassIsUsable(passHelper *pass.Pass) (err error) {
 	creds := &credentials.Credentials{
 		ServerURL: "initCheck/pass",
@@ -32,7 +32,7 @@ var testData = map[string]string{ //nolint[gochecknoglobals]
 }
 
 func TestSplitServiceAndID(t *testing.T) {
-	acc, err := NewAccess("bridge")
+	acc, err := NewAccess("bridge", BackendAuto)
 	require.NoError(t, err)
 	expectedUserID := "user"
 
@@ -58,7 +58,7 @@ func TestInsertReadRemove(t *testing.T) { // nolint[funlen]
 		t.Skip("skipping test in short mode.")
 	}
 
-	access, err := NewAccess("bridge")
+	access, err := NewAccess("bridge", BackendAuto)
 	require.NoError(t, err)
 	access.KeychainURL = "protonmail/testchain/users"
 	access.KeychainMacURL = "ProtonMailTestChainService"
@@ -0,0 +1,226 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// Environment variables configuring the Vault keychain. Address, token and
+// namespace use the same variables as the vault command.
+const (
+	VaultAddressEnv   = "VAULT_ADDR"                  //nolint[golint]
+	VaultTokenEnv     = "VAULT_TOKEN"                 //nolint[golint]
+	VaultNamespaceEnv = "VAULT_NAMESPACE"             //nolint[golint]
+	VaultMountEnv     = "BRIDGE_KEYCHAIN_VAULT_MOUNT" //nolint[golint]
+	VaultPathEnv      = "BRIDGE_KEYCHAIN_VAULT_PATH"  //nolint[golint]
+)
+
+const (
+	vaultTokenFile    = ".vault-token"
+	vaultTimeout      = 30 * time.Second
+	defaultVaultMount = "secret"
+	defaultVaultPath  = "protonmail-bridge"
+)
+
+var (
+	ErrVaultNoAddress = errors.New("vault keychain needs " + VaultAddressEnv)
+	ErrVaultNoToken   = errors.New("vault keychain needs " + VaultTokenEnv + " or ~/" + vaultTokenFile)
+)
+
+// vaultKeychain keeps credentials in KV version 2 secrets engine of
+// HashiCorp Vault. Each credential is one secret under the path with its
+// server URL, e.g. secret/protonmail-bridge/protonmail/bridge/users/ID.
+type vaultKeychain struct {
+	client    *http.Client
+	address   string
+	token     string
+	namespace string
+	mount     string
+	path      string
+}
+
+func newVaultKeychainFromEnv() (credentials.Helper, error) {
+	address := os.Getenv(VaultAddressEnv)
+	if address == "" {
+		return nil, ErrVaultNoAddress
+	}
+
+	token := os.Getenv(VaultTokenEnv)
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := ioutil.ReadFile(filepath.Join(home, vaultTokenFile)); err == nil { //nolint[gosec]
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token == "" {
+		return nil, ErrVaultNoToken
+	}
+
+	kc := &vaultKeychain{
+		client:    &http.Client{Timeout: vaultTimeout},
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: os.Getenv(VaultNamespaceEnv),
+		mount:     getEnvOrDefault(VaultMountEnv, defaultVaultMount),
+		path:      getEnvOrDefault(VaultPathEnv, defaultVaultPath),
+	}
+
+	log.WithField("address", kc.address).Debug("Creating vault")
+
+	// Listing checks the address, token and its permissions at once.
+	if _, err := kc.List(); err != nil {
+		return nil, err
+	}
+	return kc, nil
+}
+
+func getEnvOrDefault(name, defaultValue string) string {
+	if value := strings.Trim(os.Getenv(name), "/"); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (kc *vaultKeychain) Add(cred *credentials.Credentials) error {
+	body := map[string]interface{}{
+		"data": keychainItem{Username: cred.Username, Secret: cred.Secret},
+	}
+	_, err := kc.do(http.MethodPost, "data", cred.ServerURL, body)
+	return err
+}
+
+func (kc *vaultKeychain) Delete(serverURL string) error {
+	// Deleting metadata removes all versions of the secret.
+	_, err := kc.do(http.MethodDelete, "metadata", serverURL, nil)
+	return err
+}
+
+func (kc *vaultKeychain) Get(serverURL string) (string, string, error) {
+	b, err := kc.do(http.MethodGet, "data", serverURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	var res struct {
+		Data struct {
+			Data keychainItem `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return "", "", err
+	}
+	return res.Data.Data.Username, res.Data.Data.Secret, nil
+}
+
+func (kc *vaultKeychain) List() (map[string]string, error) {
+	userIDByURL := map[string]string{}
+	if err := kc.list("", userIDByURL); err != nil {
+		return nil, err
+	}
+	return userIDByURL, nil
+}
+
+// list adds credentials under the directory and its subdirectories.
+func (kc *vaultKeychain) list(dir string, userIDByURL map[string]string) error {
+	b, err := kc.do("LIST", "metadata", dir, nil)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return err
+	}
+
+	for _, key := range res.Data.Keys {
+		if strings.HasSuffix(key, "/") {
+			if err := kc.list(dir+key, userIDByURL); err != nil {
+				return err
+			}
+			continue
+		}
+
+		username, _, err := kc.Get(dir + key)
+		if err != nil {
+			return err
+		}
+		userIDByURL[dir+key] = username
+	}
+	return nil
+}
+
+// do sends the request to the endpoint (data or metadata) of the secret and
+// returns the response body. Missing secret is credentials not found error.
+func (kc *vaultKeychain) do(method, endpoint, serverURL string, body interface{}) ([]byte, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	url := fmt.Sprintf("%v/v1/%v/%v/%v/%v", kc.address, kc.mount, endpoint, kc.path, strings.TrimPrefix(serverURL, "/"))
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", kc.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if kc.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", kc.namespace)
+	}
+
+	res, err := kc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() //nolint[errcheck]
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, credentials.NewErrCredentialsNotFound()
+	case res.StatusCode >= 300:
+		return nil, fmt.Errorf("vault returned %v: %v", res.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package keychain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVault returns fake KV version 2 secrets engine mounted at secret.
+func newTestVault(t *testing.T) *httptest.Server {
+	var lock sync.Mutex
+	secrets := map[string]json.RawMessage{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		path := r.URL.Path
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/v1/secret/data/"):
+			var body struct {
+				Data json.RawMessage `json:"data"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			secrets[strings.TrimPrefix(path, "/v1/secret/data/")] = body.Data
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/secret/data/"):
+			data, ok := secrets[strings.TrimPrefix(path, "/v1/secret/data/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/v1/secret/metadata/"):
+			delete(secrets, strings.TrimPrefix(path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "LIST" && strings.HasPrefix(path, "/v1/secret/metadata/"):
+			dir := strings.TrimPrefix(path, "/v1/secret/metadata/")
+			keys := map[string]bool{}
+			for key := range secrets {
+				if strings.HasPrefix(key, dir) {
+					rest := strings.TrimPrefix(key, dir)
+					if i := strings.Index(rest, "/"); i >= 0 {
+						rest = rest[:i+1]
+					}
+					keys[rest] = true
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			list := []string{}
+			for key := range keys {
+				list = append(list, key)
+			}
+			sort.Strings(list)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": list}})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestVaultKeychain(t *testing.T) {
+	server := newTestVault(t)
+	defer server.Close()
+
+	defer os.Unsetenv(VaultAddressEnv) //nolint[errcheck]
+	defer os.Unsetenv(VaultTokenEnv)   //nolint[errcheck]
+
+	require.NoError(t, os.Setenv(VaultAddressEnv, server.URL))
+	require.NoError(t, os.Setenv(VaultTokenEnv, "wrong"))
+	_, err := newVaultKeychainFromEnv()
+	require.Error(t, err)
+
+	require.NoError(t, os.Setenv(VaultTokenEnv, "token"))
+	kc, err := newVaultKeychainFromEnv()
+	require.NoError(t, err)
+
+	for id, secret := range testData {
+		require.NoError(t, kc.Add(&credentials.Credentials{ServerURL: "protonmail/bridge/users/" + id, Username: id, Secret: secret}))
+	}
+	require.NoError(t, kc.Add(&credentials.Credentials{ServerURL: "protonmail/users/old", Username: "old", Secret: "old"}))
+
+	list, err := kc.List()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"protonmail/bridge/users/user1": "user1",
+		"protonmail/bridge/users/user2": "user2",
+		"protonmail/users/old":          "old",
+	}, list)
+
+	username, secret, err := kc.Get("protonmail/bridge/users/user2")
+	require.NoError(t, err)
+	require.Equal(t, "user2", username)
+	require.Equal(t, testData["user2"], secret)
+
+	require.NoError(t, kc.Delete("protonmail/bridge/users/user2"))
+	_, _, err = kc.Get("protonmail/bridge/users/user2")
+	require.True(t, credentials.IsErrCredentialsNotFound(err))
+}
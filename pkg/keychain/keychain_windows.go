@@ -27,6 +27,8 @@ func newKeychain() (credentials.Helper, error) {
 	return &wincred.Wincred{}, nil
 }
 
+func addPlatformBackends(map[string]backendFactory) {}
+
 func (s *Access) KeychainName(userID string) string {
 	return s.KeychainURL + "/" + userID
 }
@@ -47,21 +47,31 @@ func WriteBody(w io.Writer, kr *crypto.KeyRing, m *pmapi.Message) error {
 }
 
 func WriteAttachmentBody(w io.Writer, kr *crypto.KeyRing, m *pmapi.Message, att *pmapi.Attachment, r io.Reader) (err error) {
-	// Decrypt it
-	var dr io.Reader
+	dr, _, err := DecryptAttachment(kr, att, r)
+	if err != nil {
+		return
+	}
+	return EncodeAttachmentBody(w, dr)
+}
+
+// DecryptAttachment returns reader of the decrypted attachment. Attachment
+// encrypted with a different key is returned as it is, renamed and marked as
+// encrypted; decrypted is false then.
+func DecryptAttachment(kr *crypto.KeyRing, att *pmapi.Attachment, r io.Reader) (dr io.Reader, decrypted bool, err error) {
 	dr, err = att.Decrypt(r, kr)
 	if err == openpgperrors.ErrKeyIncorrect {
 		// Do not fail if attachment is encrypted with a different key.
-		dr = r
-		err = nil
 		att.Name += ".gpg"
 		att.MIMEType = "application/pgp-encrypted" //nolint
+		return r, false, nil
 	} else if err != nil && err != openpgperrors.ErrSignatureExpired {
-		err = fmt.Errorf("cannot decrypt attachment: %v", err)
-		return
+		return nil, false, fmt.Errorf("cannot decrypt attachment: %v", err)
 	}
+	return dr, true, nil
+}
 
-	// Encode it.
+// EncodeAttachmentBody writes the decrypted attachment encoded as MIME part body.
+func EncodeAttachmentBody(w io.Writer, dr io.Reader) (err error) {
 	ww := textwrapper.NewRFC822(w)
 	bw := base64.NewEncoder(base64.StdEncoding, ww)
 
@@ -271,9 +271,17 @@ func (bld *Builder) WriteAttachmentBody(w io.Writer, att *pmapi.Attachment, attR
 	return err
 }
 
-func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) ([]byte, error) { //nolint[funlen]
+func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) ([]byte, error) {
 	b := &bytes.Buffer{}
+	if err := WriteEncrypted(b, m, readers, kr); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
 
+// WriteEncrypted writes the message for import to `w` part by part, so only
+// one part at a time has to be kept in memory, not the whole message.
+func WriteEncrypted(w io.Writer, m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) error { //nolint[funlen]
 	// Overwrite content for main header for import.
 	// Even if message has just simple body we should upload as multipart/mixed.
 	// Each part has encrypted body and header reflects the original header.
@@ -281,12 +289,12 @@ func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) (
 	mainHeader.Set("Content-Type", "multipart/mixed; boundary="+GetBoundary(m))
 	mainHeader.Del("Content-Disposition")
 	mainHeader.Del("Content-Transfer-Encoding")
-	if err := WriteHeader(b, mainHeader); err != nil {
-		return nil, err
+	if err := WriteHeader(w, mainHeader); err != nil {
+		return err
 	}
-	mw := multipart.NewWriter(b)
+	mw := multipart.NewWriter(w)
 	if err := mw.SetBoundary(GetBoundary(m)); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Write the body part.
@@ -297,14 +305,14 @@ func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) (
 
 	p, err := mw.CreatePart(bodyHeader)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// First, encrypt the message body.
 	if err := m.Encrypt(kr, kr); err != nil {
-		return nil, err
+		return err
 	}
 	if _, err := io.WriteString(p, m.Body); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Write the attachments parts.
@@ -314,7 +322,7 @@ func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) (
 		h := GetAttachmentHeader(att)
 		p, err := mw.CreatePart(h)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// Create line wrapper writer.
 		ww := textwrapper.NewRFC822(p)
@@ -324,25 +332,21 @@ func BuildEncrypted(m *pmapi.Message, readers []io.Reader, kr *crypto.KeyRing) (
 
 		data, err := ioutil.ReadAll(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// Create encrypted writer.
 		pgpMessage, err := kr.Encrypt(crypto.NewPlainMessage(data), nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if _, err := bw.Write(pgpMessage.GetBinary()); err != nil {
-			return nil, err
+			return err
 		}
 		if err := bw.Close(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	if err := mw.Close(); err != nil {
-		return nil, err
-	}
-
-	return b.Bytes(), nil
+	return mw.Close()
 }
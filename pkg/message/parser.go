@@ -20,6 +20,7 @@ package message
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"io"
 	"mime"
 	"net/mail"
@@ -81,7 +82,9 @@ func Parse(r io.Reader, key, keyName string) (m *pmapi.Message, mimeBody, plainB
 
 	mimeBodyBuffer := new(bytes.Buffer)
 
-	if err = p.NewWriter().Write(mimeBodyBuffer); err != nil {
+	// The MIME body is converted to 7-bit only for recipients who need it,
+	// see Make7Bit.
+	if err = p.NewWriter().Allow8Bit().Write(mimeBodyBuffer); err != nil {
 		err = errors.Wrap(err, "failed to write out mime message")
 		return
 	}
@@ -89,6 +92,114 @@ func Parse(r io.Reader, key, keyName string) (m *pmapi.Message, mimeBody, plainB
 	return m, mimeBodyBuffer.String(), plainBody, attReaders, nil
 }
 
+// Make7Bit encodes 8-bit parts of the MIME body returned by Parse to base64.
+// It is needed when the body is signed because signature has to be done over
+// 7-bit data (RFC3156).
+func Make7Bit(mimeBody string) (string, error) {
+	p, err := parser.New(strings.NewReader(mimeBody))
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := p.NewWriter().Write(buf); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// RemoveHeaders removes the top-level header fields from the MIME body returned
+// by Parse. Parts are not changed.
+func RemoveHeaders(mimeBody string, keys ...string) (string, error) {
+	p, err := parser.New(strings.NewReader(mimeBody))
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		p.Root().Header.Del(key)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := p.NewWriter().Allow8Bit().Write(buf); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// SetHeaders sets the top-level header fields of the MIME body returned by
+// Parse, replacing existing values. Parts are not changed.
+func SetHeaders(mimeBody string, fields map[string]string) (string, error) {
+	p, err := parser.New(strings.NewReader(mimeBody))
+	if err != nil {
+		return "", err
+	}
+
+	for key, value := range fields {
+		p.Root().Header.Set(key, value)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := p.NewWriter().Allow8Bit().Write(buf); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// AddFooter appends plain text footer to the body of given MIME type.
+// HTML footer is escaped and placed before the closing body tag.
+func AddFooter(body, mimeType, footer string) string {
+	if mimeType != "text/html" {
+		return body + "\n\n" + footer
+	}
+
+	htmlFooter := "<br><br>" + strings.ReplaceAll(html.EscapeString(footer), "\n", "<br>")
+
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + htmlFooter + body[i:]
+	}
+
+	return body + htmlFooter
+}
+
+// AddFooterToMIMEBody appends plain text footer to all text parts of the MIME
+// body returned by Parse which are not attachments.
+func AddFooterToMIMEBody(mimeBody, footer string) (string, error) {
+	p, err := parser.New(strings.NewReader(mimeBody))
+	if err != nil {
+		return "", err
+	}
+
+	addFooter := func(mimeType string) parser.HandlerFunc {
+		return func(p *parser.Part) error {
+			p.Body = []byte(AddFooter(string(p.Body), mimeType, footer))
+			return nil
+		}
+	}
+
+	if err := p.NewWalker().
+		RegisterContentDispositionHandler("attachment", func(*parser.Part) error { return nil }).
+		RegisterContentTypeHandler("text/plain", addFooter("text/plain")).
+		RegisterContentTypeHandler("text/html", addFooter("text/html")).
+		Walk(); err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := p.NewWriter().Allow8Bit().Write(buf); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
 func convertForeignEncodings(p *parser.Parser) error {
 	logrus.Trace("Converting foreign encodings")
 
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"errors"
 	"mime"
+	"strings"
 	"unicode/utf8"
 
 	pmmime "github.com/ProtonMail/proton-bridge/pkg/mime"
@@ -166,7 +167,7 @@ func selectSuitableDecoder(p *Part, t string, params map[string]string) *encodin
 
 func (p *Part) is7BitClean() bool {
 	for _, b := range p.Body {
-		if b > 1<<7 {
+		if b >= 1<<7 {
 			return false
 		}
 	}
@@ -174,6 +175,31 @@ func (p *Part) is7BitClean() bool {
 	return true
 }
 
+// is8BitClean returns whether the body can be sent as 8bit (RFC2045), i.e.
+// it is text without NUL characters and lines longer than 998 characters.
+func (p *Part) is8BitClean() bool {
+	if t, _, err := p.ContentType(); err != nil || !strings.HasPrefix(t, "text/") {
+		return false
+	}
+
+	lineLength := 0
+	for _, b := range p.Body {
+		switch b {
+		case 0:
+			return false
+		case '\r':
+		case '\n':
+			lineLength = 0
+		default:
+			if lineLength++; lineLength > 998 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 func (p *Part) isMultipartMixed() bool {
 	t, _, err := p.ContentType()
 	if err != nil {
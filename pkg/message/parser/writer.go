@@ -24,7 +24,8 @@ import (
 )
 
 type Writer struct {
-	root *Part
+	root      *Part
+	allow8Bit bool
 }
 
 func newWriter(root *Part) *Writer {
@@ -33,10 +34,16 @@ func newWriter(root *Part) *Writer {
 	}
 }
 
+// Allow8Bit keeps 8-bit text parts as they are instead of encoding them
+// to base64. It must be used only when the message is not transported over
+// 7-bit channel and is not signed.
+func (w *Writer) Allow8Bit() *Writer {
+	w.allow8Bit = true
+	return w
+}
+
 func (w *Writer) Write(ww io.Writer) error {
-	if !w.root.is7BitClean() {
-		w.root.Header.Set("Content-Transfer-Encoding", "base64")
-	}
+	w.setTransferEncoding(w.root)
 
 	msgWriter, err := message.CreateWriter(ww, w.root.Header)
 	if err != nil {
@@ -67,9 +74,7 @@ func (w *Writer) write(writer *message.Writer, p *Part) error {
 }
 
 func (w *Writer) writeAsChild(writer *message.Writer, p *Part) error {
-	if !p.is7BitClean() {
-		p.Header.Set("Content-Transfer-Encoding", "base64")
-	}
+	w.setTransferEncoding(p)
 
 	childWriter, err := writer.CreatePart(p.Header)
 	if err != nil {
@@ -82,3 +87,14 @@ func (w *Writer) writeAsChild(writer *message.Writer, p *Part) error {
 
 	return childWriter.Close()
 }
+
+func (w *Writer) setTransferEncoding(p *Part) {
+	switch {
+	case p.is7BitClean():
+		return
+	case w.allow8Bit && p.is8BitClean():
+		p.Header.Set("Content-Transfer-Encoding", "8bit")
+	default:
+		p.Header.Set("Content-Transfer-Encoding", "base64")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParserWrite(t *testing.T) {
@@ -39,3 +40,41 @@ func TestParserWrite(t *testing.T) {
 func crlf(s string) string {
 	return strings.ReplaceAll(s, "\r\n", "\n")
 }
+
+func TestParserWrite8Bit(t *testing.T) {
+	msg := "Content-Type: multipart/mixed; boundary=longrandomstring\r\n" +
+		"\r\n" +
+		"--longrandomstring\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"žluťoučký kůň\r\n" +
+		"--longrandomstring\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"\r\n" +
+		"\x00\x80\r\n" +
+		"--longrandomstring--\r\n"
+
+	write := func(allow8Bit bool) string {
+		p, err := New(strings.NewReader(msg))
+		require.NoError(t, err)
+
+		w := p.NewWriter()
+		if allow8Bit {
+			w = w.Allow8Bit()
+		}
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, w.Write(buf))
+
+		return buf.String()
+	}
+
+	with8Bit := write(true)
+	assert.Contains(t, with8Bit, "Content-Transfer-Encoding: 8bit\r\n")
+	assert.Contains(t, with8Bit, "\r\n\r\nžluťoučký kůň\r\n")
+	assert.Equal(t, 1, strings.Count(with8Bit, "Content-Transfer-Encoding: base64"))
+
+	without8Bit := write(false)
+	assert.NotContains(t, without8Bit, "žluťoučký")
+	assert.Equal(t, 2, strings.Count(without8Bit, "Content-Transfer-Encoding: base64"))
+}
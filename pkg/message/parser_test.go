@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,6 +76,63 @@ func TestParseTextPlainLatin1(t *testing.T) {
 	assert.Len(t, attReaders, 0)
 }
 
+func TestParseMake7Bit(t *testing.T) {
+	f := getFileReader("text_plain_latin1.eml")
+
+	_, mimeBody, _, _, err := Parse(f, "", "")
+	require.NoError(t, err)
+	assert.Contains(t, mimeBody, "ééééééé")
+
+	mimeBody7Bit, err := Make7Bit(mimeBody)
+	require.NoError(t, err)
+	assert.NotContains(t, mimeBody7Bit, "ééééééé")
+	assert.Contains(t, mimeBody7Bit, "Content-Transfer-Encoding: base64")
+}
+
+func TestParseRemoveHeaders(t *testing.T) {
+	f := getFileReader("text_plain_latin1.eml")
+
+	_, mimeBody, _, _, err := Parse(f, "", "")
+	require.NoError(t, err)
+	assert.Contains(t, mimeBody, "To: ")
+
+	mimeBody, err = RemoveHeaders(mimeBody, "To")
+	require.NoError(t, err)
+	assert.NotContains(t, mimeBody, "To: ")
+	assert.Contains(t, mimeBody, "From: ")
+	assert.Contains(t, mimeBody, "ééééééé")
+}
+
+func TestParseSetHeaders(t *testing.T) {
+	f := getFileReader("text_plain_latin1.eml")
+
+	_, mimeBody, _, _, err := Parse(f, "", "")
+	require.NoError(t, err)
+
+	mimeBody, err = SetHeaders(mimeBody, map[string]string{"X-Archived": "yes"})
+	require.NoError(t, err)
+	assert.Contains(t, mimeBody, "X-Archived: yes")
+	assert.Contains(t, mimeBody, "ééééééé")
+}
+
+func TestAddFooter(t *testing.T) {
+	assert.Equal(t, "body\n\nfooter", AddFooter("body", "text/plain", "footer"))
+	assert.Equal(t, "<b>body</b><br><br>a &lt; b<br>c", AddFooter("<b>body</b>", "text/html", "a < b\nc"))
+	assert.Equal(t, "<html><BODY>body<br><br>footer</BODY></html>", AddFooter("<html><BODY>body</BODY></html>", "text/html", "footer"))
+}
+
+func TestParseAddFooterToMIMEBody(t *testing.T) {
+	f := getFileReader("text_plain_plain_attachment.eml")
+
+	_, mimeBody, _, _, err := Parse(f, "", "")
+	require.NoError(t, err)
+
+	mimeBody, err = AddFooterToMIMEBody(mimeBody, "footer")
+	require.NoError(t, err)
+	assert.Contains(t, mimeBody, "body\n\nfooter")
+	assert.Equal(t, 1, strings.Count(mimeBody, "footer"))
+}
+
 func TestParseTextPlainUTF8Subject(t *testing.T) {
 	f := getFileReader("text_plain_utf8_subject.eml")
 
@@ -20,6 +20,7 @@ package message
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -157,6 +158,50 @@ func NewBodyStructure(reader io.Reader) (structure *BodyStructure, err error) {
 	return
 }
 
+// persistentSectionInfo is the part of sectionInfo which can be stored.
+type persistentSectionInfo struct {
+	Header                    textproto.MIMEHeader
+	Start, BSize, Size, Lines int
+}
+
+// DeserializeBodyStructure creates body structure from data created by Serialize.
+func DeserializeBodyStructure(raw []byte) (*BodyStructure, error) {
+	persistent := map[string]persistentSectionInfo{}
+	if err := json.Unmarshal(raw, &persistent); err != nil {
+		return nil, err
+	}
+
+	bs := &BodyStructure{}
+	for path, info := range persistent {
+		(*bs)[path] = &sectionInfo{
+			header: info.Header,
+			start:  info.Start,
+			bsize:  info.BSize,
+			size:   info.Size,
+			lines:  info.Lines,
+		}
+	}
+
+	return bs, nil
+}
+
+// Serialize returns the body structure in a form which can be stored
+// and later loaded by DeserializeBodyStructure.
+func (bs *BodyStructure) Serialize() ([]byte, error) {
+	persistent := map[string]persistentSectionInfo{}
+	for path, info := range *bs {
+		persistent[path] = persistentSectionInfo{
+			Header: info.header,
+			Start:  info.start,
+			BSize:  info.bsize,
+			Size:   info.size,
+			Lines:  info.lines,
+		}
+	}
+
+	return json.Marshal(persistent)
+}
+
 func (bs *BodyStructure) Parse(r io.Reader) error {
 	return bs.parseAllChildSections(r, []int{}, 0)
 }
@@ -338,6 +383,26 @@ func (bs *BodyStructure) GetSectionContent(wholeMail io.ReadSeeker, sectionPath
 	*/
 }
 
+// GetSectionReader returns reader of the whole section including its header.
+// It reads directly from wholeMail so the section is not copied to memory.
+func (bs *BodyStructure) GetSectionReader(wholeMail io.ReaderAt, sectionPath []int) (*io.SectionReader, error) {
+	info, err := bs.getInfo(sectionPath)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(wholeMail, int64(info.start), int64(info.size)), nil
+}
+
+// GetSectionContentReader returns reader of the section content without its header.
+// It reads directly from wholeMail so the section is not copied to memory.
+func (bs *BodyStructure) GetSectionContentReader(wholeMail io.ReaderAt, sectionPath []int) (*io.SectionReader, error) {
+	info, err := bs.getInfo(sectionPath)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(wholeMail, int64(info.start+info.size-info.bsize), int64(info.bsize)), nil
+}
+
 func (bs *BodyStructure) GetSectionHeader(sectionPath []int) (header textproto.MIMEHeader, err error) {
 	info, err := bs.getInfo(sectionPath)
 	if err != nil {
@@ -19,9 +19,11 @@ package message
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -106,6 +108,65 @@ func TestGetSection(t *testing.T) {
 	}
 }
 
+func TestGetSectionReader(t *testing.T) {
+	bs, err := NewBodyStructure(strings.NewReader(sampleMail))
+	require.NoError(t, err)
+
+	mailReader := strings.NewReader(sampleMail)
+	for path := range *bs {
+		sectionPath := []int{}
+		if path != "" {
+			for _, n := range strings.Split(path, ".") {
+				i, err := strconv.Atoi(n)
+				require.NoError(t, err)
+				sectionPath = append(sectionPath, i)
+			}
+		}
+
+		want, err := bs.GetSection(mailReader, sectionPath)
+		require.NoError(t, err)
+		r, err := bs.GetSectionReader(mailReader, sectionPath)
+		require.NoError(t, err)
+		have, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(have))
+
+		want, err = bs.GetSectionContent(mailReader, sectionPath)
+		require.NoError(t, err)
+		r, err = bs.GetSectionContentReader(mailReader, sectionPath)
+		require.NoError(t, err)
+		have, err = ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(have))
+	}
+}
+
+func TestSerializeBodyStructure(t *testing.T) {
+	bs, err := NewBodyStructure(strings.NewReader(sampleMail))
+	require.NoError(t, err)
+
+	raw, err := bs.Serialize()
+	require.NoError(t, err)
+
+	restored, err := DeserializeBodyStructure(raw)
+	require.NoError(t, err)
+
+	wantIMAP, err := bs.IMAPBodyStructure([]int{})
+	require.NoError(t, err)
+	haveIMAP, err := restored.IMAPBodyStructure([]int{})
+	require.NoError(t, err)
+	require.Equal(t, wantIMAP, haveIMAP)
+
+	require.Equal(t, len(*bs), len(*restored))
+	for path, info := range *bs {
+		require.Equal(t, info.header, (*restored)[path].header)
+		require.Equal(t, info.start, (*restored)[path].start)
+		require.Equal(t, info.bsize, (*restored)[path].bsize)
+		require.Equal(t, info.size, (*restored)[path].size)
+		require.Equal(t, info.lines, (*restored)[path].lines)
+	}
+}
+
 /* Structure example:
 HEADER     ([RFC-2822] header of the message)
 TEXT       ([RFC-2822] text body of the message) MULTIPART/MIXED
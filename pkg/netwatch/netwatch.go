@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+// Package netwatch notifies about changes of the network, e.g. switching to
+// other Wi-Fi, VPN going up or down or resume from sleep. Connections opened
+// before such change are often broken without being closed and requests
+// using them hang until long TCP timeouts.
+package netwatch
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// settleTime groups bursts of changes (e.g. link and addresses of one
+	// interface going up) into one notification.
+	settleTime = 2 * time.Second
+
+	// clockCheckInterval is how often the wall clock is compared with the
+	// monotonic clock to find out the computer was asleep.
+	clockCheckInterval = 5 * time.Second
+
+	// sleepThreshold is how much more the wall clock has to move than the
+	// monotonic clock to count as sleep.
+	sleepThreshold = 30 * time.Second
+)
+
+var log = logrus.WithField("pkg", "netwatch") //nolint[gochecknoglobals]
+
+type panicHandler interface {
+	HandlePanic()
+}
+
+// Watch calls onChange when the network changed or the computer woke up
+// from sleep. It never returns.
+func Watch(panicHandler panicHandler, onChange func()) {
+	changes := make(chan string, 1)
+	notify := func(reason string) {
+		select {
+		case changes <- reason:
+		default:
+		}
+	}
+
+	go func() {
+		defer panicHandler.HandlePanic()
+		watchClock(notify)
+	}()
+
+	go func() {
+		defer panicHandler.HandlePanic()
+		if err := watchInterfaces(notify); err != nil {
+			log.WithError(err).Warn("Cannot watch network interfaces, only resume from sleep is detected")
+		}
+	}()
+
+	for reason := range changes {
+		settle(changes, settleTime)
+		log.WithField("reason", reason).Info("Network changed")
+		onChange()
+	}
+}
+
+// settle waits until no change comes for the given time.
+func settle(changes <-chan string, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-changes:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(wait)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// watchClock detects resume from sleep by the wall clock jumping ahead. The
+// monotonic clock does not count the time spent asleep on most systems.
+func watchClock(notify func(string)) {
+	ticker := time.NewTicker(clockCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		// Round(0) strips the monotonic reading, so Sub uses the wall clock.
+		if wasAsleep(now.Round(0).Sub(last.Round(0)), now.Sub(last)) {
+			notify("resume")
+		}
+		last = now
+	}
+}
+
+// wasAsleep returns whether the wall clock moved much more than the monotonic
+// clock in the same time.
+func wasAsleep(wall, monotonic time.Duration) bool {
+	return wall-monotonic > sleepThreshold
+}
+
+// virtualPrefixes are names of interfaces of containers and virtual machines
+// on this computer. They come and go without any change of the connection
+// to the internet. Interfaces of VPNs (tun, wg, ppp...) are not among them.
+var virtualPrefixes = []string{ //nolint[gochecknoglobals]
+	"docker", "veth", "br-", "virbr", "vboxnet", "vmnet", "cni", "flannel",
+	"cali", "lxc", "lxd", "podman", "vnet", "bridge", "awdl", "llw", "anpi",
+}
+
+// isVirtual returns whether the interface is local to the computer.
+func isVirtual(name string) bool {
+	for _, prefix := range virtualPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressKey returns what matters of the address for the connection to the
+// internet: IPv4 address and IPv6 network, so rotation of IPv6 temporary
+// addresses (privacy extensions) is not a change. Link-local addresses do
+// not matter at all.
+func addressKey(addr net.Addr) (string, bool) {
+	ipNet, ok := addr.(*net.IPNet)
+	if !ok {
+		return addr.String(), true
+	}
+	if ipNet.IP.IsLinkLocalUnicast() {
+		return "", false
+	}
+	if ipNet.IP.To4() != nil {
+		return ipNet.String(), true
+	}
+	network := &net.IPNet{IP: ipNet.IP.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return network.String(), true
+}
+
+// fingerprint describes addresses of interfaces which are up, except
+// loopback and virtual ones. It changes when a network is joined or left.
+func fingerprint() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.WithError(err).Warn("Cannot list network interfaces")
+		return ""
+	}
+
+	var items []string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || isVirtual(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if key, ok := addressKey(addr); ok {
+				items = append(items, iface.Name+" "+key)
+			}
+		}
+	}
+
+	// Duplicates come from more IPv6 addresses in one network.
+	sort.Strings(items)
+	unique := items[:0]
+	for i, item := range items {
+		if i == 0 || item != items[i-1] {
+			unique = append(unique, item)
+		}
+	}
+	return strings.Join(unique, "\n")
+}
+
+// addressWatcher notifies only when the fingerprint really changed, so
+// events not affecting addresses (e.g. Wi-Fi signal) are ignored.
+type addressWatcher struct {
+	last   string
+	notify func(string)
+}
+
+func newAddressWatcher(notify func(string)) *addressWatcher {
+	return &addressWatcher{last: fingerprint(), notify: notify}
+}
+
+func (w *addressWatcher) check() {
+	if current := fingerprint(); current != w.last {
+		w.last = current
+		w.notify("addresses")
+	}
+}
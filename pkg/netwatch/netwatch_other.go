@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+// +build !linux
+
+package netwatch
+
+import "time"
+
+// interfacesCheckInterval is how often addresses are compared on systems
+// without rtnetlink.
+const interfacesCheckInterval = 5 * time.Second
+
+// watchInterfaces compares addresses of interfaces periodically.
+func watchInterfaces(notify func(string)) error {
+	w := newAddressWatcher(notify)
+
+	ticker := time.NewTicker(interfacesCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.check()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package netwatch
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasAsleep(t *testing.T) {
+	assert.False(t, wasAsleep(5*time.Second, 5*time.Second))
+	assert.False(t, wasAsleep(6*time.Second, 5*time.Second))
+	assert.True(t, wasAsleep(2*time.Hour, 5*time.Second))
+}
+
+func TestSettle(t *testing.T) {
+	changes := make(chan string, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(20 * time.Millisecond)
+			changes <- "addresses"
+		}
+	}()
+
+	start := time.Now()
+	settle(changes, 50*time.Millisecond)
+	require.True(t, time.Since(start) >= 110*time.Millisecond)
+	require.Empty(t, changes)
+}
+
+func TestAddressWatcherNotifiesOnlyChanges(t *testing.T) {
+	var reasons []string
+	w := newAddressWatcher(func(reason string) { reasons = append(reasons, reason) })
+
+	w.check()
+	assert.Empty(t, reasons)
+
+	w.last = "wlan0 192.168.1.10/24"
+	w.check()
+	assert.Equal(t, []string{"addresses"}, reasons)
+}
+
+func TestIsVirtual(t *testing.T) {
+	for _, name := range []string{"docker0", "veth1a2b3c", "br-0123abcd", "virbr0", "vboxnet0"} {
+		assert.True(t, isVirtual(name), name)
+	}
+	for _, name := range []string{"eth0", "wlan0", "wlp3s0", "en0", "tun0", "wg0", "ppp0"} {
+		assert.False(t, isVirtual(name), name)
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	parse := func(cidr string) net.Addr {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ipNet.IP = ip
+		return ipNet
+	}
+
+	key, ok := addressKey(parse("192.168.1.10/24"))
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.10/24", key)
+
+	_, ok = addressKey(parse("fe80::1c2b:3aff:fe4d:5e6f/64"))
+	assert.False(t, ok)
+	_, ok = addressKey(parse("169.254.10.20/16"))
+	assert.False(t, ok)
+
+	// Temporary IPv6 addresses of one network are the same.
+	first, ok := addressKey(parse("2001:db8:1:2:a1b2:c3d4:e5f6:1/64"))
+	assert.True(t, ok)
+	second, _ := addressKey(parse("2001:db8:1:2:9f8e:7d6c:5b4a:2/64"))
+	assert.Equal(t, "2001:db8:1:2::/64", first)
+	assert.Equal(t, first, second)
+}
@@ -22,6 +22,8 @@ import (
 	"strings"
 
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Address statuses.
@@ -72,6 +74,7 @@ type Address struct {
 	Signature   string
 	MemberID    string `json:",omitempty"`
 	MemberName  string `json:",omitempty"`
+	CatchAll    bool   `json:",omitempty"`
 
 	HasKeys int
 	Keys    PMKeys
@@ -125,15 +128,43 @@ func (l AddressList) Main() *Address {
 
 // ByEmail gets an address by email. Returns nil if no address is found.
 func (l AddressList) ByEmail(email string) *Address {
-	email = SanitizeEmail(email)
+	email = NormalizeEmail(SanitizeEmail(email))
 	for _, addr := range l {
-		if strings.EqualFold(addr.Email, email) {
+		if strings.EqualFold(NormalizeEmail(addr.Email), email) {
 			return addr
 		}
 	}
 	return nil
 }
 
+// ByEmailForSending gets an address which can send as the email, i.e. the
+// address itself, its plus alias or any address under the custom domain of
+// a catch-all address. Returns nil if no address is found.
+func (l AddressList) ByEmailForSending(email string) *Address {
+	if addr := l.ByEmail(email); addr != nil {
+		return addr
+	}
+
+	domain := emailDomain(NormalizeEmail(email))
+	if domain == "" {
+		return nil
+	}
+	for _, addr := range l {
+		if addr.CatchAll && addr.Status == EnabledAddress && emailDomain(NormalizeEmail(addr.Email)) == domain {
+			return addr
+		}
+	}
+	return nil
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return email[at+1:]
+}
+
 func SanitizeEmail(email string) string {
 	splitAt := strings.Split(email, "@")
 	if len(splitAt) != 2 {
@@ -144,6 +175,25 @@ func SanitizeEmail(email string) string {
 	return email
 }
 
+// NormalizeEmail returns internationalized email (RFC6530) in one form so it can
+// be compared: domain is converted from punycode to lowercase unicode and the
+// whole address is in unicode normalization form C.
+func NormalizeEmail(email string) string {
+	email = norm.NFC.String(email)
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	domain, err := idna.Lookup.ToUnicode(email[at+1:])
+	if err != nil {
+		return email
+	}
+
+	return email[:at+1] + domain
+}
+
 func ConstructAddress(headerEmail string, addressEmail string) string {
 	splitAtHeader := strings.Split(headerEmail, "@")
 	if len(splitAtHeader) != 2 {
@@ -82,3 +82,43 @@ func TestAddressList(t *testing.T) {
 		t.Errorf("Main() expected:\n%v\n but have:\n%v\n", testAddressList[1], addr)
 	}
 }
+
+func TestNormalizeEmail(t *testing.T) {
+	testData := map[string]string{
+		"root@protonmail.com":       "root@protonmail.com",
+		"jöe@xn--bcher-kva.de":      "jöe@bücher.de",
+		"jöe@BÜCHER.de":             "jöe@bücher.de",
+		"jo\u0308e@bu\u0308cher.de": "jöe@bücher.de",
+		"用户@例子.广告":                  "用户@例子.广告",
+		"not an address":            "not an address",
+	}
+
+	for input, want := range testData {
+		if have := NormalizeEmail(input); have != want {
+			t.Errorf("NormalizeEmail(%q) expected %q but have %q", input, want, have)
+		}
+	}
+}
+
+func TestAddressListByEmailForSending(t *testing.T) {
+	catchAll := &Address{ID: "4", Email: "me@custom.org", Status: EnabledAddress, CatchAll: true}
+	disabledCatchAll := &Address{ID: "5", Email: "me@disabled.org", Status: DisabledAddress, CatchAll: true}
+	addresses := append(AddressList{catchAll, disabledCatchAll}, testAddressList...)
+
+	testData := map[string]*Address{
+		"root@nsa.gov":          testAddressList[0],
+		"root+tag@nsa.gov":      testAddressList[0],
+		"me@custom.org":         catchAll,
+		"anything@CUSTOM.org":   catchAll,
+		"anyone+tag@custom.org": catchAll,
+		"anything@nsa.gov":      nil,
+		"anything@disabled.org": nil,
+		"not an address":        nil,
+	}
+
+	for input, want := range testData {
+		if have := addresses.ByEmailForSending(input); have != want {
+			t.Errorf("ByEmailForSending(%q) expected %v but have %v", input, want, have)
+		}
+	}
+}
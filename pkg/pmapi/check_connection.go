@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
 )
 
 const protonStatusURL = "http://protonstatus.com/vpn_status"
@@ -33,7 +35,7 @@ var ErrNoInternetConnection = errors.New("no internet connection")
 // This should be moved to the ConnectionManager when it is implemented.
 func (cm *ClientManager) CheckConnection() error {
 	// We use a normal dialer here which doesn't check tls fingerprints.
-	client := &http.Client{Timeout: time.Second * 10}
+	client := newCheckConnectionClient()
 
 	// Do not cumulate timeouts, use goroutines.
 	retStatus := make(chan error)
@@ -70,12 +72,19 @@ func (cm *ClientManager) CheckConnection() error {
 
 // CheckConnection returns an error if there is no internet connection.
 func CheckConnection() error {
-	client := &http.Client{Timeout: time.Second * 10}
+	client := newCheckConnectionClient()
 	retStatus := make(chan error)
 	go checkConnection(client, protonStatusURL, retStatus)
 	return <-retStatus
 }
 
+func newCheckConnectionClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{Proxy: sysproxy.Proxy},
+		Timeout:   time.Second * 10,
+	}
+}
+
 func checkConnection(client *http.Client, url string, errorChannel chan error) {
 	resp, err := client.Get(url)
 	if err != nil {
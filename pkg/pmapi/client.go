@@ -121,6 +121,12 @@ type client struct {
 	addrKeyRing map[string]*crypto.KeyRing
 	keyRingLock sync.Locker
 
+	// rateLimitedUntil is set when API responds by 429. All requests of the
+	// client wait until then, so parallel requests (e.g. sync workers) do
+	// not keep hitting the limit.
+	rateLimitedUntil time.Time
+	rateLimitLock    sync.Mutex
+
 	log *logrus.Entry
 }
 
@@ -128,7 +134,7 @@ type client struct {
 func newClient(cm *ClientManager, userID string) *client {
 	return &client{
 		cm:            cm,
-		hc:            getHTTPClient(cm.config, cm.roundTripper, cm.cookieJar),
+		hc:            getHTTPClient(cm.config, cm.throttle.roundTripper(cm.roundTripper), cm.cookieJar),
 		userID:        userID,
 		requestLocker: &sync.Mutex{},
 		refreshLocker: &sync.Mutex{},
@@ -257,7 +263,8 @@ func (c *client) doBuffered(req *http.Request, bodyBuffer []byte, retryUnauthori
 		c.log.Tracef("REQBODY '%s'", printBytes(bodyBuffer))
 	}
 
-	hasBody := len(bodyBuffer) > 0
+	c.waitForRateLimit()
+
 	if res, err = c.hc.Do(req); err != nil {
 		if res == nil {
 			c.log.WithError(err).Error("Cannot get response")
@@ -276,17 +283,10 @@ func (c *client) doBuffered(req *http.Request, bodyBuffer []byte, retryUnauthori
 		}
 	}
 
-	if res.StatusCode == http.StatusUnauthorized {
-		if hasBody {
-			r := bytes.NewReader(bodyBuffer)
-			req.Body = ioutil.NopCloser(r)
-		}
-
-		if !isAuthReq {
-			_, _ = io.Copy(ioutil.Discard, res.Body)
-			_ = res.Body.Close()
-			return c.handleStatusUnauthorized(req, bodyBuffer, res, retryUnauthorized)
-		}
+	if res.StatusCode == http.StatusUnauthorized && !isAuthReq {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		_ = res.Body.Close()
+		return c.handleStatusUnauthorized(req, bodyBuffer, res, retryUnauthorized)
 	}
 
 	// Retry induced by HTTP status code>
@@ -299,21 +299,62 @@ func (c *client) doBuffered(req *http.Request, bodyBuffer []byte, retryUnauthori
 		// To avoid spikes when all clients retry at the same time, we add some random wait.
 		retryAfter += rand.Intn(10)
 
-		if hasBody {
-			r := bytes.NewReader(bodyBuffer)
-			req.Body = ioutil.NopCloser(r)
-		}
-
 		c.log.Warningf("Retrying %s after %ds induced by http code %d", req.URL.Path, retryAfter, res.StatusCode)
-		time.Sleep(time.Duration(retryAfter) * time.Second)
+		c.setRateLimited(time.Duration(retryAfter) * time.Second)
 		_, _ = io.Copy(ioutil.Discard, res.Body)
 		_ = res.Body.Close()
+		if err = rewindBody(req, bodyBuffer); err != nil {
+			return nil, err
+		}
 		return c.doBuffered(req, bodyBuffer, false)
 	}
 
 	return res, err
 }
 
+// rewindBody sets the body of the request again before the request is
+// repeated. Buffered body is read from the buffer; requests streamed without
+// the buffer need req.GetBody to create the body again.
+func rewindBody(req *http.Request, bodyBuffer []byte) error {
+	if len(bodyBuffer) > 0 {
+		req.Body = ioutil.NopCloser(bytes.NewReader(bodyBuffer))
+		return nil
+	}
+
+	if req.GetBody == nil {
+		return nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return errors.Wrap(err, "cannot rewind request body")
+	}
+	req.Body = body
+	return nil
+}
+
+// setRateLimited holds all requests of the client for the given duration.
+func (c *client) setRateLimited(wait time.Duration) {
+	c.rateLimitLock.Lock()
+	defer c.rateLimitLock.Unlock()
+
+	if until := time.Now().Add(wait); until.After(c.rateLimitedUntil) {
+		c.rateLimitedUntil = until
+	}
+}
+
+// waitForRateLimit waits until the client is not rate limited anymore.
+func (c *client) waitForRateLimit() {
+	c.rateLimitLock.Lock()
+	wait := time.Until(c.rateLimitedUntil)
+	c.rateLimitLock.Unlock()
+
+	if wait > 0 {
+		c.log.WithField("wait", wait).Debug("Waiting for API rate limit")
+		time.Sleep(wait)
+	}
+}
+
 // DoJSON performs the request and unmarshals the response as JSON into data.
 // If the API returns a non-2xx HTTP status code, the error returned will contain status
 // and response as plaintext. API errors must be checked by the caller.
@@ -397,8 +438,8 @@ func (c *client) doJSONBuffered(req *http.Request, reqBodyBuffer []byte, data in
 			retryAfter := 3
 			c.log.Warningf("Retrying %s after %ds induced by API code %d", req.URL.Path, retryAfter, errCode.Code)
 			time.Sleep(time.Duration(retryAfter) * time.Second)
-			if len(reqBodyBuffer) > 0 {
-				req.Body = ioutil.NopCloser(bytes.NewReader(reqBodyBuffer))
+			if err := rewindBody(req, reqBodyBuffer); err != nil {
+				return err
 			}
 			return c.doJSONBuffered(req, reqBodyBuffer, data)
 		}
@@ -495,6 +536,9 @@ func (c *client) handleStatusUnauthorized(req *http.Request, reqBodyBuffer []byt
 
 		_, _ = io.Copy(ioutil.Discard, res.Body)
 		_ = res.Body.Close()
+		if err = rewindBody(req, reqBodyBuffer); err != nil {
+			return
+		}
 		return c.doBuffered(req, reqBodyBuffer, true)
 	}
 
@@ -509,5 +553,8 @@ func (c *client) handleStatusUnauthorized(req *http.Request, reqBodyBuffer []byt
 		c.log.WithError(err).Warn("Failed to read out response body")
 	}
 	_ = res.Body.Close()
+	if err = rewindBody(req, reqBodyBuffer); err != nil {
+		return
+	}
 	return c.doBuffered(req, reqBodyBuffer, true)
 }
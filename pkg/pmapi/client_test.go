@@ -108,6 +108,24 @@ func TestClient_DoRetryAfter(t *testing.T) {
 	require.True(t, isInRange, "Waited time: %v", waitedTime)
 }
 
+func TestClient_RateLimitHoldsOtherRequests(t *testing.T) {
+	finish, c := newTestServerCallbacks(t,
+		func(tb testing.TB, w http.ResponseWriter, req *http.Request) string {
+			w.Header().Set("content-type", "application/json;charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			return "/HTTP_200.json"
+		},
+	)
+	defer finish()
+
+	// Another request was rate limited meanwhile.
+	c.setRateLimited(time.Second)
+
+	start := time.Now()
+	require.Nil(t, c.SendSimpleMetric("some_category", "some_action", "some_label"))
+	require.True(t, time.Since(start) >= time.Second, "Waited time: %v", time.Since(start))
+}
+
 type slowTransport struct {
 	transport      http.RoundTripper
 	firstBodySleep time.Duration
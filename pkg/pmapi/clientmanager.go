@@ -38,6 +38,8 @@ type ClientManager struct {
 
 	config       *ClientConfig
 	roundTripper http.RoundTripper
+	connTracker  *TrackingTLSDialer
+	throttle     *Throttle
 
 	clients       map[string]Client
 	clientsLocker sync.Locker
@@ -88,6 +90,7 @@ func NewClientManager(config *ClientConfig) (cm *ClientManager) {
 	cm = &ClientManager{
 		config:       config,
 		roundTripper: http.DefaultTransport,
+		throttle:     newThrottle(),
 
 		clients:       make(map[string]Client),
 		clientsLocker: &sync.Mutex{},
@@ -137,6 +140,34 @@ func (cm *ClientManager) SetRoundTripper(rt http.RoundTripper) {
 	cm.roundTripper = rt
 }
 
+// SetConnectionTracker sets the dialer tracking connections of the roundtripper,
+// so ResetConnections can close also broken connections in use.
+func (cm *ClientManager) SetConnectionTracker(tracker *TrackingTLSDialer) {
+	cm.connTracker = tracker
+}
+
+// SetThrottle limits the total bandwidth in bytes per second and the number
+// of parallel requests of all clients. Zero means unlimited.
+func (cm *ClientManager) SetThrottle(bytesPerSecond int64, parallel int) {
+	cm.throttle.SetLimits(bytesPerSecond, parallel)
+}
+
+// ResetConnections closes idle connections to API and connections in use
+// whose local address is gone. It is used when the network changed and the
+// connections are possibly broken; requests waiting on broken ones fail right
+// away, other requests finish, and new requests open new connections.
+func (cm *ClientManager) ResetConnections() {
+	cm.clientsLocker.Lock()
+	for _, client := range cm.clients {
+		client.CloseConnections()
+	}
+	cm.clientsLocker.Unlock()
+
+	if cm.connTracker != nil {
+		cm.connTracker.CloseBrokenConnections()
+	}
+}
+
 func (cm *ClientManager) SetUserAgent(clientName, clientVersion, os string) {
 	cm.config.UserAgent = formatUserAgent(clientName, clientVersion, os)
 }
@@ -19,6 +19,8 @@ package pmapi
 
 import (
 	"net/http"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
 )
 
 // rootURL is the API root URL.
@@ -32,7 +34,7 @@ var rootScheme = "https"          //nolint[gochecknoglobals]
 
 // The HTTP transport to use by default.
 var defaultTransport = &http.Transport{ //nolint[gochecknoglobals]
-	Proxy: http.ProxyFromEnvironment,
+	Proxy: sysproxy.Proxy,
 }
 
 // checkTLSCerts controls whether TLS certs are checked against known fingerprints.
@@ -24,6 +24,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
 )
 
 func init() {
@@ -40,7 +42,7 @@ func init() {
 
 	// TLS certificate of testing environment might be self-signed.
 	defaultTransport = &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
+		Proxy:           sysproxy.Proxy,
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	}
 
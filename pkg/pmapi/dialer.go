@@ -31,9 +31,10 @@ type TLSDialer interface {
 // CreateTransportWithDialer creates an http.Transport that uses the given dialer to make TLS connections.
 func CreateTransportWithDialer(dialer TLSDialer) *http.Transport {
 	return &http.Transport{
+		// No Proxy here: the transport would then make TLS itself and skip
+		// the dialer. BasicTLSDialer goes through the proxy instead.
 		DialTLS: dialer.DialTLS,
 
-		Proxy:                 http.ProxyFromEnvironment,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       5 * time.Minute,
 		ExpectContinueTimeout: 500 * time.Millisecond,
@@ -57,7 +58,8 @@ func NewBasicTLSDialer() *BasicTLSDialer {
 	return &BasicTLSDialer{}
 }
 
-// DialTLS returns a connection to the given address using the given network.
+// DialTLS returns a connection to the given address using the given network,
+// through the proxy of the system if any.
 func (b *BasicTLSDialer) DialTLS(network, address string) (conn net.Conn, err error) {
 	dialer := &net.Dialer{Timeout: 30 * time.Second} // Alternative Routes spec says this should be a 30s timeout.
 
@@ -68,5 +70,30 @@ func (b *BasicTLSDialer) DialTLS(network, address string) (conn net.Conn, err er
 		tlsConfig = &tls.Config{InsecureSkipVerify: true} // nolint[gosec]
 	}
 
-	return tls.DialWithDialer(dialer, network, address, tlsConfig)
+	rawConn, err := dialThroughProxy(dialer, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		tlsConfig.ServerName = host
+	}
+
+	tlsConn := tls.Client(rawConn, tlsConfig)
+
+	_ = rawConn.SetDeadline(time.Now().Add(dialer.Timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		_ = rawConn.Close()
+		return nil, err
+	}
+	_ = rawConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
 }
@@ -20,6 +20,7 @@ package pmapi
 import (
 	"crypto/tls"
 	"net"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -44,6 +45,12 @@ type PinningTLSDialer struct {
 	// enableRemoteReporting instructs the dialer to report TLS mismatches.
 	enableRemoteReporting bool
 
+	// tlsExceptions allow servers with keys which are not pinned.
+	tlsExceptions        []TLSException
+	tlsExceptionNotifier func(host string)
+	tlsExceptionsUsed    map[string]bool
+	tlsExceptionsLock    sync.Mutex
+
 	// A logger for logging messages.
 	log logrus.FieldLogger
 }
@@ -53,9 +60,10 @@ type PinningTLSDialer struct {
 // If enabled, it reports any invalid certificates it finds.
 func NewPinningTLSDialer(dialer TLSDialer) *PinningTLSDialer {
 	return &PinningTLSDialer{
-		dialer:     dialer,
-		pinChecker: newPinChecker(TrustedAPIPins),
-		log:        logrus.WithField("pkg", "pmapi/tls-pinning"),
+		dialer:            dialer,
+		pinChecker:        newPinChecker(TrustedAPIPins),
+		tlsExceptionsUsed: map[string]bool{},
+		log:               logrus.WithField("pkg", "pmapi/tls-pinning"),
 	}
 }
 
@@ -69,6 +77,14 @@ func (p *PinningTLSDialer) EnableRemoteTLSIssueReporting(appVersion, userAgent s
 	p.userAgent = userAgent
 }
 
+// SetTLSExceptions sets CAs trusted for some hosts instead of the pinned
+// keys. The notifier is called when an exception is used first time for
+// the host, so the user is warned.
+func (p *PinningTLSDialer) SetTLSExceptions(exceptions []TLSException, notifier func(host string)) {
+	p.tlsExceptions = exceptions
+	p.tlsExceptionNotifier = notifier
+}
+
 // DialTLS dials the given network/address, returning an error if the certificates don't match the trusted pins.
 func (p *PinningTLSDialer) DialTLS(network, address string) (conn net.Conn, err error) {
 	if conn, err = p.dialer.DialTLS(network, address); err != nil {
@@ -81,6 +97,10 @@ func (p *PinningTLSDialer) DialTLS(network, address string) (conn net.Conn, err
 	}
 
 	if err = p.pinChecker.checkCertificate(conn); err != nil {
+		if p.checkTLSExceptions(host, conn) {
+			return conn, nil
+		}
+
 		if p.tlsIssueNotifier != nil {
 			go p.tlsIssueNotifier()
 		}
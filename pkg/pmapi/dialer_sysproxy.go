@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// dialThroughProxy connects to the address directly or through the proxy
+// selected by sysproxy. The proxy is tunnelled here, not by http.Transport,
+// so that TLS of the API is always made by the dialers and checked by
+// PinningTLSDialer, even behind a proxy.
+func dialThroughProxy(dialer *net.Dialer, network, address string) (net.Conn, error) {
+	proxyURL, err := sysproxy.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.Dial(network, address)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.Dial(network, address)
+	case "http", "https":
+		return dialHTTPConnect(dialer, proxyURL, address)
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to the address by HTTP CONNECT.
+func dialHTTPConnect(dialer *net.Dialer, proxyURL *url.URL, address string) (conn net.Conn, err error) {
+	proxyAddress := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddress = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddress = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+
+	if proxyURL.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", proxyAddress, &tls.Config{ServerName: proxyURL.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", proxyAddress)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dialer.Timeout != 0 {
+		_ = conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to send CONNECT to proxy")
+	}
+
+	// Reading by the bufio.Reader could swallow the beginning of TLS of the
+	// server if the proxy sent it together with the response; it does not
+	// as the server speaks only after our ClientHello.
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to read CONNECT response of proxy")
+	}
+
+	// Body of successful CONNECT is the tunnel, it must not be read.
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		_ = conn.Close()
+		return nil, errors.Errorf("proxy refused CONNECT: %s", res.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ProtonMail/proton-bridge/pkg/sysproxy"
+	"github.com/stretchr/testify/require"
+)
+
+// newConnectProxy starts HTTP proxy which tunnels CONNECT requests and
+// counts them. The returned func stops it.
+func newConnectProxy(t *testing.T) (*int32, func()) {
+	var tunnels int32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&tunnels, 1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		_, _ = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, client)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(client, upstream)
+		_ = client.Close()
+	}))
+
+	require.NoError(t, sysproxy.SetMode(proxy.URL))
+
+	return &tunnels, func() {
+		require.NoError(t, sysproxy.SetMode(sysproxy.ModeDirect))
+		proxy.Close()
+	}
+}
+
+func TestPinningThroughProxyMismatch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tunnels, stopProxy := newConnectProxy(t)
+	defer stopProxy()
+
+	called := int32(0)
+	dialer := NewPinningTLSDialer(NewBasicTLSDialer())
+	dialer.SetTLSIssueNotifier(func() { atomic.AddInt32(&called, 1) })
+
+	_, err := dialer.DialTLS("tcp", server.Listener.Addr().String())
+	require.Equal(t, ErrTLSMismatch, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(tunnels))
+
+	// The same must hold for requests made by the transport.
+	client := &http.Client{Transport: CreateTransportWithDialer(dialer)}
+	_, err = client.Get(server.URL) //nolint[noctx]
+	require.Error(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(tunnels))
+}
+
+func TestPinningThroughProxyMatch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tunnels, stopProxy := newConnectProxy(t)
+	defer stopProxy()
+
+	dialer := NewPinningTLSDialer(NewBasicTLSDialer())
+	dialer.pinChecker = newPinChecker([]string{certFingerprint(server.Certificate())})
+
+	client := &http.Client{Transport: CreateTransportWithDialer(dialer)}
+	res, err := client.Get(server.URL) //nolint[noctx]
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, int32(1), atomic.LoadInt32(tunnels))
+}
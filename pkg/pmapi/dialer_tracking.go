@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package pmapi
+
+import (
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// interfaceAddrs is replaced in tests.
+var interfaceAddrs = net.InterfaceAddrs //nolint[gochecknoglobals]
+
+// TrackingTLSDialer wraps a TLSDialer to remember opened connections so those
+// broken by network change can be closed, including those in use by requests.
+type TrackingTLSDialer struct {
+	dialer TLSDialer
+
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewTrackingTLSDialer constructs a dialer which tracks connections made by
+// an underlying dialer.
+func NewTrackingTLSDialer(dialer TLSDialer) *TrackingTLSDialer {
+	return &TrackingTLSDialer{
+		dialer: dialer,
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// DialTLS dials the given network/address and remembers the connection until
+// it is closed.
+func (d *TrackingTLSDialer) DialTLS(network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialTLS(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &trackedConn{Conn: conn, dialer: d}
+
+	d.lock.Lock()
+	d.conns[tc] = struct{}{}
+	d.lock.Unlock()
+
+	return tc, nil
+}
+
+// CloseBrokenConnections closes connections whose local address is not
+// assigned to any interface anymore, e.g. after switching to other Wi-Fi.
+// Requests using them fail right away instead of waiting for TCP timeouts.
+// Connections on addresses which are still there keep working.
+func (d *TrackingTLSDialer) CloseBrokenConnections() {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		logrus.WithError(err).Warn("Cannot list addresses to find broken connections")
+		return
+	}
+
+	assigned := map[string]bool{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			assigned[ipNet.IP.String()] = true
+		}
+	}
+
+	d.lock.Lock()
+	var broken []net.Conn
+	for conn := range d.conns {
+		if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok && !assigned[tcpAddr.IP.String()] {
+			broken = append(broken, conn)
+		}
+	}
+	d.lock.Unlock()
+
+	for _, conn := range broken {
+		_ = conn.Close()
+	}
+}
+
+func (d *TrackingTLSDialer) forget(conn net.Conn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.conns, conn)
+}
+
+type trackedConn struct {
+	net.Conn
+
+	dialer *TrackingTLSDialer
+}
+
+func (c *trackedConn) Close() error {
+	c.dialer.forget(c)
+	return c.Conn.Close()
+}
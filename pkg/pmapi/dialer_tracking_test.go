@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+package pmapi
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addrConn is a pipe with the local address of a TCP connection.
+type addrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+type pipeDialer struct {
+	local   net.IP
+	remotes []net.Conn
+}
+
+func (d *pipeDialer) DialTLS(network, address string) (net.Conn, error) {
+	local, remote := net.Pipe()
+	d.remotes = append(d.remotes, remote)
+	return &addrConn{Conn: local, local: &net.TCPAddr{IP: d.local, Port: 50000}}, nil
+}
+
+func TestTrackingDialerClosesBrokenConnections(t *testing.T) {
+	assigned := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)},
+	}
+	prev := interfaceAddrs
+	interfaceAddrs = func() ([]net.Addr, error) { return assigned, nil }
+	defer func() { interfaceAddrs = prev }()
+
+	pipes := &pipeDialer{local: net.ParseIP("192.168.1.10")}
+	dialer := NewTrackingTLSDialer(pipes)
+
+	closed, err := dialer.DialTLS("tcp", "api:443")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	kept, err := dialer.DialTLS("tcp", "api:443")
+	require.NoError(t, err)
+	pipes.local = net.ParseIP("10.0.0.5")
+	_, err = dialer.DialTLS("tcp", "api:443")
+	require.NoError(t, err)
+	assert.Len(t, dialer.conns, 2)
+
+	// Address of the second connection was never assigned, e.g. it was
+	// lost by leaving the network.
+	dialer.CloseBrokenConnections()
+	assert.Len(t, dialer.conns, 1)
+
+	_, err = pipes.remotes[2].Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	// Connection on the address still assigned keeps working.
+	go func() { _, _ = kept.Write([]byte("x")) }()
+	_, err = pipes.remotes[1].Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
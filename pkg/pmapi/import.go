@@ -64,7 +64,12 @@ func (req *ImportReq) WriteTo(w *multipart.Writer) (err error) {
 			return err
 		}
 
-		if _, err = fw.Write(msg.Body); err != nil {
+		if msg.BodyReader != nil {
+			_, err = io.Copy(fw, msg.BodyReader)
+		} else {
+			_, err = fw.Write(msg.Body)
+		}
+		if err != nil {
 			return
 		}
 	}
@@ -72,12 +77,42 @@ func (req *ImportReq) WriteTo(w *multipart.Writer) (err error) {
 	return err
 }
 
+// isRewindable returns whether message bodies can be read again.
+func (req *ImportReq) isRewindable() bool {
+	for _, msg := range req.Messages {
+		if msg.BodyReader == nil {
+			continue
+		}
+		if _, ok := msg.BodyReader.(io.Seeker); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// rewind moves readers of message bodies to the start.
+func (req *ImportReq) rewind() error {
+	for _, msg := range req.Messages {
+		if msg.BodyReader == nil {
+			continue
+		}
+		if _, err := msg.BodyReader.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ImportMsgReq is a request to import a message. All fields are optional except AddressID and Body.
 type ImportMsgReq struct {
 	// The address where the message will be imported.
 	AddressID string
 	// The full MIME message.
 	Body []byte `json:"-"`
+	// The full MIME message read while the request is sent, used instead of
+	// Body so big messages do not need to be kept in memory. It is read from
+	// the start again when the request is repeated if it is an io.Seeker.
+	BodyReader io.Reader `json:"-"`
 
 	// 0: read, 1: unread.
 	Unread int
@@ -121,20 +156,48 @@ type ImportMsgRes struct {
 	MessageID string
 }
 
-// Import imports messages to the user's account.
+// Import imports messages to the user's account. The request is streamed
+// when message bodies can be read again for a repeated request, i.e. when
+// every BodyReader is also an io.Seeker; it is buffered otherwise.
 func (c *client) Import(reqs []*ImportMsgReq) (resps []*ImportMsgRes, err error) {
 	importReq := &ImportReq{Messages: reqs}
 
+	var importRes ImportRes
+	if importReq.isRewindable() {
+		err = c.importStreamed(importReq, &importRes)
+	} else {
+		err = c.importBuffered(importReq, &importRes)
+	}
+	if err != nil {
+		return
+	}
+	if err = importRes.Err(); err != nil {
+		return
+	}
+
+	resps = make([]*ImportMsgRes, len(importRes.Responses))
+	for i, r := range importRes.Responses {
+		resps[i] = &ImportMsgRes{
+			Error:     r.Response.Err(),
+			MessageID: r.Response.MessageID,
+		}
+	}
+
+	return resps, err
+}
+
+// importBuffered sends the import request which is kept in memory by DoJSON
+// in case it needs to be repeated.
+func (c *client) importBuffered(importReq *ImportReq, importRes *ImportRes) (err error) {
 	req, w, err := c.NewMultipartRequest("POST", "/mail/v4/messages/import")
 	if err != nil {
 		return
 	}
 
 	// We will write the request as long as it is sent to the API.
-	var importRes ImportRes
 	done := make(chan error, 1)
 	go (func() {
-		done <- c.DoJSON(req, &importRes)
+		done <- c.DoJSON(req, importRes)
 	})()
 
 	// Write the request.
@@ -143,20 +206,64 @@ func (c *client) Import(reqs []*ImportMsgReq) (resps []*ImportMsgRes, err error)
 	}
 	_ = w.Close()
 
-	if err = <-done; err != nil {
-		return
-	}
-	if err = importRes.Err(); err != nil {
-		return
+	return <-done
+}
+
+// importStreamed sends the import request without keeping it in memory.
+// When the request is repeated, the multipart body is written again with
+// message bodies read from the start.
+func (c *client) importStreamed(importReq *ImportReq, importRes *ImportRes) error {
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	var body *io.PipeReader
+	var written chan struct{}
+
+	// stopWriting waits until message bodies are not read anymore.
+	stopWriting := func() {
+		if body != nil {
+			_ = body.Close()
+			<-written
+		}
 	}
+	defer stopWriting()
 
-	resps = make([]*ImportMsgRes, len(importRes.Responses))
-	for i, r := range importRes.Responses {
-		resps[i] = &ImportMsgRes{
-			Error:     r.Response.Err(),
-			MessageID: r.Response.MessageID,
+	getBody := func() (io.ReadCloser, error) {
+		stopWriting()
+
+		if err := importReq.rewind(); err != nil {
+			return nil, err
 		}
+
+		pr, pw := io.Pipe()
+		body, written = pr, make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+
+			w := multipart.NewWriter(pw)
+			err := w.SetBoundary(boundary)
+			if err == nil {
+				err = importReq.WriteTo(w)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			_ = pw.CloseWithError(err)
+		}(written)
+
+		return pr, nil
 	}
 
-	return resps, err
+	reqBody, err := getBody()
+	if err != nil {
+		return err
+	}
+
+	req, err := c.NewRequest("POST", "/mail/v4/messages/import", reqBody)
+	if err != nil {
+		return err
+	}
+	req.GetBody = getBody
+	req.Header.Add("Content-Type", "multipart/form-data; boundary="+boundary)
+
+	return c.doJSONBuffered(req, nil, importRes)
 }
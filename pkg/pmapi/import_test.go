@@ -18,6 +18,7 @@
 package pmapi
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -25,6 +26,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	pmmime "github.com/ProtonMail/proton-bridge/pkg/mime"
@@ -154,3 +156,62 @@ func TestClient_Import(t *testing.T) { // nolint[funlen]
 		t.Errorf("Invalid response for imported message: expected %+v but got %+v", testImportRes, imported[0])
 	}
 }
+
+func TestImportReq_WriteToBodyReader(t *testing.T) {
+	req := &ImportReq{Messages: []*ImportMsgReq{{
+		AddressID:  "addressID",
+		BodyReader: strings.NewReader("Hello World!"),
+		LabelIDs:   []string{ArchiveLabel},
+	}}}
+
+	b := &bytes.Buffer{}
+	w := multipart.NewWriter(b)
+	Ok(t, req.WriteTo(w))
+	Ok(t, w.Close())
+
+	mr := multipart.NewReader(b, w.Boundary())
+	_, err := mr.NextPart() // Metadata.
+	Ok(t, err)
+
+	p, err := mr.NextPart()
+	Ok(t, err)
+	body, err := ioutil.ReadAll(p)
+	Ok(t, err)
+	Equals(t, "Hello World!", string(body))
+}
+
+func TestClient_ImportRepeatedStreamed(t *testing.T) {
+	var bodies []string
+	s, c := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Ok(t, checkMethodAndPath(r, "POST", "/mail/v4/messages/import"))
+		Equals(t, int64(-1), r.ContentLength)
+
+		_, params, err := pmmime.ParseMediaType(r.Header.Get("Content-Type"))
+		Ok(t, err)
+
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		_, err = mr.NextPart() // Metadata.
+		Ok(t, err)
+		p, err := mr.NextPart()
+		Ok(t, err)
+		b, err := ioutil.ReadAll(p)
+		Ok(t, err)
+		bodies = append(bodies, string(b))
+
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, testImportBody)
+	}))
+	defer s.Close()
+
+	imported, err := c.Import([]*ImportMsgReq{{
+		AddressID:  "addressID",
+		BodyReader: strings.NewReader("Hello World!"),
+		LabelIDs:   []string{ArchiveLabel},
+	}})
+	Ok(t, err)
+	Equals(t, []string{"Hello World!", "Hello World!"}, bodies)
+	Equals(t, testImportRes, imported[0])
+}
@@ -31,6 +31,8 @@ const (
 	SentLabel      = "7"
 	DraftLabel     = "8"
 	StarredLabel   = "10"
+	ScheduledLabel = "12"
+	SnoozedLabel   = "16"
 
 	LabelTypeMailbox      = 1
 	LabelTypeContactGroup = 2
@@ -39,7 +41,7 @@ const (
 // IsSystemLabel checks if a label is a pre-defined system label.
 func IsSystemLabel(label string) bool {
 	switch label {
-	case InboxLabel, DraftLabel, SentLabel, TrashLabel, SpamLabel, ArchiveLabel, StarredLabel, AllMailLabel, AllSentLabel, AllDraftsLabel:
+	case InboxLabel, DraftLabel, SentLabel, TrashLabel, SpamLabel, ArchiveLabel, StarredLabel, AllMailLabel, AllSentLabel, AllDraftsLabel, ScheduledLabel, SnoozedLabel:
 		return true
 	}
 	return false
@@ -145,6 +145,8 @@ type sendData struct {
 
 type SendMessageReq struct {
 	ExpirationTime int64 `json:",omitempty"`
+	// DeliveryTime schedules sending of the message (Unix time).
+	DeliveryTime int64 `json:",omitempty"`
 	// AutoSaveContacts int `json:",omitempty"`
 
 	// Data for encrypted recipients.
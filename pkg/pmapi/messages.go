@@ -500,7 +500,13 @@ type MessagesListRes struct {
 	Messages []*Message
 }
 
-// ListMessages gets message metadata.
+// ErrRequestURITooLong is returned when the filter, e.g. the list of IDs,
+// does not fit into the request URI.
+var ErrRequestURITooLong = errors.New("request URI too long")
+
+// ListMessages gets message metadata. The filter is never relaxed, so when
+// it does not fit into the request ErrRequestURITooLong is returned and the
+// caller has to split it.
 func (c *client) ListMessages(filter *MessagesFilter) (msgs []*Message, total int, err error) {
 	req, err := c.NewRequest("GET", "/mail/v4/messages", nil)
 	if err != nil {
@@ -510,10 +516,8 @@ func (c *client) ListMessages(filter *MessagesFilter) (msgs []*Message, total in
 	req.URL.RawQuery = filter.urlValues().Encode()
 	var res MessagesListRes
 	if err = c.DoJSON(req, &res); err != nil {
-		// If the URI was too long and we searched with IDs, we will try again without the API IDs.
-		if strings.Contains(err.Error(), "api returned: 414") && len(filter.ID) > 0 {
-			filter.ID = []string{}
-			return c.ListMessages(filter)
+		if strings.Contains(err.Error(), "api returned: 414") {
+			err = ErrRequestURITooLong
 		}
 		return
 	}
@@ -61,6 +61,7 @@ func (res Res) Err() error {
 
 	return &Error{
 		Code:         res.Code,
+		StatusCode:   res.StatusCode,
 		ErrorMessage: res.ResError.Error,
 	}
 }
@@ -73,6 +74,8 @@ type ResError struct {
 type Error struct {
 	// The error code.
 	Code int
+	// The HTTP status code, if known.
+	StatusCode int `json:"-"`
 	// The error message.
 	ErrorMessage string `json:"Error"`
 }
@@ -0,0 +1,221 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most a single read or write waits for at once so that
+// a limited transfer flows steadily instead of in bursts.
+const throttleChunk = 32 * 1024
+
+// Throttle limits the total bandwidth and the number of parallel requests of
+// all clients of a client manager. Zero limits mean unlimited.
+type Throttle struct {
+	lock sync.Mutex
+
+	bytesPerSecond int64
+	parallel       int
+	running        int
+
+	tokens float64
+	last   time.Time
+
+	// changed is closed (and replaced) whenever a request finishes or limits
+	// change to wake up everybody waiting.
+	changed chan struct{}
+}
+
+func newThrottle() *Throttle {
+	return &Throttle{
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetLimits sets the total bandwidth in bytes per second and the number of
+// requests made in parallel. Zero means unlimited.
+func (t *Throttle) SetLimits(bytesPerSecond int64, parallel int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.bytesPerSecond = bytesPerSecond
+	t.parallel = parallel
+	t.tokens = 0
+	t.last = time.Now()
+	t.notify()
+}
+
+// notify wakes up all waiting requests. It must be called with lock held.
+func (t *Throttle) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// acquire waits for a free slot for a request.
+func (t *Throttle) acquire(ctx context.Context) error {
+	t.lock.Lock()
+	for t.parallel > 0 && t.running >= t.parallel {
+		changed := t.changed
+		t.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		t.lock.Lock()
+	}
+	t.running++
+	t.lock.Unlock()
+
+	return nil
+}
+
+func (t *Throttle) release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.running--
+	t.notify()
+}
+
+// take accounts n transferred bytes and waits until the transfer is back
+// within the bandwidth limit.
+func (t *Throttle) take(ctx context.Context, n int) error {
+	t.lock.Lock()
+
+	if t.bytesPerSecond <= 0 {
+		t.lock.Unlock()
+		return nil
+	}
+
+	// Unused bandwidth is saved for at most one second.
+	now := time.Now()
+	rate := float64(t.bytesPerSecond)
+	t.tokens += now.Sub(t.last).Seconds() * rate
+	if t.tokens > rate {
+		t.tokens = rate
+	}
+	t.last = now
+	t.tokens -= float64(n)
+
+	if t.tokens >= 0 {
+		t.lock.Unlock()
+		return nil
+	}
+
+	wait := time.Duration(-t.tokens / rate * float64(time.Second))
+	changed := t.changed
+	t.lock.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-changed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// roundTripper wraps the transport so that all requests made through it are
+// subject to the limits.
+func (t *Throttle) roundTripper(rt http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{throttle: t, rt: rt}
+}
+
+type throttledTransport struct {
+	throttle *Throttle
+	rt       http.RoundTripper
+}
+
+func (tt *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if err := tt.throttle.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil {
+		req = req.Clone(ctx)
+		req.Body = &throttledBody{ReadCloser: req.Body, ctx: ctx, throttle: tt.throttle}
+	}
+
+	res, err := tt.rt.RoundTrip(req)
+	if err != nil {
+		tt.throttle.release()
+		return nil, err
+	}
+
+	res.Body = &throttledBody{ReadCloser: res.Body, ctx: ctx, throttle: tt.throttle, release: true}
+
+	return res, nil
+}
+
+// throttledBody slows down reading according to the bandwidth limit.
+// Response bodies also give back the request slot once read or closed.
+type throttledBody struct {
+	io.ReadCloser
+
+	ctx      context.Context
+	throttle *Throttle
+
+	release     bool
+	releaseOnce sync.Once
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+
+	if n > 0 {
+		if takeErr := b.throttle.take(b.ctx, n); takeErr != nil && err == nil {
+			err = takeErr
+		}
+	}
+
+	if err == io.EOF {
+		b.done()
+	}
+
+	return n, err
+}
+
+func (b *throttledBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *throttledBody) done() {
+	if b.release {
+		b.releaseOnce.Do(b.throttle.release)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bodyRoundTripper []byte
+
+func (rt bodyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(rt)),
+		Request:    req,
+	}, nil
+}
+
+func newThrottleTestRequest(t *testing.T, timeout time.Duration) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	req, err := http.NewRequest("GET", "https://localhost/", nil)
+	require.NoError(t, err)
+	return req.WithContext(ctx), cancel
+}
+
+func TestThrottleParallel(t *testing.T) {
+	throttle := newThrottle()
+	throttle.SetLimits(0, 1)
+	rt := throttle.roundTripper(bodyRoundTripper("body"))
+
+	req, cancel := newThrottleTestRequest(t, time.Second)
+	defer cancel()
+	first, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+
+	// Second request waits until the first one is finished.
+	req, cancel = newThrottleTestRequest(t, 50*time.Millisecond)
+	defer cancel()
+	_, err = rt.RoundTrip(req)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, first.Body.Close())
+
+	req, cancel = newThrottleTestRequest(t, time.Second)
+	defer cancel()
+	second, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+
+	// Removing the limit lets waiting requests through.
+	done := make(chan error)
+	go func() {
+		req, cancel := newThrottleTestRequest(t, time.Second)
+		defer cancel()
+		_, err := rt.RoundTrip(req)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	throttle.SetLimits(0, 0)
+	require.NoError(t, <-done)
+	require.NoError(t, second.Body.Close())
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	throttle := newThrottle()
+	throttle.SetLimits(1024*1024, 0)
+	rt := throttle.roundTripper(bodyRoundTripper(make([]byte, 300*1024)))
+
+	req, cancel := newThrottleTestRequest(t, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	res, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	require.Len(t, body, 300*1024)
+	require.True(t, time.Since(start) > 250*time.Millisecond, "took %v", time.Since(start))
+	require.True(t, time.Since(start) < 2*time.Second, "took %v", time.Since(start))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+)
+
+// TLSException trusts servers of the hosts presenting a certificate issued
+// by the CA even though it is not one of the pinned keys. It is meant for
+// networks with TLS-inspecting proxy whose CA the user imported explicitly.
+// Pinning still applies to all other hosts and certificates.
+type TLSException struct {
+	CA    *x509.Certificate
+	Hosts []string // Host names, `*.example.com` matches its subdomains.
+}
+
+// MatchesHost returns whether the exception applies to the host.
+func (e *TLSException) MatchesHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range e.Hosts {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the chain presented by the host against the CA only, not
+// against the system roots.
+func (e *TLSException) verify(host string, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return errors.New("no certificate presented")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(e.CA)
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       host,
+	})
+	return err
+}
+
+// checkTLSExceptions returns whether the connection whose keys are not
+// pinned is allowed by some exception.
+func (p *PinningTLSDialer) checkTLSExceptions(host string, conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+
+	for _, exception := range p.tlsExceptions {
+		if !exception.MatchesHost(host) || exception.verify(host, certs) != nil {
+			continue
+		}
+
+		p.tlsExceptionsLock.Lock()
+		firstUse := !p.tlsExceptionsUsed[host]
+		p.tlsExceptionsUsed[host] = true
+		p.tlsExceptionsLock.Unlock()
+
+		if firstUse {
+			p.log.WithField("host", host).
+				WithField("ca", exception.CA.Subject.String()).
+				Warn("Server is trusted by TLS exception instead of pinned keys")
+			if p.tlsExceptionNotifier != nil {
+				go p.tlsExceptionNotifier(host)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GetAPIHost returns host of the API, e.g. as the default host of TLS
+// exceptions.
+func GetAPIHost() string {
+	return rootURL
+}
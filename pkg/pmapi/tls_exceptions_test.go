@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package pmapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSExceptionMatchesHost(t *testing.T) {
+	exception := &TLSException{Hosts: []string{"api.protonmail.ch", "*.example.com"}}
+
+	require.True(t, exception.MatchesHost("api.protonmail.ch"))
+	require.True(t, exception.MatchesHost("API.protonmail.ch."))
+	require.True(t, exception.MatchesHost("mail.example.com"))
+	require.False(t, exception.MatchesHost("example.com"))
+	require.False(t, exception.MatchesHost("protonmail.ch"))
+	require.False(t, exception.MatchesHost("api.protonmail.ch.evil.com"))
+}
+
+func TestTLSException(t *testing.T) {
+	// Test server presents self-signed certificate for 127.0.0.1 which acts
+	// as CA of TLS-inspecting proxy.
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer ts.Close()
+	address := ts.Listener.Addr().String()
+
+	var issues, used int32
+	dialer := NewPinningTLSDialer(NewBasicTLSDialer())
+	// Other tests add pin of the test server to the trusted pins, none are
+	// trusted here.
+	dialer.pinChecker = newPinChecker(nil)
+	dialer.SetTLSIssueNotifier(func() { atomic.AddInt32(&issues, 1) })
+
+	_, err := dialer.DialTLS("tcp", address)
+	require.Equal(t, ErrTLSMismatch, err)
+
+	// Exception for another host or by another CA is not used.
+	dialer.SetTLSExceptions([]TLSException{
+		{CA: ts.Certificate(), Hosts: []string{"api.protonmail.ch"}},
+		{CA: newTestCA(t), Hosts: []string{"127.0.0.1"}},
+	}, func(string) { atomic.AddInt32(&used, 1) })
+	_, err = dialer.DialTLS("tcp", address)
+	require.Equal(t, ErrTLSMismatch, err)
+
+	dialer.SetTLSExceptions([]TLSException{
+		{CA: ts.Certificate(), Hosts: []string{"127.0.0.1"}},
+	}, func(string) { atomic.AddInt32(&used, 1) })
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.DialTLS("tcp", address)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close())
+	}
+
+	// Notifiers run in goroutines.
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&issues) == 2 && atomic.LoadInt32(&used) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func newTestCA(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package saslbearer implements server side of OAUTHBEARER (RFC7628) and
+// XOAUTH2 SASL mechanisms used by clients which insist on OAuth.
+package saslbearer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+)
+
+const (
+	// OAuthBearer is the OAUTHBEARER mechanism name.
+	OAuthBearer = "OAUTHBEARER"
+
+	// XOAuth2 is the XOAUTH2 mechanism name.
+	XOAuth2 = "XOAUTH2"
+)
+
+var errInvalidResponse = errors.New("invalid response")
+
+// Authenticator checks the bearer token of the user. The username is empty
+// when OAUTHBEARER client did not send it and the user has to be identified
+// by the token.
+type Authenticator func(username, token string) error
+
+type parser func(response []byte) (username, token string, err error)
+
+type server struct {
+	parse        parser
+	authenticate Authenticator
+
+	done    bool
+	failErr error
+}
+
+// NewOAuthBearerServer returns OAUTHBEARER server.
+func NewOAuthBearerServer(authenticate Authenticator) sasl.Server {
+	return &server{parse: parseOAuthBearer, authenticate: authenticate}
+}
+
+// NewXOAuth2Server returns XOAUTH2 server.
+func NewXOAuth2Server(authenticate Authenticator) sasl.Server {
+	return &server{parse: parseXOAuth2, authenticate: authenticate}
+}
+
+func (s *server) Next(response []byte) (challenge []byte, done bool, err error) {
+	// Both mechanisms report failure as JSON challenge and the client has to
+	// answer it (with empty response or 0x01) before the exchange is over.
+	if s.failErr != nil {
+		return nil, true, s.failErr
+	}
+
+	if s.done {
+		return nil, true, sasl.ErrUnexpectedClientResponse
+	}
+
+	// Ask for the credentials when client did not send initial response.
+	if response == nil {
+		return []byte{}, false, nil
+	}
+
+	s.done = true
+
+	username, token, err := s.parse(response)
+	if err != nil {
+		return s.fail("invalid_request", err)
+	}
+
+	if err := s.authenticate(username, token); err != nil {
+		return s.fail("invalid_token", err)
+	}
+
+	return nil, true, nil
+}
+
+func (s *server) fail(status string, err error) ([]byte, bool, error) {
+	s.failErr = err
+	challenge, _ := json.Marshal(map[string]string{ //nolint[errcheck] map of strings is always marshalled
+		"status":  status,
+		"schemes": "bearer",
+	})
+	return challenge, false, nil
+}
+
+// parseOAuthBearer parses `n,a=user,^Ahost=...^Aauth=Bearer token^A^A`.
+// The authzid is optional (`n,,^Aauth=...`). Channel binding is not
+// supported, so only `n` and `y` flags are accepted.
+func parseOAuthBearer(response []byte) (username, token string, err error) {
+	parts := bytes.SplitN(response, []byte{','}, 3)
+	if len(parts) != 3 || (string(parts[0]) != "n" && string(parts[0]) != "y") {
+		return "", "", errInvalidResponse
+	}
+	if len(parts[1]) != 0 {
+		if !bytes.HasPrefix(parts[1], []byte("a=")) {
+			return "", "", errInvalidResponse
+		}
+		username = string(bytes.TrimPrefix(parts[1], []byte("a=")))
+	}
+
+	token, err = parseAuthParam(parts[2])
+	return username, token, err
+}
+
+// parseXOAuth2 parses `user=user^Aauth=Bearer token^A^A`.
+func parseXOAuth2(response []byte) (username, token string, err error) {
+	parts := bytes.SplitN(response, []byte{0x01}, 2)
+	if len(parts) != 2 || !bytes.HasPrefix(parts[0], []byte("user=")) {
+		return "", "", errInvalidResponse
+	}
+	username = string(bytes.TrimPrefix(parts[0], []byte("user=")))
+
+	token, err = parseAuthParam(parts[1])
+	return username, token, err
+}
+
+// parseAuthParam finds bearer token in 0x01 separated key=value parameters.
+func parseAuthParam(params []byte) (string, error) {
+	for _, param := range bytes.Split(params, []byte{0x01}) {
+		keyValue := strings.SplitN(string(param), "=", 2)
+		if len(keyValue) != 2 || keyValue[0] != "auth" {
+			continue
+		}
+
+		const prefix = "bearer "
+		if !strings.HasPrefix(strings.ToLower(keyValue[1]), prefix) {
+			return "", errors.New("unsupported token type")
+		}
+		return keyValue[1][len(prefix):], nil
+	}
+	return "", errors.New("missing auth parameter")
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package saslbearer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuthenticator(gotUsername, gotToken *string) Authenticator {
+	return func(username, token string) error {
+		*gotUsername, *gotToken = username, token
+		if token != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+}
+
+func TestOAuthBearerServer(t *testing.T) {
+	var username, token string
+	s := NewOAuthBearerServer(newTestAuthenticator(&username, &token))
+
+	challenge, done, err := s.Next(nil)
+	require.NoError(t, err)
+	require.False(t, done)
+	require.Empty(t, challenge)
+
+	_, done, err = s.Next([]byte("n,a=user@pm.me,\x01host=127.0.0.1\x01port=1143\x01auth=Bearer secret\x01\x01"))
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, "user@pm.me", username)
+	require.Equal(t, "secret", token)
+}
+
+func TestOAuthBearerServerWithoutUsername(t *testing.T) {
+	var username, token string
+	s := NewOAuthBearerServer(newTestAuthenticator(&username, &token))
+
+	_, done, err := s.Next([]byte("n,,\x01auth=Bearer secret\x01\x01"))
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Empty(t, username)
+	require.Equal(t, "secret", token)
+}
+
+func TestXOAuth2Server(t *testing.T) {
+	var username, token string
+	s := NewXOAuth2Server(newTestAuthenticator(&username, &token))
+
+	_, done, err := s.Next([]byte("user=user@pm.me\x01auth=bearer secret\x01\x01"))
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, "user@pm.me", username)
+	require.Equal(t, "secret", token)
+}
+
+func TestServerFailure(t *testing.T) {
+	var username, token string
+	s := NewXOAuth2Server(newTestAuthenticator(&username, &token))
+
+	challenge, done, err := s.Next([]byte("user=user@pm.me\x01auth=Bearer wrong\x01\x01"))
+	require.NoError(t, err)
+	require.False(t, done)
+	require.JSONEq(t, `{"status":"invalid_token","schemes":"bearer"}`, string(challenge))
+
+	_, done, err = s.Next([]byte{})
+	require.EqualError(t, err, "bad token")
+	require.True(t, done)
+}
+
+func TestServerInvalidResponse(t *testing.T) {
+	var username, token string
+	s := NewOAuthBearerServer(newTestAuthenticator(&username, &token))
+
+	challenge, done, err := s.Next([]byte("user=user@pm.me\x01auth=Bearer secret\x01\x01"))
+	require.NoError(t, err)
+	require.False(t, done)
+	require.JSONEq(t, `{"status":"invalid_request","schemes":"bearer"}`, string(challenge))
+
+	_, _, err = s.Next([]byte{0x01})
+	require.Error(t, err)
+	require.Empty(t, username)
+}
+
+func TestParseAuthParam(t *testing.T) {
+	_, err := parseAuthParam([]byte("\x01auth=Basic abc\x01\x01"))
+	require.Error(t, err)
+
+	_, err = parseAuthParam([]byte("\x01host=localhost\x01\x01"))
+	require.Error(t, err)
+}
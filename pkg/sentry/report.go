@@ -19,16 +19,98 @@ package sentry
 
 import (
 	"errors"
+	"regexp"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
 	log "github.com/sirupsen/logrus"
 )
 
-// ReportSentryCrash reports a sentry crash.
+// Levels of crash and error reporting.
+const (
+	// ReportOff sends nothing; crash dumps are only written locally.
+	ReportOff = "off"
+	// ReportAnonymous sends reports without user ID, user agent, host name
+	// and with e-mails, IPs and tokens redacted.
+	ReportAnonymous = "anonymous"
+	// ReportFull sends reports with everything Sentry collects.
+	ReportFull = "full"
+)
+
+var (
+	reportLevel     = ReportFull //nolint[gochecknoglobals]
+	reportLevelLock sync.RWMutex //nolint[gochecknoglobals]
+
+	redactEmailRgx = regexp.MustCompile(`[^\s"'<>:=,;()\[\]]+@[^\s"'<>:=,;()\[\]]+\.[a-zA-Z]{2,}`) //nolint[gochecknoglobals]
+	redactIPRgx    = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`)                  //nolint[gochecknoglobals]
+	redactTokenRgx = regexp.MustCompile(`[a-zA-Z0-9_\-+]{32,}={0,2}`)                              //nolint[gochecknoglobals]
+)
+
+// GetReportLevels returns all levels of reporting.
+func GetReportLevels() []string {
+	return []string{ReportOff, ReportAnonymous, ReportFull}
+}
+
+// SetReportLevel changes what is sent to Sentry. Unknown level turns
+// reporting off to be on the safe side.
+func SetReportLevel(level string) {
+	switch level {
+	case ReportOff, ReportAnonymous, ReportFull:
+	default:
+		log.WithField("level", level).Warn("Unknown crash report level, reporting is off")
+		level = ReportOff
+	}
+
+	reportLevelLock.Lock()
+	defer reportLevelLock.Unlock()
+
+	reportLevel = level
+}
+
+// GetReportLevel returns what is sent to Sentry.
+func GetReportLevel() string {
+	reportLevelLock.RLock()
+	defer reportLevelLock.RUnlock()
+
+	return reportLevel
+}
+
+// Redact replaces e-mail addresses, IPs and long tokens such as IDs or
+// keys so the text can be shared without identifying the user.
+func Redact(text string) string {
+	text = redactEmailRgx.ReplaceAllString(text, "[email]")
+	text = redactIPRgx.ReplaceAllString(text, "[ip]")
+	return redactTokenRgx.ReplaceAllString(text, "[token]")
+}
+
+// BeforeSend is the Sentry hook dropping or anonymising every event
+// according to the report level, including those not sent by this package.
+func BeforeSend(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	switch GetReportLevel() {
+	case ReportFull:
+		return event
+	case ReportAnonymous:
+		event.User = sentry.User{}
+		event.ServerName = ""
+		event.Request = nil
+		event.Message = Redact(event.Message)
+		delete(event.Tags, "Client")
+		delete(event.Tags, "UserAgent")
+		delete(event.Tags, "UserID")
+		for i := range event.Exception {
+			event.Exception[i].Value = Redact(event.Exception[i].Value)
+		}
+		return event
+	default:
+		return nil
+	}
+}
+
+// ReportSentryCrash reports a sentry crash unless reporting is off.
 func ReportSentryCrash(clientID, appVersion, userAgent string, reportErr error) (err error) {
-	if reportErr == nil {
+	if reportErr == nil || GetReportLevel() == ReportOff {
 		return
 	}
 
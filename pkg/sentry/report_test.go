@@ -0,0 +1,61 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sentry
+
+import (
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	require.Equal(t,
+		"login [email] from [ip] with UID [token] in /home/user/proton-bridge/internal/imap/backend.go",
+		Redact("login john.doe@protonmail.com from 192.168.1.20 with UID lx7kjbw3frzdOsvsg6gPmSfWuqvlwJkQd1A9jBY_Mk4xsbVsRLm2VYhqhiR5ZKT-DP2grH3Vd8TfYPyB2ErwCQ== in /home/user/proton-bridge/internal/imap/backend.go"),
+	)
+}
+
+func TestBeforeSend(t *testing.T) {
+	defer SetReportLevel(ReportFull)
+
+	newEvent := func() *sentry.Event {
+		return &sentry.Event{
+			User:       sentry.User{ID: "userID"},
+			ServerName: "laptop",
+			Message:    "cannot send to john@example.com",
+			Tags:       map[string]string{"OS": "linux", "Client": "Bridge", "UserAgent": "Thunderbird"},
+		}
+	}
+
+	SetReportLevel(ReportFull)
+	require.Equal(t, newEvent(), BeforeSend(newEvent(), nil))
+
+	SetReportLevel(ReportAnonymous)
+	event := BeforeSend(newEvent(), nil)
+	require.Equal(t, sentry.User{}, event.User)
+	require.Equal(t, "", event.ServerName)
+	require.Equal(t, "cannot send to [email]", event.Message)
+	require.Equal(t, map[string]string{"OS": "linux"}, event.Tags)
+
+	SetReportLevel(ReportOff)
+	require.Nil(t, BeforeSend(newEvent(), nil))
+
+	SetReportLevel("unknown")
+	require.Equal(t, ReportOff, GetReportLevel())
+}
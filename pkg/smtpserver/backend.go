@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+import (
+	"io"
+	"time"
+)
+
+// Backend authenticates users of the server.
+type Backend interface {
+	Login(username, password string) (User, error)
+}
+
+// User is an authenticated user of the server.
+type User interface {
+	// Send sends the message. The error is replied to the client; it should
+	// be *SMTPError to choose the reply code.
+	Send(envelope *Envelope, r io.Reader) error
+
+	// Logout is called when the User is no longer used.
+	Logout() error
+}
+
+// Envelope is the sender and recipients of the message with their parameters.
+type Envelope struct {
+	From string
+	To   []Recipient
+
+	// Return is RET parameter of DSN (RFC3461), FULL or HDRS if set.
+	Return string
+	// EnvelopeID is decoded ENVID parameter of DSN.
+	EnvelopeID string
+	// HoldUntil is the release time requested by HOLDFOR or HOLDUNTIL
+	// parameter of FUTURERELEASE, zero for immediate release.
+	HoldUntil time.Time
+}
+
+// Recipient is the address of RCPT command with its parameters.
+type Recipient struct {
+	Address string
+
+	// Notify is NOTIFY parameter of DSN, i.e. NEVER or any of SUCCESS,
+	// FAILURE and DELAY.
+	Notify []string
+	// OriginalRecipient is decoded ORCPT parameter of DSN including address
+	// type, e.g. "rfc822;user@example.com".
+	OriginalRecipient string
+}
+
+// Addresses returns addresses of all recipients.
+func (e *Envelope) Addresses() []string {
+	addresses := make([]string, len(e.To))
+	for i, r := range e.To {
+		addresses[i] = r.Address
+	}
+	return addresses
+}
@@ -0,0 +1,675 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUnrecognizedCommands is how many unknown commands close the connection.
+const maxUnrecognizedCommands = 3
+
+// Conn is one connection of a client.
+type Conn struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	server    *Server
+	helo      string
+	msg       *Envelope
+	chunks    *bytes.Buffer // Message received by BDAT so far.
+	binary    bool          // BODY=BINARYMIME, message can be sent only by BDAT.
+	nbrErrors int
+	user      User
+	locker    sync.Mutex
+}
+
+func newConn(c net.Conn, s *Server) *Conn {
+	sc := &Conn{
+		server: s,
+		conn:   c,
+	}
+
+	sc.init()
+	return sc
+}
+
+func (c *Conn) init() {
+	var rwc io.ReadWriteCloser = c.conn
+	if c.server.Debug != nil {
+		rwc = struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{
+			io.TeeReader(c.conn, c.server.Debug),
+			io.MultiWriter(c.conn, c.server.Debug),
+			c.conn,
+		}
+	}
+
+	c.text = textproto.NewConn(rwc)
+}
+
+// handle dispatches the command and returns whether the connection goes on.
+func (c *Conn) handle(cmd string, arg string) bool {
+	switch cmd {
+	case "":
+		c.WriteResponse(500, "5.5.2", "Speak up")
+	case "SEND", "SOML", "SAML", "EXPN", "HELP", "TURN":
+		c.WriteResponse(502, "5.5.1", fmt.Sprintf("%v command not implemented", cmd))
+	case "HELO", "EHLO":
+		c.handleGreet(cmd == "EHLO", arg)
+	case "MAIL":
+		c.handleMail(arg)
+	case "RCPT":
+		c.handleRcpt(arg)
+	case "VRFY":
+		c.WriteResponse(252, "2.5.0", "Cannot VRFY user, but will accept message")
+	case "NOOP":
+		c.WriteResponse(250, "2.0.0", "I have successfully done nothing")
+	case "RSET":
+		c.resetMessage()
+		c.WriteResponse(250, "2.0.0", "Session reset")
+	case "DATA":
+		c.handleData(arg)
+	case "BDAT":
+		c.handleBdat(arg)
+	case "QUIT":
+		c.WriteResponse(221, "2.0.0", "Goodnight and good luck")
+		return false
+	case "AUTH":
+		c.handleAuth(arg)
+	case "STARTTLS":
+		c.handleStartTLS()
+	default:
+		c.WriteResponse(500, "5.5.2", fmt.Sprintf("Syntax error, %v command unrecognized", cmd))
+
+		c.nbrErrors++
+		if c.nbrErrors > maxUnrecognizedCommands {
+			c.WriteResponse(500, "5.5.2", "Too many unrecognized commands")
+			return false
+		}
+	}
+	return true
+}
+
+// Server returns the server of the connection.
+func (c *Conn) Server() *Server {
+	return c.server
+}
+
+// User returns the authenticated user or nil.
+func (c *Conn) User() User {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.user
+}
+
+// SetUser sets the authenticated user.
+func (c *Conn) SetUser(user User) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.user = user
+}
+
+// Close logs the user out and closes the connection.
+func (c *Conn) Close() error {
+	c.locker.Lock()
+	user := c.user
+	c.user = nil
+	c.locker.Unlock()
+
+	if user != nil {
+		_ = user.Logout()
+	}
+
+	return c.conn.Close()
+}
+
+// IsTLS returns whether the connection is encrypted.
+func (c *Conn) IsTLS() bool {
+	_, ok := c.conn.(*tls.Conn)
+	return ok
+}
+
+// isAuthAllowed returns whether the client can authenticate.
+func (c *Conn) isAuthAllowed() bool {
+	return c.IsTLS() || c.server.AllowInsecureAuth
+}
+
+func (c *Conn) handleGreet(enhanced bool, arg string) {
+	domain, err := parseHelloArgument(arg)
+	if err != nil {
+		c.WriteResponse(501, "5.5.4", "Domain/address argument required")
+		return
+	}
+
+	// HELO or EHLO in the middle of transaction resets it.
+	c.resetMessage()
+	c.helo = domain
+
+	if !enhanced {
+		c.WriteResponse(250, "", fmt.Sprintf("Hello %s", domain))
+		return
+	}
+
+	caps := []string{}
+	caps = append(caps, c.server.caps...)
+	if c.server.TLSConfig != nil && !c.IsTLS() {
+		caps = append(caps, "STARTTLS")
+	}
+	if c.isAuthAllowed() {
+		authCap := "AUTH"
+		for name := range c.server.auths {
+			authCap += " " + name
+		}
+
+		caps = append(caps, authCap)
+	}
+	if c.server.MaxMessageBytes > 0 {
+		caps = append(caps, fmt.Sprintf("SIZE %v", c.server.MaxMessageBytes))
+	}
+	if c.server.MaxFutureRelease > 0 {
+		maxUntil := time.Now().Add(c.server.MaxFutureRelease).UTC().Format(time.RFC3339)
+		caps = append(caps, fmt.Sprintf("FUTURERELEASE %v %v", int64(c.server.MaxFutureRelease/time.Second), maxUntil))
+	}
+
+	args := []string{"Hello " + domain}
+	args = append(args, caps...)
+	c.WriteResponse(250, "", args...)
+}
+
+func (c *Conn) handleMail(arg string) {
+	if c.helo == "" {
+		c.WriteResponse(503, "5.5.1", "Please introduce yourself first.")
+		return
+	}
+	if c.User() == nil {
+		c.WriteResponse(530, "5.7.0", "Please authenticate first.")
+		return
+	}
+	if c.msg != nil {
+		c.WriteResponse(503, "5.5.1", "Sender already specified.")
+		return
+	}
+
+	if len(arg) < 5 || strings.ToUpper(arg[0:5]) != "FROM:" {
+		c.WriteResponse(501, "5.5.4", "Was expecting MAIL arg syntax of FROM:<address>")
+		return
+	}
+
+	from, params, err := parsePath(arg[5:])
+	if err != nil {
+		c.WriteResponse(501, "5.5.4", "Was expecting MAIL arg syntax of FROM:<address>")
+		return
+	}
+
+	args, err := parseArgs(params)
+	if err != nil {
+		c.WriteResponse(501, "5.5.4", "Unable to parse MAIL ESMTP parameters")
+		return
+	}
+
+	// UTF-8 addresses and headers are accepted with or without SMTPUTF8,
+	// which has no value.
+	if utf8, ok := args["SMTPUTF8"]; ok && utf8 != "" {
+		c.WriteResponse(501, "5.5.4", "SMTPUTF8 parameter has no value")
+		return
+	}
+
+	// Message with BINARYMIME body has to be sent by BDAT (RFC3030).
+	binary := false
+	if body, ok := args["BODY"]; ok {
+		switch strings.ToUpper(body) {
+		case "7BIT", "8BITMIME":
+		case "BINARYMIME":
+			binary = true
+		default:
+			c.WriteResponse(501, "5.5.4", "BODY parameter must be 7BIT, 8BITMIME or BINARYMIME")
+			return
+		}
+	}
+
+	envelope := &Envelope{From: from}
+
+	if ret, ok := args["RET"]; ok {
+		envelope.Return = strings.ToUpper(ret)
+		if envelope.Return != "FULL" && envelope.Return != "HDRS" {
+			c.WriteResponse(501, "5.5.4", "RET parameter must be FULL or HDRS")
+			return
+		}
+	}
+
+	if envID, ok := args["ENVID"]; ok {
+		if envelope.EnvelopeID, err = decodeXtext(envID); err != nil {
+			c.WriteResponse(501, "5.5.4", "ENVID parameter is not valid xtext")
+			return
+		}
+	}
+
+	if c.server.MaxFutureRelease > 0 {
+		holdUntil, err := parseFutureRelease(args, time.Now())
+		if err != nil {
+			c.WriteResponse(501, "5.5.4", "Invalid FUTURERELEASE parameters: "+err.Error())
+			return
+		}
+		if holdUntil.After(time.Now().Add(c.server.MaxFutureRelease)) {
+			c.WriteResponse(554, "5.3.4", "Requested hold time is too long")
+			return
+		}
+		envelope.HoldUntil = holdUntil
+	}
+
+	if args["SIZE"] != "" {
+		size, err := strconv.ParseInt(args["SIZE"], 10, 64)
+		if err != nil {
+			c.WriteResponse(501, "5.5.4", "Unable to parse SIZE as an integer")
+			return
+		}
+
+		if c.server.MaxMessageBytes > 0 && size > int64(c.server.MaxMessageBytes) {
+			c.WriteResponse(552, "5.3.4", "Max message size exceeded")
+			return
+		}
+	}
+
+	c.msg = envelope
+	c.binary = binary
+	c.WriteResponse(250, "2.1.0", fmt.Sprintf("Roger, accepting mail from <%v>", from))
+}
+
+func (c *Conn) handleRcpt(arg string) {
+	if c.msg == nil {
+		c.WriteResponse(503, "5.5.1", "Missing MAIL FROM command.")
+		return
+	}
+
+	if (len(arg) < 4) || (strings.ToUpper(arg[0:3]) != "TO:") {
+		c.WriteResponse(501, "5.5.4", "Was expecting RCPT arg syntax of TO:<address>")
+		return
+	}
+
+	recipient, err := parseRecipient(arg[3:])
+	if err != nil {
+		c.WriteResponse(501, "5.5.4", "Invalid RCPT arguments: "+err.Error())
+		return
+	}
+
+	if c.server.MaxRecipients > 0 && len(c.msg.To) >= c.server.MaxRecipients {
+		c.WriteResponse(452, "4.5.3", fmt.Sprintf("Maximum limit of %v recipients reached", c.server.MaxRecipients))
+		return
+	}
+
+	c.msg.To = append(c.msg.To, recipient)
+	c.WriteResponse(250, "2.1.5", fmt.Sprintf("I'll make sure <%v> gets this", recipient.Address))
+}
+
+func (c *Conn) handleAuth(arg string) {
+	if c.helo == "" {
+		c.WriteResponse(503, "5.5.1", "Please introduce yourself first.")
+		return
+	}
+	if c.User() != nil {
+		c.WriteResponse(503, "5.5.1", "Already authenticated.")
+		return
+	}
+	if !c.isAuthAllowed() {
+		c.WriteResponse(530, "5.7.0", "Must issue a STARTTLS command first.")
+		return
+	}
+
+	parts := strings.Fields(arg)
+	if len(parts) == 0 {
+		c.WriteResponse(501, "5.5.4", "Missing parameter")
+		return
+	}
+	mechanism := strings.ToUpper(parts[0])
+
+	// Parse client initial response if there is one.
+	var ir []byte
+	if len(parts) > 1 {
+		var err error
+		if ir, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+			c.WriteResponse(501, "5.5.2", "Invalid base64 data")
+			return
+		}
+	}
+
+	newSasl, ok := c.server.auths[mechanism]
+	if !ok {
+		c.WriteResponse(504, "5.5.4", "Unsupported authentication mechanism")
+		return
+	}
+
+	sasl := newSasl(c)
+
+	response := ir
+	for {
+		challenge, done, err := sasl.Next(response)
+		if err != nil {
+			c.WriteResponse(454, "4.7.0", err.Error())
+			return
+		}
+
+		if done {
+			break
+		}
+
+		encoded := ""
+		if len(challenge) > 0 {
+			encoded = base64.StdEncoding.EncodeToString(challenge)
+		}
+		c.WriteResponse(334, "", encoded)
+
+		if encoded, err = c.ReadLine(); err != nil {
+			return
+		}
+
+		if encoded == "*" {
+			c.WriteResponse(501, "5.0.0", "Authentication canceled")
+			return
+		}
+
+		if response, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			c.WriteResponse(454, "4.7.0", "Invalid base64 data")
+			return
+		}
+	}
+
+	if c.User() == nil {
+		c.WriteResponse(454, "4.7.0", "Authentication failed")
+		return
+	}
+	c.WriteResponse(235, "2.7.0", "Authentication succeeded")
+}
+
+func (c *Conn) handleStartTLS() {
+	if c.IsTLS() {
+		c.WriteResponse(502, "5.5.1", "Already running in TLS")
+		return
+	}
+
+	if c.server.TLSConfig == nil {
+		c.WriteResponse(502, "5.5.1", "TLS not supported")
+		return
+	}
+
+	c.WriteResponse(220, "2.0.0", "Ready to start TLS")
+
+	tlsConn := tls.Server(c.conn, c.server.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		c.WriteResponse(550, "5.0.0", "Handshake error")
+		return
+	}
+
+	c.conn = tlsConn
+	c.init()
+
+	// A new EHLO is required after STARTTLS.
+	c.helo = ""
+	c.resetMessage()
+}
+
+func (c *Conn) handleData(arg string) {
+	if arg != "" {
+		c.WriteResponse(501, "5.5.4", "DATA command should not have any arguments")
+		return
+	}
+
+	if c.msg == nil || len(c.msg.To) == 0 {
+		c.WriteResponse(503, "5.5.1", "Missing RCPT TO command.")
+		return
+	}
+
+	if c.chunks != nil {
+		c.WriteResponse(503, "5.5.1", "DATA cannot follow BDAT in the same transaction.")
+		return
+	}
+
+	if c.binary {
+		c.WriteResponse(503, "5.5.1", "DATA cannot be used with BODY=BINARYMIME, use BDAT.")
+		return
+	}
+
+	c.WriteResponse(354, "", "Go ahead. End your data with <CR><LF>.<CR><LF>")
+
+	dot := c.text.DotReader()
+	err := c.User().Send(c.msg, newDataReader(c, dot))
+
+	// The rest of the message has to be read when sending fails early.
+	_, _ = io.Copy(ioutil.Discard, dot)
+
+	c.writeResult(err)
+	c.resetMessage()
+}
+
+// handleBdat receives a chunk of the message (RFC3030). The chunk is read even
+// when it is refused, so its content is not taken for commands. The message
+// is sent after the last chunk.
+func (c *Conn) handleBdat(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields) > 2 {
+		c.WriteResponse(501, "5.5.4", "Was expecting BDAT arg syntax of size [LAST]")
+		return
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || size < 0 {
+		c.WriteResponse(501, "5.5.4", "Unable to parse BDAT size")
+		return
+	}
+
+	last := len(fields) == 2
+	if last && strings.ToUpper(fields[1]) != "LAST" {
+		c.WriteResponse(501, "5.5.4", "Was expecting BDAT arg syntax of size [LAST]")
+		return
+	}
+
+	if err := c.conn.SetReadDeadline(c.nextDeadline()); err != nil {
+		return
+	}
+
+	if c.msg == nil || len(c.msg.To) == 0 {
+		_, _ = io.CopyN(ioutil.Discard, c.text.R, size)
+		c.WriteResponse(503, "5.5.1", "Missing RCPT TO command.")
+		return
+	}
+
+	if c.chunks == nil {
+		c.chunks = &bytes.Buffer{}
+	}
+
+	if c.server.MaxMessageBytes > 0 && int64(c.chunks.Len())+size > int64(c.server.MaxMessageBytes) {
+		_, _ = io.CopyN(ioutil.Discard, c.text.R, size)
+		c.resetMessage()
+		c.writeResult(ErrDataTooLarge)
+		return
+	}
+
+	if _, err := io.CopyN(c.chunks, c.text.R, size); err != nil {
+		return
+	}
+
+	if !last {
+		c.WriteResponse(250, "2.0.0", fmt.Sprintf("%v octets received", size))
+		return
+	}
+
+	c.writeResult(c.User().Send(c.msg, c.chunks))
+	c.resetMessage()
+}
+
+// writeResult replies to the client with the result of sending.
+func (c *Conn) writeResult(err error) {
+	var smtpErr *SMTPError
+	switch {
+	case err == nil:
+		c.WriteResponse(250, "2.0.0", "Ok: queued")
+	case errors.As(err, &smtpErr):
+		c.WriteResponse(smtpErr.Code, smtpErr.EnhancedCode, smtpErr.Message)
+	default:
+		c.WriteResponse(554, "5.0.0", "Transaction failed: "+err.Error())
+	}
+}
+
+// resetMessage drops the envelope of the message being submitted.
+func (c *Conn) resetMessage() {
+	c.msg = nil
+	c.chunks = nil
+	c.binary = false
+}
+
+func (c *Conn) greet() {
+	c.WriteResponse(220, "", fmt.Sprintf("%v ESMTP Service Ready", c.server.Domain))
+}
+
+// nextDeadline returns the deadline of read or write according to
+// MaxIdleSeconds.
+func (c *Conn) nextDeadline() time.Time {
+	if c.server.MaxIdleSeconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(time.Duration(c.server.MaxIdleSeconds) * time.Second)
+}
+
+// WriteResponse writes the reply with enhanced status code, if any, on each
+// line of the reply.
+func (c *Conn) WriteResponse(code int, enhancedCode string, text ...string) {
+	_ = c.conn.SetDeadline(c.nextDeadline())
+
+	if enhancedCode != "" {
+		for i := range text {
+			text[i] = enhancedCode + " " + text[i]
+		}
+	}
+
+	for i := 0; i < len(text)-1; i++ {
+		_ = c.text.PrintfLine("%v-%v", code, text[i])
+	}
+	_ = c.text.PrintfLine("%v %v", code, text[len(text)-1])
+}
+
+// ReadLine reads a line of input.
+func (c *Conn) ReadLine() (string, error) {
+	if err := c.conn.SetReadDeadline(c.nextDeadline()); err != nil {
+		return "", err
+	}
+
+	return c.text.ReadLine()
+}
+
+// parsePath splits MAIL or RCPT argument after the colon into the path in
+// angle brackets and the rest with ESMTP parameters. Closing angle bracket
+// can be escaped or quoted in the local part.
+func parsePath(arg string) (path, params string, err error) {
+	arg = strings.TrimLeft(arg, " ")
+	if !strings.HasPrefix(arg, "<") {
+		return "", "", errors.New("path is not in angle brackets")
+	}
+
+	inQuotes, escaped := false, false
+	for i := 1; i < len(arg); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case arg[i] == '\\':
+			escaped = true
+		case arg[i] == '"':
+			inQuotes = !inQuotes
+		case arg[i] == '>' && !inQuotes:
+			return arg[1:i], arg[i+1:], nil
+		}
+	}
+
+	return "", "", errors.New("path is not closed")
+}
+
+// parseRecipient parses RCPT argument after the colon. Angle brackets are
+// optional for compatibility with clients which do not send them.
+func parseRecipient(arg string) (Recipient, error) {
+	var recipient Recipient
+
+	address, params, err := parsePath(arg)
+	if err != nil {
+		fields := strings.SplitN(strings.TrimSpace(arg), " ", 2)
+		if fields[0] == "" {
+			return recipient, errors.New("recipient is missing")
+		}
+		address, params = fields[0], ""
+		if len(fields) > 1 {
+			params = " " + fields[1]
+		}
+	}
+	recipient.Address = address
+
+	args, err := parseArgs(params)
+	if err != nil {
+		return recipient, errors.New("parameters cannot be parsed")
+	}
+
+	if notify, ok := args["NOTIFY"]; ok {
+		if recipient.Notify, err = parseNotify(notify); err != nil {
+			return recipient, err
+		}
+	}
+
+	if orcpt, ok := args["ORCPT"]; ok {
+		parts := strings.SplitN(orcpt, ";", 2)
+		if len(parts) != 2 {
+			return recipient, errors.New("ORCPT must be address type and address")
+		}
+		original, err := decodeXtext(parts[1])
+		if err != nil {
+			return recipient, errors.New("ORCPT is not valid xtext")
+		}
+		recipient.OriginalRecipient = parts[0] + ";" + original
+	}
+
+	return recipient, nil
+}
+
+// parseNotify parses NOTIFY parameter of RCPT, which is either NEVER or
+// a list of SUCCESS, FAILURE and DELAY.
+func parseNotify(value string) ([]string, error) {
+	notify := strings.Split(strings.ToUpper(value), ",")
+	for _, n := range notify {
+		switch n {
+		case "NEVER":
+			if len(notify) > 1 {
+				return nil, errors.New("NOTIFY=NEVER cannot be combined with other values")
+			}
+		case "SUCCESS", "FAILURE", "DELAY":
+		default:
+			return nil, errors.New("NOTIFY has unknown value " + n)
+		}
+	}
+	return notify, nil
+}
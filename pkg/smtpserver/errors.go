@@ -0,0 +1,37 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+// SMTPError is a reply to the client with reply code (RFC5321) and enhanced
+// status code (RFC3463), e.g. 451 and "4.4.1".
+type SMTPError struct {
+	Code         int
+	EnhancedCode string
+	Message      string
+}
+
+func (err *SMTPError) Error() string {
+	return err.Message
+}
+
+// ErrDataTooLarge is returned when the message is bigger than MaxMessageBytes.
+var ErrDataTooLarge = &SMTPError{ //nolint[gochecknoglobals]
+	Code:         552,
+	EnhancedCode: "5.3.4",
+	Message:      "Maximum message size exceeded",
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCmd splits the line into upper-case command and its argument.
+func parseCmd(line string) (cmd string, arg string, err error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	l := len(line)
+	switch {
+	case strings.HasPrefix(strings.ToUpper(line), "STARTTLS"):
+		return "STARTTLS", "", nil
+	case l == 0:
+		return "", "", nil
+	case l < 4:
+		return "", "", fmt.Errorf("command too short: %q", line)
+	case l == 4:
+		return strings.ToUpper(line), "", nil
+	case l == 5:
+		// Too long to be only command, too short to have argument.
+		return "", "", fmt.Errorf("mangled command: %q", line)
+	}
+
+	if line[4] != ' ' {
+		return "", "", fmt.Errorf("mangled command: %q", line)
+	}
+
+	return strings.ToUpper(line[0:4]), strings.Trim(line[5:], " \n\r"), nil
+}
+
+// parseArgs parses ESMTP parameters, e.g. " BODY=8BITMIME SIZE=1024", into
+// map with upper-case keys. Parameters without value have empty value.
+func parseArgs(arg string) (map[string]string, error) {
+	args := map[string]string{}
+	for _, param := range strings.Fields(arg) {
+		kv := strings.SplitN(param, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("failed to parse parameter: %q", param)
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		args[strings.ToUpper(kv[0])] = kv[1]
+	}
+	return args, nil
+}
+
+func parseHelloArgument(arg string) (string, error) {
+	domain := arg
+	if idx := strings.IndexRune(arg, ' '); idx >= 0 {
+		domain = arg[:idx]
+	}
+	if domain == "" {
+		return "", fmt.Errorf("invalid domain")
+	}
+	return domain, nil
+}
+
+// decodeXtext decodes xtext (RFC3461) of ENVID and ORCPT parameters, where
+// characters outside printable ASCII, "+" and "=" are written as "+XX".
+func decodeXtext(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '+':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("truncated hexchar at %d", i)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid hexchar at %d", i)
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		case c < '!' || c > '~' || c == '=':
+			return "", fmt.Errorf("invalid character at %d", i)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// parseFutureRelease returns the release time of HOLDFOR (seconds from now)
+// or HOLDUNTIL (RFC3339 date-time) parameter of FUTURERELEASE (RFC4865),
+// or zero time when neither is set.
+func parseFutureRelease(args map[string]string, now time.Time) (time.Time, error) {
+	holdFor, isHoldFor := args["HOLDFOR"]
+	holdUntil, isHoldUntil := args["HOLDUNTIL"]
+
+	switch {
+	case isHoldFor && isHoldUntil:
+		return time.Time{}, fmt.Errorf("HOLDFOR and HOLDUNTIL cannot be used together")
+	case isHoldFor:
+		seconds, err := strconv.ParseUint(holdFor, 10, 32)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("HOLDFOR is not a number of seconds")
+		}
+		return now.Add(time.Duration(seconds) * time.Second), nil
+	case isHoldUntil:
+		until, err := time.Parse(time.RFC3339, holdUntil)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("HOLDUNTIL is not a date-time")
+		}
+		return until, nil
+	}
+
+	return time.Time{}, nil
+}
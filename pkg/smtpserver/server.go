@@ -0,0 +1,168 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-sasl"
+)
+
+// SaslServerFactory creates SASL server for the connection.
+type SaslServerFactory func(conn *Conn) sasl.Server
+
+// Server is SMTP submission server.
+type Server struct {
+	// Addr is the TCP address to listen on.
+	Addr string
+	// TLSConfig is used by STARTTLS; without it STARTTLS is not announced.
+	TLSConfig *tls.Config
+
+	Domain            string
+	MaxRecipients     int
+	MaxIdleSeconds    int
+	MaxMessageBytes   int
+	AllowInsecureAuth bool
+	Debug             io.Writer
+
+	// MaxFutureRelease is the longest hold of the message accepted by
+	// FUTURERELEASE (RFC4865); without it FUTURERELEASE is not announced.
+	MaxFutureRelease time.Duration
+
+	Backend Backend
+
+	listener net.Listener
+	caps     []string
+	auths    map[string]SaslServerFactory
+
+	locker sync.Mutex
+	conns  map[*Conn]struct{}
+}
+
+// NewServer creates a new SMTP server with PLAIN authentication.
+func NewServer(be Backend) *Server {
+	return &Server{
+		Backend: be,
+		caps:    []string{"PIPELINING", "8BITMIME", "ENHANCEDSTATUSCODES", "SMTPUTF8", "DSN", "CHUNKING", "BINARYMIME"},
+		auths: map[string]SaslServerFactory{
+			sasl.Plain: func(conn *Conn) sasl.Server {
+				return sasl.NewPlainServer(func(identity, username, password string) error {
+					if identity != "" && identity != username {
+						return errors.New("identities not supported")
+					}
+
+					user, err := be.Login(username, password)
+					if err != nil {
+						return err
+					}
+
+					conn.SetUser(user)
+					return nil
+				})
+			},
+		},
+		conns: make(map[*Conn]struct{}),
+	}
+}
+
+// Serve accepts incoming connections on the Listener l.
+func (s *Server) Serve(l net.Listener) error {
+	s.locker.Lock()
+	s.listener = l
+	s.locker.Unlock()
+	defer s.Close()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handleConn(newConn(c, s))
+	}
+}
+
+func (s *Server) handleConn(c *Conn) {
+	s.locker.Lock()
+	s.conns[c] = struct{}{}
+	s.locker.Unlock()
+
+	defer func() {
+		_ = c.Close()
+
+		s.locker.Lock()
+		delete(s.conns, c)
+		s.locker.Unlock()
+	}()
+
+	c.greet()
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				c.WriteResponse(421, "4.4.2", "Idle timeout, bye bye")
+			}
+			return
+		}
+
+		cmd, arg, err := parseCmd(line)
+		if err != nil {
+			c.nbrErrors++
+			c.WriteResponse(501, "5.5.2", "Bad command")
+			continue
+		}
+
+		if !c.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// EnableAuth enables an authentication mechanism on this server.
+func (s *Server) EnableAuth(name string, f SaslServerFactory) {
+	s.auths[name] = f
+}
+
+// ForEachConn iterates through all opened connections.
+func (s *Server) ForEachConn(f func(*Conn)) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for conn := range s.conns {
+		f(conn)
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package smtpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testBackend struct {
+	envelopes []*Envelope
+	messages  []string
+	err       error
+}
+
+func (b *testBackend) Login(username, password string) (User, error) {
+	if password != "password" {
+		return nil, errors.New("incorrect password")
+	}
+	return &testUser{backend: b}, nil
+}
+
+type testUser struct {
+	backend *testBackend
+}
+
+func (u *testUser) Send(envelope *Envelope, r io.Reader) error {
+	if u.backend.err != nil {
+		return u.backend.err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	u.backend.envelopes = append(u.backend.envelopes, envelope)
+	u.backend.messages = append(u.backend.messages, string(b))
+	return nil
+}
+
+func (u *testUser) Logout() error {
+	return nil
+}
+
+// newTestClient returns client connected to the server and logged in.
+func newTestClient(t *testing.T, be Backend) *textproto.Conn {
+	return newTestServerClient(t, NewServer(be))
+}
+
+func newTestServerClient(t *testing.T, s *Server) *textproto.Conn {
+	s.Domain = "localhost"
+	s.AllowInsecureAuth = true
+
+	serverConn, clientConn := net.Pipe()
+	go s.handleConn(newConn(serverConn, s))
+
+	c := textproto.NewConn(clientConn)
+	_, _, err := c.ReadResponse(220)
+	require.NoError(t, err)
+
+	// user\x00user\x00password
+	cmd(t, c, 250, "EHLO localhost")
+	cmd(t, c, 235, "AUTH PLAIN AHVzZXIAcGFzc3dvcmQ=")
+
+	return c
+}
+
+func cmd(t *testing.T, c *textproto.Conn, code int, format string, args ...interface{}) string {
+	id, err := c.Cmd(format, args...)
+	require.NoError(t, err)
+	c.StartResponse(id)
+	defer c.EndResponse(id)
+	_, msg, err := c.ReadResponse(code)
+	require.NoError(t, err)
+	return msg
+}
+
+func sendMessage(t *testing.T, c *textproto.Conn, dataCode int) string {
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	cmd(t, c, 354, "DATA")
+
+	w := c.DotWriter()
+	_, err := w.Write([]byte("Subject: Hello\r\n\r\nHello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, msg, err := c.ReadResponse(dataCode)
+	require.NoError(t, err)
+	return msg
+}
+
+func TestServerAnnouncesEnhancedStatusCodes(t *testing.T) {
+	s := NewServer(&testBackend{})
+	serverConn, clientConn := net.Pipe()
+	go s.handleConn(newConn(serverConn, s))
+
+	c := textproto.NewConn(clientConn)
+	_, _, err := c.ReadResponse(220)
+	require.NoError(t, err)
+
+	caps := cmd(t, c, 250, "EHLO localhost")
+	require.Contains(t, caps, "ENHANCEDSTATUSCODES")
+	require.Contains(t, caps, "SMTPUTF8")
+	require.Equal(t, "5.7.0 Please authenticate first.", cmd(t, c, 530, "MAIL FROM:<from@example.com>"))
+}
+
+func TestServerSend(t *testing.T) {
+	be := &testBackend{}
+	c := newTestClient(t, be)
+
+	require.Equal(t, "2.0.0 Ok: queued", sendMessage(t, c, 250))
+	require.Equal(t, []string{"Subject: Hello\n\nHello\n"}, be.messages)
+}
+
+func TestServerSendFailure(t *testing.T) {
+	be := &testBackend{err: &SMTPError{Code: 451, EnhancedCode: "4.4.1", Message: "Server cannot be reached"}}
+	c := newTestClient(t, be)
+
+	require.Equal(t, "4.4.1 Server cannot be reached", sendMessage(t, c, 451))
+
+	be.err = errors.New("unknown")
+	require.Equal(t, "5.0.0 Transaction failed: unknown", sendMessage(t, c, 554))
+
+	// Unread message does not break the session.
+	be.err = nil
+	require.Equal(t, "2.0.0 Ok: queued", sendMessage(t, c, 250))
+}
+
+func TestServerRefusesAuthWithoutTLS(t *testing.T) {
+	s := NewServer(&testBackend{})
+	serverConn, clientConn := net.Pipe()
+	go s.handleConn(newConn(serverConn, s))
+
+	c := textproto.NewConn(clientConn)
+	_, _, err := c.ReadResponse(220)
+	require.NoError(t, err)
+
+	require.NotContains(t, cmd(t, c, 250, "EHLO localhost"), "AUTH")
+	require.Equal(t, "5.7.0 Must issue a STARTTLS command first.", cmd(t, c, 530, "AUTH PLAIN AHVzZXIAcGFzc3dvcmQ="))
+}
+
+func TestServerLimits(t *testing.T) {
+	s := NewServer(&testBackend{})
+	s.MaxMessageBytes = 100
+	s.MaxRecipients = 1
+	c := newTestServerClient(t, s)
+
+	require.Contains(t, cmd(t, c, 250, "EHLO localhost"), "SIZE 100")
+
+	require.Equal(t, "5.3.4 Max message size exceeded", cmd(t, c, 552, "MAIL FROM:<from@example.com> SIZE=101"))
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> SIZE=100")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	require.Equal(t, "4.5.3 Maximum limit of 1 recipients reached", cmd(t, c, 452, "RCPT TO:<other@example.com>"))
+}
+
+func TestServerSMTPUTF8(t *testing.T) {
+	c := newTestClient(t, &testBackend{})
+
+	require.Equal(t, "5.5.4 SMTPUTF8 parameter has no value", cmd(t, c, 501, "MAIL FROM:<from@example.com> SMTPUTF8=yes"))
+	cmd(t, c, 250, "MAIL FROM:<josé@example.com> SMTPUTF8")
+	cmd(t, c, 250, "RCPT TO:<用户@例子.广告>")
+}
+
+func TestServerDSN(t *testing.T) {
+	be := &testBackend{}
+	c := newTestClient(t, be)
+
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> RET=HDRS ENVID=QQ+2B314159")
+	cmd(t, c, 250, "RCPT TO:<to@example.com> NOTIFY=FAILURE,DELAY ORCPT=rfc822;To+2BTag@example.com")
+	cmd(t, c, 250, "RCPT TO:other@example.com")
+	cmd(t, c, 354, "DATA")
+	w := c.DotWriter()
+	_, err := w.Write([]byte("Subject: Hello\r\n\r\nHello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, _, err = c.ReadResponse(250)
+	require.NoError(t, err)
+
+	require.Equal(t, []*Envelope{{
+		From: "from@example.com",
+		To: []Recipient{
+			{Address: "to@example.com", Notify: []string{"FAILURE", "DELAY"}, OriginalRecipient: "rfc822;To+Tag@example.com"},
+			{Address: "other@example.com"},
+		},
+		Return:     "HDRS",
+		EnvelopeID: "QQ+314159",
+	}}, be.envelopes)
+
+	cmd(t, c, 501, "MAIL FROM:<from@example.com> RET=ALL")
+	cmd(t, c, 501, "MAIL FROM:<from@example.com> ENVID=QQ+2")
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 501, "RCPT TO:<to@example.com> NOTIFY=NEVER,FAILURE")
+	cmd(t, c, 501, "RCPT TO:<to@example.com> ORCPT=to@example.com")
+}
+
+// bdat sends the chunk by BDAT command and returns the reply.
+func bdat(t *testing.T, c *textproto.Conn, code int, chunk string, last bool) string {
+	arg := fmt.Sprintf("BDAT %d", len(chunk))
+	if last {
+		arg += " LAST"
+	}
+	_, err := c.W.WriteString(arg + "\r\n" + chunk)
+	require.NoError(t, err)
+	require.NoError(t, c.W.Flush())
+
+	_, msg, err := c.ReadResponse(code)
+	require.NoError(t, err)
+	return msg
+}
+
+func TestServerChunking(t *testing.T) {
+	be := &testBackend{}
+	c := newTestClient(t, be)
+
+	// Chunk without transaction is read and refused.
+	require.Equal(t, "5.5.1 Missing RCPT TO command.", bdat(t, c, 503, "NOOP\r\n", false))
+
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	require.Equal(t, "2.0.0 14 octets received", bdat(t, c, 250, "Subject: Hello", false))
+	require.Equal(t, "2.0.0 Ok: queued", bdat(t, c, 250, "\r\n\r\n.Hello\r\n", true))
+	require.Equal(t, []string{"Subject: Hello\r\n\r\n.Hello\r\n"}, be.messages)
+
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	bdat(t, c, 250, "Subject: Hello", false)
+	cmd(t, c, 503, "DATA")
+	cmd(t, c, 250, "RSET")
+
+	be.err = &SMTPError{Code: 451, EnhancedCode: "4.4.1", Message: "Server cannot be reached"}
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	require.Equal(t, "4.4.1 Server cannot be reached", bdat(t, c, 451, "Subject: Hello\r\n\r\nHello\r\n", true))
+
+	cmd(t, c, 501, "BDAT")
+	cmd(t, c, 501, "BDAT 10 NOW")
+}
+
+func TestServerBinaryMIME(t *testing.T) {
+	be := &testBackend{}
+	c := newTestClient(t, be)
+
+	require.Contains(t, cmd(t, c, 250, "EHLO localhost"), "BINARYMIME")
+
+	require.Equal(t, "5.5.4 BODY parameter must be 7BIT, 8BITMIME or BINARYMIME", cmd(t, c, 501, "MAIL FROM:<from@example.com> BODY=BINARY"))
+
+	// Binary message cannot be sent by DATA.
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> BODY=BINARYMIME")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	require.Equal(t, "5.5.1 DATA cannot be used with BODY=BINARYMIME, use BDAT.", cmd(t, c, 503, "DATA"))
+	cmd(t, c, 250, "RSET")
+
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> BODY=binarymime")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	require.Equal(t, "2.0.0 Ok: queued", bdat(t, c, 250, "Subject: Hello\r\n\r\n\x00\xff\r\n", true))
+	require.Equal(t, []string{"Subject: Hello\r\n\r\n\x00\xff\r\n"}, be.messages)
+
+	// BINARYMIME applies only to its transaction.
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> BODY=8BITMIME")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	cmd(t, c, 354, "DATA")
+}
+
+func TestServerChunkingTooLarge(t *testing.T) {
+	s := NewServer(&testBackend{})
+	s.MaxMessageBytes = 10
+	c := newTestServerClient(t, s)
+
+	cmd(t, c, 250, "MAIL FROM:<from@example.com>")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	bdat(t, c, 250, "Subject: ", false)
+	require.Equal(t, "5.3.4 Maximum message size exceeded", bdat(t, c, 552, "Hello\r\n", false))
+	bdat(t, c, 503, "\r\nHello\r\n", true)
+	cmd(t, c, 250, "NOOP")
+}
+
+func TestServerFutureRelease(t *testing.T) {
+	require.NotContains(t, cmd(t, newTestClient(t, &testBackend{}), 250, "EHLO localhost"), "FUTURERELEASE")
+
+	be := &testBackend{}
+	s := NewServer(be)
+	s.MaxFutureRelease = 24 * time.Hour
+	c := newTestServerClient(t, s)
+
+	require.Contains(t, cmd(t, c, 250, "EHLO localhost"), "FUTURERELEASE 86400 ")
+
+	before := time.Now()
+	cmd(t, c, 250, "MAIL FROM:<from@example.com> HOLDFOR=3600")
+	cmd(t, c, 250, "RCPT TO:<to@example.com>")
+	cmd(t, c, 354, "DATA")
+	w := c.DotWriter()
+	_, err := w.Write([]byte("Subject: Hello\r\n\r\nHello\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, _, err = c.ReadResponse(250)
+	require.NoError(t, err)
+
+	require.Len(t, be.envelopes, 1)
+	require.False(t, be.envelopes[0].HoldUntil.Before(before.Add(time.Hour)))
+	require.False(t, be.envelopes[0].HoldUntil.After(time.Now().Add(time.Hour)))
+
+	cmd(t, c, 501, "MAIL FROM:<from@example.com> HOLDFOR=soon")
+	cmd(t, c, 501, "MAIL FROM:<from@example.com> HOLDFOR=60 HOLDUNTIL=2030-01-01T00:00:00Z")
+	cmd(t, c, 554, "MAIL FROM:<from@example.com> HOLDFOR=86460")
+	cmd(t, c, 554, "MAIL FROM:<from@example.com> HOLDUNTIL=%v", time.Now().Add(48*time.Hour).UTC().Format(time.RFC3339))
+}
+
+func TestParseFutureRelease(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	until, err := parseFutureRelease(map[string]string{}, now)
+	require.NoError(t, err)
+	require.True(t, until.IsZero())
+
+	until, err = parseFutureRelease(map[string]string{"HOLDFOR": "90"}, now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(90*time.Second), until)
+
+	until, err = parseFutureRelease(map[string]string{"HOLDUNTIL": "2021-06-02T10:00:00+02:00"}, now)
+	require.NoError(t, err)
+	require.True(t, now.Add(20*time.Hour).Equal(until))
+
+	for _, args := range []map[string]string{
+		{"HOLDFOR": "-1"},
+		{"HOLDUNTIL": "tomorrow"},
+		{"HOLDFOR": "1", "HOLDUNTIL": "2021-06-02T10:00:00Z"},
+	} {
+		_, err := parseFutureRelease(args, now)
+		require.Error(t, err, args)
+	}
+}
+
+func TestDecodeXtext(t *testing.T) {
+	for xtext, want := range map[string]string{
+		"":                 "",
+		"plain":            "plain",
+		"user+2Btag+3Dx":   "user+tag=x",
+		"+E2+82+AC":        "\u20ac",
+		"<id@example.com>": "<id@example.com>",
+		"a+20b":            "a b",
+	} {
+		got, err := decodeXtext(xtext)
+		require.NoError(t, err, xtext)
+		require.Equal(t, want, got, xtext)
+	}
+
+	for _, xtext := range []string{"a=b", "a b", "+2", "+ZZ"} {
+		_, err := decodeXtext(xtext)
+		require.Error(t, err, xtext)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		arg, path, params string
+	}{
+		{"<user@example.com>", "user@example.com", ""},
+		{" <user@example.com> SIZE=10", "user@example.com", " SIZE=10"},
+		{`<"us>er"@example.com>`, `"us>er"@example.com`, ""},
+		{`<us\>er@example.com>`, `us\>er@example.com`, ""},
+		{"<>", "", ""},
+	}
+
+	for _, test := range tests {
+		path, params, err := parsePath(test.arg)
+		require.NoError(t, err)
+		require.Equal(t, test.path, path)
+		require.Equal(t, test.params, params)
+	}
+
+	_, _, err := parsePath("user@example.com")
+	require.Error(t, err)
+	_, _, err = parsePath("<user@example.com")
+	require.Error(t, err)
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package smtpserver implements SMTP submission server (RFC5321, RFC6409)
+// with extensions 8BITMIME (RFC6152), AUTH (RFC4954), STARTTLS (RFC3207),
+// SIZE (RFC1870), PIPELINING (RFC2920), ENHANCEDSTATUSCODES (RFC2034),
+// SMTPUTF8 (RFC6531), DSN (RFC3461), CHUNKING (RFC3030) and FUTURERELEASE
+// (RFC4865).
+//
+// It is based on the server of go-smtp fork used before, which neither lets
+// Bridge announce other extensions and read their parameters nor reply to
+// failed DATA by other code than 554. go-smtp is available under the MIT
+// License, see LICENSE-go-smtp.
+package smtpserver
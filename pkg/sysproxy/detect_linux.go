@@ -0,0 +1,61 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// detectSystemSettings reads KDE settings on KDE and GNOME settings
+// elsewhere, as other desktops usually follow GNOME.
+func detectSystemSettings() (*settings, error) {
+	if strings.Contains(strings.ToUpper(os.Getenv("XDG_CURRENT_DESKTOP")), "KDE") {
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			configDir = filepath.Join(home, ".config")
+		}
+		f, err := os.Open(filepath.Join(configDir, "kioslaverc")) //nolint[gosec]
+		if os.IsNotExist(err) {
+			return &settings{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close() //nolint[errcheck]
+		return parseKioslaverc(f), nil
+	}
+
+	gsettings, err := exec.LookPath("gsettings")
+	if err != nil {
+		return &settings{}, nil
+	}
+	return parseGSettings(func(schema, key string) string {
+		out, err := exec.Command(gsettings, "get", schema, key).Output() //nolint[gosec]
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	}), nil
+}
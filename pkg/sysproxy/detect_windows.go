@@ -0,0 +1,45 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const internetSettingsKey = `HKCU\Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+func detectSystemSettings() (*settings, error) {
+	out, err := regQuery(internetSettingsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Auto-detection flag is in the connection settings; missing key only
+	// means the flag was never changed.
+	connections, _ := regQuery(internetSettingsKey + `\Connections`)
+
+	return parseRegQuery(string(out) + "\n" + string(connections)), nil
+}
+
+// regQuery runs reg.exe without flashing console window of GUI.
+func regQuery(key string) ([]byte, error) {
+	cmd := exec.Command("reg", "query", key)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd.Output()
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PAC scripts are JavaScript and there is no JavaScript engine in Bridge,
+// so they are loaded and evaluated by the system: WinHTTP on Windows,
+// CFNetwork on macOS and libproxy on Linux.
+
+var errPACUnsupported = errors.New("PAC scripts are not supported on this system")
+
+// pacResolver returns the proxy chosen by the PAC script for the URL, nil
+// for direct connection.
+type pacResolver interface {
+	findProxy(reqURL *url.URL) (*url.URL, error)
+}
+
+// newPACResolver returns resolver by the PAC script at the URL, or by the
+// one found by WPAD when the URL is empty. It is replaced in tests.
+var newPACResolver = newSystemPACResolver //nolint[gochecknoglobals]
+
+// parseProxyList returns the first proxy of the list of URLs, e.g.
+// `http://proxy:8080 direct://`, supported by Bridge, nil for direct
+// connection or nothing usable.
+func parseProxyList(list []string) *url.URL {
+	for _, item := range list {
+		proxyURL, err := url.Parse(item)
+		if err != nil || !strings.Contains(item, "://") {
+			continue
+		}
+		switch proxyURL.Scheme {
+		case "direct":
+			return nil
+		case "http", "https":
+			return &url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host, User: proxyURL.User}
+		case "socks", "socks5":
+			return &url.URL{Scheme: "socks5", Host: proxyURL.Host, User: proxyURL.User}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework CFNetwork
+
+#include <stdlib.h>
+#include <string.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <CFNetwork/CFNetwork.h>
+
+// appendProxy appends one proxy of CFNetwork as URL, e.g. http://host:port.
+static void appendProxy(CFMutableStringRef list, CFDictionaryRef proxy) {
+	CFStringRef type = CFDictionaryGetValue(proxy, kCFProxyTypeKey);
+	if (type == NULL) {
+		return;
+	}
+	if (CFEqual(type, kCFProxyTypeNone)) {
+		CFStringAppend(list, CFSTR("direct:// "));
+		return;
+	}
+
+	CFStringRef scheme = NULL;
+	if (CFEqual(type, kCFProxyTypeHTTP)) {
+		scheme = CFSTR("http");
+	} else if (CFEqual(type, kCFProxyTypeHTTPS)) {
+		// HTTPS in CFNetwork is a proxy of HTTPS traffic, spoken to by HTTP.
+		scheme = CFSTR("http");
+	} else if (CFEqual(type, kCFProxyTypeSOCKS)) {
+		scheme = CFSTR("socks5");
+	} else {
+		return;
+	}
+
+	CFStringRef host = CFDictionaryGetValue(proxy, kCFProxyHostNameKey);
+	CFNumberRef portNumber = CFDictionaryGetValue(proxy, kCFProxyPortNumberKey);
+	int port = 0;
+	if (host == NULL || portNumber == NULL || !CFNumberGetValue(portNumber, kCFNumberIntType, &port)) {
+		return;
+	}
+	CFStringAppendFormat(list, NULL, CFSTR("%@://%@:%d "), scheme, host, port);
+}
+
+// findProxies evaluates the PAC script for the URL and returns the space
+// separated proxies, or NULL on failure. The result must be freed.
+static char *findProxies(const char *script, const char *rawURL) {
+	char *result = NULL;
+
+	CFStringRef scriptString = CFStringCreateWithCString(NULL, script, kCFStringEncodingUTF8);
+	CFURLRef url = CFURLCreateWithBytes(NULL, (const UInt8 *)rawURL, strlen(rawURL), kCFStringEncodingUTF8, NULL);
+	CFArrayRef proxies = NULL;
+	if (scriptString != NULL && url != NULL) {
+		CFErrorRef error = NULL;
+		proxies = CFNetworkCopyProxiesForAutoConfigurationScript(scriptString, url, &error);
+		if (error != NULL) {
+			CFRelease(error);
+		}
+	}
+
+	if (proxies != NULL) {
+		CFMutableStringRef list = CFStringCreateMutable(NULL, 0);
+		for (CFIndex i = 0; i < CFArrayGetCount(proxies); i++) {
+			appendProxy(list, CFArrayGetValueAtIndex(proxies, i));
+		}
+
+		CFIndex size = CFStringGetMaximumSizeForEncoding(CFStringGetLength(list), kCFStringEncodingUTF8) + 1;
+		result = malloc(size);
+		if (result != NULL && !CFStringGetCString(list, result, size, kCFStringEncodingUTF8)) {
+			free(result);
+			result = NULL;
+		}
+
+		CFRelease(list);
+		CFRelease(proxies);
+	}
+
+	if (url != NULL) {
+		CFRelease(url);
+	}
+	if (scriptString != NULL) {
+		CFRelease(scriptString);
+	}
+	return result;
+}
+*/
+import "C"
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const pacMaxSize = 1 << 20
+
+// cfNetworkResolver evaluates the PAC script by CFNetwork, as Safari does.
+// CFNetwork can also download the script but only asynchronously with run
+// loop, so it is downloaded by Bridge.
+type cfNetworkResolver struct {
+	script string
+}
+
+func newSystemPACResolver(pacURL string) (pacResolver, error) {
+	if pacURL != "" {
+		script, err := fetchPAC(pacURL)
+		if err != nil {
+			return nil, err
+		}
+		return &cfNetworkResolver{script: script}, nil
+	}
+
+	for _, pacURL := range getWPADURLs() {
+		if script, err := fetchPAC(pacURL); err == nil {
+			log.WithField("url", pacURL).Info("PAC script found by WPAD")
+			return &cfNetworkResolver{script: script}, nil
+		}
+	}
+	return nil, errors.New("no PAC script found by WPAD")
+}
+
+func (r *cfNetworkResolver) findProxy(reqURL *url.URL) (*url.URL, error) {
+	script := C.CString(r.script)
+	defer C.free(unsafe.Pointer(script))
+	rawURL := C.CString(reqURL.String())
+	defer C.free(unsafe.Pointer(rawURL))
+
+	proxies := C.findProxies(script, rawURL)
+	if proxies == nil {
+		return nil, errors.New("CFNetwork failed to evaluate PAC script")
+	}
+	defer C.free(unsafe.Pointer(proxies))
+
+	return parseProxyList(strings.Fields(C.GoString(proxies))), nil
+}
+
+// getWPADURLs returns where PAC script is looked for by DNS variant of WPAD:
+// wpad in the search domain and in parent domains of the host.
+func getWPADURLs() []string {
+	urls := []string{"http://wpad/wpad.dat"}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return urls
+	}
+	labels := strings.Split(strings.Trim(hostname, "."), ".")
+	for i := 1; i < len(labels)-1; i++ {
+		urls = append(urls, "http://wpad."+strings.Join(labels[i:], ".")+"/wpad.dat")
+	}
+	return urls
+}
+
+// fetchPAC downloads PAC script directly, never through a proxy, or reads it
+// from a file.
+func fetchPAC(pacURL string) (string, error) {
+	var script []byte
+	var err error
+
+	switch {
+	case strings.HasPrefix(pacURL, "file://"):
+		script, err = ioutil.ReadFile(strings.TrimPrefix(pacURL, "file://"))
+	case strings.HasPrefix(pacURL, "/"):
+		script, err = ioutil.ReadFile(pacURL) //nolint[gosec]
+	default:
+		script, err = downloadPAC(pacURL)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(script), nil
+}
+
+func downloadPAC(pacURL string) ([]byte, error) {
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: nil},
+		Timeout:   pacTimeout,
+	}
+	resp, err := client.Get(pacURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint[errcheck]
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("PAC script request failed with status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, pacMaxSize))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// libproxyCommand is the command line tool of libproxy, in package
+// libproxy-tools or libproxy1-bin by distribution.
+const libproxyCommand = "proxy"
+
+// libproxyResolver asks libproxy, which reads the same settings of the
+// desktop, downloads the PAC script or finds it by WPAD and evaluates it
+// by its JavaScript engine.
+type libproxyResolver struct {
+	path string
+}
+
+// newSystemPACResolver ignores the URL as libproxy reads it from the
+// settings itself.
+func newSystemPACResolver(pacURL string) (pacResolver, error) {
+	path, err := exec.LookPath(libproxyCommand)
+	if err != nil {
+		return nil, errors.Wrap(errPACUnsupported, "install libproxy tools to use PAC scripts")
+	}
+	return &libproxyResolver{path: path}, nil
+}
+
+func (r *libproxyResolver) findProxy(reqURL *url.URL) (*url.URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pacTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, r.path, reqURL.String()).Output() //nolint[gosec]
+	if err != nil {
+		return nil, errors.Wrap(err, "libproxy failed")
+	}
+	return parseProxyList(strings.Fields(string(out))), nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibproxyResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libproxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	// Fake proxy command answering as libproxy does, one line per URL.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, libproxyCommand), []byte(`#!/bin/sh
+case "$1" in
+	https://api.protonmail.ch/*) echo "http://pac:3128 direct://" ;;
+	*) echo "direct://" ;;
+esac
+`), 0700))
+
+	prevPath := os.Getenv("PATH")
+	require.NoError(t, os.Setenv("PATH", dir))
+	defer os.Setenv("PATH", prevPath) //nolint[errcheck]
+
+	resolver, err := newSystemPACResolver("")
+	require.NoError(t, err)
+
+	api, err := url.Parse("https://api.protonmail.ch/tests/ping")
+	require.NoError(t, err)
+	proxyURL, err := resolver.findProxy(api)
+	require.NoError(t, err)
+	require.Equal(t, &url.URL{Scheme: "http", Host: "pac:3128"}, proxyURL)
+
+	other, err := url.Parse("https://example.com/")
+	require.NoError(t, err)
+	proxyURL, err = resolver.findProxy(other)
+	require.NoError(t, err)
+	require.Nil(t, proxyURL)
+
+	require.NoError(t, os.Setenv("PATH", ""))
+	_, err = newSystemPACResolver("")
+	require.Error(t, err)
+}
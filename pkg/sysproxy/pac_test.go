@@ -0,0 +1,45 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProxyList(t *testing.T) {
+	tests := []struct {
+		list string
+		want *url.URL
+	}{
+		{"", nil},
+		{"direct://", nil},
+		{"http://proxy:8080 direct://", &url.URL{Scheme: "http", Host: "proxy:8080"}},
+		{"https://proxy:443", &url.URL{Scheme: "https", Host: "proxy:443"}},
+		{"socks://proxy:1080", &url.URL{Scheme: "socks5", Host: "proxy:1080"}},
+		{"socks4://old:1080 http://fallback:3128", &url.URL{Scheme: "http", Host: "fallback:3128"}},
+		{"direct:// http://proxy:8080", nil},
+		{"proxy:8080", nil},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.want, parseProxyList(strings.Fields(test.list)), test.list)
+	}
+}
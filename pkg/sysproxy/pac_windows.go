@@ -0,0 +1,136 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"net/url"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	winHTTPAccessTypeNoProxy    = 1
+	winHTTPAccessTypeNamedProxy = 3
+
+	winHTTPAutoProxyAutoDetect = 0x1
+	winHTTPAutoProxyConfigURL  = 0x2
+
+	winHTTPAutoDetectTypeDHCP = 0x1
+	winHTTPAutoDetectTypeDNSA = 0x2
+)
+
+var (
+	winHTTP                   = syscall.NewLazyDLL("winhttp.dll")                        //nolint[gochecknoglobals]
+	procWinHTTPOpen           = winHTTP.NewProc("WinHttpOpen")                           //nolint[gochecknoglobals]
+	procWinHTTPCloseHandle    = winHTTP.NewProc("WinHttpCloseHandle")                    //nolint[gochecknoglobals]
+	procWinHTTPGetProxyForURL = winHTTP.NewProc("WinHttpGetProxyForUrl")                 //nolint[gochecknoglobals]
+	procGlobalFree            = syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalFree") //nolint[gochecknoglobals]
+)
+
+// winHTTPAutoProxyOptions is WINHTTP_AUTOPROXY_OPTIONS.
+type winHTTPAutoProxyOptions struct {
+	flags                 uint32
+	autoDetectFlags       uint32
+	autoConfigURL         *uint16
+	reserved1             uintptr
+	reserved2             uint32
+	autoLogonIfChallenged int32
+}
+
+// winHTTPProxyInfo is WINHTTP_PROXY_INFO.
+type winHTTPProxyInfo struct {
+	accessType  uint32
+	proxy       *uint16
+	proxyBypass *uint16
+}
+
+// winHTTPResolver lets WinHTTP download the PAC script, or find it by WPAD
+// incl. DHCP, and evaluate it, as Windows applications do.
+type winHTTPResolver struct {
+	pacURL string
+}
+
+func newSystemPACResolver(pacURL string) (pacResolver, error) {
+	if err := procWinHTTPGetProxyForURL.Find(); err != nil {
+		return nil, errors.Wrap(errPACUnsupported, err.Error())
+	}
+	return &winHTTPResolver{pacURL: pacURL}, nil
+}
+
+func (r *winHTTPResolver) findProxy(reqURL *url.URL) (*url.URL, error) {
+	session, _, err := procWinHTTPOpen.Call(0, winHTTPAccessTypeNoProxy, 0, 0, 0)
+	if session == 0 {
+		return nil, errors.Wrap(err, "WinHttpOpen failed")
+	}
+	defer procWinHTTPCloseHandle.Call(session) //nolint[errcheck]
+
+	options := winHTTPAutoProxyOptions{autoLogonIfChallenged: 1}
+	if r.pacURL != "" {
+		options.flags = winHTTPAutoProxyConfigURL
+		if options.autoConfigURL, err = syscall.UTF16PtrFromString(r.pacURL); err != nil {
+			return nil, err
+		}
+	} else {
+		options.flags = winHTTPAutoProxyAutoDetect
+		options.autoDetectFlags = winHTTPAutoDetectTypeDHCP | winHTTPAutoDetectTypeDNSA
+	}
+
+	rawURL, err := syscall.UTF16PtrFromString(reqURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var info winHTTPProxyInfo
+	ok, _, err := procWinHTTPGetProxyForURL.Call(
+		session,
+		uintptr(unsafe.Pointer(rawURL)),
+		uintptr(unsafe.Pointer(&options)),
+		uintptr(unsafe.Pointer(&info)),
+	)
+	if ok == 0 {
+		return nil, errors.Wrap(err, "WinHttpGetProxyForUrl failed")
+	}
+	defer globalFree(info.proxy)
+	defer globalFree(info.proxyBypass)
+
+	if info.accessType != winHTTPAccessTypeNamedProxy || info.proxy == nil {
+		return nil, nil
+	}
+	return parseWindowsProxyServer(utf16PtrToString(info.proxy)), nil
+}
+
+func globalFree(p *uint16) {
+	if p != nil {
+		procGlobalFree.Call(uintptr(unsafe.Pointer(p))) //nolint[errcheck]
+	}
+}
+
+// utf16PtrToString reads zero terminated string allocated by WinHTTP.
+func utf16PtrToString(p *uint16) string {
+	var chars []uint16
+	for ptr := unsafe.Pointer(p); ; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		char := *(*uint16)(ptr)
+		if char == 0 {
+			break
+		}
+		chars = append(chars, char)
+	}
+	return syscall.UTF16ToString(chars)
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pacTimeout limits download and evaluation of PAC script.
+const pacTimeout = 10 * time.Second
+
+// settings are proxy settings of the system. PAC script has priority over
+// discovery and both over the manual proxy, as browsers do.
+type settings struct {
+	proxy        *url.URL // Manual proxy of HTTPS traffic.
+	bypass       []string // Hosts connected directly instead of the manual proxy.
+	pacURL       string
+	autoDiscover bool
+}
+
+// isBypassed checks the host against exceptions of the manual proxy: exact
+// hosts, domains starting with * or ., wildcards, CIDR ranges and <local>
+// for hosts without dot.
+func (s *settings) isBypassed(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range s.bypass {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == "":
+			continue
+		case pattern == "<local>":
+			if !strings.Contains(host, ".") {
+				return true
+			}
+		case strings.Contains(pattern, "/"):
+			if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+				if ip := net.ParseIP(host); ip != nil && ipNet.Contains(ip) {
+					return true
+				}
+			}
+		case strings.HasPrefix(pattern, "*."), strings.HasPrefix(pattern, "."):
+			domain := strings.TrimPrefix(pattern, "*")
+			if strings.HasSuffix(host, domain) || host == domain[1:] {
+				return true
+			}
+		case strings.ContainsAny(pattern, "*?"):
+			if shExpMatch(host, pattern) {
+				return true
+			}
+		case host == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+// shExpMatch matches shell expression with `*` and `?` wildcards.
+func shExpMatch(str, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	matched, err := regexp.MatchString(b.String(), str)
+	if err != nil {
+		log.WithError(err).WithField("pattern", pattern).Warn("Invalid shell expression")
+		return false
+	}
+	return matched
+}
+
+// newHostPortProxy returns proxy URL from host and port as they are kept
+// separately by some systems. Zero port means the host contains it.
+func newHostPortProxy(scheme, host, port string) *url.URL {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return nil
+	}
+	if strings.Contains(host, "://") {
+		if proxyURL, err := url.Parse(host); err == nil {
+			host = proxyURL.Host
+		}
+	}
+	if port = strings.TrimSpace(port); port != "" && port != "0" {
+		host = net.JoinHostPort(host, port)
+	}
+	return &url.URL{Scheme: scheme, Host: host}
+}
+
+// parseScutil reads output of `scutil --proxy` on macOS.
+func parseScutil(out string) *settings {
+	values := map[string]string{}
+	var exceptions []string
+	inExceptions := false
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "}" {
+			inExceptions = false
+			continue
+		}
+		parts := strings.SplitN(line, " : ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if inExceptions {
+			exceptions = append(exceptions, value)
+			continue
+		}
+		if key == "ExceptionsList" {
+			inExceptions = true
+			continue
+		}
+		values[key] = value
+	}
+
+	s := &settings{bypass: exceptions}
+	switch {
+	case values["HTTPSEnable"] == "1":
+		s.proxy = newHostPortProxy("http", values["HTTPSProxy"], values["HTTPSPort"])
+	case values["HTTPEnable"] == "1":
+		s.proxy = newHostPortProxy("http", values["HTTPProxy"], values["HTTPPort"])
+	case values["SOCKSEnable"] == "1":
+		s.proxy = newHostPortProxy("socks5", values["SOCKSProxy"], values["SOCKSPort"])
+	}
+	if values["ProxyAutoConfigEnable"] == "1" {
+		s.pacURL = values["ProxyAutoConfigURLString"]
+	}
+	s.autoDiscover = values["ProxyAutoDiscoveryEnable"] == "1"
+	return s
+}
+
+// parseRegQuery reads output of `reg query` of Internet Settings and their
+// Connections key on Windows.
+func parseRegQuery(out string) *settings {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[1], "REG_") {
+			continue
+		}
+		values[fields[0]] = strings.Join(fields[2:], " ")
+	}
+
+	s := &settings{}
+	if values["ProxyEnable"] == "0x1" {
+		s.proxy = parseWindowsProxyServer(values["ProxyServer"])
+		for _, pattern := range strings.Split(values["ProxyOverride"], ";") {
+			if pattern != "" {
+				s.bypass = append(s.bypass, pattern)
+			}
+		}
+	}
+	s.pacURL = values["AutoConfigURL"]
+
+	// Flags of the connection are the ninth byte, 0x08 is "Automatically
+	// detect settings".
+	if raw, err := hex.DecodeString(values["DefaultConnectionSettings"]); err == nil && len(raw) > 8 {
+		s.autoDiscover = raw[8]&0x08 != 0
+	}
+	return s
+}
+
+// parseWindowsProxyServer reads either proxies for all protocols, e.g.
+// `proxy:80;backup:80` of which the first is used, or proxies by protocol,
+// e.g. `http=proxy:80;https=proxy:443`.
+func parseWindowsProxyServer(value string) *url.URL {
+	if !strings.Contains(value, "=") {
+		servers := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ' ' })
+		if len(servers) == 0 {
+			return nil
+		}
+		return newHostPortProxy("http", servers[0], "")
+	}
+
+	byProtocol := map[string]string{}
+	for _, part := range strings.Split(value, ";") {
+		if split := strings.SplitN(part, "=", 2); len(split) == 2 {
+			byProtocol[strings.ToLower(split[0])] = split[1]
+		}
+	}
+	for _, protocol := range []string{"https", "http"} {
+		if server := byProtocol[protocol]; server != "" {
+			return newHostPortProxy("http", server, "")
+		}
+	}
+	return nil
+}
+
+// parseKioslaverc reads proxy settings of KDE.
+func parseKioslaverc(r io.Reader) *settings {
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		if section != "[Proxy Settings]" {
+			continue
+		}
+		if split := strings.SplitN(line, "=", 2); len(split) == 2 {
+			values[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+		}
+	}
+
+	s := &settings{}
+	switch values["ProxyType"] {
+	case "1":
+		for _, key := range []string{"httpsProxy", "httpProxy"} {
+			if value := values[key]; value != "" {
+				// Newer KDE keeps the port separated by space.
+				hostPort := strings.Fields(value)
+				port := ""
+				if len(hostPort) == 2 {
+					port = hostPort[1]
+				}
+				s.proxy = newHostPortProxy("http", hostPort[0], port)
+				break
+			}
+		}
+		for _, pattern := range strings.Split(values["NoProxyFor"], ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				s.bypass = append(s.bypass, pattern)
+			}
+		}
+	case "2":
+		s.pacURL = values["Proxy Config Script"]
+	case "3":
+		s.autoDiscover = true
+	}
+	return s
+}
+
+// parseGSettings reads proxy settings of GNOME by the getter of values as
+// printed by gsettings.
+func parseGSettings(getRaw func(schema, key string) string) *settings {
+	get := func(schema, key string) string {
+		return parseGVariantString(getRaw(schema, key))
+	}
+
+	s := &settings{}
+	switch get("org.gnome.system.proxy", "mode") {
+	case "manual":
+		for _, protocol := range []string{"https", "http", "socks"} {
+			schema := "org.gnome.system.proxy." + protocol
+			if host := get(schema, "host"); host != "" {
+				scheme := "http"
+				if protocol == "socks" {
+					scheme = "socks5"
+				}
+				s.proxy = newHostPortProxy(scheme, host, get(schema, "port"))
+				break
+			}
+		}
+		s.bypass = parseGVariantList(getRaw("org.gnome.system.proxy", "ignore-hosts"))
+	case "auto":
+		if s.pacURL = get("org.gnome.system.proxy", "autoconfig-url"); s.pacURL == "" {
+			s.autoDiscover = true
+		}
+	}
+	return s
+}
+
+// parseGVariantString removes quotes of string printed by gsettings.
+func parseGVariantString(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseGVariantList reads list of strings printed by gsettings, e.g.
+// `['localhost', '127.0.0.0/8']`.
+func parseGVariantList(value string) (list []string) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "@as ")
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	for _, item := range strings.Split(value, ",") {
+		if item = parseGVariantString(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package sysproxy
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScutil(t *testing.T) {
+	s := parseScutil(`<dictionary> {
+  ExceptionsList : <array> {
+    0 : *.local
+    1 : 169.254/16
+  }
+  FTPPassive : 1
+  HTTPEnable : 1
+  HTTPPort : 3128
+  HTTPProxy : proxy.example.com
+  HTTPSEnable : 0
+  ProxyAutoDiscoveryEnable : 0
+}`)
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy.example.com:3128"}, s.proxy)
+	require.Equal(t, []string{"*.local", "169.254/16"}, s.bypass)
+	require.Equal(t, "", s.pacURL)
+	require.False(t, s.autoDiscover)
+
+	s = parseScutil(`<dictionary> {
+  ProxyAutoConfigEnable : 1
+  ProxyAutoConfigURLString : http://config.example.com/proxy.pac
+}`)
+	require.Nil(t, s.proxy)
+	require.Equal(t, "http://config.example.com/proxy.pac", s.pacURL)
+}
+
+func TestParseRegQuery(t *testing.T) {
+	s := parseRegQuery(`
+HKEY_CURRENT_USER\Software\Microsoft\Windows\CurrentVersion\Internet Settings
+    ProxyEnable    REG_DWORD    0x1
+    ProxyServer    REG_SZ    http=proxy:80;https=proxy:8443;socks=proxy:1080
+    ProxyOverride    REG_SZ    *.example.com;<local>
+
+HKEY_CURRENT_USER\Software\Microsoft\Windows\CurrentVersion\Internet Settings\Connections
+    DefaultConnectionSettings    REG_BINARY    46000000030000000900000000000000
+`)
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy:8443"}, s.proxy)
+	require.Equal(t, []string{"*.example.com", "<local>"}, s.bypass)
+	require.True(t, s.autoDiscover)
+
+	s = parseRegQuery(`
+    ProxyEnable    REG_DWORD    0x0
+    ProxyServer    REG_SZ    proxy:80
+    AutoConfigURL    REG_SZ    http://config/proxy.pac
+`)
+	require.Nil(t, s.proxy)
+	require.Equal(t, "http://config/proxy.pac", s.pacURL)
+	require.False(t, s.autoDiscover)
+}
+
+func TestParseKioslaverc(t *testing.T) {
+	s := parseKioslaverc(strings.NewReader(`[Proxy Settings]
+NoProxyFor=localhost,.example.com
+ProxyType=1
+httpProxy=http://proxy.example.com 8080
+httpsProxy=http://secure.example.com 8443
+`))
+	require.Equal(t, &url.URL{Scheme: "http", Host: "secure.example.com:8443"}, s.proxy)
+	require.Equal(t, []string{"localhost", ".example.com"}, s.bypass)
+
+	s = parseKioslaverc(strings.NewReader("[Proxy Settings]\nProxyType=2\nProxy Config Script=file:///etc/proxy.pac\n"))
+	require.Equal(t, "file:///etc/proxy.pac", s.pacURL)
+
+	s = parseKioslaverc(strings.NewReader("[Proxy Settings]\nProxyType=0\nhttpProxy=http://proxy 8080\n"))
+	require.Nil(t, s.proxy)
+}
+
+func TestParseGSettings(t *testing.T) {
+	values := map[string]string{
+		"org.gnome.system.proxy mode":           "'manual'\n",
+		"org.gnome.system.proxy ignore-hosts":   "['localhost', '127.0.0.0/8', '*.example.com']\n",
+		"org.gnome.system.proxy.https host":     "''\n",
+		"org.gnome.system.proxy.http host":      "'proxy.example.com'\n",
+		"org.gnome.system.proxy.http port":      "3128\n",
+		"org.gnome.system.proxy autoconfig-url": "''\n",
+	}
+	get := func(schema, key string) string { return values[schema+" "+key] }
+
+	s := parseGSettings(get)
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy.example.com:3128"}, s.proxy)
+	require.Equal(t, []string{"localhost", "127.0.0.0/8", "*.example.com"}, s.bypass)
+
+	values["org.gnome.system.proxy mode"] = "'auto'"
+	s = parseGSettings(get)
+	require.Nil(t, s.proxy)
+	require.True(t, s.autoDiscover)
+
+	values["org.gnome.system.proxy autoconfig-url"] = "'http://config/proxy.pac'"
+	s = parseGSettings(get)
+	require.Equal(t, "http://config/proxy.pac", s.pacURL)
+	require.False(t, s.autoDiscover)
+}
+
+func TestIsBypassed(t *testing.T) {
+	s := &settings{bypass: []string{"<local>", "*.example.com", ".corp", "10.0.0.0/8", "api-?.test", "exact.org"}}
+
+	for _, host := range []string{"intranet", "mail.example.com", "example.com", "git.corp", "10.1.2.3", "api-1.test", "EXACT.org"} {
+		require.True(t, s.isBypassed(host), host)
+	}
+	for _, host := range []string{"mail.proton.me", "notexample.com", "11.1.2.3", "api-12.test", "sub.exact.org"} {
+		require.False(t, s.isBypassed(host), host)
+	}
+}
+
+func TestParseWindowsProxyServer(t *testing.T) {
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy:80"}, parseWindowsProxyServer("proxy:80;backup:80"))
+	require.Equal(t, &url.URL{Scheme: "http", Host: "proxy:80"}, parseWindowsProxyServer("proxy:80 backup:80"))
+	require.Equal(t, &url.URL{Scheme: "http", Host: "secure:443"}, parseWindowsProxyServer("http=plain:80;https=secure:443"))
+	require.Nil(t, parseWindowsProxyServer(""))
+}
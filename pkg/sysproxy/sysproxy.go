@@ -0,0 +1,323 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sysproxy selects the proxy of API traffic by environment and
+// settings of the system incl. PAC scripts and WPAD, so users of GUI do not
+// need to set HTTP_PROXY, or by a proxy set manually.
+package sysproxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Modes of proxy selection, any other mode is URL of the proxy.
+const (
+	ModeAuto   = "auto"   // Environment, then settings of the system.
+	ModeDirect = "direct" // No proxy.
+)
+
+// detectionTTL is how long detected settings and fetched PAC script are
+// used before detecting again. Network change resets them sooner.
+const detectionTTL = 5 * time.Minute
+
+// detectionWait is how long a request waits for the first detection before
+// it connects directly. Later detections run while the previous result is
+// used.
+const detectionWait = 15 * time.Second
+
+var log = logrus.WithField("pkg", "sysproxy") //nolint[gochecknoglobals]
+
+// getSystemSettings is replaced in tests.
+var getSystemSettings = detectSystemSettings //nolint[gochecknoglobals]
+
+// resolver keeps the mode and what was detected for it. Detection does
+// network requests (WPAD, PAC download) so it runs in background and never
+// under the lock, which is needed by every API request.
+type resolver struct {
+	lock sync.Mutex
+
+	mode   string
+	manual *url.URL
+
+	detection  *detection
+	detecting  chan struct{} // Closed when running detection is done.
+	generation int           // Detection of older generation is outdated.
+}
+
+// detection is what was found in environment or settings of the system.
+// Only the cache of PAC results changes after it is made.
+type detection struct {
+	generation int
+	at         time.Time
+	envProxy   func(*url.URL) (*url.URL, error)
+	settings   *settings
+	pac        pacResolver
+
+	pacLock    sync.Mutex
+	pacResults map[string]*url.URL
+}
+
+var defaultResolver = &resolver{mode: ModeAuto} //nolint[gochecknoglobals]
+
+// ParseMode checks the mode: auto, direct or URL of HTTP, HTTPS or SOCKS5
+// proxy. Empty mode is auto.
+func ParseMode(mode string) (string, *url.URL, error) {
+	switch mode {
+	case "", ModeAuto:
+		return ModeAuto, nil, nil
+	case ModeDirect:
+		return ModeDirect, nil, nil
+	}
+
+	proxyURL, err := parseProxyURL(mode)
+	if err != nil {
+		return "", nil, err
+	}
+	return mode, proxyURL, nil
+}
+
+func parseProxyURL(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy")
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %q, use http, https or socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.Errorf("proxy %q has no host", value)
+	}
+	return proxyURL, nil
+}
+
+// SetMode sets how the proxy is selected, see ParseMode. Invalid mode is
+// an error and the previous mode is kept. Settings of the system are
+// detected again before next request.
+func SetMode(mode string) error {
+	mode, manual, err := ParseMode(mode)
+	if err != nil {
+		return err
+	}
+
+	defaultResolver.lock.Lock()
+	defer defaultResolver.lock.Unlock()
+
+	if mode != defaultResolver.mode {
+		log.WithField("mode", redactURL(mode)).Info("Proxy mode changed")
+	}
+	defaultResolver.mode = mode
+	defaultResolver.manual = manual
+	defaultResolver.outdate()
+	defaultResolver.detection = nil
+	return nil
+}
+
+// Reset starts detection again, e.g. after the network changed and another
+// PAC script or no proxy applies. Until it is done, the previous result is
+// used.
+func Reset() {
+	defaultResolver.lock.Lock()
+	defer defaultResolver.lock.Unlock()
+
+	defaultResolver.outdate()
+}
+
+// Proxy returns the proxy of the request by the mode. It is meant for
+// http.Transport.Proxy.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return defaultResolver.proxyForURL(req.URL)
+}
+
+// outdate makes the current detection outdated and the running one
+// discarded. Must be called with the lock held.
+func (r *resolver) outdate() {
+	r.generation++
+	r.detecting = nil
+}
+
+func (r *resolver) proxyForURL(reqURL *url.URL) (*url.URL, error) {
+	r.lock.Lock()
+	switch r.mode {
+	case ModeDirect:
+		r.lock.Unlock()
+		return nil, nil
+	case ModeAuto:
+	default:
+		manual := r.manual
+		r.lock.Unlock()
+		return manual, nil
+	}
+	det, done := r.getDetection()
+	r.lock.Unlock()
+
+	if det == nil {
+		select {
+		case <-done:
+		case <-time.After(detectionWait):
+			log.Warn("Proxy detection takes too long, connecting directly")
+		}
+
+		r.lock.Lock()
+		det = r.detection
+		r.lock.Unlock()
+
+		if det == nil {
+			return nil, nil
+		}
+	}
+
+	return det.proxyForURL(reqURL)
+}
+
+// getDetection returns the last detection, if any, and starts a new one in
+// background when it is missing or outdated. The channel is closed when
+// the new one is done. Must be called with the lock held.
+func (r *resolver) getDetection() (*detection, <-chan struct{}) {
+	outdated := r.detection == nil ||
+		r.detection.generation != r.generation ||
+		time.Since(r.detection.at) > detectionTTL
+
+	if outdated && r.detecting == nil {
+		r.detecting = make(chan struct{})
+		go r.runDetection(r.generation, r.detecting)
+	}
+
+	return r.detection, r.detecting
+}
+
+func (r *resolver) runDetection(generation int, done chan struct{}) {
+	defer close(done)
+
+	det := detect()
+	det.generation = generation
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.detecting == done {
+		r.detecting = nil
+	}
+	if generation == r.generation {
+		r.detection = det
+	}
+}
+
+// detect looks for the proxy in environment first, as Go programs usually
+// do, and then in settings of the system.
+func detect() *detection {
+	det := &detection{at: time.Now()}
+
+	if env := httpproxy.FromEnvironment(); env.HTTPProxy != "" || env.HTTPSProxy != "" {
+		log.Debug("Using proxy from environment")
+		det.envProxy = env.ProxyFunc()
+		return det
+	}
+
+	settings, err := getSystemSettings()
+	if err != nil {
+		log.WithError(err).Warn("Cannot read proxy settings of the system")
+		return det
+	}
+	det.settings = settings
+
+	switch {
+	case settings.pacURL != "":
+		det.loadPAC(settings.pacURL)
+	case settings.autoDiscover:
+		det.loadPAC("")
+	case settings.proxy != nil:
+		log.WithField("proxy", redactURL(settings.proxy.String())).Info("Using proxy of the system")
+	}
+
+	return det
+}
+
+// loadPAC prepares the PAC script at the URL, or found by WPAD when the
+// URL is empty.
+func (det *detection) loadPAC(pacURL string) {
+	pac, err := newPACResolver(pacURL)
+	if err != nil {
+		log.WithError(err).WithField("url", pacURL).Warn("Cannot use PAC script, connecting directly")
+		return
+	}
+	log.WithField("url", pacURL).Info("Using PAC script of the system")
+	det.pac = pac
+	det.pacResults = map[string]*url.URL{}
+}
+
+func (det *detection) proxyForURL(reqURL *url.URL) (*url.URL, error) {
+	if det.envProxy != nil {
+		return det.envProxy(reqURL)
+	}
+	if det.pac != nil {
+		return det.proxyByPAC(reqURL), nil
+	}
+	if det.settings != nil && det.settings.proxy != nil && !det.settings.isBypassed(reqURL.Hostname()) {
+		return det.settings.proxy, nil
+	}
+	return nil, nil
+}
+
+// proxyByPAC evaluates the PAC script once per host until the next
+// detection. Failure of the script means direct connection.
+func (det *detection) proxyByPAC(reqURL *url.URL) *url.URL {
+	host := reqURL.Hostname()
+
+	det.pacLock.Lock()
+	proxyURL, ok := det.pacResults[host]
+	det.pacLock.Unlock()
+	if ok {
+		return proxyURL
+	}
+
+	proxyURL, err := det.pac.findProxy(reqURL)
+	if err != nil {
+		log.WithError(err).WithField("host", host).Warn("PAC script failed, connecting directly")
+		proxyURL = nil
+	}
+
+	det.pacLock.Lock()
+	det.pacResults[host] = proxyURL
+	det.pacLock.Unlock()
+
+	return proxyURL
+}
+
+// redactURL removes password of the proxy for logs.
+func redactURL(value string) string {
+	proxyURL, err := url.Parse(value)
+	if err != nil || proxyURL.User == nil {
+		return value
+	}
+	if _, ok := proxyURL.User.Password(); ok {
+		proxyURL.User = url.UserPassword(proxyURL.User.Username(), "xxxxx")
+	}
+	return proxyURL.String()
+}
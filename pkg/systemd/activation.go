@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Listeners returns listeners passed by socket activation by their names
+// set by FileDescriptorName= in the socket unit. It returns no listeners
+// when the process was not socket activated. The variables are unset so
+// they are not inherited by child processes.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	fds, err := parseListenFDs(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := map[string]net.Listener{}
+	for name, fd := range fds {
+		file := os.NewFile(fd, name)
+		l, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("socket %q: %v", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// parseListenFDs returns passed file descriptors by names according to
+// values of LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES variables.
+func parseListenFDs(pid int, listenPID, listenFDs, listenFDNames string) (map[string]uintptr, error) {
+	if listenPID == "" || listenPID != strconv.Itoa(pid) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(listenFDs)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+
+	var names []string
+	if listenFDNames != "" {
+		names = strings.Split(listenFDNames, ":")
+	}
+	if len(names) != count {
+		return nil, fmt.Errorf("LISTEN_FDNAMES has %d names for %d sockets, set FileDescriptorName= of each socket", len(names), count)
+	}
+
+	fds := map[string]uintptr{}
+	for i, name := range names {
+		if _, ok := fds[name]; ok {
+			return nil, fmt.Errorf("socket name %q is used more than once", name)
+		}
+		fds[name] = uintptr(listenFDsStart + i)
+	}
+	return fds, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package systemd implements the parts of systemd service protocol Bridge
+// uses: readiness and watchdog notifications and socket activation. All of
+// them do nothing when Bridge is not started by systemd.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States sent to the service manager by Notify.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends the state to the service manager of units with Type=notify.
+// It does nothing when NOTIFY_SOCKET is not set.
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// Leading @ stands for abstract namespace socket.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint[errcheck]
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often the service manager expects watchdog
+// pings or zero when the watchdog of the unit is off.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the service manager twice per watchdog interval. It
+// returns right away when the watchdog is off and never returns otherwise.
+func RunWatchdog() {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	for range time.Tick(interval / 2) {
+		_ = Notify(Watchdog)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Proton Technologies AG
+//
+// This file is part of ProtonMail Bridge.
+//
+// ProtonMail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// ProtonMail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with ProtonMail Bridge.  If not, see <https://www.gnu.org/licenses/>.
+
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint[errcheck]
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close() //nolint[errcheck]
+
+	require.NoError(t, os.Setenv("NOTIFY_SOCKET", path))
+	defer os.Unsetenv("NOTIFY_SOCKET") //nolint[errcheck]
+
+	require.NoError(t, Notify(Ready))
+
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, Ready, string(buf[:n]))
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	require.NoError(t, os.Unsetenv("NOTIFY_SOCKET"))
+	require.NoError(t, Notify(Ready))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC") //nolint[errcheck]
+	defer os.Unsetenv("WATCHDOG_PID")  //nolint[errcheck]
+
+	require.NoError(t, os.Unsetenv("WATCHDOG_USEC"))
+	require.Equal(t, time.Duration(0), WatchdogInterval())
+
+	require.NoError(t, os.Setenv("WATCHDOG_USEC", "30000000"))
+	require.Equal(t, 30*time.Second, WatchdogInterval())
+
+	require.NoError(t, os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1)))
+	require.Equal(t, time.Duration(0), WatchdogInterval())
+}
+
+func TestParseListenFDs(t *testing.T) {
+	fds, err := parseListenFDs(42, "42", "2", "imap:smtp")
+	require.NoError(t, err)
+	require.Equal(t, map[string]uintptr{"imap": 3, "smtp": 4}, fds)
+
+	fds, err = parseListenFDs(42, "41", "2", "imap:smtp")
+	require.NoError(t, err)
+	require.Nil(t, fds)
+
+	_, err = parseListenFDs(42, "42", "2", "")
+	require.Error(t, err)
+
+	_, err = parseListenFDs(42, "42", "2", "imap:imap")
+	require.Error(t, err)
+
+	_, err = parseListenFDs(42, "42", "x", "imap")
+	require.Error(t, err)
+}
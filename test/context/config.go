@@ -22,6 +22,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/ProtonMail/proton-bridge/pkg/config"
 	"github.com/ProtonMail/proton-bridge/pkg/constants"
@@ -71,12 +72,18 @@ func (c *fakeConfig) GetVersion() string {
 func (c *fakeConfig) GetLogDir() string {
 	return c.dir
 }
+func (c *fakeConfig) CountCrashDumps(since time.Time) int {
+	return 0
+}
 func (c *fakeConfig) GetLogPrefix() string {
 	return "test"
 }
 func (c *fakeConfig) GetPreferencesPath() string {
 	return filepath.Join(c.dir, "prefs.json")
 }
+func (c *fakeConfig) GetConfigFilePath() string {
+	return filepath.Join(c.dir, "config.yaml")
+}
 func (c *fakeConfig) GetTransferDir() string {
 	return c.dir
 }
@@ -89,6 +96,12 @@ func (c *fakeConfig) GetTLSKeyPath() string {
 func (c *fakeConfig) GetEventsPath() string {
 	return filepath.Join(c.dir, "events.json")
 }
+func (c *fakeConfig) GetSMTPQueueDir() string {
+	return filepath.Join(c.dir, "smtp_queue")
+}
+func (c *fakeConfig) GetIMAPSpoolDir() string {
+	return filepath.Join(c.dir, "imap_spool")
+}
 func (c *fakeConfig) GetIMAPCachePath() string {
 	return filepath.Join(c.dir, "user_info.json")
 }
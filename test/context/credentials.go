@@ -77,6 +77,40 @@ func (c *fakeCredStore) SwitchAddressMode(userID string) error {
 	return nil
 }
 
+func (c *fakeCredStore) AddAppPassword(userID, name string) (string, error) {
+	creds, err := c.Get(userID)
+	if err != nil {
+		return "", err
+	}
+	password := bridgePassword + "-" + name
+	creds.AppPasswords = append(creds.AppPasswords, credentials.AppPassword{Name: name, Password: password})
+	return password, nil
+}
+
+func (c *fakeCredStore) AddBearerToken(userID, name string) (string, error) {
+	creds, err := c.Get(userID)
+	if err != nil {
+		return "", err
+	}
+	token := bridgePassword + "-token-" + name
+	creds.AppPasswords = append(creds.AppPasswords, credentials.AppPassword{Name: name, Password: token, IsBearerToken: true})
+	return token, nil
+}
+
+func (c *fakeCredStore) RemoveAppPassword(userID, name string) error {
+	creds, err := c.Get(userID)
+	if err != nil {
+		return err
+	}
+	for i, appPassword := range creds.AppPasswords {
+		if appPassword.Name == name {
+			creds.AppPasswords = append(creds.AppPasswords[:i], creds.AppPasswords[i+1:]...)
+			return nil
+		}
+	}
+	return credentials.ErrAppPasswordNotFound
+}
+
 func (c *fakeCredStore) UpdateEmails(userID string, emails []string) error {
 	return nil
 }
@@ -58,8 +58,9 @@ func (ctx *TestContext) withIMAPServer() {
 	port := pref.GetInt(preferences.IMAPPortKey)
 	tls, _ := config.GetTLSConfig(ctx.cfg)
 
-	backend := imap.NewIMAPBackend(ph, ctx.listener, ctx.cfg, ctx.bridge)
-	server := imap.NewIMAPServer(true, true, port, tls, backend, ctx.listener)
+	backend := imap.NewIMAPBackend(ph, ctx.listener, ctx.cfg, pref, ctx.bridge)
+	useNamespace := pref.GetBool(preferences.IMAPNamespaceKey)
+	server := imap.NewIMAPServer(true, true, false, bridge.ListenerConfig{Host: bridge.Host, Port: port}, imap.ConnectionLimits{}, imap.Timeouts{}, useNamespace, tls, backend, ctx.listener)
 
 	go server.ListenAndServe()
 	require.NoError(ctx.t, waitForPort(port, 5*time.Second))
@@ -59,8 +59,8 @@ func (ctx *TestContext) withSMTPServer() {
 	port := pref.GetInt(preferences.SMTPPortKey)
 	useSSL := pref.GetBool(preferences.SMTPSSLKey)
 
-	backend := smtp.NewSMTPBackend(ph, ctx.listener, pref, ctx.bridge)
-	server := smtp.NewSMTPServer(true, port, useSSL, tls, backend, ctx.listener)
+	backend := smtp.NewSMTPBackend(ph, ctx.listener, pref, ctx.bridge, ctx.cfg.GetSMTPQueueDir())
+	server := smtp.NewSMTPServer(true, bridge.ListenerConfig{Host: bridge.Host, Port: port}, useSSL, tls, backend, ctx.listener)
 
 	go server.ListenAndServe()
 	require.NoError(ctx.t, waitForPort(port, 5*time.Second))
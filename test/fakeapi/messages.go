@@ -109,6 +109,9 @@ func (api *FakePMAPI) ListMessages(filter *pmapi.MessagesFilter) ([]*pmapi.Messa
 }
 
 func isMessageMatchingFilter(filter *pmapi.MessagesFilter, message *pmapi.Message) bool {
+	if len(filter.ID) != 0 && !hasItem(filter.ID, message.ID) {
+		return false
+	}
 	if filter.ExternalID != "" && filter.ExternalID != message.ExternalID {
 		return false
 	}
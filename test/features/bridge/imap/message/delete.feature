@@ -93,9 +93,13 @@ Feature: IMAP remove messages from mailbox
       | LOGOUT        | 9  |
       | UNSELECT      | 10 |
 
-  Scenario: Not possible to delete from All Mail
+  Scenario: Deleted flag is ignored in All Mail
     Given there are 1 messages in mailbox "INBOX" for "user"
     And there is IMAP client logged in as "user"
     And there is IMAP client selected in "All Mail"
     When IMAP client marks message seq "1" as deleted
-    Then IMAP response is "IMAP error: NO operation not allowed for 'All Mail' folder"
+    Then IMAP response is "OK"
+    And message "1" in "All Mail" for "user" is marked as undeleted
+    When IMAP client sends expunge
+    Then IMAP response is "OK"
+    And mailbox "All Mail" for "user" has 1 messages
@@ -38,4 +38,4 @@ Feature: SMTP wrong messages
 
 
       """
-    Then SMTP response is "SMTP error: 554 Error: transaction failed, blame it on the weather: failed to create new parser: unexpected EOF"
+    Then SMTP response is "SMTP error: 554 5.6.0 Message (multipart/related) is not closed by final boundary bc5bd30245232f31b6c976adcd59bb0069c9b13f986f9e40c2571bb80aa16606"